@@ -0,0 +1,290 @@
+// app.go
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/loganmanery/go-react-app/config"
+	"github.com/loganmanery/go-react-app/db"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Helper function to connect to the database the configuration points at
+func connectDatabase(cfg *config.Config) (*db.Database, error) {
+	return db.Connect(db.DBConfig{
+		Host:     cfg.Database.Host,
+		Port:     cfg.Database.Port,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		DBName:   cfg.Database.Name,
+		SSLMode:  cfg.Database.SSLMode,
+	})
+}
+
+// Helper function to build the auth service from the configuration, the same way for the
+// server and every command. The returned function closes the files it opened.
+func newAuthService(cfg *config.Config, database *db.Database) (authService *services.AuthService, closeAll func(), err error) {
+	var closers []func()
+	closeAll = func() {
+		for _, closer := range closers {
+			closer()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	authService = services.NewAuthService(database.Pool, cfg.Auth.JWTSecret, cfg.Auth.TokenExpiryMinutes)
+
+	// Configure session lifetimes; the idle timeout defaults to TOKEN_EXPIRY_MINUTES
+	sessionPolicy := services.NewSessionPolicy(cfg.Session.IdleTimeout)
+	sessionPolicy.AbsoluteTimeout = cfg.Session.AbsoluteTimeout
+	sessionPolicy.ActivityUpdateInterval = cfg.Session.ActivityUpdateInterval
+	sessionPolicy.RememberMeIdleTimeout = cfg.Session.RememberMeIdleTimeout
+	sessionPolicy.RememberMeAbsoluteTimeout = cfg.Session.RememberMeAbsoluteTimeout
+	authService.SetSessionPolicy(sessionPolicy)
+
+	// Configure concurrent session limits, e.g. SESSION_ROLE_LIMITS=admin=1,support=3
+	sessionLimits := services.NewSessionLimitPolicy()
+	sessionLimits.MaxSessions = cfg.Session.MaxConcurrent
+	sessionLimits.Action = cfg.Session.LimitAction
+	for role, limit := range cfg.Session.RoleLimits {
+		sessionLimits.RoleLimits[role] = limit
+	}
+	authService.SetSessionLimitPolicy(sessionLimits)
+
+	// Configure how long admins can act as another user
+	impersonationPolicy := services.NewImpersonationPolicy()
+	impersonationPolicy.TTL = cfg.Session.ImpersonationTTL
+	authService.SetImpersonationPolicy(impersonationPolicy)
+
+	// Configure how long users have to change their mind after asking for their account to be deleted
+	accountDeletionPolicy := services.NewAccountDeletionPolicy()
+	accountDeletionPolicy.GracePeriod = cfg.Accounts.DeletionGracePeriod
+	authService.SetAccountDeletionPolicy(accountDeletionPolicy)
+
+	// Configure how long deleted users keep their email and username, and can be restored
+	userRetentionPolicy := services.NewUserRetentionPolicy()
+	userRetentionPolicy.ReleaseAfter = cfg.Accounts.DeletedUserReleaseAfter
+	authService.SetUserRetentionPolicy(userRetentionPolicy)
+
+	// Check passwords against LDAP or Active Directory if configured. LDAP_GROUP_ROLES maps
+	// groups to roles, e.g. "CN=App Admins,OU=Groups,DC=corp,DC=example=>admin;Support=>support".
+	// LOCAL_LOGIN_ROLES limits local passwords to break-glass accounts, e.g. "admin".
+	credentialPolicy := services.NewCredentialPolicy()
+	if cfg.LDAP.URL != "" {
+		ldapConfig := services.NewLDAPConfig(cfg.LDAP.URL, cfg.LDAP.BaseDN)
+		ldapConfig.StartTLS = cfg.LDAP.StartTLS
+		ldapConfig.InsecureSkipVerify = cfg.LDAP.InsecureSkipVerify
+		ldapConfig.Timeout = cfg.LDAP.Timeout
+		ldapConfig.BindDN = cfg.LDAP.BindDN
+		ldapConfig.BindPassword = cfg.LDAP.BindPassword
+		ldapConfig.UserFilter = cfg.LDAP.UserFilter
+		ldapConfig.IDAttribute = cfg.LDAP.IDAttribute
+		ldapConfig.UsernameAttribute = cfg.LDAP.UsernameAttribute
+		ldapConfig.EmailAttribute = cfg.LDAP.EmailAttribute
+		ldapConfig.FirstNameAttribute = cfg.LDAP.FirstNameAttribute
+		ldapConfig.LastNameAttribute = cfg.LDAP.LastNameAttribute
+		ldapConfig.GroupAttribute = cfg.LDAP.GroupAttribute
+		ldapConfig.GroupBaseDN = cfg.LDAP.GroupBaseDN
+		ldapConfig.GroupFilter = cfg.LDAP.GroupFilter
+		for group, role := range cfg.LDAP.GroupRoles {
+			ldapConfig.GroupRoles[group] = role
+		}
+		ldapVerifier, err := services.NewLDAPVerifier(ldapConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("configure LDAP: %w", err)
+		}
+		credentialPolicy.Verifiers = append(credentialPolicy.Verifiers, ldapVerifier)
+	}
+	credentialPolicy.LocalLoginRoles = cfg.Directory.LocalLoginRoles
+	credentialPolicy.AutoProvision = cfg.Directory.AutoProvision
+	authService.SetCredentialPolicy(credentialPolicy)
+
+	// Configure the password policy
+	policyConfig := services.NewPasswordPolicyConfig()
+	policyConfig.MinLength = cfg.Password.MinLength
+	policyConfig.MinStrengthScore = cfg.Password.MinStrength
+	policyConfig.HistoryCount = cfg.Password.HistoryCount
+	policyConfig.BreachedListDir = cfg.Password.BreachedListDir
+	policyConfig.BreachedMinCount = cfg.Password.BreachedMinCount
+	passwordPolicy, err := services.NewPasswordPolicy(database.Pool, policyConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load password policy: %w", err)
+	}
+	authService.SetPasswordPolicy(passwordPolicy)
+
+	// Configure the account lockout policy
+	lockoutPolicy := models.NewLockoutPolicy()
+	lockoutPolicy.MaxAttempts = cfg.Lockout.MaxAttempts
+	lockoutPolicy.Window = cfg.Lockout.Window
+	lockoutPolicy.BaseDuration = cfg.Lockout.Duration
+	lockoutPolicy.Multiplier = cfg.Lockout.Multiplier
+	lockoutPolicy.MaxDuration = cfg.Lockout.MaxDuration
+	lockoutPolicy.EscalationReset = cfg.Lockout.EscalationReset
+	authService.SetLockoutPolicy(lockoutPolicy)
+
+	// Configure login risk scoring; GeoIP databases and IP lists are optional local files
+	riskPolicy := services.NewLoginRiskPolicy()
+	riskPolicy.ChallengeThreshold = cfg.LoginRisk.ChallengeThreshold
+	riskPolicy.BlockThreshold = cfg.LoginRisk.BlockThreshold
+	riskPolicy.MaxTravelSpeedKmh = cfg.LoginRisk.MaxTravelSpeedKmh
+	riskPolicy.ChallengeMethod = cfg.LoginRisk.ChallengeMethod
+	riskPolicy.ChallengeTTL = cfg.LoginRisk.ChallengeTTL
+	authService.SetLoginRiskPolicy(riskPolicy)
+
+	var ipReputation services.IPReputation
+	if cfg.LoginRisk.GeoIPCityDB != "" || cfg.LoginRisk.GeoIPASNDB != "" {
+		geoIP, err := services.OpenGeoIP(cfg.LoginRisk.GeoIPCityDB, cfg.LoginRisk.GeoIPASNDB)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open GeoIP databases: %w", err)
+		}
+		closers = append(closers, geoIP.Close)
+		ipReputation.GeoIP = geoIP
+	}
+	if path := cfg.LoginRisk.TorExitNodeList; path != "" {
+		if ipReputation.TorExitNodes, err = services.LoadIPList(path); err != nil {
+			return nil, nil, fmt.Errorf("load Tor exit node list: %w", err)
+		}
+	}
+	if path := cfg.LoginRisk.DatacenterIPList; path != "" {
+		if ipReputation.Datacenters, err = services.LoadIPList(path); err != nil {
+			return nil, nil, fmt.Errorf("load datacenter IP list: %w", err)
+		}
+	}
+	authService.SetIPReputation(ipReputation)
+
+	// Send notification emails through SMTP if configured, otherwise just log them
+	var emailSender services.EmailSender = services.LogEmailSender{}
+	if cfg.SMTP.Host != "" {
+		emailSender = services.NewSMTPEmailSender(services.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+		})
+	}
+	authService.SetEmailService(services.NewEmailService(emailSender, cfg.App.Name, cfg.App.BaseURL))
+
+	// Act as an OpenID Connect provider. Without a key file an ephemeral key is generated,
+	// which invalidates issued ID tokens on restart and does not work across replicas.
+	var signingKey *services.SigningKey
+	if path := cfg.OIDC.SigningKeyFile; path != "" {
+		if signingKey, err = services.LoadSigningKey(path); err != nil {
+			return nil, nil, fmt.Errorf("load OIDC signing key: %w", err)
+		}
+	} else {
+		slog.Warn("OIDC_SIGNING_KEY_FILE is not set, generating a temporary signing key")
+		if signingKey, err = services.GenerateSigningKey(); err != nil {
+			return nil, nil, fmt.Errorf("generate OIDC signing key: %w", err)
+		}
+	}
+	oidcConfig := services.NewOIDCConfig(cfg.OIDC.Issuer, signingKey)
+	if cfg.OIDC.LoginURL != "" {
+		oidcConfig.LoginURL = cfg.OIDC.LoginURL
+	}
+	if cfg.OIDC.ConsentURL != "" {
+		oidcConfig.ConsentURL = cfg.OIDC.ConsentURL
+	}
+	oidcConfig.AccessTokenTTL = cfg.OIDC.AccessTokenTTL
+	oidcConfig.IDTokenTTL = cfg.OIDC.IDTokenTTL
+	oidcConfig.RefreshTokenTTL = cfg.OIDC.RefreshTokenTTL
+	authService.SetOIDCConfig(oidcConfig)
+
+	// Let users sign in with external OpenID Connect providers, e.g. EXTERNAL_IDPS=google,corp
+	// with EXTERNAL_IDP_GOOGLE_ISSUER, EXTERNAL_IDP_GOOGLE_CLIENT_ID and so on for each
+	appBaseURL := strings.TrimRight(cfg.App.BaseURL, "/")
+	for name, idp := range cfg.ExternalIDPs {
+		providerConfig := services.NewExternalProviderConfig(
+			name,
+			idp.Issuer,
+			idp.ClientID,
+			idp.ClientSecret,
+			appBaseURL+"/api/auth/external/"+name+"/callback",
+		)
+		if idp.DisplayName != "" {
+			providerConfig.DisplayName = idp.DisplayName
+		}
+		if len(idp.Scopes) > 0 {
+			providerConfig.Scopes = idp.Scopes
+		}
+		providerConfig.AutoProvision = idp.AutoProvision
+		providerConfig.LinkByEmail = idp.LinkByEmail
+		if err := authService.AddExternalProvider(providerConfig); err != nil {
+			return nil, nil, fmt.Errorf("configure external identity provider: %w", err)
+		}
+	}
+
+	// Let users sign in with SAML identity providers, e.g. SAML_IDPS=okta with
+	// SAML_IDP_OKTA_METADATA_URL and so on for each. SAML_CERT_FILE and SAML_KEY_FILE hold our
+	// signing key; without them a temporary one is generated, which identity providers that
+	// pinned our certificate will reject after a restart.
+	if len(cfg.SAML.IDPs) > 0 {
+		var samlKey *rsa.PrivateKey
+		var samlCertificate *x509.Certificate
+		if cfg.SAML.CertFile != "" {
+			if samlKey, samlCertificate, err = services.LoadSAMLKeyPair(cfg.SAML.CertFile, cfg.SAML.KeyFile); err != nil {
+				return nil, nil, fmt.Errorf("load SAML key pair: %w", err)
+			}
+		} else {
+			slog.Warn("SAML_CERT_FILE is not set, generating a temporary SAML key pair")
+			if samlKey, samlCertificate, err = services.GenerateSAMLKeyPair(appBaseURL); err != nil {
+				return nil, nil, fmt.Errorf("generate SAML key pair: %w", err)
+			}
+		}
+
+		for name, idp := range cfg.SAML.IDPs {
+			samlConfig := services.NewSAMLProviderConfig(name, appBaseURL, samlKey, samlCertificate)
+			if idp.DisplayName != "" {
+				samlConfig.DisplayName = idp.DisplayName
+			}
+			if idp.EntityID != "" {
+				samlConfig.EntityID = idp.EntityID
+			}
+			samlConfig.NameIDFormat = idp.NameIDFormat
+			samlConfig.IDPMetadataURL = idp.MetadataURL
+			if idp.MetadataFile != "" {
+				if samlConfig.IDPMetadataXML, err = os.ReadFile(idp.MetadataFile); err != nil {
+					return nil, nil, fmt.Errorf("read SAML metadata for %s: %w", name, err)
+				}
+			}
+			samlConfig.AllowIDPInitiated = idp.AllowIDPInitiated
+			samlConfig.AutoProvision = idp.AutoProvision
+			samlConfig.Attributes.Subject = idp.SubjectAttribute
+			samlConfig.Attributes.Username = idp.UsernameAttribute
+			samlConfig.Attributes.Email = idp.EmailAttribute
+			samlConfig.Attributes.FirstName = idp.FirstNameAttribute
+			samlConfig.Attributes.LastName = idp.LastNameAttribute
+			samlConfig.Attributes.Groups = idp.GroupAttribute
+			for group, role := range idp.GroupRoles {
+				samlConfig.GroupRoles[group] = role
+			}
+			if err := authService.AddSAMLProvider(samlConfig); err != nil {
+				return nil, nil, fmt.Errorf("configure SAML identity provider: %w", err)
+			}
+		}
+	}
+
+	// Let identity providers provision users and groups through SCIM 2.0 at /scim/v2, with a
+	// personal access token that has the scim scope. SCIM_GROUP_ROLES maps groups to roles,
+	// e.g. "App Admins=>admin;Support=>support".
+	if cfg.SCIM.Enabled {
+		scimConfig := services.NewSCIMConfig(appBaseURL)
+		scimConfig.MaxResults = cfg.SCIM.MaxResults
+		for group, role := range cfg.SCIM.GroupRoles {
+			scimConfig.GroupRoles[group] = role
+		}
+		authService.SetSCIMConfig(scimConfig)
+	}
+
+	return authService, closeAll, nil
+}
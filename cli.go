@@ -0,0 +1,329 @@
+// cli.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/loganmanery/go-react-app/config"
+	"github.com/loganmanery/go-react-app/db"
+	"github.com/loganmanery/go-react-app/logging"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Exit codes, which scripts can rely on
+const (
+	exitOK       = 0
+	exitFailure  = 1 // Anything unexpected, e.g. the database can't be reached
+	exitUsage    = 2 // Unknown command, invalid flags or missing arguments
+	exitConfig   = 3 // The configuration is invalid
+	exitNotFound = 4 // The user doesn't exist
+	exitRejected = 5 // The change was refused, e.g. the username is taken or the password too weak
+)
+
+// Changes made from the command line are audited with this user agent
+const cliUserAgent = "cli"
+
+// A command of the binary, e.g. "user create"
+type command struct {
+	name    string
+	summary string
+	run     func(cmd *commandContext, args []string) int
+}
+
+// Helper function to list the commands, in the order the usage shows them
+func commands() []command {
+	return []command{
+		{"serve", "Run the server (the default without a command)", runServe},
+		{"migrate", "Apply pending database migrations", runMigrate},
+		{"config check", "Load and validate the configuration", runConfigCheck},
+		{"user create", "Create a user with a verified email", runUserCreate},
+		{"user disable", "Disable a user and sign them out everywhere", runUserDisable},
+		{"user enable", "Enable a disabled user", runUserEnable},
+		{"user reset-password", "Set a user's password and sign them out everywhere", runUserResetPassword},
+		{"user grant-role", "Grant a user a role", runUserGrantRole},
+		{"sessions purge", "Delete expired sessions, or sign one user out everywhere", runSessionsPurge},
+		{"audit export", "Export audit log entries as JSON lines or CSV", runAuditExport},
+		{"audit prune", "Delete old audit log entries", runAuditPrune},
+	}
+}
+
+// Run the command named by args and return the process exit code. Without a command, or
+// with flags only, the server runs.
+func runCommand(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help") {
+		return runServe(newCommandContext("serve", stdin, stdout, stderr), args)
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stdout)
+		return exitOK
+	}
+
+	// Commands are one or two words long
+	for _, c := range commands() {
+		words := strings.Fields(c.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == c.name {
+			return c.run(newCommandContext(c.name, stdin, stdout, stderr), args[len(words):])
+		}
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n", strings.Join(args[:min(len(args), 2)], " "))
+	printUsage(stderr)
+	return exitUsage
+}
+
+// Helper function to print the list of commands
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: go-react-app <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-22s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command accepts --format json for machine-readable output, --config for a")
+	fmt.Fprintln(w, "config file and a flag per setting, e.g. --db-host for DB_HOST.")
+	fmt.Fprintln(w, "Exit codes: 0 success, 1 failure, 2 usage, 3 invalid configuration, 4 user not found,")
+	fmt.Fprintln(w, "5 change refused.")
+}
+
+// Everything a command needs: its flags, the configuration and where to write output
+type commandContext struct {
+	name   string
+	flags  *flag.FlagSet
+	loader *config.Loader
+	format string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	cfg    *config.Config
+}
+
+// Helper function to create a command's context. Commands add their own flags, then call parse.
+func newCommandContext(name string, stdin io.Reader, stdout, stderr io.Writer) *commandContext {
+	cmd := &commandContext{
+		name:   name,
+		flags:  flag.NewFlagSet(name, flag.ContinueOnError),
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	cmd.flags.SetOutput(stderr)
+	cmd.flags.StringVar(&cmd.format, "format", "text", "Output format: text or json")
+	return cmd
+}
+
+// Parse the command's flags along with the configuration's. Unless ok, the command should
+// return code straight away.
+func (cmd *commandContext) parse(args []string) (code int, ok bool) {
+	// The usage lists the command's own flags; the configuration's would drown them out
+	own := make(map[string]bool)
+	cmd.flags.VisitAll(func(f *flag.Flag) { own[f.Name] = true })
+	cmd.loader = config.NewLoader(cmd.flags)
+	cmd.flags.Usage = func() {
+		fmt.Fprintf(cmd.stderr, "Usage: go-react-app %s [flags]\n\nFlags:\n", cmd.name)
+		cmd.flags.VisitAll(func(f *flag.Flag) {
+			if own[f.Name] {
+				name, usage := flag.UnquoteUsage(f)
+				fmt.Fprintf(cmd.stderr, "  --%s %s\n    \t%s\n", f.Name, name, usage)
+			}
+		})
+		fmt.Fprintln(cmd.stderr, "\nConfiguration flags such as --config and --db-host are accepted too.")
+	}
+
+	if err := cmd.flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if cmd.flags.NArg() > 0 {
+		return cmd.fail(exitUsage, fmt.Errorf("unexpected argument %q", cmd.flags.Arg(0))), false
+	}
+	if cmd.format != "text" && cmd.format != "json" {
+		cmd.format = "text"
+		return cmd.fail(exitUsage, fmt.Errorf("--format must be text or json")), false
+	}
+	return exitOK, true
+}
+
+// Load the configuration and set up logging to stderr. Unless ok, the command should
+// return code straight away.
+func (cmd *commandContext) loadConfig() (code int, ok bool) {
+	cfg, err := cmd.loader.Load()
+	if err != nil {
+		return cmd.fail(exitConfig, err), false
+	}
+	logger, err := logging.New(cmd.stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return cmd.fail(exitConfig, err), false
+	}
+	slog.SetDefault(logger)
+	cmd.cfg = cfg
+	return exitOK, true
+}
+
+// Connect to the database and build the auth service the way the server does. The
+// returned function closes both.
+func (cmd *commandContext) connect() (*db.Database, *services.AuthService, func(), error) {
+	database, err := connectDatabase(cmd.cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	authService, closeAuthService, err := newAuthService(cmd.cfg, database)
+	if err != nil {
+		database.Close()
+		return nil, nil, nil, err
+	}
+	return database, authService, func() {
+		closeAuthService()
+		database.Close()
+	}, nil
+}
+
+// Print a command's result, as JSON or as the text text writes
+func (cmd *commandContext) output(result interface{}, text func(w io.Writer)) {
+	if cmd.format == "json" {
+		if err := json.NewEncoder(cmd.stdout).Encode(result); err != nil {
+			fmt.Fprintf(cmd.stderr, "%s: %v\n", cmd.name, err)
+		}
+		return
+	}
+	text(cmd.stdout)
+}
+
+// Report an error on stderr, as JSON or text, and return the exit code
+func (cmd *commandContext) fail(code int, err error) int {
+	if cmd.format == "json" {
+		result := map[string]interface{}{"error": err.Error(), "exit_code": code}
+		var configErr *config.Error
+		var policyErr *services.PasswordPolicyError
+		if errors.As(err, &configErr) {
+			result["problems"] = configErr.Problems
+		} else if errors.As(err, &policyErr) {
+			result["violations"] = policyErr.Violations
+		}
+		json.NewEncoder(cmd.stderr).Encode(result)
+	} else {
+		fmt.Fprintf(cmd.stderr, "%s: %v\n", cmd.name, err)
+	}
+	return code
+}
+
+// Report an error from the services with the exit code that fits it
+func (cmd *commandContext) failService(err error) int {
+	var policyErr *services.PasswordPolicyError
+	switch {
+	case errors.Is(err, services.ErrUserNotFound):
+		return cmd.fail(exitNotFound, err)
+	case errors.Is(err, services.ErrEmailAlreadyExists),
+		errors.Is(err, services.ErrUsernameAlreadyExists),
+		errors.Is(err, services.ErrConfusableUsername),
+		errors.Is(err, services.ErrPasswordManagedExternally),
+		errors.As(err, &policyErr):
+		return cmd.fail(exitRejected, err)
+	default:
+		return cmd.fail(exitFailure, err)
+	}
+}
+
+// Run the server
+func runServe(cmd *commandContext, args []string) int {
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+	serve(cmd.cfg)
+	return exitOK
+}
+
+// Apply pending database migrations, or list them with --dry-run
+func runMigrate(cmd *commandContext, args []string) int {
+	dryRun := cmd.flags.Bool("dry-run", false, "List pending migrations without applying them")
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+
+	database, err := connectDatabase(cmd.cfg)
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	pending, err := database.PendingMigrations(ctx)
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	if pending == nil {
+		pending = []string{}
+	}
+	if !*dryRun {
+		if err := database.Migrate(ctx); err != nil {
+			return cmd.fail(exitFailure, err)
+		}
+	}
+
+	cmd.output(map[string]interface{}{"pending": pending, "applied": !*dryRun}, func(w io.Writer) {
+		switch {
+		case len(pending) == 0:
+			fmt.Fprintln(w, "The database is up to date")
+		case *dryRun:
+			fmt.Fprintf(w, "%d pending migrations:\n", len(pending))
+			for _, version := range pending {
+				fmt.Fprintf(w, "  %s\n", version)
+			}
+		default:
+			fmt.Fprintf(w, "Applied %d migrations:\n", len(pending))
+			for _, version := range pending {
+				fmt.Fprintf(w, "  %s\n", version)
+			}
+		}
+	})
+	return exitOK
+}
+
+// Load and validate the configuration without starting anything
+func runConfigCheck(cmd *commandContext, args []string) int {
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+
+	result := map[string]interface{}{"valid": true, "problems": []string{}}
+	cfg, err := cmd.loader.Load()
+	var configErr *config.Error
+	if errors.As(err, &configErr) {
+		result["valid"] = false
+		result["problems"] = configErr.Problems
+	} else if err != nil {
+		return cmd.fail(exitFailure, err)
+	} else {
+		result["environment"] = cfg.Environment
+	}
+
+	cmd.output(result, func(w io.Writer) {
+		if configErr == nil {
+			fmt.Fprintf(w, "Configuration is valid (%s)\n", cfg.Environment)
+			return
+		}
+		fmt.Fprintln(w, "Configuration is invalid:")
+		for _, problem := range configErr.Problems {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+	})
+	if configErr != nil {
+		return exitConfig
+	}
+	return exitOK
+}
@@ -0,0 +1,161 @@
+// cli_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Helper function to run a command with no input and collect its output
+func runTestCommand(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	// Settings from the environment would change what the configuration checks find
+	for _, env := range []string{"CONFIG_FILE", "APP_ENV", "JWT_SECRET", "CSRF_SECRET", "DB_PASSWORD", "ADMIN_PASSWORD", "DB_PORT"} {
+		t.Setenv(env, "")
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand(args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// Flags that make the default configuration fail the production checks
+var productionArgs = []string{"--app-env", "production"}
+
+func TestCommandExitCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"help", []string{"help"}, exitOK, "Usage: go-react-app <command>", ""},
+		{"command help", []string{"audit", "prune", "--help"}, exitOK, "", "--older-than"},
+		{"unknown command", []string{"frobnicate"}, exitUsage, "", `unknown command "frobnicate"`},
+		{"unknown subcommand", []string{"user", "delete"}, exitUsage, "", `unknown command "user delete"`},
+		{"unknown flag", []string{"config", "check", "--bogus"}, exitUsage, "", "flag provided but not defined: -bogus"},
+		{"unexpected argument", []string{"config", "check", "extra"}, exitUsage, "", `unexpected argument "extra"`},
+		{"unknown format", []string{"config", "check", "--format", "xml"}, exitUsage, "", "--format must be text or json"},
+		{"missing required flag", []string{"user", "create"}, exitUsage, "", "--email and --username are required"},
+		{"no password source", []string{"user", "create", "--email", "a@example.com", "--username", "alice"}, exitUsage, "", "--password-stdin and --generate-password"},
+		{"missing user", []string{"user", "disable"}, exitUsage, "", "--user is required"},
+		{"missing age", []string{"audit", "prune"}, exitUsage, "", "--older-than is required"},
+		{"invalid age", []string{"audit", "prune", "--older-than", "-5d"}, exitUsage, "", "age must be positive"},
+		{"invalid time", []string{"audit", "export", "--since", "yesterday"}, exitUsage, "", "--since: invalid time"},
+		{"valid configuration", []string{"config", "check"}, exitOK, "Configuration is valid (development)", ""},
+		{"invalid configuration", append([]string{"config", "check"}, productionArgs...), exitConfig, "JWT_SECRET: is set to a well-known default", ""},
+		{"invalid setting", []string{"config", "check", "--db-port", "many"}, exitConfig, "--db-port", ""},
+		// Commands that need the database stop at the configuration, before connecting
+		{"migrate with invalid configuration", append([]string{"migrate"}, productionArgs...), exitConfig, "", "invalid configuration"},
+		{"user create with invalid configuration", append([]string{"user", "create", "--email", "a@example.com", "--username", "alice", "--generate-password"}, productionArgs...), exitConfig, "", "invalid configuration"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCommand(t, tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d\nstdout: %s\nstderr: %s", code, tt.wantCode, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestConfigCheckJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantCode     int
+		wantValid    bool
+		wantProblems []string
+	}{
+		{
+			name:      "valid",
+			args:      []string{"config", "check", "--format", "json"},
+			wantCode:  exitOK,
+			wantValid: true,
+		},
+		{
+			name:     "default secrets in production",
+			args:     append([]string{"config", "check", "--format", "json"}, productionArgs...),
+			wantCode: exitConfig,
+			wantProblems: []string{
+				"JWT_SECRET: is set to a well-known default and must be changed in production",
+				"DB_PASSWORD: is set to a well-known default and must be changed in production",
+				"JWT_SECRET: must be at least 32 characters in production",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCommand(t, tt.args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %s", code, tt.wantCode, stderr)
+			}
+
+			var result struct {
+				Valid       bool     `json:"valid"`
+				Problems    []string `json:"problems"`
+				Environment string   `json:"environment"`
+			}
+			if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+				t.Fatalf("stdout is not JSON: %v\n%s", err, stdout)
+			}
+			if result.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v", result.Valid, tt.wantValid)
+			}
+			if result.Problems == nil || strings.Join(result.Problems, "\n") != strings.Join(tt.wantProblems, "\n") {
+				t.Errorf("problems = %#v, want %#v", result.Problems, tt.wantProblems)
+			}
+			if tt.wantValid && result.Environment != "development" {
+				t.Errorf("environment = %q, want development", result.Environment)
+			}
+		})
+	}
+}
+
+func TestCommandErrorsAsJSON(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantCode     int
+		wantError    string
+		wantProblems bool
+	}{
+		{"usage", []string{"audit", "prune", "--format", "json"}, exitUsage, "--older-than is required", false},
+		{"configuration", append([]string{"migrate", "--format", "json"}, productionArgs...), exitConfig, "invalid configuration", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runTestCommand(t, tt.args...)
+			if code != tt.wantCode {
+				t.Fatalf("exit code = %d, want %d: %s", code, tt.wantCode, stderr)
+			}
+			if stdout != "" {
+				t.Errorf("stdout = %q, want errors on stderr only", stdout)
+			}
+
+			var result struct {
+				Error    string   `json:"error"`
+				ExitCode int      `json:"exit_code"`
+				Problems []string `json:"problems"`
+			}
+			if err := json.Unmarshal([]byte(stderr), &result); err != nil {
+				t.Fatalf("stderr is not JSON: %v\n%s", err, stderr)
+			}
+			if !strings.Contains(result.Error, tt.wantError) || result.ExitCode != tt.wantCode {
+				t.Errorf("result = %+v, want error %q and exit code %d", result, tt.wantError, tt.wantCode)
+			}
+			if (len(result.Problems) > 0) != tt.wantProblems {
+				t.Errorf("problems = %#v, want them listed: %v", result.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+// cli_user.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Flags to choose a password: read from stdin, or generated and printed
+type passwordFlags struct {
+	stdin    *bool
+	generate *bool
+}
+
+func addPasswordFlags(cmd *commandContext) passwordFlags {
+	return passwordFlags{
+		stdin:    cmd.flags.Bool("password-stdin", false, "Read the password from the first line of stdin"),
+		generate: cmd.flags.Bool("generate-password", false, "Generate a random password and print it"),
+	}
+}
+
+// Helper function to get the password the flags asked for; generated tells whether to print it
+func (p passwordFlags) password(cmd *commandContext) (password string, generated bool, err error) {
+	switch {
+	case *p.stdin == *p.generate:
+		return "", false, errors.New("exactly one of --password-stdin and --generate-password is required")
+	case *p.generate:
+		bytes := make([]byte, 18)
+		if _, err := rand.Read(bytes); err != nil {
+			return "", false, err
+		}
+		return base64.RawURLEncoding.EncodeToString(bytes), true, nil
+	default:
+		line, err := bufio.NewReader(cmd.stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			return "", false, errors.New("no password on stdin")
+		}
+		return line, false, nil
+	}
+}
+
+// Helper function to collect a repeatable or comma separated flag
+func listFlag(list *[]string) func(string) error {
+	return func(value string) error {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*list = append(*list, item)
+			}
+		}
+		return nil
+	}
+}
+
+// Create a user with a verified email, e.g. a break-glass admin
+func runUserCreate(cmd *commandContext, args []string) int {
+	email := cmd.flags.String("email", "", "Email address (required)")
+	username := cmd.flags.String("username", "", "Username (required)")
+	firstName := cmd.flags.String("first-name", "", "First name")
+	lastName := cmd.flags.String("last-name", "", "Last name")
+	var roles []string
+	cmd.flags.Func("role", "Role to grant; repeat or separate with commas", listFlag(&roles))
+	passwordFlags := addPasswordFlags(cmd)
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if *email == "" || *username == "" {
+		return cmd.fail(exitUsage, errors.New("--email and --username are required"))
+	}
+	password, generated, err := passwordFlags.password(cmd)
+	if err != nil {
+		return cmd.fail(exitUsage, err)
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+
+	_, authService, closeAll, err := cmd.connect()
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	defer closeAll()
+
+	user, err := authService.CreateUser(context.Background(), uuid.Nil, *username, *email, password, *firstName, *lastName, roles, "", cliUserAgent)
+	if err != nil {
+		return cmd.failService(err)
+	}
+
+	if roles == nil {
+		roles = []string{}
+	}
+	result := map[string]interface{}{"user": user, "roles": roles}
+	if generated {
+		result["password"] = password
+	}
+	cmd.output(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Created user %s (%s)\n", user.Username, user.UserID)
+		if generated {
+			fmt.Fprintf(w, "Password: %s\n", password)
+		}
+	})
+	return exitOK
+}
+
+// Disable a user and sign them out everywhere
+func runUserDisable(cmd *commandContext, args []string) int {
+	return runUserSetActive(cmd, args, false)
+}
+
+// Enable a disabled user
+func runUserEnable(cmd *commandContext, args []string) int {
+	return runUserSetActive(cmd, args, true)
+}
+
+// Helper function for user disable and user enable
+func runUserSetActive(cmd *commandContext, args []string, active bool) int {
+	identifier := cmd.flags.String("user", "", "User ID, email or username (required)")
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if *identifier == "" {
+		return cmd.fail(exitUsage, errors.New("--user is required"))
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+
+	_, authService, closeAll, err := cmd.connect()
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	defer closeAll()
+
+	ctx := context.Background()
+	user, err := authService.FindUser(ctx, *identifier)
+	if err != nil {
+		return cmd.failService(err)
+	}
+	if err := authService.SetUserActive(ctx, uuid.Nil, user.UserID, active, "", cliUserAgent); err != nil {
+		return cmd.failService(err)
+	}
+
+	cmd.output(map[string]interface{}{"user_id": user.UserID, "is_active": active}, func(w io.Writer) {
+		if active {
+			fmt.Fprintf(w, "Enabled user %s (%s)\n", user.Username, user.UserID)
+		} else {
+			fmt.Fprintf(w, "Disabled user %s (%s)\n", user.Username, user.UserID)
+		}
+	})
+	return exitOK
+}
+
+// Set a user's password and sign them out everywhere
+func runUserResetPassword(cmd *commandContext, args []string) int {
+	identifier := cmd.flags.String("user", "", "User ID, email or username (required)")
+	passwordFlags := addPasswordFlags(cmd)
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if *identifier == "" {
+		return cmd.fail(exitUsage, errors.New("--user is required"))
+	}
+	password, generated, err := passwordFlags.password(cmd)
+	if err != nil {
+		return cmd.fail(exitUsage, err)
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+
+	_, authService, closeAll, err := cmd.connect()
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	defer closeAll()
+
+	ctx := context.Background()
+	user, err := authService.FindUser(ctx, *identifier)
+	if err != nil {
+		return cmd.failService(err)
+	}
+	if err := authService.SetUserPassword(ctx, uuid.Nil, user.UserID, password, "", cliUserAgent); err != nil {
+		return cmd.failService(err)
+	}
+
+	result := map[string]interface{}{"user_id": user.UserID}
+	if generated {
+		result["password"] = password
+	}
+	cmd.output(result, func(w io.Writer) {
+		fmt.Fprintf(w, "Reset the password of %s (%s)\n", user.Username, user.UserID)
+		if generated {
+			fmt.Fprintf(w, "Password: %s\n", password)
+		}
+	})
+	return exitOK
+}
+
+// Grant a user a role
+func runUserGrantRole(cmd *commandContext, args []string) int {
+	identifier := cmd.flags.String("user", "", "User ID, email or username (required)")
+	role := cmd.flags.String("role", "", "Role to grant, e.g. "+models.RoleAdmin+" (required)")
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if *identifier == "" || *role == "" {
+		return cmd.fail(exitUsage, errors.New("--user and --role are required"))
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+
+	_, authService, closeAll, err := cmd.connect()
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	defer closeAll()
+
+	ctx := context.Background()
+	user, err := authService.FindUser(ctx, *identifier)
+	if err != nil {
+		return cmd.failService(err)
+	}
+	if err := authService.GrantRole(ctx, uuid.Nil, user.UserID, *role, "", cliUserAgent); err != nil {
+		return cmd.failService(err)
+	}
+	roles, err := authService.GetUserRoles(ctx, user.UserID)
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+
+	cmd.output(map[string]interface{}{"user_id": user.UserID, "roles": roles}, func(w io.Writer) {
+		fmt.Fprintf(w, "%s (%s) has roles: %s\n", user.Username, user.UserID, strings.Join(roles, ", "))
+	})
+	return exitOK
+}
+
+// Delete expired sessions and end lapsed impersonations, or sign one user out everywhere
+func runSessionsPurge(cmd *commandContext, args []string) int {
+	identifier := cmd.flags.String("user", "", "Sign out this user ID, email or username instead")
+	if code, ok := cmd.parse(args); !ok {
+		return code
+	}
+	if code, ok := cmd.loadConfig(); !ok {
+		return code
+	}
+
+	database, authService, closeAll, err := cmd.connect()
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	defer closeAll()
+
+	ctx := context.Background()
+	if *identifier != "" {
+		user, err := authService.FindUser(ctx, *identifier)
+		if err != nil {
+			return cmd.failService(err)
+		}
+		if err := authService.RevokeUserSessions(ctx, user.UserID); err != nil {
+			return cmd.fail(exitFailure, err)
+		}
+		cmd.output(map[string]interface{}{"user_id": user.UserID, "revoked": true}, func(w io.Writer) {
+			fmt.Fprintf(w, "Signed out %s (%s) everywhere\n", user.Username, user.UserID)
+		})
+		return exitOK
+	}
+
+	// Lapsed impersonations are recorded as ended before their sessions are deleted
+	ended, err := authService.EndExpiredImpersonations(ctx)
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+	deleted, err := models.NewSessionRepository(database.Pool).DeleteExpiredSessions(ctx)
+	if err != nil {
+		return cmd.fail(exitFailure, err)
+	}
+
+	cmd.output(map[string]interface{}{"deleted": deleted, "impersonations_ended": ended}, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted %d expired sessions, ended %d impersonations\n", deleted, ended)
+	})
+	return exitOK
+}
@@ -0,0 +1,380 @@
+// config/config.go
+package config
+
+import (
+	"time"
+
+	"github.com/loganmanery/go-react-app/logging"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Environments the server can run in
+const (
+	EnvironmentDevelopment = "development"
+	EnvironmentProduction  = "production" // Startup fails on insecure settings such as default secrets
+)
+
+// Config is every setting the server reads at startup. Each field is loaded from, in
+// increasing order of precedence, its default, the config file (under the key tags), its
+// environment variable (the env tag) and its command line flag (the env tag in lower case
+// with dashes, e.g. --db-host).
+type Config struct {
+	Environment  string                       `key:"environment" env:"APP_ENV"`
+	Server       ServerConfig                 `key:"server"`
+	Log          LogConfig                    `key:"log"`
+	App          AppConfig                    `key:"app"`
+	Database     DatabaseConfig               `key:"database"`
+	Auth         AuthConfig                   `key:"auth"`
+	Admin        AdminConfig                  `key:"admin"`
+	Session      SessionConfig                `key:"session"`
+	Cookie       CookieConfig                 `key:"cookie"`
+	CSRF         CSRFConfig                   `key:"csrf"`
+	RateLimit    RateLimitConfig              `key:"rate_limit"`
+	Password     PasswordConfig               `key:"password"`
+	Lockout      LockoutConfig                `key:"lockout"`
+	LoginRisk    LoginRiskConfig              `key:"login_risk"`
+	Accounts     AccountsConfig               `key:"accounts"`
+	SMTP         SMTPConfig                   `key:"smtp"`
+	Directory    DirectoryConfig              `key:"directory"`
+	LDAP         LDAPConfig                   `key:"ldap"`
+	OIDC         OIDCConfig                   `key:"oidc"`
+	ExternalIDPs map[string]ExternalIDPConfig `key:"external_idps" env:"EXTERNAL_IDPS" envPrefix:"EXTERNAL_IDP_"`
+	SAML         SAMLConfig                   `key:"saml"`
+	SCIM         SCIMConfig                   `key:"scim"`
+}
+
+// ServerConfig holds the HTTP listener settings
+type ServerConfig struct {
+	Port           int      `key:"port" env:"PORT"`
+	AdminAddr      string   `key:"admin_addr" env:"ADMIN_LISTEN_ADDR"`    // Serves /metrics; empty disables it
+	TrustedProxies []string `key:"trusted_proxies" env:"TRUSTED_PROXIES"` // IPs or CIDRs whose X-Forwarded-For is believed; none by default
+}
+
+// LogConfig controls the log output
+type LogConfig struct {
+	Level  string `key:"level" env:"LOG_LEVEL"`   // debug, info, warn or error
+	Format string `key:"format" env:"LOG_FORMAT"` // text or json
+}
+
+// AppConfig describes the application to users, in emails and redirects
+type AppConfig struct {
+	Name    string `key:"name" env:"APP_NAME"`
+	BaseURL string `key:"base_url" env:"APP_BASE_URL"`
+}
+
+// DatabaseConfig holds the PostgreSQL connection settings
+type DatabaseConfig struct {
+	Host     string `key:"host" env:"DB_HOST"`
+	Port     int    `key:"port" env:"DB_PORT"`
+	User     string `key:"user" env:"DB_USER"`
+	Password string `key:"password" env:"DB_PASSWORD"`
+	Name     string `key:"name" env:"DB_NAME"`
+	SSLMode  string `key:"sslmode" env:"DB_SSLMODE"`
+}
+
+// AuthConfig holds the token signing settings
+type AuthConfig struct {
+	JWTSecret          string `key:"jwt_secret" env:"JWT_SECRET"`
+	TokenExpiryMinutes int    `key:"token_expiry_minutes" env:"TOKEN_EXPIRY_MINUTES"`
+}
+
+// AdminConfig describes the admin account created at startup if it doesn't exist
+type AdminConfig struct {
+	Email    string `key:"email" env:"ADMIN_EMAIL"`
+	Username string `key:"username" env:"ADMIN_USERNAME"`
+	Password string `key:"password" env:"ADMIN_PASSWORD"` // No admin is created while this is empty
+}
+
+// SessionConfig holds session lifetimes, limits and caching
+type SessionConfig struct {
+	IdleTimeout               time.Duration  `key:"idle_timeout" env:"SESSION_IDLE_TIMEOUT"` // Defaults to TOKEN_EXPIRY_MINUTES
+	AbsoluteTimeout           time.Duration  `key:"absolute_timeout" env:"SESSION_ABSOLUTE_TIMEOUT"`
+	ActivityUpdateInterval    time.Duration  `key:"activity_update_interval" env:"SESSION_ACTIVITY_UPDATE_INTERVAL"`
+	RememberMeIdleTimeout     time.Duration  `key:"remember_me_idle_timeout" env:"REMEMBER_ME_IDLE_TIMEOUT"`
+	RememberMeAbsoluteTimeout time.Duration  `key:"remember_me_absolute_timeout" env:"REMEMBER_ME_ABSOLUTE_TIMEOUT"`
+	MaxConcurrent             int            `key:"max_concurrent" env:"SESSION_MAX_CONCURRENT"`
+	LimitAction               string         `key:"limit_action" env:"SESSION_LIMIT_ACTION"`
+	RoleLimits                map[string]int `key:"role_limits" env:"SESSION_ROLE_LIMITS"` // e.g. admin=1,support=3
+	CacheSize                 int            `key:"cache_size" env:"SESSION_CACHE_SIZE"`   // 0 disables the cache
+	CacheTTL                  time.Duration  `key:"cache_ttl" env:"SESSION_CACHE_TTL"`
+	ImpersonationTTL          time.Duration  `key:"impersonation_ttl" env:"IMPERSONATION_TTL"`
+}
+
+// CookieConfig holds the session and device cookie settings
+type CookieConfig struct {
+	Name       string `key:"name" env:"SESSION_COOKIE_NAME"`
+	DeviceName string `key:"device_name" env:"DEVICE_COOKIE_NAME"`
+	Domain     string `key:"domain" env:"SESSION_COOKIE_DOMAIN"`
+	Path       string `key:"path" env:"SESSION_COOKIE_PATH"`
+	Secure     bool   `key:"secure" env:"SESSION_COOKIE_SECURE"`
+	SameSite   string `key:"samesite" env:"SESSION_COOKIE_SAMESITE"`
+}
+
+// CSRFConfig holds the CSRF protection settings
+type CSRFConfig struct {
+	Secret         string   `key:"secret" env:"CSRF_SECRET"`                   // Defaults to JWT_SECRET
+	AllowedOrigins []string `key:"allowed_origins" env:"CSRF_ALLOWED_ORIGINS"` // Defaults to APP_BASE_URL
+}
+
+// RateLimitConfig chooses where rate limit buckets live
+type RateLimitConfig struct {
+	Store string `key:"store" env:"RATE_LIMIT_STORE"` // "memory", or "postgres" with more than one replica
+}
+
+// PasswordConfig holds the password policy settings
+type PasswordConfig struct {
+	MinLength        int    `key:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	MinStrength      int    `key:"min_strength" env:"PASSWORD_MIN_STRENGTH"`
+	HistoryCount     int    `key:"history_count" env:"PASSWORD_HISTORY_COUNT"`
+	BreachedListDir  string `key:"breached_list_dir" env:"BREACHED_PASSWORDS_DIR"`
+	BreachedMinCount int    `key:"breached_min_count" env:"BREACHED_PASSWORDS_MIN_COUNT"`
+}
+
+// LockoutConfig holds the account lockout settings
+type LockoutConfig struct {
+	MaxAttempts     int           `key:"max_attempts" env:"LOCKOUT_MAX_ATTEMPTS"`
+	Window          time.Duration `key:"window" env:"LOCKOUT_WINDOW"`
+	Duration        time.Duration `key:"duration" env:"LOCKOUT_DURATION"`
+	Multiplier      float64       `key:"multiplier" env:"LOCKOUT_MULTIPLIER"`
+	MaxDuration     time.Duration `key:"max_duration" env:"LOCKOUT_MAX_DURATION"`
+	EscalationReset time.Duration `key:"escalation_reset" env:"LOCKOUT_ESCALATION_RESET"`
+}
+
+// LoginRiskConfig holds the login risk scoring settings and its optional data files
+type LoginRiskConfig struct {
+	ChallengeThreshold int           `key:"challenge_threshold" env:"LOGIN_RISK_CHALLENGE_THRESHOLD"`
+	BlockThreshold     int           `key:"block_threshold" env:"LOGIN_RISK_BLOCK_THRESHOLD"`
+	MaxTravelSpeedKmh  float64       `key:"max_travel_speed_kmh" env:"LOGIN_RISK_MAX_TRAVEL_SPEED_KMH"`
+	ChallengeMethod    string        `key:"challenge_method" env:"LOGIN_CHALLENGE_METHOD"`
+	ChallengeTTL       time.Duration `key:"challenge_ttl" env:"LOGIN_CHALLENGE_TTL"`
+	GeoIPCityDB        string        `key:"geoip_city_db" env:"GEOIP_CITY_DB"`
+	GeoIPASNDB         string        `key:"geoip_asn_db" env:"GEOIP_ASN_DB"`
+	TorExitNodeList    string        `key:"tor_exit_node_list" env:"TOR_EXIT_NODE_LIST"`
+	DatacenterIPList   string        `key:"datacenter_ip_list" env:"DATACENTER_IP_LIST"`
+}
+
+// AccountsConfig holds the account deletion settings
+type AccountsConfig struct {
+	DeletionGracePeriod     time.Duration `key:"deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD"`
+	DeletedUserReleaseAfter time.Duration `key:"deleted_user_release_after" env:"DELETED_USER_RELEASE_AFTER"`
+}
+
+// SMTPConfig holds the mail server settings; emails are only logged while Host is empty
+type SMTPConfig struct {
+	Host     string `key:"host" env:"SMTP_HOST"`
+	Port     int    `key:"port" env:"SMTP_PORT"`
+	Username string `key:"username" env:"SMTP_USERNAME"`
+	Password string `key:"password" env:"SMTP_PASSWORD"`
+	From     string `key:"from" env:"SMTP_FROM"`
+}
+
+// DirectoryConfig controls how directory accounts and local passwords mix
+type DirectoryConfig struct {
+	LocalLoginRoles []string `key:"local_login_roles" env:"LOCAL_LOGIN_ROLES"` // Limits local passwords to these roles
+	AutoProvision   bool     `key:"auto_provision" env:"DIRECTORY_AUTO_PROVISION"`
+}
+
+// LDAPConfig holds the LDAP or Active Directory settings; LDAP is off while URL is empty
+type LDAPConfig struct {
+	URL                string            `key:"url" env:"LDAP_URL"`
+	BaseDN             string            `key:"base_dn" env:"LDAP_BASE_DN"`
+	StartTLS           bool              `key:"start_tls" env:"LDAP_START_TLS"`
+	InsecureSkipVerify bool              `key:"insecure_skip_verify" env:"LDAP_INSECURE_SKIP_VERIFY"`
+	Timeout            time.Duration     `key:"timeout" env:"LDAP_TIMEOUT"`
+	BindDN             string            `key:"bind_dn" env:"LDAP_BIND_DN"`
+	BindPassword       string            `key:"bind_password" env:"LDAP_BIND_PASSWORD"`
+	UserFilter         string            `key:"user_filter" env:"LDAP_USER_FILTER"`
+	IDAttribute        string            `key:"id_attribute" env:"LDAP_ID_ATTRIBUTE"`
+	UsernameAttribute  string            `key:"username_attribute" env:"LDAP_USERNAME_ATTRIBUTE"`
+	EmailAttribute     string            `key:"email_attribute" env:"LDAP_EMAIL_ATTRIBUTE"`
+	FirstNameAttribute string            `key:"first_name_attribute" env:"LDAP_FIRST_NAME_ATTRIBUTE"`
+	LastNameAttribute  string            `key:"last_name_attribute" env:"LDAP_LAST_NAME_ATTRIBUTE"`
+	GroupAttribute     string            `key:"group_attribute" env:"LDAP_GROUP_ATTRIBUTE"`
+	GroupBaseDN        string            `key:"group_base_dn" env:"LDAP_GROUP_BASE_DN"`
+	GroupFilter        string            `key:"group_filter" env:"LDAP_GROUP_FILTER"`
+	GroupRoles         map[string]string `key:"group_roles" env:"LDAP_GROUP_ROLES"` // e.g. CN=Admins,DC=corp=>admin;Support=>support
+}
+
+// OIDCConfig holds the settings of the OpenID Connect provider we run
+type OIDCConfig struct {
+	Issuer          string        `key:"issuer" env:"OIDC_ISSUER"` // Defaults to APP_BASE_URL
+	SigningKeyFile  string        `key:"signing_key_file" env:"OIDC_SIGNING_KEY_FILE"`
+	LoginURL        string        `key:"login_url" env:"OIDC_LOGIN_URL"`     // Defaults to the issuer's /login
+	ConsentURL      string        `key:"consent_url" env:"OIDC_CONSENT_URL"` // Defaults to the issuer's /oauth/consent
+	AccessTokenTTL  time.Duration `key:"access_token_ttl" env:"OIDC_ACCESS_TOKEN_TTL"`
+	IDTokenTTL      time.Duration `key:"id_token_ttl" env:"OIDC_ID_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `key:"refresh_token_ttl" env:"OIDC_REFRESH_TOKEN_TTL"`
+}
+
+// ExternalIDPConfig describes an external OpenID Connect provider users can sign in with.
+// Its environment variables are named after the provider, e.g. EXTERNAL_IDP_GOOGLE_ISSUER.
+type ExternalIDPConfig struct {
+	Issuer        string   `key:"issuer" env:"ISSUER"`
+	ClientID      string   `key:"client_id" env:"CLIENT_ID"`
+	ClientSecret  string   `key:"client_secret" env:"CLIENT_SECRET"`
+	DisplayName   string   `key:"display_name" env:"DISPLAY_NAME"` // Defaults to the provider's name
+	Scopes        []string `key:"scopes" env:"SCOPES"`             // Defaults to openid, email and profile
+	AutoProvision bool     `key:"auto_provision" env:"AUTO_PROVISION"`
+	LinkByEmail   bool     `key:"link_by_email" env:"LINK_BY_EMAIL"`
+}
+
+func (c *ExternalIDPConfig) setDefaults() {
+	c.AutoProvision = true
+	c.LinkByEmail = true
+}
+
+// SAMLConfig holds our SAML service provider key pair and the identity providers we trust
+type SAMLConfig struct {
+	CertFile string                   `key:"cert_file" env:"SAML_CERT_FILE"`
+	KeyFile  string                   `key:"key_file" env:"SAML_KEY_FILE"`
+	IDPs     map[string]SAMLIDPConfig `key:"idps" env:"SAML_IDPS" envPrefix:"SAML_IDP_"`
+}
+
+// SAMLIDPConfig describes a SAML identity provider users can sign in with. Its environment
+// variables are named after the provider, e.g. SAML_IDP_OKTA_METADATA_URL.
+type SAMLIDPConfig struct {
+	DisplayName        string            `key:"display_name" env:"DISPLAY_NAME"` // Defaults to the provider's name
+	EntityID           string            `key:"entity_id" env:"ENTITY_ID"`       // Defaults to our metadata URL
+	NameIDFormat       string            `key:"name_id_format" env:"NAME_ID_FORMAT"`
+	MetadataURL        string            `key:"metadata_url" env:"METADATA_URL"`
+	MetadataFile       string            `key:"metadata_file" env:"METADATA_FILE"`
+	AllowIDPInitiated  bool              `key:"allow_idp_initiated" env:"ALLOW_IDP_INITIATED"`
+	AutoProvision      bool              `key:"auto_provision" env:"AUTO_PROVISION"`
+	SubjectAttribute   string            `key:"subject_attribute" env:"SUBJECT_ATTRIBUTE"`
+	UsernameAttribute  string            `key:"username_attribute" env:"USERNAME_ATTRIBUTE"`
+	EmailAttribute     string            `key:"email_attribute" env:"EMAIL_ATTRIBUTE"`
+	FirstNameAttribute string            `key:"first_name_attribute" env:"FIRST_NAME_ATTRIBUTE"`
+	LastNameAttribute  string            `key:"last_name_attribute" env:"LAST_NAME_ATTRIBUTE"`
+	GroupAttribute     string            `key:"group_attribute" env:"GROUP_ATTRIBUTE"`
+	GroupRoles         map[string]string `key:"group_roles" env:"GROUP_ROLES"`
+}
+
+func (c *SAMLIDPConfig) setDefaults() {
+	defaults := services.NewSAMLProviderConfig("", "", nil, nil)
+	c.NameIDFormat = defaults.NameIDFormat
+	c.AutoProvision = defaults.AutoProvision
+	c.SubjectAttribute = defaults.Attributes.Subject
+	c.UsernameAttribute = defaults.Attributes.Username
+	c.EmailAttribute = defaults.Attributes.Email
+	c.FirstNameAttribute = defaults.Attributes.FirstName
+	c.LastNameAttribute = defaults.Attributes.LastName
+	c.GroupAttribute = defaults.Attributes.Groups
+}
+
+// SCIMConfig holds the SCIM provisioning API settings
+type SCIMConfig struct {
+	Enabled    bool              `key:"enabled" env:"SCIM_ENABLED"`
+	MaxResults int               `key:"max_results" env:"SCIM_MAX_RESULTS"`
+	GroupRoles map[string]string `key:"group_roles" env:"SCIM_GROUP_ROLES"` // e.g. App Admins=>admin;Support=>support
+}
+
+// Default returns the configuration used when nothing is set, which is only fit for
+// development: the secrets are well known
+func Default() *Config {
+	sessionPolicy := services.NewSessionPolicy(0)
+	sessionLimits := services.NewSessionLimitPolicy()
+	passwordPolicy := services.NewPasswordPolicyConfig()
+	lockoutPolicy := models.NewLockoutPolicy()
+	riskPolicy := services.NewLoginRiskPolicy()
+	ldap := services.NewLDAPConfig("", "")
+	oidc := services.NewOIDCConfig("", nil)
+
+	return &Config{
+		Environment: EnvironmentDevelopment,
+		Server:      ServerConfig{Port: 8080, AdminAddr: "127.0.0.1:9090"},
+		Log:         LogConfig{Level: "info", Format: logging.FormatText},
+		App:         AppConfig{Name: "Go React App", BaseURL: "http://localhost:8080"},
+		Database: DatabaseConfig{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "postgres",
+			Password: "password",
+			Name:     "web_application_db",
+			SSLMode:  "disable",
+		},
+		Auth:  AuthConfig{JWTSecret: "your-secret-key", TokenExpiryMinutes: 60},
+		Admin: AdminConfig{Email: "admin@example.com", Username: "admin"},
+		Session: SessionConfig{
+			AbsoluteTimeout:           sessionPolicy.AbsoluteTimeout,
+			ActivityUpdateInterval:    sessionPolicy.ActivityUpdateInterval,
+			RememberMeIdleTimeout:     sessionPolicy.RememberMeIdleTimeout,
+			RememberMeAbsoluteTimeout: sessionPolicy.RememberMeAbsoluteTimeout,
+			MaxConcurrent:             sessionLimits.MaxSessions,
+			LimitAction:               sessionLimits.Action,
+			RoleLimits:                map[string]int{},
+			CacheSize:                 10000,
+			CacheTTL:                  30 * time.Second,
+			ImpersonationTTL:          services.NewImpersonationPolicy().TTL,
+		},
+		Cookie:    CookieConfig{Name: "session", DeviceName: "device", Path: "/", Secure: true, SameSite: "lax"},
+		RateLimit: RateLimitConfig{Store: "memory"},
+		Password: PasswordConfig{
+			MinLength:        passwordPolicy.MinLength,
+			MinStrength:      passwordPolicy.MinStrengthScore,
+			HistoryCount:     passwordPolicy.HistoryCount,
+			BreachedMinCount: passwordPolicy.BreachedMinCount,
+		},
+		Lockout: LockoutConfig{
+			MaxAttempts:     lockoutPolicy.MaxAttempts,
+			Window:          lockoutPolicy.Window,
+			Duration:        lockoutPolicy.BaseDuration,
+			Multiplier:      lockoutPolicy.Multiplier,
+			MaxDuration:     lockoutPolicy.MaxDuration,
+			EscalationReset: lockoutPolicy.EscalationReset,
+		},
+		LoginRisk: LoginRiskConfig{
+			ChallengeThreshold: riskPolicy.ChallengeThreshold,
+			BlockThreshold:     riskPolicy.BlockThreshold,
+			MaxTravelSpeedKmh:  riskPolicy.MaxTravelSpeedKmh,
+			ChallengeMethod:    riskPolicy.ChallengeMethod,
+			ChallengeTTL:       riskPolicy.ChallengeTTL,
+		},
+		Accounts: AccountsConfig{
+			DeletionGracePeriod:     services.NewAccountDeletionPolicy().GracePeriod,
+			DeletedUserReleaseAfter: services.NewUserRetentionPolicy().ReleaseAfter,
+		},
+		SMTP:      SMTPConfig{Port: 587, From: "no-reply@example.com"},
+		Directory: DirectoryConfig{AutoProvision: true},
+		LDAP: LDAPConfig{
+			Timeout:            ldap.Timeout,
+			UserFilter:         ldap.UserFilter,
+			IDAttribute:        ldap.IDAttribute,
+			UsernameAttribute:  ldap.UsernameAttribute,
+			EmailAttribute:     ldap.EmailAttribute,
+			FirstNameAttribute: ldap.FirstNameAttribute,
+			LastNameAttribute:  ldap.LastNameAttribute,
+			GroupAttribute:     ldap.GroupAttribute,
+			GroupRoles:         map[string]string{},
+		},
+		OIDC: OIDCConfig{
+			AccessTokenTTL:  oidc.AccessTokenTTL,
+			IDTokenTTL:      oidc.IDTokenTTL,
+			RefreshTokenTTL: oidc.RefreshTokenTTL,
+		},
+		ExternalIDPs: map[string]ExternalIDPConfig{},
+		SAML:         SAMLConfig{IDPs: map[string]SAMLIDPConfig{}},
+		SCIM:         SCIMConfig{Enabled: true, MaxResults: services.NewSCIMConfig("").MaxResults, GroupRoles: map[string]string{}},
+	}
+}
+
+// IsProduction reports whether the server runs in production mode
+func (c *Config) IsProduction() bool {
+	return c.Environment == EnvironmentProduction
+}
+
+// Helper function to fill in the defaults that depend on other settings
+func (c *Config) applyDerivedDefaults() {
+	if c.Session.IdleTimeout == 0 {
+		c.Session.IdleTimeout = time.Duration(c.Auth.TokenExpiryMinutes) * time.Minute
+	}
+	if c.CSRF.Secret == "" {
+		c.CSRF.Secret = c.Auth.JWTSecret
+	}
+	if len(c.CSRF.AllowedOrigins) == 0 {
+		c.CSRF.AllowedOrigins = []string{c.App.BaseURL}
+	}
+	if c.OIDC.Issuer == "" {
+		c.OIDC.Issuer = c.App.BaseURL
+	}
+}
@@ -0,0 +1,179 @@
+// config/config_test.go
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A JWT secret long enough for production
+const testProductionSecret = "0123456789abcdef0123456789abcdef"
+
+// Helper function to write a config file into a temporary directory
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+// Helper function to load the configuration with only the given environment variables set,
+// alternating names and values, among the ones these tests use
+func loadTestConfig(t *testing.T, env []string, args ...string) (*Config, error) {
+	t.Helper()
+	for _, name := range []string{ConfigFileEnv, "APP_ENV", "JWT_SECRET", "CSRF_SECRET", "DB_PASSWORD", "ADMIN_PASSWORD", "DB_HOST", "DB_PORT", "LOG_LEVEL", "SESSION_IDLE_TIMEOUT", "TOKEN_EXPIRY_MINUTES"} {
+		t.Setenv(name, "")
+	}
+	for i := 0; i+1 < len(env); i += 2 {
+		t.Setenv(env[i], env[i+1])
+	}
+	return Load(args)
+}
+
+// Helper function to get the problems from a Load error
+func configProblems(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var configErr *Error
+	if !errors.As(err, &configErr) {
+		t.Fatalf("Load: %v, want an *Error", err)
+	}
+	return configErr.Problems
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	yamlFile := writeConfigFile(t, "config.yaml", "database:\n  host: file-host\n  port: 6000\nlog:\n  level: debug\n")
+	tomlFile := writeConfigFile(t, "config.toml", "[database]\nhost = \"file-host\"\nport = 6000\n\n[log]\nlevel = \"debug\"\n")
+
+	tests := []struct {
+		name     string
+		env      []string
+		args     []string
+		wantHost string
+		wantPort int
+		wantLog  string
+	}{
+		{"defaults", nil, nil, "localhost", 5432, "info"},
+		{"YAML file", nil, []string{"--config", yamlFile}, "file-host", 6000, "debug"},
+		{"TOML file", nil, []string{"--config", tomlFile}, "file-host", 6000, "debug"},
+		{"file from the environment", []string{ConfigFileEnv, yamlFile}, nil, "file-host", 6000, "debug"},
+		{"environment over file", []string{"DB_HOST", "env-host"}, []string{"--config", yamlFile}, "env-host", 6000, "debug"},
+		{"flag over environment", []string{"DB_HOST", "env-host", "DB_PORT", "7000"}, []string{"--config", yamlFile, "--db-host", "flag-host"}, "flag-host", 7000, "debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadTestConfig(t, tt.env, tt.args...)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.Database.Host != tt.wantHost || cfg.Database.Port != tt.wantPort || cfg.Log.Level != tt.wantLog {
+				t.Errorf("host %q, port %d, log level %q; want %q, %d, %q",
+					cfg.Database.Host, cfg.Database.Port, cfg.Log.Level, tt.wantHost, tt.wantPort, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestLoadDerivedDefaults(t *testing.T) {
+	cfg, err := loadTestConfig(t, []string{"JWT_SECRET", "jwt-secret"}, "--token-expiry-minutes", "15")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Session.IdleTimeout != 15*time.Minute {
+		t.Errorf("SESSION_IDLE_TIMEOUT = %v, want TOKEN_EXPIRY_MINUTES", cfg.Session.IdleTimeout)
+	}
+	if cfg.CSRF.Secret != "jwt-secret" {
+		t.Errorf("CSRF_SECRET = %q, want JWT_SECRET", cfg.CSRF.Secret)
+	}
+	if len(cfg.CSRF.AllowedOrigins) != 1 || cfg.CSRF.AllowedOrigins[0] != cfg.App.BaseURL {
+		t.Errorf("CSRF_ALLOWED_ORIGINS = %v, want APP_BASE_URL", cfg.CSRF.AllowedOrigins)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", "database:\n  hots: typo\n")
+	jsonFile := writeConfigFile(t, "config.json", "{}")
+	tests := []struct {
+		name string
+		env  []string
+		args []string
+		want []string
+	}{
+		{"unknown file setting", nil, []string{"--config", file}, []string{"unknown setting database.hots"}},
+		{"unsupported file type", nil, []string{"--config", jsonFile}, []string{"must be .yaml, .yml or .toml"}},
+		{"invalid environment value", []string{"DB_PORT", "many", "SESSION_IDLE_TIMEOUT", "soon"}, nil, []string{"DB_PORT:", "SESSION_IDLE_TIMEOUT:"}},
+		{"invalid flag value", nil, []string{"--db-port", "many"}, []string{"--db-port:"}},
+		{"unknown flag", nil, []string{"--bogus"}, []string{"flag provided but not defined"}},
+		{"failed validation", []string{"LOG_LEVEL", "loud"}, []string{"--db-port", "70000"}, []string{"DB_PORT: must be a port", "LOG_LEVEL: must be one of"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.env, tt.args...)
+			problems := strings.Join(configProblems(t, err), "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(problems, want) {
+					t.Errorf("problems = %q, want one containing %q", problems, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProductionSafetyCheck(t *testing.T) {
+	safe := []string{"APP_ENV", "production", "JWT_SECRET", testProductionSecret, "DB_PASSWORD", "db-password"}
+	tests := []struct {
+		name string
+		env  []string
+		want []string
+	}{
+		{
+			name: "default secrets",
+			env:  []string{"APP_ENV", "production"},
+			want: []string{
+				"JWT_SECRET: is set to a well-known default and must be changed in production",
+				"DB_PASSWORD: is set to a well-known default and must be changed in production",
+				"JWT_SECRET: must be at least 32 characters in production",
+			},
+		},
+		{
+			name: "default CSRF secret",
+			env:  append(append([]string{}, safe...), "CSRF_SECRET", "your-secret-key"),
+			want: []string{"CSRF_SECRET: is set to a well-known default and must be changed in production"},
+		},
+		{
+			name: "default admin password",
+			env:  append(append([]string{}, safe...), "ADMIN_PASSWORD", "admin_password"),
+			want: []string{"ADMIN_PASSWORD: is set to a well-known default and must be changed in production"},
+		},
+		{
+			name: "short JWT secret",
+			env:  []string{"APP_ENV", "production", "JWT_SECRET", "short-secret", "DB_PASSWORD", "db-password"},
+			want: []string{"JWT_SECRET: must be at least 32 characters in production"},
+		},
+		{
+			name: "secrets changed",
+			env:  safe,
+		},
+		{
+			name: "default secrets in development",
+			env:  []string{"APP_ENV", "development", "ADMIN_PASSWORD", "admin_password"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadTestConfig(t, tt.env)
+			got := configProblems(t, err)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("problems = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+// config/loader.go
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the environment variable that points at the config file when the
+// --config flag isn't given
+const ConfigFileEnv = "CONFIG_FILE"
+
+// Error lists every problem found while loading or validating the configuration
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Loader loads the configuration, taking flags from a flag set it registered them on, so
+// commands can mix their own flags with the configuration's
+type Loader struct {
+	cfg        *Config
+	settings   []setting
+	configFile *string
+	flagValues map[string]string
+}
+
+// NewLoader registers --config and a flag for every setting on flags. Call Load once the
+// flags have been parsed.
+func NewLoader(flags *flag.FlagSet) *Loader {
+	cfg := Default()
+	l := &Loader{
+		cfg:        cfg,
+		settings:   collectSettings(reflect.ValueOf(cfg).Elem(), "", ""),
+		configFile: flags.String("config", os.Getenv(ConfigFileEnv), "YAML or TOML config file"),
+		flagValues: make(map[string]string),
+	}
+	for _, setting := range l.settings {
+		name := setting.flag()
+		flags.Func(name, "overrides "+setting.env, func(value string) error {
+			l.flagValues[name] = value
+			return nil
+		})
+	}
+	return l
+}
+
+// Load builds the configuration from, in increasing order of precedence, the defaults, the
+// YAML or TOML file named by the --config flag or CONFIG_FILE, environment variables and
+// command line flags, then validates it. Problems are reported together in an *Error.
+func (l *Loader) Load() (*Config, error) {
+	cfg := l.cfg
+	var problems []string
+	if *l.configFile != "" {
+		if err := loadFile(cfg, *l.configFile); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	// Settings are collected again, as the file may have added identity providers
+	for _, setting := range collectSettings(reflect.ValueOf(cfg).Elem(), "", "") {
+		if value := os.Getenv(setting.env); value != "" {
+			if err := setString(setting.value, value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", setting.env, err))
+			}
+		}
+	}
+	problems = append(problems, applyEnvProviders(reflect.ValueOf(cfg).Elem())...)
+	for _, setting := range l.settings {
+		if value, ok := l.flagValues[setting.flag()]; ok {
+			if err := setString(setting.value, value); err != nil {
+				problems = append(problems, fmt.Sprintf("--%s: %v", setting.flag(), err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+
+	cfg.applyDerivedDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Load parses args, which should not include the program name, for the configuration's
+// flags alone and loads the configuration
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	loader := NewLoader(flags)
+	if err := flags.Parse(args); err != nil {
+		return nil, &Error{Problems: []string{err.Error()}}
+	}
+	if flags.NArg() > 0 {
+		return nil, &Error{Problems: []string{fmt.Sprintf("unexpected argument %q", flags.Arg(0))}}
+	}
+	return loader.Load()
+}
+
+// A single value that can be set from the file, the environment or a flag
+type setting struct {
+	value reflect.Value
+	key   string // Dotted path in the config file, e.g. database.host
+	env   string
+}
+
+// Flags are named after the environment variable, e.g. --db-host for DB_HOST
+func (s setting) flag() string {
+	return strings.ToLower(strings.ReplaceAll(s.env, "_", "-"))
+}
+
+// Interface of map entries that start from non-zero defaults
+type defaulter interface {
+	setDefaults()
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Helper function to list the settings in a struct, recursing into nested sections.
+// Identity provider maps are left to applyEnvProviders.
+func collectSettings(v reflect.Value, keyPrefix, envPrefix string) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+		key := keyPrefix + field.Tag.Get("key")
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			settings = append(settings, collectSettings(value, key+".", envPrefix)...)
+		case value.Kind() == reflect.Map && value.Type().Elem().Kind() == reflect.Struct:
+			continue
+		case field.Tag.Get("env") != "":
+			settings = append(settings, setting{value: value, key: key, env: envPrefix + field.Tag.Get("env")})
+		}
+	}
+	return settings
+}
+
+// Helper function to apply the environment variables of identity providers, which are
+// named after the provider: EXTERNAL_IDPS=google reads EXTERNAL_IDP_GOOGLE_ISSUER and so
+// on. Providers already in the config file can be overridden the same way.
+func applyEnvProviders(v reflect.Value) []string {
+	var problems []string
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			problems = append(problems, applyEnvProviders(value)...)
+			continue
+		}
+		prefix := field.Tag.Get("envPrefix")
+		if value.Kind() != reflect.Map || prefix == "" {
+			continue
+		}
+
+		names := make(map[string]bool)
+		for _, key := range value.MapKeys() {
+			names[key.String()] = true
+		}
+		listEnv := field.Tag.Get("env")
+		for _, name := range splitList(os.Getenv(listEnv)) {
+			names[name] = true
+		}
+
+		for _, name := range sortedKeys(names) {
+			entry := mapEntry(value, name)
+			entryPrefix := prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+			for _, setting := range collectSettings(entry, "", entryPrefix) {
+				if raw := os.Getenv(setting.env); raw != "" {
+					if err := setString(setting.value, raw); err != nil {
+						problems = append(problems, fmt.Sprintf("%s: %v", setting.env, err))
+					}
+				}
+			}
+			value.SetMapIndex(reflect.ValueOf(name), entry)
+		}
+	}
+	return problems
+}
+
+// Helper function to read the config file, YAML or TOML depending on its extension
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var raw map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var problems []string
+	applyFile(reflect.ValueOf(cfg).Elem(), raw, "", &problems)
+	if len(problems) > 0 {
+		return errors.New(path + ": " + strings.Join(problems, "; "+path+": "))
+	}
+	return nil
+}
+
+// Helper function to apply a section of the config file to a struct. Unknown keys are
+// reported, so typos don't go unnoticed.
+func applyFile(v reflect.Value, raw map[string]interface{}, keyPrefix string, problems *[]string) {
+	fields := make(map[string]int)
+	for i := 0; i < v.NumField(); i++ {
+		fields[v.Type().Field(i).Tag.Get("key")] = i
+	}
+
+	for _, key := range sortedKeys(raw) {
+		path := keyPrefix + key
+		index, ok := fields[key]
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("unknown setting %s", path))
+			continue
+		}
+		value := v.Field(index)
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			section, ok := raw[key].(map[string]interface{})
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("%s must be a table", path))
+				continue
+			}
+			applyFile(value, section, path+".", problems)
+		case value.Kind() == reflect.Map && value.Type().Elem().Kind() == reflect.Struct:
+			entries, ok := raw[key].(map[string]interface{})
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("%s must be a table", path))
+				continue
+			}
+			for _, name := range sortedKeys(entries) {
+				section, ok := entries[name].(map[string]interface{})
+				if !ok {
+					*problems = append(*problems, fmt.Sprintf("%s.%s must be a table", path, name))
+					continue
+				}
+				entry := mapEntry(value, name)
+				applyFile(entry, section, path+"."+name+".", problems)
+				value.SetMapIndex(reflect.ValueOf(name), entry)
+			}
+		default:
+			if err := setRaw(value, raw[key]); err != nil {
+				*problems = append(*problems, fmt.Sprintf("%s: %v", path, err))
+			}
+		}
+	}
+}
+
+// Helper function to get an addressable copy of a map entry, starting from its defaults
+// when it doesn't exist yet
+func mapEntry(m reflect.Value, name string) reflect.Value {
+	entry := reflect.New(m.Type().Elem()).Elem()
+	if existing := m.MapIndex(reflect.ValueOf(name)); existing.IsValid() {
+		entry.Set(existing)
+	} else if d, ok := entry.Addr().Interface().(defaulter); ok {
+		d.setDefaults()
+	}
+	return entry
+}
+
+// Helper function to set a value from the config file, which may already be typed
+func setRaw(v reflect.Value, raw interface{}) error {
+	if s, ok := raw.(string); ok {
+		return setString(v, s)
+	}
+
+	switch {
+	case v.Type() == durationType:
+		return fmt.Errorf("must be a duration such as \"15m\"")
+	case v.Kind() == reflect.String:
+		switch raw.(type) {
+		case int, int64, float64, bool:
+			v.SetString(fmt.Sprint(raw))
+			return nil
+		}
+	case v.Kind() == reflect.Int:
+		switch n := raw.(type) {
+		case int:
+			v.SetInt(int64(n))
+			return nil
+		case int64:
+			v.SetInt(n)
+			return nil
+		}
+	case v.Kind() == reflect.Float64:
+		switch n := raw.(type) {
+		case int:
+			v.SetFloat(float64(n))
+			return nil
+		case int64:
+			v.SetFloat(float64(n))
+			return nil
+		case float64:
+			v.SetFloat(n)
+			return nil
+		}
+	case v.Kind() == reflect.Bool:
+		if b, ok := raw.(bool); ok {
+			v.SetBool(b)
+			return nil
+		}
+	case v.Kind() == reflect.Slice:
+		items, ok := raw.([]interface{})
+		if !ok {
+			break
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			list = append(list, fmt.Sprint(item))
+		}
+		v.Set(reflect.ValueOf(list))
+		return nil
+	case v.Kind() == reflect.Map:
+		entries, ok := raw.(map[string]interface{})
+		if !ok {
+			break
+		}
+		m := reflect.MakeMap(v.Type())
+		for _, key := range sortedKeys(entries) {
+			entry := reflect.New(v.Type().Elem()).Elem()
+			if err := setRaw(entry, entries[key]); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			m.SetMapIndex(reflect.ValueOf(key), entry)
+		}
+		v.Set(m)
+		return nil
+	}
+	return fmt.Errorf("has the wrong type (%T)", raw)
+}
+
+// Helper function to set a value from its text form, as found in environment variables
+// and flags. Lists are separated by commas, maps of roles look like "group=>role;group=>role"
+// and maps of limits like "role=1,role=3".
+func setString(v reflect.Value, s string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(s)))
+	case v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.String:
+		m := make(map[string]string)
+		for _, entry := range strings.Split(s, ";") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			group, role, ok := strings.Cut(entry, "=>")
+			if !ok {
+				return fmt.Errorf("invalid mapping %q, expected group=>role", entry)
+			}
+			m[strings.TrimSpace(group)] = strings.TrimSpace(role)
+		}
+		v.Set(reflect.ValueOf(m))
+	case v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.Int:
+		m := make(map[string]int)
+		for _, entry := range strings.Split(s, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return fmt.Errorf("invalid entry %q, expected name=number", entry)
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number in %q", entry)
+			}
+			m[name] = n
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// Helper function to split a list on commas and whitespace
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// Helper function to iterate over a map in a stable order, so problems are reported consistently
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,224 @@
+// config/validate.go
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loganmanery/go-react-app/logging"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Secrets that ship in examples and defaults, which production must never run with
+var knownDefaultSecrets = map[string][]string{
+	"JWT_SECRET":     {"your-secret-key"},
+	"CSRF_SECRET":    {"your-secret-key"},
+	"DB_PASSWORD":    {"password"},
+	"ADMIN_PASSWORD": {"admin_password"},
+}
+
+// Helper type to collect every problem instead of stopping at the first
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, setting, format string, args ...interface{}) {
+	if !ok {
+		v.problems = append(v.problems, setting+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) positive(setting string, value time.Duration) {
+	v.check(value > 0, setting, "must be a positive duration")
+}
+
+func (v *validator) port(setting string, value int) {
+	v.check(value > 0 && value <= 65535, setting, "must be a port between 1 and 65535")
+}
+
+func (v *validator) oneOf(setting, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.check(false, setting, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
+
+// url checks an absolute URL with one of the given schemes; empty values are left to the caller
+func (v *validator) url(setting, value string, schemes ...string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		v.check(false, setting, "must be an absolute URL, got %q", value)
+		return
+	}
+	v.oneOf(setting+" scheme", u.Scheme, schemes...)
+}
+
+func (v *validator) notDefault(setting, value string) {
+	for _, secret := range knownDefaultSecrets[setting] {
+		v.check(value != secret, setting, "is set to a well-known default and must be changed in production")
+	}
+}
+
+// Validate checks every setting, and in production refuses well-known default secrets.
+// All problems are reported together in an *Error.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.oneOf("APP_ENV", c.Environment, EnvironmentDevelopment, EnvironmentProduction)
+	v.port("PORT", c.Server.Port)
+	if c.Server.AdminAddr != "" {
+		// The main listener binds every interface, so the ports must differ whatever the host
+		_, port, err := net.SplitHostPort(c.Server.AdminAddr)
+		n, _ := strconv.Atoi(port)
+		v.check(err == nil && n > 0 && n <= 65535, "ADMIN_LISTEN_ADDR", "must be a host:port address, got %q", c.Server.AdminAddr)
+		v.check(n != c.Server.Port, "ADMIN_LISTEN_ADDR", "must not use the same port as PORT")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		v.check(cidrErr == nil || net.ParseIP(proxy) != nil, "TRUSTED_PROXIES", "must be IP addresses or CIDR ranges, got %q", proxy)
+	}
+	v.oneOf("LOG_LEVEL", strings.ToLower(c.Log.Level), "debug", "info", "warn", "error")
+	v.oneOf("LOG_FORMAT", c.Log.Format, logging.FormatText, logging.FormatJSON)
+	v.check(c.App.Name != "", "APP_NAME", "must not be empty")
+	v.check(c.App.BaseURL != "", "APP_BASE_URL", "must not be empty")
+	v.url("APP_BASE_URL", c.App.BaseURL, "http", "https")
+
+	v.check(c.Database.Host != "", "DB_HOST", "must not be empty")
+	v.port("DB_PORT", c.Database.Port)
+	v.check(c.Database.User != "", "DB_USER", "must not be empty")
+	v.check(c.Database.Name != "", "DB_NAME", "must not be empty")
+	v.oneOf("DB_SSLMODE", c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+
+	v.check(c.Auth.JWTSecret != "", "JWT_SECRET", "must not be empty")
+	v.check(c.Auth.TokenExpiryMinutes > 0, "TOKEN_EXPIRY_MINUTES", "must be positive")
+	if c.Admin.Password != "" {
+		_, err := mail.ParseAddress(c.Admin.Email)
+		v.check(err == nil, "ADMIN_EMAIL", "must be an email address, got %q", c.Admin.Email)
+		v.check(c.Admin.Username != "", "ADMIN_USERNAME", "must not be empty")
+	}
+
+	v.positive("SESSION_IDLE_TIMEOUT", c.Session.IdleTimeout)
+	v.positive("SESSION_ABSOLUTE_TIMEOUT", c.Session.AbsoluteTimeout)
+	v.positive("SESSION_ACTIVITY_UPDATE_INTERVAL", c.Session.ActivityUpdateInterval)
+	v.positive("REMEMBER_ME_IDLE_TIMEOUT", c.Session.RememberMeIdleTimeout)
+	v.positive("REMEMBER_ME_ABSOLUTE_TIMEOUT", c.Session.RememberMeAbsoluteTimeout)
+	v.check(c.Session.IdleTimeout <= c.Session.AbsoluteTimeout, "SESSION_IDLE_TIMEOUT", "must not exceed SESSION_ABSOLUTE_TIMEOUT")
+	v.check(c.Session.MaxConcurrent >= 0, "SESSION_MAX_CONCURRENT", "must not be negative")
+	v.oneOf("SESSION_LIMIT_ACTION", c.Session.LimitAction, services.SessionLimitEvictOldest, services.SessionLimitReject)
+	for _, role := range sortedKeys(c.Session.RoleLimits) {
+		v.check(c.Session.RoleLimits[role] >= 0, "SESSION_ROLE_LIMITS", "limit for %s must not be negative", role)
+	}
+	v.check(c.Session.CacheSize >= 0, "SESSION_CACHE_SIZE", "must not be negative")
+	if c.Session.CacheSize > 0 {
+		v.positive("SESSION_CACHE_TTL", c.Session.CacheTTL)
+	}
+	v.positive("IMPERSONATION_TTL", c.Session.ImpersonationTTL)
+
+	v.check(c.Cookie.Name != "", "SESSION_COOKIE_NAME", "must not be empty")
+	v.check(c.Cookie.DeviceName != "", "DEVICE_COOKIE_NAME", "must not be empty")
+	v.check(c.Cookie.Name != c.Cookie.DeviceName, "DEVICE_COOKIE_NAME", "must differ from SESSION_COOKIE_NAME")
+	v.check(strings.HasPrefix(c.Cookie.Path, "/"), "SESSION_COOKIE_PATH", "must start with /")
+	v.oneOf("SESSION_COOKIE_SAMESITE", strings.ToLower(c.Cookie.SameSite), "lax", "strict", "none")
+	if strings.EqualFold(c.Cookie.SameSite, "none") {
+		v.check(c.Cookie.Secure, "SESSION_COOKIE_SAMESITE", "none requires SESSION_COOKIE_SECURE")
+	}
+
+	v.check(c.CSRF.Secret != "", "CSRF_SECRET", "must not be empty")
+	for _, origin := range c.CSRF.AllowedOrigins {
+		v.url("CSRF_ALLOWED_ORIGINS", origin, "http", "https")
+	}
+	v.oneOf("RATE_LIMIT_STORE", c.RateLimit.Store, "memory", "postgres")
+
+	v.check(c.Password.MinLength > 0, "PASSWORD_MIN_LENGTH", "must be positive")
+	v.check(c.Password.MinStrength >= 0 && c.Password.MinStrength <= 4, "PASSWORD_MIN_STRENGTH", "must be between 0 and 4")
+	v.check(c.Password.HistoryCount >= 0, "PASSWORD_HISTORY_COUNT", "must not be negative")
+	v.check(c.Password.BreachedMinCount > 0, "BREACHED_PASSWORDS_MIN_COUNT", "must be positive")
+
+	v.check(c.Lockout.MaxAttempts > 0, "LOCKOUT_MAX_ATTEMPTS", "must be positive")
+	v.positive("LOCKOUT_WINDOW", c.Lockout.Window)
+	v.positive("LOCKOUT_DURATION", c.Lockout.Duration)
+	v.check(c.Lockout.Multiplier >= 1, "LOCKOUT_MULTIPLIER", "must be at least 1")
+	v.check(c.Lockout.MaxDuration >= c.Lockout.Duration, "LOCKOUT_MAX_DURATION", "must not be shorter than LOCKOUT_DURATION")
+	v.positive("LOCKOUT_ESCALATION_RESET", c.Lockout.EscalationReset)
+
+	v.check(c.LoginRisk.ChallengeThreshold >= 0, "LOGIN_RISK_CHALLENGE_THRESHOLD", "must not be negative")
+	v.check(c.LoginRisk.BlockThreshold >= 0, "LOGIN_RISK_BLOCK_THRESHOLD", "must not be negative")
+	if c.LoginRisk.BlockThreshold > 0 && c.LoginRisk.ChallengeThreshold > 0 {
+		v.check(c.LoginRisk.BlockThreshold > c.LoginRisk.ChallengeThreshold, "LOGIN_RISK_BLOCK_THRESHOLD", "must be above LOGIN_RISK_CHALLENGE_THRESHOLD")
+	}
+	v.check(c.LoginRisk.MaxTravelSpeedKmh > 0, "LOGIN_RISK_MAX_TRAVEL_SPEED_KMH", "must be positive")
+	v.oneOf("LOGIN_CHALLENGE_METHOD", c.LoginRisk.ChallengeMethod, models.ChallengeMethodEmail, models.ChallengeMethodMFA)
+	v.positive("LOGIN_CHALLENGE_TTL", c.LoginRisk.ChallengeTTL)
+
+	v.positive("ACCOUNT_DELETION_GRACE_PERIOD", c.Accounts.DeletionGracePeriod)
+	v.positive("DELETED_USER_RELEASE_AFTER", c.Accounts.DeletedUserReleaseAfter)
+
+	if c.SMTP.Host != "" {
+		v.port("SMTP_PORT", c.SMTP.Port)
+		_, err := mail.ParseAddress(c.SMTP.From)
+		v.check(err == nil, "SMTP_FROM", "must be an email address, got %q", c.SMTP.From)
+	}
+
+	if c.LDAP.URL != "" {
+		v.url("LDAP_URL", c.LDAP.URL, "ldap", "ldaps")
+		v.check(c.LDAP.BaseDN != "", "LDAP_BASE_DN", "is required with LDAP_URL")
+		v.positive("LDAP_TIMEOUT", c.LDAP.Timeout)
+		v.check(c.LDAP.UserFilter != "", "LDAP_USER_FILTER", "must not be empty")
+		v.check(c.LDAP.BindDN == "" || c.LDAP.BindPassword != "", "LDAP_BIND_PASSWORD", "is required with LDAP_BIND_DN")
+		v.check(!c.LDAP.StartTLS || strings.HasPrefix(c.LDAP.URL, "ldap://"), "LDAP_START_TLS", "only applies to ldap:// URLs")
+	}
+
+	v.url("OIDC_ISSUER", c.OIDC.Issuer, "http", "https")
+	v.url("OIDC_LOGIN_URL", c.OIDC.LoginURL, "http", "https")
+	v.url("OIDC_CONSENT_URL", c.OIDC.ConsentURL, "http", "https")
+	v.positive("OIDC_ACCESS_TOKEN_TTL", c.OIDC.AccessTokenTTL)
+	v.positive("OIDC_ID_TOKEN_TTL", c.OIDC.IDTokenTTL)
+	v.positive("OIDC_REFRESH_TOKEN_TTL", c.OIDC.RefreshTokenTTL)
+
+	for _, name := range sortedKeys(c.ExternalIDPs) {
+		idp := c.ExternalIDPs[name]
+		setting := "external identity provider " + name
+		v.check(idp.Issuer != "", setting, "issuer is required")
+		v.url(setting+" issuer", idp.Issuer, "https", "http")
+		v.check(idp.ClientID != "", setting, "client ID is required")
+		v.check(idp.ClientSecret != "", setting, "client secret is required")
+	}
+
+	v.check((c.SAML.CertFile == "") == (c.SAML.KeyFile == ""), "SAML_CERT_FILE", "and SAML_KEY_FILE must be set together")
+	for _, name := range sortedKeys(c.SAML.IDPs) {
+		idp := c.SAML.IDPs[name]
+		setting := "SAML identity provider " + name
+		v.check(idp.MetadataURL != "" || idp.MetadataFile != "", setting, "metadata URL or file is required")
+		v.url(setting+" metadata URL", idp.MetadataURL, "https", "http")
+	}
+
+	if c.SCIM.Enabled {
+		v.check(c.SCIM.MaxResults > 0, "SCIM_MAX_RESULTS", "must be positive")
+	}
+
+	if c.IsProduction() {
+		v.notDefault("JWT_SECRET", c.Auth.JWTSecret)
+		if c.CSRF.Secret != c.Auth.JWTSecret {
+			v.notDefault("CSRF_SECRET", c.CSRF.Secret)
+		}
+		v.notDefault("DB_PASSWORD", c.Database.Password)
+		v.notDefault("ADMIN_PASSWORD", c.Admin.Password)
+		v.check(len(c.Auth.JWTSecret) >= 32, "JWT_SECRET", "must be at least 32 characters in production")
+	}
+
+	if len(v.problems) > 0 {
+		return &Error{Problems: v.problems}
+	}
+	return nil
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/jackc/pgx/v4"
@@ -62,7 +62,7 @@ func Connect(config DBConfig) (*Database, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Println("Successfully connected to the database")
+	slog.InfoContext(ctx, "Connected to the database")
 	return &Database{Pool: pool}, nil
 }
 
@@ -73,6 +73,11 @@ func (db *Database) Close() {
 	}
 }
 
+// Stat returns a snapshot of the connection pool's statistics
+func (db *Database) Stat() *pgxpool.Stat {
+	return db.Pool.Stat()
+}
+
 // InTransaction executes a function within a transaction
 func (db *Database) InTransaction(ctx context.Context, fn func(tx pgx.Tx) error) error {
 	// Begin transaction
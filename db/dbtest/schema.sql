@@ -0,0 +1,45 @@
+-- The tables the application started with, before db/migrations existed. The migrations
+-- only extend them, so a test database needs them first.
+CREATE SCHEMA IF NOT EXISTS auth;
+
+CREATE TABLE IF NOT EXISTS auth.users (
+    user_id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    username                   TEXT NOT NULL CONSTRAINT users_username_key UNIQUE,
+    email                      TEXT NOT NULL CONSTRAINT users_email_key UNIQUE,
+    password_hash              TEXT NOT NULL,
+    first_name                 TEXT NOT NULL DEFAULT '',
+    last_name                  TEXT NOT NULL DEFAULT '',
+    is_email_verified          BOOLEAN NOT NULL DEFAULT FALSE,
+    email_verification_token   TEXT,
+    email_verification_sent_at TIMESTAMPTZ,
+    password_reset_token       TEXT,
+    password_reset_expires_at  TIMESTAMPTZ,
+    failed_login_attempts      INTEGER NOT NULL DEFAULT 0,
+    locked_until               TIMESTAMPTZ,
+    last_login_at              TIMESTAMPTZ,
+    created_at                 TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at                 TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    is_active                  BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS auth.sessions (
+    session_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id        UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    token          TEXT NOT NULL UNIQUE,
+    ip_address     TEXT NOT NULL DEFAULT '',
+    user_agent     TEXT NOT NULL DEFAULT '',
+    expires_at     TIMESTAMPTZ NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_active_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    is_valid       BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE IF NOT EXISTS auth.audit_log (
+    log_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    details    JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
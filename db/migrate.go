@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migrate applies any schema migrations that have not been applied yet, in filename order
+func (db *Database) Migrate(ctx context.Context) error {
+	// Make sure the bookkeeping table exists
+	query := `
+		CREATE SCHEMA IF NOT EXISTS auth;
+		CREATE TABLE IF NOT EXISTS auth.schema_migrations (
+			version    TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`
+	if _, err := db.Pool.Exec(ctx, query); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	versions, err := migrationVersions()
+	if err != nil {
+		return err
+	}
+
+	for _, version := range versions {
+		var applied bool
+		err := db.Pool.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM auth.schema_migrations WHERE version = $1)`,
+			version,
+		).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("error checking migration %s: %w", version, err)
+		}
+		if applied {
+			continue
+		}
+
+		contents, err := migrationFiles.ReadFile("migrations/" + version + ".sql")
+		if err != nil {
+			return fmt.Errorf("error reading migration %s: %w", version, err)
+		}
+
+		// Apply the migration and record it in the same transaction
+		err = db.InTransaction(ctx, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, string(contents)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO auth.schema_migrations (version) VALUES ($1)`, version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error applying migration %s: %w", version, err)
+		}
+
+		slog.InfoContext(ctx, "Applied migration", "version", version)
+	}
+
+	return nil
+}
+
+// PendingMigrations lists the embedded migrations that have not been applied yet, in the
+// order Migrate would apply them
+func (db *Database) PendingMigrations(ctx context.Context) ([]string, error) {
+	versions, err := migrationVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing has been applied before the bookkeeping table exists
+	var exists bool
+	if err := db.Pool.QueryRow(ctx, `SELECT to_regclass('auth.schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("error checking schema_migrations table: %w", err)
+	}
+	if !exists {
+		return versions, nil
+	}
+
+	rows, err := db.Pool.Query(ctx, `SELECT version FROM auth.schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pending []string
+	for _, version := range versions {
+		if !applied[version] {
+			pending = append(pending, version)
+		}
+	}
+	return pending, nil
+}
+
+// Helper function to list the embedded migration versions in order
+func migrationVersions() ([]string, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error listing migrations: %w", err)
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		versions = append(versions, strings.TrimSuffix(entry.Name(), ".sql"))
+	}
+	sort.Strings(versions)
+
+	return versions, nil
+}
@@ -0,0 +1,10 @@
+-- Previous password hashes, used to prevent password reuse
+CREATE TABLE IF NOT EXISTS auth.password_history (
+    history_id    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_user_created
+    ON auth.password_history (user_id, created_at DESC);
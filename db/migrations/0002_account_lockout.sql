@@ -0,0 +1,5 @@
+-- Sliding-window failure tracking and escalating lockouts
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS failed_login_timestamps TIMESTAMPTZ[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS lockout_count           INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_locked_at          TIMESTAMPTZ;
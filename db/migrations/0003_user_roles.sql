@@ -0,0 +1,9 @@
+-- Roles granted to users, e.g. "admin"
+CREATE TABLE IF NOT EXISTS auth.user_roles (
+    user_id    UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    role       TEXT NOT NULL,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_roles_role ON auth.user_roles (role);
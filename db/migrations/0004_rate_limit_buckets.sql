@@ -0,0 +1,9 @@
+-- Token buckets shared by all replicas for rate limiting
+CREATE TABLE IF NOT EXISTS auth.rate_limit_buckets (
+    bucket_key TEXT PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    allowed    BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated ON auth.rate_limit_buckets (updated_at);
@@ -0,0 +1,6 @@
+-- Sessions slide expires_at forward on activity but never past absolute_expires_at
+ALTER TABLE auth.sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMPTZ;
+
+UPDATE auth.sessions SET absolute_expires_at = expires_at WHERE absolute_expires_at IS NULL;
+
+ALTER TABLE auth.sessions ALTER COLUMN absolute_expires_at SET NOT NULL;
@@ -0,0 +1,2 @@
+-- "Remember me" sessions get longer lifetimes and a persistent cookie
+ALTER TABLE auth.sessions ADD COLUMN IF NOT EXISTS is_persistent BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,23 @@
+-- Devices users have signed in from, recognised by a long-lived device cookie
+CREATE TABLE IF NOT EXISTS auth.user_devices (
+    device_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    token_hash    TEXT NOT NULL,
+    browser       TEXT NOT NULL DEFAULT '',
+    os            TEXT NOT NULL DEFAULT '',
+    device_type   TEXT NOT NULL DEFAULT '',
+    status        TEXT NOT NULL DEFAULT 'new', -- new, trusted or reported
+    last_ip       TEXT NOT NULL DEFAULT '',
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, token_hash)
+);
+
+-- Parsed device details stored with each session
+ALTER TABLE auth.sessions
+    ADD COLUMN IF NOT EXISTS device_id      UUID REFERENCES auth.user_devices (device_id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS device_browser TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS device_os      TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS device_type    TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_sessions_device ON auth.sessions (device_id);
@@ -0,0 +1,33 @@
+-- Where each session was started from and how risky the login looked
+ALTER TABLE auth.sessions
+    ADD COLUMN IF NOT EXISTS geo_country   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS geo_latitude  DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS geo_longitude DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS risk_score    INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_created ON auth.sessions (user_id, created_at DESC);
+
+-- High-risk logins that must be confirmed by email (or MFA) before a session is created
+CREATE TABLE IF NOT EXISTS auth.login_challenges (
+    challenge_id        UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id             UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    device_id           UUID REFERENCES auth.user_devices (device_id) ON DELETE CASCADE,
+    secret_hash         TEXT NOT NULL,
+    approval_token_hash TEXT,
+    method              TEXT NOT NULL, -- email or mfa
+    risk_score          INTEGER NOT NULL,
+    geo_country         TEXT NOT NULL DEFAULT '',
+    geo_latitude        DOUBLE PRECISION,
+    geo_longitude       DOUBLE PRECISION,
+    new_device          BOOLEAN NOT NULL DEFAULT false,
+    remember_me         BOOLEAN NOT NULL DEFAULT false,
+    ip_address          TEXT NOT NULL DEFAULT '',
+    user_agent          TEXT NOT NULL DEFAULT '',
+    expires_at          TIMESTAMPTZ NOT NULL,
+    approved_at         TIMESTAMPTZ,
+    completed_at        TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_login_challenges_approval_token
+    ON auth.login_challenges (approval_token_hash) WHERE approval_token_hash IS NOT NULL;
@@ -0,0 +1,35 @@
+-- Tell every replica to drop cached sessions and users when they are revoked or change.
+-- Payloads are "session:<user_id>:<session_id>" or "user:<user_id>".
+CREATE OR REPLACE FUNCTION auth.notify_session_invalidated() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('auth_cache_invalidation', 'session:' || OLD.user_id || ':' || OLD.session_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION auth.notify_user_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('auth_cache_invalidation', 'user:' || OLD.user_id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS sessions_invalidated_notify ON auth.sessions;
+CREATE TRIGGER sessions_invalidated_notify
+    AFTER UPDATE OF is_valid ON auth.sessions
+    FOR EACH ROW
+    WHEN (OLD.is_valid AND NOT NEW.is_valid)
+    EXECUTE FUNCTION auth.notify_session_invalidated();
+
+DROP TRIGGER IF EXISTS sessions_deleted_notify ON auth.sessions;
+CREATE TRIGGER sessions_deleted_notify
+    AFTER DELETE ON auth.sessions
+    FOR EACH ROW
+    WHEN (OLD.is_valid)
+    EXECUTE FUNCTION auth.notify_session_invalidated();
+
+DROP TRIGGER IF EXISTS users_changed_notify ON auth.users;
+CREATE TRIGGER users_changed_notify
+    AFTER UPDATE OR DELETE ON auth.users
+    FOR EACH ROW
+    EXECUTE FUNCTION auth.notify_user_changed();
@@ -0,0 +1,19 @@
+-- Personal access tokens for scripts and CI. Only a hash of each token is stored;
+-- token_prefix is the start of the token, kept so users can tell their tokens apart.
+CREATE TABLE IF NOT EXISTS auth.access_tokens (
+    token_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id      UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    token_prefix TEXT NOT NULL,
+    token_hash   TEXT NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    allowed_ips  TEXT[] NOT NULL DEFAULT '{}', -- Addresses or CIDR ranges; empty allows any
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_tokens_user ON auth.access_tokens (user_id);
+
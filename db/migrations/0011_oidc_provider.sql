@@ -0,0 +1,56 @@
+-- Relying parties that can sign users in through our OpenID Connect provider.
+-- Public clients (e.g. SPAs) have no secret and must use PKCE.
+CREATE TABLE IF NOT EXISTS auth.oauth_clients (
+    client_id          TEXT PRIMARY KEY,
+    client_secret_hash TEXT,
+    name               TEXT NOT NULL,
+    redirect_uris      TEXT[] NOT NULL,
+    allowed_scopes     TEXT[] NOT NULL DEFAULT '{openid}',
+    require_consent    BOOLEAN NOT NULL DEFAULT true,
+    created_by         UUID REFERENCES auth.users (user_id) ON DELETE SET NULL,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Scopes a user has agreed to share with a client
+CREATE TABLE IF NOT EXISTS auth.oauth_consents (
+    user_id    UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    client_id  TEXT NOT NULL REFERENCES auth.oauth_clients (client_id) ON DELETE CASCADE,
+    scopes     TEXT[] NOT NULL,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
+
+-- Authorization requests, from /oauth/authorize through consent to the code being redeemed
+CREATE TABLE IF NOT EXISTS auth.oauth_authorizations (
+    authorization_id      UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id             TEXT NOT NULL REFERENCES auth.oauth_clients (client_id) ON DELETE CASCADE,
+    user_id               UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    redirect_uri          TEXT NOT NULL,
+    scopes                TEXT[] NOT NULL,
+    state                 TEXT NOT NULL DEFAULT '',
+    nonce                 TEXT NOT NULL DEFAULT '',
+    code_challenge        TEXT NOT NULL DEFAULT '',
+    code_challenge_method TEXT NOT NULL DEFAULT '',
+    auth_time             TIMESTAMPTZ NOT NULL,
+    code_hash             TEXT UNIQUE,
+    expires_at            TIMESTAMPTZ NOT NULL,
+    approved_at           TIMESTAMPTZ,
+    used_at               TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Opaque access and refresh tokens issued to clients
+CREATE TABLE IF NOT EXISTS auth.oauth_tokens (
+    token_id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash       TEXT NOT NULL UNIQUE,
+    token_type       TEXT NOT NULL, -- access or refresh
+    client_id        TEXT NOT NULL REFERENCES auth.oauth_clients (client_id) ON DELETE CASCADE,
+    user_id          UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    scopes           TEXT[] NOT NULL,
+    authorization_id UUID REFERENCES auth.oauth_authorizations (authorization_id) ON DELETE SET NULL,
+    expires_at       TIMESTAMPTZ NOT NULL,
+    revoked_at       TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_authorization ON auth.oauth_tokens (authorization_id);
@@ -0,0 +1,26 @@
+-- Accounts at external OpenID Connect providers that users sign in with
+CREATE TABLE IF NOT EXISTS auth.external_identities (
+    identity_id   UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id       UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    provider      TEXT NOT NULL,
+    subject       TEXT NOT NULL, -- The provider's "sub" claim
+    email         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_external_identities_user ON auth.external_identities (user_id);
+
+-- Sign-ins sent to an external provider and not back yet. Each row holds the secrets
+-- that tie the provider's callback to the request that started it.
+CREATE TABLE IF NOT EXISTS auth.external_logins (
+    state_hash    TEXT PRIMARY KEY,
+    provider      TEXT NOT NULL,
+    nonce         TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    return_to     TEXT NOT NULL DEFAULT '',
+    remember_me   BOOLEAN NOT NULL DEFAULT false,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,39 @@
+-- SAML authentication requests sent to an identity provider and not answered yet. The
+-- relay state ties the response to the request and the browser that started it.
+CREATE TABLE IF NOT EXISTS auth.saml_requests (
+    state_hash  TEXT PRIMARY KEY,
+    provider    TEXT NOT NULL,
+    request_id  TEXT NOT NULL,
+    return_to   TEXT NOT NULL DEFAULT '',
+    remember_me BOOLEAN NOT NULL DEFAULT false,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Assertions that have been used to sign in, kept until they could no longer be
+-- accepted so that none is accepted twice
+CREATE TABLE IF NOT EXISTS auth.saml_assertions (
+    provider     TEXT NOT NULL,
+    assertion_id TEXT NOT NULL,
+    expires_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, assertion_id)
+);
+
+-- The identity provider session each of our sessions came from, so that single logout
+-- can find them. Logins held back by a challenge are linked to the challenge until
+-- their session is created.
+CREATE TABLE IF NOT EXISTS auth.saml_sessions (
+    link_id        UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    provider       TEXT NOT NULL,
+    name_id        TEXT NOT NULL,
+    name_id_format TEXT NOT NULL DEFAULT '',
+    session_index  TEXT NOT NULL DEFAULT '',
+    session_id     UUID REFERENCES auth.sessions (session_id) ON DELETE CASCADE,
+    challenge_id   UUID REFERENCES auth.login_challenges (challenge_id) ON DELETE CASCADE,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (session_id IS NOT NULL OR challenge_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_saml_sessions_name_id ON auth.saml_sessions (provider, name_id);
+CREATE INDEX IF NOT EXISTS idx_saml_sessions_session ON auth.saml_sessions (session_id);
+CREATE INDEX IF NOT EXISTS idx_saml_sessions_challenge ON auth.saml_sessions (challenge_id);
@@ -0,0 +1,29 @@
+-- Users created or managed by a SCIM provisioning client, with the client's own
+-- identifier for them
+CREATE TABLE IF NOT EXISTS auth.scim_users (
+    user_id     UUID PRIMARY KEY REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    external_id TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_users_external_id ON auth.scim_users (external_id);
+
+-- Groups pushed by the provisioning client. Groups named in SCIM_GROUP_ROLES grant
+-- their members a role.
+CREATE TABLE IF NOT EXISTS auth.scim_groups (
+    group_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    display_name TEXT NOT NULL,
+    external_id  TEXT,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_groups_display_name ON auth.scim_groups (lower(display_name));
+
+CREATE TABLE IF NOT EXISTS auth.scim_group_members (
+    group_id UUID NOT NULL REFERENCES auth.scim_groups (group_id) ON DELETE CASCADE,
+    user_id  UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_group_members_user ON auth.scim_group_members (user_id);
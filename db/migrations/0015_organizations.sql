@@ -0,0 +1,48 @@
+-- Organizations (tenants) that users belong to with a role
+CREATE TABLE IF NOT EXISTS auth.organizations (
+    org_id     UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name       TEXT NOT NULL,
+    slug       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_organizations_slug ON auth.organizations (lower(slug));
+
+CREATE TABLE IF NOT EXISTS auth.organization_members (
+    org_id     UUID NOT NULL REFERENCES auth.organizations (org_id) ON DELETE CASCADE,
+    user_id    UUID NOT NULL REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    role       TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_members_user ON auth.organization_members (user_id);
+
+-- Invitations to join an organization, accepted with a token sent to the invited address
+CREATE TABLE IF NOT EXISTS auth.organization_invitations (
+    invitation_id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    org_id        UUID NOT NULL REFERENCES auth.organizations (org_id) ON DELETE CASCADE,
+    email         TEXT NOT NULL,
+    role          TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    token_hash    TEXT NOT NULL UNIQUE,
+    invited_by    UUID REFERENCES auth.users (user_id) ON DELETE SET NULL,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    accepted_at   TIMESTAMPTZ,
+    accepted_by   UUID REFERENCES auth.users (user_id) ON DELETE SET NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_organization_invitations_org ON auth.organization_invitations (org_id);
+
+-- The organization a session is working in
+ALTER TABLE auth.sessions
+    ADD COLUMN IF NOT EXISTS active_org_id UUID REFERENCES auth.organizations (org_id) ON DELETE SET NULL;
+
+-- Cached sessions carry their active organization, so drop them when it changes
+DROP TRIGGER IF EXISTS sessions_org_changed_notify ON auth.sessions;
+CREATE TRIGGER sessions_org_changed_notify
+    AFTER UPDATE OF active_org_id ON auth.sessions
+    FOR EACH ROW
+    WHEN (OLD.is_valid AND OLD.active_org_id IS DISTINCT FROM NEW.active_org_id)
+    EXECUTE FUNCTION auth.notify_session_invalidated();
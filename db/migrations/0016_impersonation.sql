@@ -0,0 +1,7 @@
+-- Sessions an admin started to act as another user. impersonator_session_id is the admin's
+-- own session, which they return to when the impersonation ends.
+ALTER TABLE auth.sessions
+    ADD COLUMN IF NOT EXISTS impersonator_id         UUID REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS impersonator_session_id UUID REFERENCES auth.sessions (session_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_impersonator ON auth.sessions (impersonator_id) WHERE impersonator_id IS NOT NULL;
@@ -0,0 +1,12 @@
+-- Deletions users have asked for, carried out once the grace period ends unless cancelled
+CREATE TABLE IF NOT EXISTS auth.account_deletions (
+    user_id           UUID PRIMARY KEY REFERENCES auth.users (user_id) ON DELETE CASCADE,
+    cancel_token_hash TEXT NOT NULL UNIQUE,
+    requested_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delete_after      TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_deletions_delete_after ON auth.account_deletions (delete_after);
+
+-- Audit entries outlive the users they are about, anonymised
+ALTER TABLE auth.audit_log ALTER COLUMN user_id DROP NOT NULL;
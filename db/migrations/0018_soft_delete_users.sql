@@ -0,0 +1,7 @@
+-- Deleted users are kept, hidden, until an admin purges them
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- When a deleted user's email and username were replaced so others could use them
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS identifiers_released_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON auth.users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Emails and usernames are stored in their canonical form: NFKC normalized, trimmed and
+-- lowercased. The application normalizes them in Go before every write and lookup, so the
+-- existing unique constraints on the stored values are enough to keep two accounts from
+-- differing only by case, even when registrations race. Accounts that only differ by case
+-- have to be merged by hand before this runs.
+--
+-- lower() follows the database collation, which under "C" only lowercases ASCII; the ICU
+-- root collation lowercases the rest of Unicode the way Go's strings.ToLower does.
+UPDATE auth.users SET
+    email = lower(btrim(normalize(email, NFKC)) COLLATE "und-x-icu"),
+    username = lower(btrim(normalize(username, NFKC)) COLLATE "und-x-icu")
+WHERE email <> lower(btrim(normalize(email, NFKC)) COLLATE "und-x-icu")
+   OR username <> lower(btrim(normalize(username, NFKC)) COLLATE "und-x-icu");
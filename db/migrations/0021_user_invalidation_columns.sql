@@ -0,0 +1,25 @@
+-- Only tell replicas to drop a user's cached sessions when a change affects whether those
+-- sessions are still valid or what the cached user shows, not on every login or lockout
+-- counter update. Roles are checked against auth.user_roles on every request, so they are
+-- never stale in the cache.
+DROP TRIGGER IF EXISTS users_changed_notify ON auth.users;
+CREATE TRIGGER users_changed_notify
+    AFTER UPDATE OF is_active, deleted_at, locked_until, password_hash,
+        email, username, first_name, last_name, is_email_verified ON auth.users
+    FOR EACH ROW
+    WHEN (OLD.is_active IS DISTINCT FROM NEW.is_active
+       OR OLD.deleted_at IS DISTINCT FROM NEW.deleted_at
+       OR OLD.locked_until IS DISTINCT FROM NEW.locked_until
+       OR OLD.password_hash IS DISTINCT FROM NEW.password_hash
+       OR OLD.email IS DISTINCT FROM NEW.email
+       OR OLD.username IS DISTINCT FROM NEW.username
+       OR OLD.first_name IS DISTINCT FROM NEW.first_name
+       OR OLD.last_name IS DISTINCT FROM NEW.last_name
+       OR OLD.is_email_verified IS DISTINCT FROM NEW.is_email_verified)
+    EXECUTE FUNCTION auth.notify_user_changed();
+
+DROP TRIGGER IF EXISTS users_deleted_notify ON auth.users;
+CREATE TRIGGER users_deleted_notify
+    AFTER DELETE ON auth.users
+    FOR EACH ROW
+    EXECUTE FUNCTION auth.notify_user_changed();
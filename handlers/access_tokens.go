@@ -0,0 +1,80 @@
+// handlers/access_tokens.go
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+type createAccessTokenRequest struct {
+	Name       string     `json:"name" binding:"required,max=100"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"`
+	AllowedIPs []string   `json:"allowed_ips"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// CreateAccessToken issues a personal access token for the authenticated user.
+// The token is only ever shown in this response.
+func CreateAccessToken(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createAccessTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		token, value, err := authService.CreateAccessToken(c.Request.Context(), user.UserID, services.CreateAccessTokenRequest{
+			Name:       req.Name,
+			Scopes:     req.Scopes,
+			AllowedIPs: req.AllowedIPs,
+			ExpiresAt:  req.ExpiresAt,
+		}, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, gin.H{"access_token": token, "token": value})
+	}
+}
+
+// ListAccessTokens lists the authenticated user's personal access tokens
+func ListAccessTokens(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		tokens, err := authService.ListAccessTokens(c.Request.Context(), user.UserID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"access_tokens": tokens, "available_scopes": services.AccessTokenScopes})
+	}
+}
+
+// RevokeAccessToken revokes the personal access token in the :id path parameter
+func RevokeAccessToken(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid token ID")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.RevokeAccessToken(c.Request.Context(), user.UserID, tokenID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
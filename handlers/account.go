@@ -0,0 +1,88 @@
+// handlers/account.go
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// ExportAccount downloads everything held about the authenticated user as a JSON file
+func ExportAccount(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		export, err := authService.ExportAccount(c.Request.Context(), user.UserID, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		filename := fmt.Sprintf("account-%s-%s.json", user.Username, export.ExportedAt.Format("20060102"))
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		c.Header("Cache-Control", "no-store")
+		c.IndentedJSON(http.StatusOK, export)
+	}
+}
+
+// GetAccountDeletion returns the authenticated user's pending deletion request, if any
+func GetAccountDeletion(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		deletion, err := authService.GetAccountDeletion(c.Request.Context(), user.UserID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"deletion": deletion})
+	}
+}
+
+// RequestAccountDeletion schedules the authenticated user's account for deletion
+func RequestAccountDeletion(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		deletion, err := authService.RequestAccountDeletion(c.Request.Context(), user.UserID, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"deletion": deletion})
+	}
+}
+
+// CancelAccountDeletion cancels the authenticated user's pending deletion request
+func CancelAccountDeletion(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		if err := authService.CancelAccountDeletion(c.Request.Context(), user.UserID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// CancelAccountDeletionWithToken cancels a pending deletion request with the emailed token
+func CancelAccountDeletionWithToken(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req tokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		if err := authService.CancelAccountDeletionWithToken(c.Request.Context(), req.Token, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled"})
+	}
+}
@@ -0,0 +1,119 @@
+// handlers/admin.go
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// UnlockUser clears the lockout on the user in the :id path parameter
+func UnlockUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+			return
+		}
+
+		admin := middleware.CurrentUser(c)
+		if err := authService.UnlockUser(c.Request.Context(), admin.UserID, userID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// DeleteUser soft deletes the user in the :id path parameter
+func DeleteUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		admin := middleware.CurrentUser(c)
+		if err := authService.DeleteUser(c.Request.Context(), admin.UserID, userID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ListDeletedUsers lists deleted users, most recently deleted first, paged with the
+// offset and limit query parameters
+func ListDeletedUsers(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		offset, limit := 0, 50
+		if value, err := strconv.Atoi(c.Query("offset")); err == nil && value > 0 {
+			offset = value
+		}
+		if value, err := strconv.Atoi(c.Query("limit")); err == nil && value > 0 && value <= 200 {
+			limit = value
+		}
+
+		users, err := authService.ListDeletedUsers(c.Request.Context(), offset, limit)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"users": users, "offset": offset, "limit": limit})
+	}
+}
+
+// RestoreUser undoes the deletion of the user in the :id path parameter
+func RestoreUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		admin := middleware.CurrentUser(c)
+		user, err := authService.RestoreUser(c.Request.Context(), admin.UserID, userID, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"user": user})
+	}
+}
+
+// PurgeUser permanently deletes the deleted user in the :id path parameter
+func PurgeUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := userIDParam(c)
+		if !ok {
+			return
+		}
+
+		admin := middleware.CurrentUser(c)
+		if err := authService.PurgeUser(c.Request.Context(), admin.UserID, userID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Helper function to read the user ID from the :id path parameter
+func userIDParam(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
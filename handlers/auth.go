@@ -0,0 +1,335 @@
+// handlers/auth.go
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+type registerRequest struct {
+	Username  string `json:"username" binding:"required"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type loginRequest struct {
+	Login      string `json:"login" binding:"required"` // username or email
+	Password   string `json:"password" binding:"required"`
+	RememberMe bool   `json:"remember_me"`
+	Transport  string `json:"transport" binding:"omitempty,oneof=cookie bearer"` // defaults to cookie
+}
+
+type completeLoginChallengeRequest struct {
+	ChallengeID string `json:"challenge_id" binding:"required"`
+	Secret      string `json:"challenge_secret" binding:"required"`
+	Code        string `json:"code"`                                              // MFA code, for MFA challenges
+	Transport   string `json:"transport" binding:"omitempty,oneof=cookie bearer"` // defaults to cookie
+}
+
+type tokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type validatePasswordRequest struct {
+	Password string `json:"password"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// Register creates a new user account
+func Register(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req registerRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user, err := authService.Register(c.Request.Context(), req.Username, req.Email, req.Password, req.FirstName, req.LastName)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"user": user})
+	}
+}
+
+// Login authenticates a user and starts a new session.
+// Browsers get the token in an HttpOnly cookie; clients that ask for the "bearer"
+// transport get it in the response body instead.
+func Login(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		result, err := authService.Login(c.Request.Context(), services.LoginRequest{
+			UsernameOrEmail: req.Login,
+			Password:        req.Password,
+			IPAddress:       c.ClientIP(),
+			UserAgent:       c.Request.UserAgent(),
+			RememberMe:      req.RememberMe,
+			DeviceToken:     cookie.DeviceToken(c),
+		})
+		var challengeErr *services.LoginChallengeError
+		if errors.As(err, &challengeErr) {
+			respondLoginChallenge(c, cookie, req.Transport, challengeErr)
+			return
+		}
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		respondLogin(c, cookie, req.Transport, result)
+	}
+}
+
+// ApproveLogin confirms a risky login using the token from the confirmation email.
+// The client that attempted the login then completes it to get its session.
+func ApproveLogin(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req tokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		if err := authService.ApproveLoginChallenge(c.Request.Context(), req.Token, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Sign-in confirmed"})
+	}
+}
+
+// CompleteLoginChallenge starts the session for a risky login once it has been
+// confirmed by email or with an MFA code
+func CompleteLoginChallenge(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req completeLoginChallengeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		challengeID, err := uuid.Parse(req.ChallengeID)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidToken, "Invalid or expired token")
+			return
+		}
+
+		result, err := authService.CompleteLoginChallenge(c.Request.Context(), services.CompleteLoginChallengeRequest{
+			ChallengeID: challengeID,
+			Secret:      req.Secret,
+			Code:        req.Code,
+			IPAddress:   c.ClientIP(),
+			UserAgent:   c.Request.UserAgent(),
+		})
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		respondLogin(c, cookie, req.Transport, result)
+	}
+}
+
+// Helper function to hand a new session to the client over the requested transport
+func respondLogin(c *gin.Context, cookie *middleware.SessionCookie, transport string, result *services.LoginResult) {
+	if transport == "bearer" {
+		c.JSON(http.StatusOK, gin.H{
+			"session":      result.Session,
+			"device_token": result.DeviceToken,
+			"new_device":   result.NewDevice,
+		})
+		return
+	}
+
+	// Keep the tokens out of reach of scripts
+	cookie.Set(c, result.Session)
+	if result.DeviceToken != "" {
+		cookie.SetDeviceToken(c, result.DeviceToken)
+	}
+	response := *result.Session
+	response.Token = ""
+	c.JSON(http.StatusOK, gin.H{"session": response, "new_device": result.NewDevice})
+}
+
+// Helper function to tell the client a login must be confirmed before it gets a session
+func respondLoginChallenge(c *gin.Context, cookie *middleware.SessionCookie, transport string, challenge *services.LoginChallengeError) {
+	response := gin.H{
+		"error":            "Sign-in must be confirmed",
+		"code":             CodeLoginChallengeRequired,
+		"challenge_id":     challenge.ChallengeID,
+		"challenge_secret": challenge.Secret,
+		"method":           challenge.Method,
+		"expires_at":       challenge.ExpiresAt,
+		"new_device":       challenge.NewDevice,
+	}
+	if transport == "bearer" {
+		response["device_token"] = challenge.DeviceToken
+	} else {
+		cookie.SetDeviceToken(c, challenge.DeviceToken)
+	}
+	c.JSON(http.StatusAccepted, response)
+}
+
+// Logout invalidates the current session and clears the session cookie. For sessions from
+// a SAML sign-in it returns the identity provider URL that finishes single logout. Signing
+// out of an impersonation session returns the browser to the admin's own session.
+func Logout(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := middleware.CurrentSession(c)
+
+		// Signing out of an impersonation session ends the impersonation
+		if session.ImpersonatorID != nil {
+			endImpersonation(c, authService, cookie, session, services.ImpersonationEndedLogout)
+			return
+		}
+
+		// Sessions from a SAML sign-in also end the identity provider session. Failing to
+		// reach the identity provider must not stop the user signing out here.
+		location, err := authService.SAMLLogoutRedirect(c.Request.Context(), session.SessionID, c.Query("return_to"))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Error starting SAML single logout", "error", err)
+		}
+
+		if err := authService.Logout(c.Request.Context(), session.Token); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		cookie.Clear(c)
+		if location != "" {
+			c.JSON(http.StatusOK, gin.H{"redirect_to": location})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// VerifyEmail marks an email address as verified using the emailed token
+func VerifyEmail(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req tokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		if err := authService.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ForgotPassword starts the password reset process.
+// It always responds the same way so it cannot be used to discover registered emails.
+func ForgotPassword(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		if _, err := authService.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// ResetPassword sets a new password using a password reset token
+func ResetPassword(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		if err := authService.ResetPassword(c.Request.Context(), req.Token, req.NewPassword); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ChangePassword changes the authenticated user's password
+func ChangePassword(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req changePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.ChangePassword(c.Request.Context(), user.UserID, req.CurrentPassword, req.NewPassword); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ValidatePassword checks a candidate password against the policy without saving it,
+// so the frontend can show violations while the user types
+func ValidatePassword(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req validatePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := &models.User{Username: req.Username, Email: req.Email}
+		if err := authService.ValidatePassword(c.Request.Context(), req.Password, user); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"valid":    true,
+			"strength": services.EstimatePasswordStrength(req.Password, user.Username, user.Email),
+		})
+	}
+}
@@ -0,0 +1,26 @@
+// handlers/csrf.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/middleware"
+)
+
+// CSRFToken issues a CSRF token for the current session.
+// The frontend should call it on load and again after logging in, then send the
+// token back in the X-CSRF-Token header on every state-changing request.
+func CSRFToken(csrf *middleware.CSRFProtection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, err := csrf.IssueToken(c)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, gin.H{"csrf_token": token, "header_name": middleware.CSRFHeaderName})
+	}
+}
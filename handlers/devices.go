@@ -0,0 +1,70 @@
+// handlers/devices.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// ListDevices lists the devices the authenticated user has signed in from
+func ListDevices(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		devices, err := authService.ListDevices(c.Request.Context(), user.UserID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		// Flag the device making this request so the frontend can label it
+		var currentDeviceID *uuid.UUID
+		if session := middleware.CurrentSession(c); session != nil {
+			currentDeviceID = session.DeviceID
+		}
+
+		c.JSON(http.StatusOK, gin.H{"devices": devices, "current_device_id": currentDeviceID})
+	}
+}
+
+// TrustDevice marks the device in the :id path parameter as the user's own
+func TrustDevice(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deviceID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid device ID")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.TrustDevice(c.Request.Context(), user.UserID, deviceID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ReportDevice reports the device in the :id path parameter as not the user's and signs it out
+func ReportDevice(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deviceID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid device ID")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.ReportDevice(c.Request.Context(), user.UserID, deviceID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
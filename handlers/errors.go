@@ -0,0 +1,151 @@
+// handlers/errors.go
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Error codes returned alongside error messages so the frontend can react to them
+const (
+	CodeInvalidRequest          = "invalid_request"
+	CodeInvalidCredentials      = "invalid_credentials"
+	CodeAccountLocked           = "account_locked"
+	CodeEmailAlreadyExists      = "email_already_exists"
+	CodeUsernameAlreadyExists   = "username_already_exists"
+	CodeInvalidUsername         = "invalid_username"
+	CodeUserNotFound            = "user_not_found"
+	CodeInvalidToken            = "invalid_token"
+	CodeDeviceNotFound          = "device_not_found"
+	CodePasswordPolicyViolation = "password_policy_violation"
+	CodeLoginChallengeRequired  = "login_challenge_required"
+	CodeLoginChallengePending   = "login_challenge_pending"
+	CodeLoginBlocked            = "login_blocked"
+	CodeInvalidMFACode          = "invalid_mfa_code"
+	CodeSessionLimitReached     = "session_limit_reached"
+	CodeAccessTokenNotFound     = "access_token_not_found"
+	CodeOAuthClientNotFound     = "oauth_client_not_found"
+	CodeInvalidRedirectURI      = "invalid_redirect_uri"
+	CodeOIDCDisabled            = "oidc_disabled"
+	CodeProviderNotFound        = "provider_not_found"
+	CodeDirectoryUnavailable    = "directory_unavailable"
+	CodePasswordManaged         = "password_managed_externally"
+	CodeOrganizationNotFound    = "organization_not_found"
+	CodeOrganizationSlugTaken   = "organization_slug_taken"
+	CodeOrganizationForbidden   = "organization_forbidden"
+	CodeLastOrganizationOwner   = "last_organization_owner"
+	CodeMemberNotFound          = "member_not_found"
+	CodeAlreadyMember           = "already_member"
+	CodeInvitationNotFound      = "invitation_not_found"
+	CodeInvitationEmailMismatch = "invitation_email_mismatch"
+	CodeImpersonationNotAllowed = "impersonation_not_allowed"
+	CodeImpersonationRestricted = "impersonation_restricted"
+	CodeNotImpersonating        = "not_impersonating"
+	CodeDeletionPending         = "account_deletion_pending"
+	CodeDeletionNotPending      = "account_deletion_not_pending"
+	CodeUserNotDeleted          = "user_not_deleted"
+	CodeUserIdentifiersReleased = "user_identifiers_released"
+	CodeCannotDeleteSelf        = "cannot_delete_self"
+	CodeInternalError           = "internal_error"
+)
+
+// Helper function to write an error response with a code
+func respondError(c *gin.Context, status int, code, message string) {
+	c.JSON(status, gin.H{"error": message, "code": code})
+}
+
+// Helper function to map service errors onto HTTP responses
+func respondServiceError(c *gin.Context, err error) {
+	var policyErr *services.PasswordPolicyError
+	switch {
+	case errors.As(err, &policyErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      "Password does not meet the password policy",
+			"code":       CodePasswordPolicyViolation,
+			"violations": policyErr.Violations,
+		})
+	case errors.Is(err, services.ErrInvalidCredentials):
+		respondError(c, http.StatusUnauthorized, CodeInvalidCredentials, "Invalid username or password")
+	case errors.Is(err, services.ErrUserLocked):
+		respondError(c, http.StatusForbidden, CodeAccountLocked, "Account is temporarily locked")
+	case errors.Is(err, services.ErrDirectoryUnavailable):
+		respondError(c, http.StatusServiceUnavailable, CodeDirectoryUnavailable, "Directory service is unavailable, try again later")
+	case errors.Is(err, services.ErrPasswordManagedExternally):
+		respondError(c, http.StatusConflict, CodePasswordManaged, "Password is managed by your organization's directory")
+	case errors.Is(err, services.ErrLoginBlocked):
+		respondError(c, http.StatusForbidden, CodeLoginBlocked, "Sign-in blocked because it looks unusual")
+	case errors.Is(err, services.ErrLoginChallengePending):
+		respondError(c, http.StatusConflict, CodeLoginChallengePending, "Sign-in has not been confirmed yet")
+	case errors.Is(err, services.ErrInvalidMFACode):
+		respondError(c, http.StatusUnauthorized, CodeInvalidMFACode, "Invalid MFA code")
+	case errors.Is(err, services.ErrSessionLimitReached):
+		respondError(c, http.StatusConflict, CodeSessionLimitReached, "Too many active sessions; sign out on another device first")
+	case errors.Is(err, services.ErrEmailAlreadyExists):
+		respondError(c, http.StatusConflict, CodeEmailAlreadyExists, "Email already exists")
+	case errors.Is(err, services.ErrConfusableUsername):
+		respondError(c, http.StatusBadRequest, CodeInvalidUsername, "Username contains invisible characters or mixes letters from different scripts")
+	case errors.Is(err, services.ErrUsernameAlreadyExists):
+		respondError(c, http.StatusConflict, CodeUsernameAlreadyExists, "Username already exists")
+	case errors.Is(err, services.ErrUserNotFound):
+		respondError(c, http.StatusNotFound, CodeUserNotFound, "User not found")
+	case errors.Is(err, services.ErrDeviceNotFound):
+		respondError(c, http.StatusNotFound, CodeDeviceNotFound, "Device not found")
+	case errors.Is(err, services.ErrInvalidScope), errors.Is(err, services.ErrInvalidAllowedIP),
+		errors.Is(err, services.ErrInvalidTokenExpiry):
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case errors.Is(err, services.ErrAccessTokenUnknown):
+		respondError(c, http.StatusNotFound, CodeAccessTokenNotFound, "Access token not found")
+	case errors.Is(err, services.ErrOAuthClientNotFound):
+		respondError(c, http.StatusNotFound, CodeOAuthClientNotFound, "OAuth client not found")
+	case errors.Is(err, services.ErrInvalidRedirectURI):
+		respondError(c, http.StatusBadRequest, CodeInvalidRedirectURI, err.Error())
+	case errors.Is(err, services.ErrOIDCDisabled):
+		respondError(c, http.StatusNotFound, CodeOIDCDisabled, "OpenID Connect is not enabled")
+	case errors.Is(err, services.ErrExternalProviderNotFound):
+		respondError(c, http.StatusNotFound, CodeProviderNotFound, "Sign-in provider not found")
+	case errors.Is(err, services.ErrOrganizationNotFound):
+		respondError(c, http.StatusNotFound, CodeOrganizationNotFound, "Organization not found")
+	case errors.Is(err, services.ErrOrganizationSlugTaken):
+		respondError(c, http.StatusConflict, CodeOrganizationSlugTaken, "An organization with this slug already exists")
+	case errors.Is(err, services.ErrInvalidOrganization), errors.Is(err, services.ErrInvalidOrganizationRole):
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	case errors.Is(err, services.ErrOrganizationForbidden):
+		respondError(c, http.StatusForbidden, CodeOrganizationForbidden, "Your role in the organization does not allow this")
+	case errors.Is(err, services.ErrLastOrganizationOwner):
+		respondError(c, http.StatusConflict, CodeLastOrganizationOwner, "The organization must keep at least one owner")
+	case errors.Is(err, services.ErrOrganizationMemberNotFound):
+		respondError(c, http.StatusNotFound, CodeMemberNotFound, "Member not found")
+	case errors.Is(err, services.ErrAlreadyOrganizationMember):
+		respondError(c, http.StatusConflict, CodeAlreadyMember, "User is already a member of the organization")
+	case errors.Is(err, services.ErrInvitationNotFound):
+		respondError(c, http.StatusNotFound, CodeInvitationNotFound, "Invitation not found, already used or expired")
+	case errors.Is(err, services.ErrInvitationEmailMismatch):
+		respondError(c, http.StatusForbidden, CodeInvitationEmailMismatch, "This invitation was sent to a different email address")
+	case errors.Is(err, services.ErrImpersonationNotAllowed):
+		respondError(c, http.StatusForbidden, CodeImpersonationNotAllowed, "This user cannot be impersonated")
+	case errors.Is(err, services.ErrImpersonationRestricted):
+		respondError(c, http.StatusForbidden, CodeImpersonationRestricted, "This action is not allowed while impersonating a user")
+	case errors.Is(err, services.ErrNotImpersonating):
+		respondError(c, http.StatusConflict, CodeNotImpersonating, "This session is not impersonating a user")
+	case errors.Is(err, services.ErrAccountDeletionPending):
+		respondError(c, http.StatusConflict, CodeDeletionPending, "Account deletion has already been requested")
+	case errors.Is(err, services.ErrAccountDeletionNotPending):
+		respondError(c, http.StatusNotFound, CodeDeletionNotPending, "No account deletion has been requested")
+	case errors.Is(err, services.ErrUserNotDeleted):
+		respondError(c, http.StatusConflict, CodeUserNotDeleted, "User has not been deleted")
+	case errors.Is(err, services.ErrUserIdentifiersReleased):
+		respondError(c, http.StatusConflict, CodeUserIdentifiersReleased, "User can no longer be restored; their email and username have been released")
+	case errors.Is(err, services.ErrCannotDeleteSelf):
+		respondError(c, http.StatusConflict, CodeCannotDeleteSelf, "Admins cannot delete their own account this way")
+	case errors.Is(err, services.ErrInvalidToken):
+		respondError(c, http.StatusBadRequest, CodeInvalidToken, "Invalid or expired token")
+	default:
+		slog.ErrorContext(c.Request.Context(), "Unhandled error", "method", c.Request.Method, "route", c.FullPath(), "error", err)
+		respondError(c, http.StatusInternalServerError, CodeInternalError, "Internal server error")
+	}
+}
@@ -0,0 +1,121 @@
+// handlers/external_login.go
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Frontend pages external sign-ins end on when they cannot go straight to return_to
+const (
+	externalLoginErrorPath     = "/login"
+	externalLoginChallengePath = "/login/challenge"
+)
+
+// ListExternalProviders lists the external identity providers users can sign in with
+func ListExternalProviders(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"providers": authService.ExternalProviders()})
+	}
+}
+
+// StartExternalLogin sends the browser to the external provider in the :provider path parameter
+func StartExternalLogin(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		location, state, err := authService.StartExternalLogin(c.Request.Context(), c.Param("provider"), c.Query("return_to"), c.Query("remember_me") == "true")
+		if err != nil {
+			if errors.Is(err, services.ErrExternalProviderNotFound) {
+				respondServiceError(c, err)
+				return
+			}
+			slog.ErrorContext(c.Request.Context(), "Error starting external sign-in", "provider", c.Param("provider"), "error", err)
+			redirectExternalLoginError(c, "provider_unavailable")
+			return
+		}
+
+		cookie.SetExternalState(c, state, services.ExternalLoginTTL)
+		c.Redirect(http.StatusFound, location)
+	}
+}
+
+// ExternalLoginCallback finishes a sign-in when the external provider sends the browser back.
+// The session is set as a cookie and the browser goes on to where the sign-in started.
+func ExternalLoginCallback(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, returnTo, err := authService.CompleteExternalLogin(c.Request.Context(), services.ExternalLoginCallback{
+			Provider:      c.Param("provider"),
+			State:         c.Query("state"),
+			ExpectedState: cookie.ExternalState(c),
+			Code:          c.Query("code"),
+			Error:         c.Query("error"),
+			IPAddress:     c.ClientIP(),
+			UserAgent:     c.Request.UserAgent(),
+			DeviceToken:   cookie.DeviceToken(c),
+		})
+
+		finishExternalLogin(c, cookie, result, returnTo, err, c.Param("provider"))
+	}
+}
+
+// ListExternalIdentities lists the external identities linked to the authenticated user
+func ListExternalIdentities(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		identities, err := authService.ListExternalIdentities(c.Request.Context(), user.UserID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"identities": identities})
+	}
+}
+
+// Helper function to end a sign-in through an external provider: set the session cookie and
+// go on to returnTo, go to the challenge page, or go back to the login page with an error code
+func finishExternalLogin(c *gin.Context, cookie *middleware.SessionCookie, result *services.LoginResult, returnTo string, err error, provider string) {
+	var challengeErr *services.LoginChallengeError
+	switch {
+	case errors.As(err, &challengeErr):
+		// The secret goes in the fragment so it is not sent to servers or written to logs
+		cookie.SetDeviceToken(c, challengeErr.DeviceToken)
+		query := url.Values{
+			"challenge_id": {challengeErr.ChallengeID.String()},
+			"method":       {challengeErr.Method},
+			"return_to":    {returnTo},
+		}
+		fragment := url.Values{"challenge_secret": {challengeErr.Secret}}
+		c.Redirect(http.StatusFound, externalLoginChallengePath+"?"+query.Encode()+"#"+fragment.Encode())
+	case errors.Is(err, services.ErrExternalEmailConflict), errors.Is(err, services.ErrEmailAlreadyExists):
+		redirectExternalLoginError(c, "email_conflict")
+	case errors.Is(err, services.ErrExternalAccountNotFound):
+		redirectExternalLoginError(c, "account_not_found")
+	case errors.Is(err, services.ErrUserLocked):
+		redirectExternalLoginError(c, CodeAccountLocked)
+	case errors.Is(err, services.ErrLoginBlocked):
+		redirectExternalLoginError(c, CodeLoginBlocked)
+	case errors.Is(err, services.ErrSessionLimitReached):
+		redirectExternalLoginError(c, CodeSessionLimitReached)
+	case err != nil:
+		slog.WarnContext(c.Request.Context(), "External sign-in failed", "provider", provider, "error", err)
+		redirectExternalLoginError(c, "external_login_failed")
+	default:
+		cookie.Set(c, result.Session)
+		if result.DeviceToken != "" {
+			cookie.SetDeviceToken(c, result.DeviceToken)
+		}
+		c.Redirect(http.StatusFound, returnTo)
+	}
+}
+
+// Helper function to send the browser back to the login page with an error code
+func redirectExternalLoginError(c *gin.Context, code string) {
+	c.Redirect(http.StatusFound, externalLoginErrorPath+"?"+url.Values{"error": {code}}.Encode())
+}
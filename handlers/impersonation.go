@@ -0,0 +1,88 @@
+// handlers/impersonation.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+type startImpersonationRequest struct {
+	Reason    string `json:"reason" binding:"required,max=500"`                 // Why support needs to act as the user, for the audit log
+	Transport string `json:"transport" binding:"omitempty,oneof=cookie bearer"` // defaults to cookie
+}
+
+// StartImpersonation starts a session acting as the user in the :id path parameter. Browsers
+// get it in the session cookie in place of the admin's own session, which EndImpersonation
+// restores; clients that ask for the "bearer" transport get the token in the response body.
+func StartImpersonation(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+			return
+		}
+
+		var req startImpersonationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		session, user, err := authService.StartImpersonation(c.Request.Context(), middleware.CurrentSession(c), userID, req.Reason, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		if req.Transport == "bearer" {
+			c.Header("Cache-Control", "no-store")
+			c.JSON(http.StatusCreated, gin.H{"session": session, "user": user})
+			return
+		}
+
+		cookie.Set(c, session)
+		response := *session
+		response.Token = ""
+		c.JSON(http.StatusCreated, gin.H{"session": response, "user": user})
+	}
+}
+
+// EndImpersonation ends the current impersonation session. Browsers are put back in the
+// admin's own session if it is still valid.
+func EndImpersonation(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		endImpersonation(c, authService, cookie, middleware.CurrentSession(c), services.ImpersonationEndedByAdmin)
+	}
+}
+
+// Helper function to end an impersonation session and hand a browser back its admin session
+func endImpersonation(c *gin.Context, authService *services.AuthService, cookie *middleware.SessionCookie, session *models.Session, reason string) {
+	adminSession, err := authService.EndImpersonation(c.Request.Context(), session, reason, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		respondServiceError(c, err)
+		return
+	}
+
+	// Only a browser that was using the impersonation session through the cookie gets its
+	// session back; bearer clients still hold the admin's token themselves
+	if cookie.Token(c) != session.Token {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if adminSession == nil {
+		cookie.Clear(c)
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	cookie.Set(c, adminSession)
+	response := *adminSession
+	response.Token = ""
+	c.JSON(http.StatusOK, gin.H{"session": response})
+}
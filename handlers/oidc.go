@@ -0,0 +1,310 @@
+// handlers/oidc.go
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// OIDCDiscovery serves the OpenID Connect discovery document
+func OIDCDiscovery(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		document, err := authService.OIDCDiscovery()
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, document)
+	}
+}
+
+// OIDCKeys serves the public keys that verify ID tokens
+func OIDCKeys(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys, err := authService.OIDCKeys()
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, keys)
+	}
+}
+
+// OAuthAuthorize starts the authorization code flow. Users who are not signed in are sent
+// to the login page and come back here afterwards.
+func OAuthAuthorize(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := services.AuthorizeRequest{
+			ClientID:            c.Query("client_id"),
+			RedirectURI:         c.Query("redirect_uri"),
+			ResponseType:        c.Query("response_type"),
+			Scope:               c.Query("scope"),
+			State:               c.Query("state"),
+			Nonce:               c.Query("nonce"),
+			CodeChallenge:       c.Query("code_challenge"),
+			CodeChallengeMethod: c.Query("code_challenge_method"),
+			Prompt:              c.Query("prompt"),
+		}
+
+		// The redirect URI is not trusted until it matches the client, so these errors are shown here
+		client, err := authService.CheckAuthorizeRequest(c.Request.Context(), req)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		token := cookie.Token(c)
+		if token == "" {
+			c.Redirect(http.StatusFound, authService.LoginRedirect(req, c.Request.URL.RequestURI()))
+			return
+		}
+		session, user, err := authService.ValidateSession(c.Request.Context(), token)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrUserNotFound) {
+				cookie.Clear(c)
+				c.Redirect(http.StatusFound, authService.LoginRedirect(req, c.Request.URL.RequestURI()))
+				return
+			}
+			respondServiceError(c, err)
+			return
+		}
+
+		location, err := authService.Authorize(c.Request.Context(), client, user, session, req)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Redirect(http.StatusFound, location)
+	}
+}
+
+// OAuthToken exchanges an authorization code or refresh token for tokens. Clients
+// authenticate with HTTP Basic or with client_id and client_secret form fields.
+func OAuthToken(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret := oauthClientCredentials(c)
+		response, err := authService.ExchangeToken(c.Request.Context(), services.TokenRequest{
+			GrantType:    c.PostForm("grant_type"),
+			Code:         c.PostForm("code"),
+			RedirectURI:  c.PostForm("redirect_uri"),
+			CodeVerifier: c.PostForm("code_verifier"),
+			RefreshToken: c.PostForm("refresh_token"),
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+		})
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// OAuthUserInfo returns claims about the user an OAuth access token was issued for
+func OAuthUserInfo(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, err := authService.UserInfo(c.Request.Context(), middleware.BearerToken(c))
+		if err != nil {
+			var oauthErr *services.OAuthError
+			if errors.As(err, &oauthErr) {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				c.JSON(http.StatusUnauthorized, oauthErr)
+				return
+			}
+			respondOAuthError(c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, claims)
+	}
+}
+
+// OAuthIntrospect describes a token to an authenticated confidential client
+func OAuthIntrospect(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret := oauthClientCredentials(c)
+		result, err := authService.IntrospectToken(c.Request.Context(), clientID, clientSecret, c.PostForm("token"))
+		if err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// OAuthRevoke revokes a token issued to the calling client
+func OAuthRevoke(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientID, clientSecret := oauthClientCredentials(c)
+		if err := authService.RevokeOAuthToken(c.Request.Context(), clientID, clientSecret, c.PostForm("token")); err != nil {
+			respondOAuthError(c, err)
+			return
+		}
+
+		c.Status(http.StatusOK)
+	}
+}
+
+// GetOAuthConsent returns what the client in a pending authorization is asking for,
+// for the consent screen
+func GetOAuthConsent(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorizationID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid authorization ID")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		consent, err := authService.GetConsentRequest(c.Request.Context(), user.UserID, authorizationID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"consent": consent})
+	}
+}
+
+type decideOAuthConsentRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// DecideOAuthConsent records the user's answer on the consent screen. The response holds
+// the URL the frontend should send the browser to.
+func DecideOAuthConsent(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorizationID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid authorization ID")
+			return
+		}
+
+		var req decideOAuthConsentRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		location, err := authService.DecideConsent(c.Request.Context(), user.UserID, authorizationID, req.Approve, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"redirect_to": location})
+	}
+}
+
+type registerOAuthClientRequest struct {
+	Name           string   `json:"name" binding:"required,max=100"`
+	RedirectURIs   []string `json:"redirect_uris" binding:"required,min=1"`
+	Scopes         []string `json:"scopes"`
+	Confidential   bool     `json:"confidential"`
+	RequireConsent *bool    `json:"require_consent"`
+}
+
+// RegisterOAuthClient registers a relying party. A confidential client's secret is only
+// ever shown in this response.
+func RegisterOAuthClient(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req registerOAuthClientRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		// Ask for consent unless the admin explicitly marks the client as trusted
+		requireConsent := req.RequireConsent == nil || *req.RequireConsent
+
+		admin := middleware.CurrentUser(c)
+		client, secret, err := authService.RegisterOAuthClient(c.Request.Context(), admin.UserID, services.RegisterOAuthClientRequest{
+			Name:           req.Name,
+			RedirectURIs:   req.RedirectURIs,
+			Scopes:         req.Scopes,
+			Confidential:   req.Confidential,
+			RequireConsent: requireConsent,
+		}, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		response := gin.H{"client": client}
+		if secret != "" {
+			response["client_secret"] = secret
+		}
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// ListOAuthClients lists every registered relying party
+func ListOAuthClients(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients, err := authService.ListOAuthClients(c.Request.Context())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"clients": clients})
+	}
+}
+
+// DeleteOAuthClient removes the relying party in the :id path parameter
+func DeleteOAuthClient(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin := middleware.CurrentUser(c)
+		if err := authService.DeleteOAuthClient(c.Request.Context(), admin.UserID, c.Param("id"), c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Helper function to read client credentials from HTTP Basic auth or the form
+func oauthClientCredentials(c *gin.Context) (string, string) {
+	if clientID, clientSecret, ok := c.Request.BasicAuth(); ok {
+		return clientID, clientSecret
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret")
+}
+
+// Helper function to write an error in the RFC 6749 format relying parties expect
+func respondOAuthError(c *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	switch {
+	case errors.As(err, &oauthErr):
+		status := http.StatusBadRequest
+		if oauthErr.Code == services.OAuthErrInvalidClient {
+			status = http.StatusUnauthorized
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		c.JSON(status, oauthErr)
+	case errors.Is(err, services.ErrOIDCDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found", "error_description": err.Error()})
+	default:
+		slog.ErrorContext(c.Request.Context(), "Unhandled error", "method", c.Request.Method, "route", c.FullPath(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+	}
+}
@@ -0,0 +1,375 @@
+// handlers/organizations.go
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+type createOrganizationRequest struct {
+	Name string `json:"name" binding:"required"`
+	Slug string `json:"slug"` // made from the name when empty
+}
+
+type updateOrganizationRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type updateOrganizationMemberRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type inviteToOrganizationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required"`
+}
+
+type registerWithInvitationRequest struct {
+	Token     string `json:"token" binding:"required"`
+	Username  string `json:"username" binding:"required"`
+	Password  string `json:"password" binding:"required"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// ListOrganizations lists the organizations the authenticated user belongs to
+func ListOrganizations(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := middleware.CurrentUser(c)
+		orgs, err := authService.ListOrganizations(c.Request.Context(), user.UserID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		// Flag the organization this session is working in so the frontend can label it
+		var activeOrgID *uuid.UUID
+		if session := middleware.CurrentSession(c); session != nil {
+			activeOrgID = session.ActiveOrgID
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organizations": orgs, "active_org_id": activeOrgID})
+	}
+}
+
+// CreateOrganization creates an organization owned by the authenticated user
+func CreateOrganization(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		org, err := authService.CreateOrganization(c.Request.Context(), user.UserID, req.Name, req.Slug, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"organization": org})
+	}
+}
+
+// GetOrganization returns the organization in the :id path parameter and the user's role in it
+func GetOrganization(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		org, member, err := authService.GetOrganization(c.Request.Context(), user.UserID, orgID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organization": org, "role": member.Role})
+	}
+}
+
+// GetActiveOrganization returns the organization the request is working in and the user's role in it
+func GetActiveOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"organization": middleware.CurrentOrganization(c),
+			"role":         middleware.CurrentOrganizationMember(c).Role,
+		})
+	}
+}
+
+// UpdateOrganization renames the organization in the :id path parameter
+func UpdateOrganization(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req updateOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		org, err := authService.UpdateOrganization(c.Request.Context(), user.UserID, orgID, req.Name, req.Slug, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organization": org})
+	}
+}
+
+// DeleteOrganization deletes the organization in the :id path parameter
+func DeleteOrganization(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.DeleteOrganization(c.Request.Context(), user.UserID, orgID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// SwitchOrganization makes the organization in the :id path parameter the session's active one
+func SwitchOrganization(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		org, err := authService.SwitchOrganization(c.Request.Context(), middleware.CurrentSession(c), orgID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organization": org})
+	}
+}
+
+// ListOrganizationMembers lists the members of the organization in the :id path parameter
+func ListOrganizationMembers(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		members, err := authService.ListOrganizationMembers(c.Request.Context(), user.UserID, orgID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"members": members})
+	}
+}
+
+// UpdateOrganizationMember changes the role of the member in the :user_id path parameter
+func UpdateOrganizationMember(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+		userID, err := uuid.Parse(c.Param("user_id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+			return
+		}
+
+		var req updateOrganizationMemberRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.UpdateOrganizationMemberRole(c.Request.Context(), user.UserID, orgID, userID, req.Role, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RemoveOrganizationMember removes the member in the :user_id path parameter, who may be the user themselves
+func RemoveOrganizationMember(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+		userID, err := uuid.Parse(c.Param("user_id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid user ID")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.RemoveOrganizationMember(c.Request.Context(), user.UserID, orgID, userID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ListOrganizationInvitations lists the pending invitations of the organization in the :id path parameter
+func ListOrganizationInvitations(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		invitations, err := authService.ListOrganizationInvitations(c.Request.Context(), user.UserID, orgID)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+	}
+}
+
+// InviteToOrganization emails an invitation to join the organization in the :id path parameter
+func InviteToOrganization(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+
+		var req inviteToOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		invitation, err := authService.InviteToOrganization(c.Request.Context(), user.UserID, orgID, req.Email, req.Role, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"invitation": invitation})
+	}
+}
+
+// RevokeOrganizationInvitation withdraws the invitation in the :invitation_id path parameter
+func RevokeOrganizationInvitation(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orgID, ok := organizationIDParam(c)
+		if !ok {
+			return
+		}
+		invitationID, err := uuid.Parse(c.Param("invitation_id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid invitation ID")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		if err := authService.RevokeOrganizationInvitation(c.Request.Context(), user.UserID, orgID, invitationID, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// PreviewOrganizationInvitation describes the invitation a token is for
+func PreviewOrganizationInvitation(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req tokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		preview, err := authService.PreviewOrganizationInvitation(c.Request.Context(), req.Token)
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invitation": preview})
+	}
+}
+
+// AcceptOrganizationInvitation adds the authenticated user to the organization they were invited to
+func AcceptOrganizationInvitation(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req tokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user := middleware.CurrentUser(c)
+		org, err := authService.AcceptOrganizationInvitation(c.Request.Context(), user.UserID, req.Token, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"organization": org})
+	}
+}
+
+// RegisterWithInvitation creates an account for an invited email address and adds it to the organization
+func RegisterWithInvitation(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req registerWithInvitationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid request body")
+			return
+		}
+
+		user, org, err := authService.RegisterWithInvitation(c.Request.Context(), req.Token, req.Username, req.Password, req.FirstName, req.LastName, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"user": user, "organization": org})
+	}
+}
+
+// Helper function to read the organization ID from the :id path parameter
+func organizationIDParam(c *gin.Context) (uuid.UUID, bool) {
+	orgID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid organization ID")
+		return uuid.Nil, false
+	}
+	return orgID, true
+}
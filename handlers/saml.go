@@ -0,0 +1,105 @@
+// handlers/saml.go
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// SAMLMetadata serves our service provider metadata for the SAML provider in the :provider path parameter
+func SAMLMetadata(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		metadata, err := authService.SAMLMetadata(c.Param("provider"))
+		if err != nil {
+			respondServiceError(c, err)
+			return
+		}
+
+		c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+	}
+}
+
+// StartSAMLLogin sends the browser to the SAML identity provider in the :provider path parameter
+func StartSAMLLogin(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		location, state, err := authService.StartSAMLLogin(c.Request.Context(), c.Param("provider"), c.Query("return_to"), c.Query("remember_me") == "true")
+		if err != nil {
+			if errors.Is(err, services.ErrExternalProviderNotFound) {
+				respondServiceError(c, err)
+				return
+			}
+			slog.ErrorContext(c.Request.Context(), "Error starting SAML sign-in", "provider", c.Param("provider"), "error", err)
+			redirectExternalLoginError(c, "provider_unavailable")
+			return
+		}
+
+		cookie.SetSAMLState(c, state, services.ExternalLoginTTL)
+		c.Redirect(http.StatusFound, location)
+	}
+}
+
+// SAMLAssertionConsumer finishes a sign-in when the identity provider posts its response,
+// whether we or the identity provider started it
+func SAMLAssertionConsumer(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := c.Request.ParseForm(); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid SAML response")
+			return
+		}
+
+		result, returnTo, err := authService.CompleteSAMLLogin(c.Request.Context(), services.SAMLMessage{
+			Provider:      c.Param("provider"),
+			Form:          c.Request.PostForm,
+			ExpectedState: cookie.SAMLState(c),
+			IPAddress:     c.ClientIP(),
+			UserAgent:     c.Request.UserAgent(),
+			DeviceToken:   cookie.DeviceToken(c),
+		})
+		if errors.Is(err, services.ErrExternalProviderNotFound) {
+			respondServiceError(c, err)
+			return
+		}
+
+		finishExternalLogin(c, cookie, result, returnTo, err, c.Param("provider"))
+	}
+}
+
+// SAMLSingleLogout handles logout requests from the identity provider, which end the
+// matching sessions, and its responses to our own logout requests
+func SAMLSingleLogout(authService *services.AuthService, cookie *middleware.SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := c.Request.ParseForm(); err != nil {
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid SAML logout message")
+			return
+		}
+
+		location, err := authService.HandleSAMLLogout(c.Request.Context(), services.SAMLMessage{
+			Provider:  c.Param("provider"),
+			RawQuery:  c.Request.URL.RawQuery,
+			Form:      c.Request.Form,
+			IPAddress: c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		})
+		switch {
+		case errors.Is(err, services.ErrExternalProviderNotFound):
+			respondServiceError(c, err)
+			return
+		case err != nil:
+			slog.WarnContext(c.Request.Context(), "SAML single logout failed", "provider", c.Param("provider"), "error", err)
+			respondError(c, http.StatusBadRequest, CodeInvalidRequest, "Invalid SAML logout message")
+			return
+		}
+
+		cookie.Clear(c)
+		if location == "" {
+			location = "/"
+		}
+		c.Redirect(http.StatusFound, location)
+	}
+}
@@ -0,0 +1,368 @@
+// handlers/scim.go
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// SCIMServiceProviderConfig describes the features of the SCIM API
+func SCIMServiceProviderConfig(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config, err := authService.SCIMServiceProviderConfig()
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIM(c, http.StatusOK, config)
+	}
+}
+
+// SCIMResourceTypes lists the resource types of the SCIM API, or returns the one in the :id path parameter
+func SCIMResourceTypes(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resourceTypes, err := authService.SCIMResourceTypes()
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMDiscovery(c, resourceTypes)
+	}
+}
+
+// SCIMSchemas lists the schemas of the SCIM API, or returns the one in the :id path parameter
+func SCIMSchemas(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schemas, err := authService.SCIMSchemas()
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMDiscovery(c, schemas)
+	}
+}
+
+// ListSCIMUsers returns a page of users, optionally filtered
+func ListSCIMUsers(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, err := authService.ListSCIMUsers(c.Request.Context(), scimListRequest(c))
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIM(c, http.StatusOK, response)
+	}
+}
+
+// GetSCIMUser returns the user in the :id path parameter
+func GetSCIMUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := authService.GetSCIMUser(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMResource(c, http.StatusOK, user, user.Meta)
+	}
+}
+
+// CreateSCIMUser provisions a user
+func CreateSCIMUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input services.SCIMUser
+		if !bindSCIM(c, &input) {
+			return
+		}
+
+		user, err := authService.CreateSCIMUser(c.Request.Context(), middleware.CurrentUser(c).UserID, &input, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+
+		c.Header("Location", user.Meta.Location)
+		respondSCIMResource(c, http.StatusCreated, user, user.Meta)
+	}
+}
+
+// ReplaceSCIMUser overwrites the user in the :id path parameter
+func ReplaceSCIMUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input services.SCIMUser
+		if !bindSCIM(c, &input) {
+			return
+		}
+
+		user, err := authService.ReplaceSCIMUser(c.Request.Context(), middleware.CurrentUser(c).UserID, c.Param("id"), c.GetHeader("If-Match"), &input, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMResource(c, http.StatusOK, user, user.Meta)
+	}
+}
+
+// PatchSCIMUser updates the user in the :id path parameter
+func PatchSCIMUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var patch services.SCIMPatchRequest
+		if !bindSCIM(c, &patch) {
+			return
+		}
+
+		user, err := authService.PatchSCIMUser(c.Request.Context(), middleware.CurrentUser(c).UserID, c.Param("id"), c.GetHeader("If-Match"), &patch, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMResource(c, http.StatusOK, user, user.Meta)
+	}
+}
+
+// DeleteSCIMUser deletes the user in the :id path parameter
+func DeleteSCIMUser(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := authService.DeleteSCIMUser(c.Request.Context(), middleware.CurrentUser(c).UserID, c.Param("id"), c.GetHeader("If-Match"), c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// ListSCIMGroups returns a page of groups, optionally filtered
+func ListSCIMGroups(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, err := authService.ListSCIMGroups(c.Request.Context(), scimListRequest(c))
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIM(c, http.StatusOK, response)
+	}
+}
+
+// GetSCIMGroup returns the group in the :id path parameter
+func GetSCIMGroup(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		group, err := authService.GetSCIMGroup(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMResource(c, http.StatusOK, group, group.Meta)
+	}
+}
+
+// CreateSCIMGroup creates a group
+func CreateSCIMGroup(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input services.SCIMGroup
+		if !bindSCIM(c, &input) {
+			return
+		}
+
+		group, err := authService.CreateSCIMGroup(c.Request.Context(), middleware.CurrentUser(c).UserID, &input, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+
+		c.Header("Location", group.Meta.Location)
+		respondSCIMResource(c, http.StatusCreated, group, group.Meta)
+	}
+}
+
+// ReplaceSCIMGroup overwrites the group in the :id path parameter
+func ReplaceSCIMGroup(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var input services.SCIMGroup
+		if !bindSCIM(c, &input) {
+			return
+		}
+
+		group, err := authService.ReplaceSCIMGroup(c.Request.Context(), middleware.CurrentUser(c).UserID, c.Param("id"), c.GetHeader("If-Match"), &input, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMResource(c, http.StatusOK, group, group.Meta)
+	}
+}
+
+// PatchSCIMGroup updates the group in the :id path parameter
+func PatchSCIMGroup(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var patch services.SCIMPatchRequest
+		if !bindSCIM(c, &patch) {
+			return
+		}
+
+		group, err := authService.PatchSCIMGroup(c.Request.Context(), middleware.CurrentUser(c).UserID, c.Param("id"), c.GetHeader("If-Match"), &patch, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIMResource(c, http.StatusOK, group, group.Meta)
+	}
+}
+
+// DeleteSCIMGroup deletes the group in the :id path parameter
+func DeleteSCIMGroup(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := authService.DeleteSCIMGroup(c.Request.Context(), middleware.CurrentUser(c).UserID, c.Param("id"), c.GetHeader("If-Match"), c.ClientIP(), c.Request.UserAgent()); err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// Helper function to read the paging and filter parameters of a list request
+func scimListRequest(c *gin.Context) services.SCIMListRequest {
+	req := services.SCIMListRequest{
+		Filter:      c.Query("filter"),
+		StartIndex:  1,
+		Count:       -1,
+		WithMembers: !scimAttributeExcluded(c, "members"),
+	}
+	if startIndex, err := strconv.Atoi(c.Query("startIndex")); err == nil {
+		req.StartIndex = startIndex
+	}
+	if count, err := strconv.Atoi(c.Query("count")); err == nil {
+		// A negative count is treated as 0, per RFC 7644
+		req.Count = max(count, 0)
+	}
+	return req
+}
+
+// Helper function to decode a SCIM request body
+func bindSCIM(c *gin.Context, target interface{}) bool {
+	if err := json.NewDecoder(c.Request.Body).Decode(target); err != nil {
+		respondSCIMError(c, &services.SCIMError{Status: http.StatusBadRequest, ScimType: services.SCIMErrInvalidSyntax, Detail: "Request body is not valid JSON"})
+		return false
+	}
+	return true
+}
+
+// Helper function to serve the list of resource types or schemas, or one of them
+func respondSCIMDiscovery(c *gin.Context, resources []interface{}) {
+	if id := c.Param("id"); id != "" {
+		resource, err := services.SCIMDiscoveryResource(resources, id)
+		if err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+		respondSCIM(c, http.StatusOK, resource)
+		return
+	}
+	respondSCIM(c, http.StatusOK, services.SCIMDiscoveryList(resources))
+}
+
+// Helper function to write a SCIM response, keeping only the attributes the client asked
+// for with the attributes and excludedAttributes parameters
+func respondSCIM(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", services.SCIMContentType)
+	if c.Query("attributes") == "" && c.Query("excludedAttributes") == "" {
+		c.JSON(status, body)
+		return
+	}
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	if resources, ok := document["Resources"].([]interface{}); ok {
+		for _, resource := range resources {
+			if resource, ok := resource.(map[string]interface{}); ok {
+				projectSCIMResource(c, resource)
+			}
+		}
+	} else {
+		projectSCIMResource(c, document)
+	}
+	c.JSON(status, document)
+}
+
+// Helper function to write a single resource with its version as the ETag. A GET whose
+// If-None-Match lists the current version gets 304 Not Modified without a body.
+func respondSCIMResource(c *gin.Context, status int, body interface{}, meta *services.SCIMMeta) {
+	c.Header("ETag", meta.Version)
+	if c.Request.Method == http.MethodGet && services.SCIMVersionMatches(c.GetHeader("If-None-Match"), meta.Version) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	respondSCIM(c, status, body)
+}
+
+// Helper function to drop the attributes of a resource the client didn't ask for. id and
+// schemas are always returned.
+func projectSCIMResource(c *gin.Context, resource map[string]interface{}) {
+	requested := scimAttributeList(c.Query("attributes"))
+	for name := range resource {
+		if name == "id" || name == "schemas" {
+			continue
+		}
+		if (len(requested) > 0 && !requested[strings.ToLower(name)]) || scimAttributeExcluded(c, name) {
+			delete(resource, name)
+		}
+	}
+}
+
+// Helper function to check whether the client excluded an attribute
+func scimAttributeExcluded(c *gin.Context, name string) bool {
+	return scimAttributeList(c.Query("excludedAttributes"))[strings.ToLower(name)]
+}
+
+// Helper function to parse a comma-separated attributes parameter into lower-case top-level
+// attribute names, e.g. "urn:ietf:params:scim:schemas:core:2.0:User:name.givenName" becomes "name"
+func scimAttributeList(value string) map[string]bool {
+	attributes := make(map[string]bool)
+	for _, attribute := range strings.Split(value, ",") {
+		attribute = strings.TrimSpace(attribute)
+		if i := strings.LastIndex(attribute, ":"); i >= 0 {
+			attribute = attribute[i+1:]
+		}
+		attribute, _, _ = strings.Cut(attribute, ".")
+		if attribute != "" {
+			attributes[strings.ToLower(attribute)] = true
+		}
+	}
+	return attributes
+}
+
+// Helper function to write an error in the RFC 7644 format provisioning clients expect
+func respondSCIMError(c *gin.Context, err error) {
+	var scimErr *services.SCIMError
+	switch {
+	case errors.As(err, &scimErr):
+	case errors.Is(err, services.ErrSCIMDisabled):
+		scimErr = &services.SCIMError{Status: http.StatusNotFound, Detail: "SCIM provisioning is not enabled"}
+	case errors.Is(err, services.ErrUsernameAlreadyExists):
+		scimErr = &services.SCIMError{Status: http.StatusConflict, ScimType: services.SCIMErrUniqueness, Detail: "userName is already taken"}
+	case errors.Is(err, services.ErrEmailAlreadyExists):
+		scimErr = &services.SCIMError{Status: http.StatusConflict, ScimType: services.SCIMErrUniqueness, Detail: "email is already in use"}
+	default:
+		slog.ErrorContext(c.Request.Context(), "Unhandled error", "method", c.Request.Method, "route", c.FullPath(), "error", err)
+		scimErr = &services.SCIMError{Status: http.StatusInternalServerError, Detail: "Internal server error"}
+	}
+
+	c.Header("Content-Type", services.SCIMContentType)
+	c.JSON(scimErr.Status, scimErr)
+}
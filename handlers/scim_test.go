@@ -0,0 +1,190 @@
+// handlers/scim_test.go
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/services"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Helper function to route the SCIM handlers that fail before reaching the database. The
+// service has no pool, so nothing here may query it.
+func newSCIMTestRouter(enabled bool) *gin.Engine {
+	authService := services.NewAuthService(nil, "test-secret", 60)
+	if enabled {
+		authService.SetSCIMConfig(services.NewSCIMConfig("https://auth.example.com"))
+	}
+
+	router := gin.New()
+	router.GET("/scim/v2/ServiceProviderConfig", SCIMServiceProviderConfig(authService))
+	router.GET("/scim/v2/Users", ListSCIMUsers(authService))
+	router.POST("/scim/v2/Users", CreateSCIMUser(authService))
+	router.GET("/scim/v2/Groups", ListSCIMGroups(authService))
+	router.GET("/error", func(c *gin.Context) {
+		errs := map[string]error{
+			"username": services.ErrUsernameAlreadyExists,
+			"email":    services.ErrEmailAlreadyExists,
+			"version":  &services.SCIMError{Status: http.StatusPreconditionFailed, Detail: "Resource has changed"},
+			"internal": fmt.Errorf("connection refused"),
+		}
+		respondSCIMError(c, errs[c.Query("kind")])
+	})
+	return router
+}
+
+// Helper function to decode a SCIM error response, checking its media type and schema
+func decodeSCIMError(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, services.SCIMContentType) {
+		t.Errorf("Content-Type = %q, want %s", contentType, services.SCIMContentType)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if schemas, _ := body["schemas"].([]interface{}); len(schemas) != 1 || schemas[0] != services.SCIMErrorSchema {
+		t.Errorf("schemas = %v, want [%s]", body["schemas"], services.SCIMErrorSchema)
+	}
+	if status := body["status"]; status != fmt.Sprint(w.Code) {
+		t.Errorf("status = %#v, want %q", status, fmt.Sprint(w.Code))
+	}
+	return body
+}
+
+func TestSCIMInvalidFilterIsBadRequest(t *testing.T) {
+	router := newSCIMTestRouter(true)
+	filters := []string{
+		`userName`,
+		`userName eq`,
+		`userName xx "a"`,
+		`password eq "secret"`,
+		`(userName eq "a"`,
+		`userName eq "a" and`,
+		`active gt true`,
+		`meta.created gt "yesterday"`,
+	}
+	for _, resource := range []string{"Users", "Groups"} {
+		for _, filter := range filters {
+			t.Run(resource+" "+filter, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scim/v2/"+resource+"?filter="+url.QueryEscape(filter), nil))
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+				}
+				if body := decodeSCIMError(t, w); body["scimType"] != services.SCIMErrInvalidFilter {
+					t.Errorf("scimType = %v, want %s", body["scimType"], services.SCIMErrInvalidFilter)
+				}
+			})
+		}
+	}
+}
+
+func TestSCIMInvalidJSONIsBadRequest(t *testing.T) {
+	router := newSCIMTestRouter(true)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scim/v2/Users", strings.NewReader(`{"userName":`)))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if body := decodeSCIMError(t, w); body["scimType"] != services.SCIMErrInvalidSyntax {
+		t.Errorf("scimType = %v, want %s", body["scimType"], services.SCIMErrInvalidSyntax)
+	}
+}
+
+func TestSCIMErrorShapes(t *testing.T) {
+	tests := []struct {
+		kind         string
+		wantStatus   int
+		wantScimType string
+	}{
+		{"username", http.StatusConflict, services.SCIMErrUniqueness},
+		{"email", http.StatusConflict, services.SCIMErrUniqueness},
+		{"version", http.StatusPreconditionFailed, ""},
+		{"internal", http.StatusInternalServerError, ""},
+	}
+	router := newSCIMTestRouter(true)
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/error?kind="+tt.kind, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			body := decodeSCIMError(t, w)
+			if scimType, _ := body["scimType"].(string); scimType != tt.wantScimType {
+				t.Errorf("scimType = %q, want %q", scimType, tt.wantScimType)
+			}
+			if tt.kind == "internal" && strings.Contains(w.Body.String(), "connection refused") {
+				t.Error("internal error details leaked to the client")
+			}
+		})
+	}
+}
+
+func TestSCIMDisabledIsNotFound(t *testing.T) {
+	router := newSCIMTestRouter(false)
+	for _, path := range []string{"/scim/v2/ServiceProviderConfig", "/scim/v2/Users", "/scim/v2/Groups"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+		decodeSCIMError(t, w)
+	}
+}
+
+func TestSCIMServiceProviderConfigAdvertisesETags(t *testing.T) {
+	router := newSCIMTestRouter(true)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scim/v2/ServiceProviderConfig", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	var config struct {
+		Patch  struct{ Supported bool } `json:"patch"`
+		Filter struct {
+			Supported  bool `json:"supported"`
+			MaxResults int  `json:"maxResults"`
+		} `json:"filter"`
+		ETag struct{ Supported bool } `json:"etag"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &config); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if !config.Patch.Supported || !config.Filter.Supported || config.Filter.MaxResults != 200 || !config.ETag.Supported {
+		t.Errorf("config = %+v, want patch, filter with maxResults 200 and etag supported", config)
+	}
+}
+
+func TestSCIMListRequestPaging(t *testing.T) {
+	tests := []struct {
+		query                string
+		wantStart, wantCount int
+	}{
+		{"", 1, -1},
+		{"startIndex=11&count=10", 11, 10},
+		{"count=-3", 1, 0}, // Negative counts are 0, per RFC 7644
+		{"startIndex=abc&count=xyz", 1, -1},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/scim/v2/Users?"+tt.query, nil)
+		req := scimListRequest(c)
+		if req.StartIndex != tt.wantStart || req.Count != tt.wantCount {
+			t.Errorf("%q: startIndex, count = %d, %d, want %d, %d", tt.query, req.StartIndex, req.Count, tt.wantStart, tt.wantCount)
+		}
+	}
+}
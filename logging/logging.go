@@ -0,0 +1,159 @@
+// logging/logging.go
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Redacted replaces secrets in log output
+const Redacted = "[REDACTED]"
+
+// New creates a logger writing in format ("text" or "json") at level ("debug", "info",
+// "warn" or "error") or above. Every record carries the request ID of its context, and
+// tokens and passwords are redacted.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redactAttr}
+	var handler slog.Handler
+	switch format {
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request it belongs to
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID returns the ID of the request ctx belongs to, or "" outside of requests
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// Handler that adds the request ID from the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// Parts of attribute keys that name a secret
+var secretKeys = []string{"password", "passwd", "secret", "token", "authorization", "cookie", "api_key", "apikey", "private_key"}
+
+// IsSecretKey reports whether an attribute or field with this name holds a secret
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// Secrets embedded in free text: authorization headers, query parameters, JSON fields and
+// connection strings
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[a-z0-9\-._~+/]+=*`), "$1 " + Redacted},
+	{regexp.MustCompile(`(?i)\b((?:[a-z_]*(?:token|password|passwd|secret)|code)=)[^&\s"']+`), "${1}" + Redacted},
+	{regexp.MustCompile(`(?i)("[a-z_]*(?:token|password|passwd|secret)"\s*:\s*")[^"]*"`), "${1}" + Redacted + `"`},
+	{regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://[^:/@\s]+:)[^@\s]+@`), "${1}" + Redacted + "@"},
+}
+
+// RedactString removes the secrets it recognizes from free text, such as error messages
+func RedactString(s string) string {
+	for _, p := range secretPatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// Helper function to redact an attribute by its key, or the secrets in its value
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.MessageKey && IsSecretKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(RedactString(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			a.Value = slog.StringValue(RedactString(v.Error()))
+		case map[string]interface{}:
+			a.Value = slog.AnyValue(redactMap(v))
+		case map[string]string:
+			redacted := make(map[string]string, len(v))
+			for key, value := range v {
+				if IsSecretKey(key) {
+					value = Redacted
+				}
+				redacted[key] = RedactString(value)
+			}
+			a.Value = slog.AnyValue(redacted)
+		}
+	}
+	return a
+}
+
+// Helper function to copy a map with its secrets redacted, e.g. audit log details
+func redactMap(m map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		switch v := value.(type) {
+		case string:
+			value = RedactString(v)
+		case map[string]interface{}:
+			value = redactMap(v)
+		}
+		if IsSecretKey(key) {
+			value = Redacted
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime/debug"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -15,7 +17,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
-	"github.com/loganmanery/go-react-app/db"
+	"github.com/loganmanery/go-react-app/config"
+	"github.com/loganmanery/go-react-app/handlers"
+	"github.com/loganmanery/go-react-app/metrics"
+	"github.com/loganmanery/go-react-app/middleware"
 	"github.com/loganmanery/go-react-app/models"
 	"github.com/loganmanery/go-react-app/services"
 )
@@ -23,61 +28,118 @@ import (
 func main() {
 	// Load environment variables from .env file
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
+		slog.Info("No .env file found, using environment variables")
 	}
 
-	// Configure database
-	dbConfig := db.DBConfig{
-		Host:     getEnv("DB_HOST", "localhost"),
-		Port:     getEnvAsInt("DB_PORT", 5432),
-		User:     getEnv("DB_USER", "postgres"),
-		Password: getEnv("DB_PASSWORD", "password"),
-		DBName:   getEnv("DB_NAME", "web_application_db"),
-		SSLMode:  getEnv("DB_SSLMODE", "disable"),
-	}
+	os.Exit(runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
 
+// Run the server until it receives SIGINT or SIGTERM
+func serve(cfg *config.Config) {
 	// Connect to database
-	database, err := db.Connect(dbConfig)
+	database, err := connectDatabase(cfg)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", "error", err)
 	}
 	defer database.Close()
 
+	// Apply any pending schema migrations
+	if err := database.Migrate(context.Background()); err != nil {
+		fatal("Failed to apply database migrations", "error", err)
+	}
+
 	// Initialize repositories
 	userRepo := models.NewUserRepository(database.Pool)
 	sessionRepo := models.NewSessionRepository(database.Pool)
 	auditRepo := models.NewAuditLogRepository(database.Pool)
+	roleRepo := models.NewRoleRepository(database.Pool)
 
 	// Initialize services
-	jwtSecret := getEnv("JWT_SECRET", "your-secret-key")
-	tokenExpiryMin := getEnvAsInt("TOKEN_EXPIRY_MINUTES", 60)
-	authService := services.NewAuthService(database.Pool, jwtSecret, tokenExpiryMin)
+	authService, closeAuthService, err := newAuthService(cfg, database)
+	if err != nil {
+		fatal("Failed to configure services", "error", err)
+	}
+	defer closeAuthService()
+
+	// Export metrics on the admin listener, if there is one
+	var appMetrics *metrics.Metrics
+	if cfg.Server.AdminAddr != "" {
+		appMetrics = metrics.New()
+		err := appMetrics.Register(
+			metrics.NewPoolCollector(database),
+			metrics.NewActiveSessionsCollector(authService.CountActiveSessions),
+		)
+		if err != nil {
+			fatal("Failed to register metrics", "error", err)
+		}
+		authService.SetMetrics(appMetrics)
+	}
+
+	// Cache validated sessions in memory; revocations reach every replica through LISTEN/NOTIFY
+	var sessionCache *services.SessionCache
+	if cfg.Session.CacheSize > 0 {
+		sessionCache = services.NewSessionCache(cfg.Session.CacheSize, cfg.Session.CacheTTL)
+		authService.SetSessionCache(sessionCache)
+	}
 
 	// Create admin user if not exists
 	ctx := context.Background()
-	createAdminUser(ctx, userRepo)
+	createAdminUser(ctx, authService, userRepo, roleRepo, cfg.Admin)
+
+	// Choose where rate limit buckets live; use postgres when running more than one replica
+	var rateLimitStore middleware.RateLimitStore
+	switch cfg.RateLimit.Store {
+	case "postgres":
+		rateLimitStore = middleware.NewPostgresRateLimitStore(models.NewRateLimitRepository(database.Pool), 24*time.Hour)
+	default:
+		rateLimitStore = middleware.NewMemoryRateLimitStore()
+	}
 
 	// Start session cleanup in background
-	go scheduleSessionCleanup(ctx, sessionRepo)
+	go scheduleSessionCleanup(ctx, sessionRepo, authService)
+	go scheduleRateLimitCleanup(ctx, rateLimitStore)
+	if sessionCache != nil {
+		go sessionCache.Listen(ctx, database.Pool)
+	}
 
 	// Set up HTTP server with Gin
 	// Set Gin to production mode
 	gin.SetMode(gin.ReleaseMode)
 
-	// Create a default gin router
-	router := gin.Default()
-
-	// Add CORS middleware
-	router.Use(cors.Default())
+	router, err := newRouter(cfg.Server, appMetrics)
+	if err != nil {
+		fatal("Failed to configure router", "error", err)
+	}
 
 	// Setup the React app serving
 	setupViteReactApp(router)
 
+	// Configure the session cookie
+	sessionCookie := middleware.NewSessionCookie()
+	sessionCookie.Name = cfg.Cookie.Name
+	sessionCookie.DeviceName = cfg.Cookie.DeviceName
+	sessionCookie.Domain = cfg.Cookie.Domain
+	sessionCookie.Path = cfg.Cookie.Path
+	sessionCookie.Secure = cfg.Cookie.Secure
+	sessionCookie.SameSite = middleware.ParseSameSite(cfg.Cookie.SameSite)
+
+	// Configure CSRF protection for cookie-authenticated requests
+	csrf := middleware.NewCSRFProtection(cfg.CSRF.Secret, cfg.CSRF.AllowedOrigins, sessionCookie)
+
+	// Define OpenID Connect provider routes; relying parties call these cross-site, so they
+	// sit outside the CSRF-protected API group
+	setupOIDCRoutes(router, authService, rateLimitStore, sessionCookie)
+
+	// Define SAML service provider routes; identity providers post to these cross-site
+	setupSAMLRoutes(router, authService, rateLimitStore, sessionCookie)
+
+	// Define SCIM provisioning routes; clients authenticate with a bearer token, never a cookie
+	setupSCIMRoutes(router, authService, rateLimitStore)
+
 	// Define API Routes
-	setupAPIRoutes(router, authService, userRepo, sessionRepo, auditRepo)
+	setupAPIRoutes(router, authService, rateLimitStore, sessionCookie, csrf, userRepo, sessionRepo, auditRepo)
 
-	// Get port from environment or use default
-	port := getEnv("PORT", "8080")
+	port := strconv.Itoa(cfg.Server.Port)
 
 	// Create a server with a shutdown timeout
 	srv := &http.Server{
@@ -87,17 +149,35 @@ func main() {
 
 	// Start server in a goroutine so it doesn't block the graceful shutdown handling
 	go func() {
-		log.Printf("Server starting on port %s...\n", port)
+		slog.Info("Server starting", "port", port)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			fatal("Failed to start server", "error", err)
 		}
 	}()
 
+	// Serve metrics on a listener of their own, which need not be reachable from outside
+	var adminSrv *http.Server
+	if appMetrics != nil {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", appMetrics.Handler())
+		adminSrv = &http.Server{
+			Addr:              cfg.Server.AdminAddr,
+			Handler:           adminMux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			slog.Info("Admin server starting", "addr", cfg.Server.AdminAddr)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				fatal("Failed to start admin server", "error", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shut down
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
 
 	// Create a deadline for server shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -105,10 +185,41 @@ func main() {
 
 	// Attempt graceful shutdown
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v", err)
+		slog.Error("Server forced to shutdown", "error", err)
+	}
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			slog.Error("Admin server forced to shutdown", "error", err)
+		}
 	}
 
-	log.Println("Server exited")
+	slog.Info("Server exited")
+}
+
+// Helper function to log and exit when the server can't start
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(exitFailure)
+}
+
+// Helper function to log a panic in a handler, with its stack, before responding with a 500
+func recoverPanic(c *gin.Context, recovered any) {
+	slog.ErrorContext(c.Request.Context(), "Panic while handling request", "panic", recovered, "stack", string(debug.Stack()))
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// Create a router that recovers from panics, logs every request with its ID and allows
+// cross-origin requests. Client IPs come from X-Forwarded-For and X-Real-IP only when the
+// request arrives from one of the trusted proxies; otherwise any client could pick its own
+// IP and get past the per-IP rate limits.
+func newRouter(serverConfig config.ServerConfig, appMetrics *metrics.Metrics) (*gin.Engine, error) {
+	router := gin.New()
+	if err := router.SetTrustedProxies(serverConfig.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	router.Use(middleware.RequestID(), middleware.RequestLogger(), middleware.Metrics(appMetrics), gin.CustomRecovery(recoverPanic))
+	router.Use(cors.Default())
+	return router, nil
 }
 
 func setupViteReactApp(router *gin.Engine) {
@@ -133,9 +244,80 @@ func setupViteReactApp(router *gin.Engine) {
 	})
 }
 
-func setupAPIRoutes(router *gin.Engine, authService *services.AuthService, userRepo *models.UserRepository, sessionRepo *models.SessionRepository, auditRepo *models.AuditLogRepository) {
+func setupOIDCRoutes(router *gin.Engine, authService *services.AuthService, rateLimitStore middleware.RateLimitStore, sessionCookie *middleware.SessionCookie) {
+	oauthLimit := middleware.RateLimit(rateLimitStore, middleware.RateLimitRule{
+		Name:  "oauth-ip",
+		Limit: middleware.Limit{Requests: 60, Per: time.Minute},
+		Key:   middleware.ByIP,
+	})
+
+	router.GET("/.well-known/openid-configuration", handlers.OIDCDiscovery(authService))
+
+	oauth := router.Group("/oauth")
+	{
+		oauth.GET("/jwks", handlers.OIDCKeys(authService))
+		oauth.GET("/authorize", handlers.OAuthAuthorize(authService, sessionCookie))
+		oauth.POST("/token", oauthLimit, handlers.OAuthToken(authService))
+		oauth.GET("/userinfo", handlers.OAuthUserInfo(authService))
+		oauth.POST("/userinfo", handlers.OAuthUserInfo(authService))
+		oauth.POST("/introspect", oauthLimit, handlers.OAuthIntrospect(authService))
+		oauth.POST("/revoke", oauthLimit, handlers.OAuthRevoke(authService))
+	}
+}
+
+func setupSAMLRoutes(router *gin.Engine, authService *services.AuthService, rateLimitStore middleware.RateLimitStore, sessionCookie *middleware.SessionCookie) {
+	samlLimit := middleware.RateLimit(rateLimitStore, middleware.RateLimitRule{
+		Name:  "saml-ip",
+		Limit: middleware.Limit{Requests: 30, Per: time.Minute},
+		Key:   middleware.ByIP,
+	})
+
+	saml := router.Group("/saml/:provider")
+	{
+		saml.GET("/metadata", handlers.SAMLMetadata(authService))
+		saml.GET("/login", samlLimit, handlers.StartSAMLLogin(authService, sessionCookie))
+		saml.POST("/acs", samlLimit, handlers.SAMLAssertionConsumer(authService, sessionCookie))
+		saml.GET("/slo", samlLimit, handlers.SAMLSingleLogout(authService, sessionCookie))
+		saml.POST("/slo", samlLimit, handlers.SAMLSingleLogout(authService, sessionCookie))
+	}
+}
+
+func setupSCIMRoutes(router *gin.Engine, authService *services.AuthService, rateLimitStore middleware.RateLimitStore) {
+	scimLimit := middleware.RateLimit(rateLimitStore, middleware.RateLimitRule{
+		Name:  "scim-ip",
+		Limit: middleware.Limit{Requests: 600, Per: time.Minute},
+		Key:   middleware.ByIP,
+	})
+
+	scim := router.Group("/scim/v2", scimLimit)
+	{
+		// Discovery endpoints describe the API and are readable without a token
+		scim.GET("/ServiceProviderConfig", handlers.SCIMServiceProviderConfig(authService))
+		scim.GET("/ResourceTypes", handlers.SCIMResourceTypes(authService))
+		scim.GET("/ResourceTypes/:id", handlers.SCIMResourceTypes(authService))
+		scim.GET("/Schemas", handlers.SCIMSchemas(authService))
+		scim.GET("/Schemas/:id", handlers.SCIMSchemas(authService))
+
+		resources := scim.Group("", middleware.SCIMAuthenticated(authService))
+		resources.GET("/Users", handlers.ListSCIMUsers(authService))
+		resources.POST("/Users", handlers.CreateSCIMUser(authService))
+		resources.GET("/Users/:id", handlers.GetSCIMUser(authService))
+		resources.PUT("/Users/:id", handlers.ReplaceSCIMUser(authService))
+		resources.PATCH("/Users/:id", handlers.PatchSCIMUser(authService))
+		resources.DELETE("/Users/:id", handlers.DeleteSCIMUser(authService))
+		resources.GET("/Groups", handlers.ListSCIMGroups(authService))
+		resources.POST("/Groups", handlers.CreateSCIMGroup(authService))
+		resources.GET("/Groups/:id", handlers.GetSCIMGroup(authService))
+		resources.PUT("/Groups/:id", handlers.ReplaceSCIMGroup(authService))
+		resources.PATCH("/Groups/:id", handlers.PatchSCIMGroup(authService))
+		resources.DELETE("/Groups/:id", handlers.DeleteSCIMGroup(authService))
+	}
+}
+
+func setupAPIRoutes(router *gin.Engine, authService *services.AuthService, rateLimitStore middleware.RateLimitStore, sessionCookie *middleware.SessionCookie, csrf *middleware.CSRFProtection, userRepo *models.UserRepository, sessionRepo *models.SessionRepository, auditRepo *models.AuditLogRepository) {
 	// Group API routes
 	api := router.Group("/api")
+	api.Use(csrf.Middleware())
 	{
 		api.GET("/hello", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
@@ -143,20 +325,138 @@ func setupAPIRoutes(router *gin.Engine, authService *services.AuthService, userR
 			})
 		})
 
+		// Rate limits for the auth endpoints, keyed by IP, by account and by both together
+		// to slow down both credential stuffing and targeted guessing
+		limit := func(name string, requests int, per time.Duration, key middleware.RateLimitKeyFunc) middleware.RateLimitRule {
+			return middleware.RateLimitRule{Name: name, Limit: middleware.Limit{Requests: requests, Per: per}, Key: key}
+		}
+		loginLimit := middleware.RateLimit(rateLimitStore,
+			limit("login-ip", 20, time.Minute, middleware.ByIP),
+			limit("login-user", 10, 15*time.Minute, middleware.ByBodyField("login")),
+			limit("login-ip-user", 5, time.Minute, middleware.ByIPAndBodyField("login")),
+		)
+		registerLimit := middleware.RateLimit(rateLimitStore,
+			limit("register-ip", 5, time.Hour, middleware.ByIP),
+		)
+		forgotPasswordLimit := middleware.RateLimit(rateLimitStore,
+			limit("forgot-password-ip", 5, 15*time.Minute, middleware.ByIP),
+			limit("forgot-password-email", 3, time.Hour, middleware.ByBodyField("email")),
+		)
+		tokenLimit := middleware.RateLimit(rateLimitStore,
+			limit("token-ip", 10, 15*time.Minute, middleware.ByIP),
+		)
+		passwordCheckLimit := middleware.RateLimit(rateLimitStore,
+			limit("password-check-ip", 60, time.Minute, middleware.ByIP),
+		)
+
 		// Auth routes
 		auth := api.Group("/auth")
 		{
-			// TODO: Add auth endpoints
-			// Example:
-			// auth.POST("/login", handlers.Login(authService))
-			// auth.POST("/register", handlers.Register(authService))
-			// auth.POST("/logout", middleware.Authenticated(), handlers.Logout(authService))
+			auth.POST("/login", loginLimit, handlers.Login(authService, sessionCookie))
+			auth.POST("/login/approve", tokenLimit, handlers.ApproveLogin(authService))
+			auth.POST("/login/challenge/complete", tokenLimit, handlers.CompleteLoginChallenge(authService, sessionCookie))
+			auth.POST("/register", registerLimit, handlers.Register(authService))
+			auth.POST("/logout", middleware.Authenticated(authService, sessionCookie), middleware.RequireSession(), handlers.Logout(authService, sessionCookie))
+			auth.POST("/verify-email", tokenLimit, handlers.VerifyEmail(authService))
+			auth.POST("/forgot-password", forgotPasswordLimit, handlers.ForgotPassword(authService))
+			auth.POST("/reset-password", tokenLimit, handlers.ResetPassword(authService))
+			auth.POST("/change-password", tokenLimit, middleware.Authenticated(authService, sessionCookie), middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.ChangePassword(authService))
+			auth.POST("/deletion/cancel", tokenLimit, handlers.CancelAccountDeletionWithToken(authService))
+			auth.POST("/impersonation/end", middleware.Authenticated(authService, sessionCookie), middleware.RequireSession(), handlers.EndImpersonation(authService, sessionCookie))
+			auth.POST("/password-policy/check", passwordCheckLimit, handlers.ValidatePassword(authService))
+			auth.GET("/csrf", handlers.CSRFToken(csrf))
+			auth.GET("/external/providers", handlers.ListExternalProviders(authService))
+			auth.GET("/external/:provider/start", tokenLimit, handlers.StartExternalLogin(authService, sessionCookie))
+			auth.GET("/external/:provider/callback", tokenLimit, handlers.ExternalLoginCallback(authService, sessionCookie))
 		}
 
 		// User routes
 		users := api.Group("/users")
+		users.Use(middleware.Authenticated(authService, sessionCookie))
+		{
+			users.GET("/me", middleware.RequireScope(services.ScopeProfileRead), func(c *gin.Context) {
+				response := gin.H{"user": middleware.CurrentUser(c)}
+				if session := middleware.CurrentSession(c); session != nil && session.ImpersonatorID != nil {
+					response["impersonated_by"] = session.ImpersonatorID
+				}
+				c.JSON(http.StatusOK, response)
+			})
+			users.GET("/me/identities", middleware.RequireScope(services.ScopeProfileRead), handlers.ListExternalIdentities(authService))
+			users.GET("/me/devices", middleware.RequireScope(services.ScopeDevicesRead), handlers.ListDevices(authService))
+			users.POST("/me/devices/:id/trust", middleware.RequireScope(services.ScopeDevicesWrite), middleware.RestrictImpersonation(authService), handlers.TrustDevice(authService))
+			users.POST("/me/devices/:id/report", middleware.RequireScope(services.ScopeDevicesWrite), middleware.RestrictImpersonation(authService), handlers.ReportDevice(authService))
+
+			// Personal access tokens can only be managed from an interactive session
+			users.GET("/me/tokens", middleware.RequireSession(), handlers.ListAccessTokens(authService))
+			users.POST("/me/tokens", middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.CreateAccessToken(authService))
+			users.DELETE("/me/tokens/:id", middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.RevokeAccessToken(authService))
+
+			// Downloading and deleting the account are for the user themselves, not tokens or admins acting as them
+			users.GET("/me/export", middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.ExportAccount(authService))
+			users.GET("/me/deletion", middleware.RequireSession(), handlers.GetAccountDeletion(authService))
+			users.POST("/me/deletion", middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.RequestAccountDeletion(authService))
+			users.DELETE("/me/deletion", middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.CancelAccountDeletion(authService))
+		}
+
+		// Organizations the user belongs to. Switching the active organization is per session;
+		// requests made with an access token name their organization in the X-Organization-ID header.
+		orgs := api.Group("/orgs")
+		orgs.Use(middleware.Authenticated(authService, sessionCookie), middleware.RequireSession())
+		{
+			orgs.GET("", handlers.ListOrganizations(authService))
+			orgs.POST("", handlers.CreateOrganization(authService))
+			orgs.GET("/:id", handlers.GetOrganization(authService))
+			orgs.PATCH("/:id", handlers.UpdateOrganization(authService))
+			orgs.DELETE("/:id", middleware.RestrictImpersonation(authService), handlers.DeleteOrganization(authService))
+			orgs.POST("/:id/switch", handlers.SwitchOrganization(authService))
+			orgs.GET("/:id/members", handlers.ListOrganizationMembers(authService))
+			orgs.PATCH("/:id/members/:user_id", handlers.UpdateOrganizationMember(authService))
+			orgs.DELETE("/:id/members/:user_id", handlers.RemoveOrganizationMember(authService))
+			orgs.GET("/:id/invitations", handlers.ListOrganizationInvitations(authService))
+			orgs.POST("/:id/invitations", handlers.InviteToOrganization(authService))
+			orgs.DELETE("/:id/invitations/:invitation_id", handlers.RevokeOrganizationInvitation(authService))
+		}
+
+		// Invitations are accepted by signed-in users, or by new users as they register
+		invitations := api.Group("/invitations")
+		{
+			invitations.POST("/preview", tokenLimit, handlers.PreviewOrganizationInvitation(authService))
+			invitations.POST("/accept", tokenLimit, middleware.Authenticated(authService, sessionCookie), middleware.RequireSession(), middleware.RestrictImpersonation(authService), handlers.AcceptOrganizationInvitation(authService))
+			invitations.POST("/register", registerLimit, handlers.RegisterWithInvitation(authService))
+		}
+
+		// Data scoped to the active organization goes under here
+		tenant := api.Group("/org")
+		tenant.Use(middleware.Authenticated(authService, sessionCookie), middleware.RequireOrganization(authService))
+		{
+			tenant.GET("", middleware.RequireScope(services.ScopeProfileRead), handlers.GetActiveOrganization())
+		}
+
+		// Consent screen for OpenID Connect clients
+		consent := api.Group("/oauth/consent")
+		consent.Use(middleware.Authenticated(authService, sessionCookie), middleware.RequireSession())
+		{
+			consent.GET("/:id", handlers.GetOAuthConsent(authService))
+			consent.POST("/:id", middleware.RestrictImpersonation(authService), handlers.DecideOAuthConsent(authService))
+		}
+
+		// Admin routes
+		admin := api.Group("/admin")
+		admin.Use(
+			middleware.Authenticated(authService, sessionCookie),
+			middleware.RequireScope(services.ScopeAdmin),
+			middleware.RequireRole(authService, models.RoleAdmin),
+		)
 		{
-			// TODO: Add user endpoints
+			admin.GET("/users/deleted", handlers.ListDeletedUsers(authService))
+			admin.DELETE("/users/:id", handlers.DeleteUser(authService))
+			admin.POST("/users/:id/restore", handlers.RestoreUser(authService))
+			admin.DELETE("/users/:id/purge", handlers.PurgeUser(authService))
+			admin.POST("/users/:id/unlock", handlers.UnlockUser(authService))
+			admin.POST("/users/:id/impersonate", middleware.RequireSession(), handlers.StartImpersonation(authService, sessionCookie))
+			admin.GET("/oauth/clients", handlers.ListOAuthClients(authService))
+			admin.POST("/oauth/clients", handlers.RegisterOAuthClient(authService))
+			admin.DELETE("/oauth/clients/:id", handlers.DeleteOAuthClient(authService))
 		}
 
 		// TODO: Add more API endpoints as needed
@@ -164,15 +464,15 @@ func setupAPIRoutes(router *gin.Engine, authService *services.AuthService, userR
 }
 
 // Create admin user if it doesn't exist
-func createAdminUser(ctx context.Context, userRepo *models.UserRepository) {
-	adminEmail := getEnv("ADMIN_EMAIL", "admin@example.com")
-	adminUsername := getEnv("ADMIN_USERNAME", "admin")
-	adminPassword := getEnv("ADMIN_PASSWORD", "admin_password")
+func createAdminUser(ctx context.Context, authService *services.AuthService, userRepo *models.UserRepository, roleRepo *models.RoleRepository, adminConfig config.AdminConfig) {
+	adminEmail := adminConfig.Email
+	adminUsername := adminConfig.Username
+	adminPassword := adminConfig.Password
 
 	// Check if admin exists
 	admin, err := userRepo.GetByEmail(ctx, adminEmail)
 	if err != nil {
-		log.Printf("Error checking admin user: %v", err)
+		slog.ErrorContext(ctx, "Error checking admin user", "error", err)
 		return
 	}
 
@@ -187,28 +487,72 @@ func createAdminUser(ctx context.Context, userRepo *models.UserRepository) {
 			IsActive:        true,
 		}
 
+		// There is no default admin password, and the one provided must pass the policy
+		if adminPassword == "" {
+			slog.WarnContext(ctx, "ADMIN_PASSWORD is not set, skipping admin user creation")
+			return
+		}
+		if err := authService.ValidatePassword(ctx, adminPassword, admin); err != nil {
+			slog.ErrorContext(ctx, "Error creating admin user", "error", err)
+			return
+		}
+
 		if err := userRepo.Create(ctx, admin, adminPassword); err != nil {
-			log.Printf("Error creating admin user: %v", err)
+			slog.ErrorContext(ctx, "Error creating admin user", "error", err)
 			return
 		}
 
-		log.Printf("Admin user created with email: %s", adminEmail)
+		slog.InfoContext(ctx, "Admin user created", "email", adminEmail)
+	}
+
+	// Make sure the admin user has the admin role
+	if err := roleRepo.Grant(ctx, admin.UserID, models.RoleAdmin); err != nil {
+		slog.ErrorContext(ctx, "Error granting admin role", "error", err)
 	}
 }
 
 // Schedule regular cleanup of expired sessions
-func scheduleSessionCleanup(ctx context.Context, sessionRepo *models.SessionRepository) {
+func scheduleSessionCleanup(ctx context.Context, sessionRepo *models.SessionRepository, authService *services.AuthService) {
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
+			// Record the end of lapsed impersonations before their sessions are deleted
+			if _, err := authService.EndExpiredImpersonations(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error ending expired impersonation sessions", "error", err)
+			}
 			count, err := sessionRepo.DeleteExpiredSessions(ctx)
 			if err != nil {
-				log.Printf("Error cleaning up expired sessions: %v", err)
+				slog.ErrorContext(ctx, "Error cleaning up expired sessions", "error", err)
 			} else if count > 0 {
-				log.Printf("Cleaned up %d expired sessions", count)
+				slog.InfoContext(ctx, "Cleaned up expired sessions", "count", count)
+			}
+			if _, err := authService.CleanupExpiredLoginChallenges(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error cleaning up expired login challenges", "error", err)
+			}
+			if _, err := authService.CleanupExpiredExternalLogins(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error cleaning up expired external sign-ins", "error", err)
+			}
+			if _, err := authService.CleanupExpiredSAMLState(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error cleaning up expired SAML requests", "error", err)
+			}
+			if _, err := authService.CleanupExpiredOrganizationInvitations(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error cleaning up expired organization invitations", "error", err)
+			}
+			if err := authService.CleanupExpiredOAuthGrants(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error cleaning up expired OAuth grants", "error", err)
+			}
+			if count, err := authService.CompleteDueAccountDeletions(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error completing account deletions", "error", err)
+			} else if count > 0 {
+				slog.InfoContext(ctx, "Deleted accounts at the end of their grace period", "count", count)
+			}
+			if count, err := authService.ReleaseDeletedUserIdentifiers(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error releasing deleted users' emails and usernames", "error", err)
+			} else if count > 0 {
+				slog.InfoContext(ctx, "Released the emails and usernames of deleted users", "count", count)
 			}
 		case <-ctx.Done():
 			return
@@ -216,28 +560,19 @@ func scheduleSessionCleanup(ctx context.Context, sessionRepo *models.SessionRepo
 	}
 }
 
-// Helper function to read environment variables with default values
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
-	}
-	return value
-}
-
-// Helper function to read environment variables as integers with default values
-func getEnvAsInt(key string, defaultValue int) int {
-	valueStr := os.Getenv(key)
-	if valueStr == "" {
-		return defaultValue
-	}
+// Schedule regular cleanup of idle rate limit buckets
+func scheduleRateLimitCleanup(ctx context.Context, store middleware.RateLimitStore) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
 
-	var value int
-	_, err := fmt.Sscanf(valueStr, "%d", &value)
-	if err != nil {
-		log.Printf("Warning: Invalid value for %s: %s, using default: %d", key, valueStr, defaultValue)
-		return defaultValue
+	for {
+		select {
+		case <-ticker.C:
+			if err := store.Cleanup(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error cleaning up rate limit buckets", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
 	}
-
-	return value
 }
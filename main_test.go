@@ -0,0 +1,97 @@
+// main_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/config"
+	"github.com/loganmanery/go-react-app/middleware"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Helper function to build the server's router with a route limited to two requests a
+// minute by IP, and by IP and login together
+func newRateLimitedRouter(t *testing.T, trustedProxies []string) *gin.Engine {
+	t.Helper()
+	router, err := newRouter(config.ServerConfig{TrustedProxies: trustedProxies}, nil)
+	if err != nil {
+		t.Fatalf("newRouter: %v", err)
+	}
+	store := middleware.NewMemoryRateLimitStore()
+	limit := middleware.Limit{Requests: 2, Per: time.Minute}
+	router.GET("/by-ip", middleware.RateLimit(store, middleware.RateLimitRule{Name: "ip", Limit: limit, Key: middleware.ByIP}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.POST("/by-ip-and-login", middleware.RateLimit(store, middleware.RateLimitRule{Name: "ip-user", Limit: limit, Key: middleware.ByIPAndBodyField("login")}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+// Helper function to send requests from one address, each claiming a different client IP
+// through the forwarding headers, and collect the response statuses
+func sendForgedRequests(router *gin.Engine, method, path, body string, count int) []int {
+	forged := []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"}
+	var statuses []int
+	for i := 0; i < count; i++ {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = "203.0.113.7:40000"
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forged[i%len(forged)])
+		req.Header.Set("X-Real-IP", forged[i%len(forged)])
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		statuses = append(statuses, w.Code)
+	}
+	return statuses
+}
+
+func TestForgedForwardedForDoesNotChangeRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+	}{
+		{"by IP", http.MethodGet, "/by-ip", ""},
+		{"by IP and login", http.MethodPost, "/by-ip-and-login", `{"login":"alice"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newRateLimitedRouter(t, nil)
+			statuses := sendForgedRequests(router, tt.method, tt.path, tt.body, 3)
+			want := []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests}
+			for i := range want {
+				if statuses[i] != want[i] {
+					t.Fatalf("statuses = %v, want %v: the forged headers changed the rate limit key", statuses, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTrustedProxyForwardedForIsBelieved(t *testing.T) {
+	router := newRateLimitedRouter(t, []string{"203.0.113.0/24"})
+
+	// Behind a trusted proxy each forwarded client gets its own bucket
+	for i, status := range sendForgedRequests(router, http.MethodGet, "/by-ip", "", 4) {
+		if status != http.StatusNoContent {
+			t.Fatalf("request %d: status = %d, want %d", i, status, http.StatusNoContent)
+		}
+	}
+}
+
+func TestNewRouterRejectsInvalidTrustedProxies(t *testing.T) {
+	if _, err := newRouter(config.ServerConfig{TrustedProxies: []string{"not-an-ip"}}, nil); err == nil {
+		t.Fatal("newRouter accepted an invalid trusted proxy")
+	}
+}
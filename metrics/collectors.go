@@ -0,0 +1,111 @@
+// metrics/collectors.go
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/loganmanery/go-react-app/db"
+)
+
+// How long a scrape waits for the active session count
+const activeSessionsTimeout = 5 * time.Second
+
+// PoolCollector exports the database connection pool's statistics at each scrape
+type PoolCollector struct {
+	database *db.Database
+
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+	acquiredConns        *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	idleConns            *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+}
+
+// NewPoolCollector creates a collector for the database's pool
+func NewPoolCollector(database *db.Database) *PoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(Namespace, "db_pool", name), help, nil, nil)
+	}
+	return &PoolCollector{
+		database:             database,
+		acquireCount:         desc("acquires_total", "Connections acquired from the pool."),
+		acquireDuration:      desc("acquire_duration_seconds_total", "Time spent acquiring connections from the pool."),
+		emptyAcquireCount:    desc("empty_acquires_total", "Acquires that had to wait because the pool had no idle connection."),
+		canceledAcquireCount: desc("canceled_acquires_total", "Acquires cancelled by their context while waiting."),
+		acquiredConns:        desc("acquired_connections", "Connections currently in use."),
+		constructingConns:    desc("constructing_connections", "Connections currently being opened."),
+		idleConns:            desc("idle_connections", "Idle connections in the pool."),
+		totalConns:           desc("connections", "Connections in the pool, in use, idle or being opened."),
+		maxConns:             desc("max_connections", "Most connections the pool will open."),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *PoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquireCount
+	ch <- c.acquireDuration
+	ch <- c.emptyAcquireCount
+	ch <- c.canceledAcquireCount
+	ch <- c.acquiredConns
+	ch <- c.constructingConns
+	ch <- c.idleConns
+	ch <- c.totalConns
+	ch <- c.maxConns
+}
+
+// Collect implements prometheus.Collector
+func (c *PoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.database.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquireCount, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquireCount, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.canceledAcquireCount, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.constructingConns, prometheus.GaugeValue, float64(stat.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(c.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+}
+
+// ActiveSessionsCollector exports the number of active sessions at each scrape. The
+// count comes from the database rather than this process, so every replica reports
+// the same figure.
+type ActiveSessionsCollector struct {
+	count func(ctx context.Context) (int64, error)
+	desc  *prometheus.Desc
+}
+
+// NewActiveSessionsCollector creates a collector reporting what count returns
+func NewActiveSessionsCollector(count func(ctx context.Context) (int64, error)) *ActiveSessionsCollector {
+	return &ActiveSessionsCollector{
+		count: count,
+		desc:  prometheus.NewDesc(prometheus.BuildFQName(Namespace, "auth", "active_sessions"), "Sessions that are valid and not yet expired.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *ActiveSessionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *ActiveSessionsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), activeSessionsTimeout)
+	defer cancel()
+
+	count, err := c.count(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error counting active sessions", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
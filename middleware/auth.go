@@ -0,0 +1,186 @@
+// middleware/auth.go
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Context keys for values set by the authentication middleware
+const (
+	ContextUserKey        = "user"
+	ContextSessionKey     = "session"
+	ContextAccessTokenKey = "access_token"
+)
+
+// Authenticated rejects requests without a valid session token, taken from the Authorization
+// header or, failing that, the session cookie. Personal access tokens are accepted in the
+// Authorization header too; requests made with one have no session.
+func Authenticated(authService *services.AuthService, cookie *SessionCookie) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := BearerToken(c)
+		if services.IsAccessToken(token) {
+			authenticateAccessToken(c, authService, token)
+			return
+		}
+		if token == "" {
+			token = cookie.Token(c)
+		}
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		session, user, err := authService.ValidateSession(c.Request.Context(), token)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrUserNotFound) {
+				// Drop a stale cookie so the browser stops sending it
+				if cookie.Token(c) == token {
+					cookie.Clear(c)
+				}
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.Set(ContextSessionKey, session)
+		c.Set(ContextUserKey, user)
+		if session.ImpersonatorID != nil {
+			serveImpersonated(c, authService, session)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Helper function to authenticate a request made with a personal access token
+func authenticateAccessToken(c *gin.Context, authService *services.AuthService, value string) {
+	token, user, err := authService.ValidateAccessToken(c.Request.Context(), value, c.ClientIP())
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrIPNotAllowed):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access token cannot be used from this IP address"})
+		case errors.Is(err, services.ErrUserLocked):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Account is temporarily locked"})
+		case errors.Is(err, services.ErrInvalidToken) || errors.Is(err, services.ErrUserNotFound):
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired access token"})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		}
+		return
+	}
+
+	c.Set(ContextAccessTokenKey, token)
+	c.Set(ContextUserKey, user)
+	c.Next()
+}
+
+// BearerToken extracts the token from the Authorization header
+func BearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// CurrentUser returns the authenticated user, or nil if the request is not authenticated
+func CurrentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(ContextUserKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// CurrentSession returns the authenticated session, or nil if the request is not authenticated
+func CurrentSession(c *gin.Context) *models.Session {
+	if value, ok := c.Get(ContextSessionKey); ok {
+		if session, ok := value.(*models.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+// CurrentAccessToken returns the personal access token the request was made with,
+// or nil if it was made with a session
+func CurrentAccessToken(c *gin.Context) *models.AccessToken {
+	if value, ok := c.Get(ContextAccessTokenKey); ok {
+		if token, ok := value.(*models.AccessToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// RequireScope rejects requests made with a personal access token that lacks the scope.
+// Sessions have every scope. It must run after Authenticated.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := CurrentAccessToken(c); token != nil && !hasScope(token.Scopes, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":          "Access token is missing the required scope",
+				"required_scope": scope,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects requests made with a personal access token, for actions that
+// need an interactive login such as managing tokens. It must run after Authenticated.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if CurrentSession(c) == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action requires signing in"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Helper function to check a token's scopes
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole rejects authenticated users who do not have the given role.
+// It must run after Authenticated.
+func RequireRole(authService *services.AuthService, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		ok, err := authService.UserHasRole(c.Request.Context(), user.UserID, role)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,193 @@
+// middleware/cookie.go
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// SessionCookie holds the settings for the cookie that carries the session token,
+// and for the long-lived cookie that identifies the browser as a known device
+type SessionCookie struct {
+	Name              string
+	DeviceName        string
+	ExternalStateName string // Ties an external provider's callback to the browser that started the sign-in
+	SAMLStateName     string // Ties a SAML response to the browser that started the sign-in
+	Domain            string
+	Path              string
+	Secure            bool
+	SameSite          http.SameSite
+}
+
+// How long a device cookie lasts; it is refreshed on every login
+const deviceCookieLifetime = 365 * 24 * time.Hour
+
+// NewSessionCookie creates a new session cookie configuration with default values
+func NewSessionCookie() *SessionCookie {
+	return &SessionCookie{
+		Name:              "session",
+		DeviceName:        "device",
+		ExternalStateName: "external_login",
+		SAMLStateName:     "saml_login",
+		Path:              "/",
+		Secure:            true,
+		SameSite:          http.SameSiteLaxMode,
+	}
+}
+
+// ParseSameSite converts "strict", "lax" or "none" to an http.SameSite value
+func ParseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// Set writes the session token cookie. "Remember me" sessions get a persistent cookie
+// that lasts until the session's absolute expiry; others get a browser-session cookie.
+func (sc *SessionCookie) Set(c *gin.Context, session *models.Session) {
+	cookie := &http.Cookie{
+		Name:     sc.Name,
+		Value:    session.Token,
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: sc.SameSite,
+	}
+	if session.IsPersistent {
+		cookie.Expires = session.AbsoluteExpiresAt
+		cookie.MaxAge = int(time.Until(session.AbsoluteExpiresAt).Seconds())
+	}
+	http.SetCookie(c.Writer, cookie)
+}
+
+// Clear removes the session token cookie
+func (sc *SessionCookie) Clear(c *gin.Context) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sc.Name,
+		Value:    "",
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: sc.SameSite,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+	})
+}
+
+// Token reads the session token from the cookie
+func (sc *SessionCookie) Token(c *gin.Context) string {
+	token, err := c.Cookie(sc.Name)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// SetDeviceToken writes the device cookie
+func (sc *SessionCookie) SetDeviceToken(c *gin.Context, token string) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sc.DeviceName,
+		Value:    token,
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: sc.SameSite,
+		MaxAge:   int(deviceCookieLifetime.Seconds()),
+		Expires:  time.Now().Add(deviceCookieLifetime),
+	})
+}
+
+// DeviceToken reads the device token from the device cookie, or from the
+// X-Device-Token header for clients that do not use cookies
+func (sc *SessionCookie) DeviceToken(c *gin.Context) string {
+	if token, err := c.Cookie(sc.DeviceName); err == nil && token != "" {
+		return token
+	}
+	return c.GetHeader("X-Device-Token")
+}
+
+// SetExternalState writes the cookie holding the state of a sign-in with an external provider.
+// It is always SameSite=Lax, as it has to come back with the provider's cross-site redirect.
+func (sc *SessionCookie) SetExternalState(c *gin.Context, state string, lifetime time.Duration) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sc.ExternalStateName,
+		Value:    state,
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(lifetime.Seconds()),
+		Expires:  time.Now().Add(lifetime),
+	})
+}
+
+// ExternalState reads and clears the external sign-in state cookie
+func (sc *SessionCookie) ExternalState(c *gin.Context) string {
+	state, err := c.Cookie(sc.ExternalStateName)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sc.ExternalStateName,
+		Value:    "",
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   sc.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+	})
+	return state
+}
+
+// SetSAMLState writes the cookie holding the relay state of a SAML sign-in. The identity
+// provider posts its response back cross-site, so the cookie is SameSite=None and only
+// works over HTTPS (or on localhost).
+func (sc *SessionCookie) SetSAMLState(c *gin.Context, state string, lifetime time.Duration) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sc.SAMLStateName,
+		Value:    state,
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   int(lifetime.Seconds()),
+		Expires:  time.Now().Add(lifetime),
+	})
+}
+
+// SAMLState reads and clears the SAML sign-in state cookie
+func (sc *SessionCookie) SAMLState(c *gin.Context) string {
+	state, err := c.Cookie(sc.SAMLStateName)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sc.SAMLStateName,
+		Value:    "",
+		Domain:   sc.Domain,
+		Path:     sc.Path,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		MaxAge:   -1,
+		Expires:  time.Unix(0, 0),
+	})
+	return state
+}
@@ -0,0 +1,114 @@
+// middleware/cookie_test.go
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to run a handler against a test request and return the cookies it set
+func responseCookies(t *testing.T, handler func(c *gin.Context)) []*http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+	handler(c)
+	return w.Result().Cookies()
+}
+
+func TestSessionCookie(t *testing.T) {
+	sc := NewSessionCookie()
+	sc.Domain = "example.com"
+	sc.Path = "/app"
+	sc.SameSite = http.SameSiteStrictMode
+	absoluteExpiresAt := time.Now().Add(30 * 24 * time.Hour)
+
+	tests := []struct {
+		name        string
+		set         func(c *gin.Context)
+		wantValue   string
+		wantMaxAge  int // Sign only: positive for persistent, 0 for browser-session, negative to delete
+		wantExpires bool
+	}{
+		{
+			name:      "browser session",
+			set:       func(c *gin.Context) { sc.Set(c, &models.Session{Token: "token", AbsoluteExpiresAt: absoluteExpiresAt}) },
+			wantValue: "token",
+		},
+		{
+			name: "remember me",
+			set: func(c *gin.Context) {
+				sc.Set(c, &models.Session{Token: "token", IsPersistent: true, AbsoluteExpiresAt: absoluteExpiresAt})
+			},
+			wantValue:   "token",
+			wantMaxAge:  1,
+			wantExpires: true,
+		},
+		{
+			name:        "logout",
+			set:         sc.Clear,
+			wantMaxAge:  -1,
+			wantExpires: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cookies := responseCookies(t, tt.set)
+			if len(cookies) != 1 {
+				t.Fatalf("set %d cookies, want 1", len(cookies))
+			}
+			cookie := cookies[0]
+			if cookie.Name != "session" || cookie.Value != tt.wantValue {
+				t.Errorf("cookie %s=%q, want session=%q", cookie.Name, cookie.Value, tt.wantValue)
+			}
+			if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteStrictMode {
+				t.Errorf("HttpOnly %v, Secure %v, SameSite %v; want HttpOnly, Secure and Strict", cookie.HttpOnly, cookie.Secure, cookie.SameSite)
+			}
+			if cookie.Domain != "example.com" || cookie.Path != "/app" {
+				t.Errorf("domain %q, path %q; want the configured ones", cookie.Domain, cookie.Path)
+			}
+			switch {
+			case tt.wantMaxAge > 0 && cookie.MaxAge < int((29*24*time.Hour).Seconds()):
+				t.Errorf("MaxAge = %d, want it to last until the absolute expiry", cookie.MaxAge)
+			case tt.wantMaxAge == 0 && cookie.MaxAge != 0:
+				t.Errorf("MaxAge = %d, want a browser-session cookie", cookie.MaxAge)
+			case tt.wantMaxAge < 0 && cookie.MaxAge >= 0:
+				t.Errorf("MaxAge = %d, want the cookie deleted", cookie.MaxAge)
+			}
+			if got := !cookie.Expires.IsZero(); got != tt.wantExpires {
+				t.Errorf("Expires = %v, want set: %v", cookie.Expires, tt.wantExpires)
+			}
+		})
+	}
+}
+
+func TestSessionCookieToken(t *testing.T) {
+	sc := NewSessionCookie()
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		want   string
+	}{
+		{"no cookie", nil, ""},
+		{"session cookie", &http.Cookie{Name: "session", Value: "token"}, "token"},
+		{"other cookie", &http.Cookie{Name: "device", Value: "device-token"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cookie != nil {
+				c.Request.AddCookie(tt.cookie)
+			}
+			if got := sc.Token(c); got != tt.want {
+				t.Errorf("Token = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// middleware/csrf.go
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CSRF header and cookie names shared with the frontend
+const (
+	CSRFHeaderName = "X-CSRF-Token"
+	CSRFCookieName = "csrf_token"
+)
+
+// CSRFProtection defends cookie-authenticated requests against cross-site request forgery.
+// It uses signed double-submit tokens: the token is sent both in a cookie the frontend can
+// read and in the X-CSRF-Token header, and is signed together with the session token so a
+// token issued for one session is useless with another.
+type CSRFProtection struct {
+	secret         []byte
+	allowedOrigins map[string]bool
+	sessionCookie  *SessionCookie
+}
+
+// NewCSRFProtection creates a new CSRFProtection.
+// allowedOrigins are full origins such as "https://app.example.com"; the server's own origin is always allowed.
+func NewCSRFProtection(secret string, allowedOrigins []string, sessionCookie *SessionCookie) *CSRFProtection {
+	origins := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		if origin = normalizeOrigin(origin); origin != "" {
+			origins[origin] = true
+		}
+	}
+
+	// Derive a dedicated key so CSRF tokens never share a key with anything else
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("csrf-token-key"))
+
+	return &CSRFProtection{
+		secret:         mac.Sum(nil),
+		allowedOrigins: origins,
+		sessionCookie:  sessionCookie,
+	}
+}
+
+// Middleware checks state-changing requests. Requests authenticated only by a bearer token are
+// exempt, since browsers never attach those automatically.
+func (p *CSRFProtection) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			c.Next()
+			return
+		}
+
+		sessionToken := p.sessionCookie.Token(c)
+		if sessionToken == "" && BearerToken(c) != "" {
+			c.Next()
+			return
+		}
+
+		if !p.originAllowed(c.Request) {
+			abortCSRF(c, "Request origin is not allowed")
+			return
+		}
+
+		// Without a session cookie there is no ambient authority to abuse, e.g. on login
+		if sessionToken == "" {
+			c.Next()
+			return
+		}
+
+		headerToken := c.GetHeader(CSRFHeaderName)
+		cookieToken, _ := c.Cookie(CSRFCookieName)
+		if headerToken == "" || subtle.ConstantTimeCompare([]byte(headerToken), []byte(cookieToken)) != 1 {
+			abortCSRF(c, "Missing or mismatched CSRF token")
+			return
+		}
+		if !p.validToken(headerToken, sessionToken) {
+			abortCSRF(c, "Invalid CSRF token")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IssueToken creates a token for the current session, sets the CSRF cookie and returns the token
+func (p *CSRFProtection) IssueToken(c *gin.Context) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(nonce) + "." +
+		base64.RawURLEncoding.EncodeToString(p.sign(nonce, p.sessionCookie.Token(c)))
+
+	// Readable by scripts on purpose, so the frontend can copy it into the header
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    token,
+		Domain:   p.sessionCookie.Domain,
+		Path:     "/",
+		Secure:   p.sessionCookie.Secure,
+		HttpOnly: false,
+		SameSite: p.sessionCookie.SameSite,
+	})
+
+	return token, nil
+}
+
+// Helper function to check a token's signature against the session token
+func (p *CSRFProtection) validToken(token, sessionToken string) bool {
+	encodedNonce, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(encodedNonce)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, p.sign(nonce, sessionToken))
+}
+
+// Helper function to sign a nonce together with a hash of the session token
+func (p *CSRFProtection) sign(nonce []byte, sessionToken string) []byte {
+	sessionHash := sha256.Sum256([]byte(sessionToken))
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(nonce)
+	mac.Write(sessionHash[:])
+	return mac.Sum(nil)
+}
+
+// Helper function to check Origin, or Referer when Origin is missing, against the allow-list
+func (p *CSRFProtection) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			// Neither header was sent; fall back to the token check alone
+			return origin == ""
+		}
+		origin = referer
+	}
+
+	origin = normalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+	if p.allowedOrigins[origin] {
+		return true
+	}
+
+	// Same-origin requests are always fine
+	u, _ := url.Parse(origin)
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Helper function to reduce a URL to its scheme://host[:port] origin
+func normalizeOrigin(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host)
+}
+
+// Helper function to reject a request that failed CSRF checks
+func abortCSRF(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message, "code": "csrf_failed"})
+}
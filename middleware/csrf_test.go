@@ -0,0 +1,215 @@
+// middleware/csrf_test.go
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Helper function to build a router with CSRF protection, a route that issues tokens and
+// a state-changing route behind the check
+func newCSRFRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	csrf := NewCSRFProtection("test-secret", []string{"https://app.example.com"}, NewSessionCookie())
+	router := gin.New()
+	router.Use(csrf.Middleware())
+	router.GET("/csrf", func(c *gin.Context) {
+		token, err := csrf.IssueToken(c)
+		if err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, token)
+	})
+	router.POST("/action", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+// Helper function to issue a CSRF token for a session
+func issueCSRFToken(t *testing.T, router *gin.Engine, session string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/csrf", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: session})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("issuing token: status %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestCSRFProtection(t *testing.T) {
+	router := newCSRFRouter(t)
+	token := issueCSRFToken(t, router, "session-a")
+	otherToken := issueCSRFToken(t, router, "session-b")
+
+	tests := []struct {
+		name        string
+		method      string
+		session     string
+		cookieToken string
+		headerToken string
+		headers     map[string]string
+		wantStatus  int
+	}{
+		{
+			name:       "safe method without token",
+			method:     http.MethodGet,
+			session:    "session-a",
+			headers:    map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "matching token",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			wantStatus:  http.StatusNoContent,
+		},
+		{
+			name:       "missing token",
+			method:     http.MethodPost,
+			session:    "session-a",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:        "header differs from cookie",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: otherToken,
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "token signed for another session",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: otherToken,
+			headerToken: otherToken,
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "malformed token",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: "not-a-token",
+			headerToken: "not-a-token",
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "allowed origin",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			headers:     map[string]string{"Origin": "https://APP.example.com"},
+			wantStatus:  http.StatusNoContent,
+		},
+		{
+			name:        "same origin",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			headers:     map[string]string{"Origin": "http://example.com"},
+			wantStatus:  http.StatusNoContent,
+		},
+		{
+			name:        "cross-site origin",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			headers:     map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "cross-site referer without origin",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			headers:     map[string]string{"Referer": "https://evil.example.com/page"},
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:        "allowed referer behind a null origin",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			headers:     map[string]string{"Origin": "null", "Referer": "https://app.example.com/settings"},
+			wantStatus:  http.StatusNoContent,
+		},
+		{
+			name:        "null origin without referer",
+			method:      http.MethodPost,
+			session:     "session-a",
+			cookieToken: token,
+			headerToken: token,
+			headers:     map[string]string{"Origin": "null"},
+			wantStatus:  http.StatusForbidden,
+		},
+		{
+			name:       "cross-site origin without a session",
+			method:     http.MethodPost,
+			headers:    map[string]string{"Origin": "https://evil.example.com"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no session and no token",
+			method:     http.MethodPost,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "bearer token without a session",
+			method:     http.MethodPost,
+			headers:    map[string]string{"Authorization": "Bearer api-token", "Origin": "https://evil.example.com"},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "bearer token alongside a session cookie",
+			method:     http.MethodPost,
+			session:    "session-a",
+			headers:    map[string]string{"Authorization": "Bearer api-token"},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/action"
+			if tt.method == http.MethodGet {
+				path = "/csrf"
+			}
+			req := httptest.NewRequest(tt.method, "http://example.com"+path, nil)
+			if tt.session != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: tt.session})
+			}
+			if tt.cookieToken != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookieToken})
+			}
+			if tt.headerToken != "" {
+				req.Header.Set(CSRFHeaderName, tt.headerToken)
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
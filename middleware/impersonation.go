@@ -0,0 +1,51 @@
+// middleware/impersonation.go
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// ImpersonatedByHeader is set on responses to requests made with an impersonation session,
+// to the ID of the admin acting as the user
+const ImpersonatedByHeader = "X-Impersonated-By"
+
+// Context key marking requests RestrictImpersonation refused, which it has already audited
+const contextImpersonationBlockedKey = "impersonation_blocked"
+
+// RestrictImpersonation rejects requests made with an impersonation session, for sensitive
+// actions an admin acting as a user must not take, such as changing their password. It must
+// run after Authenticated.
+func RestrictImpersonation(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		session := CurrentSession(c)
+		if session == nil || session.ImpersonatorID == nil {
+			c.Next()
+			return
+		}
+
+		authService.AuditImpersonatedAction(c.Request.Context(), session, c.Request.Method, c.FullPath(), http.StatusForbidden, true, c.ClientIP(), c.Request.UserAgent())
+		c.Set(contextImpersonationBlockedKey, true)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This action is not allowed while impersonating a user"})
+	}
+}
+
+// Helper function to serve a request made with an impersonation session: the response is
+// marked, and anything but a read is audited once it has been handled
+func serveImpersonated(c *gin.Context, authService *services.AuthService, session *models.Session) {
+	c.Header(ImpersonatedByHeader, session.ImpersonatorID.String())
+	c.Next()
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	if c.GetBool(contextImpersonationBlockedKey) {
+		return
+	}
+	authService.AuditImpersonatedAction(c.Request.Context(), session, c.Request.Method, c.FullPath(), c.Writer.Status(), false, c.ClientIP(), c.Request.UserAgent())
+}
@@ -0,0 +1,139 @@
+// middleware/impersonation_test.go
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// Helper function to build a router that serves requests as if made with a session. Only
+// requests that need auditing reach the auth service, so these tests run without one.
+func newImpersonationRouter(session *models.Session) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(ContextSessionKey, session)
+		if session.ImpersonatorID != nil {
+			serveImpersonated(c, nil, session)
+			return
+		}
+		c.Next()
+	})
+	router.GET("/profile", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.POST("/change-password", RestrictImpersonation(nil), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func TestImpersonationMiddleware(t *testing.T) {
+	adminID := uuid.New()
+	own := &models.Session{SessionID: uuid.New(), UserID: uuid.New()}
+	impersonated := &models.Session{SessionID: uuid.New(), UserID: uuid.New(), ImpersonatorID: &adminID}
+
+	tests := []struct {
+		name       string
+		session    *models.Session
+		method     string
+		path       string
+		wantStatus int
+		wantHeader string
+	}{
+		{"own session reads", own, http.MethodGet, "/profile", http.StatusOK, ""},
+		{"own session takes a restricted action", own, http.MethodPost, "/change-password", http.StatusNoContent, ""},
+		{"impersonation reads", impersonated, http.MethodGet, "/profile", http.StatusOK, adminID.String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newImpersonationRouter(tt.session).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get(ImpersonatedByHeader); got != tt.wantHeader {
+				t.Errorf("%s = %q, want %q", ImpersonatedByHeader, got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestImpersonationMiddlewareRestrictsAndAudits(t *testing.T) {
+	pool := dbtest.New(t)
+	ctx := context.Background()
+	authService := services.NewAuthService(pool, "test-secret", 60)
+	users := models.NewUserRepository(pool)
+
+	admin := &models.User{Username: "admin", Email: "admin@example.com", IsEmailVerified: true, IsActive: true}
+	target := &models.User{Username: "bjensen", Email: "bjensen@example.com", IsEmailVerified: true, IsActive: true}
+	for _, user := range []*models.User{admin, target} {
+		if err := users.Create(ctx, user, "correct-Horse-battery-9-staple"); err != nil {
+			t.Fatalf("creating user: %v", err)
+		}
+	}
+	if err := models.NewRoleRepository(pool).Grant(ctx, admin.UserID, models.RoleAdmin); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+	login, err := authService.Login(ctx, services.LoginRequest{
+		UsernameOrEmail: "admin",
+		Password:        "correct-Horse-battery-9-staple",
+		IPAddress:       "192.0.2.1",
+		UserAgent:       "impersonation-test",
+	})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	session, _, err := authService.StartImpersonation(ctx, login.Session, target.UserID, "testing", "192.0.2.1", "impersonation-test")
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(Authenticated(authService, NewSessionCookie()))
+	router.POST("/change-password", RestrictImpersonation(authService), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.POST("/notes", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantEvent  string
+	}{
+		{"restricted action", "/change-password", http.StatusForbidden, "impersonation_action_blocked"},
+		{"allowed action", "/notes", http.StatusCreated, "impersonation_action"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+session.Token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			// Each request is recorded once, under the admin
+			var count int
+			query := `SELECT COUNT(*) FROM auth.audit_log WHERE user_id = $1 AND event_type = $2 AND details->>'route' = $3`
+			if err := pool.QueryRow(ctx, query, admin.UserID, tt.wantEvent, tt.path).Scan(&count); err != nil {
+				t.Fatalf("counting audit events: %v", err)
+			}
+			if count != 1 {
+				t.Errorf("%d %s events, want 1", count, tt.wantEvent)
+			}
+		})
+	}
+}
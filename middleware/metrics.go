@@ -0,0 +1,27 @@
+// middleware/metrics.go
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/metrics"
+)
+
+// Route label for requests that matched no route, so scanners can't create new series
+const unmatchedRoute = "unmatched"
+
+// Metrics records how long each request took in the HTTP request histogram, by gin route
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		m.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
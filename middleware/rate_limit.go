@@ -0,0 +1,148 @@
+// middleware/rate_limit.go
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit allows Requests requests per Per, refilling continuously, with bursts of up to Requests
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) refillPerSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimitKeyFunc derives the bucket key for a request.
+// Returning false skips the rule, e.g. when the request has no username.
+type RateLimitKeyFunc func(c *gin.Context) (string, bool)
+
+// RateLimitRule is a single limit applied to requests sharing a key
+type RateLimitRule struct {
+	Name  string // Distinguishes buckets of different rules with the same key
+	Limit Limit
+	Key   RateLimitKeyFunc
+}
+
+// ByIP keys requests by client IP address
+func ByIP(c *gin.Context) (string, bool) {
+	return "ip:" + c.ClientIP(), true
+}
+
+// ByBodyField keys requests by a JSON body field such as "login" or "email", case-insensitively
+func ByBodyField(field string) RateLimitKeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		value := jsonBodyField(c, field)
+		if value == "" {
+			return "", false
+		}
+		return "user:" + value, true
+	}
+}
+
+// ByIPAndBodyField keys requests by client IP address and a JSON body field together
+func ByIPAndBodyField(field string) RateLimitKeyFunc {
+	return func(c *gin.Context) (string, bool) {
+		value := jsonBodyField(c, field)
+		if value == "" {
+			return "", false
+		}
+		return "ip-user:" + c.ClientIP() + "|" + value, true
+	}
+}
+
+// RateLimit enforces token-bucket limits and reports the most restrictive one in RateLimit-* headers.
+// If the store fails the request is let through, so an outage does not lock everyone out.
+func RateLimit(store RateLimitStore, rules ...RateLimitRule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tightest *RateLimitResult
+		var tightestLimit Limit
+
+		for _, rule := range rules {
+			key, ok := rule.Key(c)
+			if !ok {
+				continue
+			}
+
+			result, err := store.Take(c.Request.Context(), rule.Name+":"+key, rule.Limit)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Error checking rate limit", "rule", rule.Name, "error", err)
+				continue
+			}
+
+			if !result.Allowed {
+				setRateLimitHeaders(c, rule.Limit, result)
+				c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"error": "Too many requests, please try again later",
+					"code":  "rate_limited",
+				})
+				return
+			}
+
+			if tightest == nil || result.Remaining < tightest.Remaining {
+				r := result
+				tightest = &r
+				tightestLimit = rule.Limit
+			}
+		}
+
+		if tightest != nil {
+			setRateLimitHeaders(c, tightestLimit, *tightest)
+		}
+
+		c.Next()
+	}
+}
+
+// Helper function to set the RateLimit-* headers from the IETF RateLimit header fields draft
+func setRateLimitHeaders(c *gin.Context, limit Limit, result RateLimitResult) {
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Per)))
+}
+
+// Helper function to round a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// Helper function to read a string field from a JSON body without consuming it
+func jsonBodyField(c *gin.Context, field string) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+
+	// Put back what was read in front of anything left, so the handler still sees the whole body
+	original := c.Request.Body
+	body, err := io.ReadAll(io.LimitReader(original, 1<<20))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), original), original}
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+
+	value, _ := fields[field].(string)
+	return strings.ToLower(strings.TrimSpace(value))
+}
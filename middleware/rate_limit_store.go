@@ -0,0 +1,136 @@
+// middleware/rate_limit_store.go
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int           // Whole tokens left in the bucket
+	ResetAfter time.Duration // Time until the bucket is full again
+	RetryAfter time.Duration // Time until the next token is available, when not allowed
+}
+
+// RateLimitStore holds token buckets
+type RateLimitStore interface {
+	// Take refills the bucket for key and tries to remove one token from it
+	Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error)
+	// Cleanup drops buckets that have been idle long enough to be full again
+	Cleanup(ctx context.Context) error
+}
+
+// MemoryRateLimitStore keeps token buckets in process memory.
+// Limits are per replica, so use PostgresRateLimitStore when running more than one.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+// NewMemoryRateLimitStore creates a new MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take removes a token from the bucket for key if one is available
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	rate := limit.refillPerSecond()
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &memoryBucket{tokens: capacity, updatedAt: now}
+		s.buckets[key] = bucket
+	}
+
+	// Refill for the time since the last request
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updatedAt).Seconds()*rate)
+	bucket.updatedAt = now
+
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	bucket.fullAt = now.Add(secondsToDuration((capacity - bucket.tokens) / rate))
+
+	return newRateLimitResult(bucket.tokens, allowed, limit), nil
+}
+
+// Cleanup drops buckets that are full again
+func (s *MemoryRateLimitStore) Cleanup(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+	return nil
+}
+
+// PostgresRateLimitStore keeps token buckets in Postgres so limits are shared across replicas
+type PostgresRateLimitStore struct {
+	repo    *models.RateLimitRepository
+	maxIdle time.Duration
+}
+
+// NewPostgresRateLimitStore creates a new PostgresRateLimitStore.
+// maxIdle should be at least as long as the slowest bucket takes to refill.
+func NewPostgresRateLimitStore(repo *models.RateLimitRepository, maxIdle time.Duration) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{repo: repo, maxIdle: maxIdle}
+}
+
+// Take removes a token from the bucket for key if one is available
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, limit Limit) (RateLimitResult, error) {
+	tokens, allowed, err := s.repo.Take(ctx, key, float64(limit.Requests), limit.refillPerSecond())
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return newRateLimitResult(tokens, allowed, limit), nil
+}
+
+// Cleanup deletes buckets that have been idle for longer than maxIdle
+func (s *PostgresRateLimitStore) Cleanup(ctx context.Context) error {
+	_, err := s.repo.DeleteIdle(ctx, time.Now().Add(-s.maxIdle))
+	return err
+}
+
+// Helper function to build a result from the tokens left in a bucket
+func newRateLimitResult(tokens float64, allowed bool, limit Limit) RateLimitResult {
+	rate := limit.refillPerSecond()
+	result := RateLimitResult{
+		Allowed:    allowed,
+		Remaining:  int(math.Floor(tokens)),
+		ResetAfter: secondsToDuration((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return result
+}
+
+// Helper function to convert fractional seconds to a duration
+func secondsToDuration(seconds float64) time.Duration {
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,83 @@
+// middleware/request_id.go
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/logging"
+)
+
+// RequestIDHeader carries the ID that correlates a request's log lines and audit entries
+const RequestIDHeader = "X-Request-ID"
+
+// ContextRequestIDKey is the gin context key of the request ID
+const ContextRequestIDKey = "request_id"
+
+// Longest request ID accepted from a client or proxy
+const maxRequestIDLength = 128
+
+// RequestID propagates the X-Request-ID header of a request, or assigns a new ID when it
+// is missing or malformed. The ID is echoed in the response and attached to the request's
+// context, where the logger and the audit log pick it up.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Header(RequestIDHeader, requestID)
+		c.Set(ContextRequestIDKey, requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Next()
+	}
+}
+
+// RequestLogger logs every request once it has been handled. The query string is left out
+// since it may carry tokens.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+		args := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+		}
+		if errs := c.Errors.ByType(gin.ErrorTypePrivate); len(errs) > 0 {
+			args = append(args, "error", errs.String())
+		}
+		slog.Log(c.Request.Context(), level, "Request handled", args...)
+	}
+}
+
+// Helper function to check that a request ID is safe to log and echo back: printable
+// ASCII without spaces, of a sensible length
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+// middleware/scim.go
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// SCIMAuthenticated rejects requests to the SCIM API that don't carry a provisioning
+// client's bearer token: a personal access token with the scim scope, belonging to an
+// admin. Errors are written in the SCIM format clients expect.
+func SCIMAuthenticated(authService *services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, user, err := authService.AuthenticateSCIMClient(c.Request.Context(), BearerToken(c), c.ClientIP())
+		if err != nil {
+			var scimErr *services.SCIMError
+			switch {
+			case errors.As(err, &scimErr):
+			case errors.Is(err, services.ErrSCIMDisabled):
+				scimErr = &services.SCIMError{Status: http.StatusNotFound, Detail: "SCIM provisioning is not enabled"}
+			default:
+				slog.ErrorContext(c.Request.Context(), "Error authenticating SCIM client", "error", err)
+				scimErr = &services.SCIMError{Status: http.StatusInternalServerError, Detail: "Internal server error"}
+			}
+			if scimErr.Status == http.StatusUnauthorized {
+				c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			}
+			c.Header("Content-Type", services.SCIMContentType)
+			c.AbortWithStatusJSON(scimErr.Status, scimErr)
+			return
+		}
+
+		c.Set(ContextAccessTokenKey, token)
+		c.Set(ContextUserKey, user)
+		c.Next()
+	}
+}
@@ -0,0 +1,163 @@
+// models/access_token.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AccessToken represents a personal access token from the auth.access_tokens table
+type AccessToken struct {
+	TokenID     uuid.UUID  `json:"token_id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Name        string     `json:"name"`
+	TokenPrefix string     `json:"token_prefix"`
+	TokenHash   string     `json:"-"`
+	Scopes      []string   `json:"scopes"`
+	AllowedIPs  []string   `json:"allowed_ips"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP  *string    `json:"last_used_ip,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// AccessTokenRepository handles database operations for personal access tokens
+type AccessTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAccessTokenRepository creates a new AccessTokenRepository
+func NewAccessTokenRepository(pool *pgxpool.Pool) *AccessTokenRepository {
+	return &AccessTokenRepository{pool: pool}
+}
+
+// Create adds a new access token to the database
+func (r *AccessTokenRepository) Create(ctx context.Context, token *AccessToken) error {
+	if token.TokenID == uuid.Nil {
+		token.TokenID = uuid.New()
+	}
+	if token.Scopes == nil {
+		token.Scopes = []string{}
+	}
+	if token.AllowedIPs == nil {
+		token.AllowedIPs = []string{}
+	}
+
+	query := `
+		INSERT INTO auth.access_tokens (
+			token_id, user_id, name, token_prefix, token_hash, scopes, allowed_ips, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		token.TokenID, token.UserID, token.Name, token.TokenPrefix, token.TokenHash,
+		token.Scopes, token.AllowedIPs, token.ExpiresAt,
+	)
+
+	return row.Scan(&token.CreatedAt)
+}
+
+// GetByTokenHash retrieves an access token by the hash of its value
+func (r *AccessTokenRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*AccessToken, error) {
+	query := `
+		SELECT
+			token_id, user_id, name, token_prefix, token_hash, scopes, allowed_ips,
+			expires_at, last_used_at, last_used_ip, created_at, revoked_at
+		FROM auth.access_tokens
+		WHERE token_hash = $1`
+
+	var token AccessToken
+	err := scanAccessToken(r.pool.QueryRow(ctx, query, tokenHash), &token)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Token not found
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// GetAllByUserID retrieves a user's tokens that have not been revoked, newest first
+func (r *AccessTokenRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID) ([]*AccessToken, error) {
+	query := `
+		SELECT
+			token_id, user_id, name, token_prefix, token_hash, scopes, allowed_ips,
+			expires_at, last_used_at, last_used_ip, created_at, revoked_at
+		FROM auth.access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*AccessToken
+	for rows.Next() {
+		var token AccessToken
+		if err := scanAccessToken(rows, &token); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, &token)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Revoke revokes one of a user's tokens. Returns false if the user has no such active token.
+func (r *AccessTokenRepository) Revoke(ctx context.Context, userID, tokenID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE auth.access_tokens SET
+			revoked_at = NOW()
+		WHERE token_id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	tag, err := r.pool.Exec(ctx, query, tokenID, userID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RecordUse updates a token's last-used time and address, at most once per minInterval
+func (r *AccessTokenRepository) RecordUse(ctx context.Context, tokenID uuid.UUID, ipAddress string, minInterval time.Duration) error {
+	query := `
+		UPDATE auth.access_tokens SET
+			last_used_at = NOW(),
+			last_used_ip = $2
+		WHERE token_id = $1
+		AND (last_used_at IS NULL OR last_used_at <= NOW() - make_interval(secs => $3::float8) OR last_used_ip IS DISTINCT FROM $2)`
+
+	_, err := r.pool.Exec(ctx, query, tokenID, ipAddress, minInterval.Seconds())
+	return err
+}
+
+// Helper function to scan an access token from a row
+func scanAccessToken(row pgx.Row, token *AccessToken) error {
+	return row.Scan(
+		&token.TokenID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenPrefix,
+		&token.TokenHash,
+		&token.Scopes,
+		&token.AllowedIPs,
+		&token.ExpiresAt,
+		&token.LastUsedAt,
+		&token.LastUsedIP,
+		&token.CreatedAt,
+		&token.RevokedAt,
+	)
+}
@@ -0,0 +1,129 @@
+// models/account_deletion.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AccountDeletion is a user's request to delete their account, from the auth.account_deletions table
+type AccountDeletion struct {
+	UserID          uuid.UUID `json:"-"`
+	CancelTokenHash string    `json:"-"`
+	RequestedAt     time.Time `json:"requested_at"`
+	DeleteAfter     time.Time `json:"delete_after"` // End of the grace period, when the account is deleted
+}
+
+// AccountDeletionRepository handles database operations for account deletion requests
+type AccountDeletionRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewAccountDeletionRepository creates a new AccountDeletionRepository
+func NewAccountDeletionRepository(pool *pgxpool.Pool) *AccountDeletionRepository {
+	return &AccountDeletionRepository{pool: pool}
+}
+
+// Create records a deletion request. It reports false, leaving the existing request alone,
+// if the user has already asked for their account to be deleted.
+func (r *AccountDeletionRepository) Create(ctx context.Context, deletion *AccountDeletion) (bool, error) {
+	query := `
+		INSERT INTO auth.account_deletions (user_id, cancel_token_hash, delete_after)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING requested_at`
+
+	err := r.pool.QueryRow(ctx, query, deletion.UserID, deletion.CancelTokenHash, deletion.DeleteAfter).Scan(&deletion.RequestedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil // Already requested
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetByUserID retrieves a user's pending deletion request, or nil if there is none
+func (r *AccountDeletionRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (*AccountDeletion, error) {
+	query := `
+		SELECT user_id, cancel_token_hash, requested_at, delete_after
+		FROM auth.account_deletions
+		WHERE user_id = $1`
+
+	var deletion AccountDeletion
+	err := r.pool.QueryRow(ctx, query, userID).Scan(&deletion.UserID, &deletion.CancelTokenHash, &deletion.RequestedAt, &deletion.DeleteAfter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // No pending deletion
+		}
+		return nil, err
+	}
+
+	return &deletion, nil
+}
+
+// Cancel removes a user's pending deletion request, reporting whether there was one
+func (r *AccountDeletionRepository) Cancel(ctx context.Context, userID uuid.UUID) (bool, error) {
+	query := `DELETE FROM auth.account_deletions WHERE user_id = $1`
+	result, err := r.pool.Exec(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// CancelByTokenHash removes the pending deletion request a cancellation token is for and
+// returns it, or nil if there is none
+func (r *AccountDeletionRepository) CancelByTokenHash(ctx context.Context, tokenHash string) (*AccountDeletion, error) {
+	query := `
+		DELETE FROM auth.account_deletions
+		WHERE cancel_token_hash = $1
+		RETURNING user_id, cancel_token_hash, requested_at, delete_after`
+
+	var deletion AccountDeletion
+	err := r.pool.QueryRow(ctx, query, tokenHash).Scan(&deletion.UserID, &deletion.CancelTokenHash, &deletion.RequestedAt, &deletion.DeleteAfter)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Unknown or already cancelled
+		}
+		return nil, err
+	}
+
+	return &deletion, nil
+}
+
+// GetDue retrieves the deletion requests whose grace period has ended
+func (r *AccountDeletionRepository) GetDue(ctx context.Context, now time.Time) ([]*AccountDeletion, error) {
+	query := `
+		SELECT user_id, cancel_token_hash, requested_at, delete_after
+		FROM auth.account_deletions
+		WHERE delete_after <= $1
+		ORDER BY delete_after`
+
+	rows, err := r.pool.Query(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []*AccountDeletion
+	for rows.Next() {
+		var deletion AccountDeletion
+		if err := rows.Scan(&deletion.UserID, &deletion.CancelTokenHash, &deletion.RequestedAt, &deletion.DeleteAfter); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, &deletion)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deletions, nil
+}
@@ -7,12 +7,14 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/loganmanery/go-react-app/logging"
 )
 
 // AuditLog represents an entry in the auth.audit_log table
 type AuditLog struct {
 	LogID     uuid.UUID              `json:"log_id"`
-	UserID    uuid.UUID              `json:"user_id,omitempty"`
+	UserID    uuid.UUID              `json:"user_id,omitempty"` // uuid.Nil once anonymised, after the user was deleted
 	EventType string                 `json:"event_type"`
 	IPAddress string                 `json:"ip_address,omitempty"`
 	UserAgent string                 `json:"user_agent,omitempty"`
@@ -30,6 +32,22 @@ func NewAuditLogRepository(pool *pgxpool.Pool) *AuditLogRepository {
 	return &AuditLogRepository{pool: pool}
 }
 
+// DetailsWithRequestID returns the entry's details with the ID of the request in ctx
+// added, so the entry can be matched with the request's log lines. The details are
+// copied rather than modified.
+func (log *AuditLog) DetailsWithRequestID(ctx context.Context) map[string]interface{} {
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		return log.Details
+	}
+	details := make(map[string]interface{}, len(log.Details)+1)
+	for key, value := range log.Details {
+		details[key] = value
+	}
+	details["request_id"] = requestID
+	return details
+}
+
 // Create adds a new audit log entry
 func (r *AuditLogRepository) Create(ctx context.Context, log *AuditLog) error {
 	// Generate a new UUID if not provided
@@ -42,12 +60,14 @@ func (r *AuditLogRepository) Create(ctx context.Context, log *AuditLog) error {
 		log.CreatedAt = time.Now()
 	}
 
+	log.Details = log.DetailsWithRequestID(ctx)
+
 	// SQL query
 	query := `
 		INSERT INTO auth.audit_log (
 			log_id, user_id, event_type, ip_address, user_agent, details
 		) VALUES (
-			$1, $2, $3, $4, $5, $6
+			$1, NULLIF($2, '00000000-0000-0000-0000-000000000000'::uuid), $3, $4, $5, $6
 		) RETURNING log_id, created_at`
 
 	// Execute query
@@ -64,7 +84,7 @@ func (r *AuditLogRepository) Create(ctx context.Context, log *AuditLog) error {
 func (r *AuditLogRepository) GetByID(ctx context.Context, logID uuid.UUID) (*AuditLog, error) {
 	query := `
 		SELECT 
-			log_id, user_id, event_type, ip_address, user_agent, details, created_at
+			log_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'), event_type, ip_address, user_agent, details, created_at
 		FROM auth.audit_log
 		WHERE log_id = $1`
 
@@ -90,7 +110,7 @@ func (r *AuditLogRepository) GetByID(ctx context.Context, logID uuid.UUID) (*Aud
 func (r *AuditLogRepository) GetByUserID(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*AuditLog, error) {
 	query := `
 		SELECT 
-			log_id, user_id, event_type, ip_address, user_agent, details, created_at
+			log_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'), event_type, ip_address, user_agent, details, created_at
 		FROM auth.audit_log
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -131,7 +151,7 @@ func (r *AuditLogRepository) GetByUserID(ctx context.Context, userID uuid.UUID,
 func (r *AuditLogRepository) GetByEventType(ctx context.Context, eventType string, limit, offset int) ([]*AuditLog, error) {
 	query := `
 		SELECT 
-			log_id, user_id, event_type, ip_address, user_agent, details, created_at
+			log_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'), event_type, ip_address, user_agent, details, created_at
 		FROM auth.audit_log
 		WHERE event_type = $1
 		ORDER BY created_at DESC
@@ -172,7 +192,7 @@ func (r *AuditLogRepository) GetByEventType(ctx context.Context, eventType strin
 func (r *AuditLogRepository) List(ctx context.Context, limit, offset int) ([]*AuditLog, error) {
 	query := `
 		SELECT 
-			log_id, user_id, event_type, ip_address, user_agent, details, created_at
+			log_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'), event_type, ip_address, user_agent, details, created_at
 		FROM auth.audit_log
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2`
@@ -225,3 +245,58 @@ func (r *AuditLogRepository) DeleteOlderThan(ctx context.Context, olderThan time
 	}
 	return result.RowsAffected(), nil
 }
+
+// CountOlderThan counts the audit log entries DeleteOlderThan would delete
+func (r *AuditLogRepository) CountOlderThan(ctx context.Context, olderThan time.Time) (int64, error) {
+	var count int64
+	query := `SELECT COUNT(*) FROM auth.audit_log WHERE created_at < $1`
+	err := r.pool.QueryRow(ctx, query, olderThan).Scan(&count)
+	return count, err
+}
+
+// Export calls fn for every audit log entry created in [since, until), oldest first.
+// A zero since or until leaves that end open.
+func (r *AuditLogRepository) Export(ctx context.Context, since, until time.Time, fn func(*AuditLog) error) error {
+	query := `
+		SELECT 
+			log_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'), event_type, ip_address, user_agent, details, created_at
+		FROM auth.audit_log
+		WHERE ($1::timestamptz IS NULL OR created_at >= $1)
+		AND ($2::timestamptz IS NULL OR created_at < $2)
+		ORDER BY created_at, log_id`
+
+	rows, err := r.pool.Query(ctx, query, nullTime(since), nullTime(until))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var log AuditLog
+		err := rows.Scan(
+			&log.LogID,
+			&log.UserID,
+			&log.EventType,
+			&log.IPAddress,
+			&log.UserAgent,
+			&log.Details,
+			&log.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err := fn(&log); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Helper function to pass a zero time as NULL
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,183 @@
+// models/device.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Device statuses
+const (
+	DeviceStatusNew      = "new"      // Seen before, but not confirmed by the user
+	DeviceStatusTrusted  = "trusted"  // Confirmed by the user as theirs
+	DeviceStatusReported = "reported" // Reported by the user as not theirs
+)
+
+// UserDevice represents a device a user has signed in from, from the auth.user_devices table
+type UserDevice struct {
+	DeviceID    uuid.UUID `json:"device_id"`
+	UserID      uuid.UUID `json:"user_id"`
+	TokenHash   string    `json:"-"`
+	Browser     string    `json:"browser"`
+	OS          string    `json:"os"`
+	DeviceType  string    `json:"device_type"`
+	Status      string    `json:"status"`
+	LastIP      string    `json:"last_ip,omitempty"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// DeviceRepository handles database operations for user devices
+type DeviceRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewDeviceRepository creates a new DeviceRepository
+func NewDeviceRepository(pool *pgxpool.Pool) *DeviceRepository {
+	return &DeviceRepository{pool: pool}
+}
+
+// Create adds a new device to the database
+func (r *DeviceRepository) Create(ctx context.Context, device *UserDevice) error {
+	if device.DeviceID == uuid.Nil {
+		device.DeviceID = uuid.New()
+	}
+	if device.Status == "" {
+		device.Status = DeviceStatusNew
+	}
+
+	query := `
+		INSERT INTO auth.user_devices (
+			device_id, user_id, token_hash, browser, os, device_type, status, last_ip
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		) RETURNING first_seen_at, last_seen_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		device.DeviceID, device.UserID, device.TokenHash, device.Browser,
+		device.OS, device.DeviceType, device.Status, device.LastIP,
+	)
+
+	return row.Scan(&device.FirstSeenAt, &device.LastSeenAt)
+}
+
+// GetByID retrieves a device by ID
+func (r *DeviceRepository) GetByID(ctx context.Context, deviceID uuid.UUID) (*UserDevice, error) {
+	query := `
+		SELECT
+			device_id, user_id, token_hash, browser, os, device_type,
+			status, last_ip, first_seen_at, last_seen_at
+		FROM auth.user_devices
+		WHERE device_id = $1`
+
+	var device UserDevice
+	err := scanDevice(r.pool.QueryRow(ctx, query, deviceID), &device)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Device not found
+		}
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetByTokenHash retrieves a user's device by the hash of its device token
+func (r *DeviceRepository) GetByTokenHash(ctx context.Context, userID uuid.UUID, tokenHash string) (*UserDevice, error) {
+	query := `
+		SELECT
+			device_id, user_id, token_hash, browser, os, device_type,
+			status, last_ip, first_seen_at, last_seen_at
+		FROM auth.user_devices
+		WHERE user_id = $1 AND token_hash = $2`
+
+	var device UserDevice
+	err := scanDevice(r.pool.QueryRow(ctx, query, userID, tokenHash), &device)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Device not found
+		}
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// GetAllByUserID retrieves all devices for a user, most recently seen first
+func (r *DeviceRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID) ([]*UserDevice, error) {
+	query := `
+		SELECT
+			device_id, user_id, token_hash, browser, os, device_type,
+			status, last_ip, first_seen_at, last_seen_at
+		FROM auth.user_devices
+		WHERE user_id = $1
+		ORDER BY last_seen_at DESC`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []*UserDevice
+	for rows.Next() {
+		var device UserDevice
+		if err := scanDevice(rows, &device); err != nil {
+			return nil, err
+		}
+		devices = append(devices, &device)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// RecordSeen updates a device's details after a sign-in from it
+func (r *DeviceRepository) RecordSeen(ctx context.Context, device *UserDevice) error {
+	query := `
+		UPDATE auth.user_devices SET
+			browser = $1,
+			os = $2,
+			device_type = $3,
+			last_ip = $4,
+			last_seen_at = NOW()
+		WHERE device_id = $5
+		RETURNING last_seen_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		device.Browser, device.OS, device.DeviceType, device.LastIP, device.DeviceID,
+	)
+
+	return row.Scan(&device.LastSeenAt)
+}
+
+// UpdateStatus changes a device's status
+func (r *DeviceRepository) UpdateStatus(ctx context.Context, deviceID uuid.UUID, status string) error {
+	query := `UPDATE auth.user_devices SET status = $1 WHERE device_id = $2`
+	_, err := r.pool.Exec(ctx, query, status, deviceID)
+	return err
+}
+
+// Helper function to scan a device from a row
+func scanDevice(row pgx.Row, device *UserDevice) error {
+	return row.Scan(
+		&device.DeviceID,
+		&device.UserID,
+		&device.TokenHash,
+		&device.Browser,
+		&device.OS,
+		&device.DeviceType,
+		&device.Status,
+		&device.LastIP,
+		&device.FirstSeenAt,
+		&device.LastSeenAt,
+	)
+}
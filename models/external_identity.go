@@ -0,0 +1,194 @@
+// models/external_identity.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// ExternalIdentity links a user to their account at an external identity provider,
+// from the auth.external_identities table
+type ExternalIdentity struct {
+	IdentityID  uuid.UUID  `json:"identity_id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// ExternalLogin is a sign-in waiting for the external provider's callback,
+// from the auth.external_logins table
+type ExternalLogin struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ReturnTo     string
+	RememberMe   bool
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// ExternalIdentityRepository handles database operations for external identities
+// and the sign-ins that create them
+type ExternalIdentityRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewExternalIdentityRepository creates a new ExternalIdentityRepository
+func NewExternalIdentityRepository(pool *pgxpool.Pool) *ExternalIdentityRepository {
+	return &ExternalIdentityRepository{pool: pool}
+}
+
+// Create links an external identity to a user
+func (r *ExternalIdentityRepository) Create(ctx context.Context, identity *ExternalIdentity) error {
+	if identity.IdentityID == uuid.Nil {
+		identity.IdentityID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO auth.external_identities (
+			identity_id, user_id, provider, subject, email
+		) VALUES (
+			$1, $2, $3, $4, $5
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		identity.IdentityID, identity.UserID, identity.Provider, identity.Subject, identity.Email,
+	)
+
+	return row.Scan(&identity.CreatedAt)
+}
+
+// GetByProviderSubject retrieves the identity with a provider's subject identifier
+func (r *ExternalIdentityRepository) GetByProviderSubject(ctx context.Context, provider, subject string) (*ExternalIdentity, error) {
+	query := `
+		SELECT identity_id, user_id, provider, subject, email, created_at, last_login_at
+		FROM auth.external_identities
+		WHERE provider = $1 AND subject = $2`
+
+	var identity ExternalIdentity
+	err := scanExternalIdentity(r.pool.QueryRow(ctx, query, provider, subject), &identity)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Identity not found
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
+
+// GetAllByUserID retrieves every external identity linked to a user
+func (r *ExternalIdentityRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID) ([]*ExternalIdentity, error) {
+	query := `
+		SELECT identity_id, user_id, provider, subject, email, created_at, last_login_at
+		FROM auth.external_identities
+		WHERE user_id = $1
+		ORDER BY created_at`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []*ExternalIdentity
+	for rows.Next() {
+		var identity ExternalIdentity
+		if err := scanExternalIdentity(rows, &identity); err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+// RecordLogin updates an identity's last sign-in time and the email the provider reported
+func (r *ExternalIdentityRepository) RecordLogin(ctx context.Context, identityID uuid.UUID, email string) error {
+	query := `
+		UPDATE auth.external_identities SET
+			last_login_at = NOW(),
+			email = $2
+		WHERE identity_id = $1`
+
+	_, err := r.pool.Exec(ctx, query, identityID, email)
+	return err
+}
+
+// CreateLogin stores a sign-in that is being sent to an external provider
+func (r *ExternalIdentityRepository) CreateLogin(ctx context.Context, login *ExternalLogin) error {
+	query := `
+		INSERT INTO auth.external_logins (
+			state_hash, provider, nonce, code_verifier, return_to, remember_me, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		login.StateHash, login.Provider, login.Nonce, login.CodeVerifier,
+		login.ReturnTo, login.RememberMe, login.ExpiresAt,
+	)
+
+	return row.Scan(&login.CreatedAt)
+}
+
+// ConsumeLogin removes and returns the pending sign-in with a state, so each state can
+// only be used once. Returns nil if there is no such sign-in or it has expired.
+func (r *ExternalIdentityRepository) ConsumeLogin(ctx context.Context, stateHash string) (*ExternalLogin, error) {
+	query := `
+		DELETE FROM auth.external_logins
+		WHERE state_hash = $1
+		RETURNING state_hash, provider, nonce, code_verifier, return_to, remember_me, expires_at, created_at`
+
+	var login ExternalLogin
+	err := r.pool.QueryRow(ctx, query, stateHash).Scan(
+		&login.StateHash, &login.Provider, &login.Nonce, &login.CodeVerifier,
+		&login.ReturnTo, &login.RememberMe, &login.ExpiresAt, &login.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if time.Now().After(login.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &login, nil
+}
+
+// DeleteExpiredLogins removes sign-ins whose provider never called back
+func (r *ExternalIdentityRepository) DeleteExpiredLogins(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM auth.external_logins WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Helper function to scan an external identity from a row
+func scanExternalIdentity(row pgx.Row, identity *ExternalIdentity) error {
+	return row.Scan(
+		&identity.IdentityID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+}
@@ -0,0 +1,200 @@
+// models/filter.go
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidFilter is returned when a filter compares a field it cannot, or with a value of the wrong type
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// Filter operators. And, or and not combine other filters; the rest compare a field with a value.
+const (
+	FilterAnd            = "and"
+	FilterOr             = "or"
+	FilterNot            = "not"
+	FilterEqual          = "eq"
+	FilterNotEqual       = "ne"
+	FilterContains       = "co"
+	FilterStartsWith     = "sw"
+	FilterEndsWith       = "ew"
+	FilterPresent        = "pr"
+	FilterGreater        = "gt"
+	FilterGreaterOrEqual = "ge"
+	FilterLess           = "lt"
+	FilterLessOrEqual    = "le"
+)
+
+// Filter is a condition for searching records, in the shape of a SCIM filter expression
+type Filter struct {
+	Op       string
+	Field    string      // Field compared, for comparisons
+	Value    interface{} // A string, bool, float64 or nil; times are given as RFC 3339 strings
+	Operands []*Filter   // Filters combined by and, or and not
+}
+
+// Kinds of field a filter can compare
+type filterKind int
+
+const (
+	filterText      filterKind = iota // Text compared case-insensitively
+	filterExactText                   // Text compared as-is
+	filterBool
+	filterTime
+)
+
+// filterField describes how a field a filter can compare maps onto SQL
+type filterField struct {
+	column string
+	kind   filterKind
+	exists string // For fields of related rows, a subquery finding them; the comparison is added to its WHERE clause
+}
+
+// filterBuilder turns filters into a WHERE clause, collecting the query arguments as it goes
+type filterBuilder struct {
+	fields map[string]filterField
+	args   []interface{}
+}
+
+// Helper function to build the SQL condition for a filter
+func (b *filterBuilder) build(filter *Filter) (string, error) {
+	if filter == nil {
+		return "true", nil
+	}
+
+	switch filter.Op {
+	case FilterAnd, FilterOr:
+		if len(filter.Operands) == 0 {
+			return "", fmt.Errorf("%w: %s needs operands", ErrInvalidFilter, filter.Op)
+		}
+		parts := make([]string, 0, len(filter.Operands))
+		for _, operand := range filter.Operands {
+			part, err := b.build(operand)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, "("+part+")")
+		}
+		return strings.Join(parts, " "+strings.ToUpper(filter.Op)+" "), nil
+	case FilterNot:
+		if len(filter.Operands) != 1 {
+			return "", fmt.Errorf("%w: not needs one operand", ErrInvalidFilter)
+		}
+		part, err := b.build(filter.Operands[0])
+		if err != nil {
+			return "", err
+		}
+		// Comparisons with a missing value are NULL, and NOT NULL would drop the row too
+		return "(" + part + ") IS NOT TRUE", nil
+	}
+
+	field, ok := b.fields[filter.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: cannot filter on %s", ErrInvalidFilter, filter.Field)
+	}
+
+	condition, err := b.compare(field, filter)
+	if err != nil {
+		return "", err
+	}
+	if field.exists != "" {
+		return "EXISTS (" + field.exists + " AND " + condition + ")", nil
+	}
+	return condition, nil
+}
+
+// Helper function to build the SQL comparison of one field with a value
+func (b *filterBuilder) compare(field filterField, filter *Filter) (string, error) {
+	column := field.column
+
+	if filter.Op == FilterPresent {
+		if field.kind == filterText || field.kind == filterExactText {
+			return column + " IS NOT NULL AND " + column + " <> ''", nil
+		}
+		return column + " IS NOT NULL", nil
+	}
+
+	var value interface{}
+	switch field.kind {
+	case filterText, filterExactText:
+		text, ok := filter.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("%w: %s must be compared with a string", ErrInvalidFilter, filter.Field)
+		}
+		value = text
+	case filterBool:
+		flag, ok := filter.Value.(bool)
+		if !ok {
+			return "", fmt.Errorf("%w: %s must be compared with true or false", ErrInvalidFilter, filter.Field)
+		}
+		if filter.Op != FilterEqual && filter.Op != FilterNotEqual {
+			return "", fmt.Errorf("%w: %s only supports eq and ne", ErrInvalidFilter, filter.Field)
+		}
+		value = flag
+	case filterTime:
+		text, ok := filter.Value.(string)
+		if !ok {
+			return "", fmt.Errorf("%w: %s must be compared with a date and time", ErrInvalidFilter, filter.Field)
+		}
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s is not a valid date and time", ErrInvalidFilter, text)
+		}
+		value = t
+	}
+
+	// Substring matches are LIKE patterns, so wildcards in the value must be escaped
+	text, isText := value.(string)
+	switch filter.Op {
+	case FilterContains, FilterStartsWith, FilterEndsWith:
+		if !isText {
+			return "", fmt.Errorf("%w: %s only supports comparisons", ErrInvalidFilter, filter.Field)
+		}
+		pattern := likeEscaper.Replace(text)
+		switch filter.Op {
+		case FilterContains:
+			pattern = "%" + pattern + "%"
+		case FilterStartsWith:
+			pattern = pattern + "%"
+		case FilterEndsWith:
+			pattern = "%" + pattern
+		}
+		operator := "LIKE"
+		if field.kind == filterText {
+			operator = "ILIKE"
+		}
+		return column + " " + operator + " " + b.arg(pattern), nil
+	}
+
+	operator, ok := filterComparisons[filter.Op]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown operator %s", ErrInvalidFilter, filter.Op)
+	}
+	placeholder := b.arg(value)
+	if field.kind == filterText {
+		column, placeholder = "lower("+column+")", "lower("+placeholder+")"
+	}
+	return column + " " + operator + " " + placeholder, nil
+}
+
+// Helper function to add a query argument and return its placeholder
+func (b *filterBuilder) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+// SQL operators for the comparison filters
+var filterComparisons = map[string]string{
+	FilterEqual:          "=",
+	FilterNotEqual:       "IS DISTINCT FROM",
+	FilterGreater:        ">",
+	FilterGreaterOrEqual: ">=",
+	FilterLess:           "<",
+	FilterLessOrEqual:    "<=",
+}
+
+// Escapes the LIKE wildcards in a value, using the default backslash escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
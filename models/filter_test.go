@@ -0,0 +1,121 @@
+// models/filter_test.go
+package models
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterBuilderBuildsUserConditions(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		filter    *Filter
+		wantWhere string
+		wantArgs  []interface{}
+	}{
+		{
+			name:      "no filter",
+			filter:    nil,
+			wantWhere: "true",
+		},
+		{
+			name:      "eq on text is case-insensitive",
+			filter:    &Filter{Op: FilterEqual, Field: UserFieldUsername, Value: "BJensen"},
+			wantWhere: "lower(u.username) = lower($1)",
+			wantArgs:  []interface{}{"BJensen"},
+		},
+		{
+			name:      "eq on exact text",
+			filter:    &Filter{Op: FilterEqual, Field: UserFieldID, Value: "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"},
+			wantWhere: "u.user_id::text = $1",
+			wantArgs:  []interface{}{"5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"},
+		},
+		{
+			name:      "co escapes LIKE wildcards",
+			filter:    &Filter{Op: FilterContains, Field: UserFieldEmail, Value: `50%_off\`},
+			wantWhere: "u.email ILIKE $1",
+			wantArgs:  []interface{}{`%50\%\_off\\%`},
+		},
+		{
+			name:      "sw",
+			filter:    &Filter{Op: FilterStartsWith, Field: UserFieldLastName, Value: "Jen"},
+			wantWhere: "u.last_name ILIKE $1",
+			wantArgs:  []interface{}{"Jen%"},
+		},
+		{
+			name:      "pr on text ignores empty values",
+			filter:    &Filter{Op: FilterPresent, Field: UserFieldFirstName},
+			wantWhere: "u.first_name IS NOT NULL AND u.first_name <> ''",
+		},
+		{
+			name:      "gt on a time",
+			filter:    &Filter{Op: FilterGreater, Field: UserFieldCreatedAt, Value: created.Format(time.RFC3339)},
+			wantWhere: "u.created_at > $1",
+			wantArgs:  []interface{}{created},
+		},
+		{
+			name: "and, or and not",
+			filter: &Filter{Op: FilterOr, Operands: []*Filter{
+				{Op: FilterAnd, Operands: []*Filter{
+					{Op: FilterEqual, Field: UserFieldIsActive, Value: true},
+					{Op: FilterPresent, Field: UserFieldEmail},
+				}},
+				{Op: FilterNot, Operands: []*Filter{{Op: FilterEqual, Field: UserFieldUsername, Value: "a"}}},
+			}},
+			wantWhere: "((u.is_active = $1) AND (u.email IS NOT NULL AND u.email <> '')) OR ((lower(u.username) = lower($2)) IS NOT TRUE)",
+			wantArgs:  []interface{}{true, "a"},
+		},
+		{
+			name:      "fields of related rows use EXISTS",
+			filter:    &Filter{Op: FilterEqual, Field: UserFieldGroupID, Value: "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"},
+			wantWhere: "EXISTS (" + userFilterFields[UserFieldGroupID].exists + " AND " + userFilterFields[UserFieldGroupID].column + " = $1)",
+			wantArgs:  []interface{}{"5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &filterBuilder{fields: userFilterFields}
+			where, err := builder.build(tt.filter)
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			if where != tt.wantWhere {
+				t.Errorf("where = %q, want %q", where, tt.wantWhere)
+			}
+			if !reflect.DeepEqual(builder.args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", builder.args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFilterBuilderRejectsInvalidFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *Filter
+	}{
+		{"unknown field", &Filter{Op: FilterEqual, Field: "password_hash", Value: "x"}},
+		{"text compared with a bool", &Filter{Op: FilterEqual, Field: UserFieldUsername, Value: true}},
+		{"bool compared with a string", &Filter{Op: FilterEqual, Field: UserFieldIsActive, Value: "true"}},
+		{"bool with gt", &Filter{Op: FilterGreater, Field: UserFieldIsActive, Value: true}},
+		{"time that isn't RFC 3339", &Filter{Op: FilterGreater, Field: UserFieldCreatedAt, Value: "yesterday"}},
+		{"co on a time", &Filter{Op: FilterContains, Field: UserFieldCreatedAt, Value: "2024-05-01T12:00:00Z"}},
+		{"unknown operator", &Filter{Op: "xx", Field: UserFieldUsername, Value: "a"}},
+		{"and without operands", &Filter{Op: FilterAnd}},
+		{"not with two operands", &Filter{Op: FilterNot, Operands: []*Filter{
+			{Op: FilterPresent, Field: UserFieldEmail},
+			{Op: FilterPresent, Field: UserFieldUsername},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := &filterBuilder{fields: userFilterFields}
+			if _, err := builder.build(tt.filter); !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("err = %v, want ErrInvalidFilter", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// models/identifier.go
+package models
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrEmailAlreadyExists    = errors.New("email already exists")
+	ErrUsernameAlreadyExists = errors.New("username already exists")
+	ErrConfusableUsername    = errors.New("username contains invisible characters or mixes letters from different scripts")
+)
+
+// eastAsianScripts are written together, and alongside Latin, in ordinary names
+var eastAsianScripts = map[string]bool{
+	"Han": true, "Hiragana": true, "Katakana": true, "Hangul": true, "Bopomofo": true,
+}
+
+// NormalizeEmail returns the canonical form emails are stored and looked up in:
+// Unicode NFKC normalized, trimmed and lowercased
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(norm.NFKC.String(email)))
+}
+
+// NormalizeUsername returns the canonical form usernames are stored and looked up in:
+// Unicode NFKC normalized, trimmed and lowercased
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(norm.NFKC.String(username)))
+}
+
+// CheckUsernameConfusables rejects normalized usernames that could pass for someone
+// else's: ones with invisible or whitespace characters, and ones mixing letters from
+// several scripts (other than Latin with the East Asian ones), such as a Latin name with a
+// Cyrillic "а" in it. Names written entirely in one script, Cyrillic or Greek included,
+// are allowed.
+func CheckUsernameConfusables(username string) error {
+	scripts := make(map[string]bool)
+	for _, r := range username {
+		if unicode.IsControl(r) || unicode.IsSpace(r) || unicode.Is(unicode.Cf, r) {
+			return ErrConfusableUsername
+		}
+		if unicode.IsLetter(r) {
+			scripts[scriptOf(r)] = true
+		}
+	}
+
+	if len(scripts) > 1 {
+		for script := range scripts {
+			if script != "Latin" && !eastAsianScripts[script] {
+				return ErrConfusableUsername
+			}
+		}
+	}
+	return nil
+}
+
+// Helper function to find the Unicode script a letter belongs to
+func scriptOf(r rune) string {
+	if r < unicode.MaxASCII {
+		return "Latin"
+	}
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, r) {
+			return name
+		}
+	}
+	return "Unknown"
+}
@@ -0,0 +1,78 @@
+// models/identifier_test.go
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeIdentifiers(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"already canonical", "bjensen@example.com", "bjensen@example.com"},
+		{"surrounding whitespace", "  BJensen@Example.com\t", "bjensen@example.com"},
+		{"non-ASCII capitals", "ÉMILE.ZOLA@EXAMPLE.FR", "émile.zola@example.fr"},
+		{"Cyrillic capitals", "ИВАН", "иван"},
+		{"Greek capitals", "ΑΛΕΞΗΣ", "αλεξησ"},
+		{"fullwidth letters", "ｂｊｅｎｓｅｎ", "bjensen"},
+		{"decomposed accent", "e\u0301mile", "\u00e9mile"},
+		{"ligature", "ﬁona", "fiona"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeEmail(tt.input); got != tt.want {
+				t.Errorf("NormalizeEmail(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if got := NormalizeUsername(tt.input); got != tt.want {
+				t.Errorf("NormalizeUsername(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+
+	// Identifiers that only differ in case or form collide once normalized
+	collisions := [][2]string{
+		{"BJensen", "bjensen"},
+		{"ｂｊｅｎｓｅｎ", "bjensen"},
+		{"Émile", "émile"},
+	}
+	for _, pair := range collisions {
+		if NormalizeUsername(pair[0]) != NormalizeUsername(pair[1]) {
+			t.Errorf("%q and %q normalize differently", pair[0], pair[1])
+		}
+	}
+}
+
+func TestCheckUsernameConfusables(t *testing.T) {
+	tests := []struct {
+		name       string
+		username   string
+		confusable bool
+	}{
+		{"Latin", "bjensen", false},
+		{"Latin with digits and punctuation", "b.jensen-42", false},
+		{"accented Latin", "émile", false},
+		{"all Cyrillic", "иван", false},
+		{"all Cyrillic lookalikes", "орех", false},
+		{"all Greek", "αλεξησ", false},
+		{"Latin with Han", "li明", false},
+		{"Japanese", "やまだ太郎", false},
+		{"Latin with a Cyrillic letter", "pаypal", true},
+		{"Cyrillic with a Latin letter", "иваn", true},
+		{"Latin with a Greek letter", "bοb", true},
+		{"Cyrillic with Greek", "иванα", true},
+		{"zero-width joiner", "bjen\u200dsen", true},
+		{"internal space", "b jensen", true},
+		{"control character", "bjensen\x07", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUsernameConfusables(tt.username)
+			if got := errors.Is(err, ErrConfusableUsername); got != tt.confusable {
+				t.Errorf("CheckUsernameConfusables(%q) = %v, want confusable %v", tt.username, err, tt.confusable)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// models/login_challenge.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Login challenge methods
+const (
+	ChallengeMethodEmail = "email" // The user approves the login from a link sent by email
+	ChallengeMethodMFA   = "mfa"   // The user enters a second-factor code
+)
+
+// LoginChallenge represents a high-risk login waiting for confirmation, from the auth.login_challenges table.
+// The client that started the login holds the secret; the session is only created once the
+// challenge has been approved and the client completes it.
+type LoginChallenge struct {
+	ChallengeID       uuid.UUID
+	UserID            uuid.UUID
+	DeviceID          *uuid.UUID
+	SecretHash        string
+	ApprovalTokenHash string
+	Method            string
+	RiskScore         int
+	GeoCountry        string
+	GeoLatitude       *float64
+	GeoLongitude      *float64
+	NewDevice         bool
+	RememberMe        bool
+	IPAddress         string
+	UserAgent         string
+	ExpiresAt         time.Time
+	ApprovedAt        *time.Time
+	CompletedAt       *time.Time
+	CreatedAt         time.Time
+}
+
+// LoginChallengeRepository handles database operations for login challenges
+type LoginChallengeRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewLoginChallengeRepository creates a new LoginChallengeRepository
+func NewLoginChallengeRepository(pool *pgxpool.Pool) *LoginChallengeRepository {
+	return &LoginChallengeRepository{pool: pool}
+}
+
+// Create adds a new login challenge to the database
+func (r *LoginChallengeRepository) Create(ctx context.Context, challenge *LoginChallenge) error {
+	if challenge.ChallengeID == uuid.Nil {
+		challenge.ChallengeID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO auth.login_challenges (
+			challenge_id, user_id, device_id, secret_hash, approval_token_hash, method,
+			risk_score, geo_country, geo_latitude, geo_longitude, new_device, remember_me,
+			ip_address, user_agent, expires_at
+		) VALUES (
+			$1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, $12, $13, $14, $15
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		challenge.ChallengeID, challenge.UserID, challenge.DeviceID, challenge.SecretHash,
+		challenge.ApprovalTokenHash, challenge.Method, challenge.RiskScore, challenge.GeoCountry,
+		challenge.GeoLatitude, challenge.GeoLongitude, challenge.NewDevice, challenge.RememberMe,
+		challenge.IPAddress, challenge.UserAgent, challenge.ExpiresAt,
+	)
+
+	return row.Scan(&challenge.CreatedAt)
+}
+
+// GetByID retrieves a login challenge by ID
+func (r *LoginChallengeRepository) GetByID(ctx context.Context, challengeID uuid.UUID) (*LoginChallenge, error) {
+	query := `
+		SELECT
+			challenge_id, user_id, device_id, secret_hash, COALESCE(approval_token_hash, ''), method,
+			risk_score, geo_country, geo_latitude, geo_longitude, new_device, remember_me,
+			ip_address, user_agent, expires_at, approved_at, completed_at, created_at
+		FROM auth.login_challenges
+		WHERE challenge_id = $1`
+
+	var challenge LoginChallenge
+	err := scanLoginChallenge(r.pool.QueryRow(ctx, query, challengeID), &challenge)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Challenge not found
+		}
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// Approve marks the open challenge with the given approval token hash as approved.
+// Returns nil if there is no such challenge, or it has expired or already been completed.
+func (r *LoginChallengeRepository) Approve(ctx context.Context, approvalTokenHash string) (*LoginChallenge, error) {
+	query := `
+		UPDATE auth.login_challenges SET
+			approved_at = COALESCE(approved_at, NOW())
+		WHERE approval_token_hash = $1 AND completed_at IS NULL AND expires_at > NOW()
+		RETURNING
+			challenge_id, user_id, device_id, secret_hash, COALESCE(approval_token_hash, ''), method,
+			risk_score, geo_country, geo_latitude, geo_longitude, new_device, remember_me,
+			ip_address, user_agent, expires_at, approved_at, completed_at, created_at`
+
+	var challenge LoginChallenge
+	err := scanLoginChallenge(r.pool.QueryRow(ctx, query, approvalTokenHash), &challenge)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &challenge, nil
+}
+
+// Complete marks an open challenge as used. Returns false if it had already been
+// completed or has expired, so each challenge can create at most one session.
+func (r *LoginChallengeRepository) Complete(ctx context.Context, challengeID uuid.UUID) (bool, error) {
+	query := `
+		UPDATE auth.login_challenges SET
+			completed_at = NOW()
+		WHERE challenge_id = $1 AND completed_at IS NULL AND expires_at > NOW()`
+
+	tag, err := r.pool.Exec(ctx, query, challengeID)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteExpired removes challenges that expired before the given time
+func (r *LoginChallengeRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM auth.login_challenges WHERE expires_at < $1`
+	tag, err := r.pool.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Helper function to scan a login challenge from a row
+func scanLoginChallenge(row pgx.Row, challenge *LoginChallenge) error {
+	return row.Scan(
+		&challenge.ChallengeID,
+		&challenge.UserID,
+		&challenge.DeviceID,
+		&challenge.SecretHash,
+		&challenge.ApprovalTokenHash,
+		&challenge.Method,
+		&challenge.RiskScore,
+		&challenge.GeoCountry,
+		&challenge.GeoLatitude,
+		&challenge.GeoLongitude,
+		&challenge.NewDevice,
+		&challenge.RememberMe,
+		&challenge.IPAddress,
+		&challenge.UserAgent,
+		&challenge.ExpiresAt,
+		&challenge.ApprovedAt,
+		&challenge.CompletedAt,
+		&challenge.CreatedAt,
+	)
+}
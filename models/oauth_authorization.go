@@ -0,0 +1,177 @@
+// models/oauth_authorization.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// OAuthAuthorization represents an authorization request from the auth.oauth_authorizations table.
+// It is created when a signed-in user arrives at /oauth/authorize, approved (by consent or
+// automatically) with an authorization code, and used when the client redeems the code.
+type OAuthAuthorization struct {
+	AuthorizationID     uuid.UUID
+	ClientID            string
+	UserID              uuid.UUID
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            time.Time // When the user signed in
+	CodeHash            *string
+	ExpiresAt           time.Time
+	ApprovedAt          *time.Time
+	UsedAt              *time.Time
+	CreatedAt           time.Time
+}
+
+// OAuthAuthorizationRepository handles database operations for OAuth authorizations
+type OAuthAuthorizationRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewOAuthAuthorizationRepository creates a new OAuthAuthorizationRepository
+func NewOAuthAuthorizationRepository(pool *pgxpool.Pool) *OAuthAuthorizationRepository {
+	return &OAuthAuthorizationRepository{pool: pool}
+}
+
+// Create adds a new authorization request to the database
+func (r *OAuthAuthorizationRepository) Create(ctx context.Context, authz *OAuthAuthorization) error {
+	if authz.AuthorizationID == uuid.Nil {
+		authz.AuthorizationID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO auth.oauth_authorizations (
+			authorization_id, client_id, user_id, redirect_uri, scopes, state, nonce,
+			code_challenge, code_challenge_method, auth_time, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		authz.AuthorizationID, authz.ClientID, authz.UserID, authz.RedirectURI, authz.Scopes,
+		authz.State, authz.Nonce, authz.CodeChallenge, authz.CodeChallengeMethod,
+		authz.AuthTime, authz.ExpiresAt,
+	)
+
+	return row.Scan(&authz.CreatedAt)
+}
+
+// GetByID retrieves an authorization by ID
+func (r *OAuthAuthorizationRepository) GetByID(ctx context.Context, authorizationID uuid.UUID) (*OAuthAuthorization, error) {
+	query := `
+		SELECT
+			authorization_id, client_id, user_id, redirect_uri, scopes, state, nonce,
+			code_challenge, code_challenge_method, auth_time, code_hash, expires_at,
+			approved_at, used_at, created_at
+		FROM auth.oauth_authorizations
+		WHERE authorization_id = $1`
+
+	var authz OAuthAuthorization
+	err := scanOAuthAuthorization(r.pool.QueryRow(ctx, query, authorizationID), &authz)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Authorization not found
+		}
+		return nil, err
+	}
+
+	return &authz, nil
+}
+
+// Approve attaches an authorization code to a pending authorization. Returns false if it was
+// already approved or has expired.
+func (r *OAuthAuthorizationRepository) Approve(ctx context.Context, authorizationID uuid.UUID, codeHash string, codeExpiresAt time.Time) (bool, error) {
+	query := `
+		UPDATE auth.oauth_authorizations SET
+			approved_at = NOW(),
+			code_hash = $2,
+			expires_at = $3
+		WHERE authorization_id = $1 AND approved_at IS NULL AND expires_at > NOW()`
+
+	tag, err := r.pool.Exec(ctx, query, authorizationID, codeHash, codeExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RedeemCode marks the authorization with the given code as used and returns it.
+// replayed is true if the code had already been used, in which case the caller should
+// revoke the tokens issued from it. Returns nil if there is no such code.
+func (r *OAuthAuthorizationRepository) RedeemCode(ctx context.Context, codeHash string) (authz *OAuthAuthorization, replayed bool, err error) {
+	query := `
+		WITH previous AS (
+			SELECT authorization_id, used_at
+			FROM auth.oauth_authorizations
+			WHERE code_hash = $1
+			FOR UPDATE
+		)
+		UPDATE auth.oauth_authorizations a SET
+			used_at = COALESCE(a.used_at, NOW())
+		FROM previous
+		WHERE a.authorization_id = previous.authorization_id
+		RETURNING
+			a.authorization_id, a.client_id, a.user_id, a.redirect_uri, a.scopes, a.state, a.nonce,
+			a.code_challenge, a.code_challenge_method, a.auth_time, a.code_hash, a.expires_at,
+			a.approved_at, a.used_at, a.created_at, previous.used_at IS NOT NULL`
+
+	authz = &OAuthAuthorization{}
+	err = r.pool.QueryRow(ctx, query, codeHash).Scan(
+		&authz.AuthorizationID, &authz.ClientID, &authz.UserID, &authz.RedirectURI, &authz.Scopes,
+		&authz.State, &authz.Nonce, &authz.CodeChallenge, &authz.CodeChallengeMethod, &authz.AuthTime,
+		&authz.CodeHash, &authz.ExpiresAt, &authz.ApprovedAt, &authz.UsedAt, &authz.CreatedAt,
+		&replayed,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return authz, replayed, nil
+}
+
+// DeleteExpired removes expired authorizations. Redeemed ones are kept while tokens issued
+// from them remain, so a replayed code can still revoke those tokens.
+func (r *OAuthAuthorizationRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		DELETE FROM auth.oauth_authorizations a
+		WHERE a.expires_at < $1
+		AND NOT EXISTS (SELECT 1 FROM auth.oauth_tokens t WHERE t.authorization_id = a.authorization_id)`
+	tag, err := r.pool.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Helper function to scan an authorization from a row
+func scanOAuthAuthorization(row pgx.Row, authz *OAuthAuthorization) error {
+	return row.Scan(
+		&authz.AuthorizationID,
+		&authz.ClientID,
+		&authz.UserID,
+		&authz.RedirectURI,
+		&authz.Scopes,
+		&authz.State,
+		&authz.Nonce,
+		&authz.CodeChallenge,
+		&authz.CodeChallengeMethod,
+		&authz.AuthTime,
+		&authz.CodeHash,
+		&authz.ExpiresAt,
+		&authz.ApprovedAt,
+		&authz.UsedAt,
+		&authz.CreatedAt,
+	)
+}
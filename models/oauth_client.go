@@ -0,0 +1,159 @@
+// models/oauth_client.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// OAuthClient represents a relying party registered with our OpenID Connect provider,
+// from the auth.oauth_clients table
+type OAuthClient struct {
+	ClientID         string     `json:"client_id"`
+	ClientSecretHash string     `json:"-"` // Empty for public clients
+	Name             string     `json:"name"`
+	RedirectURIs     []string   `json:"redirect_uris"`
+	AllowedScopes    []string   `json:"allowed_scopes"`
+	RequireConsent   bool       `json:"require_consent"`
+	CreatedBy        *uuid.UUID `json:"created_by,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// IsConfidential reports whether the client authenticates with a secret
+func (c *OAuthClient) IsConfidential() bool {
+	return c.ClientSecretHash != ""
+}
+
+// OAuthClientRepository handles database operations for OAuth clients and the consents users give them
+type OAuthClientRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewOAuthClientRepository creates a new OAuthClientRepository
+func NewOAuthClientRepository(pool *pgxpool.Pool) *OAuthClientRepository {
+	return &OAuthClientRepository{pool: pool}
+}
+
+// Create adds a new client to the database
+func (r *OAuthClientRepository) Create(ctx context.Context, client *OAuthClient) error {
+	query := `
+		INSERT INTO auth.oauth_clients (
+			client_id, client_secret_hash, name, redirect_uris, allowed_scopes, require_consent, created_by
+		) VALUES (
+			$1, NULLIF($2, ''), $3, $4, $5, $6, $7
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		client.ClientID, client.ClientSecretHash, client.Name, client.RedirectURIs,
+		client.AllowedScopes, client.RequireConsent, client.CreatedBy,
+	)
+
+	return row.Scan(&client.CreatedAt)
+}
+
+// GetByID retrieves a client by ID
+func (r *OAuthClientRepository) GetByID(ctx context.Context, clientID string) (*OAuthClient, error) {
+	query := `
+		SELECT
+			client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, allowed_scopes,
+			require_consent, created_by, created_at
+		FROM auth.oauth_clients
+		WHERE client_id = $1`
+
+	var client OAuthClient
+	err := scanOAuthClient(r.pool.QueryRow(ctx, query, clientID), &client)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Client not found
+		}
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// List retrieves all clients, sorted by name
+func (r *OAuthClientRepository) List(ctx context.Context) ([]*OAuthClient, error) {
+	query := `
+		SELECT
+			client_id, COALESCE(client_secret_hash, ''), name, redirect_uris, allowed_scopes,
+			require_consent, created_by, created_at
+		FROM auth.oauth_clients
+		ORDER BY name`
+
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []*OAuthClient
+	for rows.Next() {
+		var client OAuthClient
+		if err := scanOAuthClient(rows, &client); err != nil {
+			return nil, err
+		}
+		clients = append(clients, &client)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return clients, nil
+}
+
+// Delete removes a client, along with its consents, authorizations and tokens
+func (r *OAuthClientRepository) Delete(ctx context.Context, clientID string) (bool, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM auth.oauth_clients WHERE client_id = $1`, clientID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// GetConsent retrieves the scopes a user has agreed to share with a client, or nil if none
+func (r *OAuthClientRepository) GetConsent(ctx context.Context, userID uuid.UUID, clientID string) ([]string, error) {
+	var scopes []string
+	query := `SELECT scopes FROM auth.oauth_consents WHERE user_id = $1 AND client_id = $2`
+	err := r.pool.QueryRow(ctx, query, userID, clientID).Scan(&scopes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return scopes, nil
+}
+
+// SaveConsent records that a user agreed to share scopes with a client, adding to any earlier consent
+func (r *OAuthClientRepository) SaveConsent(ctx context.Context, userID uuid.UUID, clientID string, scopes []string) error {
+	query := `
+		INSERT INTO auth.oauth_consents (user_id, client_id, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET
+			scopes = ARRAY(SELECT DISTINCT unnest(auth.oauth_consents.scopes || EXCLUDED.scopes)),
+			granted_at = NOW()`
+
+	_, err := r.pool.Exec(ctx, query, userID, clientID, scopes)
+	return err
+}
+
+// Helper function to scan a client from a row
+func scanOAuthClient(row pgx.Row, client *OAuthClient) error {
+	return row.Scan(
+		&client.ClientID,
+		&client.ClientSecretHash,
+		&client.Name,
+		&client.RedirectURIs,
+		&client.AllowedScopes,
+		&client.RequireConsent,
+		&client.CreatedBy,
+		&client.CreatedAt,
+	)
+}
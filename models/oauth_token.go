@@ -0,0 +1,122 @@
+// models/oauth_token.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// OAuth token types
+const (
+	OAuthTokenAccess  = "access"
+	OAuthTokenRefresh = "refresh"
+)
+
+// OAuthToken represents an opaque access or refresh token issued to a client, from the auth.oauth_tokens table
+type OAuthToken struct {
+	TokenID         uuid.UUID
+	TokenHash       string
+	TokenType       string
+	ClientID        string
+	UserID          uuid.UUID
+	Scopes          []string
+	AuthorizationID *uuid.UUID
+	ExpiresAt       time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+}
+
+// IsActive reports whether the token can still be used
+func (t *OAuthToken) IsActive() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+// OAuthTokenRepository handles database operations for OAuth tokens
+type OAuthTokenRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewOAuthTokenRepository creates a new OAuthTokenRepository
+func NewOAuthTokenRepository(pool *pgxpool.Pool) *OAuthTokenRepository {
+	return &OAuthTokenRepository{pool: pool}
+}
+
+// Create adds a new token to the database
+func (r *OAuthTokenRepository) Create(ctx context.Context, token *OAuthToken) error {
+	if token.TokenID == uuid.Nil {
+		token.TokenID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO auth.oauth_tokens (
+			token_id, token_hash, token_type, client_id, user_id, scopes, authorization_id, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		token.TokenID, token.TokenHash, token.TokenType, token.ClientID, token.UserID,
+		token.Scopes, token.AuthorizationID, token.ExpiresAt,
+	)
+
+	return row.Scan(&token.CreatedAt)
+}
+
+// GetByTokenHash retrieves a token by the hash of its value
+func (r *OAuthTokenRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*OAuthToken, error) {
+	query := `
+		SELECT
+			token_id, token_hash, token_type, client_id, user_id, scopes,
+			authorization_id, expires_at, revoked_at, created_at
+		FROM auth.oauth_tokens
+		WHERE token_hash = $1`
+
+	var token OAuthToken
+	err := r.pool.QueryRow(ctx, query, tokenHash).Scan(
+		&token.TokenID, &token.TokenHash, &token.TokenType, &token.ClientID, &token.UserID,
+		&token.Scopes, &token.AuthorizationID, &token.ExpiresAt, &token.RevokedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Token not found
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Revoke revokes a token. It reports whether this call revoked it, so that of two callers
+// racing to spend the same token only one succeeds.
+func (r *OAuthTokenRepository) Revoke(ctx context.Context, tokenID uuid.UUID) (bool, error) {
+	query := `UPDATE auth.oauth_tokens SET revoked_at = NOW() WHERE token_id = $1 AND revoked_at IS NULL`
+	tag, err := r.pool.Exec(ctx, query, tokenID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// RevokeAllForAuthorization revokes every token issued from an authorization
+func (r *OAuthTokenRepository) RevokeAllForAuthorization(ctx context.Context, authorizationID uuid.UUID) (int64, error) {
+	query := `UPDATE auth.oauth_tokens SET revoked_at = NOW() WHERE authorization_id = $1 AND revoked_at IS NULL`
+	tag, err := r.pool.Exec(ctx, query, authorizationID)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// DeleteExpired removes tokens that expired before the given time
+func (r *OAuthTokenRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.pool.Exec(ctx, `DELETE FROM auth.oauth_tokens WHERE expires_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
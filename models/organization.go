@@ -0,0 +1,511 @@
+// models/organization.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Roles a user can have in an organization, from most to least privileged
+const (
+	OrgRoleOwner  = "owner"  // Can do anything, including deleting the organization and managing owners
+	OrgRoleAdmin  = "admin"  // Can manage members and invitations, except owners
+	OrgRoleMember = "member" // Can work with the organization's data
+)
+
+var orgRoleRanks = map[string]int{
+	OrgRoleOwner:  3,
+	OrgRoleAdmin:  2,
+	OrgRoleMember: 1,
+}
+
+var (
+	ErrOrganizationSlugTaken = errors.New("an organization with this slug already exists")
+	ErrLastOrganizationOwner = errors.New("an organization must keep at least one owner")
+)
+
+// IsOrgRole checks whether role is one of the organization roles
+func IsOrgRole(role string) bool {
+	_, ok := orgRoleRanks[role]
+	return ok
+}
+
+// OrgRoleAtLeast checks whether role grants at least the privileges of required
+func OrgRoleAtLeast(role, required string) bool {
+	return orgRoleRanks[role] >= orgRoleRanks[required] && IsOrgRole(role)
+}
+
+// Organization represents a tenant from the auth.organizations table
+type Organization struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserOrganization is an organization a user belongs to, with their role in it
+type UserOrganization struct {
+	Organization
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// OrganizationMember is a user's membership of an organization, from the auth.organization_members table
+type OrganizationMember struct {
+	OrgID     uuid.UUID `json:"org_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// OrganizationInvitation is an invitation to join an organization, from the auth.organization_invitations table
+type OrganizationInvitation struct {
+	InvitationID uuid.UUID  `json:"invitation_id"`
+	OrgID        uuid.UUID  `json:"org_id"`
+	Email        string     `json:"email"`
+	Role         string     `json:"role"`
+	TokenHash    string     `json:"-"`
+	InvitedBy    *uuid.UUID `json:"invited_by,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	AcceptedAt   *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy   *uuid.UUID `json:"accepted_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// OrganizationRepository handles database operations for organizations, their members and invitations
+type OrganizationRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewOrganizationRepository creates a new OrganizationRepository
+func NewOrganizationRepository(pool *pgxpool.Pool) *OrganizationRepository {
+	return &OrganizationRepository{pool: pool}
+}
+
+// Create adds a new organization with ownerID as its first owner
+func (r *OrganizationRepository) Create(ctx context.Context, org *Organization, ownerID uuid.UUID) error {
+	if org.OrgID == uuid.Nil {
+		org.OrgID = uuid.New()
+	}
+
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+			INSERT INTO auth.organizations (org_id, name, slug)
+			VALUES ($1, $2, $3)
+			RETURNING created_at, updated_at`
+
+		if err := tx.QueryRow(ctx, query, org.OrgID, org.Name, org.Slug).Scan(&org.CreatedAt, &org.UpdatedAt); err != nil {
+			return organizationError(err)
+		}
+
+		query = `
+			INSERT INTO auth.organization_members (org_id, user_id, role)
+			VALUES ($1, $2, $3)`
+
+		_, err := tx.Exec(ctx, query, org.OrgID, ownerID, OrgRoleOwner)
+		return err
+	})
+}
+
+// GetByID retrieves an organization by ID
+func (r *OrganizationRepository) GetByID(ctx context.Context, orgID uuid.UUID) (*Organization, error) {
+	query := `
+		SELECT org_id, name, slug, created_at, updated_at
+		FROM auth.organizations
+		WHERE org_id = $1`
+
+	var org Organization
+	err := r.pool.QueryRow(ctx, query, orgID).Scan(&org.OrgID, &org.Name, &org.Slug, &org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Organization not found
+		}
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// Update saves an organization's name and slug
+func (r *OrganizationRepository) Update(ctx context.Context, org *Organization) error {
+	query := `
+		UPDATE auth.organizations SET
+			name = $2,
+			slug = $3,
+			updated_at = NOW()
+		WHERE org_id = $1
+		RETURNING updated_at`
+
+	err := r.pool.QueryRow(ctx, query, org.OrgID, org.Name, org.Slug).Scan(&org.UpdatedAt)
+	return organizationError(err)
+}
+
+// Delete removes an organization along with its memberships and invitations.
+// Sessions working in it are left without an active organization.
+func (r *OrganizationRepository) Delete(ctx context.Context, orgID uuid.UUID) error {
+	query := `DELETE FROM auth.organizations WHERE org_id = $1`
+	_, err := r.pool.Exec(ctx, query, orgID)
+	return err
+}
+
+// GetByUserID retrieves the organizations a user belongs to, with their role in each
+func (r *OrganizationRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*UserOrganization, error) {
+	query := `
+		SELECT o.org_id, o.name, o.slug, o.created_at, o.updated_at, m.role, m.created_at
+		FROM auth.organization_members m
+		JOIN auth.organizations o ON o.org_id = m.org_id
+		WHERE m.user_id = $1
+		ORDER BY o.name, o.org_id`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []*UserOrganization
+	for rows.Next() {
+		var org UserOrganization
+		if err := rows.Scan(&org.OrgID, &org.Name, &org.Slug, &org.CreatedAt, &org.UpdatedAt, &org.Role, &org.JoinedAt); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, &org)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orgs, nil
+}
+
+// GetDefaultForUser picks the organization a new session for the user starts in: the one
+// their most recent session was working in, or else the first they joined. It returns nil
+// if the user belongs to none.
+func (r *OrganizationRepository) GetDefaultForUser(ctx context.Context, userID uuid.UUID) (*uuid.UUID, error) {
+	query := `
+		SELECT m.org_id
+		FROM auth.organization_members m
+		WHERE m.user_id = $1
+		ORDER BY m.org_id = (
+			SELECT active_org_id FROM auth.sessions
+			WHERE user_id = $1 AND active_org_id IS NOT NULL
+			ORDER BY last_active_at DESC
+			LIMIT 1
+		) DESC NULLS LAST, m.created_at
+		LIMIT 1`
+
+	var orgID uuid.UUID
+	err := r.pool.QueryRow(ctx, query, userID).Scan(&orgID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Not a member of any organization
+		}
+		return nil, err
+	}
+
+	return &orgID, nil
+}
+
+// GetMember retrieves a user's membership of an organization, or nil if they are not a member
+func (r *OrganizationRepository) GetMember(ctx context.Context, orgID, userID uuid.UUID) (*OrganizationMember, error) {
+	query := `
+		SELECT m.org_id, m.user_id, u.username, u.email, u.first_name, u.last_name, m.role, m.created_at
+		FROM auth.organization_members m
+		JOIN auth.users u ON u.user_id = m.user_id
+		WHERE m.org_id = $1 AND m.user_id = $2`
+
+	var member OrganizationMember
+	err := scanOrganizationMember(r.pool.QueryRow(ctx, query, orgID, userID), &member)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Not a member
+		}
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// GetMembers retrieves all members of an organization
+func (r *OrganizationRepository) GetMembers(ctx context.Context, orgID uuid.UUID) ([]*OrganizationMember, error) {
+	query := `
+		SELECT m.org_id, m.user_id, u.username, u.email, u.first_name, u.last_name, m.role, m.created_at
+		FROM auth.organization_members m
+		JOIN auth.users u ON u.user_id = m.user_id
+		WHERE m.org_id = $1
+		ORDER BY m.created_at, m.user_id`
+
+	rows, err := r.pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*OrganizationMember
+	for rows.Next() {
+		var member OrganizationMember
+		if err := scanOrganizationMember(rows, &member); err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// UpdateMemberRole changes a member's role. Demoting the last owner returns ErrLastOrganizationOwner.
+func (r *OrganizationRepository) UpdateMemberRole(ctx context.Context, orgID, userID uuid.UUID, role string) error {
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if role != OrgRoleOwner {
+			if err := ensureOtherOwner(ctx, tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+
+		query := `UPDATE auth.organization_members SET role = $3 WHERE org_id = $1 AND user_id = $2`
+		_, err := tx.Exec(ctx, query, orgID, userID, role)
+		return err
+	})
+}
+
+// RemoveMember takes a user out of an organization and clears it from their sessions.
+// Removing the last owner returns ErrLastOrganizationOwner.
+func (r *OrganizationRepository) RemoveMember(ctx context.Context, orgID, userID uuid.UUID) error {
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if err := ensureOtherOwner(ctx, tx, orgID, userID); err != nil {
+			return err
+		}
+
+		query := `DELETE FROM auth.organization_members WHERE org_id = $1 AND user_id = $2`
+		if _, err := tx.Exec(ctx, query, orgID, userID); err != nil {
+			return err
+		}
+
+		query = `
+			UPDATE auth.sessions SET
+				active_org_id = NULL
+			WHERE user_id = $2 AND active_org_id = $1`
+
+		_, err := tx.Exec(ctx, query, orgID, userID)
+		return err
+	})
+}
+
+// CreateInvitation adds a new invitation to the database
+func (r *OrganizationRepository) CreateInvitation(ctx context.Context, invitation *OrganizationInvitation) error {
+	if invitation.InvitationID == uuid.Nil {
+		invitation.InvitationID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO auth.organization_invitations (
+			invitation_id, org_id, email, role, token_hash, invited_by, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		invitation.InvitationID, invitation.OrgID, invitation.Email, invitation.Role,
+		invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt,
+	)
+
+	return row.Scan(&invitation.CreatedAt)
+}
+
+// GetInvitationByTokenHash retrieves an invitation by the hash of its token, accepted or not
+func (r *OrganizationRepository) GetInvitationByTokenHash(ctx context.Context, tokenHash string) (*OrganizationInvitation, error) {
+	query := `
+		SELECT
+			invitation_id, org_id, email, role, token_hash, invited_by,
+			expires_at, accepted_at, accepted_by, created_at
+		FROM auth.organization_invitations
+		WHERE token_hash = $1`
+
+	var invitation OrganizationInvitation
+	err := scanOrganizationInvitation(r.pool.QueryRow(ctx, query, tokenHash), &invitation)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Invitation not found
+		}
+		return nil, err
+	}
+
+	return &invitation, nil
+}
+
+// GetPendingInvitations retrieves an organization's invitations that can still be accepted
+func (r *OrganizationRepository) GetPendingInvitations(ctx context.Context, orgID uuid.UUID) ([]*OrganizationInvitation, error) {
+	query := `
+		SELECT
+			invitation_id, org_id, email, role, token_hash, invited_by,
+			expires_at, accepted_at, accepted_by, created_at
+		FROM auth.organization_invitations
+		WHERE org_id = $1 AND accepted_at IS NULL AND expires_at > NOW()
+		ORDER BY created_at DESC`
+
+	rows, err := r.pool.Query(ctx, query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*OrganizationInvitation
+	for rows.Next() {
+		var invitation OrganizationInvitation
+		if err := scanOrganizationInvitation(rows, &invitation); err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, &invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// DeleteInvitation removes one of an organization's pending invitations, reporting whether it existed
+func (r *OrganizationRepository) DeleteInvitation(ctx context.Context, orgID, invitationID uuid.UUID) (bool, error) {
+	query := `
+		DELETE FROM auth.organization_invitations
+		WHERE org_id = $1 AND invitation_id = $2 AND accepted_at IS NULL`
+
+	result, err := r.pool.Exec(ctx, query, orgID, invitationID)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() > 0, nil
+}
+
+// AcceptInvitation marks an invitation as accepted by a user and adds them to the
+// organization with the invited role. It reports false if the invitation was already
+// accepted or has expired. A user who is already a member keeps their current role.
+func (r *OrganizationRepository) AcceptInvitation(ctx context.Context, invitationID, userID uuid.UUID) (bool, error) {
+	accepted := false
+
+	err := r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+			UPDATE auth.organization_invitations SET
+				accepted_at = NOW(),
+				accepted_by = $2
+			WHERE invitation_id = $1 AND accepted_at IS NULL AND expires_at > NOW()
+			RETURNING org_id, role`
+
+		var orgID uuid.UUID
+		var role string
+		if err := tx.QueryRow(ctx, query, invitationID, userID).Scan(&orgID, &role); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		query = `
+			INSERT INTO auth.organization_members (org_id, user_id, role)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (org_id, user_id) DO NOTHING`
+
+		if _, err := tx.Exec(ctx, query, orgID, userID, role); err != nil {
+			return err
+		}
+		accepted = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return accepted, nil
+}
+
+// DeleteExpiredInvitations deletes invitations that expired without being accepted
+func (r *OrganizationRepository) DeleteExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM auth.organization_invitations WHERE accepted_at IS NULL AND expires_at < NOW()`
+	result, err := r.pool.Exec(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// Helper function to check, with the organization locked, that it has an owner other than userID
+func ensureOtherOwner(ctx context.Context, tx pgx.Tx, orgID, userID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM auth.organizations WHERE org_id = $1 FOR UPDATE`, orgID); err != nil {
+		return err
+	}
+
+	var others bool
+	query := `
+		SELECT
+			NOT EXISTS (
+				SELECT 1 FROM auth.organization_members
+				WHERE org_id = $1 AND user_id = $2 AND role = $3
+			) OR EXISTS (
+				SELECT 1 FROM auth.organization_members
+				WHERE org_id = $1 AND user_id <> $2 AND role = $3
+			)`
+
+	if err := tx.QueryRow(ctx, query, orgID, userID, OrgRoleOwner).Scan(&others); err != nil {
+		return err
+	}
+	if !others {
+		return ErrLastOrganizationOwner
+	}
+	return nil
+}
+
+// Helper function to map constraint violations onto organization errors
+func organizationError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == errUniqueViolation {
+		return ErrOrganizationSlugTaken
+	}
+	return err
+}
+
+// Helper function to scan a member from a row
+func scanOrganizationMember(row pgx.Row, member *OrganizationMember) error {
+	return row.Scan(
+		&member.OrgID,
+		&member.UserID,
+		&member.Username,
+		&member.Email,
+		&member.FirstName,
+		&member.LastName,
+		&member.Role,
+		&member.JoinedAt,
+	)
+}
+
+// Helper function to scan an invitation from a row
+func scanOrganizationInvitation(row pgx.Row, invitation *OrganizationInvitation) error {
+	return row.Scan(
+		&invitation.InvitationID,
+		&invitation.OrgID,
+		&invitation.Email,
+		&invitation.Role,
+		&invitation.TokenHash,
+		&invitation.InvitedBy,
+		&invitation.ExpiresAt,
+		&invitation.AcceptedAt,
+		&invitation.AcceptedBy,
+		&invitation.CreatedAt,
+	)
+}
@@ -0,0 +1,59 @@
+// models/password_history.go
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PasswordHistory represents a previous password hash from the auth.password_history table
+type PasswordHistory struct {
+	HistoryID    uuid.UUID `json:"history_id"`
+	UserID       uuid.UUID `json:"user_id"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// PasswordHistoryRepository handles database operations for password history
+type PasswordHistoryRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewPasswordHistoryRepository creates a new PasswordHistoryRepository
+func NewPasswordHistoryRepository(pool *pgxpool.Pool) *PasswordHistoryRepository {
+	return &PasswordHistoryRepository{pool: pool}
+}
+
+// GetRecentHashes retrieves the most recent previous password hashes for a user
+func (r *PasswordHistoryRepository) GetRecentHashes(ctx context.Context, userID uuid.UUID, limit int) ([]string, error) {
+	query := `
+		SELECT password_hash
+		FROM auth.password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2`
+
+	rows, err := r.pool.Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
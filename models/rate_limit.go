@@ -0,0 +1,51 @@
+// models/rate_limit.go
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// RateLimitRepository stores token buckets in the auth.rate_limit_buckets table
+type RateLimitRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewRateLimitRepository creates a new RateLimitRepository
+func NewRateLimitRepository(pool *pgxpool.Pool) *RateLimitRepository {
+	return &RateLimitRepository{pool: pool}
+}
+
+// Take refills a bucket and tries to remove one token from it in a single statement.
+// It returns the tokens left afterwards and whether a token was taken.
+func (r *RateLimitRepository) Take(ctx context.Context, key string, capacity, refillPerSecond float64) (float64, bool, error) {
+	query := `
+		INSERT INTO auth.rate_limit_buckets AS b (bucket_key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, true, NOW())
+		ON CONFLICT (bucket_key) DO UPDATE SET
+			allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) >= 1,
+			tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8)
+				- CASE
+					WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) >= 1 THEN 1
+					ELSE 0
+				END,
+			updated_at = NOW()
+		RETURNING tokens, allowed`
+
+	var tokens float64
+	var allowed bool
+	err := r.pool.QueryRow(ctx, query, key, capacity, refillPerSecond).Scan(&tokens, &allowed)
+	return tokens, allowed, err
+}
+
+// DeleteIdle deletes buckets that have not been touched since the given time
+func (r *RateLimitRepository) DeleteIdle(ctx context.Context, idleSince time.Time) (int64, error) {
+	query := `DELETE FROM auth.rate_limit_buckets WHERE updated_at < $1`
+	result, err := r.pool.Exec(ctx, query, idleSince)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
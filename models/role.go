@@ -0,0 +1,79 @@
+// models/role.go
+package models
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Built-in roles
+const (
+	RoleAdmin = "admin"
+)
+
+// RoleRepository handles database operations for user roles
+type RoleRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewRoleRepository creates a new RoleRepository
+func NewRoleRepository(pool *pgxpool.Pool) *RoleRepository {
+	return &RoleRepository{pool: pool}
+}
+
+// Grant gives a role to a user; granting a role the user already has is a no-op
+func (r *RoleRepository) Grant(ctx context.Context, userID uuid.UUID, role string) error {
+	query := `
+		INSERT INTO auth.user_roles (user_id, role)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id, role) DO NOTHING`
+
+	_, err := r.pool.Exec(ctx, query, userID, role)
+	return err
+}
+
+// Revoke removes a role from a user
+func (r *RoleRepository) Revoke(ctx context.Context, userID uuid.UUID, role string) error {
+	query := `DELETE FROM auth.user_roles WHERE user_id = $1 AND role = $2`
+	_, err := r.pool.Exec(ctx, query, userID, role)
+	return err
+}
+
+// GetByUserID retrieves all roles for a user
+func (r *RoleRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	query := `
+		SELECT role FROM auth.user_roles
+		WHERE user_id = $1
+		ORDER BY role`
+
+	rows, err := r.pool.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// HasRole checks if a user has a role
+func (r *RoleRepository) HasRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM auth.user_roles WHERE user_id = $1 AND role = $2)`
+	err := r.pool.QueryRow(ctx, query, userID, role).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,219 @@
+// models/saml.go
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// SAMLRequest is an authentication request waiting for the identity provider's response,
+// from the auth.saml_requests table
+type SAMLRequest struct {
+	StateHash  string
+	Provider   string
+	RequestID  string
+	ReturnTo   string
+	RememberMe bool
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+// SAMLSession links one of our sessions, or a login held back by a challenge, to the
+// identity provider session it came from, from the auth.saml_sessions table
+type SAMLSession struct {
+	LinkID       uuid.UUID
+	Provider     string
+	NameID       string
+	NameIDFormat string
+	SessionIndex string
+	SessionID    *uuid.UUID
+	ChallengeID  *uuid.UUID
+	CreatedAt    time.Time
+}
+
+// SAMLRepository handles database operations for SAML sign-ins and single logout
+type SAMLRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSAMLRepository creates a new SAMLRepository
+func NewSAMLRepository(pool *pgxpool.Pool) *SAMLRepository {
+	return &SAMLRepository{pool: pool}
+}
+
+// CreateRequest stores an authentication request that is being sent to an identity provider
+func (r *SAMLRepository) CreateRequest(ctx context.Context, request *SAMLRequest) error {
+	query := `
+		INSERT INTO auth.saml_requests (
+			state_hash, provider, request_id, return_to, remember_me, expires_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		request.StateHash, request.Provider, request.RequestID,
+		request.ReturnTo, request.RememberMe, request.ExpiresAt,
+	)
+
+	return row.Scan(&request.CreatedAt)
+}
+
+// ConsumeRequest removes and returns the pending request with a relay state, so each
+// request can only be answered once. Returns nil if there is no such request or it has expired.
+func (r *SAMLRepository) ConsumeRequest(ctx context.Context, stateHash string) (*SAMLRequest, error) {
+	query := `
+		DELETE FROM auth.saml_requests
+		WHERE state_hash = $1
+		RETURNING state_hash, provider, request_id, return_to, remember_me, expires_at, created_at`
+
+	var request SAMLRequest
+	err := r.pool.QueryRow(ctx, query, stateHash).Scan(
+		&request.StateHash, &request.Provider, &request.RequestID,
+		&request.ReturnTo, &request.RememberMe, &request.ExpiresAt, &request.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if time.Now().After(request.ExpiresAt) {
+		return nil, nil
+	}
+
+	return &request, nil
+}
+
+// RecordAssertion remembers that an assertion has been used until it expires. It returns
+// false if the assertion has been used before.
+func (r *SAMLRepository) RecordAssertion(ctx context.Context, provider, assertionID string, expiresAt time.Time) (bool, error) {
+	query := `
+		INSERT INTO auth.saml_assertions (provider, assertion_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (provider, assertion_id) DO NOTHING`
+
+	tag, err := r.pool.Exec(ctx, query, provider, assertionID, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// CreateSessionLink links a session or pending login to an identity provider session
+func (r *SAMLRepository) CreateSessionLink(ctx context.Context, link *SAMLSession) error {
+	if link.LinkID == uuid.Nil {
+		link.LinkID = uuid.New()
+	}
+
+	query := `
+		INSERT INTO auth.saml_sessions (
+			link_id, provider, name_id, name_id_format, session_index, session_id, challenge_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7
+		) RETURNING created_at`
+
+	row := r.pool.QueryRow(ctx, query,
+		link.LinkID, link.Provider, link.NameID, link.NameIDFormat,
+		link.SessionIndex, link.SessionID, link.ChallengeID,
+	)
+
+	return row.Scan(&link.CreatedAt)
+}
+
+// AttachSession moves the link of a login that was held back by a challenge onto the
+// session created once the challenge was completed
+func (r *SAMLRepository) AttachSession(ctx context.Context, challengeID, sessionID uuid.UUID) error {
+	query := `
+		UPDATE auth.saml_sessions SET
+			session_id = $2,
+			challenge_id = NULL
+		WHERE challenge_id = $1`
+
+	_, err := r.pool.Exec(ctx, query, challengeID, sessionID)
+	return err
+}
+
+// GetSessionLink retrieves the identity provider session a session came from
+func (r *SAMLRepository) GetSessionLink(ctx context.Context, sessionID uuid.UUID) (*SAMLSession, error) {
+	query := `
+		SELECT link_id, provider, name_id, name_id_format, session_index, session_id, challenge_id, created_at
+		FROM auth.saml_sessions
+		WHERE session_id = $1`
+
+	var link SAMLSession
+	err := r.pool.QueryRow(ctx, query, sessionID).Scan(
+		&link.LinkID, &link.Provider, &link.NameID, &link.NameIDFormat,
+		&link.SessionIndex, &link.SessionID, &link.ChallengeID, &link.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Session did not come from a SAML sign-in
+		}
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// EndSessions invalidates every session linked to an identity provider session and
+// expires logins still waiting on a challenge. An empty sessionIndex matches all of the
+// subject's sessions. It returns the sessions it invalidated, with only their IDs and users set.
+func (r *SAMLRepository) EndSessions(ctx context.Context, provider, nameID, sessionIndex string) ([]*Session, error) {
+	query := `
+		WITH links AS (
+			DELETE FROM auth.saml_sessions
+			WHERE provider = $1 AND name_id = $2 AND ($3 = '' OR session_index = $3)
+			RETURNING session_id, challenge_id
+		), challenges AS (
+			UPDATE auth.login_challenges SET
+				expires_at = NOW()
+			WHERE challenge_id IN (SELECT challenge_id FROM links) AND completed_at IS NULL
+		)
+		UPDATE auth.sessions SET
+			is_valid = false,
+			last_active_at = NOW()
+		WHERE session_id IN (SELECT session_id FROM links) AND is_valid = true
+		RETURNING session_id, user_id`
+
+	rows, err := r.pool.Query(ctx, query, provider, nameID, sessionIndex)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.SessionID, &session.UserID); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteExpired removes requests that were never answered and assertions that can no
+// longer be replayed
+func (r *SAMLRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	query := `
+		WITH requests AS (
+			DELETE FROM auth.saml_requests WHERE expires_at < $1 RETURNING 1
+		), assertions AS (
+			DELETE FROM auth.saml_assertions WHERE expires_at < $1 RETURNING 1
+		)
+		SELECT (SELECT COUNT(*) FROM requests) + (SELECT COUNT(*) FROM assertions)`
+
+	var deleted int64
+	err := r.pool.QueryRow(ctx, query, before).Scan(&deleted)
+	return deleted, err
+}
@@ -0,0 +1,378 @@
+// models/scim.go
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+var (
+	ErrSCIMGroupExists    = errors.New("a group with this name already exists")
+	ErrSCIMMemberNotFound = errors.New("group member does not exist")
+)
+
+// PostgreSQL error codes for constraint violations
+const (
+	errUniqueViolation     = "23505"
+	errForeignKeyViolation = "23503"
+)
+
+// SCIMGroup is a group pushed by a SCIM provisioning client, from the auth.scim_groups table
+type SCIMGroup struct {
+	GroupID     uuid.UUID
+	DisplayName string
+	ExternalID  *string
+	Members     []SCIMGroupMember
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// SCIMGroupMember is a user in a SCIM group
+type SCIMGroupMember struct {
+	UserID   uuid.UUID
+	Username string
+}
+
+// Fields groups can be searched by
+const (
+	GroupFieldID          = "id"
+	GroupFieldDisplayName = "display_name"
+	GroupFieldExternalID  = "external_id"
+	GroupFieldMemberID    = "member_id"
+	GroupFieldCreatedAt   = "created_at"
+	GroupFieldUpdatedAt   = "updated_at"
+)
+
+var groupFilterFields = map[string]filterField{
+	GroupFieldID:          {column: "g.group_id::text", kind: filterExactText},
+	GroupFieldDisplayName: {column: "g.display_name", kind: filterText},
+	GroupFieldExternalID:  {column: "g.external_id", kind: filterExactText},
+	GroupFieldCreatedAt:   {column: "g.created_at", kind: filterTime},
+	GroupFieldUpdatedAt:   {column: "g.updated_at", kind: filterTime},
+	GroupFieldMemberID: {
+		column: "m.user_id::text",
+		kind:   filterExactText,
+		exists: "SELECT 1 FROM auth.scim_group_members m WHERE m.group_id = g.group_id",
+	},
+}
+
+// SCIMRepository handles database operations for SCIM provisioning that the user and
+// role repositories don't cover
+type SCIMRepository struct {
+	pool *pgxpool.Pool
+}
+
+// NewSCIMRepository creates a new SCIMRepository
+func NewSCIMRepository(pool *pgxpool.Pool) *SCIMRepository {
+	return &SCIMRepository{pool: pool}
+}
+
+// SetExternalID records that a user is managed by the provisioning client, along with
+// the client's identifier for them, which may be nil
+func (r *SCIMRepository) SetExternalID(ctx context.Context, userID uuid.UUID, externalID *string) error {
+	query := `
+		INSERT INTO auth.scim_users (user_id, external_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET external_id = EXCLUDED.external_id`
+
+	_, err := r.pool.Exec(ctx, query, userID, externalID)
+	return err
+}
+
+// GetExternalIDs retrieves the provisioning client's identifiers for users. Users without one are left out.
+func (r *SCIMRepository) GetExternalIDs(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	query := `
+		SELECT user_id, external_id FROM auth.scim_users
+		WHERE user_id = ANY($1::uuid[]) AND external_id IS NOT NULL`
+
+	rows, err := r.pool.Query(ctx, query, uuidStrings(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	externalIDs := make(map[uuid.UUID]string)
+	for rows.Next() {
+		var userID uuid.UUID
+		var externalID string
+		if err := rows.Scan(&userID, &externalID); err != nil {
+			return nil, err
+		}
+		externalIDs[userID] = externalID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return externalIDs, nil
+}
+
+// GetGroupsByUserIDs retrieves the groups each user belongs to, with only their IDs and names set
+func (r *SCIMRepository) GetGroupsByUserIDs(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]*SCIMGroup, error) {
+	query := `
+		SELECT m.user_id, g.group_id, g.display_name
+		FROM auth.scim_group_members m
+		JOIN auth.scim_groups g ON g.group_id = m.group_id
+		WHERE m.user_id = ANY($1::uuid[])
+		ORDER BY g.display_name`
+
+	rows, err := r.pool.Query(ctx, query, uuidStrings(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make(map[uuid.UUID][]*SCIMGroup)
+	for rows.Next() {
+		var userID uuid.UUID
+		var group SCIMGroup
+		if err := rows.Scan(&userID, &group.GroupID, &group.DisplayName); err != nil {
+			return nil, err
+		}
+		groups[userID] = append(groups[userID], &group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+// CreateGroup adds a group along with its members
+func (r *SCIMRepository) CreateGroup(ctx context.Context, group *SCIMGroup, members []uuid.UUID) error {
+	if group.GroupID == uuid.Nil {
+		group.GroupID = uuid.New()
+	}
+
+	err := r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+			INSERT INTO auth.scim_groups (group_id, display_name, external_id)
+			VALUES ($1, $2, $3)
+			RETURNING created_at, updated_at`
+
+		err := tx.QueryRow(ctx, query, group.GroupID, group.DisplayName, group.ExternalID).Scan(&group.CreatedAt, &group.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		return addGroupMembers(ctx, tx, group.GroupID, members)
+	})
+	return groupError(err)
+}
+
+// GetGroup retrieves a group and its members
+func (r *SCIMRepository) GetGroup(ctx context.Context, groupID uuid.UUID) (*SCIMGroup, error) {
+	query := `
+		SELECT group_id, display_name, external_id, created_at, updated_at
+		FROM auth.scim_groups
+		WHERE group_id = $1`
+
+	var group SCIMGroup
+	err := r.pool.QueryRow(ctx, query, groupID).Scan(
+		&group.GroupID, &group.DisplayName, &group.ExternalID, &group.CreatedAt, &group.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Group not found
+		}
+		return nil, err
+	}
+
+	if err := r.loadMembers(ctx, []*SCIMGroup{&group}); err != nil {
+		return nil, err
+	}
+
+	return &group, nil
+}
+
+// SearchGroups retrieves a page of the groups matching a filter, by name, and how many match
+// in all. Members are only loaded if withMembers is set.
+func (r *SCIMRepository) SearchGroups(ctx context.Context, filter *Filter, offset, limit int, withMembers bool) ([]*SCIMGroup, int, error) {
+	builder := &filterBuilder{fields: groupFilterFields}
+	where, err := builder.build(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	countQuery := `SELECT COUNT(*) FROM auth.scim_groups g WHERE ` + where
+	if err := r.pool.QueryRow(ctx, countQuery, builder.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 || offset >= total {
+		return nil, total, nil
+	}
+
+	args := append(builder.args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT group_id, display_name, external_id, created_at, updated_at
+		FROM auth.scim_groups g
+		WHERE %s
+		ORDER BY lower(display_name), group_id
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var groups []*SCIMGroup
+	for rows.Next() {
+		var group SCIMGroup
+		if err := rows.Scan(&group.GroupID, &group.DisplayName, &group.ExternalID, &group.CreatedAt, &group.UpdatedAt); err != nil {
+			return nil, 0, err
+		}
+		groups = append(groups, &group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if withMembers {
+		if err := r.loadMembers(ctx, groups); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return groups, total, nil
+}
+
+// SaveGroup updates a group's name and external ID and adds and removes members. Changes
+// to membership are applied as a difference, so concurrent updates don't undo each other.
+func (r *SCIMRepository) SaveGroup(ctx context.Context, group *SCIMGroup, added, removed []uuid.UUID) error {
+	err := r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+			UPDATE auth.scim_groups SET
+				display_name = $2,
+				external_id = $3,
+				updated_at = NOW()
+			WHERE group_id = $1
+			RETURNING updated_at`
+
+		if err := tx.QueryRow(ctx, query, group.GroupID, group.DisplayName, group.ExternalID).Scan(&group.UpdatedAt); err != nil {
+			return err
+		}
+
+		if len(removed) > 0 {
+			query := `DELETE FROM auth.scim_group_members WHERE group_id = $1 AND user_id = ANY($2::uuid[])`
+			if _, err := tx.Exec(ctx, query, group.GroupID, uuidStrings(removed)); err != nil {
+				return err
+			}
+		}
+		return addGroupMembers(ctx, tx, group.GroupID, added)
+	})
+	return groupError(err)
+}
+
+// DeleteGroup deletes a group, returning whether it existed and who its members were
+func (r *SCIMRepository) DeleteGroup(ctx context.Context, groupID uuid.UUID) (bool, []uuid.UUID, error) {
+	query := `
+		WITH members AS (
+			SELECT user_id FROM auth.scim_group_members WHERE group_id = $1
+		), deleted AS (
+			DELETE FROM auth.scim_groups WHERE group_id = $1 RETURNING 1
+		)
+		SELECT EXISTS (SELECT 1 FROM deleted), ARRAY(SELECT user_id::text FROM members)`
+
+	var deleted bool
+	var memberIDs []string
+	if err := r.pool.QueryRow(ctx, query, groupID).Scan(&deleted, &memberIDs); err != nil {
+		return false, nil, err
+	}
+
+	members := make([]uuid.UUID, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		userID, err := uuid.Parse(id)
+		if err != nil {
+			return false, nil, err
+		}
+		members = append(members, userID)
+	}
+
+	return deleted, members, nil
+}
+
+// Helper function to load the members of groups
+func (r *SCIMRepository) loadMembers(ctx context.Context, groups []*SCIMGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*SCIMGroup, len(groups))
+	groupIDs := make([]uuid.UUID, 0, len(groups))
+	for _, group := range groups {
+		byID[group.GroupID] = group
+		groupIDs = append(groupIDs, group.GroupID)
+	}
+
+	query := `
+		SELECT m.group_id, u.user_id, u.username
+		FROM auth.scim_group_members m
+		JOIN auth.users u ON u.user_id = m.user_id
+		WHERE m.group_id = ANY($1::uuid[]) AND u.deleted_at IS NULL
+		ORDER BY u.username`
+
+	rows, err := r.pool.Query(ctx, query, uuidStrings(groupIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var groupID uuid.UUID
+		var member SCIMGroupMember
+		if err := rows.Scan(&groupID, &member.UserID, &member.Username); err != nil {
+			return err
+		}
+		byID[groupID].Members = append(byID[groupID].Members, member)
+	}
+
+	return rows.Err()
+}
+
+// Helper function to add users to a group, skipping those already in it
+func addGroupMembers(ctx context.Context, tx pgx.Tx, groupID uuid.UUID, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO auth.scim_group_members (group_id, user_id)
+		SELECT $1, unnest($2::uuid[])
+		ON CONFLICT (group_id, user_id) DO NOTHING`
+
+	_, err := tx.Exec(ctx, query, groupID, uuidStrings(userIDs))
+	return err
+}
+
+// Helper function to turn constraint violations on groups into errors callers can check
+func groupError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case errUniqueViolation:
+			return ErrSCIMGroupExists
+		case errForeignKeyViolation:
+			return ErrSCIMMemberNotFound
+		}
+	}
+	return err
+}
+
+// Helper function to pass UUIDs as a text array, cast to uuid[] in the query
+func uuidStrings(ids []uuid.UUID) []string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+	return values
+}
@@ -15,15 +15,36 @@ import (
 type Session struct {
 	SessionID    uuid.UUID `json:"session_id"`
 	UserID       uuid.UUID `json:"user_id"`
-	Token        string    `json:"token"`
+	Token        string    `json:"token,omitempty"`
 	IPAddress    string    `json:"ip_address,omitempty"`
 	UserAgent    string    `json:"user_agent,omitempty"`
-	ExpiresAt    time.Time `json:"expires_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // Idle expiry, pushed forward on activity
 	CreatedAt    time.Time `json:"created_at"`
 	LastActiveAt time.Time `json:"last_active_at"`
 	IsValid      bool      `json:"is_valid"`
+
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"` // Hard limit that activity cannot extend
+	IsPersistent      bool      `json:"is_persistent"`       // Created with "remember me"
+
+	DeviceID      *uuid.UUID `json:"device_id,omitempty"`
+	DeviceBrowser string     `json:"device_browser,omitempty"`
+	DeviceOS      string     `json:"device_os,omitempty"`
+	DeviceType    string     `json:"device_type,omitempty"`
+
+	GeoCountry   string   `json:"geo_country,omitempty"`
+	GeoLatitude  *float64 `json:"-"`
+	GeoLongitude *float64 `json:"-"`
+	RiskScore    int      `json:"risk_score"`
+
+	ActiveOrgID *uuid.UUID `json:"active_org_id,omitempty"` // Organization the session is working in
+
+	ImpersonatorID        *uuid.UUID `json:"impersonator_id,omitempty"` // Admin acting as the user, for impersonation sessions
+	ImpersonatorSessionID *uuid.UUID `json:"-"`                         // The admin's own session
 }
 
+// ErrSessionLimitReached is returned when a user already has the maximum number of active sessions
+var ErrSessionLimitReached = errors.New("concurrent session limit reached")
+
 // SessionRepository handles database operations for sessions
 type SessionRepository struct {
 	pool *pgxpool.Pool
@@ -36,38 +57,81 @@ func NewSessionRepository(pool *pgxpool.Pool) *SessionRepository {
 
 // Create adds a new session to the database
 func (r *SessionRepository) Create(ctx context.Context, session *Session) error {
-	// Generate a new UUID if not provided
-	if session.SessionID == uuid.Nil {
-		session.SessionID = uuid.New()
-	}
+	return insertSession(ctx, r.pool, session)
+}
 
-	// Set timestamps if not provided
-	now := time.Now()
-	if session.CreatedAt.IsZero() {
-		session.CreatedAt = now
-	}
-	if session.LastActiveAt.IsZero() {
-		session.LastActiveAt = now
-	}
+// CreateWithinLimit adds a new session while keeping the user at no more than maxSessions
+// active sessions. With evict set, the least recently active sessions are invalidated to make
+// room and returned; otherwise ErrSessionLimitReached is returned. The user's row is locked
+// for the duration so concurrent logins cannot overshoot the limit. Impersonation sessions
+// neither count towards the limit nor get evicted.
+func (r *SessionRepository) CreateWithinLimit(ctx context.Context, session *Session, maxSessions int, evict bool) ([]*Session, error) {
+	var evicted []*Session
+
+	err := r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT 1 FROM auth.users WHERE user_id = $1 FOR UPDATE`, session.UserID); err != nil {
+			return err
+		}
 
-	// SQL query
-	query := `
-		INSERT INTO auth.sessions (
-			session_id, user_id, token, ip_address, user_agent,
-			expires_at, created_at, last_active_at, is_valid
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9
-		) RETURNING session_id, created_at`
+		if !evict {
+			var active int
+			query := `
+				SELECT COUNT(*) FROM auth.sessions
+				WHERE user_id = $1 AND is_valid = true AND impersonator_id IS NULL
+					AND expires_at > NOW() AND absolute_expires_at > NOW()`
+			if err := tx.QueryRow(ctx, query, session.UserID).Scan(&active); err != nil {
+				return err
+			}
+			if active >= maxSessions {
+				return ErrSessionLimitReached
+			}
+			return insertSession(ctx, tx, session)
+		}
 
-	// Execute query
-	row := r.pool.QueryRow(ctx, query,
-		session.SessionID, session.UserID, session.Token,
-		session.IPAddress, session.UserAgent, session.ExpiresAt,
-		session.CreatedAt, session.LastActiveAt, session.IsValid,
-	)
+		// Keep the most recently active maxSessions-1 sessions, leaving room for the new one
+		query := `
+			WITH excess AS (
+				SELECT session_id FROM auth.sessions
+				WHERE user_id = $1 AND is_valid = true AND impersonator_id IS NULL
+					AND expires_at > NOW() AND absolute_expires_at > NOW()
+				ORDER BY last_active_at DESC
+				OFFSET $2
+			)
+			UPDATE auth.sessions s SET
+				is_valid = false
+			FROM excess
+			WHERE s.session_id = excess.session_id
+			RETURNING
+				s.session_id, s.user_id, s.token, s.ip_address, s.user_agent,
+				s.expires_at, s.created_at, s.last_active_at, s.is_valid, s.absolute_expires_at,
+				s.is_persistent, s.device_id, s.device_browser, s.device_os, s.device_type,
+				s.geo_country, s.geo_latitude, s.geo_longitude, s.risk_score, s.active_org_id,
+				s.impersonator_id, s.impersonator_session_id`
+
+		rows, err := tx.Query(ctx, query, session.UserID, maxSessions-1)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var old Session
+			if err := scanSessionFromRows(rows, &old); err != nil {
+				return err
+			}
+			evicted = append(evicted, &old)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
 
-	// Scan result
-	return row.Scan(&session.SessionID, &session.CreatedAt)
+		return insertSession(ctx, tx, session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return evicted, nil
 }
 
 // GetByID retrieves a session by ID
@@ -75,7 +139,10 @@ func (r *SessionRepository) GetByID(ctx context.Context, sessionID uuid.UUID) (*
 	query := `
 		SELECT 
 			session_id, user_id, token, ip_address, user_agent,
-			expires_at, created_at, last_active_at, is_valid
+			expires_at, created_at, last_active_at, is_valid, absolute_expires_at,
+			is_persistent, device_id, device_browser, device_os, device_type,
+			geo_country, geo_latitude, geo_longitude, risk_score, active_org_id,
+			impersonator_id, impersonator_session_id
 		FROM auth.sessions
 		WHERE session_id = $1`
 
@@ -98,7 +165,10 @@ func (r *SessionRepository) GetByToken(ctx context.Context, token string) (*Sess
 	query := `
 		SELECT 
 			session_id, user_id, token, ip_address, user_agent,
-			expires_at, created_at, last_active_at, is_valid
+			expires_at, created_at, last_active_at, is_valid, absolute_expires_at,
+			is_persistent, device_id, device_browser, device_os, device_type,
+			geo_country, geo_latitude, geo_longitude, risk_score, active_org_id,
+			impersonator_id, impersonator_session_id
 		FROM auth.sessions
 		WHERE token = $1`
 
@@ -121,7 +191,10 @@ func (r *SessionRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID
 	query := `
 		SELECT 
 			session_id, user_id, token, ip_address, user_agent,
-			expires_at, created_at, last_active_at, is_valid
+			expires_at, created_at, last_active_at, is_valid, absolute_expires_at,
+			is_persistent, device_id, device_browser, device_os, device_type,
+			geo_country, geo_latitude, geo_longitude, risk_score, active_org_id,
+			impersonator_id, impersonator_session_id
 		FROM auth.sessions
 		WHERE user_id = $1
 		ORDER BY created_at DESC`
@@ -148,6 +221,46 @@ func (r *SessionRepository) GetAllByUserID(ctx context.Context, userID uuid.UUID
 	return sessions, nil
 }
 
+// GetLatestByUserID retrieves the user's most recently created session, valid or not
+func (r *SessionRepository) GetLatestByUserID(ctx context.Context, userID uuid.UUID) (*Session, error) {
+	query := `
+		SELECT 
+			session_id, user_id, token, ip_address, user_agent,
+			expires_at, created_at, last_active_at, is_valid, absolute_expires_at,
+			is_persistent, device_id, device_browser, device_os, device_type,
+			geo_country, geo_latitude, geo_longitude, risk_score, active_org_id,
+			impersonator_id, impersonator_session_id
+		FROM auth.sessions
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1`
+
+	var session Session
+	err := scanSession(r.pool.QueryRow(ctx, query, userID), &session)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // No sessions yet
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// GetCountryHistory checks whether the user has had a session from a country before.
+// known is false when none of the user's sessions have a recorded country yet.
+func (r *SessionRepository) GetCountryHistory(ctx context.Context, userID uuid.UUID, countryCode string) (seen bool, known bool, err error) {
+	query := `
+		SELECT
+			COALESCE(bool_or(geo_country = $2), false),
+			COALESCE(bool_or(geo_country <> ''), false)
+		FROM auth.sessions
+		WHERE user_id = $1`
+
+	err = r.pool.QueryRow(ctx, query, userID, countryCode).Scan(&seen, &known)
+	return seen, known, err
+}
+
 // Invalidate marks a session as invalid
 func (r *SessionRepository) Invalidate(ctx context.Context, token string) error {
 	query := `
@@ -160,18 +273,84 @@ func (r *SessionRepository) Invalidate(ctx context.Context, token string) error
 	return err
 }
 
-// InvalidateAllForUser invalidates all sessions for a user
-func (r *SessionRepository) InvalidateAllForUser(ctx context.Context, userID uuid.UUID) error {
+// InvalidateAllForUser invalidates all sessions for a user and returns how many were valid
+func (r *SessionRepository) InvalidateAllForUser(ctx context.Context, userID uuid.UUID) (int64, error) {
 	query := `
 		UPDATE auth.sessions SET
 			is_valid = false,
 			last_active_at = NOW()
-		WHERE user_id = $1`
+		WHERE user_id = $1 AND is_valid = true`
+
+	result, err := r.pool.Exec(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// InvalidateAllForDevice invalidates all sessions started from a device
+func (r *SessionRepository) InvalidateAllForDevice(ctx context.Context, deviceID uuid.UUID) (int64, error) {
+	query := `
+		UPDATE auth.sessions SET
+			is_valid = false,
+			last_active_at = NOW()
+		WHERE device_id = $1 AND is_valid = true`
+
+	result, err := r.pool.Exec(ctx, query, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// SetActiveOrganization sets the organization a session is working in; nil clears it
+func (r *SessionRepository) SetActiveOrganization(ctx context.Context, sessionID uuid.UUID, orgID *uuid.UUID) error {
+	query := `
+		UPDATE auth.sessions SET
+			active_org_id = $2
+		WHERE session_id = $1`
 
-	_, err := r.pool.Exec(ctx, query, userID)
+	_, err := r.pool.Exec(ctx, query, sessionID, orgID)
 	return err
 }
 
+// InvalidateExpiredImpersonations invalidates impersonation sessions that have expired but
+// were never ended, and returns them
+func (r *SessionRepository) InvalidateExpiredImpersonations(ctx context.Context) ([]*Session, error) {
+	query := `
+		UPDATE auth.sessions SET
+			is_valid = false
+		WHERE impersonator_id IS NOT NULL AND is_valid = true
+			AND (expires_at <= NOW() OR absolute_expires_at <= NOW())
+		RETURNING
+			session_id, user_id, token, ip_address, user_agent,
+			expires_at, created_at, last_active_at, is_valid, absolute_expires_at,
+			is_persistent, device_id, device_browser, device_os, device_type,
+			geo_country, geo_latitude, geo_longitude, risk_score, active_org_id,
+			impersonator_id, impersonator_session_id`
+
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		if err := scanSessionFromRows(rows, &session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
 // UpdateLastActiveAt updates the last_active_at timestamp
 func (r *SessionRepository) UpdateLastActiveAt(ctx context.Context, sessionID uuid.UUID) error {
 	query := `
@@ -183,6 +362,42 @@ func (r *SessionRepository) UpdateLastActiveAt(ctx context.Context, sessionID uu
 	return err
 }
 
+// Touch records activity on a session and slides its idle expiry forward, capped at the absolute expiry.
+// Sessions touched within minInterval are left alone, so a busy client does not write on every request.
+// It reports whether the session was updated.
+func (r *SessionRepository) Touch(ctx context.Context, session *Session, idleTimeout, minInterval time.Duration) (bool, error) {
+	query := `
+		UPDATE auth.sessions SET
+			last_active_at = NOW(),
+			expires_at = LEAST(NOW() + make_interval(secs => $2::float8), absolute_expires_at)
+		WHERE session_id = $1
+		AND is_valid = true
+		AND last_active_at <= NOW() - make_interval(secs => $3::float8)
+		RETURNING last_active_at, expires_at`
+
+	err := r.pool.QueryRow(ctx, query, session.SessionID, idleTimeout.Seconds(), minInterval.Seconds()).
+		Scan(&session.LastActiveAt, &session.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil // Touched recently, possibly by another replica
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CountActive counts the sessions that are valid and have not expired, across all users
+func (r *SessionRepository) CountActive(ctx context.Context) (int64, error) {
+	query := `
+		SELECT COUNT(*) FROM auth.sessions
+		WHERE is_valid = true AND expires_at > NOW() AND absolute_expires_at > NOW()`
+
+	var count int64
+	err := r.pool.QueryRow(ctx, query).Scan(&count)
+	return count, err
+}
+
 // DeleteExpiredSessions deletes all expired sessions
 func (r *SessionRepository) DeleteExpiredSessions(ctx context.Context) (int64, error) {
 	query := `DELETE FROM auth.sessions WHERE expires_at < NOW()`
@@ -200,6 +415,57 @@ func (r *SessionRepository) DeleteByID(ctx context.Context, sessionID uuid.UUID)
 	return err
 }
 
+// Helper function to insert a session using either the pool or a transaction
+func insertSession(ctx context.Context, q interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}, session *Session) error {
+	// Generate a new UUID if not provided
+	if session.SessionID == uuid.Nil {
+		session.SessionID = uuid.New()
+	}
+
+	// Set timestamps if not provided
+	now := time.Now()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	if session.LastActiveAt.IsZero() {
+		session.LastActiveAt = now
+	}
+
+	// SQL query
+	query := `
+		INSERT INTO auth.sessions (
+			session_id, user_id, token, ip_address, user_agent,
+			expires_at, created_at, last_active_at, is_valid, absolute_expires_at,
+			is_persistent, device_id, device_browser, device_os, device_type,
+			geo_country, geo_latitude, geo_longitude, risk_score, active_org_id,
+			impersonator_id, impersonator_session_id
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15,
+			$16, $17, $18, $19, $20, $21, $22
+		) RETURNING session_id, created_at`
+
+	// Sessions without an absolute expiry cannot outlive their first expiry
+	if session.AbsoluteExpiresAt.IsZero() {
+		session.AbsoluteExpiresAt = session.ExpiresAt
+	}
+
+	// Execute query
+	row := q.QueryRow(ctx, query,
+		session.SessionID, session.UserID, session.Token,
+		session.IPAddress, session.UserAgent, session.ExpiresAt,
+		session.CreatedAt, session.LastActiveAt, session.IsValid,
+		session.AbsoluteExpiresAt, session.IsPersistent, session.DeviceID,
+		session.DeviceBrowser, session.DeviceOS, session.DeviceType,
+		session.GeoCountry, session.GeoLatitude, session.GeoLongitude, session.RiskScore,
+		session.ActiveOrgID, session.ImpersonatorID, session.ImpersonatorSessionID,
+	)
+
+	// Scan result
+	return row.Scan(&session.SessionID, &session.CreatedAt)
+}
+
 // Helper function to scan a session from a row
 func scanSession(row pgx.Row, session *Session) error {
 	return row.Scan(
@@ -212,6 +478,19 @@ func scanSession(row pgx.Row, session *Session) error {
 		&session.CreatedAt,
 		&session.LastActiveAt,
 		&session.IsValid,
+		&session.AbsoluteExpiresAt,
+		&session.IsPersistent,
+		&session.DeviceID,
+		&session.DeviceBrowser,
+		&session.DeviceOS,
+		&session.DeviceType,
+		&session.GeoCountry,
+		&session.GeoLatitude,
+		&session.GeoLongitude,
+		&session.RiskScore,
+		&session.ActiveOrgID,
+		&session.ImpersonatorID,
+		&session.ImpersonatorSessionID,
 	)
 }
 
@@ -227,5 +506,18 @@ func scanSessionFromRows(rows pgx.Rows, session *Session) error {
 		&session.CreatedAt,
 		&session.LastActiveAt,
 		&session.IsValid,
+		&session.AbsoluteExpiresAt,
+		&session.IsPersistent,
+		&session.DeviceID,
+		&session.DeviceBrowser,
+		&session.DeviceOS,
+		&session.DeviceType,
+		&session.GeoCountry,
+		&session.GeoLatitude,
+		&session.GeoLongitude,
+		&session.RiskScore,
+		&session.ActiveOrgID,
+		&session.ImpersonatorID,
+		&session.ImpersonatorSessionID,
 	)
 }
@@ -3,9 +3,12 @@ package models
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"golang.org/x/crypto/bcrypt"
@@ -26,12 +29,22 @@ type User struct {
 	PasswordResetExpiresAt  *time.Time `json:"-"`
 	FailedLoginAttempts     int        `json:"-"`
 	LockedUntil             *time.Time `json:"-"`
+	LockoutCount            int        `json:"-"`
+	LastLockedAt            *time.Time `json:"-"`
 	LastLoginAt             *time.Time `json:"last_login_at,omitempty"`
 	CreatedAt               time.Time  `json:"created_at"`
 	UpdatedAt               time.Time  `json:"updated_at"`
 	IsActive                bool       `json:"is_active"`
+	DeletedAt               *time.Time `json:"deleted_at,omitempty"` // Set while the user is soft deleted
 }
 
+// ErrUserNotDeleted is returned when restoring or purging a user that hasn't been deleted
+var ErrUserNotDeleted = errors.New("user has not been deleted")
+
+// ErrUserIdentifiersReleased is returned when restoring a deleted user whose email and
+// username have already been released for others to use
+var ErrUserIdentifiersReleased = errors.New("deleted user's email and username have been released")
+
 // UserRepository handles database operations for users
 type UserRepository struct {
 	pool *pgxpool.Pool
@@ -55,6 +68,10 @@ func (r *UserRepository) Create(ctx context.Context, user *User, password string
 		user.UserID = uuid.New()
 	}
 
+	// Store the email and username in their canonical form
+	user.Email = NormalizeEmail(user.Email)
+	user.Username = NormalizeUsername(user.Username)
+
 	// Set timestamps
 	now := time.Now()
 	user.CreatedAt = now
@@ -76,20 +93,22 @@ func (r *UserRepository) Create(ctx context.Context, user *User, password string
 		user.CreatedAt, user.UpdatedAt,
 	)
 
-	// Scan result
-	return row.Scan(&user.UserID, &user.CreatedAt, &user.UpdatedAt)
+	// Scan result; a taken email or username surfaces as a unique violation, so concurrent
+	// registrations cannot both succeed
+	return userError(row.Scan(&user.UserID, &user.CreatedAt, &user.UpdatedAt))
 }
 
-// GetByID retrieves a user by their ID
+// GetByID retrieves a user by their ID. Deleted users are not found.
 func (r *UserRepository) GetByID(ctx context.Context, userID uuid.UUID) (*User, error) {
 	query := `
 		SELECT 
 			user_id, username, email, password_hash, first_name, last_name,
 			is_email_verified, email_verification_token, email_verification_sent_at,
 			password_reset_token, password_reset_expires_at, failed_login_attempts,
-			locked_until, last_login_at, created_at, updated_at, is_active
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
 		FROM auth.users
-		WHERE user_id = $1`
+		WHERE user_id = $1 AND deleted_at IS NULL`
 
 	row := r.pool.QueryRow(ctx, query, userID)
 
@@ -105,18 +124,20 @@ func (r *UserRepository) GetByID(ctx context.Context, userID uuid.UUID) (*User,
 	return &user, nil
 }
 
-// GetByEmail retrieves a user by their email
+// GetByEmail retrieves a user by their email, ignoring case: emails are stored normalized,
+// so the lookup compares normalized forms. Deleted users are not found.
 func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 		SELECT 
 			user_id, username, email, password_hash, first_name, last_name,
 			is_email_verified, email_verification_token, email_verification_sent_at,
 			password_reset_token, password_reset_expires_at, failed_login_attempts,
-			locked_until, last_login_at, created_at, updated_at, is_active
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
 		FROM auth.users
-		WHERE email = $1`
+		WHERE email = $1 AND deleted_at IS NULL`
 
-	row := r.pool.QueryRow(ctx, query, email)
+	row := r.pool.QueryRow(ctx, query, NormalizeEmail(email))
 
 	var user User
 	err := scanUser(row, &user)
@@ -130,18 +151,20 @@ func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*User, e
 	return &user, nil
 }
 
-// GetByUsername retrieves a user by their username
+// GetByUsername retrieves a user by their username, ignoring case: usernames are stored
+// normalized, so the lookup compares normalized forms. Deleted users are not found.
 func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*User, error) {
 	query := `
 		SELECT 
 			user_id, username, email, password_hash, first_name, last_name,
 			is_email_verified, email_verification_token, email_verification_sent_at,
 			password_reset_token, password_reset_expires_at, failed_login_attempts,
-			locked_until, last_login_at, created_at, updated_at, is_active
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
 		FROM auth.users
-		WHERE username = $1`
+		WHERE username = $1 AND deleted_at IS NULL`
 
-	row := r.pool.QueryRow(ctx, query, username)
+	row := r.pool.QueryRow(ctx, query, NormalizeUsername(username))
 
 	var user User
 	err := scanUser(row, &user)
@@ -155,8 +178,12 @@ func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*U
 	return &user, nil
 }
 
-// Update updates a user's information
+// Update updates a user's information. The lockout state is left alone: it only changes
+// through IncrementFailedLoginAttempts, RecordLogin and Unlock, so saving a user read
+// earlier cannot undo a lockout made in the meantime.
 func (r *UserRepository) Update(ctx context.Context, user *User) error {
+	user.Email = NormalizeEmail(user.Email)
+	user.Username = NormalizeUsername(user.Username)
 	user.UpdatedAt = time.Now()
 
 	query := `
@@ -170,62 +197,225 @@ func (r *UserRepository) Update(ctx context.Context, user *User) error {
 			email_verification_sent_at = $7,
 			password_reset_token = $8,
 			password_reset_expires_at = $9,
-			failed_login_attempts = $10,
-			locked_until = $11,
-			last_login_at = $12,
-			updated_at = $13,
-			is_active = $14
-		WHERE user_id = $15
+			last_login_at = $10,
+			updated_at = $11,
+			is_active = $12
+		WHERE user_id = $13
 		RETURNING updated_at`
 
 	row := r.pool.QueryRow(ctx, query,
 		user.Username, user.Email, user.FirstName, user.LastName,
 		user.IsEmailVerified, user.EmailVerificationToken, user.EmailVerificationSentAt,
-		user.PasswordResetToken, user.PasswordResetExpiresAt,
-		user.FailedLoginAttempts, user.LockedUntil, user.LastLoginAt,
+		user.PasswordResetToken, user.PasswordResetExpiresAt, user.LastLoginAt,
 		user.UpdatedAt, user.IsActive, user.UserID,
 	)
 
-	return row.Scan(&user.UpdatedAt)
+	return userError(row.Scan(&user.UpdatedAt))
 }
 
-// UpdatePassword updates a user's password
-func (r *UserRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, newPassword string) error {
+// UpdatePassword updates a user's password. The old hash joins the password history,
+// which is then trimmed to the most recent keepHistory entries.
+func (r *UserRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, newPassword string, keepHistory int) error {
 	// Generate new password hash
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
-	// Update the password hash and reset any password reset fields
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		// Keep the old hash in the password history, then update the password hash
+		// and reset any password reset fields
+		query := `
+			WITH previous AS (
+				INSERT INTO auth.password_history (user_id, password_hash)
+				SELECT user_id, password_hash FROM auth.users WHERE user_id = $2
+			)
+			UPDATE auth.users SET
+				password_hash = $1,
+				password_reset_token = NULL,
+				password_reset_expires_at = NULL,
+				updated_at = NOW()
+			WHERE user_id = $2`
+		if _, err := tx.Exec(ctx, query, string(hashedPassword), userID); err != nil {
+			return err
+		}
+
+		// Old hashes beyond what the password policy checks are of no use, only a liability
+		query = `
+			DELETE FROM auth.password_history
+			WHERE user_id = $1
+			AND history_id NOT IN (
+				SELECT history_id FROM auth.password_history
+				WHERE user_id = $1
+				ORDER BY created_at DESC
+				LIMIT $2
+			)`
+		_, err := tx.Exec(ctx, query, userID, max(keepHistory, 0))
+		return err
+	})
+}
+
+// Delete soft deletes a user: they are kept, but no longer found by the lookups and
+// listings, and their sessions are invalidated. Admins can restore them until their email
+// and username are released.
+func (r *UserRepository) Delete(ctx context.Context, userID uuid.UUID) error {
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+			UPDATE auth.users SET
+				deleted_at = NOW(),
+				updated_at = NOW()
+			WHERE user_id = $1 AND deleted_at IS NULL`
+		if _, err := tx.Exec(ctx, query, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, `UPDATE auth.sessions SET is_valid = false WHERE user_id = $1 AND is_valid`, userID)
+		return err
+	})
+}
+
+// GetByIDIncludingDeleted retrieves a user by their ID, whether or not they are deleted
+func (r *UserRepository) GetByIDIncludingDeleted(ctx context.Context, userID uuid.UUID) (*User, error) {
+	query := `
+		SELECT 
+			user_id, username, email, password_hash, first_name, last_name,
+			is_email_verified, email_verification_token, email_verification_sent_at,
+			password_reset_token, password_reset_expires_at, failed_login_attempts,
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
+		FROM auth.users
+		WHERE user_id = $1`
+
+	row := r.pool.QueryRow(ctx, query, userID)
+
+	var user User
+	err := scanUser(row, &user)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // User not found
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// ListDeleted retrieves a paginated list of deleted users, most recently deleted first
+func (r *UserRepository) ListDeleted(ctx context.Context, offset, limit int) ([]*User, error) {
+	query := `
+		SELECT 
+			user_id, username, email, password_hash, first_name, last_name,
+			is_email_verified, email_verification_token, email_verification_sent_at,
+			password_reset_token, password_reset_expires_at, failed_login_attempts,
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
+		FROM auth.users
+		WHERE deleted_at IS NOT NULL
+		ORDER BY deleted_at DESC
+		LIMIT $1 OFFSET $2`
+
+	rows, err := r.pool.Query(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		if err := scanUserFromRows(rows, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Restore undoes a soft delete. It fails with ErrUserNotDeleted if the user isn't deleted
+// and ErrUserIdentifiersReleased once their email and username have been released.
+func (r *UserRepository) Restore(ctx context.Context, userID uuid.UUID) error {
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var deletedAt, releasedAt *time.Time
+		query := `SELECT deleted_at, identifiers_released_at FROM auth.users WHERE user_id = $1 FOR UPDATE`
+		if err := tx.QueryRow(ctx, query, userID).Scan(&deletedAt, &releasedAt); err != nil {
+			return err
+		}
+		if deletedAt == nil {
+			return ErrUserNotDeleted
+		}
+		if releasedAt != nil {
+			return ErrUserIdentifiersReleased
+		}
+
+		_, err := tx.Exec(ctx, `UPDATE auth.users SET deleted_at = NULL, updated_at = NOW() WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// ReleaseDeletedIdentifiers replaces the email and username of users deleted before a
+// time with placeholders, so others can register with them
+func (r *UserRepository) ReleaseDeletedIdentifiers(ctx context.Context, deletedBefore time.Time) (int64, error) {
 	query := `
 		UPDATE auth.users SET
-			password_hash = $1,
+			email = 'deleted-' || user_id || '@deleted.invalid',
+			username = 'deleted-' || user_id,
+			email_verification_token = NULL,
 			password_reset_token = NULL,
-			password_reset_expires_at = NULL,
+			identifiers_released_at = NOW(),
 			updated_at = NOW()
-		WHERE user_id = $2`
+		WHERE deleted_at < $1 AND identifiers_released_at IS NULL`
 
-	_, err = r.pool.Exec(ctx, query, string(hashedPassword), userID)
-	return err
+	result, err := r.pool.Exec(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
-// Delete deletes a user
-func (r *UserRepository) Delete(ctx context.Context, userID uuid.UUID) error {
-	query := `DELETE FROM auth.users WHERE user_id = $1`
-	_, err := r.pool.Exec(ctx, query, userID)
-	return err
+// personalAuditDetails are the audit log details that identify a user, removed when
+// their entries are anonymised
+var personalAuditDetails = []string{
+	"email", "new_email", "old_email", "username", "first_name", "last_name",
+	"subject", "session_ip", "ip_address", "user_agent", "location",
 }
 
-// List retrieves a paginated list of users
+// Purge permanently deletes a user, deleted or not. Their audit log entries are kept but
+// anonymised in the same transaction: detached from the user and stripped of addresses
+// and personal details.
+func (r *UserRepository) Purge(ctx context.Context, userID uuid.UUID) error {
+	return r.pool.BeginFunc(ctx, func(tx pgx.Tx) error {
+		query := `
+			UPDATE auth.audit_log SET
+				user_id = NULL,
+				ip_address = '',
+				user_agent = '',
+				details = details::jsonb - $2::text[]
+			WHERE user_id = $1`
+		if _, err := tx.Exec(ctx, query, userID, personalAuditDetails); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, `DELETE FROM auth.users WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// List retrieves a paginated list of users, leaving out deleted ones
 func (r *UserRepository) List(ctx context.Context, offset, limit int) ([]*User, error) {
 	query := `
 		SELECT 
 			user_id, username, email, password_hash, first_name, last_name,
 			is_email_verified, email_verification_token, email_verification_sent_at,
 			password_reset_token, password_reset_expires_at, failed_login_attempts,
-			locked_until, last_login_at, created_at, updated_at, is_active
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
 		FROM auth.users
+		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2`
 
@@ -251,10 +441,99 @@ func (r *UserRepository) List(ctx context.Context, offset, limit int) ([]*User,
 	return users, nil
 }
 
-// Count returns the total number of users
+// Fields users can be searched by
+const (
+	UserFieldID         = "id"
+	UserFieldUsername   = "username"
+	UserFieldEmail      = "email"
+	UserFieldFirstName  = "first_name"
+	UserFieldLastName   = "last_name"
+	UserFieldIsActive   = "is_active"
+	UserFieldCreatedAt  = "created_at"
+	UserFieldUpdatedAt  = "updated_at"
+	UserFieldExternalID = "external_id" // The identifier a SCIM client gave the user
+	UserFieldGroupID    = "group_id"    // A SCIM group the user belongs to
+)
+
+var userFilterFields = map[string]filterField{
+	UserFieldID:        {column: "u.user_id::text", kind: filterExactText},
+	UserFieldUsername:  {column: "u.username", kind: filterText},
+	UserFieldEmail:     {column: "u.email", kind: filterText},
+	UserFieldFirstName: {column: "u.first_name", kind: filterText},
+	UserFieldLastName:  {column: "u.last_name", kind: filterText},
+	UserFieldIsActive:  {column: "u.is_active", kind: filterBool},
+	UserFieldCreatedAt: {column: "u.created_at", kind: filterTime},
+	UserFieldUpdatedAt: {column: "u.updated_at", kind: filterTime},
+	UserFieldExternalID: {
+		column: "su.external_id",
+		kind:   filterExactText,
+		exists: "SELECT 1 FROM auth.scim_users su WHERE su.user_id = u.user_id",
+	},
+	UserFieldGroupID: {
+		column: "m.group_id::text",
+		kind:   filterExactText,
+		exists: "SELECT 1 FROM auth.scim_group_members m WHERE m.user_id = u.user_id",
+	},
+}
+
+// Search retrieves a page of the users matching a filter, oldest first, and how many
+// match in all. A nil filter matches every user. Deleted users never match.
+func (r *UserRepository) Search(ctx context.Context, filter *Filter, offset, limit int) ([]*User, int, error) {
+	builder := &filterBuilder{fields: userFilterFields}
+	where, err := builder.build(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	where = "u.deleted_at IS NULL AND (" + where + ")"
+	countQuery := `SELECT COUNT(*) FROM auth.users u WHERE ` + where
+	if err := r.pool.QueryRow(ctx, countQuery, builder.args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 || offset >= total {
+		return nil, total, nil
+	}
+
+	args := append(builder.args, limit, offset)
+	query := fmt.Sprintf(`
+		SELECT 
+			user_id, username, email, password_hash, first_name, last_name,
+			is_email_verified, email_verification_token, email_verification_sent_at,
+			password_reset_token, password_reset_expires_at, failed_login_attempts,
+			locked_until, lockout_count, last_locked_at, last_login_at,
+			created_at, updated_at, is_active, deleted_at
+		FROM auth.users u
+		WHERE %s
+		ORDER BY created_at, user_id
+		LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args))
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		if err := scanUserFromRows(rows, &user); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, &user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// Count returns the total number of users, not counting deleted ones
 func (r *UserRepository) Count(ctx context.Context) (int, error) {
 	var count int
-	query := `SELECT COUNT(*) FROM auth.users`
+	query := `SELECT COUNT(*) FROM auth.users WHERE deleted_at IS NULL`
 	err := r.pool.QueryRow(ctx, query).Scan(&count)
 	return count, err
 }
@@ -272,7 +551,9 @@ func (r *UserRepository) RecordLogin(ctx context.Context, userID uuid.UUID) erro
 		UPDATE auth.users SET
 			last_login_at = $1,
 			failed_login_attempts = 0,
+			failed_login_timestamps = '{}',
 			locked_until = NULL,
+			lockout_count = 0,
 			updated_at = $1
 		WHERE user_id = $2`
 
@@ -280,32 +561,111 @@ func (r *UserRepository) RecordLogin(ctx context.Context, userID uuid.UUID) erro
 	return err
 }
 
-// IncrementFailedLoginAttempts increments the failed login attempts counter
-func (r *UserRepository) IncrementFailedLoginAttempts(ctx context.Context, userID uuid.UUID) error {
+// LockoutPolicy controls when repeated failed logins lock an account and for how long
+type LockoutPolicy struct {
+	MaxAttempts     int           // Failures within Window that lock the account
+	Window          time.Duration // Sliding window for counting failures
+	BaseDuration    time.Duration // Length of the first lockout
+	Multiplier      float64       // Each further lockout lasts Multiplier times longer
+	MaxDuration     time.Duration // Upper bound on a single lockout
+	EscalationReset time.Duration // Lockouts older than this no longer escalate the next one
+}
+
+// NewLockoutPolicy creates a new lockout policy with default values
+func NewLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		MaxAttempts:     5,
+		Window:          15 * time.Minute,
+		BaseDuration:    15 * time.Minute,
+		Multiplier:      2,
+		MaxDuration:     24 * time.Hour,
+		EscalationReset: 7 * 24 * time.Hour,
+	}
+}
+
+// LockoutStatus describes a user's lockout state after a failed login
+type LockoutStatus struct {
+	FailedAttempts int
+	LockedUntil    *time.Time
+	LockoutCount   int
+	JustLocked     bool // The failure that was just recorded locked the account
+}
+
+// IncrementFailedLoginAttempts records a failed login and locks the account if the policy says so.
+// Counting, locking and escalation happen in a single statement, so concurrent failures cannot race.
+func (r *UserRepository) IncrementFailedLoginAttempts(ctx context.Context, userID uuid.UUID, policy LockoutPolicy) (*LockoutStatus, error) {
 	query := `
-		UPDATE auth.users SET
-			failed_login_attempts = failed_login_attempts + 1,
+		WITH current AS (
+			SELECT
+				user_id,
+				ARRAY(
+					SELECT t FROM unnest(failed_login_timestamps) AS t
+					WHERE t > NOW() - make_interval(secs => $2::float8)
+				) || NOW() AS recent,
+				CASE
+					WHEN last_locked_at IS NULL OR last_locked_at < NOW() - make_interval(secs => $7::float8) THEN 0
+					ELSE lockout_count
+				END AS prior_lockouts
+			FROM auth.users
+			WHERE user_id = $1
+			FOR UPDATE
+		), decision AS (
+			SELECT user_id, recent, prior_lockouts, cardinality(recent) >= $3::int AS locks
+			FROM current
+		)
+		UPDATE auth.users u SET
+			failed_login_timestamps = CASE WHEN d.locks THEN '{}' ELSE d.recent END,
+			failed_login_attempts = CASE WHEN d.locks THEN 0 ELSE cardinality(d.recent) END,
+			lockout_count = CASE WHEN d.locks THEN d.prior_lockouts + 1 ELSE u.lockout_count END,
+			locked_until = CASE
+				WHEN d.locks THEN NOW() + make_interval(secs => LEAST($4::float8 * power($5::float8, d.prior_lockouts), $6::float8))
+				ELSE u.locked_until
+			END,
+			last_locked_at = CASE WHEN d.locks THEN NOW() ELSE u.last_locked_at END,
 			updated_at = NOW()
-		WHERE user_id = $1
-		RETURNING failed_login_attempts`
-
-	var attempts int
-	err := r.pool.QueryRow(ctx, query, userID).Scan(&attempts)
+		FROM decision d
+		WHERE u.user_id = d.user_id
+		RETURNING cardinality(d.recent), u.locked_until, u.lockout_count, d.locks`
+
+	var status LockoutStatus
+	err := r.pool.QueryRow(ctx, query,
+		userID, policy.Window.Seconds(), policy.MaxAttempts,
+		policy.BaseDuration.Seconds(), policy.Multiplier, policy.MaxDuration.Seconds(),
+		policy.EscalationReset.Seconds(),
+	).Scan(&status.FailedAttempts, &status.LockedUntil, &status.LockoutCount, &status.JustLocked)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Lock the account after 5 failed attempts
-	if attempts >= 5 {
-		lockTime := time.Now().Add(30 * time.Minute)
-		query := `
-			UPDATE auth.users SET
-				locked_until = $1,
-				updated_at = NOW()
-			WHERE user_id = $2`
-		_, err = r.pool.Exec(ctx, query, lockTime, userID)
-	}
+	return &status, nil
+}
 
+// Unlock clears a user's lockout and failed login history
+func (r *UserRepository) Unlock(ctx context.Context, userID uuid.UUID) error {
+	query := `
+		UPDATE auth.users SET
+			failed_login_attempts = 0,
+			failed_login_timestamps = '{}',
+			locked_until = NULL,
+			lockout_count = 0,
+			updated_at = NOW()
+		WHERE user_id = $1`
+
+	_, err := r.pool.Exec(ctx, query, userID)
+	return err
+}
+
+// Helper function to turn unique violations on a user's email or username into errors callers can check
+func userError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == errUniqueViolation {
+		switch {
+		case strings.Contains(pgErr.ConstraintName, "email"):
+			return ErrEmailAlreadyExists
+		case strings.Contains(pgErr.ConstraintName, "username"):
+			return ErrUsernameAlreadyExists
+		}
+	}
 	return err
 }
 
@@ -325,10 +685,13 @@ func scanUser(row pgx.Row, user *User) error {
 		&user.PasswordResetExpiresAt,
 		&user.FailedLoginAttempts,
 		&user.LockedUntil,
+		&user.LockoutCount,
+		&user.LastLockedAt,
 		&user.LastLoginAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.IsActive,
+		&user.DeletedAt,
 	)
 }
 
@@ -348,9 +711,12 @@ func scanUserFromRows(rows pgx.Rows, user *User) error {
 		&user.PasswordResetExpiresAt,
 		&user.FailedLoginAttempts,
 		&user.LockedUntil,
+		&user.LockoutCount,
+		&user.LastLockedAt,
 		&user.LastLoginAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.IsActive,
+		&user.DeletedAt,
 	)
 }
@@ -0,0 +1,142 @@
+// models/user_test.go
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+)
+
+// Helper function to create an active user on a fresh database
+func newLockoutTestUser(t *testing.T) (*UserRepository, *User) {
+	t.Helper()
+	repo := NewUserRepository(dbtest.New(t))
+	user := &User{Username: "bjensen", Email: "bjensen@example.com", IsActive: true}
+	if err := repo.Create(context.Background(), user, "Password-123"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	return repo, user
+}
+
+func TestIncrementFailedLoginAttemptsLocksAndEscalates(t *testing.T) {
+	repo, user := newLockoutTestUser(t)
+	ctx := context.Background()
+	policy := LockoutPolicy{
+		MaxAttempts:     3,
+		Window:          time.Minute,
+		BaseDuration:    time.Minute,
+		Multiplier:      2,
+		MaxDuration:     3 * time.Minute,
+		EscalationReset: time.Hour,
+	}
+
+	// Each lockout lasts twice as long as the one before, up to MaxDuration
+	for lockout, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		var status *LockoutStatus
+		for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+			var err error
+			if status, err = repo.IncrementFailedLoginAttempts(ctx, user.UserID, policy); err != nil {
+				t.Fatalf("IncrementFailedLoginAttempts: %v", err)
+			}
+			if locked := attempt == policy.MaxAttempts; status.JustLocked != locked {
+				t.Fatalf("lockout %d, attempt %d: JustLocked = %v, want %v", lockout+1, attempt, status.JustLocked, locked)
+			}
+		}
+		if status.LockoutCount != lockout+1 {
+			t.Errorf("LockoutCount = %d, want %d", status.LockoutCount, lockout+1)
+		}
+		if got := time.Until(*status.LockedUntil); got < want-5*time.Second || got > want+5*time.Second {
+			t.Errorf("lockout %d lasts %v, want about %v", lockout+1, got, want)
+		}
+	}
+
+	if err := repo.Unlock(ctx, user.UserID); err != nil {
+		t.Fatalf("Unlock: %v", err)
+	}
+	unlocked, err := repo.GetByID(ctx, user.UserID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if unlocked.LockedUntil != nil || unlocked.FailedLoginAttempts != 0 || unlocked.LockoutCount != 0 {
+		t.Errorf("after Unlock: locked until %v, %d failures, %d lockouts; want none", unlocked.LockedUntil, unlocked.FailedLoginAttempts, unlocked.LockoutCount)
+	}
+}
+
+func TestUpdateKeepsLockout(t *testing.T) {
+	repo, user := newLockoutTestUser(t)
+	ctx := context.Background()
+
+	// A profile edit, password reset request or admin change holding a copy read earlier
+	stale, err := repo.GetByID(ctx, user.UserID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	policy := NewLockoutPolicy()
+	policy.MaxAttempts = 1
+	status, err := repo.IncrementFailedLoginAttempts(ctx, user.UserID, policy)
+	if err != nil {
+		t.Fatalf("IncrementFailedLoginAttempts: %v", err)
+	}
+	if !status.JustLocked {
+		t.Fatalf("status = %+v, want the account locked", status)
+	}
+
+	stale.FirstName = "Barbara"
+	if err := repo.Update(ctx, stale); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	saved, err := repo.GetByID(ctx, user.UserID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if saved.FirstName != "Barbara" {
+		t.Errorf("FirstName = %q, want the update saved", saved.FirstName)
+	}
+	if saved.LockedUntil == nil || !saved.LockedUntil.After(time.Now()) || saved.LockoutCount != 1 {
+		t.Errorf("after Update: locked until %v with %d lockouts, want the lockout kept", saved.LockedUntil, saved.LockoutCount)
+	}
+}
+
+func TestNormalizedIdentifiersCollide(t *testing.T) {
+	repo := NewUserRepository(dbtest.New(t))
+	ctx := context.Background()
+	user := &User{Username: "Émile", Email: "Émile.Zola@Example.fr", IsActive: true}
+	if err := repo.Create(ctx, user, "Password-123"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if user.Username != "émile" || user.Email != "émile.zola@example.fr" {
+		t.Errorf("stored %q, %q; want the normalized forms", user.Username, user.Email)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		wantErr  error
+	}{
+		{"username in capitals", "ÉMILE", "other@example.fr", ErrUsernameAlreadyExists},
+		{"fullwidth username", "ｅ́ｍｉｌｅ", "other@example.fr", ErrUsernameAlreadyExists},
+		{"email in capitals", "zola", "ÉMILE.ZOLA@EXAMPLE.FR", ErrEmailAlreadyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := repo.Create(ctx, &User{Username: tt.username, Email: tt.email, IsActive: true}, "Password-123")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Create: err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Lookups find the user however the identifier is written
+	if found, err := repo.GetByUsername(ctx, "ÉMILE"); err != nil || found == nil || found.UserID != user.UserID {
+		t.Errorf("GetByUsername: %v, %v; want the user", found, err)
+	}
+	if found, err := repo.GetByEmail(ctx, " ÉMILE.ZOLA@EXAMPLE.FR "); err != nil || found == nil || found.UserID != user.UserID {
+		t.Errorf("GetByEmail: %v, %v; want the user", found, err)
+	}
+}
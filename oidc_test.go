@@ -0,0 +1,504 @@
+// oidc_test.go
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+const (
+	oidcTestIssuer      = "https://auth.example.com"
+	oidcTestRedirectURI = "https://app.example.com/callback"
+)
+
+// oidcTestProvider drives the OIDC routes as a relying party and a signed-in browser
+type oidcTestProvider struct {
+	t            *testing.T
+	router       *gin.Engine
+	cookie       *middleware.SessionCookie
+	sessionToken string
+	userID       uuid.UUID
+	clientID     string
+	clientSecret string
+}
+
+// Helper function to serve the OIDC routes with a fresh signing key. A nil pool is enough
+// for the discovery document and key set.
+func newOIDCTestRouter(t *testing.T, authService *services.AuthService) (*gin.Engine, *middleware.SessionCookie) {
+	t.Helper()
+	signingKey, err := services.GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	authService.SetOIDCConfig(services.NewOIDCConfig(oidcTestIssuer, signingKey))
+
+	router := gin.New()
+	cookie := middleware.NewSessionCookie()
+	setupOIDCRoutes(router, authService, middleware.NewMemoryRateLimitStore(), cookie)
+	return router, cookie
+}
+
+// Helper function to set up a provider on a fresh database with a signed-in user and a
+// confidential client that may skip the consent screen
+func newOIDCTestProvider(t *testing.T) *oidcTestProvider {
+	t.Helper()
+	pool := dbtest.New(t)
+	ctx := context.Background()
+
+	authService := services.NewAuthService(pool, "test-secret", 60)
+	router, cookie := newOIDCTestRouter(t, authService)
+
+	user, err := authService.CreateUser(ctx, uuid.Nil, "bjensen", "bjensen@example.com", "correct-Horse-battery-9-staple", "Barbara", "Jensen", nil, "", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	login, err := authService.Login(ctx, services.LoginRequest{
+		UsernameOrEmail: "bjensen",
+		Password:        "correct-Horse-battery-9-staple",
+		IPAddress:       "192.0.2.1",
+		UserAgent:       "oidc-test",
+	})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	client, secret, err := authService.RegisterOAuthClient(ctx, user.UserID, services.RegisterOAuthClientRequest{
+		Name:         "Test app",
+		RedirectURIs: []string{oidcTestRedirectURI},
+		Scopes:       []string{services.OIDCScopeProfile, services.OIDCScopeEmail, services.OIDCScopeOfflineAccess},
+		Confidential: true,
+	}, "", "")
+	if err != nil {
+		t.Fatalf("RegisterOAuthClient: %v", err)
+	}
+
+	return &oidcTestProvider{
+		t:            t,
+		router:       router,
+		cookie:       cookie,
+		sessionToken: login.Session.Token,
+		userID:       user.UserID,
+		clientID:     client.ClientID,
+		clientSecret: secret,
+	}
+}
+
+// Helper function to send a request to /oauth/authorize as the signed-in browser
+func (p *oidcTestProvider) authorize(params url.Values) *httptest.ResponseRecorder {
+	p.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+params.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: p.cookie.Name, Value: p.sessionToken})
+	w := httptest.NewRecorder()
+	p.router.ServeHTTP(w, req)
+	return w
+}
+
+// Helper function to run the authorization request with PKCE and return the code and verifier
+func (p *oidcTestProvider) authorizationCode(scope, nonce string) (string, string) {
+	p.t.Helper()
+	verifier, challenge := pkcePair(p.t)
+	w := p.authorize(url.Values{
+		"client_id":             {p.clientID},
+		"redirect_uri":          {oidcTestRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {scope},
+		"state":                 {"af0ifjsldkj"},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	})
+	query := redirectQuery(p.t, w)
+	if query.Get("code") == "" || query.Get("state") != "af0ifjsldkj" {
+		p.t.Fatalf("redirect = %s, want a code and the state", w.Header().Get("Location"))
+	}
+	return query.Get("code"), verifier
+}
+
+// Helper function to post to /oauth/token as the client, authenticating with HTTP Basic
+func (p *oidcTestProvider) token(form url.Values) *httptest.ResponseRecorder {
+	p.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(p.clientID, p.clientSecret)
+	w := httptest.NewRecorder()
+	p.router.ServeHTTP(w, req)
+	return w
+}
+
+// Helper function to redeem an authorization code
+func (p *oidcTestProvider) exchangeCode(code, verifier, redirectURI string) *httptest.ResponseRecorder {
+	p.t.Helper()
+	return p.token(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {verifier},
+		"redirect_uri":  {redirectURI},
+	})
+}
+
+// Helper function to swap a refresh token for new tokens
+func (p *oidcTestProvider) refresh(refreshToken string) *httptest.ResponseRecorder {
+	p.t.Helper()
+	return p.token(url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+}
+
+// Helper function to call the userinfo endpoint with an access token
+func (p *oidcTestProvider) userInfo(accessToken string) *httptest.ResponseRecorder {
+	p.t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	p.router.ServeHTTP(w, req)
+	return w
+}
+
+// Helper function to check a token response succeeded and decode it
+func (p *oidcTestProvider) expectTokens(w *httptest.ResponseRecorder) services.TokenResponse {
+	p.t.Helper()
+	if w.Code != http.StatusOK {
+		p.t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		p.t.Errorf("Cache-Control = %q, want no-store", w.Header().Get("Cache-Control"))
+	}
+	var response services.TokenResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		p.t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	return response
+}
+
+// Helper function to check a response is an RFC 6749 error with the given code
+func expectOAuthError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+	var body services.OAuthError
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if body.Code != code {
+		t.Errorf("error = %q, want %q", body.Code, code)
+	}
+}
+
+// Helper function to read the parameters of a redirect back to the client
+func redirectQuery(t *testing.T, w *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusFound, w.Body)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing Location: %v", err)
+	}
+	if base := location.Scheme + "://" + location.Host + location.Path; base != oidcTestRedirectURI {
+		t.Fatalf("redirected to %s, want %s", base, oidcTestRedirectURI)
+	}
+	return location.Query()
+}
+
+// Helper function to make a PKCE code verifier and its S256 challenge
+func pkcePair(t *testing.T) (string, string) {
+	t.Helper()
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(random)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Helper function to fetch the provider's key set the way a relying party would
+func fetchJWKS(t *testing.T, router *gin.Engine) services.JSONWebKeySet {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/oauth/jwks", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("jwks: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var keys services.JSONWebKeySet
+	if err := json.Unmarshal(w.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	return keys
+}
+
+func TestOIDCDiscoveryDocument(t *testing.T) {
+	router, _ := newOIDCTestRouter(t, services.NewAuthService(nil, "test-secret", 60))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/openid-configuration", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var document struct {
+		Issuer                 string   `json:"issuer"`
+		AuthorizationEndpoint  string   `json:"authorization_endpoint"`
+		TokenEndpoint          string   `json:"token_endpoint"`
+		JWKSURI                string   `json:"jwks_uri"`
+		ResponseTypes          []string `json:"response_types_supported"`
+		SigningAlgorithms      []string `json:"id_token_signing_alg_values_supported"`
+		CodeChallengeMethods   []string `json:"code_challenge_methods_supported"`
+		ScopesSupported        []string `json:"scopes_supported"`
+		GrantTypesSupported    []string `json:"grant_types_supported"`
+		SubjectTypesSupported  []string `json:"subject_types_supported"`
+		TokenEndpointAuthModes []string `json:"token_endpoint_auth_methods_supported"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+
+	if document.Issuer != oidcTestIssuer {
+		t.Errorf("issuer = %q, want %q", document.Issuer, oidcTestIssuer)
+	}
+	if document.AuthorizationEndpoint != oidcTestIssuer+"/oauth/authorize" || document.TokenEndpoint != oidcTestIssuer+"/oauth/token" || document.JWKSURI != oidcTestIssuer+"/oauth/jwks" {
+		t.Errorf("endpoints = %s, %s, %s, want them under %s", document.AuthorizationEndpoint, document.TokenEndpoint, document.JWKSURI, oidcTestIssuer)
+	}
+	if strings.Join(document.CodeChallengeMethods, " ") != "S256" {
+		t.Errorf("code_challenge_methods_supported = %v, want only S256", document.CodeChallengeMethods)
+	}
+	if strings.Join(document.ResponseTypes, " ") != "code" || strings.Join(document.SigningAlgorithms, " ") != "RS256" {
+		t.Errorf("response types %v and signing algorithms %v, want code and RS256", document.ResponseTypes, document.SigningAlgorithms)
+	}
+	if len(document.ScopesSupported) == 0 || document.ScopesSupported[0] != services.OIDCScopeOpenID {
+		t.Errorf("scopes_supported = %v, want openid first", document.ScopesSupported)
+	}
+	if len(document.GrantTypesSupported) == 0 || len(document.SubjectTypesSupported) == 0 || len(document.TokenEndpointAuthModes) == 0 {
+		t.Errorf("document = %+v, want grant, subject and client auth types", document)
+	}
+
+	keys := fetchJWKS(t, router)
+	if len(keys.Keys) != 1 || keys.Keys[0].Kty != "RSA" || keys.Keys[0].Alg != "RS256" || keys.Keys[0].Kid == "" || keys.Keys[0].N == "" {
+		t.Errorf("keys = %+v, want one RS256 key with a kid", keys.Keys)
+	}
+}
+
+func TestOIDCDisabledIsNotFound(t *testing.T) {
+	router := gin.New()
+	setupOIDCRoutes(router, services.NewAuthService(nil, "test-secret", 60), middleware.NewMemoryRateLimitStore(), middleware.NewSessionCookie())
+	for _, path := range []string{"/.well-known/openid-configuration", "/oauth/jwks"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	p := newOIDCTestProvider(t)
+
+	code, verifier := p.authorizationCode("openid profile email", "n-0S6_WzA2Mj")
+	tokens := p.expectTokens(p.exchangeCode(code, verifier, oidcTestRedirectURI))
+	if tokens.TokenType != "Bearer" || tokens.AccessToken == "" || tokens.IDToken == "" {
+		t.Fatalf("tokens = %+v, want a bearer access token and an ID token", tokens)
+	}
+	if tokens.RefreshToken != "" {
+		t.Error("refresh token issued without offline_access")
+	}
+
+	// The ID token verifies against the published keys and is bound to the client and nonce
+	claims, err := services.VerifyJWT(tokens.IDToken, fetchJWKS(t, p.router))
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	want := map[string]interface{}{
+		"iss":                oidcTestIssuer,
+		"aud":                p.clientID,
+		"sub":                p.userID.String(),
+		"nonce":              "n-0S6_WzA2Mj",
+		"preferred_username": "bjensen",
+		"email":              "bjensen@example.com",
+	}
+	for claim, value := range want {
+		if claims[claim] != value {
+			t.Errorf("%s = %#v, want %#v", claim, claims[claim], value)
+		}
+	}
+
+	// Another key set must not verify it
+	otherRouter, _ := newOIDCTestRouter(t, services.NewAuthService(nil, "test-secret", 60))
+	if _, err := services.VerifyJWT(tokens.IDToken, fetchJWKS(t, otherRouter)); err == nil {
+		t.Error("ID token verified against another provider's keys")
+	}
+
+	w := p.userInfo(tokens.AccessToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("userinfo: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var info map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("decoding %s: %v", w.Body, err)
+	}
+	if info["sub"] != p.userID.String() || info["email"] != "bjensen@example.com" {
+		t.Errorf("userinfo = %v, want the user's sub and email", info)
+	}
+}
+
+func TestOIDCAuthorizeRejectsUnregisteredRedirectURI(t *testing.T) {
+	p := newOIDCTestProvider(t)
+	_, challenge := pkcePair(t)
+
+	for _, redirectURI := range []string{"https://evil.example.com/callback", oidcTestRedirectURI + "/extra", ""} {
+		w := p.authorize(url.Values{
+			"client_id":             {p.clientID},
+			"redirect_uri":          {redirectURI},
+			"response_type":         {"code"},
+			"scope":                 {"openid"},
+			"code_challenge":        {challenge},
+			"code_challenge_method": {"S256"},
+		})
+		// The error is shown here, never sent to a redirect URI that isn't trusted
+		if w.Code != http.StatusBadRequest || w.Header().Get("Location") != "" {
+			t.Errorf("%q: status = %d, Location = %q, want %d and no redirect", redirectURI, w.Code, w.Header().Get("Location"), http.StatusBadRequest)
+		}
+	}
+
+	w := p.authorize(url.Values{"client_id": {"client_unknown"}, "redirect_uri": {oidcTestRedirectURI}})
+	if w.Code != http.StatusNotFound || w.Header().Get("Location") != "" {
+		t.Errorf("unknown client: status = %d, Location = %q, want %d and no redirect", w.Code, w.Header().Get("Location"), http.StatusNotFound)
+	}
+}
+
+func TestOIDCAuthorizeRejectsPlainOrMissingPKCE(t *testing.T) {
+	p := newOIDCTestProvider(t)
+	verifier, _ := pkcePair(t)
+
+	tests := []struct {
+		name      string
+		challenge url.Values
+	}{
+		{"missing", url.Values{}},
+		{"plain", url.Values{"code_challenge": {verifier}, "code_challenge_method": {"plain"}}},
+		{"no method", url.Values{"code_challenge": {verifier}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := url.Values{
+				"client_id":     {p.clientID},
+				"redirect_uri":  {oidcTestRedirectURI},
+				"response_type": {"code"},
+				"scope":         {"openid"},
+				"state":         {"xyz"},
+			}
+			for name, values := range tt.challenge {
+				params[name] = values
+			}
+			query := redirectQuery(t, p.authorize(params))
+			if query.Get("error") != services.OAuthErrInvalidRequest || query.Get("code") != "" || query.Get("state") != "xyz" {
+				t.Errorf("redirect query = %v, want an invalid_request error with the state", query)
+			}
+		})
+	}
+}
+
+func TestOIDCTokenChecksRedirectURIAndVerifier(t *testing.T) {
+	p := newOIDCTestProvider(t)
+
+	code, verifier := p.authorizationCode("openid", "")
+	expectOAuthError(t, p.exchangeCode(code, verifier, "https://app.example.com/other"), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+
+	code, _ = p.authorizationCode("openid", "")
+	otherVerifier, _ := pkcePair(t)
+	expectOAuthError(t, p.exchangeCode(code, otherVerifier, oidcTestRedirectURI), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+
+	code, _ = p.authorizationCode("openid", "")
+	expectOAuthError(t, p.exchangeCode(code, "", oidcTestRedirectURI), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+
+	// The client has to authenticate
+	code, verifier = p.authorizationCode("openid", "")
+	p.clientSecret = "secret_wrong"
+	expectOAuthError(t, p.exchangeCode(code, verifier, oidcTestRedirectURI), http.StatusUnauthorized, services.OAuthErrInvalidClient)
+}
+
+func TestOIDCCodeReplayRevokesIssuedTokens(t *testing.T) {
+	p := newOIDCTestProvider(t)
+
+	code, verifier := p.authorizationCode("openid offline_access", "")
+	tokens := p.expectTokens(p.exchangeCode(code, verifier, oidcTestRedirectURI))
+	if tokens.RefreshToken == "" {
+		t.Fatal("no refresh token issued with offline_access")
+	}
+	if w := p.userInfo(tokens.AccessToken); w.Code != http.StatusOK {
+		t.Fatalf("userinfo before replay: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The code works once; using it again withdraws what it produced
+	expectOAuthError(t, p.exchangeCode(code, verifier, oidcTestRedirectURI), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+
+	if w := p.userInfo(tokens.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("userinfo after replay: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	expectOAuthError(t, p.refresh(tokens.RefreshToken), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+}
+
+func TestOIDCRefreshTokenRotation(t *testing.T) {
+	p := newOIDCTestProvider(t)
+
+	code, verifier := p.authorizationCode("openid email offline_access", "")
+	first := p.expectTokens(p.exchangeCode(code, verifier, oidcTestRedirectURI))
+
+	second := p.expectTokens(p.refresh(first.RefreshToken))
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Fatalf("refresh returned %+v, want a new access and refresh token", second)
+	}
+	if second.Scope != "openid email offline_access" {
+		t.Errorf("scope = %q, want the scopes first granted", second.Scope)
+	}
+	if w := p.userInfo(second.AccessToken); w.Code != http.StatusOK {
+		t.Errorf("userinfo with the new access token: status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// The old refresh token is spent, and replaying it revokes the tokens that replaced it
+	expectOAuthError(t, p.refresh(first.RefreshToken), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+	expectOAuthError(t, p.refresh(second.RefreshToken), http.StatusBadRequest, services.OAuthErrInvalidGrant)
+	if w := p.userInfo(second.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("userinfo after replay: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// Requests racing with the same refresh token: only one may swap it for new tokens
+	code, verifier = p.authorizationCode("openid offline_access", "")
+	refreshToken := p.expectTokens(p.exchangeCode(code, verifier, oidcTestRedirectURI)).RefreshToken
+	const requests = 8
+	responses := make([]*httptest.ResponseRecorder, requests)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = p.refresh(refreshToken)
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, w := range responses {
+		if w.Code == http.StatusOK {
+			succeeded++
+			continue
+		}
+		expectOAuthError(t, w, http.StatusBadRequest, services.OAuthErrInvalidGrant)
+	}
+	if succeeded != 1 {
+		t.Errorf("%d of %d concurrent refreshes succeeded, want exactly 1", succeeded, requests)
+	}
+}
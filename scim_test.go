@@ -0,0 +1,412 @@
+// scim_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/middleware"
+	"github.com/loganmanery/go-react-app/models"
+	"github.com/loganmanery/go-react-app/services"
+)
+
+// scimTestClient sends requests to the SCIM routes as a provisioning client
+type scimTestClient struct {
+	t           *testing.T
+	router      *gin.Engine
+	token       string
+	authService *services.AuthService
+}
+
+// Helper function to serve the SCIM API from a fresh database, with an admin's access token
+// that has the scim scope
+func newSCIMTestClient(t *testing.T, groupRoles map[string]string) *scimTestClient {
+	t.Helper()
+	pool := dbtest.New(t)
+	ctx := context.Background()
+
+	authService := services.NewAuthService(pool, "test-secret", 60)
+	scimConfig := services.NewSCIMConfig("https://auth.example.com")
+	for group, role := range groupRoles {
+		scimConfig.GroupRoles[group] = role
+	}
+	authService.SetSCIMConfig(scimConfig)
+
+	admin, err := authService.CreateUser(ctx, uuid.Nil, "scim-admin", "scim-admin@example.com", "correct-Horse-battery-9-staple", "", "", []string{models.RoleAdmin}, "", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	_, token, err := authService.CreateAccessToken(ctx, admin.UserID, services.CreateAccessTokenRequest{Name: "scim", Scopes: []string{services.ScopeSCIM}}, "", "")
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	router := gin.New()
+	setupSCIMRoutes(router, authService, middleware.NewMemoryRateLimitStore())
+	return &scimTestClient{t: t, router: router, token: token, authService: authService}
+}
+
+// Helper function to send a request; body is encoded as JSON unless it is nil. headers
+// alternate names and values.
+func (c *scimTestClient) do(method, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	var reader *strings.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatalf("Marshal: %v", err)
+		}
+		reader = strings.NewReader(string(encoded))
+	} else {
+		reader = strings.NewReader("")
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", services.SCIMContentType)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+	return w
+}
+
+// Helper function to check a response's status and decode its body
+func (c *scimTestClient) expect(w *httptest.ResponseRecorder, status int, target interface{}) {
+	c.t.Helper()
+	if w.Code != status {
+		c.t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body)
+	}
+	if target != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), target); err != nil {
+			c.t.Fatalf("decoding %s: %v", w.Body, err)
+		}
+	}
+}
+
+// Helper function to check that a response is a SCIM error of a type
+func (c *scimTestClient) expectError(w *httptest.ResponseRecorder, status int, scimType string) {
+	c.t.Helper()
+	var body struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType"`
+	}
+	c.expect(w, status, &body)
+	if len(body.Schemas) != 1 || body.Schemas[0] != services.SCIMErrorSchema || body.Status != fmt.Sprint(status) || body.ScimType != scimType {
+		c.t.Errorf("error = %+v, want status %d and scimType %q", body, status, scimType)
+	}
+}
+
+// Helper function to provision a user and return it
+func (c *scimTestClient) createUser(userName, email, externalID string) *services.SCIMUser {
+	c.t.Helper()
+	var user services.SCIMUser
+	c.expect(c.do(http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"schemas":    []string{services.SCIMUserSchema},
+		"userName":   userName,
+		"externalId": externalID,
+		"emails":     []map[string]interface{}{{"value": email, "type": "work", "primary": true}},
+	}), http.StatusCreated, &user)
+	return &user
+}
+
+func TestSCIMUserLifecycle(t *testing.T) {
+	c := newSCIMTestClient(t, nil)
+
+	var created services.SCIMUser
+	w := c.do(http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"schemas":    []string{services.SCIMUserSchema},
+		"userName":   "bjensen",
+		"externalId": "bj-1",
+		"name":       map[string]string{"givenName": "Barbara", "familyName": "Jensen"},
+		"emails":     []map[string]interface{}{{"value": "bjensen@example.com", "type": "work", "primary": true}},
+	})
+	c.expect(w, http.StatusCreated, &created)
+	if created.ID == "" || created.UserName != "bjensen" || created.ExternalID != "bj-1" || created.Active == nil || !*created.Active {
+		t.Fatalf("created = %+v", created)
+	}
+	if created.Name == nil || created.Name.GivenName != "Barbara" || created.Name.FamilyName != "Jensen" {
+		t.Errorf("name = %+v", created.Name)
+	}
+	if location := w.Header().Get("Location"); location != "https://auth.example.com/scim/v2/Users/"+created.ID || location != created.Meta.Location {
+		t.Errorf("Location = %q, meta.location = %q", location, created.Meta.Location)
+	}
+	if etag := w.Header().Get("ETag"); etag == "" || etag != created.Meta.Version {
+		t.Errorf("ETag = %q, meta.version = %q", etag, created.Meta.Version)
+	}
+	path := "/scim/v2/Users/" + created.ID
+
+	// Reads return the same version, and a conditional read of it is not modified
+	var fetched services.SCIMUser
+	w = c.do(http.MethodGet, path, nil)
+	c.expect(w, http.StatusOK, &fetched)
+	if fetched.Meta.Version != created.Meta.Version || w.Header().Get("ETag") != created.Meta.Version {
+		t.Errorf("version = %q, ETag = %q, want %q", fetched.Meta.Version, w.Header().Get("ETag"), created.Meta.Version)
+	}
+	c.expect(c.do(http.MethodGet, path, nil, "If-None-Match", created.Meta.Version), http.StatusNotModified, nil)
+
+	// Replacing with a stale version is refused; with the current one it goes through
+	replacement := map[string]interface{}{
+		"schemas":  []string{services.SCIMUserSchema},
+		"userName": "bjensen",
+		"name":     map[string]string{"givenName": "Babs", "familyName": "Jensen"},
+		"emails":   []map[string]interface{}{{"value": "bjensen@example.com"}},
+	}
+	c.expectError(c.do(http.MethodPut, path, replacement, "If-Match", `W/"1"`), http.StatusPreconditionFailed, "")
+	var replaced services.SCIMUser
+	c.expect(c.do(http.MethodPut, path, replacement, "If-Match", created.Meta.Version), http.StatusOK, &replaced)
+	if replaced.Name == nil || replaced.Name.GivenName != "Babs" || replaced.ExternalID != "" {
+		t.Errorf("replaced = %+v", replaced)
+	}
+	if replaced.Meta.Version == created.Meta.Version {
+		t.Error("version did not change after PUT")
+	}
+
+	// Deactivating, with the boolean as a string as some clients send it
+	var patched services.SCIMUser
+	c.expect(c.do(http.MethodPatch, path, map[string]interface{}{
+		"schemas":    []string{services.SCIMPatchOpSchema},
+		"Operations": []map[string]interface{}{{"op": "Replace", "path": "active", "value": "False"}},
+	}), http.StatusOK, &patched)
+	if patched.Active == nil || *patched.Active {
+		t.Errorf("active = %v after PATCH, want false", patched.Active)
+	}
+
+	c.expectError(c.do(http.MethodDelete, path, nil, "If-Match", created.Meta.Version), http.StatusPreconditionFailed, "")
+	c.expect(c.do(http.MethodDelete, path, nil), http.StatusNoContent, nil)
+	c.expectError(c.do(http.MethodGet, path, nil), http.StatusNotFound, "")
+}
+
+func TestSCIMUserUniqueness(t *testing.T) {
+	c := newSCIMTestClient(t, nil)
+	c.createUser("bjensen", "bjensen@example.com", "")
+
+	// Usernames and emails are unique regardless of case
+	c.expectError(c.do(http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"schemas":  []string{services.SCIMUserSchema},
+		"userName": "BJensen",
+		"emails":   []map[string]interface{}{{"value": "other@example.com"}},
+	}), http.StatusConflict, services.SCIMErrUniqueness)
+	c.expectError(c.do(http.MethodPost, "/scim/v2/Users", map[string]interface{}{
+		"schemas":  []string{services.SCIMUserSchema},
+		"userName": "barbara",
+		"emails":   []map[string]interface{}{{"value": "BJENSEN@example.com"}},
+	}), http.StatusConflict, services.SCIMErrUniqueness)
+
+	// Renaming another user onto a taken username is refused too
+	other := c.createUser("other", "other@example.com", "")
+	c.expectError(c.do(http.MethodPatch, "/scim/v2/Users/"+other.ID, map[string]interface{}{
+		"schemas":    []string{services.SCIMPatchOpSchema},
+		"Operations": []map[string]interface{}{{"op": "replace", "path": "userName", "value": "bjensen"}},
+	}), http.StatusConflict, services.SCIMErrUniqueness)
+}
+
+func TestSCIMListUsersFiltersAndPages(t *testing.T) {
+	c := newSCIMTestClient(t, nil)
+	c.createUser("user1", "user1@example.com", "ext-1")
+	c.createUser("user2", "user2@example.org", "")
+	c.createUser("user3", "user3@example.com", "ext-3")
+	c.createUser("user4", "user4@example.org", "")
+	c.createUser("user5", "user5@example.com", "")
+
+	list := func(query string) services.SCIMListResponse {
+		t.Helper()
+		var response struct {
+			services.SCIMListResponse
+			Resources []services.SCIMUser `json:"Resources"`
+		}
+		c.expect(c.do(http.MethodGet, "/scim/v2/Users?"+query, nil), http.StatusOK, &response)
+		page := response.SCIMListResponse
+		page.Resources = nil
+		for _, user := range response.Resources {
+			page.Resources = append(page.Resources, user.UserName)
+		}
+		return page
+	}
+	filter := func(expression string) []interface{} {
+		t.Helper()
+		return list("filter=" + url.QueryEscape(expression)).Resources
+	}
+
+	filters := []struct {
+		expression string
+		want       string
+	}{
+		{`userName eq "user3"`, "user3"},
+		{`userName eq "USER3"`, "user3"},
+		{`emails co "example.org"`, "user2 user4"},
+		{`userName sw "user" and emails.value ew ".com"`, "user1 user3 user5"},
+		{`externalId pr`, "user1 user3"},
+		{`userName eq "user1" or userName eq "user4"`, "user1 user4"},
+		{`userName sw "user" and not (emails co "example.com")`, "user2 user4"},
+		{`userName eq "nobody"`, ""},
+	}
+	for _, tt := range filters {
+		if got := fmt.Sprint(filter(tt.expression)); got != "["+tt.want+"]" {
+			t.Errorf("%s: got %s, want [%s]", tt.expression, got, tt.want)
+		}
+	}
+
+	// Pages are 1-based and counted over all matches, ordered by creation
+	page := list("filter=" + url.QueryEscape(`userName sw "user"`) + "&startIndex=2&count=2")
+	if page.TotalResults != 5 || page.StartIndex != 2 || page.ItemsPerPage != 2 || fmt.Sprint(page.Resources) != "[user2 user3]" {
+		t.Errorf("startIndex=2&count=2: %+v", page)
+	}
+	page = list("filter=" + url.QueryEscape(`userName sw "user"`) + "&startIndex=5&count=10")
+	if page.TotalResults != 5 || page.ItemsPerPage != 1 || fmt.Sprint(page.Resources) != "[user5]" {
+		t.Errorf("last page: %+v", page)
+	}
+	page = list("filter=" + url.QueryEscape(`userName sw "user"`) + "&count=0")
+	if page.TotalResults != 5 || page.ItemsPerPage != 0 || len(page.Resources) != 0 {
+		t.Errorf("count=0: %+v", page)
+	}
+	page = list("startIndex=50")
+	if page.TotalResults != 6 || page.ItemsPerPage != 0 || len(page.Resources) != 0 { // The five and the admin
+		t.Errorf("past the end: %+v", page)
+	}
+
+	c.expectError(c.do(http.MethodGet, "/scim/v2/Users?filter="+url.QueryEscape(`userName eq`), nil), http.StatusBadRequest, services.SCIMErrInvalidFilter)
+}
+
+func TestSCIMGroupLifecycleAndRoleMapping(t *testing.T) {
+	c := newSCIMTestClient(t, map[string]string{"Auditors": "auditor"})
+	ctx := context.Background()
+	alice := c.createUser("alice", "alice@example.com", "")
+	bob := c.createUser("bob", "bob@example.com", "")
+	aliceID, bobID := uuid.MustParse(alice.ID), uuid.MustParse(bob.ID)
+
+	// Roles granted outside the mapping are left alone
+	if err := c.authService.GrantRole(ctx, uuid.Nil, bobID, "support", "", ""); err != nil {
+		t.Fatalf("GrantRole: %v", err)
+	}
+	expectRoles := func(userID uuid.UUID, want string) {
+		t.Helper()
+		roles, err := c.authService.GetUserRoles(ctx, userID)
+		if err != nil {
+			t.Fatalf("GetUserRoles: %v", err)
+		}
+		sort.Strings(roles)
+		if got := strings.Join(roles, " "); got != want {
+			t.Errorf("roles of %s = %q, want %q", userID, got, want)
+		}
+	}
+
+	var group services.SCIMGroup
+	w := c.do(http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{services.SCIMGroupSchema},
+		"displayName": "Auditors",
+		"members":     []map[string]string{{"value": alice.ID}},
+	})
+	c.expect(w, http.StatusCreated, &group)
+	if w.Header().Get("ETag") != group.Meta.Version || len(group.Members) != 1 || group.Members[0].Value != alice.ID {
+		t.Fatalf("group = %+v, ETag %q", group, w.Header().Get("ETag"))
+	}
+	path := "/scim/v2/Groups/" + group.ID
+	expectRoles(aliceID, "auditor")
+	expectRoles(bobID, "support")
+
+	// Group names are unique regardless of case
+	c.expectError(c.do(http.MethodPost, "/scim/v2/Groups", map[string]interface{}{
+		"schemas":     []string{services.SCIMGroupSchema},
+		"displayName": "auditors",
+	}), http.StatusConflict, services.SCIMErrUniqueness)
+
+	var list struct {
+		TotalResults int                  `json:"totalResults"`
+		Resources    []services.SCIMGroup `json:"Resources"`
+	}
+	c.expect(c.do(http.MethodGet, "/scim/v2/Groups?filter="+url.QueryEscape(`displayName eq "auditors"`), nil), http.StatusOK, &list)
+	if list.TotalResults != 1 || list.Resources[0].ID != group.ID {
+		t.Errorf("filtered groups = %+v", list)
+	}
+	c.expect(c.do(http.MethodGet, "/scim/v2/Groups?filter="+url.QueryEscape(`members[value eq "`+bob.ID+`"]`), nil), http.StatusOK, &list)
+	if list.TotalResults != 0 {
+		t.Errorf("groups with bob = %+v, want none", list)
+	}
+
+	// Adding bob grants him the role; removing alice takes hers away
+	c.expect(c.do(http.MethodPatch, path, map[string]interface{}{
+		"schemas": []string{services.SCIMPatchOpSchema},
+		"Operations": []map[string]interface{}{
+			{"op": "add", "path": "members", "value": []map[string]string{{"value": bob.ID}}},
+			{"op": "remove", "path": `members[value eq "` + alice.ID + `"]`},
+		},
+	}, "If-Match", group.Meta.Version), http.StatusOK, &group)
+	if len(group.Members) != 1 || group.Members[0].Value != bob.ID {
+		t.Errorf("members = %+v, want only bob", group.Members)
+	}
+	expectRoles(aliceID, "")
+	expectRoles(bobID, "auditor support")
+
+	var user services.SCIMUser
+	c.expect(c.do(http.MethodGet, "/scim/v2/Users/"+bob.ID, nil), http.StatusOK, &user)
+	if len(user.Groups) != 1 || user.Groups[0].Value != group.ID || user.Groups[0].Display != "Auditors" {
+		t.Errorf("bob's groups = %+v", user.Groups)
+	}
+
+	// Renaming the group to one that isn't mapped takes the role from everyone in it
+	c.expectError(c.do(http.MethodPut, path, map[string]interface{}{
+		"schemas":     []string{services.SCIMGroupSchema},
+		"displayName": "Readers",
+	}, "If-Match", `W/"1"`), http.StatusPreconditionFailed, "")
+	c.expect(c.do(http.MethodPut, path, map[string]interface{}{
+		"schemas":     []string{services.SCIMGroupSchema},
+		"displayName": "Readers",
+		"members":     []map[string]string{{"value": bob.ID}},
+	}), http.StatusOK, &group)
+	expectRoles(bobID, "support")
+
+	// Renaming it back, then deleting it
+	c.expect(c.do(http.MethodPatch, path, map[string]interface{}{
+		"schemas":    []string{services.SCIMPatchOpSchema},
+		"Operations": []map[string]interface{}{{"op": "replace", "value": map[string]string{"displayName": "Auditors"}}},
+	}), http.StatusOK, &group)
+	expectRoles(bobID, "auditor support")
+	c.expect(c.do(http.MethodDelete, path, nil), http.StatusNoContent, nil)
+	expectRoles(bobID, "support")
+	c.expectError(c.do(http.MethodGet, path, nil), http.StatusNotFound, "")
+}
+
+func TestSCIMRequiresAdminTokenWithScope(t *testing.T) {
+	c := newSCIMTestClient(t, nil)
+	ctx := context.Background()
+
+	user, err := c.authService.CreateUser(ctx, uuid.Nil, "plain", "plain@example.com", "correct-Horse-battery-9-staple", "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	_, notAdmin, err := c.authService.CreateAccessToken(ctx, user.UserID, services.CreateAccessTokenRequest{Name: "scim", Scopes: []string{services.ScopeSCIM}}, "", "")
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"not an access token", "not-a-token", http.StatusUnauthorized},
+		{"not an admin", notAdmin, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := *c
+			client.t, client.token = t, tt.token
+			client.expectError(client.do(http.MethodGet, "/scim/v2/Users", nil), tt.status, "")
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// services/access_tokens.go
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// AccessTokenPrefix starts every personal access token, so they are easy to recognise
+// (and for secret scanners to find) and can be told apart from session tokens
+const AccessTokenPrefix = "pat_"
+
+// Scopes a personal access token can be granted. Each one unlocks a group of routes;
+// sessions from an interactive login have every scope.
+const (
+	ScopeProfileRead  = "profile:read"  // Read the user's profile
+	ScopeDevicesRead  = "devices:read"  // List the user's devices
+	ScopeDevicesWrite = "devices:write" // Trust or report devices
+	ScopeAdmin        = "admin"         // Admin endpoints; the user must also be an admin
+	ScopeSCIM         = "scim"          // SCIM provisioning API; the user must also be an admin
+)
+
+// AccessTokenScopes lists every valid scope
+var AccessTokenScopes = []string{ScopeProfileRead, ScopeDevicesRead, ScopeDevicesWrite, ScopeAdmin, ScopeSCIM}
+
+var (
+	ErrInvalidScope       = errors.New("unknown access token scope")
+	ErrInvalidAllowedIP   = errors.New("invalid IP address or range in allow-list")
+	ErrInvalidTokenExpiry = errors.New("access token expiry must be in the future")
+	ErrIPNotAllowed       = errors.New("access token cannot be used from this IP address")
+	ErrAccessTokenUnknown = errors.New("access token not found")
+)
+
+// How many characters of a token are kept in clear so users can tell their tokens apart
+const accessTokenDisplayLength = len(AccessTokenPrefix) + 8
+
+// How often a token's last-used time is written
+const accessTokenUseInterval = time.Minute
+
+// CreateAccessTokenRequest holds the settings for a new personal access token
+type CreateAccessTokenRequest struct {
+	Name       string
+	Scopes     []string
+	AllowedIPs []string   // Addresses or CIDR ranges the token can be used from; empty allows any
+	ExpiresAt  *time.Time // Nil for a token that does not expire
+}
+
+// CreateAccessToken issues a personal access token. The token value is returned only
+// here; the database keeps just its hash.
+func (s *AuthService) CreateAccessToken(ctx context.Context, userID uuid.UUID, req CreateAccessTokenRequest, ipAddress, userAgent string) (*models.AccessToken, string, error) {
+	for _, scope := range req.Scopes {
+		if !isAccessTokenScope(scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	if _, err := ParseIPList(req.AllowedIPs); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidAllowedIP, err)
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrInvalidTokenExpiry
+	}
+
+	value, err := generatePrefixedToken(AccessTokenPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &models.AccessToken{
+		UserID:      userID,
+		Name:        req.Name,
+		TokenPrefix: value[:accessTokenDisplayLength],
+		TokenHash:   hashToken(value),
+		Scopes:      req.Scopes,
+		AllowedIPs:  req.AllowedIPs,
+		ExpiresAt:   req.ExpiresAt,
+	}
+	if err := s.accessTokenRepo.Create(ctx, token); err != nil {
+		return nil, "", err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "access_token_created",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"token_id":    token.TokenID.String(),
+			"name":        token.Name,
+			"scopes":      token.Scopes,
+			"allowed_ips": token.AllowedIPs,
+			"expires_at":  token.ExpiresAt,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return token, value, nil
+}
+
+// ListAccessTokens retrieves a user's active personal access tokens
+func (s *AuthService) ListAccessTokens(ctx context.Context, userID uuid.UUID) ([]*models.AccessToken, error) {
+	return s.accessTokenRepo.GetAllByUserID(ctx, userID)
+}
+
+// RevokeAccessToken revokes one of a user's personal access tokens
+func (s *AuthService) RevokeAccessToken(ctx context.Context, userID, tokenID uuid.UUID, ipAddress, userAgent string) error {
+	revoked, err := s.accessTokenRepo.Revoke(ctx, userID, tokenID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAccessTokenUnknown
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "access_token_revoked",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   map[string]interface{}{"token_id": tokenID.String()},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// ValidateAccessToken checks a personal access token presented from an IP address
+func (s *AuthService) ValidateAccessToken(ctx context.Context, value, ipAddress string) (*models.AccessToken, *models.User, error) {
+	token, err := s.accessTokenRepo.GetByTokenHash(ctx, hashToken(value))
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil || token.RevokedAt != nil || (token.ExpiresAt != nil && time.Now().After(*token.ExpiresAt)) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	if len(token.AllowedIPs) > 0 {
+		allowed, err := ParseIPList(token.AllowedIPs)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !allowed.Contains(ipAddress) {
+			return nil, nil, ErrIPNotAllowed
+		}
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || !user.IsActive {
+		return nil, nil, ErrUserNotFound
+	}
+	// A lockout stops scripts too, or a leaked password's owner could keep using their tokens
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, nil, ErrUserLocked
+	}
+
+	if err := s.accessTokenRepo.RecordUse(ctx, token.TokenID, ipAddress, accessTokenUseInterval); err != nil {
+		// Just log this error, don't fail the validation
+		slog.ErrorContext(ctx, "Error recording access token use", "error", err)
+	}
+
+	return token, user, nil
+}
+
+// IsAccessToken reports whether a bearer token is a personal access token rather than a session token
+func IsAccessToken(value string) bool {
+	return strings.HasPrefix(value, AccessTokenPrefix)
+}
+
+// Helper function to check a scope name
+func isAccessTokenScope(scope string) bool {
+	for _, known := range AccessTokenScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to generate a random token that starts with a recognisable prefix
+func generatePrefixedToken(prefix string) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	// Unpadded so the token is safe to paste into headers, URLs and environment variables as-is
+	return prefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
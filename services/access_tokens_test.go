@@ -0,0 +1,57 @@
+// services/access_tokens_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+)
+
+func TestValidateAccessTokenRejectsLockedUser(t *testing.T) {
+	pool := dbtest.New(t)
+	ctx := context.Background()
+	s := NewAuthService(pool, "test-secret", 60)
+
+	user, err := s.CreateUser(ctx, uuid.Nil, "bjensen", "bjensen@example.com", "correct-Horse-battery-9-staple", "", "", nil, "", "")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	_, token, err := s.CreateAccessToken(ctx, user.UserID, CreateAccessTokenRequest{Name: "script", Scopes: []string{ScopeProfileRead}}, "", "")
+	if err != nil {
+		t.Fatalf("CreateAccessToken: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		lockedUntil *time.Time
+		wantErr     error
+	}{
+		{"not locked", nil, nil},
+		{"locked", timePtr(time.Now().Add(time.Hour)), ErrUserLocked},
+		{"lockout expired", timePtr(time.Now().Add(-time.Minute)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := pool.Exec(ctx, "UPDATE auth.users SET locked_until = $1 WHERE user_id = $2", tt.lockedUntil, user.UserID); err != nil {
+				t.Fatalf("setting locked_until: %v", err)
+			}
+			_, validated, err := s.ValidateAccessToken(ctx, token, "192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ValidateAccessToken: err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && validated.UserID != user.UserID {
+				t.Errorf("user = %v, want %v", validated.UserID, user.UserID)
+			}
+		})
+	}
+}
+
+// Helper function to get a pointer to a time
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
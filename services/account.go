@@ -0,0 +1,331 @@
+// services/account.go
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrAccountDeletionPending    = errors.New("account deletion has already been requested")
+	ErrAccountDeletionNotPending = errors.New("no account deletion has been requested")
+)
+
+// accountExportAuditPage is how many audit log entries an export reads at a time
+const accountExportAuditPage = 500
+
+// AccountDeletionPolicy controls self-service account deletion
+type AccountDeletionPolicy struct {
+	GracePeriod time.Duration // How long after the request the account is deleted; it can be cancelled until then
+}
+
+// NewAccountDeletionPolicy creates an account deletion policy with default values
+func NewAccountDeletionPolicy() AccountDeletionPolicy {
+	return AccountDeletionPolicy{
+		GracePeriod: 30 * 24 * time.Hour,
+	}
+}
+
+// SetAccountDeletionPolicy replaces the default account deletion policy
+func (s *AuthService) SetAccountDeletionPolicy(policy AccountDeletionPolicy) {
+	s.accountDeletionPolicy = policy
+}
+
+// AccountExport is everything held about a user, as downloaded by them
+type AccountExport struct {
+	ExportedAt         time.Time                  `json:"exported_at"`
+	User               *models.User               `json:"user"`
+	Roles              []string                   `json:"roles"`
+	Organizations      []*models.UserOrganization `json:"organizations"`
+	Sessions           []*models.Session          `json:"sessions"`
+	Devices            []*models.UserDevice       `json:"devices"`
+	ExternalIdentities []*models.ExternalIdentity `json:"external_identities"`
+	AccessTokens       []*models.AccessToken      `json:"access_tokens"`
+	AuditLog           []*models.AuditLog         `json:"audit_log"`
+	PendingDeletion    *models.AccountDeletion    `json:"pending_deletion,omitempty"`
+}
+
+// ExportAccount gathers everything held about a user for them to download. Session tokens
+// are left out, as they are credentials rather than data about the user.
+func (s *AuthService) ExportAccount(ctx context.Context, userID uuid.UUID, ipAddress, userAgent string) (*AccountExport, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	export := &AccountExport{ExportedAt: time.Now().UTC(), User: user}
+	if export.Roles, err = s.roleRepo.GetByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Organizations, err = s.orgRepo.GetByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.Sessions, err = s.sessionRepo.GetAllByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	for _, session := range export.Sessions {
+		session.Token = ""
+	}
+	if export.Devices, err = s.deviceRepo.GetAllByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.ExternalIdentities, err = s.externalIdentityRepo.GetAllByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.AccessTokens, err = s.accessTokenRepo.GetAllByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+	if export.PendingDeletion, err = s.accountDeletionRepo.GetByUserID(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	for offset := 0; ; offset += accountExportAuditPage {
+		logs, err := s.auditRepo.GetByUserID(ctx, userID, accountExportAuditPage, offset)
+		if err != nil {
+			return nil, err
+		}
+		export.AuditLog = append(export.AuditLog, logs...)
+		if len(logs) < accountExportAuditPage {
+			break
+		}
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "account_exported",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"audit_log_entries": len(export.AuditLog),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return export, nil
+}
+
+// GetAccountDeletion returns the user's pending deletion request, or nil if there is none
+func (s *AuthService) GetAccountDeletion(ctx context.Context, userID uuid.UUID) (*models.AccountDeletion, error) {
+	return s.accountDeletionRepo.GetByUserID(ctx, userID)
+}
+
+// RequestAccountDeletion schedules the user's account for deletion once the grace period
+// ends and emails them a link to cancel it. Users who are the last owner of an organization
+// with other members must hand it over first.
+func (s *AuthService) RequestAccountDeletion(ctx context.Context, userID uuid.UUID, ipAddress, userAgent string) (*models.AccountDeletion, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if _, err := s.soleMemberOrganizations(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	cancelToken, err := generateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	deletion := &models.AccountDeletion{
+		UserID:          userID,
+		CancelTokenHash: hashToken(cancelToken),
+		DeleteAfter:     time.Now().Add(s.accountDeletionPolicy.GracePeriod),
+	}
+	created, err := s.accountDeletionRepo.Create(ctx, deletion)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, ErrAccountDeletionPending
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "account_deletion_requested",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"delete_after": deletion.DeleteAfter,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	// Send the confirmation without holding up the response
+	go func(email string, deleteAfter time.Time) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.emailService.SendAccountDeletionScheduledEmail(ctx, email, cancelToken, deleteAfter); err != nil {
+			slog.ErrorContext(ctx, "Error sending account deletion email", "error", err)
+		}
+	}(user.Email, deletion.DeleteAfter)
+
+	return deletion, nil
+}
+
+// CancelAccountDeletion cancels the user's pending deletion request
+func (s *AuthService) CancelAccountDeletion(ctx context.Context, userID uuid.UUID, ipAddress, userAgent string) error {
+	cancelled, err := s.accountDeletionRepo.Cancel(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return ErrAccountDeletionNotPending
+	}
+
+	s.auditAccountDeletionCancelled(ctx, userID, "session", ipAddress, userAgent)
+	return nil
+}
+
+// CancelAccountDeletionWithToken cancels a pending deletion request with the token emailed
+// when it was made, so users can cancel without signing in
+func (s *AuthService) CancelAccountDeletionWithToken(ctx context.Context, token, ipAddress, userAgent string) error {
+	deletion, err := s.accountDeletionRepo.CancelByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if deletion == nil {
+		return ErrInvalidToken
+	}
+
+	s.auditAccountDeletionCancelled(ctx, deletion.UserID, "email", ipAddress, userAgent)
+	return nil
+}
+
+// CompleteDueAccountDeletions purges the accounts whose grace period has ended, along with
+// the organizations they were the only member of. Their audit log entries are anonymised
+// rather than deleted. A user who has since become the last owner of an organization with
+// other members is left pending until they hand it over or cancel.
+func (s *AuthService) CompleteDueAccountDeletions(ctx context.Context) (int, error) {
+	deletions, err := s.accountDeletionRepo.GetDue(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	completed := 0
+	for _, deletion := range deletions {
+		if err := s.completeAccountDeletion(ctx, deletion); err != nil {
+			slog.ErrorContext(ctx, "Error deleting account", "user_id", deletion.UserID, "error", err)
+			continue
+		}
+		completed++
+	}
+
+	return completed, nil
+}
+
+// Helper function to delete one account whose grace period has ended
+func (s *AuthService) completeAccountDeletion(ctx context.Context, deletion *models.AccountDeletion) error {
+	// Users an admin deleted in the meantime are purged all the same
+	user, err := s.userRepo.GetByIDIncludingDeleted(ctx, deletion.UserID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil // Already gone; the request went with it
+	}
+
+	orgIDs, err := s.soleMemberOrganizations(ctx, user.UserID)
+	if err != nil {
+		return err
+	}
+	for _, orgID := range orgIDs {
+		if err := s.orgRepo.Delete(ctx, orgID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.userRepo.Purge(ctx, user.UserID); err != nil {
+		return err
+	}
+	if s.sessionCache != nil {
+		s.sessionCache.InvalidateUser(user.UserID)
+	}
+
+	// Recorded against no one, since the user no longer exists
+	auditLog := &models.AuditLog{
+		EventType: "account_deleted",
+		Details: map[string]interface{}{
+			"requested_at":          deletion.RequestedAt,
+			"organizations_deleted": len(orgIDs),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	if err := s.emailService.SendAccountDeletedEmail(ctx, user.Email); err != nil {
+		slog.ErrorContext(ctx, "Error sending account deleted email", "error", err)
+	}
+
+	return nil
+}
+
+// Helper function to find the organizations a user is the only member of, which go with
+// their account. It fails with ErrLastOrganizationOwner if the user is the last owner of
+// an organization that has other members.
+func (s *AuthService) soleMemberOrganizations(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	orgs, err := s.orgRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var orgIDs []uuid.UUID
+	for _, org := range orgs {
+		if org.Role != models.OrgRoleOwner {
+			continue
+		}
+		members, err := s.orgRepo.GetMembers(ctx, org.OrgID)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 1 {
+			orgIDs = append(orgIDs, org.OrgID)
+			continue
+		}
+
+		otherOwner := false
+		for _, member := range members {
+			if member.UserID != userID && member.Role == models.OrgRoleOwner {
+				otherOwner = true
+				break
+			}
+		}
+		if !otherOwner {
+			return nil, ErrLastOrganizationOwner
+		}
+	}
+
+	return orgIDs, nil
+}
+
+// Helper function to record a cancelled account deletion and how it was cancelled
+func (s *AuthService) auditAccountDeletionCancelled(ctx context.Context, userID uuid.UUID, via, ipAddress, userAgent string) {
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "account_deletion_cancelled",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"via": via,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+}
@@ -0,0 +1,183 @@
+// services/account_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to end the grace period of a user's pending deletion
+func makeAccountDeletionDue(t *testing.T, s *AuthService, user *models.User) {
+	t.Helper()
+	query := `UPDATE auth.account_deletions SET delete_after = NOW() - INTERVAL '1 minute' WHERE user_id = $1`
+	if _, err := s.pool.Exec(context.Background(), query, user.UserID); err != nil {
+		t.Fatalf("ending grace period: %v", err)
+	}
+}
+
+// Helper function to log in as bjensen
+func accountTestLogin(t *testing.T, s *AuthService) *LoginResult {
+	t.Helper()
+	login, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return login
+}
+
+func TestAccountDeletionGracePeriod(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	s.SetAccountDeletionPolicy(AccountDeletionPolicy{GracePeriod: 7 * 24 * time.Hour})
+	ctx := context.Background()
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+
+	deletion, err := s.RequestAccountDeletion(ctx, user.UserID, "192.0.2.1", "account-test")
+	if err != nil {
+		t.Fatalf("RequestAccountDeletion: %v", err)
+	}
+	if left := time.Until(deletion.DeleteAfter); left < 7*24*time.Hour-time.Minute || left > 7*24*time.Hour {
+		t.Errorf("deleted in %v, want the 7 day grace period", left)
+	}
+	if _, err := s.RequestAccountDeletion(ctx, user.UserID, "", ""); !errors.Is(err, ErrAccountDeletionPending) {
+		t.Errorf("requesting twice: err = %v, want ErrAccountDeletionPending", err)
+	}
+
+	// Nothing happens during the grace period
+	if completed, err := s.CompleteDueAccountDeletions(ctx); err != nil || completed != 0 {
+		t.Errorf("CompleteDueAccountDeletions during the grace period = %d, %v; want 0", completed, err)
+	}
+	if _, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple"); err != nil {
+		t.Errorf("signing in during the grace period: %v", err)
+	}
+
+	if err := s.CancelAccountDeletion(ctx, user.UserID, "", ""); err != nil {
+		t.Fatalf("CancelAccountDeletion: %v", err)
+	}
+	if err := s.CancelAccountDeletion(ctx, user.UserID, "", ""); !errors.Is(err, ErrAccountDeletionNotPending) {
+		t.Errorf("cancelling twice: err = %v, want ErrAccountDeletionNotPending", err)
+	}
+	if pending, err := s.GetAccountDeletion(ctx, user.UserID); err != nil || pending != nil {
+		t.Errorf("GetAccountDeletion after cancelling = %v, %v; want none", pending, err)
+	}
+
+	// Cancelling with the emailed token works without a session
+	if _, err := s.RequestAccountDeletion(ctx, user.UserID, "", ""); err != nil {
+		t.Fatalf("RequestAccountDeletion: %v", err)
+	}
+	token := "emailed-cancel-token"
+	if _, err := s.pool.Exec(ctx, `UPDATE auth.account_deletions SET cancel_token_hash = $2 WHERE user_id = $1`, user.UserID, hashToken(token)); err != nil {
+		t.Fatalf("setting cancel token: %v", err)
+	}
+	if err := s.CancelAccountDeletionWithToken(ctx, "wrong-token", "", ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("cancelling with the wrong token: err = %v, want ErrInvalidToken", err)
+	}
+	if err := s.CancelAccountDeletionWithToken(ctx, token, "", ""); err != nil {
+		t.Errorf("CancelAccountDeletionWithToken: %v", err)
+	}
+	if got := countAuditEvents(t, s, user, "account_deletion_cancelled"); got != 2 {
+		t.Errorf("%d account_deletion_cancelled events, want 2", got)
+	}
+}
+
+func TestCompleteDueAccountDeletions(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	ctx := context.Background()
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	colleague := createLocalTestUser(t, s, "alice", "correct-Horse-battery-9-staple")
+	login := accountTestLogin(t, s)
+
+	solo, err := s.CreateOrganization(ctx, user.UserID, "Solo", "solo", "", "")
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	shared, err := s.CreateOrganization(ctx, user.UserID, "Shared", "shared", "", "")
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	joinTestOrganization(t, s, shared, colleague, models.OrgRoleMember)
+
+	// The last owner of an organization with other members must hand it over first
+	if _, err := s.RequestAccountDeletion(ctx, user.UserID, "", ""); !errors.Is(err, ErrLastOrganizationOwner) {
+		t.Fatalf("RequestAccountDeletion as the last owner: err = %v, want ErrLastOrganizationOwner", err)
+	}
+	if err := s.UpdateOrganizationMemberRole(ctx, user.UserID, shared.OrgID, colleague.UserID, models.OrgRoleOwner, "", ""); err != nil {
+		t.Fatalf("UpdateOrganizationMemberRole: %v", err)
+	}
+	if _, err := s.RequestAccountDeletion(ctx, user.UserID, "192.0.2.1", "account-test"); err != nil {
+		t.Fatalf("RequestAccountDeletion: %v", err)
+	}
+	makeAccountDeletionDue(t, s, user)
+
+	completed, err := s.CompleteDueAccountDeletions(ctx)
+	if err != nil {
+		t.Fatalf("CompleteDueAccountDeletions: %v", err)
+	}
+	if completed != 1 {
+		t.Errorf("completed %d deletions, want 1", completed)
+	}
+
+	if purged, err := s.userRepo.GetByIDIncludingDeleted(ctx, user.UserID); err != nil || purged != nil {
+		t.Errorf("user after deletion = %v, %v; want gone", purged, err)
+	}
+	if _, _, err := s.ValidateSession(ctx, login.Session.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("session after deletion: err = %v, want ErrInvalidToken", err)
+	}
+	if org, err := s.orgRepo.GetByID(ctx, solo.OrgID); err != nil || org != nil {
+		t.Errorf("organization only the user belonged to = %v, %v; want deleted", org, err)
+	}
+	if org, err := s.orgRepo.GetByID(ctx, shared.OrgID); err != nil || org == nil {
+		t.Errorf("organization with another owner = %v, %v; want kept", org, err)
+	}
+
+	// The user's audit history stays, but no longer names them
+	var remaining, anonymised int
+	query := `
+		SELECT
+			COUNT(*) FILTER (WHERE user_id = $1),
+			COUNT(*) FILTER (WHERE user_id IS NULL AND event_type = 'account_deletion_requested' AND ip_address = '')
+		FROM auth.audit_log`
+	if err := s.pool.QueryRow(ctx, query, user.UserID).Scan(&remaining, &anonymised); err != nil {
+		t.Fatalf("counting audit events: %v", err)
+	}
+	if remaining != 0 || anonymised != 1 {
+		t.Errorf("%d audit events still name the user and %d were anonymised, want 0 and 1", remaining, anonymised)
+	}
+
+	// The username and email can be used again
+	createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+}
+
+func TestExportAccount(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	ctx := context.Background()
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple", "auditor")
+	login := accountTestLogin(t, s)
+	if _, err := s.CreateOrganization(ctx, user.UserID, "Acme", "acme", "", ""); err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+
+	export, err := s.ExportAccount(ctx, user.UserID, "192.0.2.1", "account-test")
+	if err != nil {
+		t.Fatalf("ExportAccount: %v", err)
+	}
+	if export.User.UserID != user.UserID || len(export.Roles) != 1 || len(export.Organizations) != 1 {
+		t.Errorf("export has user %v, roles %v and %d organizations; want the user's own", export.User.UserID, export.Roles, len(export.Organizations))
+	}
+	if len(export.Sessions) != 1 || export.Sessions[0].SessionID != login.Session.SessionID {
+		t.Fatalf("export has %d sessions, want the login's", len(export.Sessions))
+	}
+	if export.Sessions[0].Token != "" {
+		t.Error("export includes a session token")
+	}
+	if len(export.AuditLog) == 0 || export.PendingDeletion != nil {
+		t.Errorf("export has %d audit entries and deletion %v; want the login history and no deletion", len(export.AuditLog), export.PendingDeletion)
+	}
+	if got := countAuditEvents(t, s, user, "account_exported"); got != 1 {
+		t.Errorf("%d account_exported events, want 1", got)
+	}
+}
@@ -0,0 +1,44 @@
+// services/audit.go
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// ExportAuditLog calls fn for every audit log entry created in [since, until), oldest
+// first. A zero since or until leaves that end open.
+func (s *AuthService) ExportAuditLog(ctx context.Context, since, until time.Time, fn func(*models.AuditLog) error) error {
+	return s.auditRepo.Export(ctx, since, until, fn)
+}
+
+// PruneAuditLog deletes the audit log entries older than the cutoff, or only counts them
+// for a dry run. The pruning itself is recorded.
+func (s *AuthService) PruneAuditLog(ctx context.Context, olderThan time.Time, dryRun bool, ipAddress, userAgent string) (int64, error) {
+	if dryRun {
+		return s.auditRepo.CountOlderThan(ctx, olderThan)
+	}
+
+	count, err := s.auditRepo.DeleteOlderThan(ctx, olderThan)
+	if err != nil {
+		return 0, err
+	}
+
+	auditLog := &models.AuditLog{
+		EventType: "audit_log_pruned",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"older_than": olderThan,
+			"deleted":    count,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return count, nil
+}
@@ -4,129 +4,433 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4/pgxpool"
 
-	// "golang.org/x/crypto/bcrypt"
-
+	"github.com/loganmanery/go-react-app/metrics"
 	"github.com/loganmanery/go-react-app/models"
 )
 
 var (
 	ErrInvalidCredentials    = errors.New("invalid username or password")
 	ErrUserLocked            = errors.New("account is locked due to too many failed login attempts")
-	ErrEmailAlreadyExists    = errors.New("email already exists")
-	ErrUsernameAlreadyExists = errors.New("username already exists")
+	ErrEmailAlreadyExists    = models.ErrEmailAlreadyExists
+	ErrUsernameAlreadyExists = models.ErrUsernameAlreadyExists
+	ErrConfusableUsername    = models.ErrConfusableUsername
 	ErrUserNotFound          = errors.New("user not found")
 	ErrInvalidToken          = errors.New("invalid or expired token")
+	ErrDeviceNotFound        = errors.New("device not found")
+	ErrSessionLimitReached   = models.ErrSessionLimitReached
 )
 
 // AuthService handles authentication-related operations
 type AuthService struct {
-	pool           *pgxpool.Pool
-	userRepo       *models.UserRepository
-	sessionRepo    *models.SessionRepository
-	jwtSecret      string
-	tokenExpiryMin int
+	pool                  *pgxpool.Pool
+	userRepo              *models.UserRepository
+	sessionRepo           *models.SessionRepository
+	roleRepo              *models.RoleRepository
+	deviceRepo            *models.DeviceRepository
+	challengeRepo         *models.LoginChallengeRepository
+	accessTokenRepo       *models.AccessTokenRepository
+	oauthClientRepo       *models.OAuthClientRepository
+	oauthAuthzRepo        *models.OAuthAuthorizationRepository
+	oauthTokenRepo        *models.OAuthTokenRepository
+	externalIdentityRepo  *models.ExternalIdentityRepository
+	samlRepo              *models.SAMLRepository
+	scimRepo              *models.SCIMRepository
+	orgRepo               *models.OrganizationRepository
+	accountDeletionRepo   *models.AccountDeletionRepository
+	auditRepo             *models.AuditLogRepository
+	emailService          *EmailService
+	passwordPolicy        *PasswordPolicy
+	lockoutPolicy         models.LockoutPolicy
+	sessionPolicy         SessionPolicy
+	sessionLimits         SessionLimitPolicy
+	impersonationPolicy   ImpersonationPolicy
+	accountDeletionPolicy AccountDeletionPolicy
+	userRetentionPolicy   UserRetentionPolicy
+	credentialPolicy      CredentialPolicy
+	loginRiskPolicy       LoginRiskPolicy
+	ipReputation          IPReputation
+	mfaVerifier           MFAVerifier
+	sessionCache          *SessionCache
+	metrics               *metrics.Metrics
+	oidc                  *OIDCConfig
+	externalProviders     map[string]*externalProvider
+	samlProviders         map[string]*samlProvider
+	scim                  *SCIMConfig
+	jwtSecret             string
 }
 
 // NewAuthService creates a new AuthService
 func NewAuthService(pool *pgxpool.Pool, jwtSecret string, tokenExpiryMin int) *AuthService {
 	return &AuthService{
-		pool:           pool,
-		userRepo:       models.NewUserRepository(pool),
-		sessionRepo:    models.NewSessionRepository(pool),
-		jwtSecret:      jwtSecret,
-		tokenExpiryMin: tokenExpiryMin,
+		pool:                  pool,
+		userRepo:              models.NewUserRepository(pool),
+		sessionRepo:           models.NewSessionRepository(pool),
+		roleRepo:              models.NewRoleRepository(pool),
+		deviceRepo:            models.NewDeviceRepository(pool),
+		challengeRepo:         models.NewLoginChallengeRepository(pool),
+		accessTokenRepo:       models.NewAccessTokenRepository(pool),
+		oauthClientRepo:       models.NewOAuthClientRepository(pool),
+		oauthAuthzRepo:        models.NewOAuthAuthorizationRepository(pool),
+		oauthTokenRepo:        models.NewOAuthTokenRepository(pool),
+		externalIdentityRepo:  models.NewExternalIdentityRepository(pool),
+		samlRepo:              models.NewSAMLRepository(pool),
+		scimRepo:              models.NewSCIMRepository(pool),
+		orgRepo:               models.NewOrganizationRepository(pool),
+		accountDeletionRepo:   models.NewAccountDeletionRepository(pool),
+		auditRepo:             models.NewAuditLogRepository(pool),
+		emailService:          NewEmailService(LogEmailSender{}, "Go React App", "http://localhost:8080"),
+		lockoutPolicy:         models.NewLockoutPolicy(),
+		passwordPolicy:        &PasswordPolicy{config: NewPasswordPolicyConfig(), historyRepo: models.NewPasswordHistoryRepository(pool)},
+		sessionPolicy:         NewSessionPolicy(time.Duration(tokenExpiryMin) * time.Minute),
+		sessionLimits:         NewSessionLimitPolicy(),
+		impersonationPolicy:   NewImpersonationPolicy(),
+		accountDeletionPolicy: NewAccountDeletionPolicy(),
+		userRetentionPolicy:   NewUserRetentionPolicy(),
+		credentialPolicy:      NewCredentialPolicy(),
+		loginRiskPolicy:       NewLoginRiskPolicy(),
+		jwtSecret:             jwtSecret,
 	}
 }
 
+// SetPasswordPolicy replaces the default password policy
+func (s *AuthService) SetPasswordPolicy(policy *PasswordPolicy) {
+	s.passwordPolicy = policy
+}
+
+// SetLockoutPolicy replaces the default account lockout policy
+func (s *AuthService) SetLockoutPolicy(policy models.LockoutPolicy) {
+	s.lockoutPolicy = policy
+}
+
+// SetSessionPolicy replaces the default session lifetimes
+func (s *AuthService) SetSessionPolicy(policy SessionPolicy) {
+	s.sessionPolicy = policy
+}
+
+// SetEmailService replaces the default email service, which only logs emails
+func (s *AuthService) SetEmailService(emailService *EmailService) {
+	s.emailService = emailService
+}
+
+// SetSessionLimitPolicy replaces the default concurrent session limits
+func (s *AuthService) SetSessionLimitPolicy(policy SessionLimitPolicy) {
+	s.sessionLimits = policy
+}
+
+// SetSessionCache enables caching of validated sessions; nil disables it
+func (s *AuthService) SetSessionCache(cache *SessionCache) {
+	s.sessionCache = cache
+}
+
+// SetMetrics records login and session metrics; nil disables them
+func (s *AuthService) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetLoginRiskPolicy replaces the default login risk weights and thresholds
+func (s *AuthService) SetLoginRiskPolicy(policy LoginRiskPolicy) {
+	s.loginRiskPolicy = policy
+}
+
+// SetIPReputation sets the GeoIP databases and IP lists used to score logins
+func (s *AuthService) SetIPReputation(reputation IPReputation) {
+	s.ipReputation = reputation
+}
+
+// SetMFAVerifier lets risky logins be confirmed with MFA instead of by email
+func (s *AuthService) SetMFAVerifier(verifier MFAVerifier) {
+	s.mfaVerifier = verifier
+}
+
+// ValidatePassword checks a candidate password against the password policy
+func (s *AuthService) ValidatePassword(ctx context.Context, password string, user *models.User) error {
+	return s.passwordPolicy.Validate(ctx, password, user)
+}
+
+// LoginRequest holds the details of a login attempt
+type LoginRequest struct {
+	UsernameOrEmail string
+	Password        string
+	IPAddress       string
+	UserAgent       string
+	RememberMe      bool   // Create a longer-lived persistent session
+	DeviceToken     string // Device token from an earlier login on this device, if any
+}
+
+// LoginResult holds the outcome of a successful login
+type LoginResult struct {
+	Session     *models.Session
+	Device      *models.UserDevice
+	DeviceToken string // Device token the client should keep for future logins
+	NewDevice   bool   // The login came from a device we had not seen before
+}
+
 // Login authenticates a user and creates a new session
-func (s *AuthService) Login(ctx context.Context, usernameOrEmail, password, ipAddress, userAgent string) (*models.Session, error) {
+func (s *AuthService) Login(ctx context.Context, req LoginRequest) (_ *LoginResult, err error) {
+	defer func() { s.recordLoginAttempt(loginMethodPassword, err) }()
+
 	// Try to find the user by email first, then by username
 	var user *models.User
-	var err error
 
-	user, err = s.userRepo.GetByEmail(ctx, usernameOrEmail)
+	user, err = s.userRepo.GetByEmail(ctx, req.UsernameOrEmail)
 	if user == nil {
-		user, err = s.userRepo.GetByUsername(ctx, usernameOrEmail)
+		user, err = s.userRepo.GetByUsername(ctx, req.UsernameOrEmail)
 	}
 
 	if err != nil {
 		return nil, err
 	}
 
-	if user == nil {
+	// Users not known locally may still be in a directory
+	if user == nil && len(s.credentialPolicy.Verifiers) == 0 {
 		// Record failed login attempt but don't indicate whether the user exists
 		return nil, ErrInvalidCredentials
 	}
 
 	// Check if account is locked
-	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+	if user != nil && user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
 		return nil, ErrUserLocked
 	}
 
-	// Verify password
-	if !s.userRepo.VerifyPassword(user, password) {
-		// Increment failed login attempts
-		if err := s.userRepo.IncrementFailedLoginAttempts(ctx, user.UserID); err != nil {
+	// Verify password, locally or against a directory
+	verified, err := s.verifyCredentials(ctx, user, req.UsernameOrEmail, req.Password, req.IPAddress, req.UserAgent)
+	if errors.Is(err, ErrInvalidCredentials) && user != nil {
+		// Increment failed login attempts, which may lock the account
+		status, err := s.userRepo.IncrementFailedLoginAttempts(ctx, user.UserID, s.lockoutPolicy)
+		if err != nil {
 			return nil, err
 		}
+		if status.JustLocked {
+			s.handleAccountLocked(ctx, user, status, lockoutReasonPassword, req.IPAddress, req.UserAgent)
+			return nil, ErrUserLocked
+		}
 		return nil, ErrInvalidCredentials
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory may have matched a different local account than the login did
+	if (user == nil || verified.UserID != user.UserID) && verified.LockedUntil != nil && time.Now().Before(*verified.LockedUntil) {
+		return nil, ErrUserLocked
+	}
+
+	return s.continueLogin(ctx, verified, req, "")
+}
+
+// Takes a user whose credentials have been checked through device recognition and risk
+// scoring to a session. provider names the external identity provider the user signed
+// in with, if any.
+func (s *AuthService) continueLogin(ctx context.Context, user *models.User, req LoginRequest, provider string) (*LoginResult, error) {
+	// Work out which device the login came from
+	device, deviceToken, newDevice, err := s.recognizeDevice(ctx, user, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Score the login and hold back risky ones
+	risk, err := s.assessLoginRisk(ctx, user, newDevice, req.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+	s.auditLoginRisk(ctx, user, device, risk, req.IPAddress, req.UserAgent)
+
+	login := &pendingLogin{
+		user:        user,
+		device:      device,
+		deviceToken: deviceToken,
+		newDevice:   newDevice,
+		risk:        risk,
+		ipAddress:   req.IPAddress,
+		userAgent:   req.UserAgent,
+		rememberMe:  req.RememberMe,
+		provider:    provider,
+	}
+
+	switch risk.Action {
+	case RiskActionBlock:
+		return nil, ErrLoginBlocked
+	case RiskActionChallenge:
+		return nil, s.startLoginChallenge(ctx, login)
+	}
+
+	return s.completeLogin(ctx, login)
+}
+
+// A login that has passed every check and only needs its session created
+type pendingLogin struct {
+	user        *models.User
+	device      *models.UserDevice
+	deviceToken string
+	newDevice   bool
+	risk        *LoginRiskAssessment
+	ipAddress   string
+	userAgent   string
+	rememberMe  bool
+	challengeID *uuid.UUID // Set when the login was confirmed through a challenge
+	provider    string     // Set when the user signed in with an external identity provider
+}
+
+// Creates the session for a login and records it
+func (s *AuthService) completeLogin(ctx context.Context, login *pendingLogin) (*LoginResult, error) {
+	user, device := login.user, login.device
 
-	// Password correct - create session
 	token, err := generateSecureToken(32)
 	if err != nil {
 		return nil, err
 	}
 
 	// Create a new session
-	expiryTime := time.Now().Add(time.Duration(s.tokenExpiryMin) * time.Minute)
+	expiresAt, absoluteExpiresAt := s.sessionPolicy.expiryTimes(time.Now(), login.rememberMe)
 	session := &models.Session{
-		UserID:    user.UserID,
-		Token:     token,
-		IPAddress: ipAddress,
-		UserAgent: userAgent,
-		ExpiresAt: expiryTime,
-		IsValid:   true,
-	}
-
-	// Save the session
-	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		UserID:            user.UserID,
+		Token:             token,
+		IPAddress:         login.ipAddress,
+		UserAgent:         login.userAgent,
+		ExpiresAt:         expiresAt,
+		AbsoluteExpiresAt: absoluteExpiresAt,
+		IsValid:           true,
+		IsPersistent:      login.rememberMe,
+		DeviceID:          &device.DeviceID,
+		DeviceBrowser:     device.Browser,
+		DeviceOS:          device.OS,
+		DeviceType:        device.DeviceType,
+		GeoCountry:        login.risk.Location.CountryCode,
+		GeoLatitude:       login.risk.Location.Latitude,
+		GeoLongitude:      login.risk.Location.Longitude,
+		RiskScore:         login.risk.Score,
+	}
+
+	// Start the session in the organization the user last worked in
+	if orgID, err := s.orgRepo.GetDefaultForUser(ctx, user.UserID); err != nil {
+		// Just log this error, the user can pick an organization later
+		slog.ErrorContext(ctx, "Error choosing active organization", "error", err)
+	} else {
+		session.ActiveOrgID = orgID
+	}
+
+	// Save the session, making room for it if the user is at their session limit
+	if err := s.createSessionWithinLimit(ctx, user, session); err != nil {
 		return nil, err
 	}
+	s.metrics.SessionsCreated(sessionKindLogin, 1)
 
 	// Record successful login
 	if err := s.userRepo.RecordLogin(ctx, user.UserID); err != nil {
 		return nil, err
 	}
 
+	// A SAML sign-in held back by the challenge is linked to it; move the link to the session
+	if login.challengeID != nil {
+		if err := s.samlRepo.AttachSession(ctx, *login.challengeID, session.SessionID); err != nil {
+			// Just log this error, don't fail the login
+			slog.ErrorContext(ctx, "Error linking SAML session", "error", err)
+		}
+	}
+
 	// Create an audit log entry
+	details := map[string]interface{}{
+		"successful":  true,
+		"remember_me": login.rememberMe,
+		"device_id":   device.DeviceID.String(),
+		"new_device":  login.newDevice,
+		"risk_score":  login.risk.Score,
+	}
+	if login.challengeID != nil {
+		details["challenge_id"] = login.challengeID.String()
+	}
+	if login.provider != "" {
+		details["provider"] = login.provider
+	}
 	auditLog := &models.AuditLog{
 		UserID:    user.UserID,
 		EventType: "login",
-		IPAddress: ipAddress,
-		UserAgent: userAgent,
-		Details:   map[string]interface{}{"successful": true},
+		IPAddress: login.ipAddress,
+		UserAgent: login.userAgent,
+		Details:   details,
 	}
 
 	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
 		// Log the error but don't fail the login
-		fmt.Printf("Error creating audit log: %v\n", err)
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	// Let the user know about sign-ins from unrecognised devices, except on their very first login
+	if login.newDevice && user.LastLoginAt != nil {
+		s.handleNewDeviceLogin(ctx, user, device, login.ipAddress, login.userAgent)
+	}
+
+	return &LoginResult{
+		Session:     session,
+		Device:      device,
+		DeviceToken: login.deviceToken,
+		NewDevice:   login.newDevice,
+	}, nil
+}
+
+// UnlockUser clears a user's lockout on behalf of an admin
+func (s *AuthService) UnlockUser(ctx context.Context, adminID, userID uuid.UUID, ipAddress, userAgent string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.userRepo.Unlock(ctx, userID); err != nil {
+		return err
 	}
 
-	return session, nil
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "account_unlocked",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"unlocked_by":   adminID.String(),
+			"was_locked":    user.LockedUntil != nil && time.Now().Before(*user.LockedUntil),
+			"lockout_count": user.LockoutCount,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// UserHasRole checks if a user has been granted a role
+func (s *AuthService) UserHasRole(ctx context.Context, userID uuid.UUID, role string) (bool, error) {
+	return s.roleRepo.HasRole(ctx, userID, role)
 }
 
 // Register creates a new user account
 func (s *AuthService) Register(ctx context.Context, username, email, password, firstName, lastName string) (*models.User, error) {
-	// Check if email already exists
+	username = models.NormalizeUsername(username)
+	email = models.NormalizeEmail(email)
+	if err := models.CheckUsernameConfusables(username); err != nil {
+		return nil, err
+	}
+
+	// Check the password against the policy
+	candidate := &models.User{Username: username, Email: email, FirstName: firstName, LastName: lastName}
+	if err := s.passwordPolicy.Validate(ctx, password, candidate); err != nil {
+		return nil, err
+	}
+
+	// Check if email already exists. This is only for a friendly early answer; the unique
+	// indexes catch registrations racing for the same email or username when inserting.
 	existingUser, err := s.userRepo.GetByEmail(ctx, email)
 	if err != nil {
 		return nil, err
@@ -175,37 +479,103 @@ func (s *AuthService) Register(ctx context.Context, username, email, password, f
 	return user, nil
 }
 
-// Logout invalidates a user session
+// Logout invalidates a session
 func (s *AuthService) Logout(ctx context.Context, token string) error {
-	return s.sessionRepo.Invalidate(ctx, token)
+	return s.revokeSession(ctx, token, sessionRevokedLogout)
+}
+
+// Invalidates a session, recording why it ended
+func (s *AuthService) revokeSession(ctx context.Context, token, reason string) error {
+	if err := s.sessionRepo.Invalidate(ctx, token); err != nil {
+		return err
+	}
+	s.metrics.SessionsRevoked(reason, 1)
+
+	// Other replicas hear about it from the database; drop it here straight away
+	if s.sessionCache != nil {
+		s.sessionCache.Remove(token)
+	}
+	return nil
 }
 
 // ValidateSession checks if a session is valid
 func (s *AuthService) ValidateSession(ctx context.Context, token string) (*models.Session, *models.User, error) {
-	// Find the session
-	session, err := s.sessionRepo.GetByToken(ctx, token)
+	// Find the session and its user
+	session, user, err := s.lookupSession(ctx, token)
 	if err != nil {
 		return nil, nil, err
 	}
-	if session == nil || !session.IsValid || time.Now().After(session.ExpiresAt) {
+
+	now := time.Now()
+	if session == nil || !session.IsValid || now.After(session.ExpiresAt) || now.After(session.AbsoluteExpiresAt) {
 		return nil, nil, ErrInvalidToken
 	}
 
-	// Get the user associated with the session
-	user, err := s.userRepo.GetByID(ctx, session.UserID)
-	if err != nil {
-		return nil, nil, err
+	// Also enforce the idle timeout directly, in case it was shortened after the session was created
+	idleTimeout := s.sessionPolicy.idleTimeout(session.IsPersistent)
+	if now.Sub(session.LastActiveAt) > idleTimeout {
+		return nil, nil, ErrInvalidToken
 	}
+
 	if user == nil || !user.IsActive {
 		return nil, nil, ErrUserNotFound
 	}
 
-	// Update the last active time
-	if err := s.sessionRepo.UpdateLastActiveAt(ctx, session.SessionID); err != nil {
-		// Just log this error, don't fail the validation
-		fmt.Printf("Error updating session last active time: %v\n", err)
+	// Impersonation sessions stop working as soon as the admin behind them can no longer impersonate
+	if session.ImpersonatorID != nil {
+		if err := s.checkImpersonator(ctx, session); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Record activity and slide the idle expiry, but only once per update interval
+	if now.Sub(session.LastActiveAt) >= s.sessionPolicy.ActivityUpdateInterval {
+		touched, err := s.sessionRepo.Touch(ctx, session, idleTimeout, s.sessionPolicy.ActivityUpdateInterval)
+		switch {
+		case err != nil:
+			// Just log this error, don't fail the validation
+			slog.ErrorContext(ctx, "Error updating session last active time", "error", err)
+		case s.sessionCache == nil:
+		case touched:
+			s.sessionCache.Refresh(session)
+		default:
+			// Another replica touched it, so our cached copy is out of date
+			s.sessionCache.Remove(token)
+		}
+	}
+
+	return session, user, nil
+}
+
+// Finds a session and its user, from the cache when possible
+func (s *AuthService) lookupSession(ctx context.Context, token string) (*models.Session, *models.User, error) {
+	if s.sessionCache == nil {
+		return s.loadSession(ctx, token)
+	}
+
+	if session, user, ok := s.sessionCache.Get(token); ok {
+		return session, user, nil
 	}
 
+	generation := s.sessionCache.Generation()
+	session, user, err := s.loadSession(ctx, token)
+	if err == nil && session != nil && session.IsValid && user != nil && user.IsActive {
+		s.sessionCache.Put(session, user, generation)
+	}
+	return session, user, err
+}
+
+// Loads a session and, if it is still valid, its user from the database
+func (s *AuthService) loadSession(ctx context.Context, token string) (*models.Session, *models.User, error) {
+	session, err := s.sessionRepo.GetByToken(ctx, token)
+	if err != nil || session == nil || !session.IsValid {
+		return session, nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
 	return session, user, nil
 }
 
@@ -215,7 +585,8 @@ func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
 	query := `
 		SELECT user_id FROM auth.users 
 		WHERE email_verification_token = $1 
-		AND is_email_verified = false`
+		AND is_email_verified = false
+		AND deleted_at IS NULL`
 
 	var userID uuid.UUID
 	err := s.pool.QueryRow(ctx, query, token).Scan(&userID)
@@ -282,8 +653,25 @@ func (s *AuthService) ResetPassword(ctx context.Context, token, newPassword stri
 		return ErrInvalidToken
 	}
 
+	// Check the new password against the policy
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if directoryUser, err := s.isDirectoryUser(ctx, userID); err != nil {
+		return err
+	} else if directoryUser {
+		return ErrPasswordManagedExternally
+	}
+	if err := s.passwordPolicy.Validate(ctx, newPassword, user); err != nil {
+		return err
+	}
+
 	// Update the password
-	return s.userRepo.UpdatePassword(ctx, userID, newPassword)
+	return s.userRepo.UpdatePassword(ctx, userID, newPassword, s.passwordPolicy.historyCount())
 }
 
 // ChangePassword changes a user's password (when they know their current password)
@@ -296,28 +684,66 @@ func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, curr
 	if user == nil {
 		return ErrUserNotFound
 	}
+	if directoryUser, err := s.isDirectoryUser(ctx, userID); err != nil {
+		return err
+	} else if directoryUser {
+		return ErrPasswordManagedExternally
+	}
 
 	// Verify current password
 	if !s.userRepo.VerifyPassword(user, currentPassword) {
 		return ErrInvalidCredentials
 	}
 
+	// Check the new password against the policy
+	if err := s.passwordPolicy.Validate(ctx, newPassword, user); err != nil {
+		return err
+	}
+
 	// Update to new password
-	return s.userRepo.UpdatePassword(ctx, userID, newPassword)
+	return s.userRepo.UpdatePassword(ctx, userID, newPassword, s.passwordPolicy.historyCount())
+}
+
+// Records an account lockout and notifies the user
+func (s *AuthService) handleAccountLocked(ctx context.Context, user *models.User, status *models.LockoutStatus, reason, ipAddress, userAgent string) {
+	s.metrics.AccountLocked(reason)
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "account_locked",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"locked_until":  status.LockedUntil,
+			"lockout_count": status.LockoutCount,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	// Send the notification without holding up the login response
+	go func(email string, lockedUntil time.Time) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.emailService.SendAccountLockedEmail(ctx, email, lockedUntil); err != nil {
+			slog.ErrorContext(ctx, "Error sending account locked email", "error", err)
+		}
+	}(user.Email, *status.LockedUntil)
 }
 
-// Creates an audit log entry
+// Creates an audit log entry, belonging to no user when UserID is uuid.Nil
 func (s *AuthService) createAuditLog(ctx context.Context, log *models.AuditLog) (uuid.UUID, error) {
 	query := `
 		INSERT INTO auth.audit_log (
 			user_id, event_type, ip_address, user_agent, details
 		) VALUES (
-			$1, $2, $3, $4, $5
+			NULLIF($1, '00000000-0000-0000-0000-000000000000'::uuid), $2, $3, $4, $5
 		) RETURNING log_id`
 
 	var logID uuid.UUID
 	err := s.pool.QueryRow(ctx, query,
-		log.UserID, log.EventType, log.IPAddress, log.UserAgent, log.Details,
+		log.UserID, log.EventType, log.IPAddress, log.UserAgent, log.DetailsWithRequestID(ctx),
 	).Scan(&logID)
 
 	return logID, err
@@ -331,3 +757,9 @@ func generateSecureToken(length int) (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
+
+// Helper function to hash a token for storage
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
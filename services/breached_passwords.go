@@ -0,0 +1,91 @@
+// services/breached_passwords.go
+package services
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedPasswordList checks passwords against a local copy of a breached-password corpus.
+// The directory holds one file per 5 character SHA-1 prefix (e.g. "21BD1" or "21BD1.txt"),
+// each containing "SUFFIX:COUNT" lines, the same layout as the Have I Been Pwned range API.
+type BreachedPasswordList struct {
+	dir      string
+	minCount int
+}
+
+// LoadBreachedPasswordList opens a breached-password directory.
+// Hashes seen fewer than minCount times are ignored.
+func LoadBreachedPasswordList(dir string, minCount int) (*BreachedPasswordList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening breached password list: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password list %s is not a directory", dir)
+	}
+
+	if minCount < 1 {
+		minCount = 1
+	}
+
+	return &BreachedPasswordList{dir: dir, minCount: minCount}, nil
+}
+
+// Contains reports whether the password appears in the breached-password list
+func (l *BreachedPasswordList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := l.openPrefixFile(prefix)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// No file for this prefix means no breached hashes share it
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, countStr, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+
+		// Lines without a count are treated as seen once
+		count := 1
+		if countStr != "" {
+			if count, err = strconv.Atoi(strings.TrimSpace(countStr)); err != nil {
+				return false, fmt.Errorf("invalid count in breached password file %s: %w", prefix, err)
+			}
+		}
+		return count >= l.minCount, nil
+	}
+
+	return false, scanner.Err()
+}
+
+// Helper function to open the file for a hash prefix, with or without an extension
+func (l *BreachedPasswordList) openPrefixFile(prefix string) (*os.File, error) {
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		file, err := os.Open(filepath.Join(l.dir, name))
+		if err == nil {
+			return file, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, os.ErrNotExist
+}
@@ -0,0 +1,296 @@
+// services/credentials.go
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	// ErrCredentialsNotHandled is returned by a CredentialVerifier that does not know the login,
+	// so the next verifier in the chain is tried
+	ErrCredentialsNotHandled = errors.New("credentials not handled by this verifier")
+
+	ErrDirectoryUnavailable      = errors.New("directory service unavailable")
+	ErrPasswordManagedExternally = errors.New("password is managed by the directory service")
+)
+
+// CredentialVerifier checks a login and password against an external directory. It returns
+// ErrCredentialsNotHandled if the login is unknown to it, ErrInvalidCredentials if the
+// password is wrong, and ErrDirectoryUnavailable if it cannot tell.
+type CredentialVerifier interface {
+	Name() string // Stored as the provider of the identities it verifies, e.g. "ldap"
+	Verify(ctx context.Context, login, password string) (*VerifiedCredentials, error)
+}
+
+// VerifiedCredentials describes the directory account a CredentialVerifier authenticated
+type VerifiedCredentials struct {
+	Subject      string // Stable identifier of the account in the directory
+	Username     string
+	Email        string
+	FirstName    string
+	LastName     string
+	Roles        []string // Roles the account's groups map to
+	ManagedRoles []string // Every role the directory controls; these are granted or revoked on each login
+}
+
+// CredentialPolicy controls how logins are checked once directory verifiers are in use
+type CredentialPolicy struct {
+	Verifiers []CredentialVerifier // Tried in order for users who are not local accounts
+
+	// If set, local passwords only work for users with one of these roles, so that with a
+	// directory in place only break-glass admins keep a local login
+	LocalLoginRoles []string
+
+	AutoProvision bool // Create accounts for directory users on their first login
+}
+
+// NewCredentialPolicy creates a credential policy that only checks local passwords
+func NewCredentialPolicy() CredentialPolicy {
+	return CredentialPolicy{
+		AutoProvision: true,
+	}
+}
+
+// SetCredentialPolicy updates the credential verifier chain
+func (s *AuthService) SetCredentialPolicy(policy CredentialPolicy) {
+	s.credentialPolicy = policy
+}
+
+// Checks a password for a login through the verifier chain. user is the local account the
+// login matched, if any; the returned user may be a different or newly provisioned one.
+func (s *AuthService) verifyCredentials(ctx context.Context, user *models.User, login, password, ipAddress, userAgent string) (*models.User, error) {
+	directoryUser := false
+	if user != nil && len(s.credentialPolicy.Verifiers) > 0 {
+		var err error
+		if directoryUser, err = s.isDirectoryUser(ctx, user.UserID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Local accounts, including break-glass admins, keep using their own password
+	if user != nil && !directoryUser {
+		allowed, err := s.localLoginAllowed(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed || !s.userRepo.VerifyPassword(user, password) {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	}
+
+	for _, verifier := range s.credentialPolicy.Verifiers {
+		credentials, err := verifier.Verify(ctx, login, password)
+		if errors.Is(err, ErrCredentialsNotHandled) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.syncDirectoryUser(ctx, verifier.Name(), credentials, s.credentialPolicy.AutoProvision, ipAddress, userAgent)
+	}
+
+	return nil, ErrInvalidCredentials
+}
+
+// Finds or provisions the local account for a directory account and brings its
+// details and directory-managed roles up to date
+func (s *AuthService) syncDirectoryUser(ctx context.Context, provider string, credentials *VerifiedCredentials, autoProvision bool, ipAddress, userAgent string) (*models.User, error) {
+	identity, err := s.externalIdentityRepo.GetByProviderSubject(ctx, provider, credentials.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	if identity != nil {
+		if user, err = s.userRepo.GetByID(ctx, identity.UserID); err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, ErrUserNotFound
+		}
+
+		// Keep the profile in line with the directory
+		if user.FirstName != credentials.FirstName || user.LastName != credentials.LastName ||
+			(credentials.Email != "" && user.Email != credentials.Email) {
+			user.FirstName = credentials.FirstName
+			user.LastName = credentials.LastName
+			if credentials.Email != "" {
+				user.Email = credentials.Email
+			}
+			if err := s.userRepo.Update(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if !autoProvision {
+			return nil, ErrInvalidCredentials
+		}
+		if user, err = s.provisionDirectoryUser(ctx, provider, credentials, ipAddress, userAgent); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.syncDirectoryRoles(ctx, user.UserID, provider, credentials, ipAddress, userAgent); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Creates the local account for a directory user on their first login
+func (s *AuthService) provisionDirectoryUser(ctx context.Context, provider string, credentials *VerifiedCredentials, ipAddress, userAgent string) (*models.User, error) {
+	if credentials.Email == "" {
+		return nil, fmt.Errorf("directory account %s has no email address", credentials.Username)
+	}
+	existing, err := s.userRepo.GetByEmail(ctx, credentials.Email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		// A local account already uses this email; don't take it over
+		return nil, ErrEmailAlreadyExists
+	}
+
+	username, err := s.availableUsername(ctx, credentials.Username, credentials.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	// The local password is never used; the directory checks every login
+	password, err := generateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:        username,
+		Email:           credentials.Email,
+		FirstName:       credentials.FirstName,
+		LastName:        credentials.LastName,
+		IsEmailVerified: true,
+		IsActive:        true,
+	}
+	if err := s.userRepo.Create(ctx, user, password); err != nil {
+		return nil, err
+	}
+
+	identity := &models.ExternalIdentity{
+		UserID:   user.UserID,
+		Provider: provider,
+		Subject:  credentials.Subject,
+		Email:    credentials.Email,
+	}
+	if err := s.externalIdentityRepo.Create(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "user_provisioned",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider": provider,
+			"subject":  credentials.Subject,
+			"username": username,
+			"email":    credentials.Email,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return user, nil
+}
+
+// Grants and revokes the roles the directory controls to match the user's groups
+func (s *AuthService) syncDirectoryRoles(ctx context.Context, userID uuid.UUID, provider string, credentials *VerifiedCredentials, ipAddress, userAgent string) error {
+	current, err := s.roleRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var granted, revoked []string
+	for _, role := range credentials.ManagedRoles {
+		want, has := containsString(credentials.Roles, role), containsString(current, role)
+		switch {
+		case want && !has:
+			if err := s.roleRepo.Grant(ctx, userID, role); err != nil {
+				return err
+			}
+			granted = append(granted, role)
+		case !want && has:
+			if err := s.roleRepo.Revoke(ctx, userID, role); err != nil {
+				return err
+			}
+			revoked = append(revoked, role)
+		}
+	}
+
+	if len(granted) == 0 && len(revoked) == 0 {
+		return nil
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "roles_synced",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider": provider,
+			"granted":  granted,
+			"revoked":  revoked,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// Checks whether a user's password is checked by one of the directory verifiers
+func (s *AuthService) isDirectoryUser(ctx context.Context, userID uuid.UUID) (bool, error) {
+	if len(s.credentialPolicy.Verifiers) == 0 {
+		return false, nil
+	}
+
+	identities, err := s.externalIdentityRepo.GetAllByUserID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, identity := range identities {
+		for _, verifier := range s.credentialPolicy.Verifiers {
+			if identity.Provider == verifier.Name() {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Checks whether a local account may sign in with its local password
+func (s *AuthService) localLoginAllowed(ctx context.Context, user *models.User) (bool, error) {
+	if len(s.credentialPolicy.LocalLoginRoles) == 0 {
+		return true, nil
+	}
+
+	roles, err := s.roleRepo.GetByUserID(ctx, user.UserID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if containsString(s.credentialPolicy.LocalLoginRoles, role) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,264 @@
+// services/credentials_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// fakeVerifier is a directory behind the CredentialVerifier interface. Logins it has no
+// account for are not handled, so the next verifier or the local password is tried.
+type fakeVerifier struct {
+	name        string
+	accounts    map[string]fakeDirectoryAccount
+	unavailable bool
+	calls       int
+}
+
+// fakeDirectoryAccount is an account in a fakeVerifier
+type fakeDirectoryAccount struct {
+	password    string
+	credentials VerifiedCredentials
+}
+
+func (v *fakeVerifier) Name() string {
+	return v.name
+}
+
+func (v *fakeVerifier) Verify(ctx context.Context, login, password string) (*VerifiedCredentials, error) {
+	v.calls++
+	if v.unavailable {
+		return nil, ErrDirectoryUnavailable
+	}
+	account, ok := v.accounts[strings.ToLower(login)]
+	if !ok {
+		return nil, ErrCredentialsNotHandled
+	}
+	if password == "" || password != account.password {
+		return nil, ErrInvalidCredentials
+	}
+	credentials := account.credentials
+	return &credentials, nil
+}
+
+// Helper function to set up a service on a fresh database that checks passwords against a
+// fake directory holding bjensen, whose groups control the admin and auditor roles
+func newDirectoryTestService(t *testing.T, configure func(*CredentialPolicy)) (*AuthService, *fakeVerifier) {
+	t.Helper()
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+
+	directory := &fakeVerifier{
+		name: "ldap",
+		accounts: map[string]fakeDirectoryAccount{
+			"bjensen": {
+				password: "directory-password",
+				credentials: VerifiedCredentials{
+					Subject:      "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d",
+					Username:     "bjensen",
+					Email:        "bjensen@example.com",
+					FirstName:    "Barbara",
+					LastName:     "Jensen",
+					Roles:        []string{"auditor"},
+					ManagedRoles: []string{"admin", "auditor"},
+				},
+			},
+		},
+	}
+	policy := NewCredentialPolicy()
+	policy.Verifiers = []CredentialVerifier{directory}
+	if configure != nil {
+		configure(&policy)
+	}
+	s.SetCredentialPolicy(policy)
+	return s, directory
+}
+
+// Helper function to log in from a fixed address
+func directoryTestLogin(s *AuthService, login, password string) (*LoginResult, error) {
+	return s.Login(context.Background(), LoginRequest{
+		UsernameOrEmail: login,
+		Password:        password,
+		IPAddress:       "192.0.2.1",
+		UserAgent:       "credentials-test",
+	})
+}
+
+// Helper function to create a local account with roles
+func createLocalTestUser(t *testing.T, s *AuthService, username, password string, roles ...string) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	user := &models.User{Username: username, Email: username + "@example.com", IsEmailVerified: true, IsActive: true}
+	if err := s.userRepo.Create(ctx, user, password); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	for _, role := range roles {
+		if err := s.roleRepo.Grant(ctx, user.UserID, role); err != nil {
+			t.Fatalf("Grant: %v", err)
+		}
+	}
+	return user
+}
+
+// Helper function to read a user's roles as a comma-separated list
+func userRoles(t *testing.T, s *AuthService, user *models.User) string {
+	t.Helper()
+	roles, err := s.roleRepo.GetByUserID(context.Background(), user.UserID)
+	if err != nil {
+		t.Fatalf("GetByUserID: %v", err)
+	}
+	return strings.Join(roles, ",")
+}
+
+func TestDirectoryLoginProvisionsUser(t *testing.T) {
+	s, _ := newDirectoryTestService(t, nil)
+	ctx := context.Background()
+
+	result, err := directoryTestLogin(s, "bjensen", "directory-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	user, err := s.userRepo.GetByID(ctx, result.Session.UserID)
+	if err != nil || user == nil {
+		t.Fatalf("GetByID: %v, %v", user, err)
+	}
+	if user.Username != "bjensen" || user.Email != "bjensen@example.com" || user.FirstName != "Barbara" || !user.IsEmailVerified {
+		t.Errorf("provisioned user = %+v, want the directory's details", user)
+	}
+	if roles := userRoles(t, s, user); roles != "auditor" {
+		t.Errorf("roles = %q, want auditor", roles)
+	}
+
+	if _, err := directoryTestLogin(s, "bjensen", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestDirectoryLoginWithoutAutoProvision(t *testing.T) {
+	s, _ := newDirectoryTestService(t, func(policy *CredentialPolicy) { policy.AutoProvision = false })
+	if _, err := directoryTestLogin(s, "bjensen", "directory-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestDirectoryLoginDoesNotTakeOverLocalEmail(t *testing.T) {
+	s, _ := newDirectoryTestService(t, nil)
+	local := &models.User{Username: "barbara", Email: "bjensen@example.com", IsEmailVerified: true, IsActive: true}
+	if err := s.userRepo.Create(context.Background(), local, "local-Password-123"); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+
+	// The login matches no local username or email, so the directory is asked
+	if _, err := directoryTestLogin(s, "bjensen", "directory-password"); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("err = %v, want ErrEmailAlreadyExists", err)
+	}
+}
+
+func TestDirectoryLoginSyncsManagedRoles(t *testing.T) {
+	s, directory := newDirectoryTestService(t, nil)
+
+	result, err := directoryTestLogin(s, "bjensen", "directory-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	user := &models.User{UserID: result.Session.UserID}
+
+	// Roles the directory does not manage are left alone
+	if err := s.roleRepo.Grant(context.Background(), user.UserID, "support"); err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+
+	// Moving between groups in the directory moves the roles on the next login
+	account := directory.accounts["bjensen"]
+	account.credentials.Roles = []string{"admin"}
+	account.credentials.LastName = "Jensen-Smith"
+	directory.accounts["bjensen"] = account
+
+	if _, err := directoryTestLogin(s, "bjensen", "directory-password"); err != nil {
+		t.Fatalf("second Login: %v", err)
+	}
+	if roles := userRoles(t, s, user); roles != "admin,support" {
+		t.Errorf("roles = %q, want admin,support", roles)
+	}
+	synced, err := s.userRepo.GetByID(context.Background(), user.UserID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+	if synced.LastName != "Jensen-Smith" {
+		t.Errorf("LastName = %q, want the directory's new name", synced.LastName)
+	}
+
+	// Leaving every managed group takes every managed role away
+	account.credentials.Roles = nil
+	directory.accounts["bjensen"] = account
+	if _, err := directoryTestLogin(s, "bjensen", "directory-password"); err != nil {
+		t.Fatalf("third Login: %v", err)
+	}
+	if roles := userRoles(t, s, user); roles != "support" {
+		t.Errorf("roles = %q, want support", roles)
+	}
+}
+
+func TestDirectoryUserCannotUseLocalPassword(t *testing.T) {
+	s, directory := newDirectoryTestService(t, nil)
+	ctx := context.Background()
+
+	result, err := directoryTestLogin(s, "bjensen", "directory-password")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	// Even a local password set on the account is ignored while the directory owns it
+	if err := s.userRepo.UpdatePassword(ctx, result.Session.UserID, "local-Password-123", 0); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	if _, err := directoryTestLogin(s, "bjensen", "local-Password-123"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("local password: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	directory.unavailable = true
+	if _, err := directoryTestLogin(s, "bjensen", "directory-password"); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Errorf("directory down: err = %v, want ErrDirectoryUnavailable", err)
+	}
+}
+
+func TestLocalLoginRolesBreakGlass(t *testing.T) {
+	s, directory := newDirectoryTestService(t, func(policy *CredentialPolicy) {
+		policy.LocalLoginRoles = []string{models.RoleAdmin}
+	})
+	createLocalTestUser(t, s, "breakglass", "local-Password-123", models.RoleAdmin)
+	createLocalTestUser(t, s, "localuser", "local-Password-123")
+
+	// The admin keeps a local login even when the directory is down
+	directory.unavailable = true
+	if _, err := directoryTestLogin(s, "breakglass", "local-Password-123"); err != nil {
+		t.Errorf("break-glass admin: %v", err)
+	}
+	if _, err := directoryTestLogin(s, "breakglass", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("break-glass admin, wrong password: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	// Other local accounts lose their local login, right password or not
+	if _, err := directoryTestLogin(s, "localuser", "local-Password-123"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("local user: err = %v, want ErrInvalidCredentials", err)
+	}
+	if directory.calls != 0 {
+		t.Errorf("directory called %d times, want local accounts never sent to it", directory.calls)
+	}
+}
+
+func TestLocalLoginWithoutRestriction(t *testing.T) {
+	s, directory := newDirectoryTestService(t, nil)
+	createLocalTestUser(t, s, "localuser", "local-Password-123")
+
+	if _, err := directoryTestLogin(s, "localuser", "local-Password-123"); err != nil {
+		t.Errorf("local user: %v", err)
+	}
+	if directory.calls != 0 {
+		t.Errorf("directory called %d times, want 0", directory.calls)
+	}
+}
@@ -0,0 +1,167 @@
+// services/devices.go
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// ListDevices retrieves the devices a user has signed in from
+func (s *AuthService) ListDevices(ctx context.Context, userID uuid.UUID) ([]*models.UserDevice, error) {
+	return s.deviceRepo.GetAllByUserID(ctx, userID)
+}
+
+// TrustDevice marks one of the user's devices as theirs
+func (s *AuthService) TrustDevice(ctx context.Context, userID, deviceID uuid.UUID, ipAddress, userAgent string) error {
+	device, err := s.getOwnDevice(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.deviceRepo.UpdateStatus(ctx, device.DeviceID, models.DeviceStatusTrusted); err != nil {
+		return err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "device_trusted",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   map[string]interface{}{"device_id": device.DeviceID.String()},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// ReportDevice marks one of the user's devices as not theirs and signs it out everywhere.
+// The device's token is burned, so a later login from it counts as a new device.
+func (s *AuthService) ReportDevice(ctx context.Context, userID, deviceID uuid.UUID, ipAddress, userAgent string) error {
+	device, err := s.getOwnDevice(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.deviceRepo.UpdateStatus(ctx, device.DeviceID, models.DeviceStatusReported); err != nil {
+		return err
+	}
+
+	revoked, err := s.sessionRepo.InvalidateAllForDevice(ctx, device.DeviceID)
+	if err != nil {
+		return err
+	}
+	s.metrics.SessionsRevoked(sessionRevokedDevice, int(revoked))
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "device_reported",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"device_id":        device.DeviceID.String(),
+			"sessions_revoked": revoked,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// Finds the device a login came from, registering a new one if it is not recognised
+func (s *AuthService) recognizeDevice(ctx context.Context, user *models.User, req LoginRequest) (*models.UserDevice, string, bool, error) {
+	info := ParseUserAgent(req.UserAgent)
+	token := req.DeviceToken
+
+	if token != "" {
+		device, err := s.deviceRepo.GetByTokenHash(ctx, user.UserID, hashToken(token))
+		if err != nil {
+			return nil, "", false, err
+		}
+
+		if device != nil && device.Status != models.DeviceStatusReported {
+			device.Browser = info.Browser
+			device.OS = info.OS
+			device.DeviceType = info.DeviceType
+			device.LastIP = req.IPAddress
+			if err := s.deviceRepo.RecordSeen(ctx, device); err != nil {
+				return nil, "", false, err
+			}
+			return device, token, false, nil
+		}
+
+		// A reported device's token must not be reused
+		if device != nil {
+			token = ""
+		}
+	}
+
+	// Browsers shared between accounts keep one device token for all of them
+	if token == "" {
+		var err error
+		if token, err = generateSecureToken(32); err != nil {
+			return nil, "", false, err
+		}
+	}
+
+	device := &models.UserDevice{
+		UserID:     user.UserID,
+		TokenHash:  hashToken(token),
+		Browser:    info.Browser,
+		OS:         info.OS,
+		DeviceType: info.DeviceType,
+		LastIP:     req.IPAddress,
+	}
+	if err := s.deviceRepo.Create(ctx, device); err != nil {
+		return nil, "", false, err
+	}
+
+	return device, token, true, nil
+}
+
+// Records a sign-in from a new device and emails the user about it
+func (s *AuthService) handleNewDeviceLogin(ctx context.Context, user *models.User, device *models.UserDevice, ipAddress, userAgent string) {
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "new_device_login",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"device_id":   device.DeviceID.String(),
+			"browser":     device.Browser,
+			"os":          device.OS,
+			"device_type": device.DeviceType,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	go func(email string, device models.UserDevice) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.emailService.SendNewDeviceEmail(ctx, email, &device); err != nil {
+			slog.ErrorContext(ctx, "Error sending new device email", "error", err)
+		}
+	}(user.Email, *device)
+}
+
+// Looks up a device and checks it belongs to the user
+func (s *AuthService) getOwnDevice(ctx context.Context, userID, deviceID uuid.UUID) (*models.UserDevice, error) {
+	device, err := s.deviceRepo.GetByID(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil || device.UserID != userID {
+		return nil, ErrDeviceNotFound
+	}
+	return device, nil
+}
@@ -0,0 +1,120 @@
+// services/devices_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to log in as bjensen from a device, identified by its device token
+func deviceTestLogin(t *testing.T, s *AuthService, deviceToken string) *LoginResult {
+	t.Helper()
+	login, err := s.Login(context.Background(), LoginRequest{
+		UsernameOrEmail: "bjensen",
+		Password:        "correct-Horse-battery-9-staple",
+		IPAddress:       "192.0.2.1",
+		UserAgent:       "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+		DeviceToken:     deviceToken,
+	})
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return login
+}
+
+// Helper function to count a user's audit events of one type
+func countAuditEvents(t *testing.T, s *AuthService, user *models.User, eventType string) int {
+	t.Helper()
+	var count int
+	query := `SELECT COUNT(*) FROM auth.audit_log WHERE user_id = $1 AND event_type = $2`
+	if err := s.pool.QueryRow(context.Background(), query, user.UserID, eventType).Scan(&count); err != nil {
+		t.Fatalf("counting audit events: %v", err)
+	}
+	return count
+}
+
+func TestLoginRecognizesDevices(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+
+	first := deviceTestLogin(t, s, "")
+	if !first.NewDevice || first.DeviceToken == "" {
+		t.Fatalf("first login: new device %v, token %q; want a new device with a token", first.NewDevice, first.DeviceToken)
+	}
+	if first.Device.Browser != "Firefox 125" || first.Device.OS != "Linux" || first.Device.DeviceType != DeviceTypeDesktop {
+		t.Errorf("device = %s on %s (%s), want the parsed user agent", first.Device.Browser, first.Device.OS, first.Device.DeviceType)
+	}
+	if first.Session.DeviceID == nil || *first.Session.DeviceID != first.Device.DeviceID {
+		t.Errorf("session device = %v, want %v", first.Session.DeviceID, first.Device.DeviceID)
+	}
+
+	again := deviceTestLogin(t, s, first.DeviceToken)
+	if again.NewDevice || again.Device.DeviceID != first.Device.DeviceID || again.DeviceToken != first.DeviceToken {
+		t.Errorf("second login: new device %v, device %v; want the first device again", again.NewDevice, again.Device.DeviceID)
+	}
+
+	unknown := deviceTestLogin(t, s, "token-from-nowhere")
+	if !unknown.NewDevice || unknown.Device.DeviceID == first.Device.DeviceID {
+		t.Errorf("unknown token: new device %v, want a new device", unknown.NewDevice)
+	}
+
+	// The very first login is not reported, the one from the unknown token is
+	if got := countAuditEvents(t, s, user, "new_device_login"); got != 1 {
+		t.Errorf("%d new_device_login events, want 1", got)
+	}
+}
+
+func TestReportDeviceRevokesItsSessions(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	ctx := context.Background()
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	other := createLocalTestUser(t, s, "alice", "correct-Horse-battery-9-staple")
+
+	reported := deviceTestLogin(t, s, "")
+	kept := deviceTestLogin(t, s, "")
+
+	// Nobody can trust or report someone else's device
+	if err := s.ReportDevice(ctx, other.UserID, reported.Device.DeviceID, "", ""); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("ReportDevice by another user: err = %v, want ErrDeviceNotFound", err)
+	}
+	if err := s.TrustDevice(ctx, other.UserID, reported.Device.DeviceID, "", ""); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("TrustDevice by another user: err = %v, want ErrDeviceNotFound", err)
+	}
+
+	if err := s.TrustDevice(ctx, user.UserID, kept.Device.DeviceID, "", ""); err != nil {
+		t.Fatalf("TrustDevice: %v", err)
+	}
+	if err := s.ReportDevice(ctx, user.UserID, reported.Device.DeviceID, "", ""); err != nil {
+		t.Fatalf("ReportDevice: %v", err)
+	}
+
+	if _, _, err := s.ValidateSession(ctx, reported.Session.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("session on the reported device: err = %v, want ErrInvalidToken", err)
+	}
+	if _, _, err := s.ValidateSession(ctx, kept.Session.Token); err != nil {
+		t.Errorf("session on the trusted device: %v", err)
+	}
+
+	devices, err := s.ListDevices(ctx, user.UserID)
+	if err != nil {
+		t.Fatalf("ListDevices: %v", err)
+	}
+	statuses := make(map[string]string)
+	for _, device := range devices {
+		statuses[device.DeviceID.String()] = device.Status
+	}
+	if statuses[reported.Device.DeviceID.String()] != models.DeviceStatusReported || statuses[kept.Device.DeviceID.String()] != models.DeviceStatusTrusted {
+		t.Errorf("device statuses = %v, want one reported and one trusted", statuses)
+	}
+
+	// The reported device's token is burned, so signing in with it counts as a new device
+	relogin := deviceTestLogin(t, s, reported.DeviceToken)
+	if !relogin.NewDevice || relogin.DeviceToken == reported.DeviceToken {
+		t.Errorf("login from the reported device: new device %v, same token %v; want a new device and token",
+			relogin.NewDevice, relogin.DeviceToken == reported.DeviceToken)
+	}
+}
@@ -0,0 +1,206 @@
+// services/email.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// EmailSender delivers a single plain-text email
+type EmailSender interface {
+	SendEmail(ctx context.Context, to, subject, body string) error
+}
+
+// SMTPConfig holds SMTP connection settings
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPEmailSender sends email through an SMTP server
+type SMTPEmailSender struct {
+	config SMTPConfig
+}
+
+// NewSMTPEmailSender creates a new SMTPEmailSender
+func NewSMTPEmailSender(config SMTPConfig) *SMTPEmailSender {
+	return &SMTPEmailSender{config: config}
+}
+
+// SendEmail sends an email through the configured SMTP server
+func (s *SMTPEmailSender) SendEmail(ctx context.Context, to, subject, body string) error {
+	addr := net.JoinHostPort(s.config.Host, strconv.Itoa(s.config.Port))
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	message := strings.Join([]string{
+		"From: " + s.config.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	// net/smtp has no context support, so run the send in the background and honour cancellation
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, s.config.From, []string{to}, []byte(message))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogEmailSender writes emails to the log instead of sending them, for development
+type LogEmailSender struct{}
+
+// SendEmail logs the email
+func (LogEmailSender) SendEmail(ctx context.Context, to, subject, body string) error {
+	slog.InfoContext(ctx, "Email not sent, logging it instead", "to", to, "subject", subject, "body", body)
+	return nil
+}
+
+// EmailService composes and sends account notification emails
+type EmailService struct {
+	sender  EmailSender
+	appName string
+	baseURL string
+}
+
+// NewEmailService creates a new EmailService
+func NewEmailService(sender EmailSender, appName, baseURL string) *EmailService {
+	return &EmailService{
+		sender:  sender,
+		appName: appName,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// SendAccountLockedEmail tells a user their account was locked after repeated failed logins
+func (s *EmailService) SendAccountLockedEmail(ctx context.Context, to string, lockedUntil time.Time) error {
+	subject := fmt.Sprintf("%s: your account has been temporarily locked", s.appName)
+	body := fmt.Sprintf(
+		"We locked your %s account after several failed sign-in attempts.\n\n"+
+			"You can try again after %s.\n\n"+
+			"If this wasn't you, someone may be trying to guess your password. "+
+			"Consider resetting it at %s/forgot-password.\n",
+		s.appName, lockedUntil.UTC().Format(time.RFC1123), s.baseURL,
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
+
+// SendNewDeviceEmail tells a user about a sign-in from a device we have not seen before
+func (s *EmailService) SendNewDeviceEmail(ctx context.Context, to string, device *models.UserDevice) error {
+	subject := fmt.Sprintf("%s: new sign-in from %s on %s", s.appName, device.Browser, device.OS)
+	body := fmt.Sprintf(
+		"Your %s account was just signed in to from a new device.\n\n"+
+			"Device: %s on %s (%s)\n"+
+			"IP address: %s\n"+
+			"Time: %s\n\n"+
+			"If this was you, you can mark the device as yours at %s/account/devices.\n"+
+			"If it wasn't, report the device there to sign it out, and change your password.\n",
+		s.appName, device.Browser, device.OS, device.DeviceType, device.LastIP,
+		device.FirstSeenAt.UTC().Format(time.RFC1123), s.baseURL,
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
+
+// SendLoginConfirmationEmail asks a user to confirm a sign-in that looked unusual
+func (s *EmailService) SendLoginConfirmationEmail(ctx context.Context, to, approvalToken, ipAddress string, location GeoLocation, expiresAt time.Time) error {
+	where := "an unknown location"
+	switch {
+	case location.City != "" && location.CountryCode != "":
+		where = location.City + ", " + location.CountryCode
+	case location.CountryCode != "":
+		where = location.CountryCode
+	}
+
+	subject := fmt.Sprintf("%s: confirm your sign-in", s.appName)
+	body := fmt.Sprintf(
+		"Someone signed in to your %s account from %s (IP address %s) with your password, "+
+			"but the sign-in looked unusual so we have held it back.\n\n"+
+			"If this was you, confirm it before %s at:\n%s/login/approve?token=%s\n\n"+
+			"If it wasn't, ignore this email and change your password at %s/account/security.\n",
+		s.appName, where, ipAddress, expiresAt.UTC().Format(time.RFC1123),
+		s.baseURL, url.QueryEscape(approvalToken), s.baseURL,
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
+
+// SendSessionsEvictedEmail tells a user that older sessions were signed out to make room for a new sign-in
+func (s *EmailService) SendSessionsEvictedEmail(ctx context.Context, to string, evicted []*models.Session, newSession *models.Session) error {
+	var lines []string
+	for _, session := range evicted {
+		lines = append(lines, fmt.Sprintf("- %s on %s (IP address %s), last active %s",
+			session.DeviceBrowser, session.DeviceOS, session.IPAddress,
+			session.LastActiveAt.UTC().Format(time.RFC1123)))
+	}
+
+	subject := fmt.Sprintf("%s: you were signed out on another device", s.appName)
+	body := fmt.Sprintf(
+		"Your %s account reached its limit of active sessions when you signed in on %s on %s "+
+			"(IP address %s), so we signed out the least recently used:\n\n%s\n\n"+
+			"If you don't recognise the new sign-in, change your password at %s/account/security.\n",
+		s.appName, newSession.DeviceBrowser, newSession.DeviceOS, newSession.IPAddress,
+		strings.Join(lines, "\n"), s.baseURL,
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
+
+// SendOrganizationInvitationEmail invites someone to join an organization
+func (s *EmailService) SendOrganizationInvitationEmail(ctx context.Context, to, orgName, inviterName, role, token string, expiresAt time.Time) error {
+	subject := fmt.Sprintf("%s: %s invited you to join %s", s.appName, inviterName, orgName)
+	body := fmt.Sprintf(
+		"%s invited you to join %s on %s as %s.\n\n"+
+			"To accept, sign in or create an account before %s at:\n%s/invitations/accept?token=%s\n\n"+
+			"If you weren't expecting this invitation, you can ignore this email.\n",
+		inviterName, orgName, s.appName, role, expiresAt.UTC().Format(time.RFC1123),
+		s.baseURL, url.QueryEscape(token),
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
+
+// SendAccountDeletionScheduledEmail confirms a deletion request and explains how to cancel it
+func (s *EmailService) SendAccountDeletionScheduledEmail(ctx context.Context, to, cancelToken string, deleteAfter time.Time) error {
+	subject := fmt.Sprintf("%s: your account will be deleted", s.appName)
+	body := fmt.Sprintf(
+		"You asked for your %s account to be deleted. It will be deleted, along with everything we hold about you, on %s.\n\n"+
+			"Changed your mind? Cancel the deletion before then at:\n%s/account/deletion/cancel?token=%s\n\n"+
+			"If you didn't ask for this, cancel it and change your password at %s/account/security.\n",
+		s.appName, deleteAfter.UTC().Format(time.RFC1123),
+		s.baseURL, url.QueryEscape(cancelToken), s.baseURL,
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
+
+// SendAccountDeletedEmail tells a user their account has been deleted
+func (s *EmailService) SendAccountDeletedEmail(ctx context.Context, to string) error {
+	subject := fmt.Sprintf("%s: your account has been deleted", s.appName)
+	body := fmt.Sprintf(
+		"Your %s account has been deleted as you asked. This is the last email we will send you.\n",
+		s.appName,
+	)
+	return s.sender.SendEmail(ctx, to, subject, body)
+}
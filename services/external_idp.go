@@ -0,0 +1,662 @@
+// services/external_idp.go
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrExternalProviderNotFound = errors.New("external identity provider not found")
+	ErrExternalLoginFailed      = errors.New("external sign-in failed")
+	ErrExternalEmailConflict    = errors.New("an account with this email already exists; sign in with it first")
+	ErrExternalAccountNotFound  = errors.New("no account is linked to this external identity")
+)
+
+// ExternalLoginTTL is how long a user has to finish signing in at an external provider
+const ExternalLoginTTL = 10 * time.Minute
+
+// How long a provider's discovery document and keys are used before being fetched again
+const (
+	externalDiscoveryTTL = 24 * time.Hour
+	externalKeysTTL      = time.Hour
+	externalKeysMinAge   = time.Minute // Unknown key IDs refetch the keys at most this often
+)
+
+// Allowed clock difference between us and the provider when checking ID token times
+const externalClockSkew = 2 * time.Minute
+
+var externalProviderNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Client for calls to external providers, so a slow provider cannot hold sign-ins forever
+var externalHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ExternalProviderConfig holds the settings for signing in with an external OpenID Connect provider
+type ExternalProviderConfig struct {
+	Name          string // Short identifier used in URLs and stored on linked identities, e.g. "google"
+	DisplayName   string // Shown on the sign-in button
+	Issuer        string // Provider's issuer URL; the discovery document is fetched from here
+	ClientID      string
+	ClientSecret  string   // Empty for providers that treat us as a public client
+	Scopes        []string // "openid" is always requested
+	RedirectURL   string   // Our callback URL, registered with the provider
+	AutoProvision bool     // Create an account on the first sign-in of an unknown user
+	LinkByEmail   bool     // Link to an existing account when both sides have verified the email
+}
+
+// NewExternalProviderConfig creates an external provider configuration with default values
+func NewExternalProviderConfig(name, issuer, clientID, clientSecret, redirectURL string) ExternalProviderConfig {
+	return ExternalProviderConfig{
+		Name:          name,
+		DisplayName:   name,
+		Issuer:        strings.TrimRight(issuer, "/"),
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		Scopes:        []string{OIDCScopeOpenID, OIDCScopeEmail, OIDCScopeProfile},
+		RedirectURL:   redirectURL,
+		AutoProvision: true,
+		LinkByEmail:   true,
+	}
+}
+
+// ExternalProviderInfo describes a configured provider to the frontend
+type ExternalProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Protocol    string `json:"protocol"` // "oidc" or "saml", which decides where sign-ins start
+}
+
+// An external provider and what we have fetched from it
+type externalProvider struct {
+	config ExternalProviderConfig
+
+	mu           sync.Mutex
+	discovery    *externalDiscovery
+	discoveredAt time.Time
+	keys         JSONWebKeySet
+	keysAt       time.Time
+}
+
+// The parts of a provider's discovery document we use
+type externalDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// AddExternalProvider enables signing in with an external provider. Nothing is fetched
+// from the provider until the first sign-in, so an unreachable provider does not stop startup.
+func (s *AuthService) AddExternalProvider(config ExternalProviderConfig) error {
+	if !externalProviderNamePattern.MatchString(config.Name) {
+		return fmt.Errorf("invalid external provider name %q", config.Name)
+	}
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return fmt.Errorf("external provider %s needs an issuer, client ID and redirect URL", config.Name)
+	}
+	if _, ok := s.samlProviders[config.Name]; ok {
+		return fmt.Errorf("provider name %q is already used by a SAML provider", config.Name)
+	}
+	if !containsString(config.Scopes, OIDCScopeOpenID) {
+		config.Scopes = append([]string{OIDCScopeOpenID}, config.Scopes...)
+	}
+
+	if s.externalProviders == nil {
+		s.externalProviders = make(map[string]*externalProvider)
+	}
+	s.externalProviders[config.Name] = &externalProvider{config: config}
+	return nil
+}
+
+// ExternalProviders lists the providers users can sign in with
+func (s *AuthService) ExternalProviders() []ExternalProviderInfo {
+	providers := []ExternalProviderInfo{}
+	for _, provider := range s.externalProviders {
+		providers = append(providers, ExternalProviderInfo{Name: provider.config.Name, DisplayName: provider.config.DisplayName, Protocol: "oidc"})
+	}
+	for _, provider := range s.samlProviders {
+		providers = append(providers, ExternalProviderInfo{Name: provider.config.Name, DisplayName: provider.config.DisplayName, Protocol: "saml"})
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	return providers
+}
+
+// StartExternalLogin begins a sign-in with an external provider. It returns the provider URL
+// to send the browser to and the state, which the client must keep and present again with the
+// callback so that a callback started in another browser is rejected.
+func (s *AuthService) StartExternalLogin(ctx context.Context, providerName, returnTo string, rememberMe bool) (string, string, error) {
+	provider, ok := s.externalProviders[providerName]
+	if !ok {
+		return "", "", ErrExternalProviderNotFound
+	}
+
+	discovery, err := provider.getDiscovery(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := generatePrefixedToken("")
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := generatePrefixedToken("")
+	if err != nil {
+		return "", "", err
+	}
+	codeVerifier, err := generatePrefixedToken("")
+	if err != nil {
+		return "", "", err
+	}
+
+	login := &models.ExternalLogin{
+		StateHash:    hashToken(state),
+		Provider:     provider.config.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ReturnTo:     SafeReturnTo(returnTo),
+		RememberMe:   rememberMe,
+		ExpiresAt:    time.Now().Add(ExternalLoginTTL),
+	}
+	if err := s.externalIdentityRepo.CreateLogin(ctx, login); err != nil {
+		return "", "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.config.ClientID},
+		"redirect_uri":          {provider.config.RedirectURL},
+		"scope":                 {strings.Join(provider.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), state, nil
+}
+
+// ExternalLoginCallback holds what the provider sent back to our callback
+type ExternalLoginCallback struct {
+	Provider      string
+	State         string // From the callback URL
+	ExpectedState string // Kept by the client since StartExternalLogin
+	Code          string
+	Error         string // Set by the provider if the user did not sign in
+	IPAddress     string
+	UserAgent     string
+	DeviceToken   string
+}
+
+// CompleteExternalLogin finishes a sign-in with an external provider. The user is found by
+// their linked identity, linked by verified email, or provisioned, and then goes through the
+// same device and risk checks as a password login. It also returns the local path to send
+// the user to afterwards.
+func (s *AuthService) CompleteExternalLogin(ctx context.Context, req ExternalLoginCallback) (_ *LoginResult, _ string, err error) {
+	defer func() { s.recordLoginAttempt(loginMethodExternal, err) }()
+
+	provider, ok := s.externalProviders[req.Provider]
+	if !ok {
+		return nil, "", ErrExternalProviderNotFound
+	}
+
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(req.State), []byte(req.ExpectedState)) != 1 {
+		return nil, "", fmt.Errorf("%w: state does not match this browser", ErrExternalLoginFailed)
+	}
+	login, err := s.externalIdentityRepo.ConsumeLogin(ctx, hashToken(req.State))
+	if err != nil {
+		return nil, "", err
+	}
+	if login == nil || login.Provider != provider.config.Name {
+		return nil, "", fmt.Errorf("%w: unknown or expired state", ErrExternalLoginFailed)
+	}
+	if req.Error != "" {
+		return nil, login.ReturnTo, fmt.Errorf("%w: provider returned %s", ErrExternalLoginFailed, req.Error)
+	}
+
+	claims, err := provider.exchangeCode(ctx, req.Code, login)
+	if err != nil {
+		return nil, login.ReturnTo, err
+	}
+
+	user, identity, err := s.resolveExternalUser(ctx, provider, claims, req.IPAddress, req.UserAgent)
+	if err != nil {
+		return nil, login.ReturnTo, err
+	}
+
+	if !user.IsActive {
+		return nil, login.ReturnTo, fmt.Errorf("%w: account is disabled", ErrExternalLoginFailed)
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, login.ReturnTo, ErrUserLocked
+	}
+
+	if err := s.externalIdentityRepo.RecordLogin(ctx, identity.IdentityID, claimString(claims, "email")); err != nil {
+		// Just log this error, don't fail the login
+		slog.ErrorContext(ctx, "Error recording external identity login", "error", err)
+	}
+
+	result, err := s.continueLogin(ctx, user, LoginRequest{
+		IPAddress:   req.IPAddress,
+		UserAgent:   req.UserAgent,
+		RememberMe:  login.RememberMe,
+		DeviceToken: req.DeviceToken,
+	}, provider.config.Name)
+	return result, login.ReturnTo, err
+}
+
+// ListExternalIdentities retrieves the external identities linked to a user
+func (s *AuthService) ListExternalIdentities(ctx context.Context, userID uuid.UUID) ([]*models.ExternalIdentity, error) {
+	return s.externalIdentityRepo.GetAllByUserID(ctx, userID)
+}
+
+// CleanupExpiredExternalLogins removes sign-ins whose provider never called back
+func (s *AuthService) CleanupExpiredExternalLogins(ctx context.Context) (int64, error) {
+	return s.externalIdentityRepo.DeleteExpiredLogins(ctx, time.Now())
+}
+
+// SafeReturnTo limits where users are sent after signing in to paths on this site,
+// so the sign-in flow cannot be used as an open redirect
+func SafeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.ContainsAny(returnTo, "\\\r\n") {
+		return "/"
+	}
+	return returnTo
+}
+
+// Finds the user for an external identity, linking or provisioning one on first sign-in
+func (s *AuthService) resolveExternalUser(ctx context.Context, provider *externalProvider, claims map[string]interface{}, ipAddress, userAgent string) (*models.User, *models.ExternalIdentity, error) {
+	subject := claimString(claims, "sub")
+
+	identity, err := s.externalIdentityRepo.GetByProviderSubject(ctx, provider.config.Name, subject)
+	if err != nil {
+		return nil, nil, err
+	}
+	if identity != nil {
+		user, err := s.userRepo.GetByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if user == nil {
+			return nil, nil, ErrUserNotFound
+		}
+		return user, identity, nil
+	}
+
+	email := claimString(claims, "email")
+	emailVerified := claimBool(claims, "email_verified")
+
+	var user *models.User
+	if email != "" {
+		if user, err = s.userRepo.GetByEmail(ctx, email); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if user != nil {
+		// Only take over an existing account if both we and the provider know the email is theirs
+		if !provider.config.LinkByEmail || !emailVerified || !user.IsEmailVerified {
+			return nil, nil, ErrExternalEmailConflict
+		}
+	} else {
+		if !provider.config.AutoProvision {
+			return nil, nil, ErrExternalAccountNotFound
+		}
+		if email == "" {
+			return nil, nil, fmt.Errorf("%w: provider did not share an email address", ErrExternalLoginFailed)
+		}
+		if user, err = s.provisionExternalUser(ctx, provider, claims, email, emailVerified, ipAddress, userAgent); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	identity = &models.ExternalIdentity{
+		UserID:   user.UserID,
+		Provider: provider.config.Name,
+		Subject:  subject,
+		Email:    email,
+	}
+	if err := s.externalIdentityRepo.Create(ctx, identity); err != nil {
+		return nil, nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "external_identity_linked",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider":    provider.config.Name,
+			"subject":     subject,
+			"email":       email,
+			"identity_id": identity.IdentityID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return user, identity, nil
+}
+
+// Creates an account for someone signing in with an external provider for the first time
+func (s *AuthService) provisionExternalUser(ctx context.Context, provider *externalProvider, claims map[string]interface{}, email string, emailVerified bool, ipAddress, userAgent string) (*models.User, error) {
+	username, err := s.availableUsername(ctx, claimString(claims, "preferred_username"), email)
+	if err != nil {
+		return nil, err
+	}
+
+	// The account can only be signed in to through the provider until the user sets a password
+	password, err := generateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Username:        username,
+		Email:           email,
+		FirstName:       claimString(claims, "given_name"),
+		LastName:        claimString(claims, "family_name"),
+		IsEmailVerified: emailVerified,
+		IsActive:        true,
+	}
+	if err := s.userRepo.Create(ctx, user, password); err != nil {
+		return nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "user_provisioned",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider": provider.config.Name,
+			"username": username,
+			"email":    email,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return user, nil
+}
+
+// Picks an unused username based on the provider's suggestion or the email address
+func (s *AuthService) availableUsername(ctx context.Context, preferred, email string) (string, error) {
+	base := sanitizeUsername(preferred)
+	if base == "" {
+		local, _, _ := strings.Cut(email, "@")
+		base = sanitizeUsername(local)
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 10; attempt++ {
+		existing, err := s.userRepo.GetByUsername(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+
+		var suffix [4]byte
+		if _, err := rand.Read(suffix[:]); err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%d", base, binary.BigEndian.Uint32(suffix[:])%10000)
+	}
+	return "", ErrUsernameAlreadyExists
+}
+
+// Returns the discovery document, fetching it if it is missing or old
+func (p *externalProvider) getDiscovery(ctx context.Context) (*externalDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil && time.Since(p.discoveredAt) < externalDiscoveryTTL {
+		return p.discovery, nil
+	}
+
+	var discovery externalDiscovery
+	if err := fetchJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("error fetching discovery document for %s: %w", p.config.Name, err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("discovery document for %s has issuer %q", p.config.Name, discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document for %s is missing endpoints", p.config.Name)
+	}
+
+	p.discovery = &discovery
+	p.discoveredAt = time.Now()
+	return p.discovery, nil
+}
+
+// Returns the provider's signing keys, fetching them if they are old or refresh is set
+func (p *externalProvider) getKeys(ctx context.Context, refresh bool) (JSONWebKeySet, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return JSONWebKeySet{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	age := time.Since(p.keysAt)
+	if len(p.keys.Keys) > 0 && age < externalKeysTTL && (!refresh || age < externalKeysMinAge) {
+		return p.keys, nil
+	}
+
+	var keys JSONWebKeySet
+	if err := fetchJSON(ctx, discovery.JWKSURI, &keys); err != nil {
+		return JSONWebKeySet{}, fmt.Errorf("error fetching signing keys for %s: %w", p.config.Name, err)
+	}
+
+	p.keys = keys
+	p.keysAt = time.Now()
+	return p.keys, nil
+}
+
+// Redeems the authorization code and returns the verified ID token claims
+func (p *externalProvider) exchangeCode(ctx context.Context, code string, login *models.ExternalLogin) (map[string]interface{}, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {login.CodeVerifier},
+	}
+	// Use HTTP Basic unless the provider says it only accepts credentials in the form
+	useBasic := p.config.ClientSecret != "" &&
+		(len(discovery.TokenAuthMethods) == 0 || containsString(discovery.TokenAuthMethods, "client_secret_basic"))
+	if !useBasic {
+		form.Set("client_id", p.config.ClientID)
+		if p.config.ClientSecret != "" {
+			form.Set("client_secret", p.config.ClientSecret)
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if useBasic {
+		httpReq.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := externalHTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling token endpoint of %s: %w", p.config.Name, err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("%w: unreadable token response (HTTP %d)", ErrExternalLoginFailed, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: token endpoint returned %q %s", ErrExternalLoginFailed, tokens.Error, tokens.ErrorDescription)
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken, login.Nonce)
+}
+
+// Checks an ID token's signature and claims
+func (p *externalProvider) verifyIDToken(ctx context.Context, idToken, nonce string) (map[string]interface{}, error) {
+	keys, err := p.getKeys(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := VerifyJWT(idToken, keys)
+	if errors.Is(err, ErrUnknownJWTKey) {
+		// The provider may have rotated its keys since we fetched them
+		if keys, err = p.getKeys(ctx, true); err != nil {
+			return nil, err
+		}
+		claims, err = VerifyJWT(idToken, keys)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExternalLoginFailed, err)
+	}
+
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: ID token %s", ErrExternalLoginFailed, reason)
+	}
+
+	if strings.TrimRight(claimString(claims, "iss"), "/") != p.config.Issuer {
+		return nil, invalid("has the wrong issuer")
+	}
+	audiences := claimStrings(claims, "aud")
+	if !containsString(audiences, p.config.ClientID) {
+		return nil, invalid("is for another client")
+	}
+	if azp := claimString(claims, "azp"); (len(audiences) > 1 || azp != "") && azp != p.config.ClientID {
+		return nil, invalid("was issued to another client")
+	}
+
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(externalClockSkew)) {
+		return nil, invalid("has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(externalClockSkew)) {
+		return nil, invalid("was issued in the future")
+	}
+	if subtle.ConstantTimeCompare([]byte(claimString(claims, "nonce")), []byte(nonce)) != 1 {
+		return nil, invalid("has the wrong nonce")
+	}
+	if claimString(claims, "sub") == "" {
+		return nil, invalid("has no subject")
+	}
+
+	return claims, nil
+}
+
+// Helper function to GET a JSON document from a provider
+func fetchJSON(ctx context.Context, endpoint string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := externalHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d from %s", resp.StatusCode, endpoint)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
+
+// Helper function to read a string claim
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// Helper function to read a claim that may be a string or a list of strings, like "aud"
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Helper function to read a boolean claim; some providers send "true" as a string
+func claimBool(claims map[string]interface{}, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true"
+	}
+	return false
+}
+
+// Helper function to turn a suggested name into a plain username
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	username := strings.Trim(b.String(), "._-")
+	if len(username) > 32 {
+		username = username[:32]
+	}
+	return username
+}
@@ -0,0 +1,507 @@
+// services/external_idp_test.go
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+const (
+	mockIdPClientID     = "our-client"
+	mockIdPClientSecret = "our-secret"
+	mockIdPRedirectURL  = "https://auth.example.com/api/auth/external/mock/callback"
+	mockIdPCode         = "valid-code"
+)
+
+// mockIdP is an OpenID Connect provider serving discovery, keys and a token endpoint.
+// Its token endpoint answers mockIdPCode with an ID token built from the fields below.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *SigningKey
+
+	issuer    string                 // Issuer in the discovery document; the server URL by default
+	signer    *SigningKey            // Key the ID token is signed with; key by default
+	nonce     string                 // Nonce put in the ID token
+	challenge string                 // PKCE challenge the code verifier must match, if set
+	claims    map[string]interface{} // Replace the default claims; nil values remove them
+	tamper    bool                   // Change the ID token's claims after signing it
+}
+
+// Helper function to start a mock provider that is stopped when the test ends
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	idp := &mockIdP{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := idp.issuer
+		if issuer == "" {
+			issuer = idp.server.URL
+		}
+		writeMockJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                 issuer,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJSON(w, http.StatusOK, JSONWebKeySet{Keys: []JSONWebKey{idp.key.PublicJWK()}})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// Helper function to answer a token request like a provider would
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if r.Method != http.MethodPost || clientID != mockIdPClientID || clientSecret != mockIdPClientSecret {
+		writeMockJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != mockIdPCode ||
+		r.PostFormValue("redirect_uri") != mockIdPRedirectURL {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if idp.challenge != "" && base64.RawURLEncoding.EncodeToString(sum[:]) != idp.challenge {
+		writeMockJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   idp.server.URL,
+		"aud":   mockIdPClientID,
+		"sub":   "idp-user-1",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": idp.nonce,
+	}
+	for name, value := range idp.claims {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+
+	signer := idp.signer
+	if signer == nil {
+		signer = idp.key
+	}
+	idToken, err := signer.Sign(claims)
+	if err != nil {
+		idp.t.Errorf("signing ID token: %v", err)
+		writeMockJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	if idp.tamper {
+		parts := strings.Split(idToken, ".")
+		claims["sub"] = "someone-else"
+		payload, _ := json.Marshal(claims)
+		parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+		idToken = strings.Join(parts, ".")
+	}
+
+	writeMockJSON(w, http.StatusOK, map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+}
+
+// Helper function to configure a provider named "mock" that signs in through the mock IdP
+func (idp *mockIdP) providerConfig() ExternalProviderConfig {
+	return NewExternalProviderConfig("mock", idp.server.URL, mockIdPClientID, mockIdPClientSecret, mockIdPRedirectURL)
+}
+
+// Helper function to write a JSON response from the mock provider
+func writeMockJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestExternalIDTokenVerification(t *testing.T) {
+	now := time.Now()
+	otherKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		setup   func(idp *mockIdP)
+		code    string
+		wantErr bool
+	}{
+		{name: "valid", setup: func(idp *mockIdP) {}},
+		{name: "issuer with trailing slash", setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"iss": idp.server.URL + "/"}
+		}},
+		{name: "several audiences with azp", setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"aud": []string{mockIdPClientID, "other"}, "azp": mockIdPClientID}
+		}},
+		{name: "wrong nonce", wantErr: true, setup: func(idp *mockIdP) {
+			idp.nonce = "someone-elses-nonce"
+		}},
+		{name: "missing nonce", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"nonce": nil}
+		}},
+		{name: "wrong issuer", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"iss": "https://evil.example.com"}
+		}},
+		{name: "wrong audience", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"aud": "another-client"}
+		}},
+		{name: "several audiences without azp", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"aud": []string{mockIdPClientID, "other"}}
+		}},
+		{name: "expired", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}
+		}},
+		{name: "issued in the future", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"iat": now.Add(time.Hour).Unix()}
+		}},
+		{name: "no subject", wantErr: true, setup: func(idp *mockIdP) {
+			idp.claims = map[string]interface{}{"sub": nil}
+		}},
+		{name: "signed with an unknown key", wantErr: true, setup: func(idp *mockIdP) {
+			idp.signer = otherKey
+		}},
+		{name: "bad signature", wantErr: true, setup: func(idp *mockIdP) {
+			idp.tamper = true
+		}},
+		{name: "code refused by the provider", wantErr: true, code: "stolen-code", setup: func(idp *mockIdP) {}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newMockIdP(t)
+			idp.nonce = "our-nonce"
+			idp.challenge = pkceChallenge("our-verifier")
+			tt.setup(idp)
+
+			code := tt.code
+			if code == "" {
+				code = mockIdPCode
+			}
+			provider := &externalProvider{config: idp.providerConfig()}
+			claims, err := provider.exchangeCode(context.Background(), code, &models.ExternalLogin{Nonce: "our-nonce", CodeVerifier: "our-verifier"})
+			if tt.wantErr {
+				if !errors.Is(err, ErrExternalLoginFailed) {
+					t.Fatalf("err = %v, want ErrExternalLoginFailed", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("exchangeCode: %v", err)
+			}
+			if claims["sub"] != "idp-user-1" {
+				t.Errorf("sub = %v, want idp-user-1", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestExternalDiscoveryChecksIssuer(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.issuer = "https://evil.example.com"
+
+	provider := &externalProvider{config: idp.providerConfig()}
+	if _, err := provider.getDiscovery(context.Background()); err == nil || !strings.Contains(err.Error(), "issuer") {
+		t.Errorf("err = %v, want an issuer mismatch", err)
+	}
+}
+
+func TestExternalLoginRejectsStateMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	s := NewAuthService(nil, "test-secret", 60)
+	if err := s.AddExternalProvider(idp.providerConfig()); err != nil {
+		t.Fatalf("AddExternalProvider: %v", err)
+	}
+
+	// These fail before the state is looked up, so the service needs no database
+	tests := []struct {
+		name, state, expected string
+	}{
+		{"different browser", "state-a", "state-b"},
+		{"no state kept", "state-a", ""},
+		{"no state returned", "", "state-b"},
+		{"neither", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.CompleteExternalLogin(context.Background(), ExternalLoginCallback{
+				Provider:      "mock",
+				State:         tt.state,
+				ExpectedState: tt.expected,
+				Code:          mockIdPCode,
+			})
+			if !errors.Is(err, ErrExternalLoginFailed) {
+				t.Errorf("err = %v, want ErrExternalLoginFailed", err)
+			}
+		})
+	}
+
+	if _, _, err := s.CompleteExternalLogin(context.Background(), ExternalLoginCallback{Provider: "unknown"}); !errors.Is(err, ErrExternalProviderNotFound) {
+		t.Errorf("unknown provider: err = %v, want ErrExternalProviderNotFound", err)
+	}
+}
+
+func TestSafeReturnTo(t *testing.T) {
+	tests := map[string]string{
+		"/settings?tab=security":   "/settings?tab=security",
+		"":                         "/",
+		"https://evil.example.com": "/",
+		"//evil.example.com":       "/",
+		"/\\evil.example.com":      "/",
+		"/a\r\nLocation: x":        "/",
+	}
+	for returnTo, want := range tests {
+		if got := SafeReturnTo(returnTo); got != want {
+			t.Errorf("SafeReturnTo(%q) = %q, want %q", returnTo, got, want)
+		}
+	}
+}
+
+func TestSanitizeUsername(t *testing.T) {
+	tests := map[string]string{
+		"Barbara.Jensen":        "barbara.jensen",
+		"..b j@x!..":            "bjx",
+		"日本":                    "",
+		strings.Repeat("a", 40): strings.Repeat("a", 32),
+	}
+	for name, want := range tests {
+		if got := sanitizeUsername(name); got != want {
+			t.Errorf("sanitizeUsername(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// Helper function to set up a service on a fresh database that signs in through a mock IdP
+func newExternalLoginTestService(t *testing.T, configure func(*ExternalProviderConfig)) (*AuthService, *mockIdP) {
+	t.Helper()
+	pool := dbtest.New(t)
+	idp := newMockIdP(t)
+
+	s := NewAuthService(pool, "test-secret", 60)
+	config := idp.providerConfig()
+	if configure != nil {
+		configure(&config)
+	}
+	if err := s.AddExternalProvider(config); err != nil {
+		t.Fatalf("AddExternalProvider: %v", err)
+	}
+	return s, idp
+}
+
+// Helper function to sign in through the mock IdP, which vouches for the given claims
+func externalSignIn(t *testing.T, s *AuthService, idp *mockIdP, claims map[string]interface{}) (*LoginResult, error) {
+	t.Helper()
+	ctx := context.Background()
+
+	location, state, err := s.StartExternalLogin(ctx, "mock", "/settings", false)
+	if err != nil {
+		t.Fatalf("StartExternalLogin: %v", err)
+	}
+	authURL, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", location, err)
+	}
+	params := authURL.Query()
+	if !strings.HasPrefix(location, idp.server.URL+"/authorize?") || params.Get("state") != state ||
+		params.Get("client_id") != mockIdPClientID || params.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL = %s, want the provider's endpoint with our client, state and PKCE", location)
+	}
+
+	// The provider echoes the nonce and checks the verifier against the challenge
+	idp.nonce = params.Get("nonce")
+	idp.challenge = params.Get("code_challenge")
+	idp.claims = claims
+
+	result, returnTo, err := s.CompleteExternalLogin(ctx, ExternalLoginCallback{
+		Provider:      "mock",
+		State:         state,
+		ExpectedState: state,
+		Code:          mockIdPCode,
+		IPAddress:     "192.0.2.1",
+		UserAgent:     "external-idp-test",
+	})
+	if returnTo != "/settings" {
+		t.Errorf("returnTo = %q, want /settings", returnTo)
+	}
+	return result, err
+}
+
+// Helper function to create a local account
+func createExternalTestUser(t *testing.T, s *AuthService, username, email string, emailVerified bool) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: email, IsEmailVerified: emailVerified, IsActive: true}
+	if err := s.userRepo.Create(context.Background(), user, "correct-Horse-battery-9-staple"); err != nil {
+		t.Fatalf("creating user: %v", err)
+	}
+	return user
+}
+
+func TestExternalLoginProvisionsUser(t *testing.T) {
+	s, idp := newExternalLoginTestService(t, nil)
+	ctx := context.Background()
+
+	result, err := externalSignIn(t, s, idp, map[string]interface{}{
+		"email":              "New.User@example.com",
+		"email_verified":     true,
+		"preferred_username": "New.User",
+		"given_name":         "New",
+		"family_name":        "User",
+	})
+	if err != nil {
+		t.Fatalf("CompleteExternalLogin: %v", err)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, result.Session.UserID)
+	if err != nil || user == nil {
+		t.Fatalf("GetByID: %v, %v", user, err)
+	}
+	if user.Username != "new.user" || user.Email != "new.user@example.com" || !user.IsEmailVerified || user.FirstName != "New" || user.LastName != "User" {
+		t.Errorf("provisioned user = %+v, want the provider's details", user)
+	}
+
+	// The next sign-in finds the account by its linked identity, even if the email changed
+	again, err := externalSignIn(t, s, idp, map[string]interface{}{"email": "renamed@example.com", "email_verified": true})
+	if err != nil {
+		t.Fatalf("second CompleteExternalLogin: %v", err)
+	}
+	if again.Session.UserID != user.UserID {
+		t.Errorf("second sign-in went to user %s, want %s", again.Session.UserID, user.UserID)
+	}
+	identities, err := s.ListExternalIdentities(ctx, user.UserID)
+	if err != nil {
+		t.Fatalf("ListExternalIdentities: %v", err)
+	}
+	if len(identities) != 1 || identities[0].Provider != "mock" || identities[0].Subject != "idp-user-1" {
+		t.Errorf("identities = %+v, want one for mock/idp-user-1", identities)
+	}
+}
+
+func TestExternalLoginProvisioningNeedsEmailAndSetting(t *testing.T) {
+	s, idp := newExternalLoginTestService(t, nil)
+	if _, err := externalSignIn(t, s, idp, map[string]interface{}{"email": nil}); !errors.Is(err, ErrExternalLoginFailed) {
+		t.Errorf("no email: err = %v, want ErrExternalLoginFailed", err)
+	}
+
+	s, idp = newExternalLoginTestService(t, func(config *ExternalProviderConfig) { config.AutoProvision = false })
+	if _, err := externalSignIn(t, s, idp, map[string]interface{}{"email": "new@example.com", "email_verified": true}); !errors.Is(err, ErrExternalAccountNotFound) {
+		t.Errorf("provisioning off: err = %v, want ErrExternalAccountNotFound", err)
+	}
+}
+
+func TestExternalLoginLinksByVerifiedEmailOnly(t *testing.T) {
+	tests := []struct {
+		name             string
+		localVerified    bool
+		providerVerified interface{}
+		linkByEmail      bool
+		wantErr          error
+	}{
+		{"both verified", true, true, true, nil},
+		{"provider sends verified as a string", true, "true", true, nil},
+		{"provider has not verified", true, false, true, ErrExternalEmailConflict},
+		{"provider does not say", true, nil, true, ErrExternalEmailConflict},
+		{"we have not verified", false, true, true, ErrExternalEmailConflict},
+		{"linking by email is off", true, true, false, ErrExternalEmailConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, idp := newExternalLoginTestService(t, func(config *ExternalProviderConfig) { config.LinkByEmail = tt.linkByEmail })
+			existing := createExternalTestUser(t, s, "bjensen", "bjensen@example.com", tt.localVerified)
+
+			result, err := externalSignIn(t, s, idp, map[string]interface{}{
+				"email":          "BJensen@example.com",
+				"email_verified": tt.providerVerified,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+
+			identities, listErr := s.ListExternalIdentities(context.Background(), existing.UserID)
+			if listErr != nil {
+				t.Fatalf("ListExternalIdentities: %v", listErr)
+			}
+			if tt.wantErr != nil {
+				if len(identities) != 0 {
+					t.Errorf("identities = %+v, want the account left unlinked", identities)
+				}
+				return
+			}
+			if result.Session.UserID != existing.UserID || len(identities) != 1 {
+				t.Errorf("signed in as %s with %d identities, want %s linked", result.Session.UserID, len(identities), existing.UserID)
+			}
+		})
+	}
+}
+
+func TestExternalLoginStateIsSingleUse(t *testing.T) {
+	s, idp := newExternalLoginTestService(t, nil)
+	ctx := context.Background()
+
+	location, state, err := s.StartExternalLogin(ctx, "mock", "/", false)
+	if err != nil {
+		t.Fatalf("StartExternalLogin: %v", err)
+	}
+	authURL, _ := url.Parse(location)
+	idp.nonce = authURL.Query().Get("nonce")
+	idp.claims = map[string]interface{}{"email": "once@example.com", "email_verified": true}
+
+	callback := ExternalLoginCallback{Provider: "mock", State: state, ExpectedState: state, Code: mockIdPCode, IPAddress: "192.0.2.1"}
+	if _, _, err := s.CompleteExternalLogin(ctx, callback); err != nil {
+		t.Fatalf("CompleteExternalLogin: %v", err)
+	}
+	if _, _, err := s.CompleteExternalLogin(ctx, callback); !errors.Is(err, ErrExternalLoginFailed) {
+		t.Errorf("replayed callback: err = %v, want ErrExternalLoginFailed", err)
+	}
+}
+
+func TestExternalLoginRejectsWrongNonce(t *testing.T) {
+	s, idp := newExternalLoginTestService(t, nil)
+	ctx := context.Background()
+
+	_, state, err := s.StartExternalLogin(ctx, "mock", "/", false)
+	if err != nil {
+		t.Fatalf("StartExternalLogin: %v", err)
+	}
+	// An ID token minted for another sign-in attempt
+	idp.nonce = "nonce-of-another-login"
+	idp.claims = map[string]interface{}{"email": "victim@example.com", "email_verified": true}
+
+	_, _, err = s.CompleteExternalLogin(ctx, ExternalLoginCallback{Provider: "mock", State: state, ExpectedState: state, Code: mockIdPCode})
+	if !errors.Is(err, ErrExternalLoginFailed) {
+		t.Fatalf("err = %v, want ErrExternalLoginFailed", err)
+	}
+	if user, _ := s.userRepo.GetByEmail(ctx, "victim@example.com"); user != nil {
+		t.Error("an account was provisioned from a rejected ID token")
+	}
+}
+
+// Helper function to compute the S256 PKCE challenge for a verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
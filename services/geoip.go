@@ -0,0 +1,117 @@
+// services/geoip.go
+package services
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoLocation is what we know about where an IP address is
+type GeoLocation struct {
+	CountryCode string   `json:"country_code,omitempty"`
+	City        string   `json:"city,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	ASN         uint     `json:"asn,omitempty"`
+	ASOrg       string   `json:"as_org,omitempty"`
+}
+
+// HasCoordinates reports whether the location includes a latitude and longitude
+func (l GeoLocation) HasCoordinates() bool {
+	return l.Latitude != nil && l.Longitude != nil
+}
+
+// GeoIP looks up IP addresses in local MaxMind-format (.mmdb) databases,
+// e.g. GeoLite2-City and GeoLite2-ASN. Either database may be omitted.
+type GeoIP struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Subset of the GeoIP2/GeoLite2 City and Country record layout
+type mmdbCityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Subset of the GeoLite2 ASN record layout
+type mmdbASNRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// OpenGeoIP opens the city (or country) and ASN databases; empty paths are skipped
+func OpenGeoIP(cityDBPath, asnDBPath string) (*GeoIP, error) {
+	g := &GeoIP{}
+
+	if cityDBPath != "" {
+		reader, err := maxminddb.Open(cityDBPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening GeoIP city database: %w", err)
+		}
+		g.city = reader
+	}
+
+	if asnDBPath != "" {
+		reader, err := maxminddb.Open(asnDBPath)
+		if err != nil {
+			g.Close()
+			return nil, fmt.Errorf("error opening GeoIP ASN database: %w", err)
+		}
+		g.asn = reader
+	}
+
+	return g, nil
+}
+
+// Lookup finds the location of an IP address. Unknown or unparseable addresses give an empty location.
+func (g *GeoIP) Lookup(ipAddress string) (GeoLocation, error) {
+	var location GeoLocation
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return location, nil
+	}
+
+	if g.city != nil {
+		var record mmdbCityRecord
+		if err := g.city.Lookup(ip, &record); err != nil {
+			return location, err
+		}
+		location.CountryCode = record.Country.ISOCode
+		location.City = record.City.Names["en"]
+		location.Latitude = record.Location.Latitude
+		location.Longitude = record.Location.Longitude
+	}
+
+	if g.asn != nil {
+		var record mmdbASNRecord
+		if err := g.asn.Lookup(ip, &record); err != nil {
+			return location, err
+		}
+		location.ASN = record.Number
+		location.ASOrg = record.Organization
+	}
+
+	return location, nil
+}
+
+// Close closes the databases
+func (g *GeoIP) Close() {
+	if g.city != nil {
+		g.city.Close()
+	}
+	if g.asn != nil {
+		g.asn.Close()
+	}
+}
@@ -0,0 +1,227 @@
+// services/impersonation.go
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrImpersonationNotAllowed = errors.New("this user cannot be impersonated")
+	ErrImpersonationRestricted = errors.New("this action is not allowed while impersonating a user")
+	ErrNotImpersonating        = errors.New("session is not impersonating a user")
+)
+
+// Reasons an impersonation session ended, as recorded in the audit log
+const (
+	ImpersonationEndedByAdmin = "ended"
+	ImpersonationEndedLogout  = "logout"
+	ImpersonationEndedExpired = "expired"
+)
+
+// ImpersonationPolicy controls sessions admins start to act as another user
+type ImpersonationPolicy struct {
+	TTL time.Duration // How long an impersonation session lasts; activity does not extend it
+}
+
+// NewImpersonationPolicy creates an impersonation policy with default values
+func NewImpersonationPolicy() ImpersonationPolicy {
+	return ImpersonationPolicy{
+		TTL: 15 * time.Minute,
+	}
+}
+
+// SetImpersonationPolicy replaces the default impersonation policy
+func (s *AuthService) SetImpersonationPolicy(policy ImpersonationPolicy) {
+	s.impersonationPolicy = policy
+}
+
+// StartImpersonation creates a session for an admin to act as another user. The session
+// records the admin and their own session, lasts no longer than the policy TTL or the admin's
+// session, and is restricted from sensitive actions. Admins cannot be impersonated, and an
+// impersonation session cannot start another.
+func (s *AuthService) StartImpersonation(ctx context.Context, adminSession *models.Session, targetID uuid.UUID, reason, ipAddress, userAgent string) (*models.Session, *models.User, error) {
+	if adminSession.ImpersonatorID != nil {
+		return nil, nil, ErrImpersonationRestricted
+	}
+	if targetID == adminSession.UserID {
+		return nil, nil, ErrImpersonationNotAllowed
+	}
+
+	target, err := s.userRepo.GetByID(ctx, targetID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if target == nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if !target.IsActive {
+		return nil, nil, ErrImpersonationNotAllowed
+	}
+	isAdmin, err := s.roleRepo.HasRole(ctx, targetID, models.RoleAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isAdmin {
+		return nil, nil, ErrImpersonationNotAllowed
+	}
+
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	expiresAt := time.Now().Add(s.impersonationPolicy.TTL)
+	if adminSession.AbsoluteExpiresAt.Before(expiresAt) {
+		expiresAt = adminSession.AbsoluteExpiresAt
+	}
+	info := ParseUserAgent(userAgent)
+	session := &models.Session{
+		UserID:                targetID,
+		Token:                 token,
+		IPAddress:             ipAddress,
+		UserAgent:             userAgent,
+		ExpiresAt:             expiresAt,
+		AbsoluteExpiresAt:     expiresAt,
+		IsValid:               true,
+		DeviceBrowser:         info.Browser,
+		DeviceOS:              info.OS,
+		DeviceType:            info.DeviceType,
+		ImpersonatorID:        &adminSession.UserID,
+		ImpersonatorSessionID: &adminSession.SessionID,
+	}
+	if orgID, err := s.orgRepo.GetDefaultForUser(ctx, targetID); err != nil {
+		// Just log this error, the admin can pick an organization later
+		slog.ErrorContext(ctx, "Error choosing active organization", "error", err)
+	} else {
+		session.ActiveOrgID = orgID
+	}
+
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, nil, err
+	}
+	s.metrics.SessionsCreated(sessionKindImpersonation, 1)
+
+	s.auditImpersonation(ctx, session, "impersonation_started", ipAddress, userAgent, map[string]interface{}{
+		"reason":     reason,
+		"expires_at": expiresAt,
+	})
+
+	return session, target, nil
+}
+
+// EndImpersonation ends an impersonation session. It returns the admin's own session if it
+// is still valid, so they can be returned to it.
+func (s *AuthService) EndImpersonation(ctx context.Context, session *models.Session, reason, ipAddress, userAgent string) (*models.Session, error) {
+	if session.ImpersonatorID == nil {
+		return nil, ErrNotImpersonating
+	}
+
+	if err := s.revokeSession(ctx, session.Token, sessionRevokedImpersonationEnded); err != nil {
+		return nil, err
+	}
+
+	s.auditImpersonation(ctx, session, "impersonation_ended", ipAddress, userAgent, map[string]interface{}{
+		"reason": reason,
+	})
+
+	if session.ImpersonatorSessionID == nil {
+		return nil, nil
+	}
+	adminSession, err := s.sessionRepo.GetByID(ctx, *session.ImpersonatorSessionID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if adminSession == nil || !adminSession.IsValid || now.After(adminSession.ExpiresAt) || now.After(adminSession.AbsoluteExpiresAt) {
+		return nil, nil
+	}
+	return adminSession, nil
+}
+
+// AuditImpersonatedAction records a request made with an impersonation session: state-changing
+// requests once they have been handled, and requests refused because of the restrictions
+func (s *AuthService) AuditImpersonatedAction(ctx context.Context, session *models.Session, method, route string, status int, blocked bool, ipAddress, userAgent string) {
+	eventType := "impersonation_action"
+	if blocked {
+		eventType = "impersonation_action_blocked"
+	}
+	s.auditImpersonation(ctx, session, eventType, ipAddress, userAgent, map[string]interface{}{
+		"method": method,
+		"route":  route,
+		"status": status,
+	})
+}
+
+// EndExpiredImpersonations invalidates impersonation sessions that ran out without being
+// ended and records their end, before the expired session cleanup deletes them
+func (s *AuthService) EndExpiredImpersonations(ctx context.Context) (int, error) {
+	sessions, err := s.sessionRepo.InvalidateExpiredImpersonations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.metrics.SessionsRevoked(sessionRevokedImpersonationExpired, len(sessions))
+
+	for _, session := range sessions {
+		if s.sessionCache != nil {
+			s.sessionCache.Remove(session.Token)
+		}
+		s.auditImpersonation(ctx, session, "impersonation_ended", session.IPAddress, session.UserAgent, map[string]interface{}{
+			"reason": ImpersonationEndedExpired,
+		})
+	}
+
+	return len(sessions), nil
+}
+
+// Checks that the admin behind an impersonation session can still impersonate
+func (s *AuthService) checkImpersonator(ctx context.Context, session *models.Session) error {
+	impersonator, err := s.userRepo.GetByID(ctx, *session.ImpersonatorID)
+	if err != nil {
+		return err
+	}
+	if impersonator == nil || !impersonator.IsActive {
+		return ErrInvalidToken
+	}
+
+	isAdmin, err := s.roleRepo.HasRole(ctx, impersonator.UserID, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	if !isAdmin {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Records an impersonation event under the admin, naming the user they are acting as.
+// Starting and ending are also recorded under that user, so it shows in their own history.
+func (s *AuthService) auditImpersonation(ctx context.Context, session *models.Session, eventType, ipAddress, userAgent string, details map[string]interface{}) {
+	details["session_id"] = session.SessionID.String()
+	details["target_user_id"] = session.UserID.String()
+	details["impersonator_id"] = session.ImpersonatorID.String()
+
+	userIDs := []uuid.UUID{*session.ImpersonatorID}
+	if eventType == "impersonation_started" || eventType == "impersonation_ended" {
+		userIDs = append(userIDs, session.UserID)
+	}
+
+	for _, userID := range userIDs {
+		auditLog := &models.AuditLog{
+			UserID:    userID,
+			EventType: eventType,
+			IPAddress: ipAddress,
+			UserAgent: userAgent,
+			Details:   details,
+		}
+		if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+			slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+		}
+	}
+}
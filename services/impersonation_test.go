@@ -0,0 +1,179 @@
+// services/impersonation_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to set up a service with a logged in admin and a user they can impersonate
+func newImpersonationTestService(t *testing.T) (*AuthService, *LoginResult, *models.User) {
+	t.Helper()
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	policy := NewImpersonationPolicy()
+	policy.TTL = 15 * time.Minute
+	s.SetImpersonationPolicy(policy)
+
+	createLocalTestUser(t, s, "admin", "correct-Horse-battery-9-staple", models.RoleAdmin)
+	target := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	login, err := directoryTestLogin(s, "admin", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return s, login, target
+}
+
+func TestStartImpersonationRestrictions(t *testing.T) {
+	s, admin, _ := newImpersonationTestService(t)
+	ctx := context.Background()
+	otherAdmin := createLocalTestUser(t, s, "otheradmin", "correct-Horse-battery-9-staple", models.RoleAdmin)
+	inactive := createLocalTestUser(t, s, "inactive", "correct-Horse-battery-9-staple")
+	if _, err := s.pool.Exec(ctx, `UPDATE auth.users SET is_active = false WHERE user_id = $1`, inactive.UserID); err != nil {
+		t.Fatalf("deactivating user: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		targetID uuid.UUID
+		wantErr  error
+	}{
+		{"themselves", admin.Session.UserID, ErrImpersonationNotAllowed},
+		{"another admin", otherAdmin.UserID, ErrImpersonationNotAllowed},
+		{"inactive user", inactive.UserID, ErrImpersonationNotAllowed},
+		{"missing user", uuid.New(), ErrUserNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.StartImpersonation(ctx, admin.Session, tt.targetID, "testing", "", ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("StartImpersonation: err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestImpersonationSession(t *testing.T) {
+	s, admin, target := newImpersonationTestService(t)
+	ctx := context.Background()
+
+	session, user, err := s.StartImpersonation(ctx, admin.Session, target.UserID, "support ticket 42", "192.0.2.1", "impersonation-test")
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	if user.UserID != target.UserID || session.UserID != target.UserID {
+		t.Errorf("impersonating %v with a session for %v, want %v", user.UserID, session.UserID, target.UserID)
+	}
+	if session.ImpersonatorID == nil || *session.ImpersonatorID != admin.Session.UserID ||
+		session.ImpersonatorSessionID == nil || *session.ImpersonatorSessionID != admin.Session.SessionID {
+		t.Errorf("impersonator %v, session %v; want the admin and their session", session.ImpersonatorID, session.ImpersonatorSessionID)
+	}
+	if left := time.Until(session.AbsoluteExpiresAt); left > 15*time.Minute || left < 14*time.Minute {
+		t.Errorf("impersonation lasts %v, want the 15m TTL", left)
+	}
+	if !session.ExpiresAt.Equal(session.AbsoluteExpiresAt) {
+		t.Errorf("idle expiry %v, want the absolute expiry %v", session.ExpiresAt, session.AbsoluteExpiresAt)
+	}
+
+	validated, _, err := s.ValidateSession(ctx, session.Token)
+	if err != nil {
+		t.Fatalf("ValidateSession: %v", err)
+	}
+	if _, _, err := s.StartImpersonation(ctx, validated, admin.Session.UserID, "nested", "", ""); !errors.Is(err, ErrImpersonationRestricted) {
+		t.Errorf("impersonating from an impersonation session: err = %v, want ErrImpersonationRestricted", err)
+	}
+	if _, err := s.EndImpersonation(ctx, admin.Session, ImpersonationEndedByAdmin, "", ""); !errors.Is(err, ErrNotImpersonating) {
+		t.Errorf("EndImpersonation of the admin's own session: err = %v, want ErrNotImpersonating", err)
+	}
+
+	// Ending it invalidates the impersonation session and hands back the admin's own
+	back, err := s.EndImpersonation(ctx, validated, ImpersonationEndedByAdmin, "192.0.2.1", "impersonation-test")
+	if err != nil {
+		t.Fatalf("EndImpersonation: %v", err)
+	}
+	if back == nil || back.SessionID != admin.Session.SessionID {
+		t.Errorf("EndImpersonation returned %v, want the admin's session", back)
+	}
+	if _, _, err := s.ValidateSession(ctx, session.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateSession after ending: err = %v, want ErrInvalidToken", err)
+	}
+
+	// Starting and ending are recorded for both the admin and the user
+	adminUser := &models.User{UserID: admin.Session.UserID}
+	for _, user := range []*models.User{adminUser, target} {
+		for _, eventType := range []string{"impersonation_started", "impersonation_ended"} {
+			if got := countAuditEvents(t, s, user, eventType); got != 1 {
+				t.Errorf("%d %s events for %v, want 1", got, eventType, user.UserID)
+			}
+		}
+	}
+}
+
+func TestImpersonationCappedByAdminSession(t *testing.T) {
+	s, admin, target := newImpersonationTestService(t)
+	ctx := context.Background()
+	backdateSession(t, s, admin.Session, 0, 5*time.Minute, 5*time.Minute)
+	adminSession, _, err := s.ValidateSession(ctx, admin.Session.Token)
+	if err != nil {
+		t.Fatalf("ValidateSession: %v", err)
+	}
+
+	session, _, err := s.StartImpersonation(ctx, adminSession, target.UserID, "testing", "", "")
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	if !session.AbsoluteExpiresAt.Equal(adminSession.AbsoluteExpiresAt) {
+		t.Errorf("impersonation expires at %v, want the admin session's expiry %v", session.AbsoluteExpiresAt, adminSession.AbsoluteExpiresAt)
+	}
+}
+
+func TestImpersonationEndsWhenAdminLosesRole(t *testing.T) {
+	s, admin, target := newImpersonationTestService(t)
+	ctx := context.Background()
+
+	session, _, err := s.StartImpersonation(ctx, admin.Session, target.UserID, "testing", "", "")
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	if err := s.roleRepo.Revoke(ctx, admin.Session.UserID, models.RoleAdmin); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, _, err := s.ValidateSession(ctx, session.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("ValidateSession after the admin lost their role: err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestEndExpiredImpersonations(t *testing.T) {
+	s, admin, target := newImpersonationTestService(t)
+	ctx := context.Background()
+
+	session, _, err := s.StartImpersonation(ctx, admin.Session, target.UserID, "testing", "", "")
+	if err != nil {
+		t.Fatalf("StartImpersonation: %v", err)
+	}
+	backdateSession(t, s, session, time.Minute, -time.Minute, -time.Minute)
+
+	ended, err := s.EndExpiredImpersonations(ctx)
+	if err != nil {
+		t.Fatalf("EndExpiredImpersonations: %v", err)
+	}
+	if ended != 1 {
+		t.Errorf("ended %d impersonations, want 1", ended)
+	}
+	if got := countAuditEvents(t, s, target, "impersonation_ended"); got != 1 {
+		t.Errorf("%d impersonation_ended events, want 1", got)
+	}
+
+	// The admin's own session is untouched, and running it again finds nothing
+	if _, _, err := s.ValidateSession(ctx, admin.Session.Token); err != nil {
+		t.Errorf("admin session: %v", err)
+	}
+	if ended, err := s.EndExpiredImpersonations(ctx); err != nil || ended != 0 {
+		t.Errorf("EndExpiredImpersonations again = %d, %v; want 0", ended, err)
+	}
+}
@@ -0,0 +1,104 @@
+// services/ip_list.go
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// IPList is a set of IP addresses and CIDR ranges loaded from a file, such as a Tor exit
+// node list or a list of datacenter ranges. Blank lines and "#" comments are ignored.
+type IPList struct {
+	addresses map[string]struct{}
+	networks  []*net.IPNet
+}
+
+// LoadIPList reads an IP list file with one address or CIDR range per line
+func LoadIPList(path string) (*IPList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening IP list: %w", err)
+	}
+	defer file.Close()
+
+	list := &IPList{addresses: make(map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if err := list.add(line); err != nil {
+			return nil, fmt.Errorf("line %d of %s: %w", lineNumber, path, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// ParseIPList builds an IP list from addresses and CIDR ranges
+func ParseIPList(entries []string) (*IPList, error) {
+	list := &IPList{addresses: make(map[string]struct{})}
+	for _, entry := range entries {
+		if err := list.add(strings.TrimSpace(entry)); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// Helper function to add an address or CIDR range to the list
+func (l *IPList) add(entry string) error {
+	if strings.Contains(entry, "/") {
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		l.networks = append(l.networks, network)
+		return nil
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return fmt.Errorf("invalid IP address %q", entry)
+	}
+	l.addresses[ip.String()] = struct{}{}
+	return nil
+}
+
+// Contains reports whether the IP address is in the list
+func (l *IPList) Contains(ipAddress string) bool {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return false
+	}
+
+	if _, ok := l.addresses[ip.String()]; ok {
+		return true
+	}
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of addresses and ranges in the list
+func (l *IPList) Len() int {
+	return len(l.addresses) + len(l.networks)
+}
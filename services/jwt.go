@@ -0,0 +1,248 @@
+// services/jwt.go
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// JSONWebKey is a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA exponent
+	Crv string `json:"crv,omitempty"` // EC curve
+	X   string `json:"x,omitempty"`   // EC point
+	Y   string `json:"y,omitempty"`
+}
+
+// ErrInvalidJWT is returned when a JWT is malformed or its signature does not verify
+var ErrInvalidJWT = errors.New("invalid JWT")
+
+// ErrUnknownJWTKey is returned when no key in the set matches a JWT's key ID
+var ErrUnknownJWTKey = errors.New("JWT signed with an unknown key")
+
+// PublicKey decodes the key, which must be an RSA or P-256 EC key
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC point: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// JSONWebKeySet is a set of public keys, as served from a JWKS endpoint
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// SigningKey is the RSA key used to sign ID tokens with RS256
+type SigningKey struct {
+	key *rsa.PrivateKey
+	kid string
+}
+
+// NewSigningKey wraps an RSA private key. The key ID is derived from the public key,
+// so it stays the same across restarts and replicas that share the key.
+func NewSigningKey(key *rsa.PrivateKey) (*SigningKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+	return &SigningKey{key: key, kid: base64.RawURLEncoding.EncodeToString(sum[:12])}, nil
+}
+
+// LoadSigningKey reads an RSA private key from a PEM file (PKCS #1 or PKCS #8)
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key file is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigningKey(key)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key must be an RSA key")
+	}
+	return NewSigningKey(key)
+}
+
+// GenerateSigningKey creates a new random 2048-bit RSA signing key
+func GenerateSigningKey() (*SigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(key)
+}
+
+// KeyID returns the key's ID, sent as "kid" in token headers
+func (k *SigningKey) KeyID() string {
+	return k.kid
+}
+
+// PublicJWK returns the public half of the key for the JWKS endpoint
+func (k *SigningKey) PublicJWK() JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Kid: k.kid,
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(k.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.key.E)).Bytes()),
+	}
+}
+
+// Sign creates an RS256-signed JWT with the given claims
+func (k *SigningKey) Sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": k.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{signingInput, base64.RawURLEncoding.EncodeToString(signature)}, "."), nil
+}
+
+// VerifyJWT checks an RS256 or ES256 JWT against a key set and returns its claims.
+// Only the signature is checked; the caller must validate the claims.
+func VerifyJWT(token string, keys JSONWebKeySet) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidJWT
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidJWT
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrInvalidJWT
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidJWT
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	// Try every key that could have signed the token; without a kid that may be several
+	verified, matched := false, false
+	for _, jwk := range keys.Keys {
+		if (header.Kid != "" && jwk.Kid != header.Kid) || (jwk.Alg != "" && jwk.Alg != header.Alg) || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		publicKey, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		switch key := publicKey.(type) {
+		case *rsa.PublicKey:
+			if header.Alg != "RS256" {
+				continue
+			}
+			matched = true
+			verified = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+		case *ecdsa.PublicKey:
+			if header.Alg != "ES256" || len(signature) != 64 {
+				continue
+			}
+			matched = true
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+			verified = ecdsa.Verify(key, digest[:], r, s)
+		}
+		if verified {
+			break
+		}
+	}
+	if !matched {
+		return nil, ErrUnknownJWTKey
+	}
+	if !verified {
+		return nil, ErrInvalidJWT
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidJWT
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidJWT
+	}
+
+	return claims, nil
+}
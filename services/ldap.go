@@ -0,0 +1,281 @@
+// services/ldap.go
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig holds the settings for checking passwords against an LDAP or Active Directory server
+type LDAPConfig struct {
+	Name               string // Provider name stored on linked identities
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   // Upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify bool   // Accept any server certificate; only for testing
+	Timeout            time.Duration
+
+	// Service account used to find users; empty for an anonymous search
+	BindDN       string
+	BindPassword string
+
+	// Where and how to find a user; {login} is replaced with the escaped login
+	BaseDN     string
+	UserFilter string
+
+	// Attributes read from the user's entry. IDAttribute identifies the account across
+	// renames and moves (entryUUID, or objectGUID on Active Directory); empty uses the DN.
+	IDAttribute        string
+	UsernameAttribute  string
+	EmailAttribute     string
+	FirstNameAttribute string
+	LastNameAttribute  string
+	GroupAttribute     string // Lists the groups the user is in, e.g. memberOf
+
+	// Optional group search for servers without a memberOf attribute; {dn} is replaced
+	// with the escaped user DN
+	GroupBaseDN string
+	GroupFilter string
+
+	// Maps groups, by DN or common name (case-insensitive), to roles
+	GroupRoles map[string]string
+}
+
+// NewLDAPConfig creates an LDAP configuration with defaults suited to OpenLDAP
+func NewLDAPConfig(url, baseDN string) LDAPConfig {
+	return LDAPConfig{
+		Name:               "ldap",
+		URL:                url,
+		Timeout:            5 * time.Second,
+		BaseDN:             baseDN,
+		UserFilter:         "(&(objectClass=person)(|(uid={login})(mail={login})))",
+		IDAttribute:        "entryUUID",
+		UsernameAttribute:  "uid",
+		EmailAttribute:     "mail",
+		FirstNameAttribute: "givenName",
+		LastNameAttribute:  "sn",
+		GroupAttribute:     "memberOf",
+		GroupRoles:         make(map[string]string),
+	}
+}
+
+// LDAPVerifier checks passwords by binding to an LDAP server as the user
+type LDAPVerifier struct {
+	config LDAPConfig
+}
+
+// NewLDAPVerifier creates a CredentialVerifier for an LDAP server
+func NewLDAPVerifier(config LDAPConfig) (*LDAPVerifier, error) {
+	if config.URL == "" || config.BaseDN == "" {
+		return nil, errors.New("LDAP needs a URL and a base DN")
+	}
+	if !strings.Contains(config.UserFilter, "{login}") {
+		return nil, errors.New("LDAP user filter must contain {login}")
+	}
+	if config.GroupFilter != "" && !strings.Contains(config.GroupFilter, "{dn}") {
+		return nil, errors.New("LDAP group filter must contain {dn}")
+	}
+	return &LDAPVerifier{config: config}, nil
+}
+
+// Name returns the provider name of the identities this verifier checks
+func (v *LDAPVerifier) Name() string {
+	return v.config.Name
+}
+
+// Verify finds the user in the directory and binds as them with the password
+func (v *LDAPVerifier) Verify(ctx context.Context, login, password string) (*VerifiedCredentials, error) {
+	// An empty password would be an unauthenticated bind, which many servers accept
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := v.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Give up on the directory when the login request is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	entry, err := v.findUser(ctx, conn, login)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+
+	groups := entry.GetAttributeValues(v.config.GroupAttribute)
+	if v.config.GroupFilter != "" {
+		// Search as the service account, which can usually see more groups than the user
+		if err := v.bindServiceAccount(conn); err != nil {
+			return nil, err
+		}
+		more, err := v.findGroups(conn, entry.DN)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, more...)
+	}
+
+	credentials := &VerifiedCredentials{
+		Subject:   v.subject(entry),
+		Username:  entry.GetAttributeValue(v.config.UsernameAttribute),
+		Email:     entry.GetAttributeValue(v.config.EmailAttribute),
+		FirstName: entry.GetAttributeValue(v.config.FirstNameAttribute),
+		LastName:  entry.GetAttributeValue(v.config.LastNameAttribute),
+	}
+	for _, role := range v.config.GroupRoles {
+		if !containsString(credentials.ManagedRoles, role) {
+			credentials.ManagedRoles = append(credentials.ManagedRoles, role)
+		}
+	}
+	for _, group := range groups {
+		if role, ok := v.roleForGroup(group); ok && !containsString(credentials.Roles, role) {
+			credentials.Roles = append(credentials.Roles, role)
+		}
+	}
+
+	return credentials, nil
+}
+
+// Opens a connection, secured with StartTLS if configured, and binds as the service account
+func (v *LDAPVerifier) connect() (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: v.config.Timeout}
+	tlsConfig := &tls.Config{InsecureSkipVerify: v.config.InsecureSkipVerify}
+	if host, _, err := net.SplitHostPort(strings.TrimPrefix(strings.TrimPrefix(v.config.URL, "ldaps://"), "ldap://")); err == nil {
+		tlsConfig.ServerName = host
+	}
+
+	conn, err := ldap.DialURL(v.config.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+	conn.SetTimeout(v.config.Timeout)
+
+	if v.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+		}
+	}
+
+	if err := v.bindServiceAccount(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Binds as the service account, if there is one
+func (v *LDAPVerifier) bindServiceAccount(conn *ldap.Conn) error {
+	if v.config.BindDN == "" {
+		return nil
+	}
+	if err := conn.Bind(v.config.BindDN, v.config.BindPassword); err != nil {
+		return fmt.Errorf("%w: service account bind failed: %v", ErrDirectoryUnavailable, err)
+	}
+	return nil
+}
+
+// Looks up the single entry matching a login
+func (v *LDAPVerifier) findUser(ctx context.Context, conn *ldap.Conn, login string) (*ldap.Entry, error) {
+	attributes := []string{v.config.UsernameAttribute, v.config.EmailAttribute, v.config.FirstNameAttribute, v.config.LastNameAttribute}
+	if v.config.IDAttribute != "" {
+		attributes = append(attributes, v.config.IDAttribute)
+	}
+	if v.config.GroupAttribute != "" {
+		attributes = append(attributes, v.config.GroupAttribute)
+	}
+
+	request := ldap.NewSearchRequest(
+		v.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(v.config.Timeout.Seconds()), false,
+		strings.ReplaceAll(v.config.UserFilter, "{login}", ldap.EscapeFilter(login)),
+		attributes, nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%w: user search failed: %v", ErrDirectoryUnavailable, err)
+	}
+
+	switch {
+	case result == nil || len(result.Entries) == 0:
+		return nil, ErrCredentialsNotHandled
+	case len(result.Entries) > 1:
+		// Refuse to guess which account the password is for
+		slog.WarnContext(ctx, "LDAP user filter matched more than one entry", "login", login)
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+// Looks up the groups that list a user as a member
+func (v *LDAPVerifier) findGroups(conn *ldap.Conn, userDN string) ([]string, error) {
+	baseDN := v.config.GroupBaseDN
+	if baseDN == "" {
+		baseDN = v.config.BaseDN
+	}
+
+	request := ldap.NewSearchRequest(
+		baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(v.config.Timeout.Seconds()), false,
+		strings.ReplaceAll(v.config.GroupFilter, "{dn}", ldap.EscapeFilter(userDN)),
+		[]string{"dn"}, nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w: group search failed: %v", ErrDirectoryUnavailable, err)
+	}
+
+	var groups []string
+	for _, entry := range result.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// Returns the stable identifier of an entry; binary values such as objectGUID are hex encoded
+func (v *LDAPVerifier) subject(entry *ldap.Entry) string {
+	if v.config.IDAttribute == "" {
+		return strings.ToLower(entry.DN)
+	}
+	raw := entry.GetRawAttributeValue(v.config.IDAttribute)
+	if len(raw) == 0 {
+		return strings.ToLower(entry.DN)
+	}
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return hex.EncodeToString(raw)
+}
+
+// Maps a group DN to a role, matching the full DN or its common name
+func (v *LDAPVerifier) roleForGroup(groupDN string) (string, bool) {
+	commonName := ""
+	if parsed, err := ldap.ParseDN(groupDN); err == nil && len(parsed.RDNs) > 0 && len(parsed.RDNs[0].Attributes) > 0 {
+		commonName = parsed.RDNs[0].Attributes[0].Value
+	}
+
+	for group, role := range v.config.GroupRoles {
+		if strings.EqualFold(group, groupDN) || (commonName != "" && strings.EqualFold(group, commonName)) {
+			return role, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,468 @@
+// services/ldap_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeDirectoryEntry is an entry in a fakeLDAPServer; entries with a password can be bound to
+type fakeDirectoryEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLDAPServer is an in-process LDAP server that answers simple binds and searches with
+// equality, presence, and, or and not filters. Like many real servers it accepts a bind with
+// an empty password as an unauthenticated bind.
+type fakeLDAPServer struct {
+	t        *testing.T
+	listener net.Listener
+	entries  []fakeDirectoryEntry
+
+	mu          sync.Mutex
+	binds       []string // DNs bound as, in order
+	filters     []string // Search filters received, in order
+	connections int
+}
+
+// Helper function to start a fake LDAP server that is stopped when the test ends
+func newFakeLDAPServer(t *testing.T, entries ...fakeDirectoryEntry) *fakeLDAPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := &fakeLDAPServer{t: t, listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.connections++
+			server.mu.Unlock()
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// Helper function to return the server's ldap:// URL
+func (s *fakeLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Helper function to answer the requests on one connection until it is closed
+func (s *fakeLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			s.bind(conn, messageID, op)
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, messageID, op)
+		default: // Unbind, or anything this server does not support
+			return
+		}
+	}
+}
+
+// Helper function to answer a simple bind
+func (s *fakeLDAPServer) bind(conn net.Conn, messageID int64, op *ber.Packet) {
+	dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	s.mu.Unlock()
+
+	code := uint16(ldap.LDAPResultInvalidCredentials)
+	if password == "" {
+		code = ldap.LDAPResultSuccess
+	}
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.dn, dn) && entry.password != "" && entry.password == password {
+			code = ldap.LDAPResultSuccess
+		}
+	}
+	s.write(conn, messageID, ldapResult(ldap.ApplicationBindResponse, code))
+}
+
+// Helper function to answer a search with the entries under the base DN that match the filter
+func (s *fakeLDAPServer) search(conn net.Conn, messageID int64, op *ber.Packet) {
+	baseDN := op.Children[0].Data.String()
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	if decompiled, err := ldap.DecompileFilter(filter); err == nil {
+		s.mu.Lock()
+		s.filters = append(s.filters, decompiled)
+		s.mu.Unlock()
+	}
+
+	sent := 0
+	code := uint16(ldap.LDAPResultSuccess)
+	for _, entry := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.dn), strings.ToLower(baseDN)) || !matchesFilter(entry, filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(sent) == sizeLimit {
+			code = ldap.LDAPResultSizeLimitExceeded
+			break
+		}
+
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+		attributes := ber.NewSequence("Attributes")
+		for name, values := range entry.attributes {
+			attribute := ber.NewSequence("Attribute")
+			attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+			}
+			attribute.AppendChild(set)
+			attributes.AppendChild(attribute)
+		}
+		result.AppendChild(attributes)
+		s.write(conn, messageID, result)
+		sent++
+	}
+	s.write(conn, messageID, ldapResult(ldap.ApplicationSearchResultDone, code))
+}
+
+// Helper function to send one LDAP message
+func (s *fakeLDAPServer) write(conn net.Conn, messageID int64, op *ber.Packet) {
+	message := ber.NewSequence("LDAP Message")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	message.AppendChild(op)
+	if _, err := conn.Write(message.Bytes()); err != nil {
+		s.t.Logf("fake LDAP server: %v", err)
+	}
+}
+
+// Helper function to snapshot what the server has seen
+func (s *fakeLDAPServer) seen() (binds, filters []string, connections int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...), append([]string(nil), s.filters...), s.connections
+}
+
+// Helper function to build an LDAPResult-shaped response
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+// Helper function to evaluate a search filter against an entry. Attribute names and values
+// compare case-insensitively, as they do for uid and mail on real servers.
+func matchesFilter(entry fakeDirectoryEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchesFilter(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchesFilter(entry, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matchesFilter(entry, filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		want := filter.Children[1].Data.String()
+		for _, value := range entryValues(entry, filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, want) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(entryValues(entry, filter.Data.String())) > 0
+	}
+	return false // Substring and other filters are never matched
+}
+
+// Helper function to read an attribute of an entry by case-insensitive name
+func entryValues(entry fakeDirectoryEntry, name string) []string {
+	if strings.EqualFold(name, "dn") {
+		return []string{entry.dn}
+	}
+	for attribute, values := range entry.attributes {
+		if strings.EqualFold(attribute, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+const (
+	testLDAPBaseDN     = "dc=example,dc=com"
+	testLDAPServiceDN  = "cn=service,ou=system,dc=example,dc=com"
+	testLDAPServicePW  = "service-password"
+	testLDAPUserDN     = "uid=bjensen,ou=people,dc=example,dc=com"
+	testLDAPUserPW     = "directory-password"
+	testLDAPAdminsDN   = "cn=Admins,ou=groups,dc=example,dc=com"
+	testLDAPAuditorsDN = "cn=Auditors,ou=groups,dc=example,dc=com"
+)
+
+// Helper function to build a directory with a service account, a user in two groups,
+// and the group entries
+func testLDAPEntries() []fakeDirectoryEntry {
+	return []fakeDirectoryEntry{
+		{dn: testLDAPServiceDN, password: testLDAPServicePW},
+		{
+			dn:       testLDAPUserDN,
+			password: testLDAPUserPW,
+			attributes: map[string][]string{
+				"objectClass": {"person", "inetOrgPerson"},
+				"uid":         {"bjensen"},
+				"mail":        {"bjensen@example.com"},
+				"givenName":   {"Barbara"},
+				"sn":          {"Jensen"},
+				"entryUUID":   {"5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"},
+				"memberOf":    {testLDAPAdminsDN, "cn=Staff,ou=groups,dc=example,dc=com"},
+			},
+		},
+		{
+			dn:         testLDAPAdminsDN,
+			attributes: map[string][]string{"objectClass": {"groupOfNames"}, "member": {testLDAPUserDN}},
+		},
+		{
+			dn:         testLDAPAuditorsDN,
+			attributes: map[string][]string{"objectClass": {"groupOfNames"}, "member": {testLDAPUserDN}},
+		},
+	}
+}
+
+// Helper function to create a verifier for a fake server, with groups mapped to roles
+func newTestLDAPVerifier(t *testing.T, server *fakeLDAPServer, configure func(*LDAPConfig)) *LDAPVerifier {
+	t.Helper()
+	config := NewLDAPConfig(server.URL(), testLDAPBaseDN)
+	config.Timeout = 2 * time.Second
+	config.BindDN = testLDAPServiceDN
+	config.BindPassword = testLDAPServicePW
+	config.GroupRoles = map[string]string{"admins": "admin", testLDAPAuditorsDN: "auditor"}
+	if configure != nil {
+		configure(&config)
+	}
+	verifier, err := NewLDAPVerifier(config)
+	if err != nil {
+		t.Fatalf("NewLDAPVerifier: %v", err)
+	}
+	return verifier
+}
+
+func TestLDAPVerifyBindsAsUser(t *testing.T) {
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, nil)
+
+	credentials, err := verifier.Verify(context.Background(), "BJensen@example.com", testLDAPUserPW)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if credentials.Subject != "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d" || credentials.Username != "bjensen" ||
+		credentials.Email != "bjensen@example.com" || credentials.FirstName != "Barbara" || credentials.LastName != "Jensen" {
+		t.Errorf("credentials = %+v, want bjensen's details with entryUUID as the subject", credentials)
+	}
+
+	// Admins matches by common name; Staff maps to nothing and Auditors isn't in memberOf
+	if strings.Join(credentials.Roles, ",") != "admin" {
+		t.Errorf("Roles = %v, want [admin]", credentials.Roles)
+	}
+	if len(credentials.ManagedRoles) != 2 || !containsString(credentials.ManagedRoles, "admin") || !containsString(credentials.ManagedRoles, "auditor") {
+		t.Errorf("ManagedRoles = %v, want admin and auditor", credentials.ManagedRoles)
+	}
+
+	binds, _, _ := server.seen()
+	if strings.Join(binds, " | ") != testLDAPServiceDN+" | "+testLDAPUserDN {
+		t.Errorf("binds = %v, want the service account then the user", binds)
+	}
+}
+
+func TestLDAPVerifyWithGroupSearch(t *testing.T) {
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, func(config *LDAPConfig) {
+		config.GroupAttribute = ""
+		config.GroupBaseDN = "ou=groups," + testLDAPBaseDN
+		config.GroupFilter = "(&(objectClass=groupOfNames)(member={dn}))"
+	})
+
+	credentials, err := verifier.Verify(context.Background(), "bjensen", testLDAPUserPW)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if len(credentials.Roles) != 2 || !containsString(credentials.Roles, "admin") || !containsString(credentials.Roles, "auditor") {
+		t.Errorf("Roles = %v, want admin and auditor from the group search", credentials.Roles)
+	}
+
+	// The groups are searched for as the service account, not the user
+	binds, _, _ := server.seen()
+	if len(binds) != 3 || binds[2] != testLDAPServiceDN {
+		t.Errorf("binds = %v, want the service account to bind again before the group search", binds)
+	}
+}
+
+func TestLDAPVerifyRejectsWrongPassword(t *testing.T) {
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, nil)
+
+	if _, err := verifier.Verify(context.Background(), "bjensen", "wrong-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPVerifyRejectsEmptyPassword(t *testing.T) {
+	// The fake server, like many real ones, would accept this as an unauthenticated bind
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, nil)
+
+	for _, login := range []string{"bjensen", ""} {
+		if _, err := verifier.Verify(context.Background(), login, ""); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login %q: err = %v, want ErrInvalidCredentials", login, err)
+		}
+	}
+	if _, _, connections := server.seen(); connections != 0 {
+		t.Errorf("connections = %d, want the directory not to be contacted", connections)
+	}
+}
+
+func TestLDAPVerifyUnknownLoginIsNotHandled(t *testing.T) {
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, nil)
+
+	if _, err := verifier.Verify(context.Background(), "nobody", "password"); !errors.Is(err, ErrCredentialsNotHandled) {
+		t.Errorf("err = %v, want ErrCredentialsNotHandled", err)
+	}
+}
+
+func TestLDAPVerifyEscapesLogin(t *testing.T) {
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, nil)
+
+	// Unescaped, each of these would match bjensen, or every person in the directory
+	logins := []string{
+		"*",
+		"bjensen)(uid=*",
+		"*)(objectClass=*",
+		"bjensen)(|(uid=*",
+		`bjensen\`,
+	}
+	for _, login := range logins {
+		if _, err := verifier.Verify(context.Background(), login, testLDAPUserPW); !errors.Is(err, ErrCredentialsNotHandled) {
+			t.Errorf("login %q: err = %v, want ErrCredentialsNotHandled", login, err)
+		}
+	}
+
+	binds, filters, _ := server.seen()
+	for _, bind := range binds {
+		if bind != testLDAPServiceDN {
+			t.Errorf("bound as %s, want no user binds", bind)
+		}
+	}
+	if len(filters) != len(logins) || !strings.Contains(filters[0], `(uid=\2a)`) {
+		t.Errorf("filters = %v, want the login escaped as a literal value", filters)
+	}
+}
+
+func TestLDAPVerifyRefusesAmbiguousLogin(t *testing.T) {
+	entries := append(testLDAPEntries(), fakeDirectoryEntry{
+		dn:       "uid=bjensen2,ou=people,dc=example,dc=com",
+		password: testLDAPUserPW,
+		attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {"bjensen2"},
+			"mail":        {"bjensen@example.com"},
+		},
+	})
+	server := newFakeLDAPServer(t, entries...)
+	verifier := newTestLDAPVerifier(t, server, nil)
+
+	if _, err := verifier.Verify(context.Background(), "bjensen@example.com", testLDAPUserPW); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want ErrInvalidCredentials", err)
+	}
+	if binds, _, _ := server.seen(); len(binds) != 1 {
+		t.Errorf("binds = %v, want only the service account", binds)
+	}
+}
+
+func TestLDAPVerifyDirectoryUnavailable(t *testing.T) {
+	server := newFakeLDAPServer(t, testLDAPEntries()...)
+	verifier := newTestLDAPVerifier(t, server, func(config *LDAPConfig) { config.BindPassword = "wrong" })
+	if _, err := verifier.Verify(context.Background(), "bjensen", testLDAPUserPW); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Errorf("service account bind failed: err = %v, want ErrDirectoryUnavailable", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	closedURL := "ldap://" + listener.Addr().String()
+	listener.Close()
+
+	verifier, err = NewLDAPVerifier(NewLDAPConfig(closedURL, testLDAPBaseDN))
+	if err != nil {
+		t.Fatalf("NewLDAPVerifier: %v", err)
+	}
+	if _, err := verifier.Verify(context.Background(), "bjensen", testLDAPUserPW); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Errorf("server down: err = %v, want ErrDirectoryUnavailable", err)
+	}
+}
+
+func TestNewLDAPVerifierValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*LDAPConfig)
+	}{
+		{"no URL", func(config *LDAPConfig) { config.URL = "" }},
+		{"no base DN", func(config *LDAPConfig) { config.BaseDN = "" }},
+		{"user filter without {login}", func(config *LDAPConfig) { config.UserFilter = "(uid=admin)" }},
+		{"group filter without {dn}", func(config *LDAPConfig) { config.GroupFilter = "(objectClass=groupOfNames)" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := NewLDAPConfig("ldap://localhost:389", testLDAPBaseDN)
+			tt.configure(&config)
+			if _, err := NewLDAPVerifier(config); err == nil {
+				t.Error("NewLDAPVerifier succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestLDAPSubject(t *testing.T) {
+	verifier := &LDAPVerifier{config: LDAPConfig{IDAttribute: "objectGUID"}}
+
+	guid := string([]byte{0xd3, 0x8a, 0x00, 0xff})
+	if got := verifier.subject(ldap.NewEntry("CN=B Jensen,DC=example,DC=com", map[string][]string{"objectGUID": {guid}})); got != "d38a00ff" {
+		t.Errorf("binary ID: subject = %q, want hex", got)
+	}
+	if got := verifier.subject(ldap.NewEntry("CN=B Jensen,DC=example,DC=com", nil)); got != "cn=b jensen,dc=example,dc=com" {
+		t.Errorf("missing ID: subject = %q, want the lowercased DN", got)
+	}
+}
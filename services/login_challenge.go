@@ -0,0 +1,257 @@
+// services/login_challenge.go
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrLoginBlocked          = errors.New("login blocked because it looks too risky")
+	ErrLoginChallengePending = errors.New("login has not been confirmed yet")
+	ErrInvalidMFACode        = errors.New("invalid MFA code")
+)
+
+// MFAVerifier checks second-factor codes. Deployments with MFA plug one in with
+// SetMFAVerifier so risky logins can be confirmed with it instead of by email.
+type MFAVerifier interface {
+	IsEnrolled(ctx context.Context, userID uuid.UUID) (bool, error)
+	Verify(ctx context.Context, userID uuid.UUID, code string) (bool, error)
+}
+
+// LoginChallengeError is returned by Login when the password was correct but the login
+// looks risky, so it must be confirmed before a session is created
+type LoginChallengeError struct {
+	ChallengeID uuid.UUID
+	Secret      string // Proves the client completing the challenge is the one that started it
+	Method      string
+	ExpiresAt   time.Time
+	RiskScore   int
+	DeviceToken string // Device token the client should keep, as for a successful login
+	NewDevice   bool
+}
+
+func (e *LoginChallengeError) Error() string {
+	return fmt.Sprintf("login requires %s confirmation", e.Method)
+}
+
+// CompleteLoginChallengeRequest holds the details needed to finish a confirmed login
+type CompleteLoginChallengeRequest struct {
+	ChallengeID uuid.UUID
+	Secret      string
+	Code        string // MFA code, for MFA challenges
+	IPAddress   string
+	UserAgent   string
+}
+
+// ApproveLoginChallenge confirms a risky login using the token from the confirmation email
+func (s *AuthService) ApproveLoginChallenge(ctx context.Context, approvalToken, ipAddress, userAgent string) error {
+	challenge, err := s.challengeRepo.Approve(ctx, hashToken(approvalToken))
+	if err != nil {
+		return err
+	}
+	if challenge == nil {
+		return ErrInvalidToken
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    challenge.UserID,
+		EventType: "login_challenge_approved",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"challenge_id": challenge.ChallengeID.String(),
+			"login_ip":     challenge.IPAddress,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// CompleteLoginChallenge creates the session for a risky login once it has been confirmed
+func (s *AuthService) CompleteLoginChallenge(ctx context.Context, req CompleteLoginChallengeRequest) (_ *LoginResult, err error) {
+	defer func() { s.recordLoginAttempt(loginMethodChallenge, err) }()
+
+	challenge, err := s.challengeRepo.GetByID(ctx, req.ChallengeID)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil || challenge.CompletedAt != nil || time.Now().After(challenge.ExpiresAt) ||
+		subtle.ConstantTimeCompare([]byte(hashToken(req.Secret)), []byte(challenge.SecretHash)) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidToken
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, ErrUserLocked
+	}
+
+	switch challenge.Method {
+	case models.ChallengeMethodMFA:
+		if s.mfaVerifier == nil {
+			return nil, ErrInvalidToken
+		}
+		ok, err := s.mfaVerifier.Verify(ctx, user.UserID, req.Code)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// Wrong codes count towards the lockout just like wrong passwords
+			status, err := s.userRepo.IncrementFailedLoginAttempts(ctx, user.UserID, s.lockoutPolicy)
+			if err != nil {
+				return nil, err
+			}
+			if status.JustLocked {
+				s.handleAccountLocked(ctx, user, status, lockoutReasonMFACode, req.IPAddress, req.UserAgent)
+				return nil, ErrUserLocked
+			}
+			return nil, ErrInvalidMFACode
+		}
+	default:
+		if challenge.ApprovedAt == nil {
+			return nil, ErrLoginChallengePending
+		}
+	}
+
+	// Each challenge can only be used once
+	completed, err := s.challengeRepo.Complete(ctx, challenge.ChallengeID)
+	if err != nil {
+		return nil, err
+	}
+	if !completed {
+		return nil, ErrInvalidToken
+	}
+
+	var device *models.UserDevice
+	if challenge.DeviceID != nil {
+		if device, err = s.deviceRepo.GetByID(ctx, *challenge.DeviceID); err != nil {
+			return nil, err
+		}
+	}
+	if device == nil || device.Status == models.DeviceStatusReported {
+		return nil, ErrInvalidToken
+	}
+
+	return s.completeLogin(ctx, &pendingLogin{
+		user:      user,
+		device:    device,
+		newDevice: challenge.NewDevice,
+		risk: &LoginRiskAssessment{
+			Score: challenge.RiskScore,
+			Location: GeoLocation{
+				CountryCode: challenge.GeoCountry,
+				Latitude:    challenge.GeoLatitude,
+				Longitude:   challenge.GeoLongitude,
+			},
+		},
+		ipAddress:   req.IPAddress,
+		userAgent:   req.UserAgent,
+		rememberMe:  challenge.RememberMe,
+		challengeID: &challenge.ChallengeID,
+	})
+}
+
+// Holds back a risky login until it is confirmed, returning a *LoginChallengeError
+func (s *AuthService) startLoginChallenge(ctx context.Context, login *pendingLogin) error {
+	method := models.ChallengeMethodEmail
+	if s.loginRiskPolicy.ChallengeMethod == models.ChallengeMethodMFA && s.mfaVerifier != nil {
+		enrolled, err := s.mfaVerifier.IsEnrolled(ctx, login.user.UserID)
+		if err != nil {
+			return err
+		}
+		if enrolled {
+			method = models.ChallengeMethodMFA
+		}
+	}
+
+	secret, err := generateSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	challenge := &models.LoginChallenge{
+		UserID:       login.user.UserID,
+		DeviceID:     &login.device.DeviceID,
+		SecretHash:   hashToken(secret),
+		Method:       method,
+		RiskScore:    login.risk.Score,
+		GeoCountry:   login.risk.Location.CountryCode,
+		GeoLatitude:  login.risk.Location.Latitude,
+		GeoLongitude: login.risk.Location.Longitude,
+		NewDevice:    login.newDevice,
+		RememberMe:   login.rememberMe,
+		IPAddress:    login.ipAddress,
+		UserAgent:    login.userAgent,
+		ExpiresAt:    time.Now().Add(s.loginRiskPolicy.ChallengeTTL),
+	}
+
+	var approvalToken string
+	if method == models.ChallengeMethodEmail {
+		if approvalToken, err = generateSecureToken(32); err != nil {
+			return err
+		}
+		challenge.ApprovalTokenHash = hashToken(approvalToken)
+	}
+
+	if err := s.challengeRepo.Create(ctx, challenge); err != nil {
+		return err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    login.user.UserID,
+		EventType: "login_challenge_issued",
+		IPAddress: login.ipAddress,
+		UserAgent: login.userAgent,
+		Details: map[string]interface{}{
+			"challenge_id": challenge.ChallengeID.String(),
+			"method":       method,
+			"risk_score":   login.risk.Score,
+			"factors":      login.risk.Factors,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	if method == models.ChallengeMethodEmail {
+		go func(email string, location GeoLocation, ipAddress string, expiresAt time.Time) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := s.emailService.SendLoginConfirmationEmail(ctx, email, approvalToken, ipAddress, location, expiresAt); err != nil {
+				slog.ErrorContext(ctx, "Error sending login confirmation email", "error", err)
+			}
+		}(login.user.Email, login.risk.Location, login.ipAddress, challenge.ExpiresAt)
+	}
+
+	return &LoginChallengeError{
+		ChallengeID: challenge.ChallengeID,
+		Secret:      secret,
+		Method:      method,
+		ExpiresAt:   challenge.ExpiresAt,
+		RiskScore:   login.risk.Score,
+		DeviceToken: login.deviceToken,
+		NewDevice:   login.newDevice,
+	}
+}
+
+// CleanupExpiredLoginChallenges removes login challenges that can no longer be completed
+func (s *AuthService) CleanupExpiredLoginChallenges(ctx context.Context) (int64, error) {
+	return s.challengeRepo.DeleteExpired(ctx, time.Now())
+}
@@ -0,0 +1,209 @@
+// services/login_risk.go
+package services
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Risk factors that can contribute to a login's risk score
+const (
+	RiskFactorNewCountry       = "new_country"
+	RiskFactorImpossibleTravel = "impossible_travel"
+	RiskFactorTorExitNode      = "tor_exit_node"
+	RiskFactorDatacenterIP     = "datacenter_ip"
+	RiskFactorNewDevice        = "new_device"
+	RiskFactorUnknownLocation  = "unknown_location"
+)
+
+// What happens to a login, depending on its risk score
+const (
+	RiskActionAllow     = "allow"     // Create the session straight away
+	RiskActionChallenge = "challenge" // Require MFA or email confirmation first
+	RiskActionBlock     = "block"     // Refuse the login
+)
+
+// LoginRiskPolicy holds the weights of each risk factor and the thresholds that act on the
+// total score. Scores are capped at 100.
+type LoginRiskPolicy struct {
+	NewCountryWeight       int
+	ImpossibleTravelWeight int
+	TorExitNodeWeight      int
+	DatacenterIPWeight     int
+	NewDeviceWeight        int
+	UnknownLocationWeight  int
+
+	ChallengeThreshold int // Scores at or above this need confirmation; 0 disables challenges
+	BlockThreshold     int // Scores at or above this are refused; 0 disables blocking
+
+	MaxTravelSpeedKmh   float64 // Faster travel between logins is impossible
+	MinTravelDistanceKm float64 // Shorter distances are within GeoIP accuracy and never count as travel
+
+	ChallengeMethod string        // Preferred confirmation: "mfa" falls back to "email" for users without MFA
+	ChallengeTTL    time.Duration // How long a challenge can be confirmed and completed
+}
+
+// NewLoginRiskPolicy creates a login risk policy with default values
+func NewLoginRiskPolicy() LoginRiskPolicy {
+	return LoginRiskPolicy{
+		NewCountryWeight:       30,
+		ImpossibleTravelWeight: 50,
+		TorExitNodeWeight:      40,
+		DatacenterIPWeight:     20,
+		NewDeviceWeight:        15,
+		UnknownLocationWeight:  10,
+		ChallengeThreshold:     50,
+		BlockThreshold:         0,
+		MaxTravelSpeedKmh:      1000, // Roughly airliner speed
+		MinTravelDistanceKm:    300,
+		ChallengeMethod:        models.ChallengeMethodMFA,
+		ChallengeTTL:           15 * time.Minute,
+	}
+}
+
+// Helper function to decide what to do with a login with the given score
+func (p LoginRiskPolicy) action(score int) string {
+	switch {
+	case p.BlockThreshold > 0 && score >= p.BlockThreshold:
+		return RiskActionBlock
+	case p.ChallengeThreshold > 0 && score >= p.ChallengeThreshold:
+		return RiskActionChallenge
+	default:
+		return RiskActionAllow
+	}
+}
+
+// IPReputation bundles the offline data used to judge where a login comes from.
+// Any of the sources may be nil.
+type IPReputation struct {
+	GeoIP        *GeoIP
+	TorExitNodes *IPList
+	Datacenters  *IPList
+}
+
+// LoginRiskAssessment is the outcome of scoring a login
+type LoginRiskAssessment struct {
+	Score          int         `json:"score"`
+	Action         string      `json:"action"`
+	Factors        []string    `json:"factors"`
+	Location       GeoLocation `json:"location"`
+	DistanceKm     float64     `json:"distance_km,omitempty"`      // From the previous session's location
+	TravelSpeedKmh float64     `json:"travel_speed_kmh,omitempty"` // Speed needed to cover that distance
+}
+
+// Helper function to add a factor's weight to the score
+func (a *LoginRiskAssessment) add(factor string, weight int) {
+	a.Factors = append(a.Factors, factor)
+	a.Score += weight
+}
+
+// Scores a login with a correct password, from its IP address, device and the user's previous sessions
+func (s *AuthService) assessLoginRisk(ctx context.Context, user *models.User, newDevice bool, ipAddress string) (*LoginRiskAssessment, error) {
+	policy := s.loginRiskPolicy
+	assessment := &LoginRiskAssessment{Factors: []string{}}
+
+	if s.ipReputation.GeoIP != nil {
+		location, err := s.ipReputation.GeoIP.Lookup(ipAddress)
+		if err != nil {
+			// A broken lookup should not stop the user signing in
+			slog.ErrorContext(ctx, "Error looking up GeoIP location", "error", err)
+		}
+		assessment.Location = location
+
+		if location.CountryCode == "" {
+			assessment.add(RiskFactorUnknownLocation, policy.UnknownLocationWeight)
+		}
+	}
+
+	if newDevice {
+		assessment.add(RiskFactorNewDevice, policy.NewDeviceWeight)
+	}
+
+	if s.ipReputation.TorExitNodes != nil && s.ipReputation.TorExitNodes.Contains(ipAddress) {
+		assessment.add(RiskFactorTorExitNode, policy.TorExitNodeWeight)
+	}
+
+	if s.ipReputation.Datacenters != nil && s.ipReputation.Datacenters.Contains(ipAddress) {
+		assessment.add(RiskFactorDatacenterIP, policy.DatacenterIPWeight)
+	}
+
+	if country := assessment.Location.CountryCode; country != "" {
+		// Only flag a new country once we know where the user usually signs in from
+		seen, known, err := s.sessionRepo.GetCountryHistory(ctx, user.UserID, country)
+		if err != nil {
+			return nil, err
+		}
+		if known && !seen {
+			assessment.add(RiskFactorNewCountry, policy.NewCountryWeight)
+		}
+	}
+
+	if assessment.Location.HasCoordinates() {
+		previous, err := s.sessionRepo.GetLatestByUserID(ctx, user.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil && previous.GeoLatitude != nil && previous.GeoLongitude != nil {
+			distance := haversineKm(*previous.GeoLatitude, *previous.GeoLongitude,
+				*assessment.Location.Latitude, *assessment.Location.Longitude)
+			if distance >= policy.MinTravelDistanceKm {
+				// Treat logins in quick succession as a minute apart rather than dividing by zero
+				hours := math.Max(time.Since(previous.CreatedAt).Hours(), 1.0/60)
+				assessment.DistanceKm = math.Round(distance)
+				assessment.TravelSpeedKmh = math.Round(distance / hours)
+				if assessment.TravelSpeedKmh > policy.MaxTravelSpeedKmh {
+					assessment.add(RiskFactorImpossibleTravel, policy.ImpossibleTravelWeight)
+				}
+			}
+		}
+	}
+
+	if assessment.Score > 100 {
+		assessment.Score = 100
+	}
+	assessment.Action = policy.action(assessment.Score)
+
+	return assessment, nil
+}
+
+// Records a login's risk assessment in the audit log
+func (s *AuthService) auditLoginRisk(ctx context.Context, user *models.User, device *models.UserDevice, assessment *LoginRiskAssessment, ipAddress, userAgent string) {
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "login_risk_assessed",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"score":            assessment.Score,
+			"action":           assessment.Action,
+			"factors":          assessment.Factors,
+			"country":          assessment.Location.CountryCode,
+			"city":             assessment.Location.City,
+			"asn":              assessment.Location.ASN,
+			"as_org":           assessment.Location.ASOrg,
+			"distance_km":      assessment.DistanceKm,
+			"travel_speed_kmh": assessment.TravelSpeedKmh,
+			"device_id":        device.DeviceID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+}
+
+// Helper function to compute the great-circle distance between two points in kilometres
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLon := toRadians(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
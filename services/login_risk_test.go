@@ -0,0 +1,135 @@
+// services/login_risk_test.go
+package services
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+func TestLoginRiskAction(t *testing.T) {
+	policy := NewLoginRiskPolicy()
+	policy.BlockThreshold = 90
+	noChallenges := policy
+	noChallenges.ChallengeThreshold = 0
+	defaults := NewLoginRiskPolicy()
+
+	tests := []struct {
+		name   string
+		policy LoginRiskPolicy
+		score  int
+		want   string
+	}{
+		{"below the challenge threshold", policy, 49, RiskActionAllow},
+		{"at the challenge threshold", policy, 50, RiskActionChallenge},
+		{"below the block threshold", policy, 89, RiskActionChallenge},
+		{"at the block threshold", policy, 90, RiskActionBlock},
+		{"challenges disabled", noChallenges, 89, RiskActionAllow},
+		{"blocking disabled by default", defaults, 100, RiskActionChallenge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.action(tt.score); got != tt.want {
+				t.Errorf("action(%d) = %q, want %q", tt.score, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHaversineKm(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"same place", 51.5074, -0.1278, 51.5074, -0.1278, 0},
+		{"London to Paris", 51.5074, -0.1278, 48.8566, 2.3522, 344},
+		{"New York to Los Angeles", 40.7128, -74.0060, 34.0522, -118.2437, 3936},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, 111},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := haversineKm(tt.lat1, tt.lon1, tt.lat2, tt.lon2); math.Abs(got-tt.want) > 1 {
+				t.Errorf("haversineKm = %.1f, want about %.0f", got, tt.want)
+			}
+		})
+	}
+}
+
+// Helper function to parse an IP list in a test
+func mustParseIPList(t *testing.T, entries ...string) *IPList {
+	t.Helper()
+	list, err := ParseIPList(entries)
+	if err != nil {
+		t.Fatalf("ParseIPList: %v", err)
+	}
+	return list
+}
+
+func TestAssessLoginRiskFactors(t *testing.T) {
+	// Without GeoIP data the assessment never reads the user's previous sessions
+	s := NewAuthService(nil, "test-secret", 60)
+	s.SetIPReputation(IPReputation{
+		TorExitNodes: mustParseIPList(t, "198.51.100.7", "2001:db8::7"),
+		Datacenters:  mustParseIPList(t, "198.51.100.0/24", "203.0.113.0/24"),
+	})
+	policy := NewLoginRiskPolicy()
+	policy.BlockThreshold = 70
+	s.SetLoginRiskPolicy(policy)
+	user := &models.User{UserID: uuid.New()}
+
+	tests := []struct {
+		name        string
+		newDevice   bool
+		ipAddress   string
+		wantFactors []string
+		wantScore   int
+		wantAction  string
+	}{
+		{"known device from home", false, "192.0.2.1", nil, 0, RiskActionAllow},
+		{"new device", true, "192.0.2.1", []string{RiskFactorNewDevice}, 15, RiskActionAllow},
+		{"datacenter", false, "203.0.113.9", []string{RiskFactorDatacenterIP}, 20, RiskActionAllow},
+		{"Tor exit node", false, "2001:db8::7", []string{RiskFactorTorExitNode}, 40, RiskActionAllow},
+		{"Tor exit node in a datacenter", false, "198.51.100.7", []string{RiskFactorTorExitNode, RiskFactorDatacenterIP}, 60, RiskActionChallenge},
+		{"new device on a Tor exit node in a datacenter", true, "198.51.100.7", []string{RiskFactorNewDevice, RiskFactorTorExitNode, RiskFactorDatacenterIP}, 75, RiskActionBlock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assessment, err := s.assessLoginRisk(context.Background(), user, tt.newDevice, tt.ipAddress)
+			if err != nil {
+				t.Fatalf("assessLoginRisk: %v", err)
+			}
+			if got, want := strings.Join(assessment.Factors, ","), strings.Join(tt.wantFactors, ","); got != want {
+				t.Errorf("factors = %q, want %q", got, want)
+			}
+			if assessment.Score != tt.wantScore || assessment.Action != tt.wantAction {
+				t.Errorf("score %d, action %q; want %d, %q", assessment.Score, assessment.Action, tt.wantScore, tt.wantAction)
+			}
+		})
+	}
+}
+
+func TestAssessLoginRiskCapsScore(t *testing.T) {
+	s := NewAuthService(nil, "test-secret", 60)
+	s.SetIPReputation(IPReputation{
+		TorExitNodes: mustParseIPList(t, "198.51.100.7"),
+		Datacenters:  mustParseIPList(t, "198.51.100.0/24"),
+	})
+	policy := NewLoginRiskPolicy()
+	policy.TorExitNodeWeight = 80
+	policy.DatacenterIPWeight = 80
+	s.SetLoginRiskPolicy(policy)
+
+	assessment, err := s.assessLoginRisk(context.Background(), &models.User{UserID: uuid.New()}, true, "198.51.100.7")
+	if err != nil {
+		t.Fatalf("assessLoginRisk: %v", err)
+	}
+	if assessment.Score != 100 {
+		t.Errorf("score = %d, want it capped at 100", assessment.Score)
+	}
+}
@@ -0,0 +1,95 @@
+// services/metrics.go
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/loganmanery/go-react-app/metrics"
+)
+
+// How a login was attempted, the method label of the login metrics
+const (
+	loginMethodPassword  = "password"
+	loginMethodChallenge = "challenge" // Confirming a login held back by risk scoring
+	loginMethodExternal  = "external"
+	loginMethodSAML      = "saml"
+)
+
+// What failed enough times to lock an account, the reason label of the lockout metric
+const (
+	lockoutReasonPassword = "password"
+	lockoutReasonMFACode  = "mfa_code"
+)
+
+// Kinds of session, the kind label of the sessions created metric
+const (
+	sessionKindLogin         = "login"
+	sessionKindImpersonation = "impersonation"
+)
+
+// Why sessions ended early, the reason label of the sessions revoked metric
+const (
+	sessionRevokedLogout               = "logout"
+	sessionRevokedEvicted              = "evicted"
+	sessionRevokedUser                 = "user_signed_out" // Disabled, password reset or signed out everywhere
+	sessionRevokedDevice               = "device_reported"
+	sessionRevokedImpersonationEnded   = "impersonation_ended"
+	sessionRevokedImpersonationExpired = "impersonation_expired"
+	sessionRevokedSAMLLogout           = "saml_logout"
+	sessionRevokedSCIM                 = "scim_deactivated"
+)
+
+// CountActiveSessions counts the sessions that are valid and have not expired, for the
+// active sessions metric
+func (s *AuthService) CountActiveSessions(ctx context.Context) (int64, error) {
+	return s.sessionRepo.CountActive(ctx)
+}
+
+// Helper function to record the outcome of a login attempt
+func (s *AuthService) recordLoginAttempt(method string, err error) {
+	var challengeErr *LoginChallengeError
+	switch {
+	case err == nil:
+		s.metrics.LoginAttempt(method, metrics.LoginSucceeded, "")
+	case errors.As(err, &challengeErr):
+		s.metrics.LoginAttempt(method, metrics.LoginChallenged, "")
+	default:
+		s.metrics.LoginAttempt(method, metrics.LoginFailed, loginFailureReason(err))
+	}
+}
+
+// Helper function to name the reason a login failed, keeping the label's values to a
+// short, fixed list
+func loginFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		return "invalid_credentials"
+	case errors.Is(err, ErrUserLocked):
+		return "locked"
+	case errors.Is(err, ErrLoginBlocked):
+		return "blocked"
+	case errors.Is(err, ErrInvalidMFACode):
+		return "invalid_mfa_code"
+	case errors.Is(err, ErrLoginChallengePending):
+		return "challenge_pending"
+	case errors.Is(err, ErrInvalidToken):
+		return "invalid_token"
+	case errors.Is(err, ErrSessionLimitReached):
+		return "session_limit"
+	case errors.Is(err, ErrDirectoryUnavailable):
+		return "directory_unavailable"
+	case errors.Is(err, ErrExternalProviderNotFound):
+		return "provider_not_found"
+	case errors.Is(err, ErrExternalAccountNotFound):
+		return "account_not_found"
+	case errors.Is(err, ErrExternalEmailConflict), errors.Is(err, ErrEmailAlreadyExists):
+		return "email_conflict"
+	case errors.Is(err, ErrExternalLoginFailed):
+		return "external_login_failed"
+	case errors.Is(err, ErrSAMLResponseInvalid), errors.Is(err, ErrSAMLAssertionReplayed):
+		return "invalid_saml_response"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,185 @@
+// services/oauth_clients.go
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrOAuthClientNotFound = errors.New("OAuth client not found")
+	ErrInvalidRedirectURI  = errors.New("invalid redirect URI")
+)
+
+// Prefixes for generated client IDs and secrets
+const (
+	oauthClientIDPrefix     = "client_"
+	oauthClientSecretPrefix = "secret_"
+)
+
+// RegisterOAuthClientRequest holds the settings for a new relying party
+type RegisterOAuthClientRequest struct {
+	Name           string
+	RedirectURIs   []string
+	Scopes         []string // Scopes the client may request; "openid" is always allowed
+	Confidential   bool     // Server-side apps that can keep a secret; public clients rely on PKCE alone
+	RequireConsent bool     // Ask users before sharing their details; first-party apps can skip it
+}
+
+// RegisterOAuthClient registers a relying party on behalf of an admin. For confidential
+// clients the secret is returned only here; the database keeps just its hash.
+func (s *AuthService) RegisterOAuthClient(ctx context.Context, adminID uuid.UUID, req RegisterOAuthClientRequest, ipAddress, userAgent string) (*models.OAuthClient, string, error) {
+	if len(req.RedirectURIs) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one is required", ErrInvalidRedirectURI)
+	}
+	for _, redirectURI := range req.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return nil, "", err
+		}
+	}
+
+	scopes := []string{OIDCScopeOpenID}
+	for _, scope := range req.Scopes {
+		if !isOIDCScope(scope) {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	clientID, err := generatePrefixedToken(oauthClientIDPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+	// Client IDs appear in URLs and logs, so they do not need the full token length
+	clientID = clientID[:len(oauthClientIDPrefix)+22]
+
+	client := &models.OAuthClient{
+		ClientID:       clientID,
+		Name:           req.Name,
+		RedirectURIs:   req.RedirectURIs,
+		AllowedScopes:  scopes,
+		RequireConsent: req.RequireConsent,
+		CreatedBy:      &adminID,
+	}
+
+	var secret string
+	if req.Confidential {
+		if secret, err = generatePrefixedToken(oauthClientSecretPrefix); err != nil {
+			return nil, "", err
+		}
+		client.ClientSecretHash = hashToken(secret)
+	}
+
+	if err := s.oauthClientRepo.Create(ctx, client); err != nil {
+		return nil, "", err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    adminID,
+		EventType: "oauth_client_registered",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"client_id":     client.ClientID,
+			"name":          client.Name,
+			"redirect_uris": client.RedirectURIs,
+			"scopes":        client.AllowedScopes,
+			"confidential":  req.Confidential,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return client, secret, nil
+}
+
+// ListOAuthClients retrieves every registered relying party
+func (s *AuthService) ListOAuthClients(ctx context.Context) ([]*models.OAuthClient, error) {
+	return s.oauthClientRepo.List(ctx)
+}
+
+// DeleteOAuthClient removes a relying party and everything issued to it on behalf of an admin
+func (s *AuthService) DeleteOAuthClient(ctx context.Context, adminID uuid.UUID, clientID, ipAddress, userAgent string) error {
+	deleted, err := s.oauthClientRepo.Delete(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrOAuthClientNotFound
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    adminID,
+		EventType: "oauth_client_deleted",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   map[string]interface{}{"client_id": clientID},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// Looks up and authenticates the client calling the token, introspection or revocation endpoint
+func (s *AuthService) authenticateOAuthClient(ctx context.Context, clientID, clientSecret string) (*models.OAuthClient, error) {
+	if clientID == "" {
+		return nil, &OAuthError{Code: OAuthErrInvalidClient, Description: "client authentication required"}
+	}
+
+	client, err := s.oauthClientRepo.GetByID(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, &OAuthError{Code: OAuthErrInvalidClient, Description: "unknown client"}
+	}
+
+	if client.IsConfidential() &&
+		subtle.ConstantTimeCompare([]byte(hashToken(clientSecret)), []byte(client.ClientSecretHash)) != 1 {
+		return nil, &OAuthError{Code: OAuthErrInvalidClient, Description: "invalid client credentials"}
+	}
+
+	return client, nil
+}
+
+// Helper function to check a redirect URI is absolute, has no fragment, and uses
+// HTTPS unless it points at the local machine
+func validateRedirectURI(redirectURI string) error {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" {
+		return fmt.Errorf("%w: %s", ErrInvalidRedirectURI, redirectURI)
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		if host := parsed.Hostname(); host == "localhost" || host == "127.0.0.1" || host == "::1" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: must use https: %s", ErrInvalidRedirectURI, redirectURI)
+}
+
+// Helper function to check if a slice contains a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,738 @@
+// services/oidc.go
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Scopes relying parties can request
+const (
+	OIDCScopeOpenID        = "openid"         // Required; identifies the user with an ID token
+	OIDCScopeProfile       = "profile"        // Username and name claims
+	OIDCScopeEmail         = "email"          // Email and email_verified claims
+	OIDCScopeOfflineAccess = "offline_access" // A refresh token
+)
+
+// OIDCScopes lists every scope the provider supports
+var OIDCScopes = []string{OIDCScopeOpenID, OIDCScopeProfile, OIDCScopeEmail, OIDCScopeOfflineAccess}
+
+// Error codes from RFC 6749 and OpenID Connect Core
+const (
+	OAuthErrInvalidRequest          = "invalid_request"
+	OAuthErrInvalidClient           = "invalid_client"
+	OAuthErrInvalidGrant            = "invalid_grant"
+	OAuthErrUnauthorizedClient      = "unauthorized_client"
+	OAuthErrUnsupportedGrantType    = "unsupported_grant_type"
+	OAuthErrUnsupportedResponseType = "unsupported_response_type"
+	OAuthErrInvalidScope            = "invalid_scope"
+	OAuthErrAccessDenied            = "access_denied"
+	OAuthErrLoginRequired           = "login_required"
+	OAuthErrConsentRequired         = "consent_required"
+	OAuthErrInvalidToken            = "invalid_token"
+)
+
+// Prefixes for opaque tokens issued to relying parties
+const (
+	oauthAccessTokenPrefix  = "oat_"
+	oauthRefreshTokenPrefix = "ort_"
+	oauthCodePrefix         = "oac_"
+)
+
+// ErrOIDCDisabled is returned by provider methods when no OIDC configuration has been set
+var ErrOIDCDisabled = errors.New("OpenID Connect provider is not enabled")
+
+// OAuthError is an error reported to relying parties in the format of RFC 6749
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// OIDCConfig holds the settings for running as an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer     string      // Public base URL of this service, e.g. https://auth.example.com
+	SigningKey *SigningKey // Signs ID tokens
+	LoginURL   string      // Frontend page that signs the user in and then goes to its return_to parameter
+	ConsentURL string      // Frontend page that shows the consent screen for its authorization_id parameter
+
+	CodeTTL         time.Duration // How long an authorization code can be redeemed
+	ConsentTTL      time.Duration // How long the user has to answer the consent screen
+	AccessTokenTTL  time.Duration
+	IDTokenTTL      time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// NewOIDCConfig creates an OIDC configuration with default values
+func NewOIDCConfig(issuer string, signingKey *SigningKey) OIDCConfig {
+	issuer = strings.TrimRight(issuer, "/")
+	return OIDCConfig{
+		Issuer:          issuer,
+		SigningKey:      signingKey,
+		LoginURL:        issuer + "/login",
+		ConsentURL:      issuer + "/oauth/consent",
+		CodeTTL:         time.Minute,
+		ConsentTTL:      10 * time.Minute,
+		AccessTokenTTL:  time.Hour,
+		IDTokenTTL:      time.Hour,
+		RefreshTokenTTL: 30 * 24 * time.Hour,
+	}
+}
+
+// SetOIDCConfig enables the OpenID Connect provider
+func (s *AuthService) SetOIDCConfig(config OIDCConfig) {
+	s.oidc = &config
+}
+
+// OIDCDiscovery returns the provider's discovery document (/.well-known/openid-configuration)
+func (s *AuthService) OIDCDiscovery() (map[string]interface{}, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	issuer := s.oidc.Issuer
+	return map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/oauth/jwks",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"scopes_supported":                      OIDCScopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce",
+			"name", "given_name", "family_name", "preferred_username", "updated_at",
+			"email", "email_verified",
+		},
+	}, nil
+}
+
+// OIDCKeys returns the public keys relying parties use to verify ID tokens
+func (s *AuthService) OIDCKeys() (JSONWebKeySet, error) {
+	if s.oidc == nil {
+		return JSONWebKeySet{}, ErrOIDCDisabled
+	}
+	return JSONWebKeySet{Keys: []JSONWebKey{s.oidc.SigningKey.PublicJWK()}}, nil
+}
+
+// AuthorizeRequest holds the parameters of a request to /oauth/authorize
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Prompt              string
+}
+
+// CheckAuthorizeRequest validates the client and redirect URI of an authorization request.
+// Errors from here must be shown to the user rather than sent to the redirect URI, which
+// cannot be trusted yet.
+func (s *AuthService) CheckAuthorizeRequest(ctx context.Context, req AuthorizeRequest) (*models.OAuthClient, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	client, err := s.oauthClientRepo.GetByID(ctx, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, ErrOAuthClientNotFound
+	}
+	if !containsString(client.RedirectURIs, req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	return client, nil
+}
+
+// Authorize handles an authorization request from a signed-in user, after CheckAuthorizeRequest.
+// It returns where to send the browser: back to the client with a code or an error, or to the
+// consent screen.
+func (s *AuthService) Authorize(ctx context.Context, client *models.OAuthClient, user *models.User, session *models.Session, req AuthorizeRequest) (string, error) {
+	if req.ResponseType != "code" {
+		return authorizeRedirect(req.RedirectURI, req.State, url.Values{"error": {OAuthErrUnsupportedResponseType}}), nil
+	}
+
+	scopes := strings.Fields(req.Scope)
+	if !containsString(scopes, OIDCScopeOpenID) {
+		return authorizeError(req, OAuthErrInvalidScope, "the openid scope is required"), nil
+	}
+	for _, scope := range scopes {
+		if !containsString(client.AllowedScopes, scope) {
+			return authorizeError(req, OAuthErrInvalidScope, "scope not allowed for this client: "+scope), nil
+		}
+	}
+
+	// PKCE is required for every client, with S256 only
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return authorizeError(req, OAuthErrInvalidRequest, "a PKCE code_challenge with code_challenge_method S256 is required"), nil
+	}
+
+	// An admin acting as the user must not hand out tokens for their account
+	if session.ImpersonatorID != nil {
+		s.AuditImpersonatedAction(ctx, session, "GET", "/oauth/authorize", http.StatusForbidden, true, session.IPAddress, session.UserAgent)
+		return authorizeError(req, OAuthErrAccessDenied, "not allowed while impersonating a user"), nil
+	}
+
+	authz := &models.OAuthAuthorization{
+		ClientID:            client.ClientID,
+		UserID:              user.UserID,
+		RedirectURI:         req.RedirectURI,
+		Scopes:              scopes,
+		State:               req.State,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            session.CreatedAt,
+		ExpiresAt:           time.Now().Add(s.oidc.ConsentTTL),
+	}
+	if err := s.oauthAuthzRepo.Create(ctx, authz); err != nil {
+		return "", err
+	}
+
+	consented, err := s.hasConsent(ctx, client, user.UserID, scopes)
+	if err != nil {
+		return "", err
+	}
+	if consented && req.Prompt != "consent" {
+		return s.issueAuthorizationCode(ctx, authz)
+	}
+	if req.Prompt == "none" {
+		return authorizeError(req, OAuthErrConsentRequired, ""), nil
+	}
+
+	return s.oidc.ConsentURL + "?" + url.Values{"authorization_id": {authz.AuthorizationID.String()}}.Encode(), nil
+}
+
+// LoginRedirect returns where to send a browser that made an authorization request without
+// being signed in: to the login page, which comes back to returnTo afterwards, or straight
+// back to the client with login_required if the client asked for no prompts
+func (s *AuthService) LoginRedirect(req AuthorizeRequest, returnTo string) string {
+	if req.Prompt == "none" {
+		return authorizeError(req, OAuthErrLoginRequired, "")
+	}
+	return s.oidc.LoginURL + "?" + url.Values{"return_to": {returnTo}}.Encode()
+}
+
+// ConsentRequest describes what a client is asking a user to share, for the consent screen
+type ConsentRequest struct {
+	AuthorizationID uuid.UUID `json:"authorization_id"`
+	ClientID        string    `json:"client_id"`
+	ClientName      string    `json:"client_name"`
+	Scopes          []string  `json:"scopes"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// GetConsentRequest retrieves a pending authorization for the user's consent screen
+func (s *AuthService) GetConsentRequest(ctx context.Context, userID, authorizationID uuid.UUID) (*ConsentRequest, error) {
+	authz, client, err := s.getPendingAuthorization(ctx, userID, authorizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsentRequest{
+		AuthorizationID: authz.AuthorizationID,
+		ClientID:        client.ClientID,
+		ClientName:      client.Name,
+		Scopes:          authz.Scopes,
+		ExpiresAt:       authz.ExpiresAt,
+	}, nil
+}
+
+// DecideConsent records the user's answer on the consent screen and returns where to send
+// the browser: back to the client with a code, or with an access_denied error
+func (s *AuthService) DecideConsent(ctx context.Context, userID, authorizationID uuid.UUID, approve bool, ipAddress, userAgent string) (string, error) {
+	authz, client, err := s.getPendingAuthorization(ctx, userID, authorizationID)
+	if err != nil {
+		return "", err
+	}
+
+	eventType := "oauth_consent_denied"
+	if approve {
+		eventType = "oauth_consent_granted"
+	}
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: eventType,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"client_id": client.ClientID,
+			"scopes":    authz.Scopes,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	if !approve {
+		return authorizeRedirect(authz.RedirectURI, authz.State, url.Values{
+			"error":             {OAuthErrAccessDenied},
+			"error_description": {"the user denied the request"},
+		}), nil
+	}
+
+	if err := s.oauthClientRepo.SaveConsent(ctx, userID, client.ClientID, authz.Scopes); err != nil {
+		return "", err
+	}
+	return s.issueAuthorizationCode(ctx, authz)
+}
+
+// TokenRequest holds the parameters of a request to /oauth/token
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+}
+
+// TokenResponse is the successful response from /oauth/token
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+// ExchangeToken redeems an authorization code or refresh token for tokens
+func (s *AuthService) ExchangeToken(ctx context.Context, req TokenRequest) (*TokenResponse, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	client, err := s.authenticateOAuthClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case "authorization_code":
+		return s.exchangeAuthorizationCode(ctx, client, req)
+	case "refresh_token":
+		return s.exchangeRefreshToken(ctx, client, req)
+	default:
+		return nil, &OAuthError{Code: OAuthErrUnsupportedGrantType}
+	}
+}
+
+// UserInfo returns the claims about the user that an access token's scopes allow
+func (s *AuthService) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	token, err := s.oauthTokenRepo.GetByTokenHash(ctx, hashToken(accessToken))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.TokenType != models.OAuthTokenAccess || !token.IsActive() {
+		return nil, &OAuthError{Code: OAuthErrInvalidToken, Description: "the access token is invalid or expired"}
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsActive {
+		return nil, &OAuthError{Code: OAuthErrInvalidToken, Description: "the user no longer exists"}
+	}
+
+	return userClaims(user, token.Scopes), nil
+}
+
+// IntrospectToken describes an access or refresh token to an authenticated client (RFC 7662)
+func (s *AuthService) IntrospectToken(ctx context.Context, clientID, clientSecret, value string) (map[string]interface{}, error) {
+	if s.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	client, err := s.authenticateOAuthClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.IsConfidential() {
+		return nil, &OAuthError{Code: OAuthErrUnauthorizedClient, Description: "only confidential clients can introspect tokens"}
+	}
+
+	inactive := map[string]interface{}{"active": false}
+
+	token, err := s.oauthTokenRepo.GetByTokenHash(ctx, hashToken(value))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || !token.IsActive() {
+		return inactive, nil
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsActive {
+		return inactive, nil
+	}
+
+	tokenType := "Bearer"
+	if token.TokenType == models.OAuthTokenRefresh {
+		tokenType = "refresh_token"
+	}
+
+	return map[string]interface{}{
+		"active":     true,
+		"scope":      strings.Join(token.Scopes, " "),
+		"client_id":  token.ClientID,
+		"username":   user.Username,
+		"token_type": tokenType,
+		"exp":        token.ExpiresAt.Unix(),
+		"iat":        token.CreatedAt.Unix(),
+		"sub":        user.UserID.String(),
+		"aud":        token.ClientID,
+		"iss":        s.oidc.Issuer,
+	}, nil
+}
+
+// RevokeOAuthToken revokes an access or refresh token issued to the calling client (RFC 7009).
+// Revoking a refresh token also revokes the access tokens from the same authorization.
+// Unknown tokens are not an error.
+func (s *AuthService) RevokeOAuthToken(ctx context.Context, clientID, clientSecret, value string) error {
+	if s.oidc == nil {
+		return ErrOIDCDisabled
+	}
+
+	client, err := s.authenticateOAuthClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.oauthTokenRepo.GetByTokenHash(ctx, hashToken(value))
+	if err != nil {
+		return err
+	}
+	if token == nil || token.ClientID != client.ClientID {
+		return nil
+	}
+
+	if token.TokenType == models.OAuthTokenRefresh && token.AuthorizationID != nil {
+		_, err = s.oauthTokenRepo.RevokeAllForAuthorization(ctx, *token.AuthorizationID)
+		return err
+	}
+	_, err = s.oauthTokenRepo.Revoke(ctx, token.TokenID)
+	return err
+}
+
+// CleanupExpiredOAuthGrants removes expired authorizations and tokens
+func (s *AuthService) CleanupExpiredOAuthGrants(ctx context.Context) error {
+	// Tokens go first, so the authorizations they came from can then be removed too
+	if _, err := s.oauthTokenRepo.DeleteExpired(ctx, time.Now()); err != nil {
+		return err
+	}
+	_, err := s.oauthAuthzRepo.DeleteExpired(ctx, time.Now())
+	return err
+}
+
+// Redeems an authorization code after checking the client, redirect URI and PKCE verifier
+func (s *AuthService) exchangeAuthorizationCode(ctx context.Context, client *models.OAuthClient, req TokenRequest) (*TokenResponse, error) {
+	invalidGrant := &OAuthError{Code: OAuthErrInvalidGrant, Description: "the authorization code is invalid or expired"}
+
+	authz, replayed, err := s.oauthAuthzRepo.RedeemCode(ctx, hashToken(req.Code))
+	if err != nil {
+		return nil, err
+	}
+	if authz == nil || authz.ClientID != client.ClientID {
+		return nil, invalidGrant
+	}
+	if replayed {
+		// A code used twice may have been stolen, so withdraw everything it produced
+		if _, err := s.oauthTokenRepo.RevokeAllForAuthorization(ctx, authz.AuthorizationID); err != nil {
+			return nil, err
+		}
+		return nil, invalidGrant
+	}
+	if time.Now().After(authz.ExpiresAt) || authz.RedirectURI != req.RedirectURI {
+		return nil, invalidGrant
+	}
+
+	challenge := sha256.Sum256([]byte(req.CodeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(challenge[:])
+	if req.CodeVerifier == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(authz.CodeChallenge)) != 1 {
+		return nil, &OAuthError{Code: OAuthErrInvalidGrant, Description: "the PKCE code verifier does not match"}
+	}
+
+	user, err := s.userRepo.GetByID(ctx, authz.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsActive {
+		return nil, invalidGrant
+	}
+
+	response, err := s.issueOAuthTokens(ctx, client, user, authz.Scopes, &authz.AuthorizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	response.IDToken, err = s.signIDToken(client, user, authz.Scopes, authz.Nonce, authz.AuthTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// Swaps a refresh token for new tokens. Refresh tokens are rotated: each can be used once.
+func (s *AuthService) exchangeRefreshToken(ctx context.Context, client *models.OAuthClient, req TokenRequest) (*TokenResponse, error) {
+	invalidGrant := &OAuthError{Code: OAuthErrInvalidGrant, Description: "the refresh token is invalid or expired"}
+
+	token, err := s.oauthTokenRepo.GetByTokenHash(ctx, hashToken(req.RefreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.TokenType != models.OAuthTokenRefresh || token.ClientID != client.ClientID {
+		return nil, invalidGrant
+	}
+	if !token.IsActive() {
+		// A revoked refresh token being replayed may have been stolen
+		if token.RevokedAt != nil {
+			if err := s.revokeReplayedRefreshToken(ctx, token); err != nil {
+				return nil, err
+			}
+		}
+		return nil, invalidGrant
+	}
+
+	user, err := s.userRepo.GetByID(ctx, token.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || !user.IsActive {
+		return nil, invalidGrant
+	}
+
+	// Spending the token is the atomic step: if another request spent it since we read it,
+	// this one is a replay too
+	revoked, err := s.oauthTokenRepo.Revoke(ctx, token.TokenID)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		if err := s.revokeReplayedRefreshToken(ctx, token); err != nil {
+			return nil, err
+		}
+		return nil, invalidGrant
+	}
+
+	return s.issueOAuthTokens(ctx, client, user, token.Scopes, token.AuthorizationID)
+}
+
+// Revokes every token issued from the same authorization as a refresh token that was used twice
+func (s *AuthService) revokeReplayedRefreshToken(ctx context.Context, token *models.OAuthToken) error {
+	if token.AuthorizationID == nil {
+		return nil
+	}
+	_, err := s.oauthTokenRepo.RevokeAllForAuthorization(ctx, *token.AuthorizationID)
+	return err
+}
+
+// Issues an access token, and a refresh token if offline access was granted
+func (s *AuthService) issueOAuthTokens(ctx context.Context, client *models.OAuthClient, user *models.User, scopes []string, authorizationID *uuid.UUID) (*TokenResponse, error) {
+	accessToken, err := s.createOAuthToken(ctx, models.OAuthTokenAccess, oauthAccessTokenPrefix, s.oidc.AccessTokenTTL, client, user, scopes, authorizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.oidc.AccessTokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}
+
+	if containsString(scopes, OIDCScopeOfflineAccess) {
+		response.RefreshToken, err = s.createOAuthToken(ctx, models.OAuthTokenRefresh, oauthRefreshTokenPrefix, s.oidc.RefreshTokenTTL, client, user, scopes, authorizationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// Stores a new opaque token and returns its value
+func (s *AuthService) createOAuthToken(ctx context.Context, tokenType, prefix string, ttl time.Duration, client *models.OAuthClient, user *models.User, scopes []string, authorizationID *uuid.UUID) (string, error) {
+	value, err := generatePrefixedToken(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	token := &models.OAuthToken{
+		TokenHash:       hashToken(value),
+		TokenType:       tokenType,
+		ClientID:        client.ClientID,
+		UserID:          user.UserID,
+		Scopes:          scopes,
+		AuthorizationID: authorizationID,
+		ExpiresAt:       time.Now().Add(ttl),
+	}
+	if err := s.oauthTokenRepo.Create(ctx, token); err != nil {
+		return "", err
+	}
+
+	return value, nil
+}
+
+// Signs an ID token for a user
+func (s *AuthService) signIDToken(client *models.OAuthClient, user *models.User, scopes []string, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	claims := userClaims(user, scopes)
+	claims["iss"] = s.oidc.Issuer
+	claims["aud"] = client.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.oidc.IDTokenTTL).Unix()
+	claims["auth_time"] = authTime.Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	return s.oidc.SigningKey.Sign(claims)
+}
+
+// Approves an authorization with a fresh code and returns the redirect back to the client
+func (s *AuthService) issueAuthorizationCode(ctx context.Context, authz *models.OAuthAuthorization) (string, error) {
+	code, err := generatePrefixedToken(oauthCodePrefix)
+	if err != nil {
+		return "", err
+	}
+
+	approved, err := s.oauthAuthzRepo.Approve(ctx, authz.AuthorizationID, hashToken(code), time.Now().Add(s.oidc.CodeTTL))
+	if err != nil {
+		return "", err
+	}
+	if !approved {
+		return "", ErrInvalidToken
+	}
+
+	return authorizeRedirect(authz.RedirectURI, authz.State, url.Values{"code": {code}}), nil
+}
+
+// Checks whether the user has already agreed to share the scopes with the client
+func (s *AuthService) hasConsent(ctx context.Context, client *models.OAuthClient, userID uuid.UUID, scopes []string) (bool, error) {
+	if !client.RequireConsent {
+		return true, nil
+	}
+
+	granted, err := s.oauthClientRepo.GetConsent(ctx, userID, client.ClientID)
+	if err != nil {
+		return false, err
+	}
+	for _, scope := range scopes {
+		if !containsString(granted, scope) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Looks up an authorization waiting for the user's consent
+func (s *AuthService) getPendingAuthorization(ctx context.Context, userID, authorizationID uuid.UUID) (*models.OAuthAuthorization, *models.OAuthClient, error) {
+	if s.oidc == nil {
+		return nil, nil, ErrOIDCDisabled
+	}
+
+	authz, err := s.oauthAuthzRepo.GetByID(ctx, authorizationID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if authz == nil || authz.UserID != userID || authz.ApprovedAt != nil || time.Now().After(authz.ExpiresAt) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	client, err := s.oauthClientRepo.GetByID(ctx, authz.ClientID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if client == nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	return authz, client, nil
+}
+
+// Helper function to build the claims about a user that the granted scopes allow
+func userClaims(user *models.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{"sub": user.UserID.String()}
+
+	if containsString(scopes, OIDCScopeProfile) {
+		claims["preferred_username"] = user.Username
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if containsString(scopes, OIDCScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.IsEmailVerified
+	}
+
+	return claims
+}
+
+// Helper function to check a scope is one the provider supports
+func isOIDCScope(scope string) bool {
+	return containsString(OIDCScopes, scope)
+}
+
+// Helper function to build an error redirect back to the client
+func authorizeError(req AuthorizeRequest, code, description string) string {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	return authorizeRedirect(req.RedirectURI, req.State, params)
+}
+
+// Helper function to add response parameters and the state to a redirect URI
+func authorizeRedirect(redirectURI, state string, params url.Values) string {
+	if state != "" {
+		params.Set("state", state)
+	}
+	separator := "?"
+	if strings.Contains(redirectURI, "?") {
+		separator = "&"
+	}
+	return redirectURI + separator + params.Encode()
+}
@@ -0,0 +1,190 @@
+// services/oidc_test.go
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to create a provider with a fresh signing key. The service has no pool,
+// so nothing here may query it.
+func newOIDCTestService(t *testing.T) *AuthService {
+	t.Helper()
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey: %v", err)
+	}
+	s := NewAuthService(nil, "test-secret", 60)
+	s.SetOIDCConfig(NewOIDCConfig("https://auth.example.com/", key))
+	return s
+}
+
+func TestAuthorizeRequiresS256PKCE(t *testing.T) {
+	s := newOIDCTestService(t)
+	client := &models.OAuthClient{
+		ClientID:      "client_test",
+		RedirectURIs:  []string{"https://app.example.com/callback"},
+		AllowedScopes: []string{OIDCScopeOpenID},
+	}
+	user := &models.User{UserID: uuid.New()}
+	session := &models.Session{UserID: user.UserID, CreatedAt: time.Now()}
+
+	tests := []struct {
+		name, challenge, method string
+	}{
+		{"missing challenge", "", ""},
+		{"missing method", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", ""},
+		{"plain", "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk", "plain"},
+		{"method without challenge", "", "S256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location, err := s.Authorize(context.Background(), client, user, session, AuthorizeRequest{
+				ClientID:            client.ClientID,
+				RedirectURI:         "https://app.example.com/callback",
+				ResponseType:        "code",
+				Scope:               "openid",
+				State:               "xyz",
+				CodeChallenge:       tt.challenge,
+				CodeChallengeMethod: tt.method,
+			})
+			if err != nil {
+				t.Fatalf("Authorize: %v", err)
+			}
+			redirect, err := url.Parse(location)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", location, err)
+			}
+			query := redirect.Query()
+			if query.Get("error") != OAuthErrInvalidRequest || query.Get("state") != "xyz" || query.Get("code") != "" {
+				t.Errorf("redirect = %s, want an invalid_request error with the state and no code", location)
+			}
+		})
+	}
+}
+
+func TestIDTokenVerifiesAgainstJWKS(t *testing.T) {
+	s := newOIDCTestService(t)
+	client := &models.OAuthClient{ClientID: "client_test"}
+	user := &models.User{UserID: uuid.New(), Username: "bjensen", Email: "bjensen@example.com", IsEmailVerified: true}
+	authTime := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	idToken, err := s.signIDToken(client, user, []string{OIDCScopeOpenID, OIDCScopeEmail}, "n-0S6_WzA2Mj", authTime)
+	if err != nil {
+		t.Fatalf("signIDToken: %v", err)
+	}
+	keys, err := s.OIDCKeys()
+	if err != nil {
+		t.Fatalf("OIDCKeys: %v", err)
+	}
+
+	claims, err := VerifyJWT(idToken, keys)
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	want := map[string]interface{}{
+		"iss":            "https://auth.example.com",
+		"aud":            client.ClientID,
+		"sub":            user.UserID.String(),
+		"nonce":          "n-0S6_WzA2Mj",
+		"email":          user.Email,
+		"email_verified": true,
+		"auth_time":      float64(authTime.Unix()),
+	}
+	for claim, value := range want {
+		if claims[claim] != value {
+			t.Errorf("%s = %#v, want %#v", claim, claims[claim], value)
+		}
+	}
+	if _, ok := claims["preferred_username"]; ok {
+		t.Error("profile claims included without the profile scope")
+	}
+	if exp, _ := claims["exp"].(float64); time.Unix(int64(exp), 0).Before(time.Now()) {
+		t.Errorf("exp = %v, want a time in the future", claims["exp"])
+	}
+
+	// Changing the claims breaks the signature
+	parts := strings.Split(idToken, ".")
+	forged := strings.Replace(string(mustDecodeSegment(t, parts[1])), user.UserID.String(), uuid.NewString(), 1)
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(forged))
+	if _, err := VerifyJWT(strings.Join(parts, "."), keys); !errors.Is(err, ErrInvalidJWT) {
+		t.Errorf("forged claims: err = %v, want ErrInvalidJWT", err)
+	}
+
+	// Another provider's keys do not verify it
+	if _, err := VerifyJWT(idToken, mustOIDCKeys(t, newOIDCTestService(t))); !errors.Is(err, ErrUnknownJWTKey) {
+		t.Errorf("other key set: err = %v, want ErrUnknownJWTKey", err)
+	}
+}
+
+func TestIDTokenOmitsEmptyNonce(t *testing.T) {
+	s := newOIDCTestService(t)
+	idToken, err := s.signIDToken(&models.OAuthClient{ClientID: "client_test"}, &models.User{UserID: uuid.New()}, []string{OIDCScopeOpenID}, "", time.Now())
+	if err != nil {
+		t.Fatalf("signIDToken: %v", err)
+	}
+	claims, err := VerifyJWT(idToken, mustOIDCKeys(t, s))
+	if err != nil {
+		t.Fatalf("VerifyJWT: %v", err)
+	}
+	if _, ok := claims["nonce"]; ok {
+		t.Errorf("nonce = %#v, want no nonce claim", claims["nonce"])
+	}
+}
+
+func TestAuthorizeRedirect(t *testing.T) {
+	tests := []struct {
+		redirectURI, state, want string
+	}{
+		{"https://app.example.com/cb", "", "https://app.example.com/cb?code=abc"},
+		{"https://app.example.com/cb", "s 1", "https://app.example.com/cb?code=abc&state=s+1"},
+		{"https://app.example.com/cb?tenant=a", "s", "https://app.example.com/cb?tenant=a&code=abc&state=s"},
+	}
+	for _, tt := range tests {
+		if got := authorizeRedirect(tt.redirectURI, tt.state, url.Values{"code": {"abc"}}); got != tt.want {
+			t.Errorf("authorizeRedirect(%q, %q) = %q, want %q", tt.redirectURI, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	s := NewAuthService(nil, "test-secret", 60)
+	if _, err := s.OIDCDiscovery(); !errors.Is(err, ErrOIDCDisabled) {
+		t.Errorf("OIDCDiscovery: err = %v, want ErrOIDCDisabled", err)
+	}
+	if _, err := s.OIDCKeys(); !errors.Is(err, ErrOIDCDisabled) {
+		t.Errorf("OIDCKeys: err = %v, want ErrOIDCDisabled", err)
+	}
+	if _, err := s.ExchangeToken(context.Background(), TokenRequest{GrantType: "authorization_code"}); !errors.Is(err, ErrOIDCDisabled) {
+		t.Errorf("ExchangeToken: err = %v, want ErrOIDCDisabled", err)
+	}
+}
+
+// Helper function to fetch a provider's key set
+func mustOIDCKeys(t *testing.T, s *AuthService) JSONWebKeySet {
+	t.Helper()
+	keys, err := s.OIDCKeys()
+	if err != nil {
+		t.Fatalf("OIDCKeys: %v", err)
+	}
+	return keys
+}
+
+// Helper function to decode a JWT segment
+func mustDecodeSegment(t *testing.T, segment string) []byte {
+	t.Helper()
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", segment, err)
+	}
+	return decoded
+}
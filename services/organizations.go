@@ -0,0 +1,595 @@
+// services/organizations.go
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrOrganizationNotFound       = errors.New("organization not found")
+	ErrOrganizationSlugTaken      = models.ErrOrganizationSlugTaken
+	ErrInvalidOrganization        = errors.New("organization name and slug must be 2-63 characters; slugs may only use lowercase letters, digits and dashes")
+	ErrLastOrganizationOwner      = models.ErrLastOrganizationOwner
+	ErrOrganizationForbidden      = errors.New("your role in the organization does not allow this")
+	ErrInvalidOrganizationRole    = errors.New("role must be owner, admin or member")
+	ErrOrganizationMemberNotFound = errors.New("organization member not found")
+	ErrAlreadyOrganizationMember  = errors.New("user is already a member of the organization")
+	ErrInvitationNotFound         = errors.New("invitation not found, already used or expired")
+	ErrInvitationEmailMismatch    = errors.New("invitation was sent to a different email address")
+	ErrNoActiveOrganization       = errors.New("no active organization")
+)
+
+// OrganizationInvitationTTL is how long an invitation can be accepted for
+const OrganizationInvitationTTL = 7 * 24 * time.Hour
+
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// OrganizationInvitationPreview is what someone holding an invitation token may see before accepting it
+type OrganizationInvitationPreview struct {
+	Organization *models.Organization `json:"organization"`
+	Email        string               `json:"email"`
+	Role         string               `json:"role"`
+	ExpiresAt    time.Time            `json:"expires_at"`
+}
+
+// CreateOrganization creates an organization with the user as its owner. The slug is made
+// from the name when empty.
+func (s *AuthService) CreateOrganization(ctx context.Context, userID uuid.UUID, name, slug, ipAddress, userAgent string) (*models.Organization, error) {
+	org := &models.Organization{Name: strings.TrimSpace(name), Slug: strings.TrimSpace(slug)}
+	if org.Slug == "" {
+		org.Slug = slugify(org.Name)
+	}
+	if err := validateOrganization(org); err != nil {
+		return nil, err
+	}
+
+	if err := s.orgRepo.Create(ctx, org, userID); err != nil {
+		return nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "organization_created",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id": org.OrgID.String(),
+			"name":   org.Name,
+			"slug":   org.Slug,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return org, nil
+}
+
+// ListOrganizations retrieves the organizations a user belongs to
+func (s *AuthService) ListOrganizations(ctx context.Context, userID uuid.UUID) ([]*models.UserOrganization, error) {
+	return s.orgRepo.GetByUserID(ctx, userID)
+}
+
+// GetOrganization retrieves an organization the user belongs to, along with their membership
+func (s *AuthService) GetOrganization(ctx context.Context, userID, orgID uuid.UUID) (*models.Organization, *models.OrganizationMember, error) {
+	member, err := s.requireOrgRole(ctx, orgID, userID, models.OrgRoleMember)
+	if err != nil {
+		return nil, nil, err
+	}
+	org, err := s.getOrganization(ctx, orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return org, member, nil
+}
+
+// UpdateOrganization renames an organization; empty values are left unchanged. Admins and owners can do this.
+func (s *AuthService) UpdateOrganization(ctx context.Context, userID, orgID uuid.UUID, name, slug, ipAddress, userAgent string) (*models.Organization, error) {
+	if _, err := s.requireOrgRole(ctx, orgID, userID, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+	org, err := s.getOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	before := *org
+	if name = strings.TrimSpace(name); name != "" {
+		org.Name = name
+	}
+	if slug = strings.TrimSpace(slug); slug != "" {
+		org.Slug = slug
+	}
+	if err := validateOrganization(org); err != nil {
+		return nil, err
+	}
+
+	if err := s.orgRepo.Update(ctx, org); err != nil {
+		return nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "organization_updated",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id":   org.OrgID.String(),
+			"old_name": before.Name,
+			"name":     org.Name,
+			"old_slug": before.Slug,
+			"slug":     org.Slug,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return org, nil
+}
+
+// DeleteOrganization deletes an organization with its memberships and invitations. Only owners can do this.
+func (s *AuthService) DeleteOrganization(ctx context.Context, userID, orgID uuid.UUID, ipAddress, userAgent string) error {
+	if _, err := s.requireOrgRole(ctx, orgID, userID, models.OrgRoleOwner); err != nil {
+		return err
+	}
+	org, err := s.getOrganization(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.orgRepo.Delete(ctx, orgID); err != nil {
+		return err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "organization_deleted",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id": org.OrgID.String(),
+			"name":   org.Name,
+			"slug":   org.Slug,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// ListOrganizationMembers retrieves the members of an organization the user belongs to
+func (s *AuthService) ListOrganizationMembers(ctx context.Context, userID, orgID uuid.UUID) ([]*models.OrganizationMember, error) {
+	if _, err := s.requireOrgRole(ctx, orgID, userID, models.OrgRoleMember); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.GetMembers(ctx, orgID)
+}
+
+// UpdateOrganizationMemberRole changes a member's role on behalf of an admin. Only owners
+// can make someone an owner or change an owner's role.
+func (s *AuthService) UpdateOrganizationMemberRole(ctx context.Context, actorID, orgID, userID uuid.UUID, role, ipAddress, userAgent string) error {
+	if !models.IsOrgRole(role) {
+		return ErrInvalidOrganizationRole
+	}
+	actor, err := s.requireOrgRole(ctx, orgID, actorID, models.OrgRoleAdmin)
+	if err != nil {
+		return err
+	}
+	member, err := s.orgRepo.GetMember(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return ErrOrganizationMemberNotFound
+	}
+	if (role == models.OrgRoleOwner || member.Role == models.OrgRoleOwner) && actor.Role != models.OrgRoleOwner {
+		return ErrOrganizationForbidden
+	}
+	if member.Role == role {
+		return nil
+	}
+
+	if err := s.orgRepo.UpdateMemberRole(ctx, orgID, userID, role); err != nil {
+		return err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "organization_role_changed",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id":     orgID.String(),
+			"old_role":   member.Role,
+			"role":       role,
+			"changed_by": actorID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// RemoveOrganizationMember takes a user out of an organization. Members can remove
+// themselves; removing anyone else takes an admin, and removing an owner takes an owner.
+func (s *AuthService) RemoveOrganizationMember(ctx context.Context, actorID, orgID, userID uuid.UUID, ipAddress, userAgent string) error {
+	required := models.OrgRoleAdmin
+	if actorID == userID {
+		required = models.OrgRoleMember
+	}
+	actor, err := s.requireOrgRole(ctx, orgID, actorID, required)
+	if err != nil {
+		return err
+	}
+	member, err := s.orgRepo.GetMember(ctx, orgID, userID)
+	if err != nil {
+		return err
+	}
+	if member == nil {
+		return ErrOrganizationMemberNotFound
+	}
+	if member.Role == models.OrgRoleOwner && actor.Role != models.OrgRoleOwner {
+		return ErrOrganizationForbidden
+	}
+
+	if err := s.orgRepo.RemoveMember(ctx, orgID, userID); err != nil {
+		return err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "organization_member_removed",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id":     orgID.String(),
+			"role":       member.Role,
+			"removed_by": actorID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// InviteToOrganization invites an email address to join an organization with a role and
+// emails them a token to accept with. Admins can invite; only owners can invite owners.
+func (s *AuthService) InviteToOrganization(ctx context.Context, actorID, orgID uuid.UUID, email, role, ipAddress, userAgent string) (*models.OrganizationInvitation, error) {
+	if !models.IsOrgRole(role) {
+		return nil, ErrInvalidOrganizationRole
+	}
+	actor, err := s.requireOrgRole(ctx, orgID, actorID, models.OrgRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if role == models.OrgRoleOwner && actor.Role != models.OrgRoleOwner {
+		return nil, ErrOrganizationForbidden
+	}
+	org, err := s.getOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Existing members would only be told the invitation can't change their role
+	email = strings.TrimSpace(email)
+	existing, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		member, err := s.orgRepo.GetMember(ctx, orgID, existing.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if member != nil {
+			return nil, ErrAlreadyOrganizationMember
+		}
+	}
+
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &models.OrganizationInvitation{
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashToken(token),
+		InvitedBy: &actorID,
+		ExpiresAt: time.Now().Add(OrganizationInvitationTTL),
+	}
+	if err := s.orgRepo.CreateInvitation(ctx, invitation); err != nil {
+		return nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    actorID,
+		EventType: "organization_invitation_sent",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id":        orgID.String(),
+			"invitation_id": invitation.InvitationID.String(),
+			"email":         email,
+			"role":          role,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	// Send the invitation without holding up the response
+	inviterName := strings.TrimSpace(actor.FirstName + " " + actor.LastName)
+	if inviterName == "" {
+		inviterName = actor.Username
+	}
+	go func(orgName string, expiresAt time.Time) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.emailService.SendOrganizationInvitationEmail(ctx, email, orgName, inviterName, role, token, expiresAt); err != nil {
+			slog.ErrorContext(ctx, "Error sending organization invitation email", "error", err)
+		}
+	}(org.Name, invitation.ExpiresAt)
+
+	return invitation, nil
+}
+
+// ListOrganizationInvitations retrieves an organization's pending invitations. Admins and owners can do this.
+func (s *AuthService) ListOrganizationInvitations(ctx context.Context, userID, orgID uuid.UUID) ([]*models.OrganizationInvitation, error) {
+	if _, err := s.requireOrgRole(ctx, orgID, userID, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.GetPendingInvitations(ctx, orgID)
+}
+
+// RevokeOrganizationInvitation withdraws a pending invitation. Admins and owners can do this.
+func (s *AuthService) RevokeOrganizationInvitation(ctx context.Context, actorID, orgID, invitationID uuid.UUID, ipAddress, userAgent string) error {
+	if _, err := s.requireOrgRole(ctx, orgID, actorID, models.OrgRoleAdmin); err != nil {
+		return err
+	}
+
+	deleted, err := s.orgRepo.DeleteInvitation(ctx, orgID, invitationID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrInvitationNotFound
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    actorID,
+		EventType: "organization_invitation_revoked",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"org_id":        orgID.String(),
+			"invitation_id": invitationID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// PreviewOrganizationInvitation describes the invitation a token is for, so the person
+// holding it can decide whether to sign in or create an account
+func (s *AuthService) PreviewOrganizationInvitation(ctx context.Context, token string) (*OrganizationInvitationPreview, error) {
+	invitation, err := s.pendingInvitation(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	org, err := s.getOrganization(ctx, invitation.OrgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OrganizationInvitationPreview{
+		Organization: org,
+		Email:        invitation.Email,
+		Role:         invitation.Role,
+		ExpiresAt:    invitation.ExpiresAt,
+	}, nil
+}
+
+// AcceptOrganizationInvitation adds a signed-in user to the organization they were invited
+// to. The invitation must have been sent to the user's email address.
+func (s *AuthService) AcceptOrganizationInvitation(ctx context.Context, userID uuid.UUID, token, ipAddress, userAgent string) (*models.Organization, error) {
+	invitation, err := s.pendingInvitation(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if models.NormalizeEmail(user.Email) != models.NormalizeEmail(invitation.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	return s.acceptInvitation(ctx, invitation, user, ipAddress, userAgent)
+}
+
+// RegisterWithInvitation creates an account for the invited email address and adds it to
+// the organization. Receiving the invitation proves the address, so it is marked verified.
+func (s *AuthService) RegisterWithInvitation(ctx context.Context, token, username, password, firstName, lastName, ipAddress, userAgent string) (*models.User, *models.Organization, error) {
+	invitation, err := s.pendingInvitation(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.Register(ctx, username, invitation.Email, password, firstName, lastName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.VerifyEmail(ctx, *user.EmailVerificationToken); err != nil {
+		return nil, nil, err
+	}
+	user.IsEmailVerified = true
+	user.EmailVerificationToken = nil
+
+	org, err := s.acceptInvitation(ctx, invitation, user, ipAddress, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, org, nil
+}
+
+// SwitchOrganization makes an organization the user belongs to the session's active one
+func (s *AuthService) SwitchOrganization(ctx context.Context, session *models.Session, orgID uuid.UUID) (*models.Organization, error) {
+	if _, err := s.requireOrgRole(ctx, orgID, session.UserID, models.OrgRoleMember); err != nil {
+		return nil, err
+	}
+	org, err := s.getOrganization(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionRepo.SetActiveOrganization(ctx, session.SessionID, &orgID); err != nil {
+		return nil, err
+	}
+	session.ActiveOrgID = &orgID
+
+	// Other replicas hear about it from the database; drop it here straight away
+	if s.sessionCache != nil {
+		s.sessionCache.Remove(session.Token)
+	}
+	return org, nil
+}
+
+// ResolveOrganization checks that the user still belongs to the organization a request is
+// working in and returns it with their membership. orgID is nil when no organization is active.
+func (s *AuthService) ResolveOrganization(ctx context.Context, userID uuid.UUID, orgID *uuid.UUID) (*models.Organization, *models.OrganizationMember, error) {
+	if orgID == nil {
+		return nil, nil, ErrNoActiveOrganization
+	}
+	return s.GetOrganization(ctx, userID, *orgID)
+}
+
+// CleanupExpiredOrganizationInvitations deletes invitations that expired without being accepted
+func (s *AuthService) CleanupExpiredOrganizationInvitations(ctx context.Context) (int64, error) {
+	return s.orgRepo.DeleteExpiredInvitations(ctx)
+}
+
+// Checks that a user belongs to an organization with at least the required role. Users
+// outside the organization are told it doesn't exist.
+func (s *AuthService) requireOrgRole(ctx context.Context, orgID, userID uuid.UUID, required string) (*models.OrganizationMember, error) {
+	member, err := s.orgRepo.GetMember(ctx, orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, ErrOrganizationNotFound
+	}
+	if !models.OrgRoleAtLeast(member.Role, required) {
+		return nil, ErrOrganizationForbidden
+	}
+	return member, nil
+}
+
+// Helper function to load an organization that must exist
+func (s *AuthService) getOrganization(ctx context.Context, orgID uuid.UUID) (*models.Organization, error) {
+	org, err := s.orgRepo.GetByID(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, ErrOrganizationNotFound
+	}
+	return org, nil
+}
+
+// Helper function to find the invitation a token is for, if it can still be accepted
+func (s *AuthService) pendingInvitation(ctx context.Context, token string) (*models.OrganizationInvitation, error) {
+	invitation, err := s.orgRepo.GetInvitationByTokenHash(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if invitation == nil || invitation.AcceptedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, ErrInvitationNotFound
+	}
+	return invitation, nil
+}
+
+// Adds the user to the organization an invitation is for and records it
+func (s *AuthService) acceptInvitation(ctx context.Context, invitation *models.OrganizationInvitation, user *models.User, ipAddress, userAgent string) (*models.Organization, error) {
+	accepted, err := s.orgRepo.AcceptInvitation(ctx, invitation.InvitationID, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !accepted {
+		return nil, ErrInvitationNotFound
+	}
+
+	details := map[string]interface{}{
+		"org_id":        invitation.OrgID.String(),
+		"invitation_id": invitation.InvitationID.String(),
+		"role":          invitation.Role,
+	}
+	if invitation.InvitedBy != nil {
+		details["invited_by"] = invitation.InvitedBy.String()
+	}
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "organization_invitation_accepted",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   details,
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return s.getOrganization(ctx, invitation.OrgID)
+}
+
+// Helper function to check an organization's name and slug
+func validateOrganization(org *models.Organization) error {
+	if len(org.Name) < 2 || len(org.Name) > 63 || len(org.Slug) < 2 || len(org.Slug) > 63 || !organizationSlugPattern.MatchString(org.Slug) {
+		return ErrInvalidOrganization
+	}
+	return nil
+}
+
+// Helper function to make a slug from an organization name, e.g. "Acme, Inc." becomes "acme-inc"
+func slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	if slug.Len() > 63 {
+		return strings.TrimRight(slug.String()[:63], "-")
+	}
+	return slug.String()
+}
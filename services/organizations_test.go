@@ -0,0 +1,263 @@
+// services/organizations_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Acme", "acme"},
+		{"Acme, Inc.", "acme-inc"},
+		{"  Big   Blue  Team 42 ", "big-blue-team-42"},
+		{"Ünïcode Café", "n-code-caf"},
+		{"!!!", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugify(tt.name); got != tt.want {
+				t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOrganization(t *testing.T) {
+	long := "a123456789b123456789c123456789d123456789e123456789f123456789g123"
+	tests := []struct {
+		name    string
+		org     models.Organization
+		wantErr bool
+	}{
+		{"valid", models.Organization{Name: "Acme", Slug: "acme"}, false},
+		{"dashed slug", models.Organization{Name: "Acme Inc", Slug: "acme-inc-2"}, false},
+		{"name too short", models.Organization{Name: "A", Slug: "acme"}, true},
+		{"slug too short", models.Organization{Name: "Acme", Slug: "a"}, true},
+		{"slug too long", models.Organization{Name: "Acme", Slug: long}, true},
+		{"uppercase slug", models.Organization{Name: "Acme", Slug: "Acme"}, true},
+		{"leading dash", models.Organization{Name: "Acme", Slug: "-acme"}, true},
+		{"double dash", models.Organization{Name: "Acme", Slug: "acme--inc"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOrganization(&tt.org)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateOrganization: err = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// Helper function to invite an email address to an organization with a token the test knows,
+// since InviteToOrganization only sends its token by email
+func createTestInvitation(t *testing.T, s *AuthService, orgID uuid.UUID, email, role string, expiresIn time.Duration) string {
+	t.Helper()
+	token, err := generateSecureToken(32)
+	if err != nil {
+		t.Fatalf("generateSecureToken: %v", err)
+	}
+	invitation := &models.OrganizationInvitation{
+		OrgID:     orgID,
+		Email:     email,
+		Role:      role,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(expiresIn),
+	}
+	if err := s.orgRepo.CreateInvitation(context.Background(), invitation); err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	return token
+}
+
+// Helper function to add a user to an organization with a role
+func joinTestOrganization(t *testing.T, s *AuthService, org *models.Organization, user *models.User, role string) {
+	t.Helper()
+	token := createTestInvitation(t, s, org.OrgID, user.Email, role, time.Hour)
+	if _, err := s.AcceptOrganizationInvitation(context.Background(), user.UserID, token, "192.0.2.1", "organizations-test"); err != nil {
+		t.Fatalf("AcceptOrganizationInvitation: %v", err)
+	}
+}
+
+func TestOrganizationRoles(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	ctx := context.Background()
+	owner := createLocalTestUser(t, s, "owner", "correct-Horse-battery-9-staple")
+	admin := createLocalTestUser(t, s, "admin", "correct-Horse-battery-9-staple")
+	member := createLocalTestUser(t, s, "member", "correct-Horse-battery-9-staple")
+	outsider := createLocalTestUser(t, s, "outsider", "correct-Horse-battery-9-staple")
+
+	org, err := s.CreateOrganization(ctx, owner.UserID, "Acme, Inc.", "", "192.0.2.1", "organizations-test")
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	if org.Slug != "acme-inc" {
+		t.Errorf("slug = %q, want one made from the name", org.Slug)
+	}
+	if _, err := s.CreateOrganization(ctx, admin.UserID, "Acme again", "acme-inc", "", ""); !errors.Is(err, ErrOrganizationSlugTaken) {
+		t.Errorf("CreateOrganization with a taken slug: err = %v, want ErrOrganizationSlugTaken", err)
+	}
+	joinTestOrganization(t, s, org, admin, models.OrgRoleAdmin)
+	joinTestOrganization(t, s, org, member, models.OrgRoleMember)
+
+	tests := []struct {
+		name    string
+		do      func() error
+		wantErr error
+	}{
+		{"outsider reads the organization", func() error {
+			_, _, err := s.GetOrganization(ctx, outsider.UserID, org.OrgID)
+			return err
+		}, ErrOrganizationNotFound},
+		{"outsider switches to the organization", func() error {
+			_, err := s.SwitchOrganization(ctx, &models.Session{UserID: outsider.UserID}, org.OrgID)
+			return err
+		}, ErrOrganizationNotFound},
+		{"member lists members", func() error {
+			_, err := s.ListOrganizationMembers(ctx, member.UserID, org.OrgID)
+			return err
+		}, nil},
+		{"member invites", func() error {
+			_, err := s.InviteToOrganization(ctx, member.UserID, org.OrgID, "new@example.com", models.OrgRoleMember, "", "")
+			return err
+		}, ErrOrganizationForbidden},
+		{"member renames the organization", func() error {
+			_, err := s.UpdateOrganization(ctx, member.UserID, org.OrgID, "Members Inc", "members-inc", "", "")
+			return err
+		}, ErrOrganizationForbidden},
+		{"admin invites an owner", func() error {
+			_, err := s.InviteToOrganization(ctx, admin.UserID, org.OrgID, "new@example.com", models.OrgRoleOwner, "", "")
+			return err
+		}, ErrOrganizationForbidden},
+		{"admin invites an existing member", func() error {
+			_, err := s.InviteToOrganization(ctx, admin.UserID, org.OrgID, member.Email, models.OrgRoleAdmin, "", "")
+			return err
+		}, ErrAlreadyOrganizationMember},
+		{"admin invites a member", func() error {
+			_, err := s.InviteToOrganization(ctx, admin.UserID, org.OrgID, "new@example.com", models.OrgRoleMember, "", "")
+			return err
+		}, nil},
+		{"admin promotes to owner", func() error {
+			return s.UpdateOrganizationMemberRole(ctx, admin.UserID, org.OrgID, member.UserID, models.OrgRoleOwner, "", "")
+		}, ErrOrganizationForbidden},
+		{"admin demotes the owner", func() error {
+			return s.UpdateOrganizationMemberRole(ctx, admin.UserID, org.OrgID, owner.UserID, models.OrgRoleMember, "", "")
+		}, ErrOrganizationForbidden},
+		{"admin removes the owner", func() error {
+			return s.RemoveOrganizationMember(ctx, admin.UserID, org.OrgID, owner.UserID, "", "")
+		}, ErrOrganizationForbidden},
+		{"admin deletes the organization", func() error {
+			return s.DeleteOrganization(ctx, admin.UserID, org.OrgID, "", "")
+		}, ErrOrganizationForbidden},
+		{"invalid role", func() error {
+			return s.UpdateOrganizationMemberRole(ctx, owner.UserID, org.OrgID, member.UserID, "superuser", "", "")
+		}, ErrInvalidOrganizationRole},
+		{"last owner demotes themselves", func() error {
+			return s.UpdateOrganizationMemberRole(ctx, owner.UserID, org.OrgID, owner.UserID, models.OrgRoleAdmin, "", "")
+		}, ErrLastOrganizationOwner},
+		{"last owner leaves", func() error {
+			return s.RemoveOrganizationMember(ctx, owner.UserID, org.OrgID, owner.UserID, "", "")
+		}, ErrLastOrganizationOwner},
+		{"admin promotes a member to admin", func() error {
+			return s.UpdateOrganizationMemberRole(ctx, admin.UserID, org.OrgID, member.UserID, models.OrgRoleAdmin, "", "")
+		}, nil},
+		{"member leaves", func() error {
+			return s.RemoveOrganizationMember(ctx, member.UserID, org.OrgID, member.UserID, "", "")
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.do(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Once there is another owner, the first one can step down
+	if err := s.UpdateOrganizationMemberRole(ctx, owner.UserID, org.OrgID, admin.UserID, models.OrgRoleOwner, "", ""); err != nil {
+		t.Fatalf("promoting to owner: %v", err)
+	}
+	if err := s.RemoveOrganizationMember(ctx, owner.UserID, org.OrgID, owner.UserID, "", ""); err != nil {
+		t.Errorf("owner leaving with another owner: %v", err)
+	}
+}
+
+func TestOrganizationInvitations(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	ctx := context.Background()
+	owner := createLocalTestUser(t, s, "owner", "correct-Horse-battery-9-staple")
+	invited := createLocalTestUser(t, s, "invited", "correct-Horse-battery-9-staple")
+	other := createLocalTestUser(t, s, "other", "correct-Horse-battery-9-staple")
+
+	org, err := s.CreateOrganization(ctx, owner.UserID, "Acme", "acme", "", "")
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+
+	token := createTestInvitation(t, s, org.OrgID, "INVITED@example.com", models.OrgRoleAdmin, time.Hour)
+	preview, err := s.PreviewOrganizationInvitation(ctx, token)
+	if err != nil {
+		t.Fatalf("PreviewOrganizationInvitation: %v", err)
+	}
+	if preview.Organization.OrgID != org.OrgID || preview.Role != models.OrgRoleAdmin {
+		t.Errorf("preview = %s as %s, want %s as admin", preview.Organization.Slug, preview.Role, org.Slug)
+	}
+
+	if _, err := s.AcceptOrganizationInvitation(ctx, other.UserID, token, "", ""); !errors.Is(err, ErrInvitationEmailMismatch) {
+		t.Errorf("accepting someone else's invitation: err = %v, want ErrInvitationEmailMismatch", err)
+	}
+	// The address matches however its case was typed
+	if _, err := s.AcceptOrganizationInvitation(ctx, invited.UserID, token, "", ""); err != nil {
+		t.Fatalf("AcceptOrganizationInvitation: %v", err)
+	}
+	if _, err := s.AcceptOrganizationInvitation(ctx, invited.UserID, token, "", ""); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("accepting twice: err = %v, want ErrInvitationNotFound", err)
+	}
+	if _, membership, err := s.GetOrganization(ctx, invited.UserID, org.OrgID); err != nil || membership.Role != models.OrgRoleAdmin {
+		t.Errorf("GetOrganization after accepting: membership %+v, err %v; want an admin", membership, err)
+	}
+
+	expired := createTestInvitation(t, s, org.OrgID, other.Email, models.OrgRoleMember, -time.Minute)
+	if _, err := s.AcceptOrganizationInvitation(ctx, other.UserID, expired, "", ""); !errors.Is(err, ErrInvitationNotFound) {
+		t.Errorf("accepting an expired invitation: err = %v, want ErrInvitationNotFound", err)
+	}
+	if deleted, err := s.CleanupExpiredOrganizationInvitations(ctx); err != nil || deleted != 1 {
+		t.Errorf("CleanupExpiredOrganizationInvitations = %d, %v; want 1", deleted, err)
+	}
+
+	// Registering from an invitation verifies the invited address and joins the organization
+	registration := createTestInvitation(t, s, org.OrgID, "newcomer@example.com", models.OrgRoleMember, time.Hour)
+	user, joined, err := s.RegisterWithInvitation(ctx, registration, "newcomer", "correct-Horse-battery-9-staple", "New", "Comer", "", "")
+	if err != nil {
+		t.Fatalf("RegisterWithInvitation: %v", err)
+	}
+	if !user.IsEmailVerified || joined.OrgID != org.OrgID {
+		t.Errorf("registered user verified %v, joined %v; want verified and joined %v", user.IsEmailVerified, joined.OrgID, org.OrgID)
+	}
+
+	// Revoked invitations can't be used
+	invitation, err := s.InviteToOrganization(ctx, owner.UserID, org.OrgID, "late@example.com", models.OrgRoleMember, "", "")
+	if err != nil {
+		t.Fatalf("InviteToOrganization: %v", err)
+	}
+	if err := s.RevokeOrganizationInvitation(ctx, owner.UserID, org.OrgID, invitation.InvitationID, "", ""); err != nil {
+		t.Fatalf("RevokeOrganizationInvitation: %v", err)
+	}
+	pending, err := s.ListOrganizationInvitations(ctx, owner.UserID, org.OrgID)
+	if err != nil {
+		t.Fatalf("ListOrganizationInvitations: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d pending invitations after revoking, want 0", len(pending))
+	}
+}
@@ -0,0 +1,222 @@
+// services/password_policy.go
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Password policy violation codes returned to clients
+const (
+	PasswordTooShort           = "password_too_short"
+	PasswordTooLong            = "password_too_long"
+	PasswordTooWeak            = "password_too_weak"
+	PasswordContainsUserInfo   = "password_contains_user_info"
+	PasswordPreviouslyUsed     = "password_previously_used"
+	PasswordFoundInDataBreach  = "password_found_in_data_breach"
+	passwordPolicyErrorMessage = "password does not meet the password policy"
+)
+
+// PasswordPolicyConfig holds the password policy settings
+type PasswordPolicyConfig struct {
+	MinLength        int
+	MaxLength        int
+	MinStrengthScore int
+	HistoryCount     int // Recent passwords that may not be reused, counting the current one
+	RejectUserInfo   bool
+	BreachedListDir  string
+	BreachedMinCount int
+}
+
+// NewPasswordPolicyConfig creates a new password policy configuration with default values
+func NewPasswordPolicyConfig() PasswordPolicyConfig {
+	return PasswordPolicyConfig{
+		MinLength:        12,
+		MaxLength:        72, // bcrypt ignores anything longer
+		MinStrengthScore: StrengthSafelyUnguessable,
+		HistoryCount:     5,
+		RejectUserInfo:   true,
+		BreachedMinCount: 1,
+	}
+}
+
+// PasswordViolation describes a single failed password policy rule
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError is returned when a password fails one or more policy rules
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return passwordPolicyErrorMessage + ": " + strings.Join(messages, "; ")
+}
+
+// PasswordPolicy validates new passwords against the configured rules
+type PasswordPolicy struct {
+	config      PasswordPolicyConfig
+	historyRepo *models.PasswordHistoryRepository
+	breached    *BreachedPasswordList
+}
+
+// NewPasswordPolicy creates a new PasswordPolicy, loading the breached-password list if configured
+func NewPasswordPolicy(pool *pgxpool.Pool, config PasswordPolicyConfig) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		config:      config,
+		historyRepo: models.NewPasswordHistoryRepository(pool),
+	}
+
+	if config.BreachedListDir != "" {
+		breached, err := LoadBreachedPasswordList(config.BreachedListDir, config.BreachedMinCount)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = breached
+	}
+
+	return policy, nil
+}
+
+// Validate checks a candidate password for the given user.
+// The user may be a new, unsaved user, in which case the history check is skipped.
+func (p *PasswordPolicy) Validate(ctx context.Context, password string, user *models.User) error {
+	var violations []PasswordViolation
+	length := len([]rune(password))
+
+	if length < p.config.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters", p.config.MinLength),
+		})
+	}
+	if p.config.MaxLength > 0 && len(password) > p.config.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooLong,
+			Message: fmt.Sprintf("password must be at most %d bytes", p.config.MaxLength),
+		})
+	}
+
+	userInputs := passwordUserInputs(user)
+	if p.config.RejectUserInfo && containsUserInfo(password, user) {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordContainsUserInfo,
+			Message: "password must not contain your username or email",
+		})
+	}
+
+	if length > 0 && EstimatePasswordStrength(password, userInputs...) < p.config.MinStrengthScore {
+		violations = append(violations, PasswordViolation{
+			Code:    PasswordTooWeak,
+			Message: "password is too easy to guess",
+		})
+	}
+
+	if p.breached != nil && length > 0 {
+		breached, err := p.breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violations = append(violations, PasswordViolation{
+				Code:    PasswordFoundInDataBreach,
+				Message: "password has appeared in a data breach",
+			})
+		}
+	}
+
+	if user != nil && user.UserID != uuid.Nil && p.config.HistoryCount > 0 {
+		reused, err := p.isReused(ctx, password, user)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, PasswordViolation{
+				Code:    PasswordPreviouslyUsed,
+				Message: fmt.Sprintf("password must differ from your last %d passwords", p.config.HistoryCount),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// Helper function to get how many previous passwords are checked, and so kept, per user.
+// The current password is one of the HistoryCount checked, so history holds one fewer.
+func (p *PasswordPolicy) historyCount() int {
+	return max(p.config.HistoryCount-1, 0)
+}
+
+// Helper function to check the password against the current and recent password hashes
+func (p *PasswordPolicy) isReused(ctx context.Context, password string, user *models.User) (bool, error) {
+	var hashes []string
+	if user.PasswordHash != "" {
+		hashes = append(hashes, user.PasswordHash)
+	}
+	if count := p.historyCount(); count > 0 {
+		previous, err := p.historyRepo.GetRecentHashes(ctx, user.UserID, count)
+		if err != nil {
+			return false, err
+		}
+		hashes = append(hashes, previous...)
+	}
+
+	for _, hash := range hashes {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Helper function to collect the user details a password should not be built from
+func passwordUserInputs(user *models.User) []string {
+	if user == nil {
+		return nil
+	}
+
+	inputs := []string{user.Username, user.Email, user.FirstName, user.LastName}
+	if local, _, ok := strings.Cut(user.Email, "@"); ok {
+		inputs = append(inputs, local)
+	}
+	return inputs
+}
+
+// Helper function to check if the password contains the username or email
+func containsUserInfo(password string, user *models.User) bool {
+	if user == nil {
+		return false
+	}
+
+	identifiers := []string{user.Username, user.Email}
+	if local, _, ok := strings.Cut(user.Email, "@"); ok {
+		identifiers = append(identifiers, local)
+	}
+
+	lower := strings.ToLower(password)
+	for _, identifier := range identifiers {
+		identifier = strings.ToLower(strings.TrimSpace(identifier))
+		if len([]rune(identifier)) >= 3 && strings.Contains(lower, identifier) {
+			return true
+		}
+	}
+	return false
+}
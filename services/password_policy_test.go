@@ -0,0 +1,108 @@
+// services/password_policy_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to get the violation codes from a Validate error
+func violationCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Validate: %v", err)
+	}
+	codes := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		codes[i] = v.Code
+	}
+	return codes
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	policy, err := NewPasswordPolicy(nil, NewPasswordPolicyConfig())
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy: %v", err)
+	}
+	user := &models.User{Username: "bjensen", Email: "barbara@example.com"}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"strong", "Tr0ub4dor&3-horse", nil},
+		{"too short and weak", "password", []string{PasswordTooShort, PasswordTooWeak}},
+		{"too long", "Tr0ub4dor&3-horse-" + strings.Repeat("x", 64), []string{PasswordTooLong}},
+		{"contains username", "bjensen-Tr0ub4dor&3", []string{PasswordContainsUserInfo}},
+		{"contains email name", "Tr0ub4dor&3-Barbara", []string{PasswordContainsUserInfo}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := violationCodes(t, policy.Validate(context.Background(), tt.password, user))
+			if len(got) != len(tt.want) {
+				t.Fatalf("violations = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("violations = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPasswordHistoryCountIncludesCurrentPassword(t *testing.T) {
+	pool := dbtest.New(t)
+	ctx := context.Background()
+	config := NewPasswordPolicyConfig()
+	config.HistoryCount = 3
+	policy, err := NewPasswordPolicy(pool, config)
+	if err != nil {
+		t.Fatalf("NewPasswordPolicy: %v", err)
+	}
+	repo := models.NewUserRepository(pool)
+	passwords := []string{"first-Tr0ub4dor&3", "second-Tr0ub4dor&3", "third-Tr0ub4dor&3", "fourth-Tr0ub4dor&3"}
+
+	user := &models.User{Username: "bjensen", Email: "bjensen@example.com", IsActive: true}
+	if err := repo.Create(ctx, user, passwords[0]); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	// Checks which of the passwords the policy counts as reused
+	checkReused := func(step string, want []bool) {
+		t.Helper()
+		current, err := repo.GetByID(ctx, user.UserID)
+		if err != nil {
+			t.Fatalf("GetByID: %v", err)
+		}
+		for i, password := range passwords {
+			codes := violationCodes(t, policy.Validate(ctx, password, current))
+			reused := len(codes) == 1 && codes[0] == PasswordPreviouslyUsed
+			if reused != want[i] || (!reused && len(codes) > 0) {
+				t.Errorf("%s: password %d violations = %v, want reused %v", step, i+1, codes, want[i])
+			}
+		}
+	}
+
+	for _, password := range passwords[1:3] {
+		if err := repo.UpdatePassword(ctx, user.UserID, password, policy.historyCount()); err != nil {
+			t.Fatalf("UpdatePassword: %v", err)
+		}
+	}
+	// The current password and the two before it make the last 3
+	checkReused("after three passwords", []bool{true, true, true, false})
+
+	if err := repo.UpdatePassword(ctx, user.UserID, passwords[3], policy.historyCount()); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	checkReused("after four passwords", []bool{false, true, true, true})
+}
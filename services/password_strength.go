@@ -0,0 +1,277 @@
+// services/password_strength.go
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Strength scores returned by EstimatePasswordStrength, on the same 0-4 scale as zxcvbn
+const (
+	StrengthTooGuessable = iota
+	StrengthVeryGuessable
+	StrengthSomewhatGuessable
+	StrengthSafelyUnguessable
+	StrengthVeryUnguessable
+)
+
+// Keyboard rows used to detect walks such as "qwerty" or "asdf"
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+// Frequently used passwords and words that are scored as a single dictionary guess
+var commonPasswords = wordSet(`
+	password passw0rd pass admin administrator root login welcome letmein
+	qwerty qwertyuiop asdf asdfgh zxcvbn abc abcd abcdef abc123 iloveyou
+	monkey dragon master shadow sunshine princess football baseball soccer
+	hockey batman superman trustno1 whatever secret hello freedom starwars
+	computer internet access changeme default guest user test tester
+	summer winter spring autumn love lovely michael jessica charlie
+	123456 1234567 12345678 123456789 1234567890 111111 000000 654321
+	666666 121212 112233 123123 696969 987654321 1q2w3e4r 1qaz2wsx
+	zaq12wsx qazwsx mustang jordan harley ranger buster thomas robert
+	daniel hunter killer pepper ginger cookie cheese banana orange
+	apple chocolate flower tigger purple matrix ninja azerty google
+`)
+
+// Number of bits a dictionary word match costs an attacker
+var dictionaryWordBits = math.Log2(float64(len(commonPasswords)) + 1)
+
+// Number of bits a recent year (1900-2099) costs an attacker
+var yearBits = math.Log2(200)
+
+// EstimatePasswordStrength returns a zxcvbn-style score from 0 (too guessable) to 4 (very unguessable).
+// userInputs are treated as dictionary words, so passwords built from the user's own details score lower.
+func EstimatePasswordStrength(password string, userInputs ...string) int {
+	if password == "" {
+		return StrengthTooGuessable
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := commonPasswords[lower]; ok {
+		return StrengthTooGuessable
+	}
+	if _, ok := commonPasswords[unleet(lower)]; ok {
+		return StrengthTooGuessable
+	}
+
+	return scoreFromBits(estimateEntropyBits(password, userInputs))
+}
+
+// Helper function to estimate the number of bits needed to guess a password
+func estimateEntropyBits(password string, userInputs []string) float64 {
+	runes := []rune(strings.ToLower(password))
+	bitsPerChar := math.Log2(float64(charsetSize(password)))
+
+	// Mark characters covered by dictionary words and user inputs
+	covered := make([]bool, len(runes))
+	bits := 0.0
+	normalized := []rune(unleet(string(runes)))
+	dictionary := make([]string, 0, len(commonPasswords)+len(userInputs))
+	for word := range commonPasswords {
+		dictionary = append(dictionary, word)
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if len([]rune(input)) >= 3 {
+			dictionary = append(dictionary, input)
+		}
+	}
+	// Longest words first, so "password" wins over "pass"
+	sort.Slice(dictionary, func(i, j int) bool {
+		if len(dictionary[i]) != len(dictionary[j]) {
+			return len(dictionary[i]) > len(dictionary[j])
+		}
+		return dictionary[i] < dictionary[j]
+	})
+	for _, word := range dictionary {
+		wordRunes := []rune(word)
+		if len(wordRunes) < 4 && !isUserInput(word, userInputs) {
+			continue
+		}
+		for start := 0; start+len(wordRunes) <= len(runes); start++ {
+			end := start + len(wordRunes)
+			if string(runes[start:end]) != word && string(normalized[start:end]) != word {
+				continue
+			}
+			if anyCovered(covered, start, len(wordRunes)) {
+				continue
+			}
+			for i := start; i < end; i++ {
+				covered[i] = true
+			}
+			// One guess from the dictionary, plus one bit for capitalisation variants
+			bits += dictionaryWordBits + 1
+		}
+	}
+
+	// Everything else is brute force, except years and runs of repeats, sequences and keyboard walks
+	for i := 0; i < len(runes); {
+		if covered[i] {
+			i++
+			continue
+		}
+		if isYear(runes, covered, i) {
+			bits += yearBits
+			i += 4
+			continue
+		}
+		if n := patternRunLength(runes, covered, i); n >= 3 {
+			bits += bitsPerChar + math.Log2(float64(n))
+			i += n
+			continue
+		}
+		bits += bitsPerChar
+		i++
+	}
+
+	return bits
+}
+
+// Helper function to map entropy bits onto the zxcvbn guess thresholds (10^3, 10^6, 10^8, 10^10)
+func scoreFromBits(bits float64) int {
+	guessesLog10 := bits * math.Log10(2)
+	switch {
+	case guessesLog10 < 3:
+		return StrengthTooGuessable
+	case guessesLog10 < 6:
+		return StrengthVeryGuessable
+	case guessesLog10 < 8:
+		return StrengthSomewhatGuessable
+	case guessesLog10 < 10:
+		return StrengthSafelyUnguessable
+	default:
+		return StrengthVeryUnguessable
+	}
+}
+
+// Helper function to size the character set an attacker would need to brute force
+func charsetSize(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	if size < 2 {
+		size = 2
+	}
+	return size
+}
+
+// Helper function to measure a run of repeated, sequential or keyboard-adjacent characters
+func patternRunLength(runes []rune, covered []bool, start int) int {
+	n := 1
+	for i := start + 1; i < len(runes) && !covered[i]; i++ {
+		prev, cur := runes[i-1], runes[i]
+		if cur == runes[start] && prev == runes[start] {
+			n++
+			continue
+		}
+		if delta := cur - prev; delta == 1 || delta == -1 {
+			n++
+			continue
+		}
+		if keyboardAdjacent(prev, cur) {
+			n++
+			continue
+		}
+		break
+	}
+	return n
+}
+
+// Helper function to check if four uncovered characters starting at start form a year such as 1987 or 2024
+func isYear(runes []rune, covered []bool, start int) bool {
+	if start+4 > len(runes) || anyCovered(covered, start, 4) {
+		return false
+	}
+	for _, r := range runes[start : start+4] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	prefix := string(runes[start : start+2])
+	return prefix == "19" || prefix == "20"
+}
+
+// Helper function to check if two characters are next to each other on a keyboard row
+func keyboardAdjacent(a, b rune) bool {
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, a)
+		j := strings.IndexRune(row, b)
+		if i >= 0 && j >= 0 && (i-j == 1 || j-i == 1) {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to undo common character substitutions such as "p@ssw0rd"
+func unleet(s string) string {
+	return strings.NewReplacer(
+		"@", "a", "4", "a", "3", "e", "1", "i", "!", "i",
+		"0", "o", "$", "s", "5", "s", "7", "t", "+", "t",
+	).Replace(s)
+}
+
+// Helper function to build a set from whitespace-separated words
+func wordSet(words string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, word := range strings.Fields(words) {
+		set[word] = struct{}{}
+	}
+	return set
+}
+
+// Helper function to check if any character in a range is already covered
+func anyCovered(covered []bool, start, length int) bool {
+	for i := start; i < start+length; i++ {
+		if covered[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to check if a dictionary word came from the user's own details
+func isUserInput(word string, userInputs []string) bool {
+	for _, input := range userInputs {
+		if strings.ToLower(strings.TrimSpace(input)) == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+// services/password_strength_test.go
+package services
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScoreFromBits(t *testing.T) {
+	// The zxcvbn thresholds are in guesses; convert them to bits to test either side of each
+	bitsFor := func(guessesLog10 float64) float64 { return guessesLog10 / math.Log10(2) }
+	tests := []struct {
+		name string
+		bits float64
+		want int
+	}{
+		{"no guesses", 0, StrengthTooGuessable},
+		{"just under 10^3 guesses", bitsFor(3) - 0.01, StrengthTooGuessable},
+		{"10^3 guesses", bitsFor(3), StrengthVeryGuessable},
+		{"just under 10^6 guesses", bitsFor(6) - 0.01, StrengthVeryGuessable},
+		{"10^6 guesses", bitsFor(6), StrengthSomewhatGuessable},
+		{"just under 10^8 guesses", bitsFor(8) - 0.01, StrengthSomewhatGuessable},
+		{"10^8 guesses", bitsFor(8), StrengthSafelyUnguessable},
+		{"just under 10^10 guesses", bitsFor(10) - 0.01, StrengthSafelyUnguessable},
+		{"10^10 guesses", bitsFor(10), StrengthVeryUnguessable},
+		{"far beyond 10^10 guesses", 128, StrengthVeryUnguessable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scoreFromBits(tt.bits); got != tt.want {
+				t.Errorf("scoreFromBits(%v) = %d, want %d", tt.bits, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	userInputs := []string{"bjensen", "bjensen@example.com", "Barbara", "Jensen"}
+	tests := []struct {
+		name       string
+		password   string
+		userInputs []string
+		want       int
+	}{
+		{"empty", "", nil, StrengthTooGuessable},
+		{"common password", "password", nil, StrengthTooGuessable},
+		{"common password in capitals", "PASSWORD", nil, StrengthTooGuessable},
+		{"common password with substitutions", "P@ssw0rd", nil, StrengthTooGuessable},
+		{"repeated character", "aaaaaaaaaaaa", nil, StrengthTooGuessable},
+		{"sequence", "abcdefgh", nil, StrengthVeryGuessable},
+		{"keyboard walk", "asdfghjkl;", nil, StrengthVeryGuessable},
+		{"repeated year", "19871987", nil, StrengthVeryGuessable},
+		{"word and year", "Summer2024", nil, StrengthVeryGuessable},
+		{"two dictionary words", "monkeydragon", nil, StrengthVeryGuessable},
+		{"four random characters", "Xb3#", nil, StrengthSomewhatGuessable},
+		{"five random characters", "Xb3#q", nil, StrengthSafelyUnguessable},
+		{"six random characters", "Xb3#q9", nil, StrengthVeryUnguessable},
+		{"passphrase", "correct horse battery staple", nil, StrengthVeryUnguessable},
+		{"username without user inputs", "bjensen1!", nil, StrengthVeryUnguessable},
+		{"username with user inputs", "bjensen1!", userInputs, StrengthVeryGuessable},
+		{"capitalised username with user inputs", "Bjensen!", userInputs, StrengthVeryGuessable},
+		{"unrelated password with user inputs", "Tr0ub4dor&3", userInputs, StrengthVeryUnguessable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimatePasswordStrength(tt.password, tt.userInputs...); got != tt.want {
+				t.Errorf("EstimatePasswordStrength(%q) = %d, want %d", tt.password, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,947 @@
+// services/saml.go
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrSAMLResponseInvalid   = errors.New("invalid SAML response")
+	ErrSAMLAssertionReplayed = errors.New("SAML assertion has already been used")
+	ErrSAMLLogoutInvalid     = errors.New("invalid SAML logout message")
+)
+
+// How long an identity provider's metadata is used before being fetched again
+const samlMetadataTTL = 24 * time.Hour
+
+// Largest metadata document or decoded message we accept from an identity provider
+const samlMaxMessageSize = 1 << 20
+
+// Signature algorithms accepted on redirect-binding messages from identity providers
+var samlQuerySignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	dsig.RSASHA256SignatureMethod:   x509.SHA256WithRSA,
+	dsig.RSASHA384SignatureMethod:   x509.SHA384WithRSA,
+	dsig.RSASHA512SignatureMethod:   x509.SHA512WithRSA,
+	dsig.ECDSASHA256SignatureMethod: x509.ECDSAWithSHA256,
+	dsig.ECDSASHA384SignatureMethod: x509.ECDSAWithSHA384,
+	dsig.ECDSASHA512SignatureMethod: x509.ECDSAWithSHA512,
+}
+
+var samlWhitespace = regexp.MustCompile(`\s+`)
+
+// SAMLAttributeMapping names the assertion attributes that fill in a user's details. Each
+// name is matched against an attribute's Name or FriendlyName.
+type SAMLAttributeMapping struct {
+	Subject   string // Attribute holding a stable ID for the user; empty uses the NameID
+	Username  string
+	Email     string // Falls back to the NameID when it is an email address
+	FirstName string
+	LastName  string
+	Groups    string
+}
+
+// SAMLProviderConfig holds the settings for signing in with a SAML 2.0 identity provider
+type SAMLProviderConfig struct {
+	Name        string // Short identifier used in URLs and stored on linked identities
+	DisplayName string // Shown on the sign-in button
+
+	// Our side. The metadata, assertion consumer and single logout endpoints live
+	// under BaseURL/saml/<name>/, and EntityID defaults to the metadata URL.
+	BaseURL      string
+	EntityID     string
+	NameIDFormat string
+	Key          *rsa.PrivateKey // Signs our requests and decrypts encrypted assertions
+	Certificate  *x509.Certificate
+
+	// The identity provider's metadata, either fetched from a URL or given directly
+	IDPMetadataURL string
+	IDPMetadataXML []byte
+
+	AllowIDPInitiated bool // Accept sign-ins started at the identity provider
+	AutoProvision     bool // Create an account on the first sign-in of an unknown user
+
+	Attributes SAMLAttributeMapping
+	GroupRoles map[string]string // Maps group names (case-insensitive) to roles
+}
+
+// NewSAMLProviderConfig creates a SAML provider configuration with default values
+func NewSAMLProviderConfig(name, baseURL string, key *rsa.PrivateKey, certificate *x509.Certificate) SAMLProviderConfig {
+	baseURL = strings.TrimRight(baseURL, "/")
+	return SAMLProviderConfig{
+		Name:          name,
+		DisplayName:   name,
+		BaseURL:       baseURL,
+		EntityID:      baseURL + "/saml/" + name + "/metadata",
+		NameIDFormat:  string(saml.PersistentNameIDFormat),
+		Key:           key,
+		Certificate:   certificate,
+		AutoProvision: true,
+		Attributes: SAMLAttributeMapping{
+			Username:  "uid",
+			Email:     "mail",
+			FirstName: "givenName",
+			LastName:  "sn",
+			Groups:    "memberOf",
+		},
+		GroupRoles: make(map[string]string),
+	}
+}
+
+// LoadSAMLKeyPair reads a PEM certificate and its RSA private key
+func LoadSAMLKeyPair(certFile, keyFile string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading SAML key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("SAML key must be an RSA key")
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing SAML certificate: %w", err)
+	}
+	return key, certificate, nil
+}
+
+// GenerateSAMLKeyPair creates a random 2048-bit RSA key with a self-signed certificate
+func GenerateSAMLKeyPair(commonName string) (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, certificate, nil
+}
+
+// A SAML identity provider and its metadata
+type samlProvider struct {
+	config SAMLProviderConfig
+
+	mu        sync.Mutex
+	metadata  *saml.EntityDescriptor
+	fetchedAt time.Time
+}
+
+// AddSAMLProvider enables signing in with a SAML identity provider. Metadata given as XML
+// is checked straight away; metadata from a URL is fetched on the first sign-in.
+func (s *AuthService) AddSAMLProvider(config SAMLProviderConfig) error {
+	if !externalProviderNamePattern.MatchString(config.Name) {
+		return fmt.Errorf("invalid SAML provider name %q", config.Name)
+	}
+	if _, ok := s.externalProviders[config.Name]; ok {
+		return fmt.Errorf("provider name %q is already used by an OpenID Connect provider", config.Name)
+	}
+	if config.BaseURL == "" || config.EntityID == "" || config.Key == nil || config.Certificate == nil {
+		return fmt.Errorf("SAML provider %s needs a base URL, entity ID, key and certificate", config.Name)
+	}
+
+	provider := &samlProvider{config: config}
+	switch {
+	case len(config.IDPMetadataXML) > 0:
+		metadata, err := parseSAMLMetadata(config.IDPMetadataXML)
+		if err != nil {
+			return fmt.Errorf("SAML provider %s: %w", config.Name, err)
+		}
+		provider.metadata = metadata
+	case config.IDPMetadataURL == "":
+		return fmt.Errorf("SAML provider %s needs identity provider metadata", config.Name)
+	}
+
+	if s.samlProviders == nil {
+		s.samlProviders = make(map[string]*samlProvider)
+	}
+	s.samlProviders[config.Name] = provider
+	return nil
+}
+
+// SAMLMetadata returns our service provider metadata for a SAML provider, for the
+// identity provider's administrator to import
+func (s *AuthService) SAMLMetadata(providerName string) ([]byte, error) {
+	provider, ok := s.samlProviders[providerName]
+	if !ok {
+		return nil, ErrExternalProviderNotFound
+	}
+
+	metadata, err := xml.MarshalIndent(provider.serviceProvider(nil).Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), metadata...), nil
+}
+
+// StartSAMLLogin begins a sign-in with a SAML identity provider. It returns the identity
+// provider URL to send the browser to and the relay state, which the client must keep and
+// present again with the response so that a response started in another browser is rejected.
+func (s *AuthService) StartSAMLLogin(ctx context.Context, providerName, returnTo string, rememberMe bool) (string, string, error) {
+	provider, ok := s.samlProviders[providerName]
+	if !ok {
+		return "", "", ErrExternalProviderNotFound
+	}
+
+	metadata, err := provider.getMetadata(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	sp := provider.serviceProvider(metadata)
+
+	destination := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if destination == "" {
+		return "", "", fmt.Errorf("SAML provider %s has no redirect sign-in endpoint", provider.config.Name)
+	}
+	authnRequest, err := sp.MakeAuthenticationRequest(destination, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := generatePrefixedToken("")
+	if err != nil {
+		return "", "", err
+	}
+
+	request := &models.SAMLRequest{
+		StateHash:  hashToken(state),
+		Provider:   provider.config.Name,
+		RequestID:  authnRequest.ID,
+		ReturnTo:   SafeReturnTo(returnTo),
+		RememberMe: rememberMe,
+		ExpiresAt:  time.Now().Add(ExternalLoginTTL),
+	}
+	if err := s.samlRepo.CreateRequest(ctx, request); err != nil {
+		return "", "", err
+	}
+
+	location, err := authnRequest.Redirect(state, sp)
+	if err != nil {
+		return "", "", err
+	}
+	return location.String(), state, nil
+}
+
+// SAMLMessage holds a SAML protocol message sent to one of our endpoints
+type SAMLMessage struct {
+	Provider      string
+	RawQuery      string     // Query string of a redirect-binding message, needed to check its signature
+	Form          url.Values // Form fields of a POST-binding message
+	ExpectedState string     // Relay state kept by the client since StartSAMLLogin
+	IPAddress     string
+	UserAgent     string
+	DeviceToken   string
+}
+
+// CompleteSAMLLogin checks the identity provider's response to a sign-in, either one we
+// started or, if the provider allows it, one started at the identity provider. The
+// assertion must be signed and can only be used once. The user is found by their linked
+// identity or provisioned, and then goes through the same device and risk checks as a
+// password login. It also returns the local path to send the user to afterwards.
+func (s *AuthService) CompleteSAMLLogin(ctx context.Context, msg SAMLMessage) (_ *LoginResult, _ string, err error) {
+	defer func() { s.recordLoginAttempt(loginMethodSAML, err) }()
+
+	provider, ok := s.samlProviders[msg.Provider]
+	if !ok {
+		return nil, "", ErrExternalProviderNotFound
+	}
+
+	relayState := msg.Form.Get("RelayState")
+	returnTo, rememberMe := SafeReturnTo(relayState), false
+
+	var possibleRequestIDs []string
+	if relayState != "" {
+		request, err := s.samlRepo.ConsumeRequest(ctx, hashToken(relayState))
+		if err != nil {
+			return nil, "", err
+		}
+		if request != nil && request.Provider == provider.config.Name {
+			if subtle.ConstantTimeCompare([]byte(relayState), []byte(msg.ExpectedState)) != 1 {
+				return nil, request.ReturnTo, fmt.Errorf("%w: relay state does not match this browser", ErrSAMLResponseInvalid)
+			}
+			possibleRequestIDs = []string{request.RequestID}
+			returnTo, rememberMe = request.ReturnTo, request.RememberMe
+		}
+	}
+	if possibleRequestIDs == nil && !provider.config.AllowIDPInitiated {
+		return nil, returnTo, fmt.Errorf("%w: unknown or expired relay state", ErrSAMLResponseInvalid)
+	}
+
+	assertion, err := provider.verifyResponse(ctx, msg.Form.Get("SAMLResponse"), possibleRequestIDs)
+	if err != nil {
+		return nil, returnTo, err
+	}
+
+	// Each assertion can only be used once, for as long as it would otherwise be accepted
+	accepted, err := s.samlRepo.RecordAssertion(ctx, provider.config.Name, assertion.ID, samlAssertionExpiry(assertion))
+	if err != nil {
+		return nil, returnTo, err
+	}
+	if !accepted {
+		return nil, returnTo, ErrSAMLAssertionReplayed
+	}
+
+	credentials, err := provider.credentials(assertion)
+	if err != nil {
+		return nil, returnTo, err
+	}
+	user, err := s.syncDirectoryUser(ctx, provider.config.Name, credentials, provider.config.AutoProvision, msg.IPAddress, msg.UserAgent)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, returnTo, ErrExternalAccountNotFound
+	}
+	if err != nil {
+		return nil, returnTo, err
+	}
+
+	if !user.IsActive {
+		return nil, returnTo, fmt.Errorf("%w: account is disabled", ErrExternalLoginFailed)
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, returnTo, ErrUserLocked
+	}
+
+	result, err := s.continueLogin(ctx, user, LoginRequest{
+		IPAddress:   msg.IPAddress,
+		UserAgent:   msg.UserAgent,
+		RememberMe:  rememberMe,
+		DeviceToken: msg.DeviceToken,
+	}, provider.config.Name)
+
+	// Remember the identity provider session so single logout can end ours
+	link := &models.SAMLSession{
+		Provider:     provider.config.Name,
+		NameID:       assertion.Subject.NameID.Value,
+		NameIDFormat: assertion.Subject.NameID.Format,
+	}
+	if len(assertion.AuthnStatements) > 0 {
+		link.SessionIndex = assertion.AuthnStatements[0].SessionIndex
+	}
+	var challengeErr *LoginChallengeError
+	switch {
+	case err == nil:
+		link.SessionID = &result.Session.SessionID
+	case errors.As(err, &challengeErr):
+		link.ChallengeID = &challengeErr.ChallengeID
+	default:
+		return nil, returnTo, err
+	}
+	if linkErr := s.samlRepo.CreateSessionLink(ctx, link); linkErr != nil {
+		// Just log this error, don't fail the login
+		slog.ErrorContext(ctx, "Error linking SAML session", "error", linkErr)
+	}
+
+	return result, returnTo, err
+}
+
+// SAMLLogoutRedirect returns the identity provider URL that ends the identity provider
+// session a session came from, so signing out of the app signs out everywhere. It returns
+// an empty string for sessions that did not come from a SAML sign-in.
+func (s *AuthService) SAMLLogoutRedirect(ctx context.Context, sessionID uuid.UUID, returnTo string) (string, error) {
+	link, err := s.samlRepo.GetSessionLink(ctx, sessionID)
+	if err != nil || link == nil {
+		return "", err
+	}
+	provider, ok := s.samlProviders[link.Provider]
+	if !ok {
+		return "", nil
+	}
+
+	metadata, err := provider.getMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	sp := provider.serviceProvider(metadata)
+
+	destination, _ := samlLogoutEndpoint(metadata)
+	if destination == "" {
+		return "", nil
+	}
+
+	requestID, err := samlMessageID()
+	if err != nil {
+		return "", err
+	}
+	request := &saml.LogoutRequest{
+		ID:           requestID,
+		Version:      "2.0",
+		IssueInstant: saml.TimeNow(),
+		Destination:  destination,
+		Issuer: &saml.Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.EntityID,
+		},
+		NameID: &saml.NameID{
+			Format: link.NameIDFormat,
+			Value:  link.NameID,
+		},
+	}
+	if link.SessionIndex != "" {
+		request.SessionIndex = &saml.SessionIndex{Value: link.SessionIndex}
+	}
+
+	return samlRedirectURL(sp, destination, "SAMLRequest", request.Element(), SafeReturnTo(returnTo))
+}
+
+// HandleSAMLLogout handles a message sent to our single logout endpoint. A LogoutRequest
+// from the identity provider invalidates the sessions that came from the identity provider
+// session it names and returns the URL that sends our LogoutResponse back. A LogoutResponse
+// to our own request returns the local path to send the user to.
+func (s *AuthService) HandleSAMLLogout(ctx context.Context, msg SAMLMessage) (string, error) {
+	provider, ok := s.samlProviders[msg.Provider]
+	if !ok {
+		return "", ErrExternalProviderNotFound
+	}
+
+	metadata, err := provider.getMetadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	sp := provider.serviceProvider(metadata)
+
+	if msg.Form.Get("SAMLResponse") != "" {
+		data, relayState, err := readSAMLLogoutMessage(metadata, msg, "SAMLResponse")
+		if err != nil {
+			return "", err
+		}
+		var response saml.LogoutResponse
+		if err := xml.Unmarshal(data, &response); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+		}
+		if err := checkSAMLLogoutMessage(sp, metadata, response.Issuer, response.Destination, response.IssueInstant); err != nil {
+			return "", err
+		}
+		if response.Status.StatusCode.Value != saml.StatusSuccess {
+			// Our session is already gone, so there is nothing more we can do
+			slog.WarnContext(ctx, "SAML provider did not complete single logout", "provider", provider.config.Name, "status", response.Status.StatusCode.Value)
+		}
+		return SafeReturnTo(relayState), nil
+	}
+
+	data, relayState, err := readSAMLLogoutMessage(metadata, msg, "SAMLRequest")
+	if err != nil {
+		return "", err
+	}
+	var request saml.LogoutRequest
+	if err := xml.Unmarshal(data, &request); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+	}
+	if err := checkSAMLLogoutMessage(sp, metadata, request.Issuer, request.Destination, request.IssueInstant); err != nil {
+		return "", err
+	}
+	if request.NotOnOrAfter != nil && request.NotOnOrAfter.Add(saml.MaxClockSkew).Before(time.Now()) {
+		return "", fmt.Errorf("%w: request has expired", ErrSAMLLogoutInvalid)
+	}
+	if request.NameID == nil || request.NameID.Value == "" {
+		return "", fmt.Errorf("%w: request does not name a subject", ErrSAMLLogoutInvalid)
+	}
+
+	sessionIndex := ""
+	if request.SessionIndex != nil {
+		sessionIndex = request.SessionIndex.Value
+	}
+	sessions, err := s.samlRepo.EndSessions(ctx, provider.config.Name, request.NameID.Value, sessionIndex)
+	if err != nil {
+		return "", err
+	}
+	s.metrics.SessionsRevoked(sessionRevokedSAMLLogout, len(sessions))
+
+	ended := make(map[uuid.UUID][]string)
+	for _, session := range sessions {
+		ended[session.UserID] = append(ended[session.UserID], session.SessionID.String())
+	}
+	for userID, sessionIDs := range ended {
+		auditLog := &models.AuditLog{
+			UserID:    userID,
+			EventType: "saml_logout",
+			IPAddress: msg.IPAddress,
+			UserAgent: msg.UserAgent,
+			Details: map[string]interface{}{
+				"provider":      provider.config.Name,
+				"session_index": sessionIndex,
+				"session_ids":   sessionIDs,
+			},
+		}
+		if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+			slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+		}
+	}
+
+	destination, responseDestination := samlLogoutEndpoint(metadata)
+	if destination == "" {
+		return "", nil
+	}
+	responseID, err := samlMessageID()
+	if err != nil {
+		return "", err
+	}
+	response := &saml.LogoutResponse{
+		ID:           responseID,
+		InResponseTo: request.ID,
+		Version:      "2.0",
+		IssueInstant: saml.TimeNow(),
+		Destination:  responseDestination,
+		Issuer: &saml.Issuer{
+			Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+			Value:  sp.EntityID,
+		},
+		Status: saml.Status{
+			StatusCode: saml.StatusCode{Value: saml.StatusSuccess},
+		},
+	}
+	return samlRedirectURL(sp, responseDestination, "SAMLResponse", response.Element(), relayState)
+}
+
+// CleanupExpiredSAMLState removes unanswered requests and assertions that can no longer be replayed
+func (s *AuthService) CleanupExpiredSAMLState(ctx context.Context) (int64, error) {
+	return s.samlRepo.DeleteExpired(ctx, time.Now())
+}
+
+// Builds the crewjam service provider for this provider; metadata may be nil when only
+// our own side is needed
+func (p *samlProvider) serviceProvider(metadata *saml.EntityDescriptor) *saml.ServiceProvider {
+	base := p.config.BaseURL + "/saml/" + p.config.Name
+	metadataURL, _ := url.Parse(base + "/metadata")
+	acsURL, _ := url.Parse(base + "/acs")
+	sloURL, _ := url.Parse(base + "/slo")
+
+	return &saml.ServiceProvider{
+		EntityID:          p.config.EntityID,
+		Key:               p.config.Key,
+		Certificate:       p.config.Certificate,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		SloURL:            *sloURL,
+		IDPMetadata:       metadata,
+		AuthnNameIDFormat: saml.NameIDFormat(p.config.NameIDFormat),
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+		LogoutBindings:    []string{saml.HTTPRedirectBinding, saml.HTTPPostBinding},
+	}
+}
+
+// Returns the identity provider's metadata, fetching it if it is missing or old. If a
+// refetch fails the metadata we already have is kept.
+func (p *samlProvider) getMetadata(ctx context.Context) (*saml.EntityDescriptor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil && (p.config.IDPMetadataURL == "" || time.Since(p.fetchedAt) < samlMetadataTTL) {
+		return p.metadata, nil
+	}
+
+	metadata, err := fetchSAMLMetadata(ctx, p.config.IDPMetadataURL)
+	if err != nil {
+		if p.metadata != nil {
+			slog.ErrorContext(ctx, "Error refreshing SAML metadata, keeping the old copy", "provider", p.config.Name, "error", err)
+			return p.metadata, nil
+		}
+		return nil, err
+	}
+
+	p.metadata = metadata
+	p.fetchedAt = time.Now()
+	return metadata, nil
+}
+
+// Checks a base64-encoded response to one of the given requests, or an unsolicited one
+// when there are none, and returns its signed assertion. Replays are not caught here.
+func (p *samlProvider) verifyResponse(ctx context.Context, samlResponse string, possibleRequestIDs []string) (*saml.Assertion, error) {
+	metadata, err := p.getMetadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sp := p.serviceProvider(metadata)
+	sp.AllowIDPInitiated = possibleRequestIDs == nil
+	sp.ValidateRequestID = func(response saml.Response, requestIDs []string) error {
+		// Unsolicited responses must not claim to answer a request we no longer know about
+		if len(requestIDs) == 0 && response.InResponseTo == "" {
+			return nil
+		}
+		if !containsString(requestIDs, response.InResponseTo) {
+			return fmt.Errorf("InResponseTo %q does not match the request", response.InResponseTo)
+		}
+		return nil
+	}
+
+	encoded, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSAMLResponseInvalid, err)
+	}
+	assertion, err := sp.ParseXMLResponse(encoded, possibleRequestIDs, sp.AcsURL)
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrSAMLResponseInvalid, err)
+	}
+	return assertion, nil
+}
+
+// Reads the user's details from a verified assertion
+func (p *samlProvider) credentials(assertion *saml.Assertion) (*VerifiedCredentials, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, fmt.Errorf("%w: assertion has no subject", ErrSAMLResponseInvalid)
+	}
+	nameID := assertion.Subject.NameID
+
+	mapping := p.config.Attributes
+	credentials := &VerifiedCredentials{
+		Subject:   nameID.Value,
+		Username:  samlAttribute(assertion, mapping.Username),
+		Email:     samlAttribute(assertion, mapping.Email),
+		FirstName: samlAttribute(assertion, mapping.FirstName),
+		LastName:  samlAttribute(assertion, mapping.LastName),
+	}
+	if mapping.Subject != "" {
+		if credentials.Subject = samlAttribute(assertion, mapping.Subject); credentials.Subject == "" {
+			return nil, fmt.Errorf("%w: assertion has no %s attribute", ErrSAMLResponseInvalid, mapping.Subject)
+		}
+	} else if nameID.Format == string(saml.TransientNameIDFormat) {
+		return nil, fmt.Errorf("%w: transient NameID cannot identify a user; map a subject attribute", ErrSAMLResponseInvalid)
+	}
+	if credentials.Email == "" && nameID.Format == string(saml.EmailAddressNameIDFormat) {
+		credentials.Email = nameID.Value
+	}
+
+	for _, role := range p.config.GroupRoles {
+		if !containsString(credentials.ManagedRoles, role) {
+			credentials.ManagedRoles = append(credentials.ManagedRoles, role)
+		}
+	}
+	for _, group := range samlAttributeValues(assertion, mapping.Groups) {
+		for name, role := range p.config.GroupRoles {
+			if strings.EqualFold(name, group) && !containsString(credentials.Roles, role) {
+				credentials.Roles = append(credentials.Roles, role)
+			}
+		}
+	}
+
+	return credentials, nil
+}
+
+// Returns the first value of an assertion attribute, matched by Name or FriendlyName
+func samlAttribute(assertion *saml.Assertion, name string) string {
+	if values := samlAttributeValues(assertion, name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Returns every value of an assertion attribute, matched by Name or FriendlyName
+func samlAttributeValues(assertion *saml.Assertion, name string) []string {
+	if name == "" {
+		return nil
+	}
+	var values []string
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if attribute.Name != name && attribute.FriendlyName != name {
+				continue
+			}
+			for _, value := range attribute.Values {
+				if value := strings.TrimSpace(value.Value); value != "" {
+					values = append(values, value)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// Works out how long an assertion must be remembered to stop it being replayed: until
+// it is too old to be accepted at all
+func samlAssertionExpiry(assertion *saml.Assertion) time.Time {
+	expiresAt := assertion.IssueInstant.Add(saml.MaxIssueDelay)
+	if assertion.Conditions != nil && assertion.Conditions.NotOnOrAfter.After(expiresAt) {
+		expiresAt = assertion.Conditions.NotOnOrAfter
+	}
+	return expiresAt.Add(saml.MaxClockSkew)
+}
+
+// Fetches and parses an identity provider's metadata
+func fetchSAMLMetadata(ctx context.Context, endpoint string) (*saml.EntityDescriptor, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := externalHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", endpoint, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, samlMaxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseSAMLMetadata(data)
+}
+
+// Parses identity provider metadata, which may be wrapped in an EntitiesDescriptor
+func parseSAMLMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err == nil && len(entity.IDPSSODescriptors) > 0 {
+		return &entity, nil
+	}
+
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err != nil {
+		return nil, fmt.Errorf("invalid SAML metadata: %w", err)
+	}
+	for i := range entities.EntityDescriptors {
+		if len(entities.EntityDescriptors[i].IDPSSODescriptors) > 0 {
+			return &entities.EntityDescriptors[i], nil
+		}
+	}
+	return nil, errors.New("SAML metadata does not describe an identity provider")
+}
+
+// Returns the identity provider's redirect-binding single logout endpoint, and the
+// location logout responses go to
+func samlLogoutEndpoint(metadata *saml.EntityDescriptor) (string, string) {
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, endpoint := range descriptor.SingleLogoutServices {
+			if endpoint.Binding != saml.HTTPRedirectBinding {
+				continue
+			}
+			if endpoint.ResponseLocation != "" {
+				return endpoint.Location, endpoint.ResponseLocation
+			}
+			return endpoint.Location, endpoint.Location
+		}
+	}
+	return "", ""
+}
+
+// Returns the certificates the identity provider signs with
+func samlSigningCertificates(metadata *saml.EntityDescriptor) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for _, descriptor := range metadata.IDPSSODescriptors {
+		for _, keyDescriptor := range descriptor.KeyDescriptors {
+			if keyDescriptor.Use != "" && keyDescriptor.Use != "signing" {
+				continue
+			}
+			for _, data := range keyDescriptor.KeyInfo.X509Data.X509Certificates {
+				der, err := base64.StdEncoding.DecodeString(samlWhitespace.ReplaceAllString(data.Data, ""))
+				if err != nil {
+					return nil, fmt.Errorf("invalid certificate in SAML metadata: %w", err)
+				}
+				certificate, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("invalid certificate in SAML metadata: %w", err)
+				}
+				certificates = append(certificates, certificate)
+			}
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, errors.New("SAML metadata has no signing certificate")
+	}
+	return certificates, nil
+}
+
+// Decodes a logout message in either binding and checks the identity provider signed it:
+// over the query string for the redirect binding, or inside the XML for the POST binding.
+// It returns the message XML and the relay state.
+func readSAMLLogoutMessage(metadata *saml.EntityDescriptor, msg SAMLMessage, parameter string) ([]byte, string, error) {
+	certificates, err := samlSigningCertificates(metadata)
+	if err != nil {
+		return nil, "", err
+	}
+
+	raw := make(map[string]string)
+	for _, pair := range strings.Split(msg.RawQuery, "&") {
+		if name, value, ok := strings.Cut(pair, "="); ok {
+			if _, seen := raw[name]; !seen {
+				raw[name] = value
+			}
+		}
+	}
+
+	if raw[parameter] != "" {
+		// The signature covers the parameters exactly as they were encoded by the sender
+		signed := parameter + "=" + raw[parameter]
+		if relayState, ok := raw["RelayState"]; ok {
+			signed += "&RelayState=" + relayState
+		}
+		signed += "&SigAlg=" + raw["SigAlg"]
+
+		sigAlg, _ := url.QueryUnescape(raw["SigAlg"])
+		algorithm, ok := samlQuerySignatureAlgorithms[sigAlg]
+		if !ok {
+			return nil, "", fmt.Errorf("%w: unsupported or missing signature algorithm %q", ErrSAMLLogoutInvalid, sigAlg)
+		}
+		encodedSignature, _ := url.QueryUnescape(raw["Signature"])
+		signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+		if err != nil || len(signature) == 0 {
+			return nil, "", fmt.Errorf("%w: missing signature", ErrSAMLLogoutInvalid)
+		}
+		verified := false
+		for _, certificate := range certificates {
+			if certificate.CheckSignature(algorithm, []byte(signed), signature) == nil {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, "", fmt.Errorf("%w: bad signature", ErrSAMLLogoutInvalid)
+		}
+
+		value, _ := url.QueryUnescape(raw[parameter])
+		compressed, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), samlMaxMessageSize))
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+		}
+		if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+		}
+		relayState, _ := url.QueryUnescape(raw["RelayState"])
+		return data, relayState, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(msg.Form.Get(parameter))
+	if err != nil || len(data) == 0 {
+		return nil, "", fmt.Errorf("%w: missing %s", ErrSAMLLogoutInvalid, parameter)
+	}
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil || doc.Root() == nil {
+		return nil, "", fmt.Errorf("%w: unreadable XML", ErrSAMLLogoutInvalid)
+	}
+
+	// Only read what the signature covers, so nothing can be slipped in beside it
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certificates})
+	signedElement, err := validator.Validate(doc.Root())
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrSAMLLogoutInvalid, err)
+	}
+	signedDoc := etree.NewDocument()
+	signedDoc.SetRoot(signedElement)
+	if data, err = signedDoc.WriteToBytes(); err != nil {
+		return nil, "", err
+	}
+	return data, msg.Form.Get("RelayState"), nil
+}
+
+// Checks the fields shared by logout requests and responses from the identity provider
+func checkSAMLLogoutMessage(sp *saml.ServiceProvider, metadata *saml.EntityDescriptor, issuer *saml.Issuer, destination string, issueInstant time.Time) error {
+	if issuer == nil || issuer.Value != metadata.EntityID {
+		return fmt.Errorf("%w: issuer is not %q", ErrSAMLLogoutInvalid, metadata.EntityID)
+	}
+	if destination != "" && destination != sp.SloURL.String() {
+		return fmt.Errorf("%w: destination is not %q", ErrSAMLLogoutInvalid, sp.SloURL.String())
+	}
+	now := time.Now()
+	if issueInstant.Add(saml.MaxIssueDelay).Before(now) || issueInstant.Add(-saml.MaxClockSkew).After(now) {
+		return fmt.Errorf("%w: issued at %s", ErrSAMLLogoutInvalid, issueInstant)
+	}
+	return nil
+}
+
+// Encodes a message for the redirect binding and signs the query string
+func samlRedirectURL(sp *saml.ServiceProvider, destination, parameter string, message *etree.Element, relayState string) (string, error) {
+	var encoded bytes.Buffer
+	encoder := base64.NewEncoder(base64.StdEncoding, &encoded)
+	compressor, err := flate.NewWriter(encoder, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(message)
+	if _, err := doc.WriteTo(compressor); err != nil {
+		return "", err
+	}
+	if err := compressor.Close(); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+
+	query := parameter + "=" + url.QueryEscape(encoded.String())
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(sp.SignatureMethod)
+
+	signingContext, err := saml.GetSigningContext(sp)
+	if err != nil {
+		return "", err
+	}
+	signature, err := signingContext.SignString(query)
+	if err != nil {
+		return "", err
+	}
+	query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature))
+
+	separator := "?"
+	if strings.Contains(destination, "?") {
+		separator = "&"
+	}
+	return destination + separator + query, nil
+}
+
+// Generates an ID for a SAML message; IDs must not start with a digit
+func samlMessageID() (string, error) {
+	var id [20]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return "id-" + hex.EncodeToString(id[:]), nil
+}
@@ -0,0 +1,488 @@
+// services/saml_test.go
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/jackc/pgx/v4/pgxpool"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+)
+
+// Where the test service provider, named corp, receives assertions and what it calls itself
+const (
+	samlTestACS      = "https://app.example.com/saml/corp/acs"
+	samlTestEntityID = "https://app.example.com/saml/corp/metadata"
+)
+
+// samlTestIdP is an identity provider with a self-signed certificate that builds signed
+// responses the way a real one would
+type samlTestIdP struct {
+	entityID string
+	key      *rsa.PrivateKey
+	cert     *x509.Certificate
+}
+
+// Helper function to create an identity provider with a fresh self-signed certificate
+func newSAMLTestIdP(t *testing.T) *samlTestIdP {
+	t.Helper()
+	key, cert, err := GenerateSAMLKeyPair("idp.example.com")
+	if err != nil {
+		t.Fatalf("GenerateSAMLKeyPair: %v", err)
+	}
+	return &samlTestIdP{entityID: "https://idp.example.com/metadata", key: key, cert: cert}
+}
+
+// Helper function to build the identity provider's metadata
+func (idp *samlTestIdP) metadata(t *testing.T) []byte {
+	t.Helper()
+	descriptor := saml.IDPSSODescriptor{
+		SSODescriptor: saml.SSODescriptor{
+			RoleDescriptor: saml.RoleDescriptor{
+				ProtocolSupportEnumeration: "urn:oasis:names:tc:SAML:2.0:protocol",
+				KeyDescriptors: []saml.KeyDescriptor{{
+					Use: "signing",
+					KeyInfo: saml.KeyInfo{X509Data: saml.X509Data{X509Certificates: []saml.X509Certificate{
+						{Data: base64.StdEncoding.EncodeToString(idp.cert.Raw)},
+					}}},
+				}},
+			},
+		},
+		SingleSignOnServices: []saml.Endpoint{{Binding: saml.HTTPRedirectBinding, Location: "https://idp.example.com/sso"}},
+	}
+	data, err := xml.Marshal(saml.EntityDescriptor{EntityID: idp.entityID, IDPSSODescriptors: []saml.IDPSSODescriptor{descriptor}})
+	if err != nil {
+		t.Fatalf("marshalling metadata: %v", err)
+	}
+	return data
+}
+
+// Helper function to build a successful response for bjensen, answering the given request
+// or unsolicited when it is empty. The edit function can change the response and assertion
+// before the assertion is signed.
+func (idp *samlTestIdP) response(t *testing.T, inResponseTo string, edit func(*saml.Response, *saml.Assertion)) *etree.Element {
+	t.Helper()
+	now := time.Now().UTC().Truncate(time.Second)
+	response := &saml.Response{
+		ID:           "id-" + mustRandomToken(t),
+		InResponseTo: inResponseTo,
+		Version:      "2.0",
+		IssueInstant: now,
+		Destination:  samlTestACS,
+		Issuer:       &saml.Issuer{Value: idp.entityID},
+		Status:       saml.Status{StatusCode: saml.StatusCode{Value: saml.StatusSuccess}},
+	}
+	assertion := &saml.Assertion{
+		ID:           "id-" + mustRandomToken(t),
+		IssueInstant: now,
+		Version:      "2.0",
+		Issuer:       saml.Issuer{Value: idp.entityID},
+		Subject: &saml.Subject{
+			NameID: &saml.NameID{Format: string(saml.PersistentNameIDFormat), Value: "a1b2c3d4"},
+			SubjectConfirmations: []saml.SubjectConfirmation{{
+				Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+				SubjectConfirmationData: &saml.SubjectConfirmationData{
+					InResponseTo: inResponseTo,
+					Recipient:    samlTestACS,
+					NotOnOrAfter: now.Add(5 * time.Minute),
+				},
+			}},
+		},
+		Conditions: &saml.Conditions{
+			NotBefore:            now.Add(-time.Minute),
+			NotOnOrAfter:         now.Add(5 * time.Minute),
+			AudienceRestrictions: []saml.AudienceRestriction{{Audience: saml.Audience{Value: samlTestEntityID}}},
+		},
+		AuthnStatements: []saml.AuthnStatement{{AuthnInstant: now, SessionIndex: "session-1"}},
+		AttributeStatements: []saml.AttributeStatement{{Attributes: []saml.Attribute{
+			{Name: "uid", Values: []saml.AttributeValue{{Value: "bjensen"}}},
+			{Name: "mail", Values: []saml.AttributeValue{{Value: "bjensen@example.com"}}},
+		}}},
+	}
+	if edit != nil {
+		edit(response, assertion)
+	}
+
+	signingContext := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(tls.Certificate{
+		Certificate: [][]byte{idp.cert.Raw},
+		PrivateKey:  idp.key,
+	}))
+	signingContext.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if err := signingContext.SetSignatureMethod(dsig.RSASHA256SignatureMethod); err != nil {
+		t.Fatalf("SetSignatureMethod: %v", err)
+	}
+	signed, err := signingContext.SignEnveloped(assertion.Element())
+	if err != nil {
+		t.Fatalf("SignEnveloped: %v", err)
+	}
+
+	el := response.Element()
+	el.AddChild(signed)
+	return el
+}
+
+// Helper function to encode a response the way the POST binding carries it
+func encodeSAMLResponse(t *testing.T, response *etree.Element) string {
+	t.Helper()
+	doc := etree.NewDocument()
+	doc.SetRoot(response)
+	data, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatalf("writing response: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// Helper function to strip the signature from an element. The signer does not set the
+// signature's parent, so etree's RemoveChild cannot be used.
+func removeSAMLSignature(el *etree.Element) {
+	for i, token := range el.Child {
+		if child, ok := token.(*etree.Element); ok && child.Tag == "Signature" {
+			el.RemoveChildAt(i)
+			return
+		}
+	}
+}
+
+// Helper function to create a service with a corp SAML provider trusting the identity
+// provider. The pool may be nil when only responses are checked.
+func newSAMLTestService(t *testing.T, pool *pgxpool.Pool, idp *samlTestIdP, configure func(*SAMLProviderConfig)) *AuthService {
+	t.Helper()
+	key, cert, err := GenerateSAMLKeyPair("app.example.com")
+	if err != nil {
+		t.Fatalf("GenerateSAMLKeyPair: %v", err)
+	}
+	config := NewSAMLProviderConfig("corp", "https://app.example.com/", key, cert)
+	config.IDPMetadataXML = idp.metadata(t)
+	if configure != nil {
+		configure(&config)
+	}
+
+	s := NewAuthService(pool, "test-secret", 60)
+	if err := s.AddSAMLProvider(config); err != nil {
+		t.Fatalf("AddSAMLProvider: %v", err)
+	}
+	return s
+}
+
+// Helper function to make a random ID
+func mustRandomToken(t *testing.T) string {
+	t.Helper()
+	token, err := generatePrefixedToken("")
+	if err != nil {
+		t.Fatalf("generatePrefixedToken: %v", err)
+	}
+	return token
+}
+
+func TestSAMLResponseValidation(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	s := newSAMLTestService(t, nil, idp, nil)
+	provider := s.samlProviders["corp"]
+	const requestID = "id-request-1"
+
+	// Another identity provider claiming to be ours, with its own self-signed certificate
+	forger := newSAMLTestIdP(t)
+
+	tests := []struct {
+		name       string
+		response   func(t *testing.T) *etree.Element
+		wantNameID string // Empty when the response must be rejected
+	}{
+		{
+			name:       "signed assertion",
+			response:   func(t *testing.T) *etree.Element { return idp.response(t, requestID, nil) },
+			wantNameID: "a1b2c3d4",
+		},
+		{
+			name: "unsigned assertion",
+			response: func(t *testing.T) *etree.Element {
+				response := idp.response(t, requestID, nil)
+				removeSAMLSignature(response.SelectElement("Assertion"))
+				return response
+			},
+		},
+		{
+			name:     "signed with another key",
+			response: func(t *testing.T) *etree.Element { return forger.response(t, requestID, nil) },
+		},
+		{
+			name: "changed after signing",
+			response: func(t *testing.T) *etree.Element {
+				response := idp.response(t, requestID, nil)
+				response.FindElement("./Assertion/Subject/NameID").SetText("admin")
+				return response
+			},
+		},
+		{
+			name: "wrong audience",
+			response: func(t *testing.T) *etree.Element {
+				return idp.response(t, requestID, func(_ *saml.Response, assertion *saml.Assertion) {
+					assertion.Conditions.AudienceRestrictions[0].Audience.Value = "https://other.example.com/metadata"
+				})
+			},
+		},
+		{
+			name: "wrong destination",
+			response: func(t *testing.T) *etree.Element {
+				return idp.response(t, requestID, func(response *saml.Response, _ *saml.Assertion) {
+					response.Destination = "https://other.example.com/saml/acs"
+				})
+			},
+		},
+		{
+			name: "wrong recipient",
+			response: func(t *testing.T) *etree.Element {
+				return idp.response(t, requestID, func(_ *saml.Response, assertion *saml.Assertion) {
+					assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.Recipient = "https://other.example.com/saml/acs"
+				})
+			},
+		},
+		{
+			name: "expired conditions",
+			response: func(t *testing.T) *etree.Element {
+				return idp.response(t, requestID, func(_ *saml.Response, assertion *saml.Assertion) {
+					assertion.Conditions.NotOnOrAfter = time.Now().Add(-10 * time.Minute)
+				})
+			},
+		},
+		{
+			name: "expired subject confirmation",
+			response: func(t *testing.T) *etree.Element {
+				return idp.response(t, requestID, func(_ *saml.Response, assertion *saml.Assertion) {
+					assertion.Subject.SubjectConfirmations[0].SubjectConfirmationData.NotOnOrAfter = time.Now().Add(-10 * time.Minute)
+				})
+			},
+		},
+		{
+			name:     "answer to another request",
+			response: func(t *testing.T) *etree.Element { return idp.response(t, "id-request-2", nil) },
+		},
+		{
+			// The signature is copied onto a forged assertion with the same ID, and the
+			// signed original is hidden inside it
+			name: "signature wrapped around a forged assertion",
+			response: func(t *testing.T) *etree.Element {
+				response := idp.response(t, requestID, nil)
+				original := response.SelectElement("Assertion")
+				forged := original.Copy()
+				forged.FindElement("./Subject/NameID").SetText("admin")
+				forged.SelectElement("Signature").CreateElement("ds:Object").AddChild(original)
+				response.AddChild(forged)
+				return response
+			},
+		},
+		{
+			// An unsigned forged assertion takes the original's place, and the signed
+			// original is moved somewhere the signature still finds it by ID
+			name: "signed assertion moved into extensions",
+			response: func(t *testing.T) *etree.Element {
+				response := idp.response(t, requestID, nil)
+				original := response.SelectElement("Assertion")
+				forged := original.Copy()
+				removeSAMLSignature(forged)
+				forged.FindElement("./Subject/NameID").SetText("admin")
+				response.RemoveChild(original)
+				response.InsertChildAt(1, etree.NewElement("samlp:Extensions"))
+				response.SelectElement("Extensions").AddChild(original)
+				response.AddChild(forged)
+				return response
+			},
+		},
+		{
+			// A forged assertion ahead of the signed one is skipped rather than used
+			name: "forged assertion before the signed one",
+			response: func(t *testing.T) *etree.Element {
+				response := idp.response(t, requestID, nil)
+				original := response.SelectElement("Assertion")
+				forged := original.Copy()
+				removeSAMLSignature(forged)
+				forged.CreateAttr("ID", "id-forged")
+				forged.FindElement("./Subject/NameID").SetText("admin")
+				response.InsertChildAt(original.Index(), forged)
+				return response
+			},
+			wantNameID: "a1b2c3d4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeSAMLResponse(t, tt.response(t))
+			assertion, err := provider.verifyResponse(context.Background(), encoded, []string{requestID})
+			if tt.wantNameID == "" {
+				if !errors.Is(err, ErrSAMLResponseInvalid) {
+					t.Errorf("err = %v, want ErrSAMLResponseInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyResponse: %v", err)
+			}
+			if got := assertion.Subject.NameID.Value; got != tt.wantNameID {
+				t.Errorf("NameID = %q, want %q", got, tt.wantNameID)
+			}
+		})
+	}
+}
+
+func TestSAMLUnsolicitedResponse(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	provider := newSAMLTestService(t, nil, idp, nil).samlProviders["corp"]
+
+	encoded := encodeSAMLResponse(t, idp.response(t, "", nil))
+	if _, err := provider.verifyResponse(context.Background(), encoded, nil); err != nil {
+		t.Errorf("unsolicited response: %v", err)
+	}
+
+	// Without a request to match, a response may not claim to answer one
+	encoded = encodeSAMLResponse(t, idp.response(t, "id-request-1", nil))
+	if _, err := provider.verifyResponse(context.Background(), encoded, nil); !errors.Is(err, ErrSAMLResponseInvalid) {
+		t.Errorf("unknown InResponseTo: err = %v, want ErrSAMLResponseInvalid", err)
+	}
+
+	if _, err := provider.verifyResponse(context.Background(), "not base64!", nil); !errors.Is(err, ErrSAMLResponseInvalid) {
+		t.Errorf("bad encoding: err = %v, want ErrSAMLResponseInvalid", err)
+	}
+}
+
+func TestSAMLLoginRequiresIDPInitiatedSetting(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	s := newSAMLTestService(t, nil, idp, nil)
+
+	_, _, err := s.CompleteSAMLLogin(context.Background(), SAMLMessage{
+		Provider: "corp",
+		Form:     url.Values{"SAMLResponse": {encodeSAMLResponse(t, idp.response(t, "", nil))}},
+	})
+	if !errors.Is(err, ErrSAMLResponseInvalid) {
+		t.Errorf("err = %v, want ErrSAMLResponseInvalid", err)
+	}
+}
+
+// Helper function to start a sign-in and read the ID of the request sent to the identity provider
+func startSAMLTestLogin(t *testing.T, s *AuthService, returnTo string) (string, string) {
+	t.Helper()
+	location, state, err := s.StartSAMLLogin(context.Background(), "corp", returnTo, false)
+	if err != nil {
+		t.Fatalf("StartSAMLLogin: %v", err)
+	}
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", location, err)
+	}
+	if got := redirect.Query().Get("RelayState"); got != state {
+		t.Errorf("RelayState = %q, want %q", got, state)
+	}
+
+	deflated, err := base64.StdEncoding.DecodeString(redirect.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatalf("decoding SAMLRequest: %v", err)
+	}
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("inflating SAMLRequest: %v", err)
+	}
+	var request saml.AuthnRequest
+	if err := xml.Unmarshal(data, &request); err != nil {
+		t.Fatalf("parsing SAMLRequest: %v", err)
+	}
+	return request.ID, state
+}
+
+// Helper function to post a response to the assertion consumer service
+func completeSAMLTestLogin(s *AuthService, response, state string) (*LoginResult, string, error) {
+	form := url.Values{"SAMLResponse": {response}}
+	if state != "" {
+		form.Set("RelayState", state)
+	}
+	return s.CompleteSAMLLogin(context.Background(), SAMLMessage{
+		Provider:      "corp",
+		Form:          form,
+		ExpectedState: state,
+		IPAddress:     "192.0.2.1",
+		UserAgent:     "saml-test",
+	})
+}
+
+func TestSAMLLoginProvisionsUser(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	s := newSAMLTestService(t, dbtest.New(t), idp, nil)
+
+	requestID, state := startSAMLTestLogin(t, s, "/dashboard")
+	response := encodeSAMLResponse(t, idp.response(t, requestID, nil))
+	result, returnTo, err := completeSAMLTestLogin(s, response, state)
+	if err != nil {
+		t.Fatalf("CompleteSAMLLogin: %v", err)
+	}
+	if returnTo != "/dashboard" {
+		t.Errorf("returnTo = %q, want /dashboard", returnTo)
+	}
+	user, err := s.userRepo.GetByID(context.Background(), result.Session.UserID)
+	if err != nil || user == nil {
+		t.Fatalf("GetByID: %v, %v", user, err)
+	}
+	if user.Username != "bjensen" || user.Email != "bjensen@example.com" {
+		t.Errorf("provisioned user = %+v, want the assertion's details", user)
+	}
+
+	// The relay state is used up, so posting the same response again is refused
+	if _, _, err := completeSAMLTestLogin(s, response, state); !errors.Is(err, ErrSAMLResponseInvalid) {
+		t.Errorf("resubmitted: err = %v, want ErrSAMLResponseInvalid", err)
+	}
+}
+
+func TestSAMLLoginRejectsRelayStateFromAnotherBrowser(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	s := newSAMLTestService(t, dbtest.New(t), idp, nil)
+
+	requestID, state := startSAMLTestLogin(t, s, "/")
+	_, _, err := s.CompleteSAMLLogin(context.Background(), SAMLMessage{
+		Provider:      "corp",
+		Form:          url.Values{"SAMLResponse": {encodeSAMLResponse(t, idp.response(t, requestID, nil))}, "RelayState": {state}},
+		ExpectedState: "another-browser",
+	})
+	if !errors.Is(err, ErrSAMLResponseInvalid) {
+		t.Errorf("err = %v, want ErrSAMLResponseInvalid", err)
+	}
+}
+
+func TestSAMLLoginRejectsReplayedAssertion(t *testing.T) {
+	idp := newSAMLTestIdP(t)
+	s := newSAMLTestService(t, dbtest.New(t), idp, func(config *SAMLProviderConfig) {
+		config.AllowIDPInitiated = true
+	})
+
+	const assertionID = "id-assertion-1"
+	response := encodeSAMLResponse(t, idp.response(t, "", func(_ *saml.Response, assertion *saml.Assertion) {
+		assertion.ID = assertionID
+	}))
+	if _, _, err := completeSAMLTestLogin(s, response, ""); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, _, err := completeSAMLTestLogin(s, response, ""); !errors.Is(err, ErrSAMLAssertionReplayed) {
+		t.Errorf("same response again: err = %v, want ErrSAMLAssertionReplayed", err)
+	}
+
+	// A new, correctly signed response answering a real request still cannot reuse the ID
+	requestID, state := startSAMLTestLogin(t, s, "/")
+	response = encodeSAMLResponse(t, idp.response(t, requestID, func(_ *saml.Response, assertion *saml.Assertion) {
+		assertion.ID = assertionID
+	}))
+	if _, _, err := completeSAMLTestLogin(s, response, state); !errors.Is(err, ErrSAMLAssertionReplayed) {
+		t.Errorf("reused assertion ID: err = %v, want ErrSAMLAssertionReplayed", err)
+	}
+}
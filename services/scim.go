@@ -0,0 +1,1391 @@
+// services/scim.go
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// SCIMContentType is the media type of SCIM requests and responses
+const SCIMContentType = "application/scim+json"
+
+// Schema URNs from RFC 7643 and RFC 7644
+const (
+	SCIMUserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// Error types from RFC 7644 section 3.12
+const (
+	SCIMErrInvalidFilter = "invalidFilter"
+	SCIMErrUniqueness    = "uniqueness"
+	SCIMErrMutability    = "mutability"
+	SCIMErrInvalidSyntax = "invalidSyntax"
+	SCIMErrInvalidPath   = "invalidPath"
+	SCIMErrNoTarget      = "noTarget"
+	SCIMErrInvalidValue  = "invalidValue"
+)
+
+// ErrSCIMDisabled is returned by SCIM methods when no SCIM configuration has been set
+var ErrSCIMDisabled = errors.New("SCIM provisioning is not enabled")
+
+// SCIMError is an error reported to the provisioning client in the format of RFC 7644
+type SCIMError struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *SCIMError) Error() string {
+	if e.ScimType == "" {
+		return e.Detail
+	}
+	return e.ScimType + ": " + e.Detail
+}
+
+// MarshalJSON writes the error as a SCIM error response, in which the status is a string
+func (e *SCIMError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail,omitempty"`
+	}{[]string{SCIMErrorSchema}, strconv.Itoa(e.Status), e.ScimType, e.Detail})
+}
+
+// Helper function to create a SCIM error
+func newSCIMError(status int, scimType, format string, args ...interface{}) *SCIMError {
+	return &SCIMError{Status: status, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+// SCIMConfig holds the settings for the SCIM provisioning API
+type SCIMConfig struct {
+	BaseURL    string            // Public base URL of this service; resource locations are under BaseURL/scim/v2
+	MaxResults int               // Largest page a list request returns
+	GroupRoles map[string]string // Maps group names (case-insensitive) to the role their members get
+}
+
+// NewSCIMConfig creates a SCIM configuration with default values
+func NewSCIMConfig(baseURL string) SCIMConfig {
+	return SCIMConfig{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		MaxResults: 200,
+		GroupRoles: make(map[string]string),
+	}
+}
+
+// SetSCIMConfig enables the SCIM provisioning API
+func (s *AuthService) SetSCIMConfig(config SCIMConfig) {
+	s.scim = &config
+}
+
+// SCIMUser is the SCIM representation of a user
+type SCIMUser struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *SCIMName       `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Emails      []SCIMEmail     `json:"emails,omitempty"`
+	Active      *bool           `json:"active,omitempty"`
+	Password    string          `json:"password,omitempty"` // Write-only
+	Groups      []SCIMReference `json:"groups,omitempty"`   // Read-only
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+// SCIMName is the name of a SCIM user
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+}
+
+// SCIMEmail is one of a SCIM user's email addresses. Users here have exactly one.
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMGroup is the SCIM representation of a group
+type SCIMGroup struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []SCIMReference `json:"members,omitempty"`
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+// SCIMReference points at another resource, such as a group's member
+type SCIMReference struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+// SCIMMeta holds a resource's metadata
+type SCIMMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"` // Weak entity tag, also sent as the ETag header
+}
+
+// SCIMListRequest holds the parameters of a list or filter request
+type SCIMListRequest struct {
+	Filter      string
+	StartIndex  int  // 1-based
+	Count       int  // Negative for the largest page allowed
+	WithMembers bool // Whether groups are returned with their members
+}
+
+// SCIMListResponse is a page of resources
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is a PATCH request, a list of operations applied in order
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation adds, replaces or removes the value at a path
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// AuthenticateSCIMClient checks the bearer token of a provisioning client. It must be a
+// personal access token with the scim scope that belongs to an admin.
+func (s *AuthService) AuthenticateSCIMClient(ctx context.Context, value, ipAddress string) (*models.AccessToken, *models.User, error) {
+	if s.scim == nil {
+		return nil, nil, ErrSCIMDisabled
+	}
+	if !IsAccessToken(value) {
+		return nil, nil, newSCIMError(http.StatusUnauthorized, "", "A personal access token is required")
+	}
+
+	token, user, err := s.ValidateAccessToken(ctx, value, ipAddress)
+	switch {
+	case errors.Is(err, ErrIPNotAllowed):
+		return nil, nil, newSCIMError(http.StatusForbidden, "", "Access token cannot be used from this IP address")
+	case errors.Is(err, ErrUserLocked):
+		return nil, nil, newSCIMError(http.StatusForbidden, "", "Account is temporarily locked")
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrUserNotFound):
+		return nil, nil, newSCIMError(http.StatusUnauthorized, "", "Invalid or expired access token")
+	case err != nil:
+		return nil, nil, err
+	}
+
+	if !containsString(token.Scopes, ScopeSCIM) {
+		return nil, nil, newSCIMError(http.StatusForbidden, "", "Access token is missing the %s scope", ScopeSCIM)
+	}
+	isAdmin, err := s.roleRepo.HasRole(ctx, user.UserID, models.RoleAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isAdmin {
+		return nil, nil, newSCIMError(http.StatusForbidden, "", "Insufficient permissions")
+	}
+
+	return token, user, nil
+}
+
+// ListSCIMUsers returns a page of the users matching a SCIM filter
+func (s *AuthService) ListSCIMUsers(ctx context.Context, req SCIMListRequest) (*SCIMListResponse, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	filter, err := parseSCIMFilter(req.Filter, SCIMUserSchema, scimUserFilterAttributes)
+	if err != nil {
+		return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidFilter, "%v", err)
+	}
+
+	startIndex, count := s.scimPage(req)
+	users, total, err := s.userRepo.Search(ctx, filter, startIndex-1, count)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidFilter) {
+			return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidFilter, "%v", err)
+		}
+		return nil, err
+	}
+
+	resources, err := s.scimUsers(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+
+	response := newSCIMListResponse(total, startIndex)
+	for _, resource := range resources {
+		response.Resources = append(response.Resources, resource)
+	}
+	response.ItemsPerPage = len(response.Resources)
+	return response, nil
+}
+
+// GetSCIMUser returns a user as a SCIM resource
+func (s *AuthService) GetSCIMUser(ctx context.Context, id string) (*SCIMUser, error) {
+	user, err := s.scimUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := s.scimUsers(ctx, []*models.User{user})
+	if err != nil {
+		return nil, err
+	}
+	return resources[0], nil
+}
+
+// CreateSCIMUser provisions a user on behalf of the provisioning client. Without a password
+// the user can only sign in through an external identity provider.
+func (s *AuthService) CreateSCIMUser(ctx context.Context, actorID uuid.UUID, input *SCIMUser, ipAddress, userAgent string) (*SCIMUser, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	// Provisioning clients are trusted with email addresses, as directories are
+	edit := &scimUserEdit{user: &models.User{IsEmailVerified: true, IsActive: true}}
+	edit.replace(input)
+	if err := s.checkSCIMUser(ctx, edit.user); err != nil {
+		return nil, err
+	}
+
+	password := edit.password
+	if password != "" {
+		if err := s.checkSCIMPassword(ctx, password, edit.user); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if password, err = generateSecureToken(32); err != nil {
+			return nil, err
+		}
+	}
+
+	user := edit.user
+	if err := s.userRepo.Create(ctx, user, password); err != nil {
+		return nil, err
+	}
+	if err := s.scimRepo.SetExternalID(ctx, user.UserID, edit.externalID); err != nil {
+		return nil, err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "user_provisioned",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider":       "scim",
+			"external_id":    edit.externalID,
+			"username":       user.Username,
+			"email":          user.Email,
+			"active":         user.IsActive,
+			"provisioned_by": actorID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return s.GetSCIMUser(ctx, user.UserID.String())
+}
+
+// ReplaceSCIMUser overwrites a user with the attributes in a SCIM resource. A non-empty
+// ifMatch must list the user's current version.
+func (s *AuthService) ReplaceSCIMUser(ctx context.Context, actorID uuid.UUID, id, ifMatch string, input *SCIMUser, ipAddress, userAgent string) (*SCIMUser, error) {
+	before, err := s.scimUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(ifMatch, before.UpdatedAt); err != nil {
+		return nil, err
+	}
+	edit, err := s.newSCIMUserEdit(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	edit.replace(input)
+	if err := s.saveSCIMUser(ctx, actorID, before, edit, ipAddress, userAgent); err != nil {
+		return nil, err
+	}
+	return s.GetSCIMUser(ctx, id)
+}
+
+// PatchSCIMUser applies PATCH operations to a user. Setting active to false deactivates the
+// user and ends their sessions. A non-empty ifMatch must list the user's current version.
+func (s *AuthService) PatchSCIMUser(ctx context.Context, actorID uuid.UUID, id, ifMatch string, patch *SCIMPatchRequest, ipAddress, userAgent string) (*SCIMUser, error) {
+	before, err := s.scimUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(ifMatch, before.UpdatedAt); err != nil {
+		return nil, err
+	}
+	edit, err := s.newSCIMUserEdit(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := applySCIMPatch(patch, SCIMUserSchema, edit.set, edit.remove); err != nil {
+		return nil, err
+	}
+	if err := s.saveSCIMUser(ctx, actorID, before, edit, ipAddress, userAgent); err != nil {
+		return nil, err
+	}
+	return s.GetSCIMUser(ctx, id)
+}
+
+// DeleteSCIMUser deletes a user on behalf of the provisioning client. A non-empty ifMatch
+// must list the user's current version.
+func (s *AuthService) DeleteSCIMUser(ctx context.Context, actorID uuid.UUID, id, ifMatch, ipAddress, userAgent string) error {
+	user, err := s.scimUserByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkSCIMVersion(ifMatch, user.UpdatedAt); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.Delete(ctx, user.UserID); err != nil {
+		return err
+	}
+
+	// Recorded against the admin; the user is soft deleted and can be restored for a while
+	auditLog := &models.AuditLog{
+		UserID:    actorID,
+		EventType: "user_deleted",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider": "scim",
+			"user_id":  user.UserID.String(),
+			"username": user.Username,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// ListSCIMGroups returns a page of the groups matching a SCIM filter
+func (s *AuthService) ListSCIMGroups(ctx context.Context, req SCIMListRequest) (*SCIMListResponse, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	filter, err := parseSCIMFilter(req.Filter, SCIMGroupSchema, scimGroupFilterAttributes)
+	if err != nil {
+		return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidFilter, "%v", err)
+	}
+
+	startIndex, count := s.scimPage(req)
+	groups, total, err := s.scimRepo.SearchGroups(ctx, filter, startIndex-1, count, req.WithMembers)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidFilter) {
+			return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidFilter, "%v", err)
+		}
+		return nil, err
+	}
+
+	response := newSCIMListResponse(total, startIndex)
+	for _, group := range groups {
+		response.Resources = append(response.Resources, s.scimGroup(group))
+	}
+	response.ItemsPerPage = len(response.Resources)
+	return response, nil
+}
+
+// GetSCIMGroup returns a group as a SCIM resource
+func (s *AuthService) GetSCIMGroup(ctx context.Context, id string) (*SCIMGroup, error) {
+	group, err := s.scimGroupByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.scimGroup(group), nil
+}
+
+// CreateSCIMGroup creates a group on behalf of the provisioning client
+func (s *AuthService) CreateSCIMGroup(ctx context.Context, actorID uuid.UUID, input *SCIMGroup, ipAddress, userAgent string) (*SCIMGroup, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	edit := &scimGroupEdit{group: &models.SCIMGroup{}, members: make(map[uuid.UUID]bool)}
+	if err := edit.replace(input); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(edit.group.DisplayName) == "" {
+		return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "displayName is required")
+	}
+
+	members := edit.memberIDs()
+	if err := s.scimRepo.CreateGroup(ctx, edit.group, members); err != nil {
+		return nil, scimGroupError(err)
+	}
+
+	s.logSCIMGroupChange(ctx, actorID, "group_created", edit.group, members, nil, ipAddress, userAgent)
+	if err := s.syncSCIMRoles(ctx, members, ipAddress, userAgent); err != nil {
+		return nil, err
+	}
+
+	return s.GetSCIMGroup(ctx, edit.group.GroupID.String())
+}
+
+// ReplaceSCIMGroup overwrites a group's name and members. A non-empty ifMatch must list the
+// group's current version.
+func (s *AuthService) ReplaceSCIMGroup(ctx context.Context, actorID uuid.UUID, id, ifMatch string, input *SCIMGroup, ipAddress, userAgent string) (*SCIMGroup, error) {
+	group, err := s.scimGroupByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(ifMatch, group.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	edit := newSCIMGroupEdit(group)
+	edit.members = make(map[uuid.UUID]bool)
+	if err := edit.replace(input); err != nil {
+		return nil, err
+	}
+	if err := s.saveSCIMGroup(ctx, actorID, group, edit, ipAddress, userAgent); err != nil {
+		return nil, err
+	}
+	return s.GetSCIMGroup(ctx, id)
+}
+
+// PatchSCIMGroup applies PATCH operations to a group, typically to add or remove members. A
+// non-empty ifMatch must list the group's current version.
+func (s *AuthService) PatchSCIMGroup(ctx context.Context, actorID uuid.UUID, id, ifMatch string, patch *SCIMPatchRequest, ipAddress, userAgent string) (*SCIMGroup, error) {
+	group, err := s.scimGroupByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSCIMVersion(ifMatch, group.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	edit := newSCIMGroupEdit(group)
+	if err := applySCIMPatch(patch, SCIMGroupSchema, edit.set, edit.remove); err != nil {
+		return nil, err
+	}
+	if err := s.saveSCIMGroup(ctx, actorID, group, edit, ipAddress, userAgent); err != nil {
+		return nil, err
+	}
+	return s.GetSCIMGroup(ctx, id)
+}
+
+// DeleteSCIMGroup deletes a group, taking away the role it gave its members. A non-empty
+// ifMatch must list the group's current version.
+func (s *AuthService) DeleteSCIMGroup(ctx context.Context, actorID uuid.UUID, id, ifMatch, ipAddress, userAgent string) error {
+	group, err := s.scimGroupByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := checkSCIMVersion(ifMatch, group.UpdatedAt); err != nil {
+		return err
+	}
+
+	deleted, members, err := s.scimRepo.DeleteGroup(ctx, group.GroupID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return newSCIMError(http.StatusNotFound, "", "Group %s not found", id)
+	}
+
+	s.logSCIMGroupChange(ctx, actorID, "group_deleted", group, nil, members, ipAddress, userAgent)
+	return s.syncSCIMRoles(ctx, members, ipAddress, userAgent)
+}
+
+// scimUserEdit collects the changes to a user made by a SCIM request
+type scimUserEdit struct {
+	user               *models.User
+	externalID         *string
+	previousExternalID *string
+	password           string
+}
+
+// Helper function to start editing a copy of a user
+func (s *AuthService) newSCIMUserEdit(ctx context.Context, user *models.User) (*scimUserEdit, error) {
+	externalIDs, err := s.scimRepo.GetExternalIDs(ctx, []uuid.UUID{user.UserID})
+	if err != nil {
+		return nil, err
+	}
+
+	edit := &scimUserEdit{user: new(models.User)}
+	*edit.user = *user
+	if externalID, ok := externalIDs[user.UserID]; ok {
+		edit.externalID, edit.previousExternalID = &externalID, &externalID
+	}
+	return edit, nil
+}
+
+// Helper function to take every attribute from a resource, as POST and PUT do
+func (e *scimUserEdit) replace(input *SCIMUser) {
+	e.user.Username = strings.TrimSpace(input.UserName)
+	e.user.FirstName, e.user.LastName = "", ""
+	if input.Name != nil {
+		e.user.FirstName, e.user.LastName = input.Name.GivenName, input.Name.FamilyName
+	}
+	e.setEmails(input.Emails)
+	if e.user.Email == "" && strings.Contains(e.user.Username, "@") {
+		e.user.Email = e.user.Username
+	}
+	if input.Active != nil {
+		e.user.IsActive = *input.Active
+	}
+	e.externalID = nil
+	if input.ExternalID != "" {
+		e.externalID = &input.ExternalID
+	}
+	e.password = input.Password
+}
+
+// Helper function to pick the email address from a list; users here have exactly one
+func (e *scimUserEdit) setEmails(emails []SCIMEmail) {
+	if len(emails) == 0 {
+		e.user.Email = ""
+		return
+	}
+	chosen := emails[0]
+	for _, email := range emails {
+		if email.Primary {
+			chosen = email
+			break
+		}
+		if strings.EqualFold(email.Type, "work") && chosen.Type != "work" {
+			chosen = email
+		}
+	}
+	e.user.Email = strings.TrimSpace(chosen.Value)
+}
+
+// Helper function to apply an add or replace operation to a user. Attributes that aren't
+// stored, including those of schema extensions, are ignored.
+func (e *scimUserEdit) set(path *scimPath, value json.RawMessage) error {
+	switch path.attribute {
+	case "username":
+		return decodeSCIMValue(value, &e.user.Username)
+	case "name":
+		switch path.sub {
+		case "givenname":
+			return decodeSCIMValue(value, &e.user.FirstName)
+		case "familyname":
+			return decodeSCIMValue(value, &e.user.LastName)
+		case "":
+			// Sub-attributes left out of the value keep their current values
+			var name map[string]json.RawMessage
+			if err := decodeSCIMValue(value, &name); err != nil {
+				return err
+			}
+			for key, sub := range name {
+				if err := e.set(&scimPath{attribute: "name", sub: strings.ToLower(key)}, sub); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case "emails":
+		if path.filter != "" {
+			if _, err := parseSCIMFilter(path.filter, "", scimValueFilterAttributes); err != nil {
+				return newSCIMError(http.StatusBadRequest, SCIMErrInvalidPath, "%v", err)
+			}
+		}
+		switch {
+		case path.sub == "value":
+			return decodeSCIMValue(value, &e.user.Email)
+		case path.sub != "":
+			return nil
+		case path.filter != "":
+			var email SCIMEmail
+			if err := decodeSCIMValue(value, &email); err != nil {
+				return err
+			}
+			e.user.Email = email.Value
+			return nil
+		}
+		var emails []SCIMEmail
+		if err := decodeSCIMValue(value, &emails); err != nil {
+			return err
+		}
+		e.setEmails(emails)
+		return nil
+	case "active":
+		return decodeSCIMBool(value, &e.user.IsActive)
+	case "externalid":
+		var externalID string
+		if err := decodeSCIMValue(value, &externalID); err != nil {
+			return err
+		}
+		e.externalID = nil
+		if externalID != "" {
+			e.externalID = &externalID
+		}
+		return nil
+	case "password":
+		return decodeSCIMValue(value, &e.password)
+	case "groups":
+		return newSCIMError(http.StatusBadRequest, SCIMErrMutability, "groups is read-only; change the group's members instead")
+	}
+	return nil
+}
+
+// Helper function to apply a remove operation to a user
+func (e *scimUserEdit) remove(path *scimPath, _ json.RawMessage) error {
+	switch path.attribute {
+	case "username", "emails":
+		return newSCIMError(http.StatusBadRequest, SCIMErrMutability, "%s is required", path.attribute)
+	case "name":
+		switch path.sub {
+		case "givenname":
+			e.user.FirstName = ""
+		case "familyname":
+			e.user.LastName = ""
+		case "":
+			e.user.FirstName, e.user.LastName = "", ""
+		}
+	case "externalid":
+		e.externalID = nil
+	case "active", "password", "groups":
+		return newSCIMError(http.StatusBadRequest, SCIMErrMutability, "%s cannot be removed", path.attribute)
+	}
+	return nil
+}
+
+// Helper function to validate and store the changes to a user
+func (s *AuthService) saveSCIMUser(ctx context.Context, actorID uuid.UUID, before *models.User, edit *scimUserEdit, ipAddress, userAgent string) error {
+	user := edit.user
+	if err := s.checkSCIMUser(ctx, user); err != nil {
+		return err
+	}
+	if edit.password != "" {
+		if err := s.checkSCIMPassword(ctx, edit.password, user); err != nil {
+			return err
+		}
+	}
+
+	var changed []string
+	if user.Username != before.Username {
+		changed = append(changed, "username")
+	}
+	if user.Email != before.Email {
+		changed = append(changed, "email")
+		user.IsEmailVerified = true
+		user.EmailVerificationToken = nil
+	}
+	if user.FirstName != before.FirstName || user.LastName != before.LastName {
+		changed = append(changed, "name")
+	}
+
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	if !sameStringPointer(edit.externalID, edit.previousExternalID) {
+		if err := s.scimRepo.SetExternalID(ctx, user.UserID, edit.externalID); err != nil {
+			return err
+		}
+		changed = append(changed, "external_id")
+	}
+	if edit.password != "" {
+		if err := s.userRepo.UpdatePassword(ctx, user.UserID, edit.password, s.passwordPolicy.historyCount()); err != nil {
+			return err
+		}
+		changed = append(changed, "password")
+	}
+
+	if len(changed) > 0 {
+		auditLog := &models.AuditLog{
+			UserID:    user.UserID,
+			EventType: "user_updated",
+			IPAddress: ipAddress,
+			UserAgent: userAgent,
+			Details: map[string]interface{}{
+				"provider":   "scim",
+				"changed":    changed,
+				"updated_by": actorID.String(),
+			},
+		}
+		if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+			slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+		}
+	}
+
+	if user.IsActive == before.IsActive {
+		return nil
+	}
+
+	eventType := "user_reactivated"
+	if !user.IsActive {
+		// A deactivated user's tokens stop working on their own; sessions are cached, so end them
+		eventType = "user_deactivated"
+		revoked, err := s.sessionRepo.InvalidateAllForUser(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+		s.metrics.SessionsRevoked(sessionRevokedSCIM, int(revoked))
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: eventType,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"provider":   "scim",
+			"updated_by": actorID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// Helper function to check a user's required attributes and that their username and email are free
+func (s *AuthService) checkSCIMUser(ctx context.Context, user *models.User) error {
+	user.Username = strings.TrimSpace(user.Username)
+	user.Email = strings.TrimSpace(user.Email)
+	if user.Username == "" {
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "userName is required")
+	}
+	if user.Email == "" || !strings.Contains(user.Email, "@") {
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "A valid email address is required")
+	}
+
+	existing, err := s.userRepo.GetByUsername(ctx, user.Username)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.UserID != user.UserID {
+		return newSCIMError(http.StatusConflict, SCIMErrUniqueness, "userName %s is already taken", user.Username)
+	}
+
+	existing, err = s.userRepo.GetByEmail(ctx, user.Email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.UserID != user.UserID {
+		return newSCIMError(http.StatusConflict, SCIMErrUniqueness, "email %s is already in use", user.Email)
+	}
+
+	return nil
+}
+
+// Helper function to check a password set by the provisioning client against the policy
+func (s *AuthService) checkSCIMPassword(ctx context.Context, password string, user *models.User) error {
+	var policyErr *PasswordPolicyError
+	if err := s.passwordPolicy.Validate(ctx, password, user); err != nil {
+		if errors.As(err, &policyErr) {
+			return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "password does not meet the password policy: %v", policyErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// scimGroupEdit collects the changes to a group made by a SCIM request
+type scimGroupEdit struct {
+	group   *models.SCIMGroup
+	members map[uuid.UUID]bool
+}
+
+// Helper function to start editing a copy of a group
+func newSCIMGroupEdit(group *models.SCIMGroup) *scimGroupEdit {
+	edit := &scimGroupEdit{group: new(models.SCIMGroup), members: make(map[uuid.UUID]bool)}
+	*edit.group = *group
+	for _, member := range group.Members {
+		edit.members[member.UserID] = true
+	}
+	return edit
+}
+
+// Helper function to take every attribute from a resource, as POST and PUT do
+func (e *scimGroupEdit) replace(input *SCIMGroup) error {
+	e.group.DisplayName = strings.TrimSpace(input.DisplayName)
+	e.group.ExternalID = nil
+	if input.ExternalID != "" {
+		e.group.ExternalID = &input.ExternalID
+	}
+	return e.addMembers(input.Members)
+}
+
+// Helper function to apply an add or replace operation to a group
+func (e *scimGroupEdit) set(path *scimPath, value json.RawMessage) error {
+	switch path.attribute {
+	case "displayname":
+		return decodeSCIMValue(value, &e.group.DisplayName)
+	case "externalid":
+		var externalID string
+		if err := decodeSCIMValue(value, &externalID); err != nil {
+			return err
+		}
+		e.group.ExternalID = nil
+		if externalID != "" {
+			e.group.ExternalID = &externalID
+		}
+		return nil
+	case "members":
+		if path.filter != "" || path.sub != "" {
+			return newSCIMError(http.StatusBadRequest, SCIMErrInvalidPath, "members can only be added or replaced as a whole")
+		}
+		var members []SCIMReference
+		if err := decodeSCIMValue(value, &members); err != nil {
+			return err
+		}
+		if path.op == "replace" {
+			e.members = make(map[uuid.UUID]bool)
+		}
+		return e.addMembers(members)
+	}
+	return nil
+}
+
+// Helper function to apply a remove operation to a group. Members are removed by a
+// filter on their value, by listing them in the value, or all at once.
+func (e *scimGroupEdit) remove(path *scimPath, value json.RawMessage) error {
+	switch path.attribute {
+	case "displayname":
+		return newSCIMError(http.StatusBadRequest, SCIMErrMutability, "displayName is required")
+	case "externalid":
+		e.group.ExternalID = nil
+	case "members":
+		if path.filter != "" {
+			filter, err := parseSCIMFilter(path.filter, "", scimValueFilterAttributes)
+			if err != nil {
+				return newSCIMError(http.StatusBadRequest, SCIMErrInvalidPath, "%v", err)
+			}
+			ids, err := scimMemberFilterValues(filter)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				delete(e.members, id)
+			}
+			return nil
+		}
+
+		if len(value) == 0 || string(value) == "null" {
+			e.members = make(map[uuid.UUID]bool)
+			return nil
+		}
+		var members []SCIMReference
+		if err := decodeSCIMValue(value, &members); err != nil {
+			return err
+		}
+		for _, member := range members {
+			id, err := uuid.Parse(member.Value)
+			if err != nil {
+				return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "Invalid member %q", member.Value)
+			}
+			delete(e.members, id)
+		}
+	}
+	return nil
+}
+
+// Helper function to add members given by reference
+func (e *scimGroupEdit) addMembers(members []SCIMReference) error {
+	for _, member := range members {
+		id, err := uuid.Parse(member.Value)
+		if err != nil {
+			return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "Invalid member %q", member.Value)
+		}
+		e.members[id] = true
+	}
+	return nil
+}
+
+// Helper function to list the members being edited
+func (e *scimGroupEdit) memberIDs() []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(e.members))
+	for id := range e.members {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Helper function to store the changes to a group and bring its members' roles up to date
+func (s *AuthService) saveSCIMGroup(ctx context.Context, actorID uuid.UUID, before *models.SCIMGroup, edit *scimGroupEdit, ipAddress, userAgent string) error {
+	edit.group.DisplayName = strings.TrimSpace(edit.group.DisplayName)
+	if edit.group.DisplayName == "" {
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "displayName is required")
+	}
+
+	var added, removed, kept []uuid.UUID
+	current := make(map[uuid.UUID]bool, len(before.Members))
+	for _, member := range before.Members {
+		current[member.UserID] = true
+		if edit.members[member.UserID] {
+			kept = append(kept, member.UserID)
+		} else {
+			removed = append(removed, member.UserID)
+		}
+	}
+	for id := range edit.members {
+		if !current[id] {
+			added = append(added, id)
+		}
+	}
+
+	if err := s.scimRepo.SaveGroup(ctx, edit.group, added, removed); err != nil {
+		return scimGroupError(err)
+	}
+
+	renamed := !strings.EqualFold(edit.group.DisplayName, before.DisplayName)
+	if len(added) > 0 || len(removed) > 0 || renamed {
+		s.logSCIMGroupChange(ctx, actorID, "group_updated", edit.group, added, removed, ipAddress, userAgent)
+	}
+
+	// A new name can map to a different role, so everyone in the group is affected
+	affected := append(added, removed...)
+	if renamed {
+		affected = append(affected, kept...)
+	}
+	return s.syncSCIMRoles(ctx, affected, ipAddress, userAgent)
+}
+
+// Helper function to record a change to a group in the audit log of the admin who made it
+func (s *AuthService) logSCIMGroupChange(ctx context.Context, actorID uuid.UUID, eventType string, group *models.SCIMGroup, added, removed []uuid.UUID, ipAddress, userAgent string) {
+	details := map[string]interface{}{
+		"provider":     "scim",
+		"group_id":     group.GroupID.String(),
+		"display_name": group.DisplayName,
+	}
+	if len(added) > 0 {
+		details["added_members"] = uuidStrings(added)
+	}
+	if len(removed) > 0 {
+		details["removed_members"] = uuidStrings(removed)
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    actorID,
+		EventType: eventType,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   details,
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+}
+
+// Helper function to grant and revoke the roles groups map to, for users whose groups changed
+func (s *AuthService) syncSCIMRoles(ctx context.Context, userIDs []uuid.UUID, ipAddress, userAgent string) error {
+	if len(s.scim.GroupRoles) == 0 || len(userIDs) == 0 {
+		return nil
+	}
+
+	var managed []string
+	for _, role := range s.scim.GroupRoles {
+		if !containsString(managed, role) {
+			managed = append(managed, role)
+		}
+	}
+
+	groups, err := s.scimRepo.GetGroupsByUserIDs(ctx, userIDs)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		credentials := &VerifiedCredentials{ManagedRoles: managed}
+		for _, group := range groups[userID] {
+			for name, role := range s.scim.GroupRoles {
+				if strings.EqualFold(name, group.DisplayName) && !containsString(credentials.Roles, role) {
+					credentials.Roles = append(credentials.Roles, role)
+				}
+			}
+		}
+		if err := s.syncDirectoryRoles(ctx, userID, "scim", credentials, ipAddress, userAgent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scimPath is a parsed PATCH path, e.g. emails[type eq "work"].value
+type scimPath struct {
+	op        string // add, replace or remove
+	attribute string // Lower case, without the schema
+	filter    string // Value filter between the brackets, if any
+	sub       string // Sub-attribute, lower case
+	extension bool   // The attribute belongs to a schema extension, which isn't stored
+}
+
+// Value filter attributes in PATCH paths, which select among the values of an attribute
+var scimValueFilterAttributes = map[string]string{
+	"value":   "value",
+	"type":    "type",
+	"primary": "primary",
+	"display": "display",
+}
+
+// Helper function to parse a PATCH path
+func parseSCIMPath(op, path, schema string) (*scimPath, error) {
+	path = strings.TrimSpace(path)
+	stripped := stripSCIMSchema(path, schema)
+	if stripped == path && strings.HasPrefix(strings.ToLower(path), "urn:") {
+		return &scimPath{op: op, extension: true}, nil
+	}
+
+	parsed := &scimPath{op: op}
+	if open := strings.IndexByte(stripped, '['); open >= 0 {
+		end := strings.LastIndexByte(stripped, ']')
+		if end < open {
+			return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidPath, "Invalid path %q", path)
+		}
+		parsed.attribute, parsed.filter = stripped[:open], stripped[open+1:end]
+		if rest := stripped[end+1:]; rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidPath, "Invalid path %q", path)
+			}
+			parsed.sub = rest[1:]
+		}
+	} else {
+		parsed.attribute, parsed.sub, _ = strings.Cut(stripped, ".")
+	}
+
+	parsed.attribute, parsed.sub = strings.ToLower(parsed.attribute), strings.ToLower(parsed.sub)
+	if parsed.attribute == "" {
+		return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidPath, "Invalid path %q", path)
+	}
+	return parsed, nil
+}
+
+// Helper function to run PATCH operations through a resource's set and remove functions
+func applySCIMPatch(patch *SCIMPatchRequest, schema string, set, remove func(*scimPath, json.RawMessage) error) error {
+	if len(patch.Operations) == 0 {
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidSyntax, "No operations given")
+	}
+
+	apply := func(op, path string, value json.RawMessage) error {
+		parsed, err := parseSCIMPath(op, path, schema)
+		if err != nil {
+			return err
+		}
+		if parsed.extension {
+			return nil
+		}
+		if op == "remove" {
+			return remove(parsed, value)
+		}
+		return set(parsed, value)
+	}
+
+	for _, operation := range patch.Operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return newSCIMError(http.StatusBadRequest, SCIMErrInvalidSyntax, "Unknown operation %q", operation.Op)
+		}
+
+		if operation.Path != "" {
+			if err := apply(op, operation.Path, operation.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Without a path, the value holds the attributes to add or replace
+		if op == "remove" {
+			return newSCIMError(http.StatusBadRequest, SCIMErrNoTarget, "remove needs a path")
+		}
+		var attributes map[string]json.RawMessage
+		if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+			return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "Operation value must be an object when there is no path")
+		}
+		keys := make([]string, 0, len(attributes))
+		for key := range attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := apply(op, key, attributes[key]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Helper function to decode a PATCH value
+func decodeSCIMValue(value json.RawMessage, target interface{}) error {
+	if len(value) == 0 {
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "Missing value")
+	}
+	if err := json.Unmarshal(value, target); err != nil {
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "Invalid value %s", value)
+	}
+	return nil
+}
+
+// Helper function to decode a boolean PATCH value. Some clients send "True" and "False" as strings.
+func decodeSCIMBool(value json.RawMessage, target *bool) error {
+	var text string
+	if json.Unmarshal(value, &text) == nil {
+		flag, err := strconv.ParseBool(text)
+		if err != nil {
+			return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "Invalid boolean %s", value)
+		}
+		*target = flag
+		return nil
+	}
+	return decodeSCIMValue(value, target)
+}
+
+// Helper function to collect the member IDs from a filter like value eq "..." or value eq "..."
+func scimMemberFilterValues(filter *models.Filter) ([]uuid.UUID, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	if filter.Op == models.FilterOr {
+		var ids []uuid.UUID
+		for _, operand := range filter.Operands {
+			more, err := scimMemberFilterValues(operand)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, more...)
+		}
+		return ids, nil
+	}
+
+	value, _ := filter.Value.(string)
+	id, err := uuid.Parse(value)
+	if filter.Op != models.FilterEqual || filter.Field != "value" || err != nil {
+		return nil, newSCIMError(http.StatusBadRequest, SCIMErrInvalidFilter, "Members can only be selected with value eq")
+	}
+	return []uuid.UUID{id}, nil
+}
+
+// Helper function to find a user by the ID in a resource path
+func (s *AuthService) scimUserByID(ctx context.Context, id string) (*models.User, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, newSCIMError(http.StatusNotFound, "", "User %s not found", id)
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, newSCIMError(http.StatusNotFound, "", "User %s not found", id)
+	}
+	return user, nil
+}
+
+// Helper function to find a group by the ID in a resource path
+func (s *AuthService) scimGroupByID(ctx context.Context, id string) (*models.SCIMGroup, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+	groupID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, newSCIMError(http.StatusNotFound, "", "Group %s not found", id)
+	}
+	group, err := s.scimRepo.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, newSCIMError(http.StatusNotFound, "", "Group %s not found", id)
+	}
+	return group, nil
+}
+
+// Helper function to turn repository errors about groups into SCIM errors
+func scimGroupError(err error) error {
+	switch {
+	case errors.Is(err, models.ErrSCIMGroupExists):
+		return newSCIMError(http.StatusConflict, SCIMErrUniqueness, "A group with this displayName already exists")
+	case errors.Is(err, models.ErrSCIMMemberNotFound):
+		return newSCIMError(http.StatusBadRequest, SCIMErrInvalidValue, "A member does not exist")
+	}
+	return err
+}
+
+// Helper function to build user resources, with their external IDs and groups
+func (s *AuthService) scimUsers(ctx context.Context, users []*models.User) ([]*SCIMUser, error) {
+	userIDs := make([]uuid.UUID, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.UserID)
+	}
+
+	externalIDs, err := s.scimRepo.GetExternalIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := s.scimRepo.GetGroupsByUserIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	base := s.scimBaseURL()
+	resources := make([]*SCIMUser, 0, len(users))
+	for _, user := range users {
+		active := user.IsActive
+		name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+		resource := &SCIMUser{
+			Schemas:     []string{SCIMUserSchema},
+			ID:          user.UserID.String(),
+			ExternalID:  externalIDs[user.UserID],
+			UserName:    user.Username,
+			DisplayName: name,
+			Emails:      []SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+			Active:      &active,
+			Meta: &SCIMMeta{
+				ResourceType: "User",
+				Created:      &user.CreatedAt,
+				LastModified: &user.UpdatedAt,
+				Location:     base + "/Users/" + user.UserID.String(),
+				Version:      scimVersion(user.UpdatedAt),
+			},
+		}
+		if name != "" {
+			resource.Name = &SCIMName{Formatted: name, GivenName: user.FirstName, FamilyName: user.LastName}
+		} else {
+			resource.DisplayName = user.Username
+		}
+		for _, group := range groups[user.UserID] {
+			resource.Groups = append(resource.Groups, SCIMReference{
+				Value:   group.GroupID.String(),
+				Ref:     base + "/Groups/" + group.GroupID.String(),
+				Display: group.DisplayName,
+			})
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
+
+// Helper function to build a group resource
+func (s *AuthService) scimGroup(group *models.SCIMGroup) *SCIMGroup {
+	base := s.scimBaseURL()
+	resource := &SCIMGroup{
+		Schemas:     []string{SCIMGroupSchema},
+		ID:          group.GroupID.String(),
+		DisplayName: group.DisplayName,
+		Meta: &SCIMMeta{
+			ResourceType: "Group",
+			Created:      &group.CreatedAt,
+			LastModified: &group.UpdatedAt,
+			Location:     base + "/Groups/" + group.GroupID.String(),
+			Version:      scimVersion(group.UpdatedAt),
+		},
+	}
+	if group.ExternalID != nil {
+		resource.ExternalID = *group.ExternalID
+	}
+	for _, member := range group.Members {
+		resource.Members = append(resource.Members, SCIMReference{
+			Value:   member.UserID.String(),
+			Ref:     base + "/Users/" + member.UserID.String(),
+			Display: member.Username,
+		})
+	}
+	return resource
+}
+
+// Helper function to work out the page a list request asks for
+func (s *AuthService) scimPage(req SCIMListRequest) (int, int) {
+	startIndex, count := req.StartIndex, req.Count
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 || count > s.scim.MaxResults {
+		count = s.scim.MaxResults
+	}
+	return startIndex, count
+}
+
+// Helper function to make a resource's version from when it was last modified. Every
+// change to a user or group moves its updated_at.
+func scimVersion(lastModified time.Time) string {
+	return `W/"` + strconv.FormatInt(lastModified.UnixMicro(), 10) + `"`
+}
+
+// SCIMVersionMatches reports whether an If-Match or If-None-Match header lists a version,
+// or is *. Entity tags are compared weakly, as RFC 7232 does for both headers.
+func SCIMVersionMatches(header, version string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(version, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Helper function to refuse a change to a resource that has changed since the client read it
+func checkSCIMVersion(ifMatch string, lastModified time.Time) error {
+	version := scimVersion(lastModified)
+	if ifMatch != "" && !SCIMVersionMatches(ifMatch, version) {
+		return newSCIMError(http.StatusPreconditionFailed, "", "Resource has changed; its current version is %s", version)
+	}
+	return nil
+}
+
+// Helper function to start a list response
+func newSCIMListResponse(total, startIndex int) *SCIMListResponse {
+	return &SCIMListResponse{
+		Schemas:      []string{SCIMListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+}
+
+// Helper function to get the URL resource locations are under
+func (s *AuthService) scimBaseURL() string {
+	return s.scim.BaseURL + "/scim/v2"
+}
+
+// Helper function to compare optional strings
+func sameStringPointer(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Helper function to format UUIDs for the audit log
+func uuidStrings(ids []uuid.UUID) []string {
+	values := make([]string, len(ids))
+	for i, id := range ids {
+		values[i] = id.String()
+	}
+	return values
+}
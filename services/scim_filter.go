@@ -0,0 +1,334 @@
+// services/scim_filter.go
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Attribute paths that SCIM filters on users and groups can use, in lower case, and the
+// repository fields they compare
+var (
+	scimUserFilterAttributes = map[string]string{
+		"id":                models.UserFieldID,
+		"externalid":        models.UserFieldExternalID,
+		"username":          models.UserFieldUsername,
+		"name.givenname":    models.UserFieldFirstName,
+		"name.familyname":   models.UserFieldLastName,
+		"emails":            models.UserFieldEmail,
+		"emails.value":      models.UserFieldEmail,
+		"active":            models.UserFieldIsActive,
+		"groups":            models.UserFieldGroupID,
+		"groups.value":      models.UserFieldGroupID,
+		"meta.created":      models.UserFieldCreatedAt,
+		"meta.lastmodified": models.UserFieldUpdatedAt,
+	}
+	scimGroupFilterAttributes = map[string]string{
+		"id":                models.GroupFieldID,
+		"externalid":        models.GroupFieldExternalID,
+		"displayname":       models.GroupFieldDisplayName,
+		"members":           models.GroupFieldMemberID,
+		"members.value":     models.GroupFieldMemberID,
+		"meta.created":      models.GroupFieldCreatedAt,
+		"meta.lastmodified": models.GroupFieldUpdatedAt,
+	}
+)
+
+// scimFilterComparisons lists the comparison operators of RFC 7644 section 3.4.2.2
+var scimFilterComparisons = map[string]string{
+	"eq": models.FilterEqual,
+	"ne": models.FilterNotEqual,
+	"co": models.FilterContains,
+	"sw": models.FilterStartsWith,
+	"ew": models.FilterEndsWith,
+	"gt": models.FilterGreater,
+	"ge": models.FilterGreaterOrEqual,
+	"lt": models.FilterLess,
+	"le": models.FilterLessOrEqual,
+}
+
+// Kinds of token in a filter expression
+type scimTokenKind int
+
+const (
+	scimTokenWord         scimTokenKind = iota // Attribute paths, operators, true, false, null and numbers
+	scimTokenString                            // A quoted string, already unquoted
+	scimTokenOpen                              // (
+	scimTokenClose                             // )
+	scimTokenOpenBracket                       // [
+	scimTokenCloseBracket                      // ]
+)
+
+type scimToken struct {
+	kind  scimTokenKind
+	value string
+}
+
+// scimFilterParser parses SCIM filter expressions into repository filters. Attribute paths
+// are looked up in attributes after the schema prefix is removed; schema is the resource's
+// core schema.
+type scimFilterParser struct {
+	tokens     []scimToken
+	pos        int
+	schema     string
+	attributes map[string]string
+}
+
+// parseSCIMFilter parses a filter expression; an empty expression matches everything
+func parseSCIMFilter(expression, schema string, attributes map[string]string) (*models.Filter, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, nil
+	}
+
+	tokens, err := tokenizeSCIMFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &scimFilterParser{tokens: tokens, schema: schema, attributes: attributes}
+	filter, err := parser.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q", parser.tokens[parser.pos].value)
+	}
+	return filter, nil
+}
+
+// Helper function to parse filters joined with or, which binds loosest. prefix is the
+// attribute a value path filter is inside of.
+func (p *scimFilterParser) parseOr(prefix string) (*models.Filter, error) {
+	left, err := p.parseAnd(prefix)
+	if err != nil {
+		return nil, err
+	}
+	operands := []*models.Filter{left}
+	for p.acceptWord("or") {
+		right, err := p.parseAnd(prefix)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, right)
+	}
+	if len(operands) == 1 {
+		return left, nil
+	}
+	return &models.Filter{Op: models.FilterOr, Operands: operands}, nil
+}
+
+// Helper function to parse filters joined with and
+func (p *scimFilterParser) parseAnd(prefix string) (*models.Filter, error) {
+	left, err := p.parseUnary(prefix)
+	if err != nil {
+		return nil, err
+	}
+	operands := []*models.Filter{left}
+	for p.acceptWord("and") {
+		right, err := p.parseUnary(prefix)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, right)
+	}
+	if len(operands) == 1 {
+		return left, nil
+	}
+	return &models.Filter{Op: models.FilterAnd, Operands: operands}, nil
+}
+
+// Helper function to parse a negation, a parenthesised filter, a value path or a comparison
+func (p *scimFilterParser) parseUnary(prefix string) (*models.Filter, error) {
+	if p.acceptWord("not") {
+		if !p.accept(scimTokenOpen) {
+			return nil, fmt.Errorf("expected ( after not")
+		}
+		inner, err := p.parseOr(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(scimTokenClose) {
+			return nil, fmt.Errorf("missing )")
+		}
+		return &models.Filter{Op: models.FilterNot, Operands: []*models.Filter{inner}}, nil
+	}
+
+	if p.accept(scimTokenOpen) {
+		inner, err := p.parseOr(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(scimTokenClose) {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+
+	token, ok := p.next()
+	if !ok || token.kind != scimTokenWord {
+		return nil, fmt.Errorf("expected an attribute")
+	}
+	path := stripSCIMSchema(token.value, p.schema)
+	if prefix != "" {
+		path = prefix + "." + path
+	}
+
+	// A value path filters the values of a multi-valued attribute, e.g. emails[type eq "work"]
+	if p.accept(scimTokenOpenBracket) {
+		if prefix != "" {
+			return nil, fmt.Errorf("value paths cannot be nested")
+		}
+		inner, err := p.parseOr(path)
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(scimTokenCloseBracket) {
+			return nil, fmt.Errorf("missing ]")
+		}
+		return inner, nil
+	}
+
+	field, ok := p.attributes[strings.ToLower(path)]
+	if !ok {
+		return nil, fmt.Errorf("cannot filter on %s", path)
+	}
+
+	operator, ok := p.next()
+	if !ok || operator.kind != scimTokenWord {
+		return nil, fmt.Errorf("expected an operator after %s", path)
+	}
+	op := strings.ToLower(operator.value)
+	if op == "pr" {
+		return &models.Filter{Op: models.FilterPresent, Field: field}, nil
+	}
+	comparison, ok := scimFilterComparisons[op]
+	if !ok {
+		return nil, fmt.Errorf("unknown operator %s", operator.value)
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return &models.Filter{Op: comparison, Field: field, Value: value}, nil
+}
+
+// Helper function to parse the value a comparison compares with
+func (p *scimFilterParser) parseValue() (interface{}, error) {
+	token, ok := p.next()
+	if !ok {
+		return nil, fmt.Errorf("expected a value")
+	}
+	if token.kind == scimTokenString {
+		return token.value, nil
+	}
+	if token.kind != scimTokenWord {
+		return nil, fmt.Errorf("expected a value")
+	}
+
+	switch strings.ToLower(token.value) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	number, err := strconv.ParseFloat(token.value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %s", token.value)
+	}
+	return number, nil
+}
+
+// Helper function to take the next token
+func (p *scimFilterParser) next() (scimToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return scimToken{}, false
+	}
+	p.pos++
+	return p.tokens[p.pos-1], true
+}
+
+// Helper function to take the next token if it is of a kind
+func (p *scimFilterParser) accept(kind scimTokenKind) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Helper function to take the next token if it is a keyword; keywords are case-insensitive
+func (p *scimFilterParser) acceptWord(word string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == scimTokenWord && strings.EqualFold(p.tokens[p.pos].value, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// Helper function to split a filter expression into tokens
+func tokenizeSCIMFilter(expression string) ([]scimToken, error) {
+	var tokens []scimToken
+	for i := 0; i < len(expression); {
+		switch ch := expression[i]; ch {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(':
+			tokens = append(tokens, scimToken{kind: scimTokenOpen, value: "("})
+			i++
+		case ')':
+			tokens = append(tokens, scimToken{kind: scimTokenClose, value: ")"})
+			i++
+		case '[':
+			tokens = append(tokens, scimToken{kind: scimTokenOpenBracket, value: "["})
+			i++
+		case ']':
+			tokens = append(tokens, scimToken{kind: scimTokenCloseBracket, value: "]"})
+			i++
+		case '"':
+			// Strings are JSON strings, escapes included
+			end := i + 1
+			for end < len(expression) && expression[end] != '"' {
+				if expression[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expression) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(expression[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string %s", expression[i:end+1])
+			}
+			tokens = append(tokens, scimToken{kind: scimTokenString, value: value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(expression) && !strings.ContainsRune(" \t\n\r()[]\"", rune(expression[end])) {
+				end++
+			}
+			tokens = append(tokens, scimToken{kind: scimTokenWord, value: expression[i:end]})
+			i = end
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+	return tokens, nil
+}
+
+// Helper function to remove a resource's core schema URN from the front of an attribute
+// path, e.g. urn:ietf:params:scim:schemas:core:2.0:User:userName
+func stripSCIMSchema(path, schema string) string {
+	if len(path) > len(schema) && strings.EqualFold(path[:len(schema)+1], schema+":") {
+		return path[len(schema)+1:]
+	}
+	return path
+}
@@ -0,0 +1,126 @@
+// services/scim_filter_test.go
+package services
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to write a filter in prefix form, e.g. (and (eq username "a") (pr email))
+func formatFilter(filter *models.Filter) string {
+	if filter == nil {
+		return "<nil>"
+	}
+	switch filter.Op {
+	case models.FilterAnd, models.FilterOr, models.FilterNot:
+		parts := []string{filter.Op}
+		for _, operand := range filter.Operands {
+			parts = append(parts, formatFilter(operand))
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case models.FilterPresent:
+		return "(pr " + filter.Field + ")"
+	}
+	return fmt.Sprintf("(%s %s %#v)", filter.Op, filter.Field, filter.Value)
+}
+
+func TestParseSCIMUserFilter(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{``, `<nil>`},
+		{`userName eq "bjensen"`, `(eq username "bjensen")`},
+		{`name.familyName co "O'Malley"`, `(co last_name "O'Malley")`},
+		{`userName sw "J"`, `(sw username "J")`},
+		{`emails pr`, `(pr email)`},
+		{`emails.value ew "@example.com"`, `(ew email "@example.com")`},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "J"`, `(sw username "J")`},
+		{`meta.lastModified gt "2011-05-13T04:42:34Z"`, `(gt updated_at "2011-05-13T04:42:34Z")`},
+		{`active eq true`, `(eq is_active true)`},
+		{`externalId eq null`, `(eq external_id <nil>)`},
+		{`userName eq "a\"b"`, `(eq username "a\"b")`},
+
+		// Keywords, operators and attribute names are case-insensitive
+		{`USERNAME EQ "bjensen"`, `(eq username "bjensen")`},
+		{`userName Eq "a" AND emails PR`, `(and (eq username "a") (pr email))`},
+
+		{`userName eq "a" and active eq true`, `(and (eq username "a") (eq is_active true))`},
+		{`userName eq "a" or userName eq "b"`, `(or (eq username "a") (eq username "b"))`},
+		{`userName eq "a" and emails pr and active eq true`, `(and (eq username "a") (pr email) (eq is_active true))`},
+
+		// and binds tighter than or, unless parentheses say otherwise
+		{`userName eq "a" or userName eq "b" and active eq false`, `(or (eq username "a") (and (eq username "b") (eq is_active false)))`},
+		{`(userName eq "a" or userName eq "b") and active eq false`, `(and (or (eq username "a") (eq username "b")) (eq is_active false))`},
+		{`not (active eq false)`, `(not (eq is_active false))`},
+
+		// A value path filters the values of a multi-valued attribute
+		{`emails[value co "@example.com"]`, `(co email "@example.com")`},
+		{`groups[value eq "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"]`, `(eq group_id "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			filter, err := parseSCIMFilter(tt.expression, SCIMUserSchema, scimUserFilterAttributes)
+			if err != nil {
+				t.Fatalf("parseSCIMFilter: %v", err)
+			}
+			if got := formatFilter(filter); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSCIMGroupFilter(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{`displayName eq "Engineering"`, `(eq display_name "Engineering")`},
+		{`urn:ietf:params:scim:schemas:core:2.0:Group:displayName sw "Eng"`, `(sw display_name "Eng")`},
+		{`members[value eq "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d"]`, `(eq member_id "5c9b6c34-3c7a-4f4f-8a1d-6a5f7c1e2b3d")`},
+		{`externalId pr or displayName co "ops"`, `(or (pr external_id) (co display_name "ops"))`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			filter, err := parseSCIMFilter(tt.expression, SCIMGroupSchema, scimGroupFilterAttributes)
+			if err != nil {
+				t.Fatalf("parseSCIMFilter: %v", err)
+			}
+			if got := formatFilter(filter); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSCIMFilterRejectsInvalidFilters(t *testing.T) {
+	tests := []string{
+		`userName`,
+		`userName eq`,
+		`userName xx "a"`,
+		`password eq "secret"`,
+		`emails[type eq "work"]`,
+		`userName eq "unterminated`,
+		`userName eq bjensen`,
+		`(userName eq "a"`,
+		`userName eq "a")`,
+		`userName eq "a" and`,
+		`userName eq "a" userName eq "b"`,
+		`not userName eq "a"`,
+		`emails[value eq "a"`,
+		`emails[value[value eq "a"]]`,
+		`eq "a"`,
+		`()`,
+	}
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if filter, err := parseSCIMFilter(expression, SCIMUserSchema, scimUserFilterAttributes); err == nil {
+				t.Errorf("parsed as %s, want an error", formatFilter(filter))
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// services/scim_schema.go
+package services
+
+import "net/http"
+
+// Schema URNs of the SCIM discovery resources
+const (
+	scimServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimSchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// scimAttributeSchema describes an attribute of a resource, as served from /Schemas
+type scimAttributeSchema struct {
+	Name           string                `json:"name"`
+	Type           string                `json:"type"`
+	MultiValued    bool                  `json:"multiValued"`
+	Description    string                `json:"description"`
+	Required       bool                  `json:"required"`
+	CaseExact      bool                  `json:"caseExact"`
+	Mutability     string                `json:"mutability"`
+	Returned       string                `json:"returned"`
+	Uniqueness     string                `json:"uniqueness"`
+	ReferenceTypes []string              `json:"referenceTypes,omitempty"`
+	SubAttributes  []scimAttributeSchema `json:"subAttributes,omitempty"`
+}
+
+// Helper function to describe a single-valued, read-write attribute; callers adjust the rest
+func scimAttribute(name, attributeType, description string) scimAttributeSchema {
+	return scimAttributeSchema{
+		Name:        name,
+		Type:        attributeType,
+		Description: description,
+		Mutability:  "readWrite",
+		Returned:    "default",
+		Uniqueness:  "none",
+	}
+}
+
+// SCIMServiceProviderConfig returns the features the SCIM API supports (/ServiceProviderConfig)
+func (s *AuthService) SCIMServiceProviderConfig() (map[string]interface{}, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	unsupported := map[string]interface{}{"supported": false}
+	return map[string]interface{}{
+		"schemas":        []string{scimServiceProviderConfigSchema},
+		"patch":          map[string]interface{}{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": s.scim.MaxResults},
+		"changePassword": map[string]interface{}{"supported": true},
+		"sort":           unsupported,
+		"etag":           map[string]interface{}{"supported": true},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "A personal access token with the " + ScopeSCIM + " scope, belonging to an admin",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     s.scimBaseURL() + "/ServiceProviderConfig",
+		},
+	}, nil
+}
+
+// SCIMResourceTypes returns the resource types the SCIM API serves (/ResourceTypes)
+func (s *AuthService) SCIMResourceTypes() ([]interface{}, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	resourceType := func(name, endpoint, schema, description string) interface{} {
+		return map[string]interface{}{
+			"schemas":     []string{scimResourceTypeSchema},
+			"id":          name,
+			"name":        name,
+			"endpoint":    endpoint,
+			"description": description,
+			"schema":      schema,
+			"meta": map[string]interface{}{
+				"resourceType": "ResourceType",
+				"location":     s.scimBaseURL() + "/ResourceTypes/" + name,
+			},
+		}
+	}
+	return []interface{}{
+		resourceType("User", "/Users", SCIMUserSchema, "User accounts"),
+		resourceType("Group", "/Groups", SCIMGroupSchema, "Groups, which can grant their members a role"),
+	}, nil
+}
+
+// SCIMSchemas returns the schemas of the resources the SCIM API serves (/Schemas)
+func (s *AuthService) SCIMSchemas() ([]interface{}, error) {
+	if s.scim == nil {
+		return nil, ErrSCIMDisabled
+	}
+
+	userName := scimAttribute("userName", "string", "Unique identifier for the user, used to sign in")
+	userName.Required, userName.Uniqueness = true, "server"
+
+	name := scimAttribute("name", "complex", "The user's name")
+	name.SubAttributes = []scimAttributeSchema{
+		scimAttribute("formatted", "string", "The full name"),
+		scimAttribute("familyName", "string", "The family name"),
+		scimAttribute("givenName", "string", "The given name"),
+	}
+	name.SubAttributes[0].Mutability = "readOnly"
+
+	displayName := scimAttribute("displayName", "string", "The name to show for the user, made from their name")
+	displayName.Mutability = "readOnly"
+
+	emails := scimAttribute("emails", "complex", "The user's email address; exactly one is kept")
+	emails.MultiValued, emails.Required = true, true
+	emails.SubAttributes = []scimAttributeSchema{
+		scimAttribute("value", "string", "The email address"),
+		scimAttribute("type", "string", "The kind of address, e.g. work"),
+		scimAttribute("primary", "boolean", "Whether this is the primary address"),
+	}
+
+	active := scimAttribute("active", "boolean", "Whether the user can sign in")
+
+	password := scimAttribute("password", "string", "A password for signing in locally; it must meet the password policy")
+	password.Mutability, password.Returned = "writeOnly", "never"
+
+	userGroups := scimAttribute("groups", "complex", "The groups the user belongs to")
+	userGroups.MultiValued, userGroups.Mutability = true, "readOnly"
+	userGroups.SubAttributes = []scimAttributeSchema{
+		scimAttribute("value", "string", "The group's id"),
+		scimAttribute("$ref", "reference", "The URI of the group"),
+		scimAttribute("display", "string", "The group's displayName"),
+	}
+	userGroups.SubAttributes[1].ReferenceTypes = []string{"Group"}
+	for i := range userGroups.SubAttributes {
+		userGroups.SubAttributes[i].Mutability = "readOnly"
+	}
+
+	groupName := scimAttribute("displayName", "string", "The group's name; groups named in the role mapping grant their members a role")
+	groupName.Required, groupName.Uniqueness = true, "server"
+
+	members := scimAttribute("members", "complex", "The users in the group")
+	members.MultiValued = true
+	members.SubAttributes = []scimAttributeSchema{
+		scimAttribute("value", "string", "The user's id"),
+		scimAttribute("$ref", "reference", "The URI of the user"),
+		scimAttribute("display", "string", "The user's userName"),
+	}
+	members.SubAttributes[0].Mutability = "immutable"
+	members.SubAttributes[1].Mutability, members.SubAttributes[1].ReferenceTypes = "immutable", []string{"User"}
+	members.SubAttributes[2].Mutability = "readOnly"
+
+	schema := func(id, name, description string, attributes ...scimAttributeSchema) interface{} {
+		return map[string]interface{}{
+			"schemas":     []string{scimSchemaSchema},
+			"id":          id,
+			"name":        name,
+			"description": description,
+			"attributes":  attributes,
+			"meta": map[string]interface{}{
+				"resourceType": "Schema",
+				"location":     s.scimBaseURL() + "/Schemas/" + id,
+			},
+		}
+	}
+	return []interface{}{
+		schema(SCIMUserSchema, "User", "User account", userName, name, displayName, emails, active, password, userGroups),
+		schema(SCIMGroupSchema, "Group", "Group", groupName, members),
+	}, nil
+}
+
+// SCIMDiscoveryResource finds one resource type or schema by its id
+func SCIMDiscoveryResource(resources []interface{}, id string) (interface{}, error) {
+	for _, resource := range resources {
+		if resource.(map[string]interface{})["id"] == id {
+			return resource, nil
+		}
+	}
+	return nil, newSCIMError(http.StatusNotFound, "", "%s not found", id)
+}
+
+// SCIMDiscoveryList wraps resource types or schemas in a list response
+func SCIMDiscoveryList(resources []interface{}) *SCIMListResponse {
+	response := newSCIMListResponse(len(resources), 1)
+	response.Resources = resources
+	response.ItemsPerPage = len(resources)
+	return response
+}
@@ -0,0 +1,104 @@
+// services/scim_test.go
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSCIMPage(t *testing.T) {
+	s := &AuthService{scim: &SCIMConfig{MaxResults: 50}}
+	tests := []struct {
+		name                 string
+		startIndex, count    int
+		wantStart, wantCount int
+	}{
+		{"first page", 1, 10, 1, 10},
+		{"later page", 21, 10, 21, 10},
+		{"startIndex below 1 is 1", 0, 10, 1, 10},
+		{"negative startIndex is 1", -5, 10, 1, 10},
+		{"no count is the largest page", 1, -1, 1, 50},
+		{"count above the largest page is capped", 1, 500, 1, 50},
+		{"count 0 only asks for the total", 1, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, count := s.scimPage(SCIMListRequest{StartIndex: tt.startIndex, Count: tt.count})
+			if start != tt.wantStart || count != tt.wantCount {
+				t.Errorf("scimPage = (%d, %d), want (%d, %d)", start, count, tt.wantStart, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestSCIMVersionMatches(t *testing.T) {
+	version := scimVersion(time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC))
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{version, true},
+		{version[2:], true}, // Strong form of the same tag
+		{"*", true},
+		{`W/"1", ` + version, true},
+		{`W/"1"`, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := SCIMVersionMatches(tt.header, version); got != tt.want {
+			t.Errorf("SCIMVersionMatches(%q, %q) = %v, want %v", tt.header, version, got, tt.want)
+		}
+	}
+}
+
+func TestCheckSCIMVersion(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := checkSCIMVersion("", lastModified); err != nil {
+		t.Errorf("no If-Match: %v", err)
+	}
+	if err := checkSCIMVersion(scimVersion(lastModified), lastModified); err != nil {
+		t.Errorf("current version: %v", err)
+	}
+
+	err := checkSCIMVersion(scimVersion(lastModified.Add(-time.Second)), lastModified)
+	var scimErr *SCIMError
+	if !errors.As(err, &scimErr) || scimErr.Status != http.StatusPreconditionFailed {
+		t.Fatalf("stale version: err = %v, want a 412 SCIMError", err)
+	}
+}
+
+func TestSCIMErrorJSON(t *testing.T) {
+	encoded, err := json.Marshal(newSCIMError(http.StatusConflict, SCIMErrUniqueness, "userName %s is already taken", "bjensen"))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := map[string]interface{}{
+		"schemas":  []interface{}{SCIMErrorSchema},
+		"status":   "409", // A string, per RFC 7644 section 3.12
+		"scimType": "uniqueness",
+		"detail":   "userName bjensen is already taken",
+	}
+	for key, value := range want {
+		if got, _ := json.Marshal(document[key]); string(got) != mustJSON(t, value) {
+			t.Errorf("%s = %s, want %s", key, got, mustJSON(t, value))
+		}
+	}
+}
+
+// Helper function to encode a value for comparison
+func mustJSON(t *testing.T, value interface{}) string {
+	t.Helper()
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return string(encoded)
+}
@@ -0,0 +1,249 @@
+// services/session_cache.go
+package services
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Channel the database triggers notify when sessions are revoked or users change
+const cacheInvalidationChannel = "auth_cache_invalidation"
+
+// SessionCache is a bounded LRU cache of validated sessions and their users, so
+// authenticated requests can skip the database. Entries live for a short TTL and are
+// dropped as soon as the database reports the session revoked or the user disabled, deleted,
+// locked, given a new password or changed in any field the cached user shows.
+type SessionCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List                        // Most recently used at the front
+	entries  map[string]*list.Element          // By session token
+	byUser   map[uuid.UUID]map[string]struct{} // Session tokens cached for each user
+
+	// Bumped on every invalidation, so a lookup that raced with one is not cached
+	generation uint64
+}
+
+type sessionCacheEntry struct {
+	token     string
+	session   models.Session
+	user      models.User
+	expiresAt time.Time
+}
+
+// NewSessionCache creates a new SessionCache holding at most capacity sessions for ttl each
+func NewSessionCache(capacity int, ttl time.Duration) *SessionCache {
+	return &SessionCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		byUser:   make(map[uuid.UUID]map[string]struct{}),
+	}
+}
+
+// Get returns copies of the cached session and user for a token
+func (c *SessionCache) Get(token string) (*models.Session, *models.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[token]
+	if !ok {
+		return nil, nil, false
+	}
+
+	entry := element.Value.(*sessionCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.remove(element)
+		return nil, nil, false
+	}
+
+	c.order.MoveToFront(element)
+	session, user := entry.session, entry.user
+	return &session, &user, true
+}
+
+// Generation returns a value to pass to Put, taken before loading the session from the database
+func (c *SessionCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// Put caches a validated session and its user, evicting the least recently used entry if full.
+// Nothing is cached if anything was invalidated since generation was taken.
+func (c *SessionCache) Put(session *models.Session, user *models.User, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if element, ok := c.entries[session.Token]; ok {
+		c.remove(element)
+	}
+
+	entry := &sessionCacheEntry{
+		token:     session.Token,
+		session:   *session,
+		user:      *user,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+	c.entries[session.Token] = c.order.PushFront(entry)
+	if c.byUser[user.UserID] == nil {
+		c.byUser[user.UserID] = make(map[string]struct{})
+	}
+	c.byUser[user.UserID][session.Token] = struct{}{}
+
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+// Refresh updates a cached session after its activity times changed, if it is still cached
+func (c *SessionCache) Refresh(session *models.Session) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[session.Token]; ok {
+		element.Value.(*sessionCacheEntry).session = *session
+	}
+}
+
+// Remove drops the cached session for a token
+func (c *SessionCache) Remove(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if element, ok := c.entries[token]; ok {
+		c.remove(element)
+	}
+}
+
+// InvalidateSession drops a single cached session
+func (c *SessionCache) InvalidateSession(userID, sessionID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for token := range c.byUser[userID] {
+		element := c.entries[token]
+		if element.Value.(*sessionCacheEntry).session.SessionID == sessionID {
+			c.remove(element)
+		}
+	}
+}
+
+// InvalidateUser drops every cached session belonging to a user
+func (c *SessionCache) InvalidateUser(userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for token := range c.byUser[userID] {
+		c.remove(c.entries[token])
+	}
+}
+
+// Purge empties the cache
+func (c *SessionCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.byUser = make(map[uuid.UUID]map[string]struct{})
+}
+
+// Listen applies invalidations broadcast by the database until the context is cancelled.
+// If the connection drops, the cache is purged (notifications may have been missed) and
+// Listen reconnects.
+func (c *SessionCache) Listen(ctx context.Context, pool *pgxpool.Pool) {
+	for {
+		err := c.listen(ctx, pool)
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.ErrorContext(ctx, "Session cache invalidation listener stopped", "error", err)
+		c.Purge()
+
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Holds one connection listening for invalidations
+func (c *SessionCache) listen(ctx context.Context, pool *pgxpool.Pool) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+cacheInvalidationChannel); err != nil {
+		return err
+	}
+
+	// Anything cached before we started listening may already be stale
+	c.Purge()
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			// Don't hand a connection in an unknown state back to the pool
+			conn.Conn().Close(context.Background())
+			return err
+		}
+		c.applyNotification(notification.Payload)
+	}
+}
+
+// Helper function to apply a "session:<user_id>:<session_id>" or "user:<user_id>" payload
+func (c *SessionCache) applyNotification(payload string) {
+	parts := strings.Split(payload, ":")
+	switch {
+	case len(parts) == 3 && parts[0] == "session":
+		userID, err1 := uuid.Parse(parts[1])
+		sessionID, err2 := uuid.Parse(parts[2])
+		if err1 == nil && err2 == nil {
+			c.InvalidateSession(userID, sessionID)
+			return
+		}
+	case len(parts) == 2 && parts[0] == "user":
+		if userID, err := uuid.Parse(parts[1]); err == nil {
+			c.InvalidateUser(userID)
+			return
+		}
+	}
+	slog.Warn("Ignoring unknown session cache invalidation", "payload", payload)
+}
+
+// Helper function to unlink an entry; the caller must hold the lock
+func (c *SessionCache) remove(element *list.Element) {
+	entry := element.Value.(*sessionCacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.token)
+	if tokens := c.byUser[entry.user.UserID]; tokens != nil {
+		delete(tokens, entry.token)
+		if len(tokens) == 0 {
+			delete(c.byUser, entry.user.UserID)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// services/session_cache_test.go
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to listen for cache invalidations on a connection of its own
+func listenForInvalidations(t *testing.T, pool *pgxpool.Pool) *pgxpool.Conn {
+	t.Helper()
+	conn, err := pool.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	t.Cleanup(conn.Release)
+	if _, err := conn.Exec(context.Background(), "LISTEN "+cacheInvalidationChannel); err != nil {
+		t.Fatalf("LISTEN: %v", err)
+	}
+	return conn
+}
+
+// Helper function to report whether a notification arrives within a short wait
+func receivedInvalidation(conn *pgxpool.Conn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := conn.Conn().WaitForNotification(ctx)
+	return err == nil
+}
+
+func TestUserChangesNotifySessionCache(t *testing.T) {
+	pool := dbtest.New(t)
+	ctx := context.Background()
+	repo := models.NewUserRepository(pool)
+	user := &models.User{Username: "bjensen", Email: "bjensen@example.com", FirstName: "Barbara", IsActive: true}
+	if err := repo.Create(ctx, user, "Password-123"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	conn := listenForInvalidations(t, pool)
+
+	tests := []struct {
+		name   string
+		change func(user *models.User) error
+		notify bool
+	}{
+		{"login", func(user *models.User) error { return repo.RecordLogin(ctx, user.UserID) }, false},
+		{"unchanged profile", func(user *models.User) error { return repo.Update(ctx, user) }, false},
+		{"first name", func(user *models.User) error { user.FirstName = "Babs"; return repo.Update(ctx, user) }, true},
+		{"last name", func(user *models.User) error { user.LastName = "Jensen"; return repo.Update(ctx, user) }, true},
+		{"username", func(user *models.User) error { user.Username = "babs"; return repo.Update(ctx, user) }, true},
+		{"email", func(user *models.User) error { user.Email = "babs@example.com"; return repo.Update(ctx, user) }, true},
+		{"email verified", func(user *models.User) error { user.IsEmailVerified = true; return repo.Update(ctx, user) }, true},
+		{"disabled", func(user *models.User) error { user.IsActive = false; return repo.Update(ctx, user) }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := repo.GetByID(ctx, user.UserID)
+			if err != nil || current == nil {
+				t.Fatalf("GetByID: %v, %v", current, err)
+			}
+			if err := tt.change(current); err != nil {
+				t.Fatalf("change: %v", err)
+			}
+			if got := receivedInvalidation(conn); got != tt.notify {
+				t.Errorf("notified = %v, want %v", got, tt.notify)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+// services/session_limit.go
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// What to do when a login would take a user over their session limit
+const (
+	SessionLimitEvictOldest = "evict_oldest" // Sign out the least recently active session
+	SessionLimitReject      = "reject"       // Refuse the new login
+)
+
+// SessionLimitPolicy caps how many active sessions a user can have at once
+type SessionLimitPolicy struct {
+	MaxSessions int            // Limit for users without a role limit; 0 means unlimited
+	RoleLimits  map[string]int // Limits for users with these roles; the most generous applies, and 0 means unlimited
+	Action      string         // SessionLimitEvictOldest or SessionLimitReject
+}
+
+// NewSessionLimitPolicy creates a session limit policy with default values, which allows unlimited sessions
+func NewSessionLimitPolicy() SessionLimitPolicy {
+	return SessionLimitPolicy{
+		MaxSessions: 0,
+		RoleLimits:  map[string]int{},
+		Action:      SessionLimitEvictOldest,
+	}
+}
+
+// Works out the session limit for a user with the given roles
+func (p SessionLimitPolicy) limitFor(roles []string) int {
+	limit, found := 0, false
+	for _, role := range roles {
+		roleLimit, ok := p.RoleLimits[role]
+		if !ok {
+			continue
+		}
+		if roleLimit == 0 {
+			return 0
+		}
+		if !found || roleLimit > limit {
+			limit, found = roleLimit, true
+		}
+	}
+	if !found {
+		return p.MaxSessions
+	}
+	return limit
+}
+
+// Saves a new session, enforcing the user's concurrent session limit
+func (s *AuthService) createSessionWithinLimit(ctx context.Context, user *models.User, session *models.Session) error {
+	policy := s.sessionLimits
+
+	limit := policy.MaxSessions
+	if len(policy.RoleLimits) > 0 {
+		roles, err := s.roleRepo.GetByUserID(ctx, user.UserID)
+		if err != nil {
+			return err
+		}
+		limit = policy.limitFor(roles)
+	}
+
+	if limit <= 0 {
+		return s.sessionRepo.Create(ctx, session)
+	}
+
+	evicted, err := s.sessionRepo.CreateWithinLimit(ctx, session, limit, policy.Action != SessionLimitReject)
+	if err != nil {
+		return err
+	}
+
+	if len(evicted) > 0 {
+		s.metrics.SessionsRevoked(sessionRevokedEvicted, len(evicted))
+		s.handleSessionsEvicted(ctx, user, session, evicted, limit)
+	}
+
+	return nil
+}
+
+// Records sessions signed out to make room for a new one and notifies the user
+func (s *AuthService) handleSessionsEvicted(ctx context.Context, user *models.User, newSession *models.Session, evicted []*models.Session, limit int) {
+	for _, old := range evicted {
+		auditLog := &models.AuditLog{
+			UserID:    user.UserID,
+			EventType: "session_evicted",
+			IPAddress: newSession.IPAddress,
+			UserAgent: newSession.UserAgent,
+			Details: map[string]interface{}{
+				"session_id":     old.SessionID.String(),
+				"session_ip":     old.IPAddress,
+				"last_active_at": old.LastActiveAt,
+				"new_session_id": newSession.SessionID.String(),
+				"session_limit":  limit,
+			},
+		}
+		if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+			slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+		}
+	}
+
+	go func(email string, evicted []*models.Session, newSession models.Session) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.emailService.SendSessionsEvictedEmail(ctx, email, evicted, &newSession); err != nil {
+			slog.ErrorContext(ctx, "Error sending sessions evicted email", "error", err)
+		}
+	}(user.Email, evicted, *newSession)
+}
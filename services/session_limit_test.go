@@ -0,0 +1,119 @@
+// services/session_limit_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+)
+
+func TestSessionLimitFor(t *testing.T) {
+	policy := SessionLimitPolicy{
+		MaxSessions: 3,
+		RoleLimits:  map[string]int{"support": 5, "kiosk": 1, "admin": 0},
+	}
+
+	tests := []struct {
+		name  string
+		roles []string
+		want  int
+	}{
+		{"no roles", nil, 3},
+		{"role without a limit", []string{"user"}, 3},
+		{"role with a lower limit", []string{"kiosk"}, 1},
+		{"role with a higher limit", []string{"support"}, 5},
+		{"most generous role wins", []string{"kiosk", "support"}, 5},
+		{"unlimited role wins", []string{"support", "admin"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.limitFor(tt.roles); got != tt.want {
+				t.Errorf("limitFor(%v) = %d, want %d", tt.roles, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginEvictsLeastRecentlyActiveSession(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	s.SetSessionLimitPolicy(SessionLimitPolicy{MaxSessions: 2, Action: SessionLimitEvictOldest})
+	ctx := context.Background()
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+
+	// The first session was created earlier but used more recently than the second
+	first, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	second, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	backdateSession(t, s, first.Session, time.Minute, 29*time.Minute, 7*time.Hour)
+	backdateSession(t, s, second.Session, 10*time.Minute, 20*time.Minute, 7*time.Hour)
+
+	third, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login at the limit: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"recently active session", first.Session.Token, nil},
+		{"least recently active session", second.Session.Token, ErrInvalidToken},
+		{"new session", third.Session.Token, nil},
+	} {
+		if _, _, err := s.ValidateSession(ctx, tt.token); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if got := countAuditEvents(t, s, user, "session_evicted"); got != 1 {
+		t.Errorf("%d session_evicted events, want 1", got)
+	}
+}
+
+func TestLoginRejectedAtSessionLimit(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	s.SetSessionLimitPolicy(SessionLimitPolicy{
+		MaxSessions: 1,
+		RoleLimits:  map[string]int{"support": 2},
+		Action:      SessionLimitReject,
+	})
+	createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	createLocalTestUser(t, s, "alice", "correct-Horse-battery-9-staple", "support")
+
+	first, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if _, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple"); !errors.Is(err, ErrSessionLimitReached) {
+		t.Errorf("Login over the limit: err = %v, want ErrSessionLimitReached", err)
+	}
+	if _, _, err := s.ValidateSession(context.Background(), first.Session.Token); err != nil {
+		t.Errorf("existing session after a rejected login: %v", err)
+	}
+
+	// Signing out makes room again
+	if err := s.Logout(context.Background(), first.Session.Token); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if _, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple"); err != nil {
+		t.Errorf("Login after signing out: %v", err)
+	}
+
+	// The role limit applies instead of the default one
+	for i := 0; i < 2; i++ {
+		if _, err := directoryTestLogin(s, "alice", "correct-Horse-battery-9-staple"); err != nil {
+			t.Fatalf("Login %d within the role limit: %v", i+1, err)
+		}
+	}
+	if _, err := directoryTestLogin(s, "alice", "correct-Horse-battery-9-staple"); !errors.Is(err, ErrSessionLimitReached) {
+		t.Errorf("Login over the role limit: err = %v, want ErrSessionLimitReached", err)
+	}
+}
@@ -0,0 +1,49 @@
+// services/session_policy.go
+package services
+
+import "time"
+
+// SessionPolicy controls how long sessions last
+type SessionPolicy struct {
+	IdleTimeout            time.Duration // Sessions expire after this long without activity
+	AbsoluteTimeout        time.Duration // Sessions expire this long after login, whatever the activity
+	ActivityUpdateInterval time.Duration // Activity is written to the database at most this often per session
+
+	RememberMeIdleTimeout     time.Duration // IdleTimeout for "remember me" sessions
+	RememberMeAbsoluteTimeout time.Duration // AbsoluteTimeout for "remember me" sessions
+}
+
+// NewSessionPolicy creates a new session policy with default values
+func NewSessionPolicy(idleTimeout time.Duration) SessionPolicy {
+	return SessionPolicy{
+		IdleTimeout:            idleTimeout,
+		AbsoluteTimeout:        24 * time.Hour,
+		ActivityUpdateInterval: time.Minute,
+
+		RememberMeIdleTimeout:     14 * 24 * time.Hour,
+		RememberMeAbsoluteTimeout: 30 * 24 * time.Hour,
+	}
+}
+
+// Helper function to pick the idle timeout for a session
+func (p SessionPolicy) idleTimeout(persistent bool) time.Duration {
+	if persistent {
+		return p.RememberMeIdleTimeout
+	}
+	return p.IdleTimeout
+}
+
+// Helper function to work out a new session's idle and absolute expiry times
+func (p SessionPolicy) expiryTimes(now time.Time, persistent bool) (expiresAt, absoluteExpiresAt time.Time) {
+	absoluteTimeout := p.AbsoluteTimeout
+	if persistent {
+		absoluteTimeout = p.RememberMeAbsoluteTimeout
+	}
+
+	absoluteExpiresAt = now.Add(absoluteTimeout)
+	expiresAt = now.Add(p.idleTimeout(persistent))
+	if expiresAt.After(absoluteExpiresAt) {
+		expiresAt = absoluteExpiresAt
+	}
+	return expiresAt, absoluteExpiresAt
+}
@@ -0,0 +1,198 @@
+// services/session_policy_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+func TestSessionExpiryTimes(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	policy := SessionPolicy{
+		IdleTimeout:               30 * time.Minute,
+		AbsoluteTimeout:           8 * time.Hour,
+		RememberMeIdleTimeout:     14 * 24 * time.Hour,
+		RememberMeAbsoluteTimeout: 30 * 24 * time.Hour,
+	}
+	short := policy
+	short.AbsoluteTimeout = 10 * time.Minute
+
+	tests := []struct {
+		name         string
+		policy       SessionPolicy
+		persistent   bool
+		wantExpires  time.Duration
+		wantAbsolute time.Duration
+	}{
+		{"browser session", policy, false, 30 * time.Minute, 8 * time.Hour},
+		{"remember me", policy, true, 14 * 24 * time.Hour, 30 * 24 * time.Hour},
+		{"idle timeout capped by absolute timeout", short, false, 10 * time.Minute, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expiresAt, absoluteExpiresAt := tt.policy.expiryTimes(now, tt.persistent)
+			if got := expiresAt.Sub(now); got != tt.wantExpires {
+				t.Errorf("expires after %v, want %v", got, tt.wantExpires)
+			}
+			if got := absoluteExpiresAt.Sub(now); got != tt.wantAbsolute {
+				t.Errorf("absolutely expires after %v, want %v", got, tt.wantAbsolute)
+			}
+		})
+	}
+}
+
+// Helper function to set up a service with short session lifetimes and a logged in user
+func newSessionTestService(t *testing.T) (*AuthService, *LoginResult) {
+	t.Helper()
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	policy := NewSessionPolicy(30 * time.Minute)
+	policy.AbsoluteTimeout = 8 * time.Hour
+	policy.ActivityUpdateInterval = time.Minute
+	s.SetSessionPolicy(policy)
+
+	createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	login, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return s, login
+}
+
+// Helper function to move a session's times into the past, as if it were created or last
+// used that long ago
+func backdateSession(t *testing.T, s *AuthService, session *models.Session, lastActive, expires, absoluteExpires time.Duration) {
+	t.Helper()
+	query := `
+		UPDATE auth.sessions SET
+			last_active_at = NOW() - make_interval(secs => $2::float8),
+			expires_at = NOW() + make_interval(secs => $3::float8),
+			absolute_expires_at = NOW() + make_interval(secs => $4::float8)
+		WHERE session_id = $1`
+	if _, err := s.pool.Exec(context.Background(), query, session.SessionID, lastActive.Seconds(), expires.Seconds(), absoluteExpires.Seconds()); err != nil {
+		t.Fatalf("backdating session: %v", err)
+	}
+}
+
+func TestValidateSessionExpiry(t *testing.T) {
+	tests := []struct {
+		name            string
+		lastActive      time.Duration // How long ago the session was last used
+		expires         time.Duration // From now, negative for the past
+		absoluteExpires time.Duration
+		wantErr         error
+	}{
+		{"active", time.Minute, 29 * time.Minute, 7 * time.Hour, nil},
+		{"idle expiry passed", 31 * time.Minute, -time.Minute, 7 * time.Hour, ErrInvalidToken},
+		{"idle longer than the timeout", 31 * time.Minute, time.Hour, 7 * time.Hour, ErrInvalidToken},
+		{"absolute expiry passed", time.Minute, 29 * time.Minute, -time.Minute, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, login := newSessionTestService(t)
+			backdateSession(t, s, login.Session, tt.lastActive, tt.expires, tt.absoluteExpires)
+
+			_, _, err := s.ValidateSession(context.Background(), login.Session.Token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateSession: err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateSessionSlidesExpiryAtMostOncePerInterval(t *testing.T) {
+	s, login := newSessionTestService(t)
+	ctx := context.Background()
+
+	// Used just now: nothing is written
+	before, err := s.sessionRepo.GetByToken(ctx, login.Session.Token)
+	if err != nil {
+		t.Fatalf("GetByToken: %v", err)
+	}
+	if _, _, err := s.ValidateSession(ctx, login.Session.Token); err != nil {
+		t.Fatalf("ValidateSession: %v", err)
+	}
+	after, err := s.sessionRepo.GetByToken(ctx, login.Session.Token)
+	if err != nil {
+		t.Fatalf("GetByToken: %v", err)
+	}
+	if !after.LastActiveAt.Equal(before.LastActiveAt) || !after.ExpiresAt.Equal(before.ExpiresAt) {
+		t.Errorf("session touched %v after login, want no write within the update interval", after.LastActiveAt.Sub(before.LastActiveAt))
+	}
+
+	// Used two minutes ago with ten minutes left: the idle expiry slides to 30 minutes from now
+	backdateSession(t, s, login.Session, 2*time.Minute, 10*time.Minute, 7*time.Hour)
+	if _, _, err := s.ValidateSession(ctx, login.Session.Token); err != nil {
+		t.Fatalf("ValidateSession: %v", err)
+	}
+	touched, err := s.sessionRepo.GetByToken(ctx, login.Session.Token)
+	if err != nil {
+		t.Fatalf("GetByToken: %v", err)
+	}
+	if time.Since(touched.LastActiveAt) > 5*time.Second {
+		t.Errorf("last active %v ago, want now", time.Since(touched.LastActiveAt))
+	}
+	if left := time.Until(touched.ExpiresAt); left < 29*time.Minute || left > 31*time.Minute {
+		t.Errorf("expires in %v, want about 30m", left)
+	}
+
+	// Sliding never passes the absolute expiry
+	backdateSession(t, s, login.Session, 2*time.Minute, 10*time.Minute, 20*time.Minute)
+	if _, _, err := s.ValidateSession(ctx, login.Session.Token); err != nil {
+		t.Fatalf("ValidateSession: %v", err)
+	}
+	capped, err := s.sessionRepo.GetByToken(ctx, login.Session.Token)
+	if err != nil {
+		t.Fatalf("GetByToken: %v", err)
+	}
+	if !capped.ExpiresAt.Equal(capped.AbsoluteExpiresAt) {
+		t.Errorf("expires at %v, want the absolute expiry %v", capped.ExpiresAt, capped.AbsoluteExpiresAt)
+	}
+}
+
+func TestLoginRememberMe(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	policy := NewSessionPolicy(30 * time.Minute)
+	policy.AbsoluteTimeout = 8 * time.Hour
+	s.SetSessionPolicy(policy)
+	createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+
+	tests := []struct {
+		name           string
+		rememberMe     bool
+		wantIdle       time.Duration
+		wantAbsolute   time.Duration
+		wantPersistent bool
+	}{
+		{"browser session", false, 30 * time.Minute, 8 * time.Hour, false},
+		{"remember me", true, policy.RememberMeIdleTimeout, policy.RememberMeAbsoluteTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login, err := s.Login(context.Background(), LoginRequest{
+				UsernameOrEmail: "bjensen",
+				Password:        "correct-Horse-battery-9-staple",
+				IPAddress:       "192.0.2.1",
+				UserAgent:       "session-test",
+				RememberMe:      tt.rememberMe,
+			})
+			if err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			session := login.Session
+			if session.IsPersistent != tt.wantPersistent {
+				t.Errorf("IsPersistent = %v, want %v", session.IsPersistent, tt.wantPersistent)
+			}
+			if got := session.ExpiresAt.Sub(session.CreatedAt); got < tt.wantIdle-time.Second || got > tt.wantIdle+time.Second {
+				t.Errorf("idle expiry after %v, want %v", got, tt.wantIdle)
+			}
+			if got := session.AbsoluteExpiresAt.Sub(session.CreatedAt); got < tt.wantAbsolute-time.Second || got > tt.wantAbsolute+time.Second {
+				t.Errorf("absolute expiry after %v, want %v", got, tt.wantAbsolute)
+			}
+		})
+	}
+}
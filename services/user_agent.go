@@ -0,0 +1,113 @@
+// services/user_agent.go
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Device types reported by ParseUserAgent
+const (
+	DeviceTypeDesktop = "desktop"
+	DeviceTypeMobile  = "mobile"
+	DeviceTypeTablet  = "tablet"
+	DeviceTypeBot     = "bot"
+	DeviceTypeUnknown = "unknown"
+)
+
+// DeviceInfo holds the details parsed from a User-Agent header
+type DeviceInfo struct {
+	Browser    string `json:"browser"`
+	OS         string `json:"os"`
+	DeviceType string `json:"device_type"`
+}
+
+type uaPattern struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// Browsers in the order they must be checked: many include "Chrome" or "Safari" tokens for compatibility
+var browserPatterns = []uaPattern{
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|Opera)/(\d+)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+)(?:\.\d+)*.*Safari/`)},
+	{"curl", regexp.MustCompile(`curl/(\d+)`)},
+}
+
+var osPatterns = []uaPattern{
+	{"iOS", regexp.MustCompile(`(?:iPhone|iPad|iPod).*? OS (\d+)`)},
+	{"Android", regexp.MustCompile(`Android (\d+)`)},
+	{"Windows", regexp.MustCompile(`Windows NT (\d+\.\d+)`)},
+	{"ChromeOS", regexp.MustCompile(`CrOS`)},
+	{"macOS", regexp.MustCompile(`Mac OS X (\d+)[._](\d+)`)},
+	{"Linux", regexp.MustCompile(`Linux`)},
+}
+
+var botPattern = regexp.MustCompile(`(?i)bot|crawler|spider|slurp|headless`)
+
+// ParseUserAgent extracts the browser, OS and device type from a User-Agent header
+func ParseUserAgent(userAgent string) DeviceInfo {
+	info := DeviceInfo{Browser: "Unknown browser", OS: "Unknown OS", DeviceType: DeviceTypeUnknown}
+	if userAgent == "" {
+		return info
+	}
+
+	for _, p := range browserPatterns {
+		if m := p.pattern.FindStringSubmatch(userAgent); m != nil {
+			info.Browser = p.name + " " + m[1]
+			break
+		}
+	}
+
+	for _, p := range osPatterns {
+		m := p.pattern.FindStringSubmatch(userAgent)
+		if m == nil {
+			continue
+		}
+		switch p.name {
+		case "Windows":
+			info.OS = windowsVersion(m[1])
+		case "macOS":
+			info.OS = "macOS " + m[1] + "." + m[2]
+		case "iOS", "Android":
+			info.OS = p.name + " " + m[1]
+		default:
+			info.OS = p.name
+		}
+		break
+	}
+
+	switch {
+	case botPattern.MatchString(userAgent):
+		info.DeviceType = DeviceTypeBot
+	case strings.Contains(userAgent, "iPad") || strings.Contains(userAgent, "Tablet") ||
+		(strings.Contains(userAgent, "Android") && !strings.Contains(userAgent, "Mobile")):
+		info.DeviceType = DeviceTypeTablet
+	case strings.Contains(userAgent, "Mobi") || strings.Contains(userAgent, "iPhone"):
+		info.DeviceType = DeviceTypeMobile
+	case info.OS != "Unknown OS":
+		info.DeviceType = DeviceTypeDesktop
+	}
+
+	return info
+}
+
+// Helper function to name Windows releases from their NT version
+func windowsVersion(nt string) string {
+	switch nt {
+	case "10.0":
+		return "Windows 10/11" // Windows 11 still reports NT 10.0
+	case "6.3":
+		return "Windows 8.1"
+	case "6.2":
+		return "Windows 8"
+	case "6.1":
+		return "Windows 7"
+	default:
+		return "Windows NT " + nt
+	}
+}
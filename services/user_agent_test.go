@@ -0,0 +1,75 @@
+// services/user_agent_test.go
+package services
+
+import "testing"
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      DeviceInfo
+	}{
+		{
+			name:      "empty",
+			userAgent: "",
+			want:      DeviceInfo{Browser: "Unknown browser", OS: "Unknown OS", DeviceType: DeviceTypeUnknown},
+		},
+		{
+			name:      "Chrome on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      DeviceInfo{Browser: "Chrome 124", OS: "Windows 10/11", DeviceType: DeviceTypeDesktop},
+		},
+		{
+			name:      "Edge on Windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.2478.51",
+			want:      DeviceInfo{Browser: "Edge 124", OS: "Windows 10/11", DeviceType: DeviceTypeDesktop},
+		},
+		{
+			name:      "Safari on macOS",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+			want:      DeviceInfo{Browser: "Safari 17", OS: "macOS 10.15", DeviceType: DeviceTypeDesktop},
+		},
+		{
+			name:      "Firefox on Linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			want:      DeviceInfo{Browser: "Firefox 125", OS: "Linux", DeviceType: DeviceTypeDesktop},
+		},
+		{
+			name:      "Safari on iPhone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			want:      DeviceInfo{Browser: "Safari 17", OS: "iOS 17", DeviceType: DeviceTypeMobile},
+		},
+		{
+			name:      "Chrome on iPad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+			want:      DeviceInfo{Browser: "Chrome 124", OS: "iOS 17", DeviceType: DeviceTypeTablet},
+		},
+		{
+			name:      "Samsung Internet on an Android phone",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0.0.0 Mobile Safari/537.36",
+			want:      DeviceInfo{Browser: "Samsung Internet 24", OS: "Android 14", DeviceType: DeviceTypeMobile},
+		},
+		{
+			name:      "Chrome on an Android tablet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			want:      DeviceInfo{Browser: "Chrome 124", OS: "Android 13", DeviceType: DeviceTypeTablet},
+		},
+		{
+			name:      "search engine crawler",
+			userAgent: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			want:      DeviceInfo{Browser: "Unknown browser", OS: "Unknown OS", DeviceType: DeviceTypeBot},
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.5.0",
+			want:      DeviceInfo{Browser: "curl 8", OS: "Unknown OS", DeviceType: DeviceTypeUnknown},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserAgent(tt.userAgent); got != tt.want {
+				t.Errorf("ParseUserAgent = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
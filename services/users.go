@@ -0,0 +1,323 @@
+// services/users.go
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/loganmanery/go-react-app/models"
+)
+
+var (
+	ErrUserNotDeleted          = models.ErrUserNotDeleted
+	ErrUserIdentifiersReleased = models.ErrUserIdentifiersReleased
+	ErrCannotDeleteSelf        = errors.New("admins cannot delete their own account this way")
+)
+
+// UserRetentionPolicy controls how long deleted users are kept restorable
+type UserRetentionPolicy struct {
+	ReleaseAfter time.Duration // How long a deleted user keeps their email and username before others can use them
+}
+
+// NewUserRetentionPolicy creates a user retention policy with default values
+func NewUserRetentionPolicy() UserRetentionPolicy {
+	return UserRetentionPolicy{
+		ReleaseAfter: 30 * 24 * time.Hour,
+	}
+}
+
+// SetUserRetentionPolicy replaces the default user retention policy
+func (s *AuthService) SetUserRetentionPolicy(policy UserRetentionPolicy) {
+	s.userRetentionPolicy = policy
+}
+
+// FindUser looks a user up by ID, email or username, whichever the identifier looks like
+func (s *AuthService) FindUser(ctx context.Context, identifier string) (*models.User, error) {
+	var user *models.User
+	var err error
+	if userID, parseErr := uuid.Parse(identifier); parseErr == nil {
+		user, err = s.userRepo.GetByID(ctx, userID)
+	} else if strings.Contains(identifier, "@") {
+		user, err = s.userRepo.GetByEmail(ctx, identifier)
+	} else {
+		user, err = s.userRepo.GetByUsername(ctx, identifier)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// CreateUser creates an active user with a verified email on behalf of an admin, and grants
+// them the given roles. Unlike Register, no verification email is needed.
+func (s *AuthService) CreateUser(ctx context.Context, adminID uuid.UUID, username, email, password, firstName, lastName string, roles []string, ipAddress, userAgent string) (*models.User, error) {
+	user := &models.User{
+		Username:        models.NormalizeUsername(username),
+		Email:           models.NormalizeEmail(email),
+		FirstName:       firstName,
+		LastName:        lastName,
+		IsEmailVerified: true,
+		IsActive:        true,
+	}
+	if err := models.CheckUsernameConfusables(user.Username); err != nil {
+		return nil, err
+	}
+	if err := s.passwordPolicy.Validate(ctx, password, user); err != nil {
+		return nil, err
+	}
+
+	// The unique indexes reject existing emails and usernames
+	if err := s.userRepo.Create(ctx, user, password); err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		if err := s.roleRepo.Grant(ctx, user.UserID, role); err != nil {
+			return nil, err
+		}
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    user.UserID,
+		EventType: "user_created",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"created_by": adminID.String(),
+			"roles":      roles,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return user, nil
+}
+
+// SetUserActive disables or re-enables a user on behalf of an admin. Disabled users are
+// signed out everywhere and can't sign in until they are enabled again.
+func (s *AuthService) SetUserActive(ctx context.Context, adminID, userID uuid.UUID, active bool, ipAddress, userAgent string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.IsActive == active {
+		return nil
+	}
+
+	user.IsActive = active
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	eventType := "user_enabled"
+	if !active {
+		eventType = "user_disabled"
+		if err := s.RevokeUserSessions(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	s.auditUserAdministration(ctx, userID, eventType, adminID, ipAddress, userAgent)
+	return nil
+}
+
+// SetUserPassword replaces a user's password on behalf of an admin, without knowing the
+// current one, and signs them out everywhere
+func (s *AuthService) SetUserPassword(ctx context.Context, adminID, userID uuid.UUID, password, ipAddress, userAgent string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if directoryUser, err := s.isDirectoryUser(ctx, userID); err != nil {
+		return err
+	} else if directoryUser {
+		return ErrPasswordManagedExternally
+	}
+	if err := s.passwordPolicy.Validate(ctx, password, user); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdatePassword(ctx, userID, password, s.passwordPolicy.historyCount()); err != nil {
+		return err
+	}
+	if err := s.RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	s.auditUserAdministration(ctx, userID, "password_reset_by_admin", adminID, ipAddress, userAgent)
+	return nil
+}
+
+// GrantRole gives a user a role on behalf of an admin; granting a role they have is a no-op
+func (s *AuthService) GrantRole(ctx context.Context, adminID, userID uuid.UUID, role, ipAddress, userAgent string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	hasRole, err := s.roleRepo.HasRole(ctx, userID, role)
+	if err != nil || hasRole {
+		return err
+	}
+
+	if err := s.roleRepo.Grant(ctx, userID, role); err != nil {
+		return err
+	}
+
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: "role_granted",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"role":       role,
+			"granted_by": adminID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// GetUserRoles retrieves the roles granted to a user
+func (s *AuthService) GetUserRoles(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	return s.roleRepo.GetByUserID(ctx, userID)
+}
+
+// RevokeUserSessions signs a user out everywhere, including on replicas that cached their sessions
+func (s *AuthService) RevokeUserSessions(ctx context.Context, userID uuid.UUID) error {
+	revoked, err := s.sessionRepo.InvalidateAllForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	s.metrics.SessionsRevoked(sessionRevokedUser, int(revoked))
+	if s.sessionCache != nil {
+		s.sessionCache.InvalidateUser(userID)
+	}
+	return nil
+}
+
+// DeleteUser soft deletes a user on behalf of an admin and signs them out everywhere
+func (s *AuthService) DeleteUser(ctx context.Context, adminID, userID uuid.UUID, ipAddress, userAgent string) error {
+	if adminID == userID {
+		return ErrCannotDeleteSelf
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.userRepo.Delete(ctx, userID); err != nil {
+		return err
+	}
+	if s.sessionCache != nil {
+		s.sessionCache.InvalidateUser(userID)
+	}
+
+	s.auditUserAdministration(ctx, userID, "user_deleted", adminID, ipAddress, userAgent)
+	return nil
+}
+
+// ListDeletedUsers retrieves a page of deleted users for admins to restore or purge
+func (s *AuthService) ListDeletedUsers(ctx context.Context, offset, limit int) ([]*models.User, error) {
+	return s.userRepo.ListDeleted(ctx, offset, limit)
+}
+
+// RestoreUser undoes the deletion of a user on behalf of an admin, as long as their email
+// and username have not been released
+func (s *AuthService) RestoreUser(ctx context.Context, adminID, userID uuid.UUID, ipAddress, userAgent string) (*models.User, error) {
+	user, err := s.userRepo.GetByIDIncludingDeleted(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	if err := s.userRepo.Restore(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	s.auditUserAdministration(ctx, userID, "user_restored", adminID, ipAddress, userAgent)
+	return s.userRepo.GetByID(ctx, userID)
+}
+
+// PurgeUser permanently deletes a deleted user on behalf of an admin. Their audit log
+// entries are anonymised rather than deleted.
+func (s *AuthService) PurgeUser(ctx context.Context, adminID, userID uuid.UUID, ipAddress, userAgent string) error {
+	user, err := s.userRepo.GetByIDIncludingDeleted(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+	if user.DeletedAt == nil {
+		return ErrUserNotDeleted
+	}
+
+	if err := s.userRepo.Purge(ctx, userID); err != nil {
+		return err
+	}
+
+	// Recorded against the admin, since the user no longer exists
+	auditLog := &models.AuditLog{
+		UserID:    adminID,
+		EventType: "user_purged",
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"user_id":    userID.String(),
+			"deleted_at": user.DeletedAt,
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+
+	return nil
+}
+
+// ReleaseDeletedUserIdentifiers frees the emails and usernames of users deleted longer ago
+// than the retention policy allows, so they can be registered again. Released users can no
+// longer be restored.
+func (s *AuthService) ReleaseDeletedUserIdentifiers(ctx context.Context) (int64, error) {
+	return s.userRepo.ReleaseDeletedIdentifiers(ctx, time.Now().Add(-s.userRetentionPolicy.ReleaseAfter))
+}
+
+// Helper function to record an admin's change to a user under that user
+func (s *AuthService) auditUserAdministration(ctx context.Context, userID uuid.UUID, eventType string, adminID uuid.UUID, ipAddress, userAgent string) {
+	auditLog := &models.AuditLog{
+		UserID:    userID,
+		EventType: eventType,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"changed_by": adminID.String(),
+		},
+	}
+	if _, err := s.createAuditLog(ctx, auditLog); err != nil {
+		slog.ErrorContext(ctx, "Error creating audit log", "error", err)
+	}
+}
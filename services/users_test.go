@@ -0,0 +1,146 @@
+// services/users_test.go
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/loganmanery/go-react-app/db/dbtest"
+	"github.com/loganmanery/go-react-app/models"
+)
+
+// Helper function to move a deleted user's deletion into the past
+func backdateUserDeletion(t *testing.T, s *AuthService, user *models.User, ago time.Duration) {
+	t.Helper()
+	query := `UPDATE auth.users SET deleted_at = NOW() - make_interval(secs => $2::float8) WHERE user_id = $1`
+	if _, err := s.pool.Exec(context.Background(), query, user.UserID, ago.Seconds()); err != nil {
+		t.Fatalf("backdating deletion: %v", err)
+	}
+}
+
+func TestDeleteAndRestoreUser(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	ctx := context.Background()
+	admin := createLocalTestUser(t, s, "admin", "correct-Horse-battery-9-staple", models.RoleAdmin)
+	user := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	login, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	if err := s.DeleteUser(ctx, admin.UserID, admin.UserID, "", ""); !errors.Is(err, ErrCannotDeleteSelf) {
+		t.Errorf("deleting themselves: err = %v, want ErrCannotDeleteSelf", err)
+	}
+	if _, err := s.RestoreUser(ctx, admin.UserID, user.UserID, "", ""); !errors.Is(err, ErrUserNotDeleted) {
+		t.Errorf("restoring a user who isn't deleted: err = %v, want ErrUserNotDeleted", err)
+	}
+	if err := s.PurgeUser(ctx, admin.UserID, user.UserID, "", ""); !errors.Is(err, ErrUserNotDeleted) {
+		t.Errorf("purging a user who isn't deleted: err = %v, want ErrUserNotDeleted", err)
+	}
+
+	if err := s.DeleteUser(ctx, admin.UserID, user.UserID, "192.0.2.1", "users-test"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if err := s.DeleteUser(ctx, admin.UserID, user.UserID, "", ""); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("deleting twice: err = %v, want ErrUserNotFound", err)
+	}
+
+	// A deleted user is signed out, can't sign in and is hidden from lookups
+	if _, _, err := s.ValidateSession(ctx, login.Session.Token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("session after deletion: err = %v, want ErrInvalidToken", err)
+	}
+	if _, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Login after deletion: err = %v, want ErrInvalidCredentials", err)
+	}
+	if found, err := s.userRepo.GetByEmail(ctx, user.Email); err != nil || found != nil {
+		t.Errorf("GetByEmail after deletion = %v, %v; want nothing", found, err)
+	}
+	deleted, err := s.ListDeletedUsers(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("ListDeletedUsers: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].UserID != user.UserID {
+		t.Errorf("ListDeletedUsers = %d users, want the deleted one", len(deleted))
+	}
+
+	// Until they are released, the deleted user's email and username stay taken
+	if _, err := s.Register(ctx, "someone", user.Email, "correct-Horse-battery-9-staple", "", ""); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("registering with a deleted user's email: err = %v, want ErrEmailAlreadyExists", err)
+	}
+	if _, err := s.Register(ctx, user.Username, "someone@example.com", "correct-Horse-battery-9-staple", "", ""); !errors.Is(err, ErrUsernameAlreadyExists) {
+		t.Errorf("registering with a deleted user's username: err = %v, want ErrUsernameAlreadyExists", err)
+	}
+
+	restored, err := s.RestoreUser(ctx, admin.UserID, user.UserID, "192.0.2.1", "users-test")
+	if err != nil {
+		t.Fatalf("RestoreUser: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Email != user.Email {
+		t.Errorf("restored user deleted at %v with email %q, want active with %q", restored.DeletedAt, restored.Email, user.Email)
+	}
+	if _, err := directoryTestLogin(s, "bjensen", "correct-Horse-battery-9-staple"); err != nil {
+		t.Errorf("Login after restoring: %v", err)
+	}
+	for _, eventType := range []string{"user_deleted", "user_restored"} {
+		if got := countAuditEvents(t, s, user, eventType); got != 1 {
+			t.Errorf("%d %s events, want 1", got, eventType)
+		}
+	}
+}
+
+func TestReleaseDeletedUserIdentifiers(t *testing.T) {
+	s := NewAuthService(dbtest.New(t), "test-secret", 60)
+	s.SetUserRetentionPolicy(UserRetentionPolicy{ReleaseAfter: 30 * 24 * time.Hour})
+	ctx := context.Background()
+	admin := createLocalTestUser(t, s, "admin", "correct-Horse-battery-9-staple", models.RoleAdmin)
+	old := createLocalTestUser(t, s, "bjensen", "correct-Horse-battery-9-staple")
+	recent := createLocalTestUser(t, s, "alice", "correct-Horse-battery-9-staple")
+
+	for _, user := range []*models.User{old, recent} {
+		if err := s.DeleteUser(ctx, admin.UserID, user.UserID, "", ""); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+	}
+	backdateUserDeletion(t, s, old, 31*24*time.Hour)
+	backdateUserDeletion(t, s, recent, 29*24*time.Hour)
+
+	released, err := s.ReleaseDeletedUserIdentifiers(ctx)
+	if err != nil {
+		t.Fatalf("ReleaseDeletedUserIdentifiers: %v", err)
+	}
+	if released != 1 {
+		t.Errorf("released %d users, want 1", released)
+	}
+
+	// The released email and username can be registered again; the recent ones can't
+	if _, err := s.Register(ctx, "bjensen", "bjensen@example.com", "correct-Horse-battery-9-staple", "", ""); err != nil {
+		t.Errorf("registering with released identifiers: %v", err)
+	}
+	if _, err := s.Register(ctx, "alice2", "alice@example.com", "correct-Horse-battery-9-staple", "", ""); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("registering with a recently deleted user's email: err = %v, want ErrEmailAlreadyExists", err)
+	}
+
+	// Released users can't come back, but can still be purged
+	if _, err := s.RestoreUser(ctx, admin.UserID, old.UserID, "", ""); !errors.Is(err, ErrUserIdentifiersReleased) {
+		t.Errorf("restoring a released user: err = %v, want ErrUserIdentifiersReleased", err)
+	}
+	if _, err := s.RestoreUser(ctx, admin.UserID, recent.UserID, "", ""); err != nil {
+		t.Errorf("restoring a recently deleted user: %v", err)
+	}
+	if err := s.PurgeUser(ctx, admin.UserID, old.UserID, "192.0.2.1", "users-test"); err != nil {
+		t.Fatalf("PurgeUser: %v", err)
+	}
+	if purged, err := s.userRepo.GetByIDIncludingDeleted(ctx, old.UserID); err != nil || purged != nil {
+		t.Errorf("user after purging = %v, %v; want gone", purged, err)
+	}
+	if got := countAuditEvents(t, s, admin, "user_purged"); got != 1 {
+		t.Errorf("%d user_purged events, want 1", got)
+	}
+
+	// Running it again finds nothing new
+	if released, err := s.ReleaseDeletedUserIdentifiers(ctx); err != nil || released != 0 {
+		t.Errorf("ReleaseDeletedUserIdentifiers again = %d, %v; want 0", released, err)
+	}
+}